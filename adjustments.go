@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// Adjustment is a manual score change with the reason for it. Adjustments
+// are never removed: a revert is an adjustment of its own with the opposite
+// delta, Reverts naming the original, which then has RevertedBy set.
+type Adjustment struct {
+	ID         int       `json:"id"`
+	Team       string    `json:"team"`
+	Delta      int       `json:"delta"`
+	Reason     string    `json:"reason"`
+	Time       time.Time `json:"time"`
+	Origin     string    `json:"origin"`
+	QuestionID int       `json:"question_id,omitempty"`
+	Reverts    int       `json:"reverts,omitempty"`
+	RevertedBy int       `json:"reverted_by,omitempty"`
+	// Dispute is the dispute whose ruling made this adjustment, see
+	// disputes.go.
+	Dispute int `json:"dispute,omitempty"`
+}
+
+// adjustments is the ledger of the current game, oldest first. It is
+// cleared with the scores by resetGame.
+var (
+	adjustmentsMutex sync.Mutex
+	adjustments      []Adjustment
+	nextAdjustmentID = 1
+)
+
+var (
+	errReasonRequired = apiError(http.StatusBadRequest, "reason_required", "a manual score change needs a reason")
+	errRevertRevert   = apiError(http.StatusConflict, "revert_of_revert", "a revert can't be reverted, make a new adjustment instead")
+)
+
+// adjustManually changes a team's score and records it in the ledger.
+func adjustManually(team string, delta int, reason, origin string) (Adjustment, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return Adjustment{}, errReasonRequired
+	}
+	adjustmentsMutex.Lock()
+	a, err := addAdjustment(team, delta, reason, 0, origin)
+	adjustmentsMutex.Unlock()
+	if err == nil {
+		recordScoreUndo(a, origin)
+	}
+	return a, err
+}
+
+// addAdjustment applies the score change and appends it to the ledger. The
+// score entry of the audit log carries the adjustment's ID. Call with
+// adjustmentsMutex held.
+func addAdjustment(team string, delta int, reason string, reverts int, origin string) (Adjustment, error) {
+	id := nextAdjustmentID
+	cause := causeManual
+	extra := map[string]interface{}{"adjustment_id": id, "reason": reason}
+	if reverts != 0 {
+		cause = causeRevert
+		extra["reverts"] = reverts
+	}
+	if _, err := adjustScoreNoting(team, delta, cause, origin, extra); err != nil {
+		return Adjustment{}, err
+	}
+	t, _ := findTeam(team)
+	a := Adjustment{ID: id, Team: t.Name, Delta: delta, Reason: reason, Time: clock.Now(), Origin: origin, QuestionID: liveHistoryID(), Reverts: reverts}
+	nextAdjustmentID++
+	adjustments = append(adjustments, a)
+	return a, nil
+}
+
+// revertAdjustment undoes adjustment id with a compensating one. Reason
+// defaults to naming the adjustment reverted.
+func revertAdjustment(id int, reason, origin string) (Adjustment, error) {
+	adjustmentsMutex.Lock()
+	defer adjustmentsMutex.Unlock()
+	i := -1
+	for j, a := range adjustments {
+		if a.ID == id {
+			i = j
+		}
+	}
+	switch {
+	case i < 0:
+		return Adjustment{}, notFound("adjustment not found")
+	case adjustments[i].RevertedBy != 0:
+		return Adjustment{}, apiError(http.StatusConflict, "already_reverted", "this adjustment has already been reverted").withDetail("reverted_by", adjustments[i].RevertedBy)
+	case adjustments[i].Reverts != 0:
+		return Adjustment{}, errRevertRevert
+	}
+	if reason = strings.TrimSpace(reason); reason == "" {
+		reason = fmt.Sprintf("revert of #%d", id)
+	}
+	orig := adjustments[i]
+	a, err := addAdjustment(orig.Team, -orig.Delta, reason, orig.ID, origin)
+	if err != nil {
+		return Adjustment{}, err
+	}
+	adjustments[i].RevertedBy = a.ID
+	return a, nil
+}
+
+func listAdjustments() []Adjustment {
+	adjustmentsMutex.Lock()
+	defer adjustmentsMutex.Unlock()
+	return append([]Adjustment{}, adjustments...)
+}
+
+// installAdjustments replaces the ledger, for restores and new games.
+func installAdjustments(list []Adjustment) {
+	adjustmentsMutex.Lock()
+	defer adjustmentsMutex.Unlock()
+	adjustments = list
+	nextAdjustmentID = 1
+	for _, a := range list {
+		nextAdjustmentID = max(nextAdjustmentID, a.ID+1)
+	}
+}
+
+// adjustmentError gives ledger failures their HTTP status; a score change
+// the server refuses, e.g. for an unknown team, conflicts.
+func adjustmentError(err error) error {
+	if _, ok := err.(*APIError); ok {
+		return err
+	}
+	return conflict(err.Error())
+}
+
+// AdjustmentRequest is the body of POST /adjustments.
+type AdjustmentRequest struct {
+	Team   string `json:"team"`
+	Delta  int    `json:"delta"`
+	Reason string `json:"reason"`
+}
+
+// RevertRequest is the optional body of POST /adjustments/:id/revert.
+type RevertRequest struct {
+	Reason string `json:"reason"`
+}
+
+// getAdjustments lists the ledger, optionally for one team.
+func getAdjustments(c echo.Context) error {
+	team := c.QueryParam("team")
+	out := []Adjustment{}
+	for _, a := range listAdjustments() {
+		if team == "" || strings.EqualFold(a.Team, team) {
+			out = append(out, a)
+		}
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+func postAdjustment(c echo.Context) error {
+	req := new(AdjustmentRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if req.Delta == 0 {
+		return badRequest("delta must not be zero")
+	}
+	a, err := adjustManually(req.Team, req.Delta, req.Reason, requestOrigin(c))
+	if err != nil {
+		return adjustmentError(err)
+	}
+	return c.JSON(http.StatusCreated, a)
+}
+
+func postRevertAdjustment(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return badRequest("invalid adjustment id")
+	}
+	req := new(RevertRequest)
+	if err := bindOptionalJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	a, err := revertAdjustment(id, req.Reason, requestOrigin(c))
+	if err != nil {
+		return adjustmentError(err)
+	}
+	return c.JSON(http.StatusCreated, a)
+}
+
+// printAdjustments is "score log".
+func printAdjustments() {
+	info := color.New(color.FgYellow)
+
+	list := listAdjustments()
+	if len(list) == 0 {
+		info.Println("No manual score changes this game")
+		return
+	}
+	for _, a := range list {
+		note := ""
+		switch {
+		case a.Reverts != 0:
+			note = fmt.Sprintf(" (reverts #%d)", a.Reverts)
+		case a.RevertedBy != 0:
+			note = fmt.Sprintf(" (reverted by #%d)", a.RevertedBy)
+		}
+		info.Printf("  #%-3d %s %-12s %+4d  %s%s\n", a.ID, a.Time.Local().Format("15:04:05"), a.Team, a.Delta, a.Reason, note)
+	}
+}
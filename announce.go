@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+var (
+	announceAtFlag        = flag.String("announce-at", "60s,30s,10s,5s", "remaining times at which screen readers are told the time left (empty disables)")
+	announceLangFlag      = flag.String("announce-lang", "sk", "language of the screen reader announcements")
+	announceTemplatesFlag = flag.String("announce-templates", "", `JSON file of announcement phrasings, {"<lang>": {"<seconds>" or "*": "text with {{seconds}}"}}`)
+)
+
+// announceTemplates are the built-in phrasings. "*" is used for milestones
+// without their own sentence.
+var announceTemplates = map[string]map[string]string{
+	"sk": {
+		"60": "Zostáva jedna minúta",
+		"30": "Zostáva tridsať sekúnd",
+		"10": "Zostáva desať sekúnd",
+		"5":  "Zostáva päť sekúnd",
+		"3":  "Zostávajú tri sekundy",
+		"2":  "Zostávajú dve sekundy",
+		"1":  "Zostáva jedna sekunda",
+		"*":  "Zostáva {{seconds}} sekúnd",
+	},
+	"en": {
+		"60": "One minute left",
+		"1":  "One second left",
+		"*":  "{{seconds}} seconds left",
+	},
+}
+
+var (
+	announceMutex      sync.Mutex
+	announceMilestones []time.Duration
+	announceLang       string
+	announceWake       = make(chan struct{}, 1)
+)
+
+// parseMilestones reads a comma-separated list of remaining times and
+// returns them longest first.
+func parseMilestones(s string) ([]time.Duration, error) {
+	var out []time.Duration
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		d, err := parseDurationArg(part)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("milestones must be longer than zero")
+		}
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] > out[j] })
+	return out, nil
+}
+
+func configureAnnouncements() error {
+	milestones, err := parseMilestones(*announceAtFlag)
+	if err != nil {
+		return err
+	}
+	if *announceTemplatesFlag != "" {
+		data, err := os.ReadFile(*announceTemplatesFlag)
+		if err != nil {
+			return err
+		}
+		var custom map[string]map[string]string
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return fmt.Errorf("%s: %v", *announceTemplatesFlag, err)
+		}
+		for lang, phrases := range custom {
+			if announceTemplates[lang] == nil {
+				announceTemplates[lang] = map[string]string{}
+			}
+			for k, v := range phrases {
+				announceTemplates[lang][k] = v
+			}
+		}
+	}
+	announceMutex.Lock()
+	defer announceMutex.Unlock()
+	announceMilestones = milestones
+	announceLang = *announceLangFlag
+	return nil
+}
+
+func announceSettings() ([]time.Duration, string) {
+	announceMutex.Lock()
+	defer announceMutex.Unlock()
+	return announceMilestones, announceLang
+}
+
+func setAnnounceSettings(milestones []time.Duration, lang string) {
+	announceMutex.Lock()
+	announceMilestones = milestones
+	announceLang = lang
+	announceMutex.Unlock()
+	wakeAnnouncer()
+}
+
+// announcementText phrases a milestone in lang, falling back to English.
+func announcementText(m time.Duration, lang string) string {
+	seconds := strconv.Itoa(int(m.Round(time.Second) / time.Second))
+	for _, l := range []string{lang, "en"} {
+		phrases := announceTemplates[l]
+		if text, ok := phrases[seconds]; ok {
+			return text
+		}
+		if text, ok := phrases["*"]; ok {
+			return strings.ReplaceAll(text, "{{seconds}}", seconds)
+		}
+	}
+	return seconds
+}
+
+func wakeAnnouncer() {
+	select {
+	case announceWake <- struct{}{}:
+	default:
+	}
+}
+
+// announceKey identifies one uninterrupted run of a countdown. Pausing,
+// adjusting or replacing the question changes it.
+type announceKey struct {
+	start time.Time
+	left  time.Duration
+}
+
+// nextMilestone returns the longest milestone still ahead of remaining,
+// skipping those at or above last, which was already announced.
+func nextMilestone(milestones []time.Duration, remaining, last time.Duration) (time.Duration, bool) {
+	for _, m := range milestones {
+		if m < remaining && (last == 0 || m < last) {
+			return m, true
+		}
+	}
+	return 0, false
+}
+
+// watchAnnouncements sets aria_live_text and sends an "announce" event each
+// time a running countdown passes a milestone. It follows the same state
+// as the expiry watcher, so a paused countdown announces nothing and an
+// adjusted one is rescheduled. A milestone the adjustment moves back ahead
+// is announced again when reached.
+func watchAnnouncements() {
+	var key announceKey
+	var last time.Duration
+	for {
+		milestones, lang := announceSettings()
+		questionMutex.RLock()
+		deadline, ok := countdownDeadline(question)
+		current := announceKey{question.StartTime, question.TimeLeft}
+		questionMutex.RUnlock()
+		if currentReplay() != nil {
+			ok = false
+		}
+		if current != key {
+			key, last = current, 0
+		}
+
+		var fire <-chan time.Time
+		var next time.Duration
+		if ok {
+			next, ok = nextMilestone(milestones, deadline.Sub(clock.Now()), last)
+		}
+		if ok {
+			at := deadline.Add(-next)
+			armTimer("announce", at)
+			fire = clock.After(at.Sub(clock.Now()))
+		} else {
+			disarmTimer("announce")
+		}
+		select {
+		case <-announceWake:
+		case <-fire:
+			if announce(key, next, lang) {
+				last = next
+			}
+		}
+	}
+}
+
+// announce publishes milestone m if the countdown is still the one it was
+// scheduled for.
+func announce(key announceKey, m time.Duration, lang string) bool {
+	text := announcementText(m, lang)
+	questionMutex.Lock()
+	if _, ok := countdownDeadline(question); !ok || (announceKey{question.StartTime, question.TimeLeft}) != key {
+		questionMutex.Unlock()
+		return false
+	}
+	question.AriaLiveText = text
+	stateChanged("announce")
+	questionMutex.Unlock()
+
+	hub.broadcast(Event{Type: "announce", Data: map[string]interface{}{
+		"text":      text,
+		"lang":      lang,
+		"remaining": m.Seconds(),
+	}})
+	return true
+}
+
+// handleAnnounceCommand runs "announce [at <list>|lang <code>|off]".
+func handleAnnounceCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	milestones, lang := announceSettings()
+	switch {
+	case len(args) == 0:
+		if len(milestones) == 0 {
+			info.Println("Screen reader announcements are off")
+			return nil
+		}
+		info.Printf("Announcing in %s at:\n", lang)
+		for _, m := range milestones {
+			info.Printf("  %-6s %s\n", m, announcementText(m, lang))
+		}
+	case len(args) == 1 && args[0] == "off":
+		setAnnounceSettings(nil, lang)
+		audit("announce_settings", origin, map[string]interface{}{"at": ""})
+		success.Println("Screen reader announcements disabled")
+	case len(args) == 2 && args[0] == "at":
+		parsed, err := parseMilestones(args[1])
+		if err != nil {
+			return err
+		}
+		setAnnounceSettings(parsed, lang)
+		audit("announce_settings", origin, map[string]interface{}{"at": args[1]})
+		success.Printf("Announcing at %s\n", args[1])
+	case len(args) == 2 && args[0] == "lang":
+		setAnnounceSettings(milestones, args[1])
+		audit("announce_settings", origin, map[string]interface{}{"lang": args[1]})
+		success.Printf("Announcing in %s\n", args[1])
+	default:
+		return errors.New("Usage: announce [at <60s,10s,...>|lang <code>|off]")
+	}
+	return nil
+}
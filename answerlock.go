@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var unlockedFraction = flag.Float64("unlocked-fraction", 1, "share of its points an answer earns when its team never locked it in, 0 to 1; 1 ignores lock-in")
+
+// A team locks in its answer with POST /answer/lock, a captain action.
+// After that its answer is final until an operator unlocks it, which is
+// only possible while the floor is open: once it closes the answers are
+// revealed and judged as they stand. When lock-in counts, the floor closes
+// by itself once every team has locked in rather than once all answered.
+
+// answerPoints is what an answer earns if judged correct under p: the
+// policy's points, scaled by -unlocked-fraction unless it was locked in.
+func answerPoints(p *ScoringPolicy, a Answer) int {
+	points := earnedPoints(p, a.Latency, a.Remaining)
+	if a.LockedAt == nil {
+		points = int(math.Round(float64(points) * *unlockedFraction))
+	}
+	return points
+}
+
+// lockedTeams lists the teams that have locked in. roundMutex must be held.
+func lockedTeams() []string {
+	var out []string
+	for _, a := range answers {
+		if a.LockedAt != nil {
+			out = append(out, a.Team)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// lockAnswer makes the current answer of team final.
+func lockAnswer(name, origin string) (Answer, error) {
+	t, err := roundTeam(name)
+	if err != nil {
+		return Answer{}, err
+	}
+	roundMutex.Lock()
+	a, ok := answers[t.Name]
+	switch {
+	case floorClosed != nil:
+		roundMutex.Unlock()
+		return Answer{}, &RoundError{Code: "floor_closed", Message: "answers are closed for this question"}
+	case !ok:
+		roundMutex.Unlock()
+		return Answer{}, &RoundError{Code: "no_answer", Message: t.Name + " has not answered"}
+	case a.LockedAt != nil:
+		roundMutex.Unlock()
+		return Answer{}, &RoundError{Code: "answer_locked", Message: t.Name + " has already locked in"}
+	}
+	now := clock.Now()
+	a.LockedAt = &now
+	answers[t.Name] = a
+	everyone := allAnswersIn()
+	roundMutex.Unlock()
+
+	audit("answer_lock", origin, map[string]interface{}{"team": t.Name})
+	hub.broadcastOperator(Event{Type: "answer_locked", Data: a})
+	roundChanged("answer_lock")
+	if everyone {
+		closeFloor(false, "auto")
+	}
+	return a, nil
+}
+
+// unlockAnswer lets team change its answer again, until the floor closes.
+func unlockAnswer(name, origin string) (Answer, error) {
+	t, err := roundTeam(name)
+	if err != nil {
+		return Answer{}, err
+	}
+	roundMutex.Lock()
+	a, ok := answers[t.Name]
+	switch {
+	case floorClosed != nil:
+		roundMutex.Unlock()
+		return Answer{}, &RoundError{Code: "floor_closed", Message: "answers are revealed, locks stand"}
+	case !ok || a.LockedAt == nil:
+		roundMutex.Unlock()
+		return Answer{}, &RoundError{Code: "not_locked", Message: t.Name + " has not locked in"}
+	}
+	lockedAt := *a.LockedAt
+	a.LockedAt = nil
+	answers[t.Name] = a
+	roundMutex.Unlock()
+
+	audit("answer_unlock", origin, map[string]interface{}{"team": t.Name, "locked_at": lockedAt})
+	hub.broadcastOperator(Event{Type: "answer_unlocked", Data: a})
+	roundChanged("answer_unlock")
+	return a, nil
+}
+
+func postAnswerLock(c echo.Context) error {
+	req := new(TeamRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	t, ok := findTeam(req.Team)
+	if !ok {
+		return roundError(&RoundError{Code: "unknown_team", Message: "unknown team: " + req.Team})
+	}
+	if err := checkCaptain(c, t.Name); err != nil {
+		return err
+	}
+	a, err := lockAnswer(t.Name, requestOrigin(c))
+	if err != nil {
+		return roundError(err)
+	}
+	return c.JSON(http.StatusOK, a)
+}
+
+func postAnswerUnlock(c echo.Context) error {
+	req := new(TeamRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	a, err := unlockAnswer(req.Team, requestOrigin(c))
+	if err != nil {
+		return roundError(err)
+	}
+	return c.JSON(http.StatusOK, a)
+}
+
+// handleLockinCommand runs "lockin [undo <team>]".
+func handleLockinCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	switch {
+	case len(args) == 0:
+		roundMutex.Lock()
+		locked := lockedTeams()
+		roundMutex.Unlock()
+		if len(locked) == 0 {
+			info.Println("No team has locked in")
+			return nil
+		}
+		info.Printf("Locked in: %s\n", strings.Join(locked, ", "))
+	case len(args) == 2 && args[0] == "undo":
+		a, err := unlockAnswer(args[1], origin)
+		if err != nil {
+			return err
+		}
+		success.Printf("%s may change its answer again\n", a.Team)
+	default:
+		return errors.New("Usage: lockin [undo <team>]")
+	}
+	return nil
+}
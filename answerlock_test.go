@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// TestAnswerLock has one team lock in with its captain PIN and another
+// leave its answer open, and checks the locks, the unlock and the points.
+func TestAnswerLock(t *testing.T) {
+	s := StartTestServer(t)
+	defer forgetTeamPIN("Sovy")
+	defer func(f float64) { *unlockedFraction = f }(*unlockedFraction)
+	*unlockedFraction = 0.5
+	for _, name := range []string{"Sovy", "Líšky"} {
+		if err := addTeam(Team{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setTeamPIN("Sovy", "1234", "test"); err != nil {
+		t.Fatal(err)
+	}
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{
+		"question": "Hlavné mesto?", "type": "pomoc", "time_left": 30_000_000_000, "scoring": map[string]interface{}{"mode": "static", "points": 10},
+	})
+
+	// team sends as the team, without the operator key.
+	team := func(path, name, answer, pin string) (int, string) {
+		t.Helper()
+		body := map[string]string{"team": name}
+		if answer != "" {
+			body["answer"] = answer
+		}
+		req := s.NewRequest(t, http.MethodPost, path, body)
+		req.Header.Del("X-API-Key")
+		if pin != "" {
+			req.Header.Set(teamPINHeader, pin)
+		}
+		var res struct {
+			Error APIError `json:"error"`
+		}
+		return s.Send(t, req, &res).StatusCode, res.Error.Code
+	}
+	if status, code := team("/v2/answer/lock", "Sovy", "", "1234"); status != http.StatusConflict || code != "no_answer" {
+		t.Errorf("locking without an answer: status %d, %s", status, code)
+	}
+	team("/v2/answer", "Sovy", "Bratislava", "")
+	team("/v2/answer", "Líšky", "Košice", "")
+	if status, code := team("/v2/answer/lock", "Sovy", "", ""); status != http.StatusUnauthorized || code != "captain_required" {
+		t.Errorf("locking without the PIN: status %d, %s", status, code)
+	}
+	if status, code := team("/v2/answer/lock", "Sovy", "", "1234"); status != http.StatusOK {
+		t.Fatalf("locking: status %d, %s", status, code)
+	}
+	if status, code := team("/v2/answer", "Sovy", "Nitra", ""); status != http.StatusConflict || code != "answer_locked" {
+		t.Errorf("answering after the lock: status %d, %s", status, code)
+	}
+	if status, code := team("/v2/answer/lock", "Sovy", "", "1234"); status != http.StatusConflict || code != "answer_locked" {
+		t.Errorf("locking twice: status %d, %s", status, code)
+	}
+
+	var q PublicQuestionView
+	s.Do(t, http.MethodGet, "/get-question", nil, &q)
+	if q.Round == nil || fmt.Sprint(q.Round.Locked) != "[Sovy]" {
+		t.Errorf("public round %+v", q.Round)
+	}
+	points := func() map[string]string {
+		t.Helper()
+		var views []AnswerView
+		s.Do(t, http.MethodGet, "/answers", nil, &views)
+		out := map[string]string{}
+		for _, v := range views {
+			out[v.Team] = fmt.Sprint(v.Answer.Answer, " ", v.Points, " ", v.LockedAt != nil)
+		}
+		return out
+	}
+	if got := points(); got["Sovy"] != "Bratislava 10 true" || got["Líšky"] != "Košice 5 false" {
+		t.Errorf("answers %v", got)
+	}
+
+	// An operator lets the team change its mind while the floor is open.
+	if err := cliCommand(t, "lockin undo sovy"); err != nil {
+		t.Fatal(err)
+	}
+	if e := lastAudit(t, "answer_unlock"); e.Details["team"] != "Sovy" {
+		t.Errorf("unlock audited as %+v", e)
+	}
+	if status, _ := team("/v2/answer", "Sovy", "Nitra", ""); status != http.StatusOK {
+		t.Errorf("answering after the unlock: status %d", status)
+	}
+	team("/v2/answer/lock", "Sovy", "", "1234")
+
+	s.MustDo(t, http.MethodPost, "/answers/close", nil)
+	if err := cliCommand(t, "lockin undo Sovy"); err == nil {
+		t.Error("unlocked with the floor closed")
+	}
+	var awarded []ScoreBreakdown
+	s.Do(t, http.MethodPost, "/answers/award", AwardRequest{Teams: []string{"Sovy", "Líšky"}}, &awarded)
+	if len(awarded) != 2 || !awarded[0].Locked || awarded[0].Points != 10 || awarded[1].Locked || awarded[1].Points != 5 {
+		t.Errorf("awarded %+v", awarded)
+	}
+
+	// While locking counts, the floor closes by itself once every team has
+	// locked in, not once every team has answered.
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Koľko je 2+2?", "type": "pomoc", "time_left": 30_000_000_000})
+	floorClosed := func() bool {
+		t.Helper()
+		var q PublicQuestionView
+		s.Do(t, http.MethodGet, "/get-question", nil, &q)
+		return q.Round != nil && q.Round.FloorClosed != nil
+	}
+	team("/v2/answer", "Sovy", "4", "")
+	team("/v2/answer", "Líšky", "4", "")
+	team("/v2/answer/lock", "Líšky", "", "")
+	if floorClosed() {
+		t.Error("the floor closed before everyone locked in")
+	}
+	team("/v2/answer/lock", "Sovy", "", "1234")
+	if !floorClosed() {
+		t.Error("the floor stayed open with everyone locked in")
+	}
+}
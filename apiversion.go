@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Every route is served under /v1 and /v2. /v1 is the wire format the
+// frontend, Flask and the hardware timer parse: durations in nanoseconds,
+// the question flat, errors as {"error": "message"}. It is frozen, and the
+// golden corpus in testdata/wire locks it byte for byte, see wirecheck.go.
+// /v2 writes durations in seconds and errors as the full envelope of
+// errors.go. The unversioned paths are the legacy aliases of /v1 and carry
+// a Deprecation header pointing at it.
+const (
+	apiV1            = 1
+	apiV2            = 2
+	latestAPIVersion = apiV2
+)
+
+// apiVersionHeader tells the client which version answered.
+const apiVersionHeader = "API-Version"
+
+const apiVersionKey = "api_version"
+
+// apiVersions strips the version prefix before routing, so both versions
+// and the legacy paths reach the same handlers, and notes the version for
+// the serializer and the error handler.
+func apiVersions(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		u := c.Request().URL
+		h := c.Response().Header()
+		version, rest, ok := splitAPIVersion(u.Path)
+		if !ok {
+			c.Set(apiVersionKey, apiV1)
+			h.Set(apiVersionHeader, strconv.Itoa(apiV1))
+			h.Set("Deprecation", "true")
+			h.Set("Link", fmt.Sprintf("</v%d%s>; rel=\"successor-version\"", apiV1, u.EscapedPath()))
+			return next(c)
+		}
+		if version < apiV1 || version > latestAPIVersion {
+			c.Set(apiVersionKey, latestAPIVersion)
+			return apiError(http.StatusNotFound, "unsupported_version", fmt.Sprintf("API version %d is not served, use /v1 or /v2", version)).
+				withDetail("supported", []int{apiV1, apiV2})
+		}
+		prefix := u.Path[:len(u.Path)-len(rest)]
+		u.Path = rest
+		if u.RawPath != "" {
+			u.RawPath = strings.TrimPrefix(u.RawPath, prefix)
+		}
+		c.Set(apiVersionKey, version)
+		h.Set(apiVersionHeader, strconv.Itoa(version))
+		return next(c)
+	}
+}
+
+// splitAPIVersion splits "/v2/queue" into 2 and "/queue". ok is unset for
+// a path without a version prefix.
+func splitAPIVersion(path string) (version int, rest string, ok bool) {
+	if !strings.HasPrefix(path, "/v") {
+		return 0, path, false
+	}
+	digits, rest, _ := strings.Cut(path[2:], "/")
+	version, err := strconv.Atoi(digits)
+	if err != nil || digits[0] == '+' || digits[0] == '-' {
+		return 0, path, false
+	}
+	return version, "/" + rest, true
+}
+
+// apiVersion is the version c is answered in.
+func apiVersion(c echo.Context) int {
+	if v, ok := c.Get(apiVersionKey).(int); ok {
+		return v
+	}
+	return apiV1
+}
+
+// versionedJSON is the JSON serializer of the servers: /v1 responses are
+// encoded as they are, /v2 ones with durations in seconds.
+type versionedJSON struct {
+	echo.DefaultJSONSerializer
+}
+
+func (s versionedJSON) Serialize(c echo.Context, i interface{}, indent string) error {
+	return s.DefaultJSONSerializer.Serialize(c, wireValue(i, apiVersion(c)), indent)
+}
+
+// wireValue is v as version encodes it.
+func wireValue(v interface{}, version int) interface{} {
+	if version < apiV2 {
+		return v
+	}
+	return secondsValue(reflect.ValueOf(v))
+}
+
+// errorBody is the error response of version.
+func errorBody(err *APIError, version int) interface{} {
+	if version < apiV2 {
+		return map[string]string{"error": err.Message}
+	}
+	return map[string]*APIError{"error": err}
+}
+
+var (
+	durationType  = reflect.TypeOf(time.Duration(0))
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// secondsValue rebuilds v for encoding/json with every time.Duration in
+// float seconds. Structs keep their field names, order and omitempty, and
+// values that marshal themselves are left alone.
+func secondsValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	t := v.Type()
+	if t == durationType {
+		return time.Duration(v.Int()).Seconds()
+	}
+	if t.Implements(marshalerType) {
+		return v.Interface()
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return secondsValue(v.Elem())
+	case reflect.Struct:
+		return secondsStruct(v)
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		fallthrough
+	case reflect.Array:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = secondsValue(v.Index(i))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := map[string]interface{}{}
+		iter := v.MapRange()
+		for iter.Next() {
+			k := iter.Key()
+			switch k.Kind() {
+			case reflect.String:
+				out[k.String()] = secondsValue(iter.Value())
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				out[strconv.FormatInt(k.Int(), 10)] = secondsValue(iter.Value())
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				out[strconv.FormatUint(k.Uint(), 10)] = secondsValue(iter.Value())
+			default:
+				return v.Interface()
+			}
+		}
+		return out
+	}
+	return v.Interface()
+}
+
+// jsonField is one field of a struct rebuilt by secondsStruct.
+type jsonField struct {
+	name  string
+	depth int
+	value interface{}
+}
+
+// orderedObject is a JSON object that keeps its fields in order.
+type orderedObject []jsonField
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(f.name)
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func secondsStruct(v reflect.Value) orderedObject {
+	fields := structFields(v, 0)
+	// As in encoding/json, a field of an embedded struct loses to one of
+	// the same name closer to the top.
+	shallowest := map[string]int{}
+	for _, f := range fields {
+		if d, ok := shallowest[f.name]; !ok || f.depth < d {
+			shallowest[f.name] = f.depth
+		}
+	}
+	out := orderedObject{}
+	seen := map[string]bool{}
+	for _, f := range fields {
+		if f.depth == shallowest[f.name] && !seen[f.name] {
+			out, seen[f.name] = append(out, f), true
+		}
+	}
+	return out
+}
+
+func structFields(v reflect.Value, depth int) []jsonField {
+	var out []jsonField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				ft, fv = ft.Elem(), fv.Elem()
+			}
+			if ft.Kind() == reflect.Struct && !ft.Implements(marshalerType) {
+				out = append(out, structFields(fv, depth+1)...)
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if strings.Contains(","+opts+",", ",omitempty,") && emptyValue(fv) {
+			continue
+		}
+		out = append(out, jsonField{name: name, depth: depth, value: secondsValue(fv)})
+	}
+	return out
+}
+
+// emptyValue is encoding/json's test for omitempty.
+func emptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
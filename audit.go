@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const maxAuditEntries = 10000
+
+// AuditEntry records an operator-relevant action and where it came from.
+type AuditEntry struct {
+	ID     uint64    `json:"id"`
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Origin string    `json:"origin"`
+	// Operator is who did it, for actions of an operator.
+	Operator string                 `json:"operator,omitempty"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+var (
+	auditLog    []AuditEntry
+	auditNextID uint64
+	auditMutex  sync.RWMutex
+)
+
+// audit appends an entry to the in-memory audit log, dropping the oldest
+// entries once the log is full. An operator's name in origin goes to the
+// entry's Operator. What has to outlast the cap, like the score timelines,
+// is folded into its own aggregate as the entry is added.
+func audit(action, origin string, details map[string]interface{}) {
+	transport, operator := splitOrigin(origin)
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	auditNextID++
+	auditLog = append(auditLog, AuditEntry{
+		ID:       auditNextID,
+		Time:     clock.Now(),
+		Action:   action,
+		Origin:   transport,
+		Operator: operator,
+		Details:  details,
+	})
+	if len(auditLog) > maxAuditEntries {
+		auditLog = auditLog[len(auditLog)-maxAuditEntries:]
+	}
+	observeStats(auditLog[len(auditLog)-1])
+	observeHistory(auditLog[len(auditLog)-1])
+	observeWebhooks(auditLog[len(auditLog)-1])
+	observeSessions(auditLog[len(auditLog)-1])
+	observeScoreboard(auditLog[len(auditLog)-1])
+	observeTimeline(auditLog[len(auditLog)-1])
+	observePauses(auditLog[len(auditLog)-1])
+	announceOperatorAction(auditLog[len(auditLog)-1])
+}
+
+// auditEntries returns a copy of the entries with the given action, or all
+// entries when action is empty.
+func auditEntries(action string) []AuditEntry {
+	auditMutex.RLock()
+	defer auditMutex.RUnlock()
+	var out []AuditEntry
+	for _, e := range auditLog {
+		if action == "" || e.Action == action {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+var apiKey = flag.String("api-key", os.Getenv("STUSKOVA_API_KEY"), "key required by operator endpoints (default $STUSKOVA_API_KEY)")
+
+// requestAPIKey extracts the key from either an "Authorization: Bearer" or
+// an "X-API-Key" header.
+func requestAPIKey(c echo.Context) string {
+	if key := c.Request().Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	auth := c.Request().Header.Get(echo.HeaderAuthorization)
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+func isAuthenticated(c echo.Context) bool {
+	_, ok := keyOperator(requestAPIKey(c))
+	return ok
+}
+
+// keyOperator checks key against -api-key and the -operator-key labels. ok
+// is set for a valid key; label is empty for -api-key.
+func keyOperator(key string) (label string, ok bool) {
+	if key == "" {
+		return "", false
+	}
+	if *apiKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(*apiKey)) == 1 {
+		return "", true
+	}
+	for _, k := range operatorKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k.Key)) == 1 {
+			return k.Label, true
+		}
+	}
+	return "", false
+}
+
+// operatorsEnabled reports whether any operator key is configured.
+func operatorsEnabled() bool {
+	return *apiKey != "" || len(operatorKeys) > 0
+}
+
+// requireAuth guards operator endpoints. Without a configured key those
+// endpoints stay disabled rather than open.
+func requireAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !operatorsEnabled() {
+			return apiError(http.StatusForbidden, "operator_disabled", "operator endpoints are disabled, start the server with -api-key")
+		}
+		if !isAuthenticated(c) {
+			return apiError(http.StatusUnauthorized, "unauthorized", "invalid or missing API key")
+		}
+		requestOrigin(c)
+		return next(c)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+var (
+	autoWaitFlag    = flag.Duration("auto-wait", 0, "switch to the waiting screen this long after a question ends (0 disables)")
+	autoWaitMsgFlag = flag.String("auto-wait-message", "Pripravujeme ďalšiu otázku", "text shown on the automatic waiting screen")
+)
+
+var (
+	autoWaitMutex   sync.Mutex
+	autoWaitDelay   time.Duration
+	autoWaitMessage string
+	autoWaitCancel  chan struct{}
+)
+
+func configureAutoWait() {
+	autoWaitMutex.Lock()
+	defer autoWaitMutex.Unlock()
+	autoWaitDelay = *autoWaitFlag
+	autoWaitMessage = *autoWaitMsgFlag
+}
+
+func autoWaitSettings() (time.Duration, string) {
+	autoWaitMutex.Lock()
+	defer autoWaitMutex.Unlock()
+	return autoWaitDelay, autoWaitMessage
+}
+
+func setAutoWait(delay time.Duration, message, origin string) {
+	autoWaitMutex.Lock()
+	autoWaitDelay = delay
+	if message != "" {
+		autoWaitMessage = message
+	}
+	autoWaitMutex.Unlock()
+	audit("autowait", origin, map[string]interface{}{"delay": delay.Seconds(), "message": message})
+}
+
+// autoWaitStateChanged cancels the pending switch to the waiting screen and
+// schedules a new one if q has just ended. It runs from stateChanged, so any
+// manual change made in the meantime cancels the switch.
+func autoWaitStateChanged(q Question, rev uint64) {
+	delay, message := autoWaitSettings()
+
+	autoWaitMutex.Lock()
+	defer autoWaitMutex.Unlock()
+	if autoWaitCancel != nil {
+		close(autoWaitCancel)
+		autoWaitCancel = nil
+		disarmTimer("autowait")
+	}
+	if delay <= 0 || q.Type != "end" || q.Paused {
+		return
+	}
+	// Between the parts of a group the gap is shorter, or there is none.
+	if delay = waitingGap(delay); delay <= 0 {
+		return
+	}
+	cancel := make(chan struct{})
+	autoWaitCancel = cancel
+	armTimer("autowait", clock.Now().Add(delay))
+	go func() {
+		select {
+		case <-cancel:
+		case <-clock.After(delay):
+			showWaitingScreen(rev, message)
+		}
+	}()
+}
+
+func showWaitingScreen(rev uint64, message string) {
+	questionMutex.Lock()
+	if revision != rev || question.Type != "end" || question.Paused {
+		questionMutex.Unlock()
+		return
+	}
+	question = Question{
+		Question: message,
+		Type:     "waiting",
+	}
+	clearTimer(&question)
+	stateChanged("auto-wait")
+	questionMutex.Unlock()
+	sendCurrentQuestion()
+}
+
+func handleAutoWaitCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 0 {
+		delay, message := autoWaitSettings()
+		if delay <= 0 {
+			info.Println("Automatic waiting screen is off")
+		} else {
+			info.Printf("Waiting screen %s after a question ends: %q\n", delay, message)
+		}
+		return nil
+	}
+	delay, err := parseDurationArg(args[0])
+	if err != nil {
+		return err
+	}
+	message := strings.Trim(strings.Join(args[1:], " "), `"`)
+	setAutoWait(delay, message, origin)
+	if delay == 0 {
+		success.Println("Automatic waiting screen disabled")
+	} else {
+		success.Printf("Waiting screen will follow each question after %s\n", delay)
+	}
+	return nil
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	backupManifestVersion = 1
+	maxBackupFileSize     = 32 << 20
+)
+
+// BackupManifest describes the contents of a backup archive.
+type BackupManifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Files   []string  `json:"files"`
+	// WALSeq is the last write-ahead log record a snapshot includes.
+	WALSeq uint64 `json:"wal_seq,omitempty"`
+}
+
+// QueueBackup is queue.json: the queue and where it is.
+type QueueBackup struct {
+	Entries []QueueEntry `json:"entries"`
+	NextID  int          `json:"next_id"`
+	Live    int          `json:"live"`
+}
+
+// backupSection is one file inside a backup archive. load decodes the data
+// without touching live state and returns a function that installs it, so a
+// restore can validate every section before swapping anything in. recover,
+// when set, is used instead after a crash, where the state should continue
+// exactly as it was rather than start over.
+type backupSection struct {
+	file    string
+	dump    func() (interface{}, error)
+	load    func(data []byte) (func(), error)
+	recover func(data []byte) (func(), error)
+}
+
+var backupSections = []backupSection{
+	{
+		file: "question.json",
+		dump: func() (interface{}, error) {
+			if q, ok := replayedQuestion(); ok {
+				return q, nil
+			}
+			questionMutex.RLock()
+			defer questionMutex.RUnlock()
+			return question, nil
+		},
+		load: func(data []byte) (func(), error) {
+			var q Question
+			if err := json.Unmarshal(data, &q); err != nil {
+				return nil, err
+			}
+			if err := validateQuestion(q); err != nil {
+				return nil, err
+			}
+			return func() {
+				questionMutex.Lock()
+				question = q
+				// A restored question restarts its timer.
+				if question.Duration == 0 {
+					question.Duration = question.TimeLeft
+				}
+				restartTimer(&question)
+				stateChanged("restore")
+				questionMutex.Unlock()
+			}, nil
+		},
+		recover: func(data []byte) (func(), error) {
+			var q Question
+			if err := json.Unmarshal(data, &q); err != nil {
+				return nil, err
+			}
+			if err := validateQuestion(q); err != nil {
+				return nil, err
+			}
+			return func() {
+				questionMutex.Lock()
+				question = q
+				stateChanged("recover")
+				questionMutex.Unlock()
+			}, nil
+		},
+	},
+	{
+		file: "teams.json",
+		dump: func() (interface{}, error) {
+			return listTeams(), nil
+		},
+		load: func(data []byte) (func(), error) {
+			var restored []Team
+			if err := json.Unmarshal(data, &restored); err != nil {
+				return nil, err
+			}
+			return func() {
+				teamsMutex.Lock()
+				teams = restored
+				teamsMutex.Unlock()
+				rebuildScoreboard()
+			}, nil
+		},
+	},
+	{
+		file: "adjustments.json",
+		dump: func() (interface{}, error) {
+			return listAdjustments(), nil
+		},
+		load: func(data []byte) (func(), error) {
+			var restored []Adjustment
+			if err := json.Unmarshal(data, &restored); err != nil {
+				return nil, err
+			}
+			return func() {
+				installAdjustments(restored)
+			}, nil
+		},
+	},
+	{
+		file: "presets.json",
+		dump: func() (interface{}, error) {
+			return listPresets(), nil
+		},
+		load: func(data []byte) (func(), error) {
+			var restored map[string]time.Duration
+			if err := json.Unmarshal(data, &restored); err != nil {
+				return nil, err
+			}
+			if restored == nil {
+				restored = map[string]time.Duration{}
+			}
+			return func() {
+				presetsMutex.Lock()
+				presets = restored
+				presetsMutex.Unlock()
+			}, nil
+		},
+	},
+	{
+		file: "bank.json",
+		dump: func() (interface{}, error) {
+			return bankExport(listBank()), nil
+		},
+		load: func(data []byte) (func(), error) {
+			entries, err := parseBank(data)
+			if err != nil {
+				return nil, err
+			}
+			return func() {
+				bankMutex.Lock()
+				installBank(entries)
+				if err := commitBank(entries); err != nil {
+					notify(SeverityError, "bank", "Restored bank could not be saved: %v", err)
+				}
+				bankMutex.Unlock()
+			}, nil
+		},
+	},
+	{
+		file: "history.json",
+		dump: func() (interface{}, error) {
+			return listHistory(), nil
+		},
+		load: func(data []byte) (func(), error) {
+			var restored []HistoryEntry
+			if err := json.Unmarshal(data, &restored); err != nil {
+				return nil, err
+			}
+			return func() {
+				installHistory(restored)
+			}, nil
+		},
+		recover: func(data []byte) (func(), error) {
+			var restored []HistoryEntry
+			if err := json.Unmarshal(data, &restored); err != nil {
+				return nil, err
+			}
+			return func() {
+				installHistory(restored)
+				resumeHistoryEntry()
+			}, nil
+		},
+	},
+	{
+		file: "disputes.json",
+		dump: func() (interface{}, error) {
+			return listDisputes(), nil
+		},
+		load: func(data []byte) (func(), error) {
+			var restored []Dispute
+			if err := json.Unmarshal(data, &restored); err != nil {
+				return nil, err
+			}
+			return func() {
+				installDisputes(restored)
+			}, nil
+		},
+	},
+	{
+		file: "presence.json",
+		dump: func() (interface{}, error) {
+			return presenceSpans(""), nil
+		},
+		load: func(data []byte) (func(), error) {
+			var restored []PresenceSpan
+			if err := json.Unmarshal(data, &restored); err != nil {
+				return nil, err
+			}
+			return func() {
+				installPresence(restored)
+			}, nil
+		},
+	},
+	{
+		file: "hostlead.json",
+		dump: func() (interface{}, error) {
+			return FlexDuration(showHostLead()), nil
+		},
+		load: func(data []byte) (func(), error) {
+			var restored FlexDuration
+			if err := json.Unmarshal(data, &restored); err != nil {
+				return nil, err
+			}
+			return func() {
+				hostLeadMutex.Lock()
+				hostLead = time.Duration(restored)
+				hostLeadMutex.Unlock()
+			}, nil
+		},
+	},
+	{
+		file: "queue.json",
+		dump: func() (interface{}, error) {
+			queueMutex.RLock()
+			defer queueMutex.RUnlock()
+			return QueueBackup{Entries: append([]QueueEntry{}, queue...), NextID: nextQueueID, Live: liveEntry}, nil
+		},
+		load: func(data []byte) (func(), error) {
+			var restored QueueBackup
+			if err := json.Unmarshal(data, &restored); err != nil {
+				return nil, err
+			}
+			for _, e := range restored.Entries {
+				if err := validateQuestion(e.question()); err != nil {
+					return nil, fmt.Errorf("entry %d: %v", e.ID, err)
+				}
+			}
+			return func() {
+				queueMutex.Lock()
+				queue, liveEntry = restored.Entries, restored.Live
+				nextQueueID = max(restored.NextID, 1)
+				for _, e := range queue {
+					nextQueueID = max(nextQueueID, e.ID+1)
+				}
+				queueRevision++
+				queueMutex.Unlock()
+				announceQueue("restore")
+				checkPreload()
+			}, nil
+		},
+	},
+	{
+		file: "defaults.json",
+		dump: func() (interface{}, error) {
+			return currentShowDefaults(), nil
+		},
+		load: func(data []byte) (func(), error) {
+			var restored ShowDefaults
+			if err := json.Unmarshal(data, &restored); err != nil {
+				return nil, err
+			}
+			if err := validateProfile(Profile{Types: restored.Types, AnnounceAt: restored.AnnounceAt}); err != nil {
+				return nil, err
+			}
+			return func() {
+				installShowDefaults(restored)
+			}, nil
+		},
+	},
+	{
+		file: "config.json",
+		dump: func() (interface{}, error) {
+			return currentShowConfig(), nil
+		},
+		load: func(data []byte) (func(), error) {
+			var restored ShowConfig
+			if err := json.Unmarshal(data, &restored); err != nil {
+				return nil, err
+			}
+			if err := validatePushTargets(restored.Targets); err != nil {
+				return nil, err
+			}
+			return func() {
+				installShowConfig(restored)
+			}, nil
+		},
+	},
+	{
+		file: "vars.json",
+		dump: func() (interface{}, error) {
+			return listTemplateVars(), nil
+		},
+		load: func(data []byte) (func(), error) {
+			var restored map[string]string
+			if err := json.Unmarshal(data, &restored); err != nil {
+				return nil, err
+			}
+			return func() {
+				templateVarsMutex.Lock()
+				templateVars = restored
+				templateVarsMutex.Unlock()
+			}, nil
+		},
+	},
+}
+
+// questionInProgress reports whether the live question is still running.
+func questionInProgress() bool {
+	questionMutex.RLock()
+	defer questionMutex.RUnlock()
+	return questionInProgressAt(question, clock.Now())
+}
+
+// questionInProgressAt reports whether q is still running at now.
+func questionInProgressAt(q Question, now time.Time) bool {
+	if q.Type == "end" || q.Type == "waiting" {
+		return false
+	}
+	if q.CountUp || q.Paused || q.Reading {
+		return true
+	}
+	return timeRemaining(q, now) > 0
+}
+
+func writeBackup(w io.Writer) error {
+	return writeArchive(w, 0)
+}
+
+// writeArchive writes a backup archive whose manifest records walSeq.
+func writeArchive(w io.Writer, walSeq uint64) error {
+	return writeArchiveWith(w, walSeq, nil)
+}
+
+// writeArchiveWith writes a backup archive with extra files next to the
+// sections. Restores ignore files they don't know.
+func writeArchiveWith(w io.Writer, walSeq uint64, extra map[string][]byte) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := clock.Now()
+
+	manifest := BackupManifest{Version: backupManifestVersion, Created: now, WALSeq: walSeq}
+	files := map[string][]byte{}
+	for _, s := range backupSections {
+		v, err := s.dump()
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.file, err)
+		}
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.file, err)
+		}
+		files[s.file] = data
+		manifest.Files = append(manifest.Files, s.file)
+	}
+	for name, data := range extra {
+		files[name] = data
+		manifest.Files = append(manifest.Files, name)
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	write := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := write("manifest.json", manifestData); err != nil {
+		return err
+	}
+	for _, name := range manifest.Files {
+		if err := write(name, files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeBackupFile(path string) error {
+	return writeArchiveFile(path, 0)
+}
+
+// writeArchiveFile writes an archive to path, replacing it only once the new
+// one is complete and on disk.
+func writeArchiveFile(path string, walSeq uint64) error {
+	return writeArchiveFileWith(path, walSeq, nil)
+}
+
+func writeArchiveFileWith(path string, walSeq uint64, extra map[string][]byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := writeArchiveWith(tmp, walSeq, extra); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// restoreBackup reads a whole archive, validates every section and only then
+// installs them. A failure at any point leaves the live state untouched.
+func restoreBackup(r io.Reader, force bool, origin string) (*BackupManifest, error) {
+	if !force && questionInProgress() {
+		return nil, fmt.Errorf("a question is in progress, use force to restore anyway")
+	}
+
+	files, manifest, err := readBackupArchive(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := installBackupFiles(files, false, origin); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// readBackupArchive reads the files of an archive and checks its manifest.
+func readBackupArchive(r io.Reader) (map[string][]byte, *BackupManifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a gzip archive: %v", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	files := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Size > maxBackupFileSize {
+			return nil, nil, fmt.Errorf("%s is too large", hdr.Name)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxBackupFileSize))
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading %s: %v", hdr.Name, err)
+		}
+		files[hdr.Name] = data
+	}
+
+	manifestData, ok := files["manifest.json"]
+	if !ok {
+		return nil, nil, fmt.Errorf("archive has no manifest.json")
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if manifest.Version != backupManifestVersion {
+		return nil, nil, fmt.Errorf("unsupported backup version %d (expected %d)", manifest.Version, backupManifestVersion)
+	}
+	return files, &manifest, nil
+}
+
+// installBackupFiles validates every section present in files and only then
+// installs them. recovering picks each section's recover function.
+func installBackupFiles(files map[string][]byte, recovering bool, origin string) error {
+	var installs []func()
+	for _, s := range backupSections {
+		data, ok := files[s.file]
+		if !ok {
+			continue
+		}
+		load := s.load
+		if recovering && s.recover != nil {
+			load = s.recover
+		}
+		install, err := load(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.file, err)
+		}
+		installs = append(installs, install)
+	}
+	for _, install := range installs {
+		install()
+	}
+	undoBarrier("restore", origin)
+	return nil
+}
+
+func restoreBackupFile(path string, force bool, origin string) (*BackupManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return restoreBackup(f, force, origin)
+}
+
+func getBackup(c echo.Context) error {
+	name := fmt.Sprintf("stuskova-backup-%s.tar.gz", clock.Now().Format("20060102-150405"))
+	c.Response().Header().Set(echo.HeaderContentType, "application/gzip")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", name))
+	c.Response().WriteHeader(http.StatusOK)
+	if err := writeBackup(c.Response()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error streaming backup: %v\n", err)
+		return nil
+	}
+	audit("backup", requestOrigin(c), nil)
+	return nil
+}
+
+func postRestore(c echo.Context) error {
+	fh, err := c.FormFile("archive")
+	if err != nil {
+		return badRequest("multipart field 'archive' is required")
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	defer f.Close()
+
+	force := c.FormValue("force") == "true"
+	origin := requestOrigin(c)
+	manifest, err := restoreBackup(f, force, origin)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	audit("restore", origin, map[string]interface{}{"created": manifest.Created, "force": force})
+	go sendCurrentQuestion()
+	return c.JSON(http.StatusOK, manifest)
+}
+
+func handleBackupCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	if len(args) != 1 {
+		return errors.New("Usage: backup <path>")
+	}
+	if err := writeBackupFile(args[0]); err != nil {
+		return fmt.Errorf("Backup failed: %v", err)
+	}
+	audit("backup", origin, map[string]interface{}{"path": args[0]})
+	success.Printf("Backup written to %s\n", args[0])
+	return nil
+}
+
+func handleRestoreCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	force := len(args) == 2 && args[1] == "--force"
+	if len(args) != 1 && !force {
+		return errors.New("Usage: restore <path> [--force]")
+	}
+	if !confirmCLI("restore", restoreSummary(nil), args...) {
+		return errors.New("Restore cancelled")
+	}
+	manifest, err := restoreBackupFile(args[0], force, origin)
+	if err != nil {
+		return fmt.Errorf("Restore failed: %v", err)
+	}
+	audit("restore", origin, map[string]interface{}{"path": args[0], "created": manifest.Created, "force": force})
+	success.Printf("Restored backup from %s (created %s)\n", args[0], manifest.Created.Format(time.RFC3339))
+	go sendCurrentQuestion()
+	return nil
+}
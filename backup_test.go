@@ -0,0 +1,185 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dumpSections is every section as it would be written to an archive.
+func dumpSections(t *testing.T) map[string]string {
+	t.Helper()
+	out := map[string]string{}
+	for _, s := range backupSections {
+		v, err := s.dump()
+		if err != nil {
+			t.Fatalf("%s: %v", s.file, err)
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("%s: %v", s.file, err)
+		}
+		out[s.file] = string(data)
+	}
+	return out
+}
+
+// keepSettings puts back the settings StartTestServer doesn't reset.
+func keepSettings(t *testing.T) {
+	d, c, p, v, lead := currentShowDefaults(), currentShowConfig(), listPresets(), listTemplateVars(), showHostLead()
+	t.Cleanup(func() {
+		installShowDefaults(d)
+		installShowConfig(c)
+		presetsMutex.Lock()
+		presets = p
+		presetsMutex.Unlock()
+		templateVarsMutex.Lock()
+		templateVars = v
+		templateVarsMutex.Unlock()
+		hostLeadMutex.Lock()
+		hostLead = lead
+		hostLeadMutex.Unlock()
+	})
+}
+
+// TestBackupRoundTrip backs up a show, wipes it and restores every section.
+func TestBackupRoundTrip(t *testing.T) {
+	s := StartTestServer(t)
+	keepSettings(t)
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Prestávka", "type": "waiting"})
+	flask := currentShowConfig().Targets
+
+	for _, name := range []string{"Sovy", "Líšky"} {
+		if err := addTeam(Team{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := adjustScore("Sovy", 5, causeManual, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := savePreset("kratka", 20*time.Second, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createBankEntry(BankEntry{Question: "Hlavné mesto?", Type: "pomoc", TimeLeft: FlexDuration(30 * time.Second)}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enqueue([]QueueEntry{{Question: "Prvá", TimeLeft: 30 * time.Second}, {Question: "Druhá", TimeLeft: 45 * time.Second, Round: 2}}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setTemplateVar("moderator", "Jana", "test"); err != nil {
+		t.Fatal(err)
+	}
+	setHostLead(3*time.Second, "test")
+	installShowDefaults(ShowDefaults{Types: []string{"pomoc", "bleskovka"}, AutoWait: FlexDuration(5 * time.Second), AutoWaitMessage: "Pauza", AnnounceAt: []FlexDuration{FlexDuration(10 * time.Second)}, AnnounceLang: "sk"})
+	installShowConfig(ShowConfig{Profile: "finale", LockWhileLive: true, Targets: append([]PushTarget{{Name: "obs", URL: "http://127.0.0.1:1/obs", AuthHeader: "Bearer tajné", SchemaVersion: 1}}, flask...)})
+
+	var archive bytes.Buffer
+	if err := writeBackup(&archive); err != nil {
+		t.Fatal(err)
+	}
+	want := dumpSections(t)
+	queueMutex.RLock()
+	nextID := nextQueueID
+	queueMutex.RUnlock()
+
+	StartTestServer(t)
+	queueMutex.Lock()
+	nextQueueID = 1
+	queueMutex.Unlock()
+	presetsMutex.Lock()
+	presets = map[string]time.Duration{}
+	presetsMutex.Unlock()
+	templateVarsMutex.Lock()
+	templateVars = map[string]string{}
+	templateVarsMutex.Unlock()
+	setHostLead(0, "test")
+	installShowDefaults(ShowDefaults{Types: []string{"pomoc"}})
+	installShowConfig(ShowConfig{Targets: flask})
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Koniec", "type": "end"})
+	if got := dumpSections(t); got["queue.json"] == want["queue.json"] || got["config.json"] == want["config.json"] {
+		t.Fatal("the wipe left the show as it was")
+	}
+
+	manifest, err := restoreBackup(bytes.NewReader(archive.Bytes()), false, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != len(backupSections) {
+		t.Errorf("manifest lists %v", manifest.Files)
+	}
+	got := dumpSections(t)
+	for _, s := range backupSections {
+		if got[s.file] != want[s.file] {
+			t.Errorf("%s restored as\n%s\nwant\n%s", s.file, got[s.file], want[s.file])
+		}
+	}
+	queueMutex.RLock()
+	defer queueMutex.RUnlock()
+	if nextQueueID != nextID {
+		t.Errorf("next queue id %d, want %d", nextQueueID, nextID)
+	}
+}
+
+// tarGz packs files into an archive in the order given, manifest first.
+func tarGz(t *testing.T, names []string, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name]))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(files[name])
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestRestoreRejectsVersion(t *testing.T) {
+	StartTestServer(t)
+	manifest, _ := json.Marshal(BackupManifest{Version: backupManifestVersion + 1, Files: []string{"teams.json"}})
+	archive := tarGz(t, []string{"manifest.json", "teams.json"}, map[string][]byte{
+		"manifest.json": manifest,
+		"teams.json":    []byte(`[{"name":"Orly"}]`),
+	})
+	_, err := restoreBackup(bytes.NewReader(archive), true, "test")
+	if err == nil || err.Error() != "unsupported backup version 2 (expected 1)" {
+		t.Errorf("a newer archive: %v", err)
+	}
+	if teams := listTeams(); len(teams) != 0 {
+		t.Errorf("restored %+v", teams)
+	}
+}
+
+func TestRestoreWhileLive(t *testing.T) {
+	s := StartTestServer(t)
+	if err := addTeam(Team{Name: "Sovy"}); err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err := writeBackup(&archive); err != nil {
+		t.Fatal(err)
+	}
+	StartTestServer(t)
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Hlavné mesto?", "type": "pomoc", "time_left": 30_000_000_000})
+
+	if _, err := restoreBackup(bytes.NewReader(archive.Bytes()), false, "test"); err == nil || !strings.Contains(err.Error(), "in progress") {
+		t.Errorf("without force: %v", err)
+	}
+	if teams := listTeams(); len(teams) != 0 {
+		t.Errorf("restored %+v without force", teams)
+	}
+	if _, err := restoreBackup(bytes.NewReader(archive.Bytes()), true, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if teams := listTeams(); len(teams) != 1 || teams[0].Name != "Sovy" {
+		t.Errorf("with force: teams %+v", teams)
+	}
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var bankFile = flag.String("bank", "", "question bank JSON file, loaded at startup and rewritten on every edit")
+
+// BankEntry is a question kept for later shows. Version goes up on every
+// edit so concurrent editors notice each other.
+type BankEntry struct {
+	ID                int               `json:"id"`
+	Question          string            `json:"question"`
+	TimeLeft          FlexDuration      `json:"time_left"`
+	Type              string            `json:"type"`
+	CountUp           bool              `json:"count_up"`
+	Round             int               `json:"round,omitempty"`
+	Notes             string            `json:"notes,omitempty"`
+	HostScript        []string          `json:"host_script,omitempty"`
+	AllowOvertime     bool              `json:"allow_overtime,omitempty"`
+	AnswerWindow      FlexDuration      `json:"answer_window,omitempty"`
+	OptionsRevealAt   FlexDuration      `json:"options_reveal_at,omitempty"`
+	ReadingTime       FlexDuration      `json:"reading_time,omitempty"`
+	Variants          map[string]string `json:"variants,omitempty"`
+	MediaURL          string            `json:"media_url,omitempty"`
+	MediaFallbackText string            `json:"media_fallback_text,omitempty"`
+	Options           []AnswerOption    `json:"options,omitempty"`
+	Scoring           *ScoringPolicy    `json:"scoring,omitempty"`
+	Matching          *MatchRules       `json:"matching,omitempty"`
+	Difficulty        int               `json:"difficulty,omitempty"`
+	Tags              []string          `json:"tags,omitempty"`
+	Checklist         []string          `json:"checklist,omitempty"`
+	GroupID           string            `json:"group_id,omitempty"`
+	Version           int               `json:"version"`
+	UpdatedAt         time.Time         `json:"updated_at"`
+
+	// Stats are collected from archived sessions, see bankstats.go. Edits
+	// keep them.
+	Stats []BankStats `json:"stats,omitempty"`
+}
+
+// BankExport is the file format of the bank and of GET /bank/export. With
+// ?public=true the export is for sharing after the show and leaves out
+// what only the operator sees, see publicBankEntry.
+type BankExport struct {
+	ExportedAt time.Time   `json:"exported_at"`
+	Entries    []BankEntry `json:"entries"`
+}
+
+// VersionConflict is an edit based on an outdated bank entry.
+type VersionConflict struct {
+	Current int
+}
+
+func (e *VersionConflict) Error() string {
+	return fmt.Sprintf("entry was changed by someone else, current version is %d", e.Current)
+}
+
+var (
+	bank       []BankEntry
+	bankMutex  sync.RWMutex
+	nextBankID = 1
+)
+
+func (e BankEntry) question() Question {
+	return Question{Question: e.Question, TimeLeft: time.Duration(e.TimeLeft), Type: e.Type, CountUp: e.CountUp, Notes: e.Notes, HostScript: e.HostScript, AllowOvertime: e.AllowOvertime, AnswerWindow: time.Duration(e.AnswerWindow), OptionsRevealAt: time.Duration(e.OptionsRevealAt), ReadingTime: time.Duration(e.ReadingTime), Variants: e.Variants, MediaURL: e.MediaURL, MediaFallbackText: e.MediaFallbackText, Options: e.Options, Scoring: e.Scoring, Matching: e.Matching}
+}
+
+// queueEntry is e queued for the show, remembering the entry it came from.
+func (e BankEntry) queueEntry() QueueEntry {
+	return QueueEntry{
+		Question:          e.Question,
+		TimeLeft:          time.Duration(e.TimeLeft),
+		Type:              e.Type,
+		CountUp:           e.CountUp,
+		Round:             e.Round,
+		GroupID:           e.GroupID,
+		Notes:             e.Notes,
+		AllowOvertime:     e.AllowOvertime,
+		AnswerWindow:      time.Duration(e.AnswerWindow),
+		OptionsRevealAt:   time.Duration(e.OptionsRevealAt),
+		ReadingTime:       time.Duration(e.ReadingTime),
+		Variants:          e.Variants,
+		MediaURL:          e.MediaURL,
+		MediaFallbackText: e.MediaFallbackText,
+		Options:           e.Options,
+		Scoring:           e.Scoring,
+		Matching:          e.Matching,
+		HostScript:        e.HostScript,
+		Checklist:         e.Checklist,
+		BankID:            e.ID,
+	}
+}
+
+func validateBankEntry(e *BankEntry) error {
+	if strings.TrimSpace(e.Question) == "" {
+		return fmt.Errorf("question is required")
+	}
+	if e.Type == "" {
+		e.Type = "pomoc"
+	}
+	if e.Round < 0 {
+		return fmt.Errorf("round must be non-negative")
+	}
+	if e.Difficulty < 0 || e.Difficulty > maxDifficulty {
+		return fmt.Errorf("difficulty must be between 1 and %d, or 0 for unrated", maxDifficulty)
+	}
+	e.Tags = cleanNames(e.Tags)
+	e.Checklist = cleanNames(e.Checklist)
+	return validateQuestion(e.question())
+}
+
+// parseBank reads either the export format or a bare list of entries, the
+// way the bank used to be written by hand. Entries without an ID get one.
+func parseBank(data []byte) ([]BankEntry, error) {
+	var entries []BankEntry
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, err
+		}
+	} else {
+		var export BankExport
+		if err := json.Unmarshal(data, &export); err != nil {
+			return nil, err
+		}
+		entries = export.Entries
+	}
+
+	seen := map[int]bool{}
+	maxID := 0
+	for i := range entries {
+		if err := validateBankEntry(&entries[i]); err != nil {
+			return nil, fmt.Errorf("entry %d: %v", i, err)
+		}
+		if id := entries[i].ID; id != 0 {
+			if seen[id] {
+				return nil, fmt.Errorf("entry %d: duplicate id %d", i, id)
+			}
+			seen[id] = true
+			if id > maxID {
+				maxID = id
+			}
+		}
+	}
+	if err := checkBankGroups(entries); err != nil {
+		return nil, err
+	}
+	now := clock.Now()
+	for i := range entries {
+		if entries[i].ID == 0 {
+			maxID++
+			entries[i].ID = maxID
+		}
+		if entries[i].Version == 0 {
+			entries[i].Version = 1
+		}
+		if entries[i].UpdatedAt.IsZero() {
+			entries[i].UpdatedAt = now
+		}
+	}
+	return entries, nil
+}
+
+// loadBank reads the -bank file. A missing file is an empty bank that will
+// be created on the first edit.
+func loadBank() error {
+	if *bankFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(*bankFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entries, err := parseBank(data)
+	if err != nil {
+		return err
+	}
+	bankMutex.Lock()
+	installBank(entries)
+	bankMutex.Unlock()
+	return nil
+}
+
+// installBank replaces the bank. Call with bankMutex held.
+func installBank(entries []BankEntry) {
+	bank = entries
+	indexBank(bank)
+	nextBankID = 1
+	for _, e := range bank {
+		if e.ID >= nextBankID {
+			nextBankID = e.ID + 1
+		}
+	}
+}
+
+func bankExport(entries []BankEntry) BankExport {
+	return BankExport{ExportedAt: clock.Now(), Entries: append([]BankEntry{}, entries...)}
+}
+
+// commitBank writes entries to the bank file and then makes them the bank,
+// so a failed write leaves both unchanged. Call with bankMutex held.
+func commitBank(entries []BankEntry) error {
+	if err := checkBankGroups(entries); err != nil {
+		return err
+	}
+	if *bankFile != "" {
+		data, err := json.MarshalIndent(bankExport(entries), "", "  ")
+		if err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(*bankFile), ".bank-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(data); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), *bankFile); err != nil {
+			return err
+		}
+	}
+	bank = entries
+	indexBank(bank)
+	return nil
+}
+
+func listBank() []BankEntry {
+	bankMutex.RLock()
+	defer bankMutex.RUnlock()
+	return append([]BankEntry{}, bank...)
+}
+
+func findBankEntry(id int) (BankEntry, bool) {
+	bankMutex.RLock()
+	defer bankMutex.RUnlock()
+	for _, e := range bank {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return BankEntry{}, false
+}
+
+func createBankEntry(e BankEntry, origin string) (BankEntry, error) {
+	if err := validateBankEntry(&e); err != nil {
+		return e, err
+	}
+	bankMutex.Lock()
+	e.ID = nextBankID
+	e.Version = 1
+	e.Stats = nil
+	e.UpdatedAt = clock.Now()
+	if err := commitBank(append(append([]BankEntry{}, bank...), e)); err != nil {
+		bankMutex.Unlock()
+		return e, err
+	}
+	nextBankID++
+	bankMutex.Unlock()
+	audit("bank_add", origin, map[string]interface{}{"id": e.ID})
+	return e, nil
+}
+
+// updateBankEntry replaces entry id, provided the caller edited the current
+// version of it.
+func updateBankEntry(id, version int, e BankEntry, origin string) (BankEntry, error) {
+	if err := validateBankEntry(&e); err != nil {
+		return e, err
+	}
+	bankMutex.Lock()
+	defer bankMutex.Unlock()
+	entries := append([]BankEntry{}, bank...)
+	for i, old := range entries {
+		if old.ID != id {
+			continue
+		}
+		if old.Version != version {
+			return old, &VersionConflict{Current: old.Version}
+		}
+		e.ID = id
+		e.Version = old.Version + 1
+		e.UpdatedAt = clock.Now()
+		e.Stats = old.Stats
+		entries[i] = e
+		if err := commitBank(entries); err != nil {
+			return old, err
+		}
+		audit("bank_update", origin, map[string]interface{}{"id": id, "version": e.Version})
+		return e, nil
+	}
+	return e, errBankEntryNotFound
+}
+
+var errBankEntryNotFound = fmt.Errorf("bank entry not found")
+
+func deleteBankEntry(id int, origin string) error {
+	bankMutex.Lock()
+	defer bankMutex.Unlock()
+	for i, e := range bank {
+		if e.ID != id {
+			continue
+		}
+		entries := append(append([]BankEntry{}, bank[:i]...), bank[i+1:]...)
+		if err := commitBank(entries); err != nil {
+			return err
+		}
+		audit("bank_remove", origin, map[string]interface{}{"id": id})
+		return nil
+	}
+	return errBankEntryNotFound
+}
+
+// BankEntryRequest is the body of POST /bank and PUT /bank/:id. Version is
+// the version the edit is based on and is required for PUT.
+type BankEntryRequest struct {
+	QueueEntryRequest
+	Difficulty int      `json:"difficulty"`
+	Tags       []string `json:"tags"`
+	Version    int      `json:"version"`
+}
+
+func (r BankEntryRequest) entry() BankEntry {
+	return BankEntry{
+		Question:          r.Question,
+		TimeLeft:          r.TimeLeft,
+		Type:              r.Type,
+		CountUp:           r.CountUp,
+		Round:             r.Round,
+		Notes:             r.Notes,
+		HostScript:        r.HostScript,
+		AllowOvertime:     r.AllowOvertime,
+		AnswerWindow:      r.AnswerWindow,
+		OptionsRevealAt:   r.OptionsRevealAt,
+		ReadingTime:       r.ReadingTime,
+		Variants:          r.Variants,
+		MediaURL:          r.MediaURL,
+		MediaFallbackText: r.MediaFallbackText,
+		Options:           r.Options,
+		Scoring:           r.Scoring,
+		Matching:          r.Matching,
+		Difficulty:        r.Difficulty,
+		Tags:              r.Tags,
+		Checklist:         r.Checklist,
+		GroupID:           r.GroupID,
+	}
+}
+
+func bankID(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, badRequest("invalid bank entry id")
+	}
+	return id, nil
+}
+
+func bankError(err error) error {
+	if err == errBankEntryNotFound {
+		return notFound(err.Error())
+	}
+	if verr, ok := err.(*VersionConflict); ok {
+		return apiError(http.StatusConflict, "version_conflict", verr.Error()).withDetail("current_version", verr.Current)
+	}
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+	if errors.As(err, &pathErr) || errors.As(err, &linkErr) {
+		// The bank file could not be written: a server problem, not the
+		// client's.
+		return err
+	}
+	return validationError(err)
+}
+
+func getBank(c echo.Context) error {
+	return c.JSON(http.StatusOK, listBank())
+}
+
+func getBankEntry(c echo.Context) error {
+	id, err := bankID(c)
+	if err != nil {
+		return err
+	}
+	e, ok := findBankEntry(id)
+	if !ok {
+		return notFound(errBankEntryNotFound.Error())
+	}
+	return c.JSON(http.StatusOK, e)
+}
+
+func postBankEntry(c echo.Context) error {
+	req := new(BankEntryRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if err := probeQuestionMedia(req.MediaURL, req.MediaFallbackText); err != nil {
+		return mediaError(err)
+	}
+	e, err := createBankEntry(req.entry(), requestOrigin(c))
+	if err != nil {
+		return bankError(err)
+	}
+	return c.JSON(http.StatusCreated, e)
+}
+
+func putBankEntry(c echo.Context) error {
+	id, err := bankID(c)
+	if err != nil {
+		return err
+	}
+	req := new(BankEntryRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if req.Version == 0 {
+		return badRequest("version is required")
+	}
+	if err := probeQuestionMedia(req.MediaURL, req.MediaFallbackText); err != nil {
+		return mediaError(err)
+	}
+	e, err := updateBankEntry(id, req.Version, req.entry(), requestOrigin(c))
+	if err != nil {
+		return bankError(err)
+	}
+	return c.JSON(http.StatusOK, e)
+}
+
+func deleteBankEntryHandler(c echo.Context) error {
+	id, err := bankID(c)
+	if err != nil {
+		return err
+	}
+	if err := deleteBankEntry(id, requestOrigin(c)); err != nil {
+		return bankError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// publicBankEntry is e without its host notes, host script and checklist.
+func publicBankEntry(e BankEntry) BankEntry {
+	e.Notes, e.HostScript, e.Checklist = "", nil, nil
+	return e
+}
+
+func getBankExport(c echo.Context) error {
+	entries, kind := listBank(), "bank"
+	if c.QueryParam("public") == "true" {
+		for i := range entries {
+			entries[i] = publicBankEntry(entries[i])
+		}
+		kind = "bank-public"
+	}
+	name := fmt.Sprintf("stuskova-%s-%s.json", kind, clock.Now().Format("20060102-150405"))
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", name))
+	return c.JSONPretty(http.StatusOK, bankExport(entries), "  ")
+}
+
+func handleBankCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 0 || args[0] == "list" {
+		entries := listBank()
+		if len(entries) == 0 {
+			info.Println("The bank is empty")
+			return nil
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+		for _, e := range entries {
+			info.Printf("  #%-4d round %-2d %-8s %6s  %s\n", e.ID, e.Round, e.Type, time.Duration(e.TimeLeft), e.Question)
+		}
+		return nil
+	}
+	if args[0] == "dedupe" {
+		return handleDedupeCommand(args[1:], origin)
+	}
+	if args[0] == "calibrate" {
+		return handleCalibrateCommand(args[1:], origin)
+	}
+	if len(args) != 2 {
+		return errors.New(bankUsage)
+	}
+	id, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errors.New("Invalid bank entry id")
+	}
+	switch args[0] {
+	case "show":
+		e, ok := findBankEntry(id)
+		if !ok {
+			return errBankEntryNotFound
+		}
+		info.Printf("#%d (version %d, updated %s)\n", e.ID, e.Version, e.UpdatedAt.Format(time.RFC3339))
+		info.Printf("Question: %s\n", e.Question)
+		for locale, text := range e.Variants {
+			info.Printf("  %s: %s\n", locale, text)
+		}
+		info.Printf("Type: %s, round %d, time %s, count up %v\n", e.Type, e.Round, time.Duration(e.TimeLeft), e.CountUp)
+		if e.Difficulty > 0 {
+			info.Printf("Difficulty: %d\n", e.Difficulty)
+		}
+		if len(e.Tags) > 0 {
+			info.Printf("Tags: %s\n", strings.Join(e.Tags, ", "))
+		}
+		if len(e.Checklist) > 0 {
+			info.Printf("Checklist: %s\n", strings.Join(e.Checklist, ", "))
+		}
+		if s, ok := currentBankStats(e); ok {
+			info.Printf("Asked %d time(s), %s\n", s.Asked, describeAccuracy(s))
+		}
+		if e.Notes != "" {
+			info.Printf("Notes: %s\n", e.Notes)
+		}
+		for _, line := range e.HostScript {
+			info.Printf("  > %s\n", line)
+		}
+	case "rm":
+		if !confirmCLI("bank_remove", bankRemovalSummary(id), strconv.Itoa(id)) {
+			return errors.New("Bank entry removal cancelled")
+		}
+		if err := deleteBankEntry(id, origin); err != nil {
+			return err
+		}
+		success.Printf("Bank entry #%d removed\n", id)
+	case "queue":
+		added, err := queueFromBank([]int{id}, origin)
+		if err != nil {
+			return err
+		}
+		checkRundown()
+		success.Printf("Bank entry #%d queued as #%d\n", id, added[0].ID)
+	default:
+		return errors.New(bankUsage)
+	}
+	return nil
+}
+
+const bankUsage = "Usage: bank [list|show <id>|rm <id>|queue <id>|dedupe [threshold]|calibrate [--apply] [--min n]]"
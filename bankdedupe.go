@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/text/unicode/norm"
+)
+
+var dedupeThreshold = flag.Float64("dedupe-threshold", 0.8, "token Jaccard similarity above which bank questions count as near-duplicates")
+
+// DuplicateMember is one bank entry of a duplicate group.
+type DuplicateMember struct {
+	ID       int    `json:"id"`
+	Question string `json:"question"`
+}
+
+// DuplicateGroup is a set of bank entries asking the same question.
+// Similarity is the weakest link that joined the group, 1 for exact copies.
+type DuplicateGroup struct {
+	Entries    []DuplicateMember `json:"entries"`
+	Similarity float64           `json:"similarity"`
+	Exact      bool              `json:"exact"`
+}
+
+// normalizeQuestionText lowercases s, strips diacritics ("Čo je?" and
+// "co je" compare equal) and reduces punctuation to single spaces.
+func normalizeQuestionText(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// dedupeDoc is one distinct normalized text and the entries that have it.
+type dedupeDoc struct {
+	ids    []int
+	tokens []string
+}
+
+func jaccard(a, b []string) float64 {
+	set := make(map[string]bool, len(a))
+	for _, t := range a {
+		set[t] = true
+	}
+	shared := 0
+	for _, t := range b {
+		if set[t] {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 1
+	}
+	return float64(shared) / float64(union)
+}
+
+// findDuplicates groups entries whose normalized texts are equal or whose
+// token sets are at least threshold similar. Each text is tokenized once.
+// Only pairs sharing a token from their prefixes are compared: with tokens
+// ordered rarest first, two sets that similar must share one of their first
+// len - ceil(threshold*len) + 1 tokens.
+func findDuplicates(entries []BankEntry, threshold float64) []DuplicateGroup {
+	byText := map[string]*dedupeDoc{}
+	var docs []*dedupeDoc
+	for _, e := range entries {
+		text := normalizeQuestionText(e.Question)
+		d, ok := byText[text]
+		if !ok {
+			seen := map[string]bool{}
+			d = &dedupeDoc{}
+			for _, t := range strings.Fields(text) {
+				if !seen[t] {
+					seen[t] = true
+					d.tokens = append(d.tokens, t)
+				}
+			}
+			byText[text] = d
+			docs = append(docs, d)
+		}
+		d.ids = append(d.ids, e.ID)
+	}
+
+	freq := map[string]int{}
+	for _, d := range docs {
+		for _, t := range d.tokens {
+			freq[t]++
+		}
+	}
+	for _, d := range docs {
+		sort.Slice(d.tokens, func(i, j int) bool {
+			a, b := d.tokens[i], d.tokens[j]
+			if freq[a] != freq[b] {
+				return freq[a] < freq[b]
+			}
+			return a < b
+		})
+	}
+
+	// Union-find over docs, remembering the weakest link of each group.
+	parent := make([]int, len(docs))
+	weakest := make([]float64, len(docs))
+	for i := range parent {
+		parent[i] = i
+		weakest[i] = 1
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	index := map[string][]int{}
+	for i, d := range docs {
+		prefix := len(d.tokens) - int(math.Ceil(threshold*float64(len(d.tokens)))) + 1
+		if prefix > len(d.tokens) {
+			prefix = len(d.tokens)
+		}
+		compared := map[int]bool{}
+		for _, t := range d.tokens[:prefix] {
+			for _, j := range index[t] {
+				if compared[j] {
+					continue
+				}
+				compared[j] = true
+				sim := jaccard(d.tokens, docs[j].tokens)
+				if sim < threshold {
+					continue
+				}
+				a, b := find(i), find(j)
+				if a == b {
+					continue
+				}
+				low := math.Min(sim, math.Min(weakest[a], weakest[b]))
+				parent[a] = b
+				weakest[b] = low
+			}
+			index[t] = append(index[t], i)
+		}
+	}
+
+	questions := map[int]string{}
+	for _, e := range entries {
+		questions[e.ID] = e.Question
+	}
+	members := map[int][]*dedupeDoc{}
+	for i, d := range docs {
+		root := find(i)
+		members[root] = append(members[root], d)
+	}
+	var groups []DuplicateGroup
+	for root, ds := range members {
+		var ids []int
+		for _, d := range ds {
+			ids = append(ids, d.ids...)
+		}
+		if len(ids) < 2 {
+			continue
+		}
+		sort.Ints(ids)
+		g := DuplicateGroup{Similarity: weakest[root], Exact: len(ds) == 1}
+		for _, id := range ids {
+			g.Entries = append(g.Entries, DuplicateMember{ID: id, Question: questions[id]})
+		}
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Entries[0].ID < groups[j].Entries[0].ID })
+	return groups
+}
+
+// deleteBankEntries removes several entries with a single write. Nothing
+// is removed unless every id exists.
+func deleteBankEntries(ids []int, origin string) error {
+	bankMutex.Lock()
+	defer bankMutex.Unlock()
+	remove := map[int]bool{}
+	for _, id := range ids {
+		remove[id] = true
+	}
+	var entries []BankEntry
+	for _, e := range bank {
+		if remove[e.ID] {
+			delete(remove, e.ID)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if len(remove) > 0 {
+		return errBankEntryNotFound
+	}
+	if err := commitBank(entries); err != nil {
+		return err
+	}
+	audit("bank_remove", origin, map[string]interface{}{"ids": ids})
+	return nil
+}
+
+func parseThreshold(s string) (float64, error) {
+	if s == "" {
+		return *dedupeThreshold, nil
+	}
+	t, err := strconv.ParseFloat(s, 64)
+	if err != nil || t <= 0 || t > 1 {
+		return 0, fmt.Errorf("threshold must be a number in (0, 1]")
+	}
+	return t, nil
+}
+
+func getBankDuplicates(c echo.Context) error {
+	threshold, err := parseThreshold(c.QueryParam("threshold"))
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	groups := findDuplicates(listBank(), threshold)
+	if groups == nil {
+		groups = []DuplicateGroup{}
+	}
+	return c.JSON(http.StatusOK, groups)
+}
+
+// ResolveDuplicatesRequest is the body of POST /bank/duplicates/resolve.
+type ResolveDuplicatesRequest struct {
+	Remove []int `json:"remove"`
+}
+
+func postResolveDuplicates(c echo.Context) error {
+	req := new(ResolveDuplicatesRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if len(req.Remove) == 0 {
+		return badRequest("remove must list at least one entry id")
+	}
+	if err := deleteBankEntries(req.Remove, requestOrigin(c)); err != nil {
+		return bankError(err)
+	}
+	return c.JSON(http.StatusOK, map[string][]int{"removed": req.Remove})
+}
+
+// handleDedupeCommand runs "bank dedupe [threshold]".
+func handleDedupeCommand(args []string, origin string) error {
+	info := color.New(color.FgYellow)
+
+	arg := ""
+	if len(args) > 0 {
+		arg = args[0]
+	}
+	threshold, err := parseThreshold(arg)
+	if err != nil || len(args) > 1 {
+		return errors.New("Usage: bank dedupe [threshold]")
+	}
+	groups := findDuplicates(listBank(), threshold)
+	if len(groups) == 0 {
+		info.Println("No duplicates found")
+		return nil
+	}
+	for _, g := range groups {
+		kind := "exact"
+		if !g.Exact {
+			kind = fmt.Sprintf("%.0f%% similar", g.Similarity*100)
+		}
+		info.Printf("%s:\n", kind)
+		for _, m := range g.Entries {
+			info.Printf("  #%-4d %s\n", m.ID, m.Question)
+		}
+	}
+	info.Printf("%d group(s), remove entries with bank rm <id>\n", len(groups))
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func groupIDs(groups []DuplicateGroup) [][]int {
+	var ids [][]int
+	for _, g := range groups {
+		var group []int
+		for _, m := range g.Entries {
+			group = append(group, m.ID)
+		}
+		ids = append(ids, group)
+	}
+	return ids
+}
+
+func TestFindDuplicates(t *testing.T) {
+	if got := normalizeQuestionText("  Čo je HLAVNÉ mesto, Slovenska?! "); got != "co je hlavne mesto slovenska" {
+		t.Errorf("normalized %q", got)
+	}
+
+	entries := []BankEntry{
+		{ID: 1, Question: "Hlavné mesto Slovenska?"},
+		{ID: 2, Question: "Ktorá rieka tečie cez Bratislavu?"},
+		{ID: 3, Question: "hlavne mesto slovenska"},
+		{ID: 4, Question: "Ktorá rieka tečie cez Bratislavu a Viedeň?"},
+		{ID: 5, Question: "Koľko mostov má Bratislava?"},
+		{ID: 6, Question: "HLAVNÉ MESTO SLOVENSKA!"},
+	}
+	groups := findDuplicates(entries, 0.7)
+	if want := [][]int{{1, 3, 6}, {2, 4}}; !reflect.DeepEqual(groupIDs(groups), want) {
+		t.Fatalf("groups %v, want %v", groupIDs(groups), want)
+	}
+	if g := groups[0]; !g.Exact || g.Similarity != 1 || g.Entries[1].Question != "hlavne mesto slovenska" {
+		t.Errorf("exact group %+v", g)
+	}
+	// Five shared tokens of seven.
+	if g := groups[1]; g.Exact || g.Similarity < 0.71 || g.Similarity > 0.72 {
+		t.Errorf("near group %+v", g)
+	}
+
+	// The default threshold keeps only the exact copies.
+	if got := groupIDs(findDuplicates(entries, *dedupeThreshold)); !reflect.DeepEqual(got, [][]int{{1, 3, 6}}) {
+		t.Errorf("at %v: %v", *dedupeThreshold, got)
+	}
+	if got := findDuplicates(entries[4:5], 0.5); got != nil {
+		t.Errorf("a single entry: %v", got)
+	}
+}
+
+func TestResolveDuplicates(t *testing.T) {
+	s := StartTestServer(t)
+	for _, q := range []string{"Hlavné mesto?", "Koľko mostov má Bratislava?", "hlavne mesto"} {
+		s.MustDo(t, http.MethodPost, "/bank", map[string]interface{}{"question": q, "type": "pomoc", "time_left": 30_000_000_000})
+	}
+	var groups []DuplicateGroup
+	if status := s.Do(t, http.MethodGet, "/bank/duplicates", nil, &groups); status != http.StatusOK {
+		t.Fatalf("duplicates: status %d", status)
+	}
+	if len(groups) != 1 || !groups[0].Exact || len(groups[0].Entries) != 2 {
+		t.Fatalf("groups %+v", groups)
+	}
+	for _, threshold := range []string{"0", "1.5", "x"} {
+		if status := s.Do(t, http.MethodGet, "/bank/duplicates?threshold="+threshold, nil, nil); status != http.StatusBadRequest {
+			t.Errorf("threshold %s: status %d", threshold, status)
+		}
+	}
+
+	// An unknown id removes nothing.
+	keep, drop := groups[0].Entries[0].ID, groups[0].Entries[1].ID
+	if status := s.DoConfirmed(t, http.MethodPost, "/bank/duplicates/resolve", map[string][]int{"remove": {drop, 999}}, nil); status != http.StatusNotFound {
+		t.Errorf("resolving with an unknown id: status %d", status)
+	}
+	if n := len(listBank()); n != 3 {
+		t.Fatalf("%d entries left after a failed resolve", n)
+	}
+	if status := s.DoConfirmed(t, http.MethodPost, "/bank/duplicates/resolve", map[string][]int{"remove": {}}, nil); status != http.StatusBadRequest {
+		t.Errorf("resolving nothing: status %d", status)
+	}
+
+	if status := s.DoConfirmed(t, http.MethodPost, "/bank/duplicates/resolve", map[string][]int{"remove": {drop}}, nil); status != http.StatusOK {
+		t.Fatalf("resolving: status %d", status)
+	}
+	if e := lastAudit(t, "bank_remove"); e.Origin != "http" {
+		t.Errorf("removal audited as %+v", e)
+	}
+	s.Do(t, http.MethodGet, "/bank/duplicates", nil, &groups)
+	if len(groups) != 0 {
+		t.Errorf("groups after resolving %+v", groups)
+	}
+	for _, e := range listBank() {
+		if e.ID == drop {
+			t.Errorf("#%d is still in the bank", drop)
+		}
+	}
+	if _, ok := findBankEntry(keep); !ok {
+		t.Errorf("#%d was removed", keep)
+	}
+	if err := handleDedupeCommand([]string{"0.5", "extra"}, "cli"); err == nil {
+		t.Error("dedupe took two arguments")
+	}
+}
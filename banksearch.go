@@ -0,0 +1,521 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/text/unicode/norm"
+)
+
+// The bank search index maps every folded word of the bank's texts to the
+// entries that have it. Query words match as prefixes, so results follow
+// what is being typed, and every word has to match. The index is rebuilt
+// whenever the bank changes, which for a couple of thousand entries takes
+// a few tens of milliseconds once per edit; a search takes microseconds.
+
+const (
+	bankSearchDefaultLimit = 10
+	bankSearchMaxLimit     = 100
+	// bankSnippetChars is how much of a long text a result shows around
+	// its first match.
+	bankSnippetChars = 100
+)
+
+// searchToken is one word of a text, folded for search, and where it is in
+// the text, in characters.
+type searchToken struct {
+	word       string
+	start, end int
+}
+
+// foldRune writes r to b lowercased and without diacritics: "Š" as "s".
+// Combining marks fold to nothing and stay part of their word.
+func foldRune(b *strings.Builder, r rune) {
+	if r < utf8.RuneSelf {
+		b.WriteRune(unicode.ToLower(r))
+		return
+	}
+	var src [utf8.UTFMax]byte
+	var buf [4 * utf8.UTFMax]byte
+	d := norm.NFD.Append(buf[:0], src[:utf8.EncodeRune(src[:], r)]...)
+	for len(d) > 0 {
+		c, size := utf8.DecodeRune(d)
+		d = d[size:]
+		if !unicode.Is(unicode.Mn, c) {
+			b.WriteRune(unicode.ToLower(c))
+		}
+	}
+}
+
+// tokenizeSearch splits s into folded words. Anything that is not a
+// letter, digit or combining mark separates words.
+func tokenizeSearch(s string) []searchToken {
+	var out []searchToken
+	var word strings.Builder
+	start, i := -1, 0
+	flush := func() {
+		if start >= 0 && word.Len() > 0 {
+			out = append(out, searchToken{word: word.String(), start: start, end: i})
+		}
+		word.Reset()
+		start = -1
+	}
+	for _, r := range s {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if start < 0 {
+				start = i
+			}
+			foldRune(&word, r)
+		case unicode.Is(unicode.Mn, r) && start >= 0:
+		default:
+			flush()
+		}
+		i++
+	}
+	flush()
+	return out
+}
+
+// searchWords is the folded words of a query, each once.
+func searchWords(s string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, t := range tokenizeSearch(s) {
+		if !seen[t.word] {
+			seen[t.word] = true
+			out = append(out, t.word)
+		}
+	}
+	return out
+}
+
+// searchField is one searchable text of an entry. Matches in the question
+// count most, then tags, then the rest.
+type searchField struct {
+	name   string
+	text   string
+	weight int
+	tokens []searchToken
+}
+
+// bankPosting is an entry that has a word, by its position in the index,
+// and the weight of the best field it is in.
+type bankPosting struct {
+	doc    int
+	weight int
+}
+
+// bankDoc is an indexed entry: its texts and its folded tags.
+type bankDoc struct {
+	entry  BankEntry
+	fields []searchField
+	tags   []string
+}
+
+// bankSearchIndex keeps the entries in ID order and every word they have,
+// sorted, so the words a query word is a prefix of are next to each other.
+type bankSearchIndex struct {
+	docs     []*bankDoc
+	words    []string
+	postings map[string][]bankPosting
+}
+
+// searchIndex is the index of the bank. Guarded by bankMutex, like the
+// bank it is built from.
+var searchIndex = &bankSearchIndex{}
+
+func entryFields(e BankEntry) []searchField {
+	fields := []searchField{{name: "question", text: e.Question, weight: 3}}
+	for _, tag := range e.Tags {
+		fields = append(fields, searchField{name: "tags", text: tag, weight: 2})
+	}
+	locales := make([]string, 0, len(e.Variants))
+	for locale := range e.Variants {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	for _, locale := range locales {
+		fields = append(fields, searchField{name: "variant:" + locale, text: e.Variants[locale], weight: 1})
+	}
+	for _, o := range e.Options {
+		fields = append(fields, searchField{name: "options", text: o.Text, weight: 1})
+	}
+	if e.Notes != "" {
+		fields = append(fields, searchField{name: "notes", text: e.Notes, weight: 1})
+	}
+	for i := range fields {
+		fields[i].tokens = tokenizeSearch(fields[i].text)
+	}
+	return fields
+}
+
+// indexBank rebuilds the search index from entries. Call with bankMutex
+// held.
+func indexBank(entries []BankEntry) {
+	sorted := append([]BankEntry{}, entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	idx := &bankSearchIndex{
+		docs:     make([]*bankDoc, len(sorted)),
+		postings: map[string][]bankPosting{},
+	}
+	for n, e := range sorted {
+		doc := &bankDoc{entry: e, fields: entryFields(e)}
+		for _, tag := range e.Tags {
+			doc.tags = append(doc.tags, strings.Join(searchWords(tag), " "))
+		}
+		idx.docs[n] = doc
+		best := map[string]int{}
+		for _, f := range doc.fields {
+			for _, t := range f.tokens {
+				best[t.word] = max(best[t.word], f.weight)
+			}
+		}
+		for word, weight := range best {
+			idx.postings[word] = append(idx.postings[word], bankPosting{doc: n, weight: weight})
+		}
+	}
+	idx.words = make([]string, 0, len(idx.postings))
+	for word := range idx.postings {
+		idx.words = append(idx.words, word)
+	}
+	sort.Strings(idx.words)
+	searchIndex = idx
+}
+
+// BankSearchHit is one search result. Highlights are the matched words of
+// Snippet as [start, end) offsets in characters.
+type BankSearchHit struct {
+	ID         int      `json:"id"`
+	Question   string   `json:"question"`
+	Type       string   `json:"type"`
+	Round      int      `json:"round,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Field      string   `json:"field"`
+	Snippet    string   `json:"snippet"`
+	Highlights [][2]int `json:"highlights"`
+	Score      int      `json:"score"`
+}
+
+// BankSearchResult is the answer of GET /bank/search. Total counts every
+// match, Hits only the first limit.
+type BankSearchResult struct {
+	Query string          `json:"query"`
+	Tags  []string        `json:"tags,omitempty"`
+	Total int             `json:"total"`
+	Hits  []BankSearchHit `json:"hits"`
+	Took  time.Duration   `json:"took"`
+}
+
+// matchesWord reports whether the folded word w matches one of the query
+// words.
+func matchesWord(w string, query []string) bool {
+	for _, q := range query {
+		if strings.HasPrefix(w, q) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchBank finds the entries with every word of query, as a prefix of
+// one of their words, and all of tags. An empty query lists the entries
+// with the tags.
+func searchBank(query string, tags []string, limit int) BankSearchResult {
+	began := time.Now()
+	words := searchWords(query)
+	var wantTags []string
+	for _, tag := range tags {
+		if t := strings.Join(searchWords(tag), " "); t != "" {
+			wantTags = append(wantTags, t)
+		}
+	}
+	res := BankSearchResult{Query: query, Tags: tags, Hits: []BankSearchHit{}}
+
+	bankMutex.RLock()
+	defer bankMutex.RUnlock()
+	idx := searchIndex
+
+	// A document stays a candidate while it has matched every word so far:
+	// matched counts those words and score adds up their best matches.
+	n := len(idx.docs)
+	matched, score, best := make([]int, n), make([]int, n), make([]int, n)
+	var candidates []int
+	if len(words) == 0 {
+		candidates = make([]int, n)
+		for i := range candidates {
+			candidates[i] = i
+		}
+	}
+	for k, q := range words {
+		var next []int
+		for i := sort.SearchStrings(idx.words, q); i < len(idx.words) && strings.HasPrefix(idx.words[i], q); i++ {
+			w := idx.words[i]
+			for _, p := range idx.postings[w] {
+				if matched[p.doc] != k {
+					continue
+				}
+				s := p.weight
+				if w == q {
+					s *= 2
+				}
+				if best[p.doc] == 0 {
+					next = append(next, p.doc)
+				}
+				best[p.doc] = max(best[p.doc], s)
+			}
+		}
+		for _, d := range next {
+			score[d] += best[d]
+			best[d] = 0
+			matched[d]++
+		}
+		candidates = next
+	}
+
+	// Only the best limit are kept in order, so a short word matching most
+	// of the bank doesn't sort all of it.
+	ranked := make([]bankPosting, 0, limit+1)
+	for _, d := range candidates {
+		if !hasSearchTags(idx.docs[d].tags, wantTags) {
+			continue
+		}
+		res.Total++
+		r := bankPosting{doc: d, weight: score[d]}
+		i := len(ranked)
+		for i > 0 && (ranked[i-1].weight < r.weight || ranked[i-1].weight == r.weight && ranked[i-1].doc > r.doc) {
+			i--
+		}
+		if i == limit {
+			continue
+		}
+		ranked = append(ranked, bankPosting{})
+		copy(ranked[i+1:], ranked[i:])
+		ranked[i] = r
+		if len(ranked) > limit {
+			ranked = ranked[:limit]
+		}
+	}
+	for _, r := range ranked {
+		res.Hits = append(res.Hits, searchHit(idx.docs[r.doc], words, r.weight))
+	}
+	res.Took = time.Since(began)
+	return res
+}
+
+func hasSearchTags(have, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			found = found || h == w
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// searchHit builds the result for doc. The snippet comes from its
+// weightiest field with a match, the question if nothing matched; fields
+// are kept weightiest first.
+func searchHit(doc *bankDoc, words []string, score int) BankSearchHit {
+	e := doc.entry
+	hit := BankSearchHit{ID: e.ID, Question: e.Question, Type: e.Type, Round: e.Round, Tags: e.Tags, Score: score}
+	field := doc.fields[0]
+	for _, f := range doc.fields {
+		if hasMatch(f, words) {
+			field = f
+			break
+		}
+	}
+	var marks [][2]int
+	for _, t := range field.tokens {
+		if matchesWord(t.word, words) {
+			marks = append(marks, [2]int{t.start, t.end})
+		}
+	}
+	hit.Field = field.name
+	hit.Snippet, hit.Highlights = snippet(field.text, marks)
+	return hit
+}
+
+func hasMatch(f searchField, words []string) bool {
+	for _, t := range f.tokens {
+		if matchesWord(t.word, words) {
+			return true
+		}
+	}
+	return false
+}
+
+// snippet cuts text down to bankSnippetChars around its first mark, with
+// "…" where it was cut, and moves the marks along.
+func snippet(text string, marks [][2]int) (string, [][2]int) {
+	runes := []rune(text)
+	if marks == nil {
+		marks = [][2]int{}
+	}
+	if len(runes) <= bankSnippetChars {
+		return text, marks
+	}
+	from := 0
+	if len(marks) > 0 {
+		from = max(marks[0][0]-bankSnippetChars/3, 0)
+	}
+	from = min(from, len(runes)-bankSnippetChars)
+	to := from + bankSnippetChars
+	out := string(runes[from:to])
+	shift := -from
+	if from > 0 {
+		out = "…" + out
+		shift++
+	}
+	if to < len(runes) {
+		out += "…"
+	}
+	kept := [][2]int{}
+	for _, m := range marks {
+		if m[0] >= from && m[1] <= to {
+			kept = append(kept, [2]int{m[0] + shift, m[1] + shift})
+		}
+	}
+	return out, kept
+}
+
+func getBankSearch(c echo.Context) error {
+	query, tags := c.QueryParam("q"), c.QueryParams()["tag"]
+	if len(searchWords(query)) == 0 && len(tags) == 0 {
+		return badRequest("q or tag is required")
+	}
+	limit := bankSearchDefaultLimit
+	if s := c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > bankSearchMaxLimit {
+			return badRequest(fmt.Sprintf("limit must be 1 to %d", bankSearchMaxLimit))
+		}
+		limit = n
+	}
+	return c.JSON(http.StatusOK, searchBank(query, tags, limit))
+}
+
+// lastFind is the bank entries the last "find" listed, in order, for
+// "stage #n" and "pick #n".
+var (
+	lastFind      []int
+	lastFindMutex sync.Mutex
+)
+
+// handleFindCommand runs "find <words> [--tag <tag>]...".
+func handleFindCommand(args []string, origin string) error {
+	info := color.New(color.FgYellow)
+	mark := color.New(color.FgGreen)
+
+	var words, tags []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--tag" && i+1 < len(args) {
+			tags = append(tags, args[i+1])
+			i++
+			continue
+		}
+		words = append(words, args[i])
+	}
+	if len(searchWords(strings.Join(words, " "))) == 0 && len(tags) == 0 {
+		return errors.New("Usage: find <words> [--tag <tag>]...")
+	}
+	res := searchBank(strings.Join(words, " "), tags, bankSearchDefaultLimit)
+	ids := make([]int, len(res.Hits))
+	for i, h := range res.Hits {
+		ids[i] = h.ID
+	}
+	lastFindMutex.Lock()
+	lastFind = ids
+	lastFindMutex.Unlock()
+
+	if res.Total == 0 {
+		info.Println("Nothing in the bank matches")
+		return nil
+	}
+	for i, h := range res.Hits {
+		info.Printf("  #%-2d bank %-4d R%-2d %-8s ", i+1, h.ID, h.Round, h.Type)
+		if h.Field != "question" {
+			info.Printf("(%s) ", h.Field)
+		}
+		runes, at := []rune(h.Snippet), 0
+		for _, m := range h.Highlights {
+			info.Print(string(runes[at:m[0]]))
+			mark.Print(string(runes[m[0]:m[1]]))
+			at = m[1]
+		}
+		info.Println(string(runes[at:]))
+	}
+	info.Printf("%d of %d match(es) in %s; stage #n queues one, pick #n plays it next\n", len(res.Hits), res.Total, res.Took.Round(time.Microsecond))
+	return nil
+}
+
+// findResult resolves "#n" or "n" to the bank entry the last find listed
+// as number n.
+func findResult(arg string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil {
+		return 0, fmt.Errorf("expected a result number like #2")
+	}
+	lastFindMutex.Lock()
+	defer lastFindMutex.Unlock()
+	if len(lastFind) == 0 {
+		return 0, fmt.Errorf("no search results, run find first")
+	}
+	if n < 1 || n > len(lastFind) {
+		return 0, fmt.Errorf("the last find listed results #1 to #%d", len(lastFind))
+	}
+	return lastFind[n-1], nil
+}
+
+// handleStageCommand runs "stage #n" and "pick #n": queue a result of the
+// last find at the end, or so that it is the next question.
+func handleStageCommand(cmd string, args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: %s #<n>", cmd)
+	}
+	id, err := findResult(args[0])
+	if err != nil {
+		return err
+	}
+	position := 0
+	if cmd == "pick" {
+		list := listQueue()
+		position = len(list) + 1
+		for i, e := range list {
+			if !e.Asked {
+				position = i + 1
+				break
+			}
+		}
+	}
+	added, err := queueFromBank([]int{id}, origin)
+	if err != nil {
+		return err
+	}
+	checkRundown()
+	if position == 0 {
+		success.Printf("Bank entry #%d queued as #%d\n", id, added[0].ID)
+		return nil
+	}
+	if _, err := moveQueueEntry(added[0].ID, position, nil, origin); err != nil {
+		return fmt.Errorf("Bank entry #%d queued as #%d at the end: %v", id, added[0].ID, err)
+	}
+	success.Printf("Bank entry #%d queued as #%d, next up\n", id, added[0].ID)
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenizeSearch(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []searchToken
+	}{
+		{"Kto bol Štefánik?", []searchToken{{"kto", 0, 3}, {"bol", 4, 7}, {"stefanik", 8, 16}}},
+		{"ĽUDOVÍT Štúr, 1815", []searchToken{{"ludovit", 0, 7}, {"stur", 8, 12}, {"1815", 14, 18}}},
+		// A combining accent is part of the word it follows.
+		{"Café bar", []searchToken{{"cafe", 0, 5}, {"bar", 6, 9}}},
+		{"  -- ", nil},
+	} {
+		if got := tokenizeSearch(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %v, want %v", tc.in, got, tc.want)
+		}
+	}
+	if got := searchWords("ŠTEFÁNIK stefanik Štefánik"); !reflect.DeepEqual(got, []string{"stefanik"}) {
+		t.Errorf("searchWords: %v", got)
+	}
+}
+
+// installSearchBank makes entries the bank for a test.
+func installSearchBank(t *testing.T, entries []BankEntry) {
+	StartTestServer(t)
+	bankMutex.Lock()
+	installBank(entries)
+	bankMutex.Unlock()
+}
+
+var searchTestBank = []BankEntry{
+	{ID: 1, Question: "Ktorá rieka tečie cez Bratislavu?", Tags: []string{"Geografia"}},
+	{ID: 2, Question: "Hlavné mesto Slovenska?", Tags: []string{"Geografia"}, Notes: "Bratislava"},
+	{ID: 3, Question: "Koľko mostov má Bratislava?"},
+	{ID: 4, Question: "Mosty cez Dunaj", Tags: []string{"Bratislava"}},
+}
+
+func hitIDs(res BankSearchResult) []int {
+	ids := []int{}
+	for _, h := range res.Hits {
+		ids = append(ids, h.ID)
+	}
+	return ids
+}
+
+func TestSearchBankRanking(t *testing.T) {
+	installSearchBank(t, searchTestBank)
+	for _, tc := range []struct {
+		query string
+		tags  []string
+		limit int
+		total int
+		want  []int
+	}{
+		// The whole word in the question, then in a tag, then in the notes.
+		{"Bratislava", nil, 10, 3, []int{3, 4, 2}},
+		// Prefixes rank below whole words; ties go by ID.
+		{"brat", nil, 10, 4, []int{1, 3, 4, 2}},
+		{"BRÁT", nil, 10, 4, []int{1, 3, 4, 2}},
+		{"brat", nil, 2, 4, []int{1, 3}},
+		// Every word has to match.
+		{"most bratislava", nil, 10, 2, []int{3, 4}},
+		{"most slovensk", nil, 10, 0, []int{}},
+		{"brat", []string{"geografia"}, 10, 2, []int{1, 2}},
+		{"", []string{"Geografia"}, 10, 2, []int{1, 2}},
+		{"", []string{"geografia", "bratislava"}, 10, 0, []int{}},
+	} {
+		res := searchBank(tc.query, tc.tags, tc.limit)
+		if res.Total != tc.total || !reflect.DeepEqual(hitIDs(res), tc.want) {
+			t.Errorf("%q %v: total %d, hits %v, want %d, %v", tc.query, tc.tags, res.Total, hitIDs(res), tc.total, tc.want)
+		}
+	}
+}
+
+func TestSearchHitHighlights(t *testing.T) {
+	installSearchBank(t, searchTestBank)
+	res := searchBank("bratislava", nil, 10)
+	for _, want := range []struct {
+		field, snippet string
+		highlights     [][2]int
+	}{
+		{"question", "Koľko mostov má Bratislava?", [][2]int{{16, 26}}},
+		{"tags", "Bratislava", [][2]int{{0, 10}}},
+		{"notes", "Bratislava", [][2]int{{0, 10}}},
+	} {
+		if len(res.Hits) == 0 {
+			t.Fatal("ran out of hits")
+		}
+		h := res.Hits[0]
+		res.Hits = res.Hits[1:]
+		if h.Field != want.field || h.Snippet != want.snippet || !reflect.DeepEqual(h.Highlights, want.highlights) {
+			t.Errorf("#%d: %s %q %v, want %s %q %v", h.ID, h.Field, h.Snippet, h.Highlights, want.field, want.snippet, want.highlights)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	if got, marks := snippet("Krátka otázka", nil); got != "Krátka otázka" || marks == nil || len(marks) != 0 {
+		t.Errorf("short text: %q %v", got, marks)
+	}
+
+	text := strings.Repeat("a ", 100) + "cieľ" + strings.Repeat(" b", 100)
+	got, marks := snippet(text, [][2]int{{200, 204}, {400, 401}})
+	runes := []rune(got)
+	if len(runes) != bankSnippetChars+2 || !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") {
+		t.Fatalf("cut to %d characters: %q", len(runes), got)
+	}
+	// The mark past the cut is dropped and the other one moves along.
+	if len(marks) != 1 || string(runes[marks[0][0]:marks[0][1]]) != "cieľ" {
+		t.Errorf("marks %v in %q", marks, got)
+	}
+
+	// Near the end the snippet is the last characters, with nothing cut after.
+	got, marks = snippet(text, [][2]int{{403, 404}})
+	runes = []rune(got)
+	if len(runes) != bankSnippetChars+1 || strings.HasSuffix(got, "…") || string(runes[marks[0][0]:marks[0][1]]) != "b" {
+		t.Errorf("end: %q %v", got, marks)
+	}
+}
+
+func TestSearchIndexFollowsBank(t *testing.T) {
+	StartTestServer(t)
+	find := func(query string) []int { return hitIDs(searchBank(query, nil, 10)) }
+
+	e, err := createBankEntry(BankEntry{Question: "Ktorou krajinou tečie Dunaj?", TimeLeft: FlexDuration(30 * time.Second)}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := find("dunaj"); !reflect.DeepEqual(got, []int{e.ID}) {
+		t.Fatalf("after adding: %v", got)
+	}
+
+	e.Question = "Ktorou krajinou tečie Váh?"
+	if _, err := updateBankEntry(e.ID, e.Version, e, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if got := find("dunaj"); len(got) != 0 {
+		t.Errorf("after editing, the old text still matches: %v", got)
+	}
+	if got := find("vah"); !reflect.DeepEqual(got, []int{e.ID}) {
+		t.Errorf("after editing: %v", got)
+	}
+
+	if err := deleteBankEntry(e.ID, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if got := find("vah"); len(got) != 0 {
+		t.Errorf("after removing: %v", got)
+	}
+}
+
+func TestBankSearchEndpoint(t *testing.T) {
+	installSearchBank(t, searchTestBank)
+	s := testServer
+	var res BankSearchResult
+	q := url.Values{"q": {"brat"}, "tag": {"geografia"}, "limit": {"1"}}
+	if status := s.Do(t, http.MethodGet, "/bank/search?"+q.Encode(), nil, &res); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	if res.Total != 2 || !reflect.DeepEqual(hitIDs(res), []int{1}) {
+		t.Errorf("total %d, hits %v", res.Total, hitIDs(res))
+	}
+	for _, query := range []string{"", "q=%3F%3F", "q=brat&limit=0", "q=brat&limit=101", "q=brat&limit=x"} {
+		if status := s.Do(t, http.MethodGet, "/bank/search?"+query, nil, nil); status != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want 400", query, status)
+		}
+	}
+}
+
+// BenchmarkBankSearch searches a bank of a few thousand entries, about the
+// size of a season of quizzes.
+func BenchmarkBankSearch(b *testing.B) {
+	vocabulary := strings.Fields("Bratislava Košice Dunaj Tatry rieka hrad most mesto kráľ básnik Štúr " +
+		"Hviezdoslav Štefánik rok storočie vojna pieseň hora jazero futbal hokej olympiáda film kniha " +
+		"obraz maliar chémia prvok planéta hviezda zviera vták strom kvet jedlo syr víno")
+	r := rand.New(rand.NewSource(1))
+	text := func(words int) string {
+		var b strings.Builder
+		for i := 0; i < words; i++ {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(vocabulary[r.Intn(len(vocabulary))])
+		}
+		return b.String()
+	}
+	entries := make([]BankEntry, 2000)
+	for i := range entries {
+		entries[i] = BankEntry{
+			ID:       i + 1,
+			Question: text(8+r.Intn(12)) + "?",
+			Tags:     []string{vocabulary[r.Intn(len(vocabulary))]},
+			Options:  []AnswerOption{{Text: text(2)}, {Text: text(2)}, {Text: text(2)}},
+			Notes:    text(20),
+		}
+	}
+	bankMutex.Lock()
+	installBank(entries)
+	bankMutex.Unlock()
+	defer func() {
+		bankMutex.Lock()
+		installBank(nil)
+		bankMutex.Unlock()
+	}()
+
+	for _, query := range []string{"dunaj", "h", "most bratislava", "stefanik hrad kral"} {
+		b.Run(fmt.Sprintf("%q", query), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				searchBank(query, nil, bankSearchDefaultLimit)
+			}
+		})
+	}
+}
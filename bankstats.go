@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	calibrateMinAsked   = flag.Int("calibrate-min-asked", 3, "bank questions asked fewer times than this are skipped by bank calibrate")
+	calibrateThresholds = flag.String("calibrate-thresholds", "0.85,0.65,0.45,0.25", "accuracies down to which a bank question rates difficulty 1, 2, 3 and 4; below the last it rates 5")
+)
+
+// maxDifficulty is the hardest rating. A difficulty of 0 means unrated.
+const maxDifficulty = 5
+
+// BankStats is how a bank question did in archived sessions while its text
+// hashed to TextHash. An entry edited between shows starts a new one, so
+// answers to an old wording don't rate the new one.
+type BankStats struct {
+	TextHash string `json:"text_hash"`
+	Asked    int    `json:"asked"`
+	Answers  int    `json:"answers"`
+	// Judged counts the answers whose outcome is known, Correct those of
+	// them that were right.
+	Judged       int           `json:"judged"`
+	Correct      int           `json:"correct"`
+	TotalLatency time.Duration `json:"total_latency"`
+	LastAsked    time.Time     `json:"last_asked"`
+}
+
+func (s BankStats) accuracy() (float64, bool) {
+	if s.Judged == 0 {
+		return 0, false
+	}
+	return float64(s.Correct) / float64(s.Judged), true
+}
+
+func (s BankStats) avgLatency() time.Duration {
+	if s.Answers == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Answers)
+}
+
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:8])
+}
+
+// askedBankHash is the hash of bank entry id's text, recorded when a
+// question queued from it goes live.
+func askedBankHash(id int) string {
+	if id == 0 {
+		return ""
+	}
+	e, ok := findBankEntry(id)
+	if !ok {
+		return ""
+	}
+	return textHash(e.Question)
+}
+
+// currentBankStats returns the stats of e's text as it is now.
+func currentBankStats(e BankEntry) (BankStats, bool) {
+	hash := textHash(e.Question)
+	for _, s := range e.Stats {
+		if s.TextHash == hash {
+			return s, true
+		}
+	}
+	return BankStats{}, false
+}
+
+// answerOutcomes counts the answers to an archived question and how many of
+// them were right, see teamOutcomes.
+func answerOutcomes(rec *QuestionRecord) (judged, correct int) {
+	for _, ok := range teamOutcomes(rec) {
+		judged++
+		if ok {
+			correct++
+		}
+	}
+	return judged, correct
+}
+
+// teamOutcomes tells, for each team whose answer to an archived question
+// was judged, whether it was right: by the verdicts for a free-text
+// question, otherwise by whether the team scored on it. Answers still
+// waiting for review are not judged.
+func teamOutcomes(rec *QuestionRecord) map[string]bool {
+	out := map[string]bool{}
+	if len(rec.Verdicts) > 0 {
+		for _, v := range rec.Verdicts {
+			switch v.Status {
+			case verdictCorrect:
+				out[v.Team] = true
+			case verdictIncorrect:
+				out[v.Team] = false
+			}
+		}
+		return out
+	}
+	scored := map[string]int{}
+	for _, d := range rec.ScoreDeltas {
+		scored[d.Team] += d.Delta
+	}
+	for _, a := range rec.Answers {
+		out[a.Team] = scored[a.Team] > 0
+	}
+	return out
+}
+
+// recordBankStats adds the questions of a session being archived to the
+// stats of the bank entries they were queued from, matched by entry ID and
+// by the text hash recorded when each was asked. Entries deleted since are
+// skipped.
+func recordBankStats(asked []HistoryEntry, origin string) error {
+	bankMutex.Lock()
+	defer bankMutex.Unlock()
+	entries := append([]BankEntry{}, bank...)
+	index := map[int]int{}
+	for i, e := range entries {
+		index[e.ID] = i
+	}
+	copied := map[int]bool{}
+	counted := 0
+	for _, h := range asked {
+		i, ok := index[h.BankID]
+		if h.BankID == 0 || h.BankHash == "" || h.Record == nil || !ok {
+			continue
+		}
+		e := &entries[i]
+		if !copied[e.ID] {
+			// The old slice is still the bank's until the commit.
+			e.Stats = append([]BankStats{}, e.Stats...)
+			copied[e.ID] = true
+		}
+		var s *BankStats
+		for j := range e.Stats {
+			if e.Stats[j].TextHash == h.BankHash {
+				s = &e.Stats[j]
+			}
+		}
+		if s == nil {
+			e.Stats = append(e.Stats, BankStats{TextHash: h.BankHash})
+			s = &e.Stats[len(e.Stats)-1]
+		}
+		judged, correct := answerOutcomes(h.Record)
+		s.Asked++
+		s.Answers += len(h.Record.Answers)
+		s.Judged += judged
+		s.Correct += correct
+		for _, a := range h.Record.Answers {
+			s.TotalLatency += a.Latency
+		}
+		if h.StartedAt.After(s.LastAsked) {
+			s.LastAsked = h.StartedAt
+		}
+		counted++
+	}
+	if counted == 0 {
+		return nil
+	}
+	if err := commitBank(entries); err != nil {
+		return err
+	}
+	audit("bank_stats", origin, map[string]interface{}{"questions": counted, "entries": len(copied)})
+	return nil
+}
+
+// parseThresholds reads -calibrate-thresholds: one accuracy for each
+// difficulty below the hardest, easiest first.
+func parseThresholds(s string) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != maxDifficulty-1 {
+		return nil, fmt.Errorf("calibration needs %d thresholds, got %d", maxDifficulty-1, len(parts))
+	}
+	out := make([]float64, len(parts))
+	for i, p := range parts {
+		t, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || t < 0 || t > 1 {
+			return nil, fmt.Errorf("threshold %q must be an accuracy between 0 and 1", p)
+		}
+		if i > 0 && t >= out[i-1] {
+			return nil, fmt.Errorf("thresholds must go down from the easiest difficulty")
+		}
+		out[i] = t
+	}
+	return out, nil
+}
+
+// suggestDifficulty rates an accuracy: the first difficulty whose threshold
+// it reaches.
+func suggestDifficulty(accuracy float64, thresholds []float64) int {
+	for i, t := range thresholds {
+		if accuracy >= t {
+			return i + 1
+		}
+	}
+	return maxDifficulty
+}
+
+// Calibration is a suggested difficulty for one bank entry.
+type Calibration struct {
+	ID        int     `json:"id"`
+	Question  string  `json:"question"`
+	Asked     int     `json:"asked"`
+	Accuracy  float64 `json:"accuracy"`
+	Current   int     `json:"current"`
+	Suggested int     `json:"suggested"`
+}
+
+// calibrateBank rates every entry asked at least minAsked times with its
+// current text and returns the ratings that differ from the stored ones,
+// along with how many entries were skipped. With apply the changes are
+// written in one edit.
+func calibrateBank(minAsked int, apply bool, origin string) ([]Calibration, int, error) {
+	thresholds, err := parseThresholds(*calibrateThresholds)
+	if err != nil {
+		return nil, 0, err
+	}
+	bankMutex.Lock()
+	defer bankMutex.Unlock()
+	var changes []Calibration
+	skipped := 0
+	entries := append([]BankEntry{}, bank...)
+	now := clock.Now()
+	for i, e := range entries {
+		s, ok := currentBankStats(e)
+		accuracy, judged := s.accuracy()
+		if !ok || s.Asked < minAsked || !judged {
+			skipped++
+			continue
+		}
+		suggested := suggestDifficulty(accuracy, thresholds)
+		if suggested == e.Difficulty {
+			continue
+		}
+		changes = append(changes, Calibration{ID: e.ID, Question: e.Question, Asked: s.Asked, Accuracy: accuracy, Current: e.Difficulty, Suggested: suggested})
+		entries[i].Difficulty = suggested
+		entries[i].Version++
+		entries[i].UpdatedAt = now
+	}
+	if !apply || len(changes) == 0 {
+		return changes, skipped, nil
+	}
+	if err := commitBank(entries); err != nil {
+		return nil, skipped, err
+	}
+	audit("bank_calibrate", origin, map[string]interface{}{"changed": len(changes), "min_asked": minAsked})
+	return changes, skipped, nil
+}
+
+// BankStatsSummary is one BankStats with its averages worked out. Accuracy
+// is null until an answer was judged.
+type BankStatsSummary struct {
+	BankStats
+	Accuracy   *float64      `json:"accuracy"`
+	AvgLatency time.Duration `json:"avg_latency"`
+}
+
+func summarizeBankStats(s BankStats) BankStatsSummary {
+	sum := BankStatsSummary{BankStats: s, AvgLatency: s.avgLatency()}
+	if accuracy, ok := s.accuracy(); ok {
+		sum.Accuracy = &accuracy
+	}
+	return sum
+}
+
+// BankStatsReport is the body of GET /bank/:id/stats. Current covers the
+// entry's text as it is now and Earlier the wordings it had before.
+// Suggested is what bank calibrate would rate it, once it has been asked
+// often enough.
+type BankStatsReport struct {
+	ID         int                `json:"id"`
+	TextHash   string             `json:"text_hash"`
+	Difficulty int                `json:"difficulty"`
+	Suggested  int                `json:"suggested_difficulty,omitempty"`
+	Current    *BankStatsSummary  `json:"current"`
+	Earlier    []BankStatsSummary `json:"earlier"`
+}
+
+func bankStatsReport(e BankEntry) BankStatsReport {
+	r := BankStatsReport{ID: e.ID, TextHash: textHash(e.Question), Difficulty: e.Difficulty, Earlier: []BankStatsSummary{}}
+	for _, s := range e.Stats {
+		if s.TextHash != r.TextHash {
+			r.Earlier = append(r.Earlier, summarizeBankStats(s))
+			continue
+		}
+		sum := summarizeBankStats(s)
+		r.Current = &sum
+		thresholds, err := parseThresholds(*calibrateThresholds)
+		if sum.Accuracy != nil && s.Asked >= *calibrateMinAsked && err == nil {
+			r.Suggested = suggestDifficulty(*sum.Accuracy, thresholds)
+		}
+	}
+	return r
+}
+
+func describeAccuracy(s BankStats) string {
+	accuracy, ok := s.accuracy()
+	if !ok {
+		return "no judged answers"
+	}
+	return fmt.Sprintf("%.0f%% of %d judged answers correct, answered after %.1fs on average", accuracy*100, s.Judged, s.avgLatency().Seconds())
+}
+
+// queueFromBank appends bank entries to the queue, remembering which entry
+// each came from so its stats are collected when the session is archived.
+// An entry of a group brings the whole group along.
+func queueFromBank(ids []int, origin string) ([]QueueEntry, error) {
+	ids = withBankGroups(ids)
+	entries := make([]QueueEntry, 0, len(ids))
+	for _, id := range ids {
+		e, ok := findBankEntry(id)
+		if !ok {
+			return nil, errBankEntryNotFound
+		}
+		entries = append(entries, e.queueEntry())
+	}
+	return enqueue(entries, origin)
+}
+
+func getBankStats(c echo.Context) error {
+	id, err := bankID(c)
+	if err != nil {
+		return err
+	}
+	e, ok := findBankEntry(id)
+	if !ok {
+		return notFound(errBankEntryNotFound.Error())
+	}
+	return c.JSON(http.StatusOK, bankStatsReport(e))
+}
+
+func postBankQueue(c echo.Context) error {
+	id, err := bankID(c)
+	if err != nil {
+		return err
+	}
+	added, err := queueFromBank([]int{id}, requestOrigin(c))
+	if err != nil {
+		if err == errBankEntryNotFound {
+			return notFound(err.Error())
+		}
+		return badRequest(err.Error())
+	}
+	checkRundown()
+	return c.JSON(http.StatusOK, added)
+}
+
+// handleCalibrateCommand runs "bank calibrate [--apply] [--min n]".
+func handleCalibrateCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	apply := false
+	minAsked := *calibrateMinAsked
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--apply":
+			apply = true
+		case args[i] == "--min" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 {
+				return errors.New("--min must be a positive number of times asked")
+			}
+			minAsked = n
+			i++
+		default:
+			return errors.New("Usage: bank calibrate [--apply] [--min n]")
+		}
+	}
+
+	changes, skipped, err := calibrateBank(minAsked, apply, origin)
+	if err != nil {
+		return err
+	}
+	rating := func(d int) string {
+		if d == 0 {
+			return "-"
+		}
+		return strconv.Itoa(d)
+	}
+	for _, c := range changes {
+		info.Printf("  #%-4d asked %-3d %3.0f%% correct  %s -> %d  %s\n", c.ID, c.Asked, c.Accuracy*100, rating(c.Current), c.Suggested, c.Question)
+	}
+	if skipped > 0 {
+		info.Printf("%d entr(ies) skipped, asked fewer than %d times or never judged\n", skipped, minAsked)
+	}
+	switch {
+	case len(changes) == 0:
+		info.Println("Every rated entry matches its accuracy")
+	case apply:
+		success.Printf("Difficulty updated for %d entr(ies)\n", len(changes))
+	default:
+		info.Printf("%d change(s) suggested, bank calibrate --apply writes them\n", len(changes))
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// /v2 decodes request bodies strictly: a field the request doesn't have,
+// such as a misspelt time_left, is refused instead of being dropped and
+// leaving its value at zero. /v1 and the legacy paths decode as they always
+// have, through Echo's binder, which ignores unknown fields and words its
+// errors its own way; deployed clients send fields like start_time that
+// the request types no longer have. Every handler reads its body with
+// bindJSON, or bindOptionalJSON when the body may be left out, and turns
+// the error into a response with bindError.
+
+// errEmptyBody is a request without the body it needs.
+var errEmptyBody = errors.New("the request body is empty, expected JSON")
+
+// UnknownFieldsError lists the fields of a body that its request doesn't
+// have, as dotted paths like "scoring.points" or "[1].time_left".
+type UnknownFieldsError struct {
+	Fields []string
+}
+
+func (e *UnknownFieldsError) Error() string {
+	return "unknown field(s): " + strings.Join(e.Fields, ", ")
+}
+
+// bindJSON decodes the JSON body of c into v. On /v2 an empty body,
+// unknown fields and values of the wrong type are refused.
+func bindJSON(c echo.Context, v interface{}) error {
+	body, err := readJSONBody(c)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 && apiVersion(c) >= apiV2 {
+		return errEmptyBody
+	}
+	return decodeBody(c, body, v)
+}
+
+// bindOptionalJSON is bindJSON for a body that may be left out, in which
+// case v is left as it is.
+func bindOptionalJSON(c echo.Context, v interface{}) error {
+	body, err := readJSONBody(c)
+	if err != nil || len(bytes.TrimSpace(body)) == 0 {
+		return err
+	}
+	return decodeBody(c, body, v)
+}
+
+// decodeBody decodes body, read from c, into v the way c's API version
+// does.
+func decodeBody(c echo.Context, body []byte, v interface{}) error {
+	if apiVersion(c) < apiV2 {
+		return decodeV1(c, body, v)
+	}
+	return decodeStrict(body, v)
+}
+
+// readJSONBody reads the body of c, which must be JSON when there is one.
+func readJSONBody(c echo.Context) ([]byte, error) {
+	req := c.Request()
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(body)) > 0 && !strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		return nil, echo.ErrUnsupportedMediaType
+	}
+	return body, nil
+}
+
+// decodeV1 decodes body into v the way Echo's binder does: an empty body
+// leaves v as it is, unknown fields are ignored, and errors read as Echo's
+// "Unmarshal type error: ..." and "Syntax error: ...". Durations are
+// nanoseconds, see v1Durations.
+func decodeV1(c echo.Context, body []byte, v interface{}) error {
+	if len(body) == 0 {
+		return nil
+	}
+	blanked, durations, err := v1Durations(body, reflect.TypeOf(v))
+	if err != nil {
+		var terr *json.UnmarshalTypeError
+		errors.As(err, &terr)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Unmarshal type error: expected=%v, got=%v, field=%v, offset=%v",
+			terr.Type, terr.Value, terr.Field, terr.Offset)).SetInternal(err)
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(blanked))
+	err = c.Echo().JSONSerializer.Deserialize(c, v)
+	var herr *echo.HTTPError
+	if err != nil && !errors.As(err, &herr) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+	}
+	if err != nil {
+		return err
+	}
+	setV1Durations(reflect.ValueOf(v), durations)
+	return nil
+}
+
+// decodeStrict unmarshals body into v after checking it has no unknown
+// fields.
+func decodeStrict(body []byte, v interface{}) error {
+	if !json.Valid(body) {
+		// Unmarshal says where.
+		return json.Unmarshal(body, v)
+	}
+	if fields := unknownFields(body, reflect.TypeOf(v), ""); len(fields) > 0 {
+		return &UnknownFieldsError{Fields: fields}
+	}
+	return json.Unmarshal(body, v)
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// unknownFields lists the object keys in raw that t has no field for, at
+// any depth, under path. Types that unmarshal themselves are not looked
+// into.
+func unknownFields(raw []byte, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Implements(unmarshalerType) || reflect.PointerTo(t).Implements(unmarshalerType) {
+		return nil
+	}
+	var out []string
+	switch t.Kind() {
+	case reflect.Struct:
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(raw, &obj) != nil {
+			return nil
+		}
+		known := jsonFields(t)
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			f, ok := lookupField(known, k)
+			if !ok {
+				out = append(out, joinFieldPath(path, k))
+				continue
+			}
+			out = append(out, unknownFields(obj[k], f.Type, joinFieldPath(path, k))...)
+		}
+	case reflect.Map:
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(raw, &obj) != nil {
+			return nil
+		}
+		for k, v := range obj {
+			out = append(out, unknownFields(v, t.Elem(), joinFieldPath(path, k))...)
+		}
+		sort.Strings(out)
+	case reflect.Slice, reflect.Array:
+		var arr []json.RawMessage
+		if json.Unmarshal(raw, &arr) != nil {
+			return nil
+		}
+		for i, v := range arr {
+			out = append(out, unknownFields(v, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return out
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// jsonFields maps the JSON names of the fields of struct type t, those of
+// embedded structs included, to the fields. Index is the path from t.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	out := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		ft := sf.Type
+		if sf.Anonymous && name == "" {
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for k, f := range jsonFields(ft) {
+					if _, ok := out[k]; !ok {
+						f.Index = append([]int{i}, f.Index...)
+						out[k] = f
+					}
+				}
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		out[name] = sf
+	}
+	return out
+}
+
+// lookupField finds key among known the way encoding/json does, an exact
+// match first and then one ignoring case.
+func lookupField(known map[string]reflect.StructField, key string) (reflect.StructField, bool) {
+	if f, ok := known[key]; ok {
+		return f, true
+	}
+	for name, f := range known {
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// jsonKind says what JSON value t is decoded from.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return t.String()
+}
+
+// bindError turns a failed bind into an API error: on /v2 unknown fields
+// and values of the wrong type are listed by field, and durations get
+// their own code everywhere.
+func bindError(err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	var derr *DurationError
+	if errors.As(err, &derr) {
+		return apiError(http.StatusBadRequest, "invalid_duration", derr.Error()).withDetail("value", derr.Value)
+	}
+	// Echo's errors from the /v1 decoder keep its wording, and a body that
+	// isn't JSON its 415.
+	var herr *echo.HTTPError
+	if errors.As(err, &herr) {
+		if herr.Code == http.StatusUnsupportedMediaType {
+			return apiError(herr.Code, "unsupported_media", fmt.Sprint(herr.Message))
+		}
+		return apiError(http.StatusBadRequest, "invalid_body", fmt.Sprint(herr.Message))
+	}
+	var uerr *UnknownFieldsError
+	if errors.As(err, &uerr) {
+		fields := make([]FieldError, len(uerr.Fields))
+		for i, f := range uerr.Fields {
+			fields[i] = FieldError{Field: f, Code: "unknown_field", Message: "not a field of this request"}
+		}
+		return apiError(http.StatusBadRequest, "unknown_fields", uerr.Error()).withDetail("fields", fields)
+	}
+	var terr *json.UnmarshalTypeError
+	if errors.As(err, &terr) {
+		field := terr.Field
+		if field == "" {
+			field = "body"
+		}
+		msg := fmt.Sprintf("expected %s, got %s", jsonKind(terr.Type), terr.Value)
+		return apiError(http.StatusBadRequest, "invalid_field", field+": "+msg).
+			withDetail("fields", []FieldError{{Field: field, Code: "wrong_type", Message: msg}})
+	}
+	var serr *json.SyntaxError
+	if errors.As(err, &serr) {
+		return apiError(http.StatusBadRequest, "invalid_body", fmt.Sprintf("malformed JSON at offset %d: %v", serr.Offset, serr))
+	}
+	if errors.Is(err, errEmptyBody) {
+		return apiError(http.StatusBadRequest, "empty_body", err.Error())
+	}
+	return apiError(http.StatusBadRequest, "invalid_body", err.Error())
+}
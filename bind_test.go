@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnknownFields(t *testing.T) {
+	for _, tc := range []struct {
+		body string
+		v    interface{}
+		want string
+	}{
+		{`{"question":"?","time_left_seconds":30}`, QuestionRequest{}, "[time_left_seconds]"},
+		{`{"Question":"?","TYPE":"pomoc"}`, QuestionRequest{}, "[]"},
+		{`[{"question":"?"},{"question":"?","tiem":3}]`, []QueueEntryRequest{}, "[[1].tiem]"},
+		{`{"question":"?","round":1,"scoring":{"points":1,"bonus":2}}`, QueueEntryRequest{}, "[scoring.bonus]"},
+		{`{"time_left":"8s","difficulty":2,"version":1,"z":0,"a":0}`, BankEntryRequest{}, "[a z]"},
+	} {
+		if got := fmt.Sprint(unknownFields([]byte(tc.body), reflect.TypeOf(tc.v), "")); got != tc.want {
+			t.Errorf("%s: %s, want %s", tc.body, got, tc.want)
+		}
+	}
+}
+
+// TestStrictBodies sends bodies with mistakes to /v2, which refuses them
+// field by field, and to the legacy path, which lets unknown fields by.
+func TestStrictBodies(t *testing.T) {
+	s := StartTestServer(t)
+	request := func(path, contentType, body string) *http.Request {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, s.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-API-Key", testKey)
+		req.Header.Set("Content-Type", contentType)
+		return req
+	}
+	send := func(path, body string) (int, APIError) {
+		t.Helper()
+		var res struct {
+			Error APIError `json:"error"`
+		}
+		status := s.Send(t, request(path, "application/json", body), &res).StatusCode
+		return status, res.Error
+	}
+	fields := func(e APIError) string {
+		var out []string
+		list, _ := e.Details["fields"].([]interface{})
+		for _, f := range list {
+			m, _ := f.(map[string]interface{})
+			out = append(out, fmt.Sprint(m["field"], ":", m["code"]))
+		}
+		return strings.Join(out, ",")
+	}
+	for _, tc := range []struct {
+		path, body string
+		code       string
+		fields     string
+	}{
+		{"/v2/set-question", `{"question":"?","type":"pomoc","time_left_seconds":30}`, "unknown_fields", "time_left_seconds:unknown_field"},
+		{"/v2/queue", `[{"question":"A"},{"question":"B","tiem":3}]`, "unknown_fields", "[1].tiem:unknown_field"},
+		{"/v2/set-question", `{"question":7,"type":"pomoc","time_left":30}`, "invalid_field", "question:wrong_type"},
+		{"/v2/set-question", ``, "empty_body", ""},
+		{"/v2/set-question", `{"question":`, "invalid_body", ""},
+	} {
+		status, e := send(tc.path, tc.body)
+		if status != http.StatusBadRequest || e.Code != tc.code || fields(e) != tc.fields {
+			t.Errorf("%s %s: status %d, %+v", tc.path, tc.body, status, e)
+		}
+	}
+	for _, path := range []string{"/v2/set-question", "/set-question"} {
+		if res := s.Send(t, request(path, "text/plain", `{"question":"?"}`), nil); res.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("a text body to %s: status %d", path, res.StatusCode)
+		}
+	}
+
+	// The legacy path drops what it doesn't know, and pause needs no body.
+	if status, e := send("/set-question", `{"question":"Hlavné mesto?","type":"pomoc","time_left":30000000000,"start_time":"2024-01-01T00:00:00Z"}`); status != http.StatusOK {
+		t.Errorf("legacy unknown field: status %d, %+v", status, e)
+	}
+	if status, e := send("/v2/pause", ``); status != http.StatusOK {
+		t.Errorf("pause without a body: status %d, %+v", status, e)
+	}
+}
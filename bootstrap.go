@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Bootstrap is everything a device that joins late needs in one document,
+// read from one snapshot of the state: the public question as of
+// ServerTime, the scoreboard, the ceremony while it runs and, for a
+// captain's token, the own team. Events carries on from Revision; the
+// stream skips state it already has.
+type Bootstrap struct {
+	Question   PublicQuestionView `json:"question"`
+	Phase      string             `json:"phase"`
+	Scoreboard Scoreboard         `json:"scoreboard"`
+	Ceremony   *CeremonyView      `json:"ceremony,omitempty"`
+	Team       *KioskTeam         `json:"team,omitempty"`
+	// TokenRejected is set when the captain token presented is not known,
+	// as after a restart; the device has to log in again.
+	TokenRejected bool      `json:"token_rejected,omitempty"`
+	ServerTime    time.Time `json:"server_time"`
+	Revision      uint64    `json:"revision"`
+	EventsURL     string    `json:"events_url"`
+}
+
+// buildBootstrap composes the document for lang, with team's part when
+// team is not nil. The question lock is held throughout, so no state
+// change lands between the pieces; the scoreboard has no revision of its
+// own, so the team's score is taken from it.
+func buildBootstrap(lang string, team *Team) Bootstrap {
+	questionMutex.RLock()
+	defer questionMutex.RUnlock()
+	q := localizedQuestion(publicQuestion(question), lang)
+	b := Bootstrap{
+		Question:   q,
+		Phase:      q.Phase,
+		Scoreboard: currentScoreboard(),
+		Ceremony:   q.Ceremony,
+		ServerTime: clock.Now(),
+		Revision:   revision,
+		EventsURL:  fmt.Sprintf("/events?catch_up=true&since=%d", revision),
+	}
+	if team != nil {
+		t := kioskTeam(*team)
+		for _, e := range b.Scoreboard.Entries {
+			if e.Name == t.Name {
+				t.Score = e.Score
+			}
+		}
+		b.Team = &t
+	}
+	return b
+}
+
+// encodeBootstrap is the document without a team, for the payload cache.
+func encodeBootstrap(lang string, version int) ([]byte, uint64, error) {
+	b := buildBootstrap(lang, nil)
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(wireValue(b, version)); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), b.Revision, nil
+}
+
+// getBootstrap serves GET /bootstrap. The anonymous document comes from the
+// payload cache like /get-question, so a room of phones joining at once
+// costs one build per revision; only captains with a token get their own.
+func getBootstrap(c echo.Context) error {
+	lang := c.QueryParam("lang")
+	token := c.Request().Header.Get(captainTokenHeader)
+	if token == "" {
+		body, err := cachedPoll(pollCacheKey{version: apiVersion(c), bootstrap: true}, lang, encodeBootstrap)
+		if err != nil {
+			return err
+		}
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, body)
+	}
+	t, ok := captainTokenTeam(token)
+	if !ok {
+		b := buildBootstrap(lang, nil)
+		b.TokenRejected = true
+		return c.JSON(http.StatusOK, b)
+	}
+	b := buildBootstrap(lang, &t)
+	return c.JSON(http.StatusOK, b)
+}
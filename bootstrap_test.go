@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestBootstrap joins late as an audience phone, as a captain and with a
+// stale token, then resumes the event stream from the document.
+func TestBootstrap(t *testing.T) {
+	s := StartTestServer(t)
+	defer forgetTeamPIN("Sovy")
+	for _, name := range []string{"Sovy", "Líšky"} {
+		if err := addTeam(Team{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setTeamPIN("Sovy", "1234", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := adjustScore("Sovy", 7, causeManual, "test"); err != nil {
+		t.Fatal(err)
+	}
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Hlavné mesto?", "type": "pomoc", "time_left": 30_000_000_000})
+
+	bootstrap := func(token string) Bootstrap {
+		t.Helper()
+		req := s.NewRequest(t, http.MethodGet, "/bootstrap", nil)
+		req.Header.Del("X-API-Key")
+		if token != "" {
+			req.Header.Set(captainTokenHeader, token)
+		}
+		var b Bootstrap
+		if status := s.Send(t, req, &b).StatusCode; status != http.StatusOK {
+			t.Fatalf("bootstrap: status %d", status)
+		}
+		return b
+	}
+	b := bootstrap("")
+	questionMutex.RLock()
+	rev := revision
+	questionMutex.RUnlock()
+	if b.Question.Question != "Hlavné mesto?" || b.Phase != b.Question.Phase || b.Revision != rev || !b.ServerTime.Equal(clock.Now()) {
+		t.Errorf("document %+v", b)
+	}
+	if len(b.Scoreboard.Entries) != 2 || b.Team != nil || b.TokenRejected {
+		t.Errorf("anonymous: scoreboard %+v, team %+v", b.Scoreboard.Entries, b.Team)
+	}
+	s.MustDo(t, http.MethodPost, "/pause", map[string]string{"reason": "tech"})
+	if again := bootstrap(""); again.Revision == b.Revision || !again.Question.Paused {
+		t.Errorf("after a pause: revision %d, paused %v", again.Revision, again.Question.Paused)
+	}
+
+	var login struct {
+		Token string `json:"token"`
+	}
+	req := s.NewRequest(t, http.MethodPost, "/v2/teams/Sovy/login", map[string]string{"pin": "1234"})
+	req.Header.Del("X-API-Key")
+	if status := s.Send(t, req, &login).StatusCode; status != http.StatusOK {
+		t.Fatalf("login: status %d", status)
+	}
+	if b := bootstrap(login.Token); b.Team == nil || b.Team.Name != "Sovy" || b.Team.Score != 7 || b.TokenRejected {
+		t.Errorf("captain: team %+v, rejected %v", b.Team, b.TokenRejected)
+	}
+	if b := bootstrap("stará"); b.Team != nil || !b.TokenRejected {
+		t.Errorf("a stale token: team %+v, rejected %v", b.Team, b.TokenRejected)
+	}
+
+	// The stream resumed from the document leaves out the state it had.
+	if status := s.Do(t, http.MethodGet, "/events?since=x", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("since=x: status %d", status)
+	}
+	b = bootstrap("")
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+strings.Replace(b.EventsURL, "?", "?types=state,test_resume&", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("events: status %d", resp.StatusCode)
+	}
+	hub.broadcast(Event{Type: "test_resume"})
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "event: test_resume\n" {
+		t.Errorf("read %q, %v", line, err)
+	}
+}
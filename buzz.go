@@ -0,0 +1,553 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// Answer is a team's latest submission for the live question.
+type Answer struct {
+	Team   string    `json:"team"`
+	Answer string    `json:"answer"`
+	Time   time.Time `json:"time"`
+	// Latency is how far into the answer time, pauses excluded, the answer
+	// came. Remaining is what was left of the countdown then.
+	Latency   time.Duration `json:"latency"`
+	Remaining time.Duration `json:"remaining"`
+	// LockedAt is when the team locked this answer in as final.
+	LockedAt *time.Time `json:"locked_at,omitempty"`
+}
+
+// RoundView is the public per-question interaction state.
+type RoundView struct {
+	BuzzWinner  string     `json:"buzz_winner,omitempty"`
+	Eliminated  []string   `json:"eliminated"`
+	FloorClosed *time.Time `json:"floor_closed,omitempty"`
+	// WindowClosed is set when the answer window, not the operator, closed
+	// the floor.
+	WindowClosed bool `json:"window_closed,omitempty"`
+	// Locked lists the teams that have locked in their answer.
+	Locked []string `json:"locked,omitempty"`
+	// RemovedOptions are the options the 50:50 took away, numbered from 1;
+	// see options.go.
+	RemovedOptions []int `json:"removed_options,omitempty"`
+}
+
+// RoundError is a rejected buzz, answer or elimination. Code is a stable
+// identifier for clients.
+type RoundError struct {
+	Code    string
+	Message string
+}
+
+func (e *RoundError) Error() string {
+	return e.Message
+}
+
+// Per-question state. It is cleared whenever a new question starts. Never
+// take questionMutex while holding roundMutex: publicQuestion takes them in
+// the other order.
+var (
+	roundMutex sync.Mutex
+	buzzWinner string
+	buzzOrder  []string
+	eliminated []string
+	answers    = map[string]Answer{}
+	// floorClosed is when answers stopped being accepted, if they have.
+	floorClosed *time.Time
+	// answeringAt is when the answer time began, after any reading, and
+	// answerPaused how long it has been paused since.
+	answeringAt  time.Time
+	answerPaused time.Duration
+)
+
+// resetRound forgets buzzes, answers and eliminations from the last question,
+// after freezing them into its history record.
+func resetRound() {
+	finalizeQuestion("advanced")
+	roundMutex.Lock()
+	defer roundMutex.Unlock()
+	buzzWinner = ""
+	buzzOrder = nil
+	eliminated = nil
+	answers = map[string]Answer{}
+	adjudications = map[string]Verdict{}
+	answeringAt = time.Time{}
+	answerPaused = 0
+	floorClosed = nil
+	optionsRevealed = nil
+	removedOptions = nil
+	windowClosed = nil
+	windowReopened = false
+}
+
+func isEliminated(name string) bool {
+	for _, n := range eliminated {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// publicRound returns the round state for the public payload, or nil when
+// nothing has happened yet.
+func publicRound() *RoundView {
+	roundMutex.Lock()
+	defer roundMutex.Unlock()
+	locked := lockedTeams()
+	if buzzWinner == "" && len(eliminated) == 0 && floorClosed == nil && len(locked) == 0 && len(removedOptions) == 0 {
+		return nil
+	}
+	return &RoundView{BuzzWinner: buzzWinner, Eliminated: append([]string{}, eliminated...), FloorClosed: floorClosed, WindowClosed: windowClosed != nil && !windowReopened, Locked: locked, RemovedOptions: append([]int(nil), removedOptions...)}
+}
+
+// roundTeam resolves name to a registered team that may still play the
+// live question.
+func roundTeam(name string) (Team, error) {
+	if !questionInProgress() {
+		return Team{}, &RoundError{Code: "no_question", Message: "no question is running"}
+	}
+	if readingPhase() {
+		return Team{}, &RoundError{Code: "reading", Message: "answers open once the reading time is over"}
+	}
+	t, ok := findTeam(name)
+	if !ok {
+		return Team{}, &RoundError{Code: "unknown_team", Message: "unknown team: " + name}
+	}
+	return t, nil
+}
+
+// roundChanged publishes a change of the per-question state.
+func roundChanged(kind string) {
+	questionMutex.Lock()
+	stateChanged(kind)
+	questionMutex.Unlock()
+}
+
+// buzz claims the buzzer for a team. The first buzz wins and locks everyone
+// else out until the winner is eliminated or the buzzer is reset.
+func buzz(name, origin string) (Team, error) {
+	t, err := roundTeam(name)
+	if err != nil {
+		return t, err
+	}
+	if answerWindowPassed(clock.Now()) {
+		return t, errWindowClosed
+	}
+	roundMutex.Lock()
+	switch {
+	case isEliminated(t.Name):
+		roundMutex.Unlock()
+		return t, &RoundError{Code: "eliminated", Message: t.Name + " is eliminated for this question"}
+	case buzzWinner != "":
+		winner := buzzWinner
+		roundMutex.Unlock()
+		return t, &RoundError{Code: "buzz_locked", Message: winner + " buzzed first"}
+	}
+	buzzWinner = t.Name
+	buzzOrder = append(buzzOrder, t.Name)
+	roundMutex.Unlock()
+
+	audit("buzz", origin, map[string]interface{}{"team": t.Name})
+	roundChanged("buzz")
+	return t, nil
+}
+
+func resetBuzzer(origin string) {
+	roundMutex.Lock()
+	buzzWinner = ""
+	roundMutex.Unlock()
+	audit("buzz_reset", origin, nil)
+	roundChanged("buzz_reset")
+}
+
+func submitAnswer(name, text, origin string) (Answer, error) {
+	t, err := roundTeam(name)
+	if err != nil {
+		return Answer{}, err
+	}
+	now := clock.Now()
+	if answerWindowPassed(now) {
+		return Answer{}, errWindowClosed
+	}
+	questionMutex.RLock()
+	paused, since := question.Paused, pausedAt
+	var remaining time.Duration
+	if !question.CountUp {
+		if remaining = timeRemaining(question, now); remaining < 0 {
+			remaining = 0
+		}
+	}
+	_, hidden := optionsHiddenFor(question, now)
+	options := question.Options
+	questionMutex.RUnlock()
+	// An option's letter or number before the options are shown would only
+	// be a guess at their order.
+	if hidden && optionPosition(options, text) >= 0 {
+		return Answer{}, errOptionsHidden
+	}
+
+	roundMutex.Lock()
+	if floorClosed != nil {
+		roundMutex.Unlock()
+		return Answer{}, &RoundError{Code: "floor_closed", Message: "answers are closed for this question"}
+	}
+	if isEliminated(t.Name) {
+		roundMutex.Unlock()
+		return Answer{}, &RoundError{Code: "eliminated", Message: t.Name + " is eliminated for this question"}
+	}
+	if i := optionIndex(options, text); i >= 0 && isRemovedOption(i) {
+		roundMutex.Unlock()
+		return Answer{}, errOptionRemoved
+	}
+	if prev, ok := answers[t.Name]; ok && prev.LockedAt != nil {
+		roundMutex.Unlock()
+		return Answer{}, &RoundError{Code: "answer_locked", Message: t.Name + " has locked in its answer"}
+	}
+	a := Answer{Team: t.Name, Answer: text, Time: now, Latency: answerLatency(now, paused, since), Remaining: remaining}
+	answers[t.Name] = a
+	everyone := allAnswersIn()
+	roundMutex.Unlock()
+
+	audit("answer", origin, map[string]interface{}{"team": t.Name, "answer": text})
+	// Answers stay private to operators; kiosks only learn that one arrived.
+	hub.broadcastOperator(Event{Type: "answer", Data: a})
+	if everyone {
+		closeFloor(false, "auto")
+	}
+	return a, nil
+}
+
+// allAnswersIn reports whether every team still in has answered, and
+// locked in when -unlocked-fraction makes locking count, so the floor can
+// close. roundMutex must be held.
+func allAnswersIn() bool {
+	for _, team := range listTeams() {
+		if isEliminated(team.Name) {
+			continue
+		}
+		a, ok := answers[team.Name]
+		if !ok || (*unlockedFraction < 1 && a.LockedAt == nil) {
+			return false
+		}
+	}
+	return true
+}
+
+// startAnswerClock marks the start of the answer time. It is called with
+// questionMutex held when a question goes live without reading time and
+// when reading ends.
+func startAnswerClock(at time.Time) {
+	roundMutex.Lock()
+	defer roundMutex.Unlock()
+	answeringAt = at
+	answerPaused = 0
+}
+
+// answerClockResumed adds a pause that just ended to the answer time's paused total.
+func answerClockResumed(pausedSince, now time.Time) {
+	roundMutex.Lock()
+	defer roundMutex.Unlock()
+	if answeringAt.IsZero() {
+		return
+	}
+	if pausedSince.Before(answeringAt) {
+		pausedSince = answeringAt
+	}
+	answerPaused += now.Sub(pausedSince)
+}
+
+// answerLatency is the pause-adjusted time since the answer time began,
+// counting a pause still in progress. roundMutex must be held.
+func answerLatency(now time.Time, paused bool, pausedSince time.Time) time.Duration {
+	if answeringAt.IsZero() {
+		return 0
+	}
+	latency := now.Sub(answeringAt) - answerPaused
+	if paused {
+		if pausedSince.Before(answeringAt) {
+			pausedSince = answeringAt
+		}
+		latency -= now.Sub(pausedSince)
+	}
+	if latency < 0 {
+		latency = 0
+	}
+	return latency
+}
+
+// closeFloor stops accepting answers for the live question. With stop the
+// countdown is paused too; otherwise it keeps running for the drama.
+func closeFloor(stop bool, origin string) error {
+	if !questionInProgress() {
+		return &RoundError{Code: "no_question", Message: "no question is running"}
+	}
+	roundMutex.Lock()
+	if floorClosed != nil {
+		roundMutex.Unlock()
+		return &RoundError{Code: "floor_closed", Message: "answers are already closed"}
+	}
+	now := clock.Now()
+	floorClosed = &now
+	roundMutex.Unlock()
+
+	audit("floor_close", origin, map[string]interface{}{"stop": stop})
+	hub.broadcast(Event{Type: "floor_closed", Data: map[string]interface{}{"time": now, "origin": origin}})
+	if stop {
+		// Already paused is fine: the countdown is frozen either way.
+		pauseQuestion("floor_closed", "", origin)
+	}
+	roundChanged("floor_close")
+	go sendCurrentQuestion()
+	return nil
+}
+
+// openFloor accepts answers again, as long as the question hasn't ended.
+func openFloor(origin string) error {
+	if !questionInProgress() {
+		return &RoundError{Code: "no_question", Message: "the question has ended"}
+	}
+	roundMutex.Lock()
+	if floorClosed == nil {
+		roundMutex.Unlock()
+		return &RoundError{Code: "floor_open", Message: "answers are already open"}
+	}
+	floorClosed = nil
+	windowReopened = windowClosed != nil
+	roundMutex.Unlock()
+
+	audit("floor_open", origin, nil)
+	hub.broadcast(Event{Type: "floor_opened", Data: map[string]string{"origin": origin}})
+	roundChanged("floor_open")
+	return nil
+}
+
+func listAnswers() []Answer {
+	roundMutex.Lock()
+	defer roundMutex.Unlock()
+	out := make([]Answer, 0, len(answers))
+	for _, a := range answers {
+		out = append(out, a)
+	}
+	return out
+}
+
+// eliminateTeam removes a team from the live question. Eliminating the buzz
+// winner reopens the buzzer for the others, and when only one team is left
+// a last_team_standing event names it.
+func eliminateTeam(name, origin string) (Team, error) {
+	t, err := roundTeam(name)
+	if err != nil {
+		return t, err
+	}
+	roundMutex.Lock()
+	if isEliminated(t.Name) {
+		roundMutex.Unlock()
+		return t, &RoundError{Code: "eliminated", Message: t.Name + " is already eliminated"}
+	}
+	eliminated = append(eliminated, t.Name)
+	reopened := buzzWinner == t.Name
+	if reopened {
+		buzzWinner = ""
+	}
+	var standing []string
+	for _, team := range listTeams() {
+		if !isEliminated(team.Name) {
+			standing = append(standing, team.Name)
+		}
+	}
+	roundMutex.Unlock()
+
+	audit("eliminate", origin, map[string]interface{}{"team": t.Name, "reopened_buzzer": reopened})
+	roundChanged("eliminate")
+	if len(standing) == 1 {
+		audit("last_team_standing", origin, map[string]interface{}{"team": standing[0]})
+		hub.broadcast(Event{Type: "last_team_standing", Data: map[string]string{"team": standing[0]}})
+	}
+	return t, nil
+}
+
+func roundError(err error) error {
+	rerr, ok := err.(*RoundError)
+	if !ok {
+		return badRequest(err.Error())
+	}
+	status := http.StatusConflict
+	switch rerr.Code {
+	case "unknown_team":
+		status = http.StatusNotFound
+	case "window_closed":
+		status = http.StatusGone
+	}
+	return apiError(status, rerr.Code, rerr.Message)
+}
+
+// TeamRequest names the team a buzz or elimination applies to.
+type TeamRequest struct {
+	Team string `json:"team"`
+}
+
+// AnswerRequest is a team's answer submission.
+type AnswerRequest struct {
+	Team   string `json:"team"`
+	Answer string `json:"answer"`
+}
+
+func postBuzz(c echo.Context) error {
+	req := new(TeamRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	t, err := buzz(req.Team, requestOrigin(c))
+	if err != nil {
+		return roundError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"winner": t.Name})
+}
+
+func postBuzzReset(c echo.Context) error {
+	resetBuzzer(requestOrigin(c))
+	return c.NoContent(http.StatusNoContent)
+}
+
+func postAnswer(c echo.Context) error {
+	req := new(AnswerRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	a, err := submitAnswer(req.Team, strings.TrimSpace(req.Answer), requestOrigin(c))
+	if err != nil {
+		return roundError(err)
+	}
+	return c.JSON(http.StatusOK, a)
+}
+
+// AnswerView is an answer with the points it earns if judged correct and,
+// once the floor of a free-text question closes, its classification.
+type AnswerView struct {
+	Answer
+	Points  int      `json:"points"`
+	Verdict *Verdict `json:"verdict,omitempty"`
+}
+
+func getAnswers(c echo.Context) error {
+	questionMutex.RLock()
+	policy := question.Scoring
+	verdicts := map[string]Verdict{}
+	for _, v := range liveVerdicts() {
+		verdicts[v.Team] = v
+	}
+	questionMutex.RUnlock()
+	out := []AnswerView{}
+	for _, a := range listAnswers() {
+		view := AnswerView{Answer: a, Points: answerPoints(policy, a)}
+		if v, ok := verdicts[a.Team]; ok {
+			view.Verdict = &v
+		}
+		out = append(out, view)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return c.JSON(http.StatusOK, out)
+}
+
+// FloorRequest is the body of POST /answers/close.
+type FloorRequest struct {
+	Stop bool `json:"stop"`
+}
+
+func postAnswersClose(c echo.Context) error {
+	req := new(FloorRequest)
+	if err := bindOptionalJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if err := closeFloor(req.Stop, requestOrigin(c)); err != nil {
+		return roundError(err)
+	}
+	return c.JSON(http.StatusOK, publicRound())
+}
+
+func postAnswersOpen(c echo.Context) error {
+	if err := openFloor(requestOrigin(c)); err != nil {
+		return roundError(err)
+	}
+	return c.JSON(http.StatusOK, publicRound())
+}
+
+func postEliminate(c echo.Context) error {
+	req := new(TeamRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if _, err := eliminateTeam(req.Team, requestOrigin(c)); err != nil {
+		return roundError(err)
+	}
+	return c.JSON(http.StatusOK, publicRound())
+}
+
+func handleEliminateCommand(args []string, origin string) (Team, error) {
+	success := color.New(color.FgGreen)
+
+	if len(args) != 1 {
+		return Team{}, errors.New("Usage: eliminate <team>")
+	}
+	t, err := eliminateTeam(args[0], origin)
+	if err != nil {
+		return Team{}, err
+	}
+	success.Printf("%s eliminated\n", t.Name)
+	return t, nil
+}
+
+func handleFloorCommand(command string, args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	if command == "open" {
+		if err := openFloor(origin); err != nil {
+			return err
+		}
+		success.Println("Answers open")
+		return nil
+	}
+	stop := len(args) == 1 && args[0] == "--stop"
+	if len(args) > 1 || (len(args) == 1 && !stop) {
+		return errors.New("Usage: close [--stop]")
+	}
+	if err := closeFloor(stop, origin); err != nil {
+		return err
+	}
+	if stop {
+		success.Println("Answers closed, countdown stopped")
+	} else {
+		success.Println("Answers closed")
+	}
+	return nil
+}
+
+func handleBuzzCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 1 && args[0] == "reset" {
+		resetBuzzer(origin)
+		success.Println("Buzzer reset")
+		return nil
+	}
+	round := publicRound()
+	if round == nil || round.BuzzWinner == "" {
+		info.Println("Nobody has buzzed")
+	} else {
+		info.Printf("Buzz winner: %s\n", round.BuzzWinner)
+	}
+	if round != nil && len(round.Eliminated) > 0 {
+		info.Printf("Eliminated: %s\n", strings.Join(round.Eliminated, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// Standing is a team's final placement.
+type Standing struct {
+	Place int  `json:"place"`
+	Team  Team `json:"team"`
+}
+
+// CeremonyView is the public part of the ceremony: only placements that
+// have already been revealed, last place first.
+type CeremonyView struct {
+	Revealed []Standing `json:"revealed"`
+	Total    int        `json:"total"`
+	Finished bool       `json:"finished"`
+}
+
+var (
+	ceremonyMutex     sync.RWMutex
+	ceremonyOn        bool
+	ceremonyStandings []Standing // first place first
+	ceremonyRevealed  int
+)
+
+// rankTeams orders teams by score, then fewer lifelines used, then the
+// fastest cumulative answer time. The name is the last resort so the order
+// never depends on registration order.
+func rankTeams(list []Team) []Standing {
+	sorted := append([]Team(nil), list...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.LifelinesUsed != b.LifelinesUsed {
+			return a.LifelinesUsed < b.LifelinesUsed
+		}
+		if a.AnswerTime != b.AnswerTime {
+			return a.AnswerTime < b.AnswerTime
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+	out := make([]Standing, len(sorted))
+	for i, t := range sorted {
+		out[i] = Standing{Place: i + 1, Team: t}
+	}
+	return out
+}
+
+func ceremonyActive() bool {
+	ceremonyMutex.RLock()
+	defer ceremonyMutex.RUnlock()
+	return ceremonyOn
+}
+
+func startCeremony(origin string) error {
+	ceremonyMutex.Lock()
+	defer ceremonyMutex.Unlock()
+	if ceremonyOn {
+		return fmt.Errorf("ceremony is already running")
+	}
+	list := listTeams()
+	if len(list) == 0 {
+		return fmt.Errorf("no teams registered")
+	}
+	ceremonyOn = true
+	ceremonyStandings = rankTeams(list)
+	ceremonyRevealed = 0
+	audit("ceremony_start", origin, map[string]interface{}{"teams": len(list)})
+	return nil
+}
+
+// revealNext publishes the next placement, counting up from last place.
+func revealNext(origin string) (Standing, error) {
+	ceremonyMutex.Lock()
+	defer ceremonyMutex.Unlock()
+	if !ceremonyOn {
+		return Standing{}, fmt.Errorf("ceremony is not running")
+	}
+	if ceremonyRevealed >= len(ceremonyStandings) {
+		return Standing{}, fmt.Errorf("all placements are already revealed")
+	}
+	ceremonyRevealed++
+	s := ceremonyStandings[len(ceremonyStandings)-ceremonyRevealed]
+	audit("ceremony_reveal", origin, map[string]interface{}{"place": s.Place, "team": s.Team.Name})
+	return s, nil
+}
+
+func exitCeremony(origin string) error {
+	ceremonyMutex.Lock()
+	defer ceremonyMutex.Unlock()
+	if !ceremonyOn {
+		return fmt.Errorf("ceremony is not running")
+	}
+	ceremonyOn = false
+	ceremonyStandings = nil
+	ceremonyRevealed = 0
+	audit("ceremony_exit", origin, nil)
+	return nil
+}
+
+// publicCeremony returns what the audience may see, or nil outside the
+// ceremony.
+func publicCeremony() *CeremonyView {
+	ceremonyMutex.RLock()
+	defer ceremonyMutex.RUnlock()
+	if !ceremonyOn {
+		return nil
+	}
+	n := len(ceremonyStandings)
+	view := &CeremonyView{Total: n, Finished: ceremonyRevealed == n, Revealed: []Standing{}}
+	for i := 0; i < ceremonyRevealed; i++ {
+		view.Revealed = append(view.Revealed, ceremonyStandings[n-1-i])
+	}
+	return view
+}
+
+func fullStandings() ([]Standing, int, bool) {
+	ceremonyMutex.RLock()
+	defer ceremonyMutex.RUnlock()
+	return append([]Standing(nil), ceremonyStandings...), ceremonyRevealed, ceremonyOn
+}
+
+func postCeremonyStart(c echo.Context) error {
+	if err := startCeremony(requestOrigin(c)); err != nil {
+		return conflict(err.Error())
+	}
+	return getCeremony(c)
+}
+
+func postCeremonyRevealNext(c echo.Context) error {
+	s, err := revealNext(requestOrigin(c))
+	if err != nil {
+		return conflict(err.Error())
+	}
+	return c.JSON(http.StatusOK, s)
+}
+
+func postCeremonyExit(c echo.Context) error {
+	if err := exitCeremony(requestOrigin(c)); err != nil {
+		return conflict(err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func getCeremony(c echo.Context) error {
+	standings, revealed, active := fullStandings()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"active":    active,
+		"revealed":  revealed,
+		"standings": standings,
+	})
+}
+
+func handleCeremonyCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 0 {
+		standings, revealed, active := fullStandings()
+		if !active {
+			info.Println("Ceremony is not running")
+			return nil
+		}
+		for i, s := range standings {
+			mark := " "
+			if i >= len(standings)-revealed {
+				mark = "*"
+			}
+			info.Printf(" %s %2d. %-20s %4d\n", mark, s.Place, s.Team.Name, s.Team.Score)
+		}
+		return nil
+	}
+	switch args[0] {
+	case "start":
+		if err := startCeremony(origin); err != nil {
+			return err
+		}
+		success.Println("Ceremony started, scoring is frozen")
+	case "exit":
+		if err := exitCeremony(origin); err != nil {
+			return err
+		}
+		success.Println("Ceremony ended")
+	default:
+		return errors.New("Usage: ceremony [start|exit]")
+	}
+	return nil
+}
+
+func handleRevealCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	if len(args) != 1 || args[0] != "next" {
+		return errors.New("Usage: reveal next")
+	}
+	s, err := revealNext(origin)
+	if err != nil {
+		return err
+	}
+	success.Printf("Revealed place %d: %s (%d points)\n", s.Place, s.Team.Name, s.Team.Score)
+	return nil
+}
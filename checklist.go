@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// A queue or bank entry can carry a checklist, such as "mics" and
+// "projector", for steps the operator must take before it goes live. The
+// items are ticked off while the entry is staged, the next one to go live;
+// when another entry is staged, its checklist starts unticked. Going live
+// with items left needs force.
+
+// ChecklistItem is one item of the staged checklist.
+type ChecklistItem struct {
+	Name      string     `json:"name"`
+	Checked   bool       `json:"checked"`
+	CheckedBy string     `json:"checked_by,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// ChecklistView is the checklist of the staged entry, as GET /checklist
+// and the checklist event show it.
+type ChecklistView struct {
+	EntryID  int             `json:"entry_id"`
+	Question string          `json:"question"`
+	Items    []ChecklistItem `json:"items"`
+	Complete bool            `json:"complete"`
+}
+
+// ChecklistError is a go-live refused for the unchecked items.
+type ChecklistError struct {
+	Unchecked []string
+}
+
+func (e *ChecklistError) Error() string {
+	return fmt.Sprintf("checklist not complete: %s", strings.Join(e.Unchecked, ", "))
+}
+
+func checklistError(err *ChecklistError) *APIError {
+	return apiError(http.StatusConflict, "checklist_incomplete", err.Error()).withDetail("unchecked", err.Unchecked)
+}
+
+// checklistEntry is the queue entry the ticks in checklistTicks belong
+// to, keyed by item name in lower case.
+var (
+	checklistMutex sync.Mutex
+	checklistEntry int
+	checklistTicks = map[string]ChecklistItem{}
+)
+
+// stagedChecklist is the checklist of the staged entry, ok unset when it
+// has none. It starts a fresh one when the staged entry changed.
+func stagedChecklist() (view ChecklistView, ok bool) {
+	entry, staged := nextQueueEntry()
+	if !staged || len(entry.Checklist) == 0 {
+		return ChecklistView{}, false
+	}
+	checklistMutex.Lock()
+	defer checklistMutex.Unlock()
+	return checklistFor(entry), true
+}
+
+// checklistFor builds the view of entry. Call with checklistMutex held.
+func checklistFor(entry QueueEntry) ChecklistView {
+	if checklistEntry != entry.ID {
+		checklistEntry = entry.ID
+		checklistTicks = map[string]ChecklistItem{}
+	}
+	view := ChecklistView{EntryID: entry.ID, Question: entry.Question, Items: []ChecklistItem{}, Complete: true}
+	for _, name := range entry.Checklist {
+		item, ok := checklistTicks[strings.ToLower(name)]
+		if !ok {
+			item = ChecklistItem{Name: name}
+		}
+		view.Items = append(view.Items, item)
+		view.Complete = view.Complete && item.Checked
+	}
+	return view
+}
+
+// setChecklistItem ticks the staged entry's item name off, or back on.
+func setChecklistItem(name string, checked bool, origin string) (ChecklistView, error) {
+	entry, staged := nextQueueEntry()
+	if !staged || len(entry.Checklist) == 0 {
+		return ChecklistView{}, fmt.Errorf("the staged question has no checklist")
+	}
+	item := ""
+	for _, n := range entry.Checklist {
+		if strings.EqualFold(n, strings.TrimSpace(name)) {
+			item = n
+		}
+	}
+	if item == "" {
+		return ChecklistView{}, fmt.Errorf("no checklist item %q (%s)", name, strings.Join(entry.Checklist, ", "))
+	}
+
+	checklistMutex.Lock()
+	checklistFor(entry)
+	tick := ChecklistItem{Name: item}
+	if checked {
+		now := clock.Now()
+		_, by := splitOrigin(origin)
+		tick = ChecklistItem{Name: item, Checked: true, CheckedBy: by, CheckedAt: &now}
+	}
+	checklistTicks[strings.ToLower(item)] = tick
+	view := checklistFor(entry)
+	checklistMutex.Unlock()
+
+	action := "checklist_check"
+	if !checked {
+		action = "checklist_uncheck"
+	}
+	audit(action, origin, map[string]interface{}{"entry": entry.ID, "item": item, "complete": view.Complete})
+	if checked && view.Complete {
+		audit("checklist_complete", origin, map[string]interface{}{"entry": entry.ID, "items": entry.Checklist})
+	}
+	hub.broadcastOperator(Event{Type: "checklist", Data: view})
+	return view, nil
+}
+
+// checkChecklist refuses to put entry live while items are unchecked,
+// unless forced; a forced go-live is audited with what was skipped.
+func checkChecklist(entry QueueEntry, force bool, origin string) error {
+	if len(entry.Checklist) == 0 {
+		return nil
+	}
+	checklistMutex.Lock()
+	view := checklistFor(entry)
+	checklistMutex.Unlock()
+	var unchecked []string
+	for _, item := range view.Items {
+		if !item.Checked {
+			unchecked = append(unchecked, item.Name)
+		}
+	}
+	if len(unchecked) == 0 {
+		return nil
+	}
+	if !force {
+		return &ChecklistError{Unchecked: unchecked}
+	}
+	audit("checklist_forced", origin, map[string]interface{}{"entry": entry.ID, "unchecked": unchecked})
+	return nil
+}
+
+// announceChecklist sends the staged checklist to operator streams when a
+// different entry was staged.
+func announceChecklist() {
+	entry, staged := nextQueueEntry()
+	checklistMutex.Lock()
+	if !staged || entry.ID == checklistEntry {
+		checklistMutex.Unlock()
+		return
+	}
+	view := checklistFor(entry)
+	checklistMutex.Unlock()
+	if len(entry.Checklist) > 0 {
+		hub.broadcastOperator(Event{Type: "checklist", Data: view})
+	}
+}
+
+func getChecklist(c echo.Context) error {
+	view, ok := stagedChecklist()
+	if !ok {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.JSON(http.StatusOK, view)
+}
+
+func postChecklistItem(checked bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name, err := url.PathUnescape(c.Param("item"))
+		if err != nil {
+			return badRequest("invalid checklist item")
+		}
+		view, err := setChecklistItem(name, checked, requestOrigin(c))
+		if err != nil {
+			return notFound(err.Error())
+		}
+		return c.JSON(http.StatusOK, view)
+	}
+}
+
+// printChecklist shows the staged checklist, if there is one.
+func printChecklist() {
+	view, ok := stagedChecklist()
+	if !ok {
+		return
+	}
+	info := color.New(color.FgYellow)
+	info.Println("Checklist:")
+	for _, item := range view.Items {
+		mark := "[ ]"
+		if item.Checked {
+			mark = "[x]"
+		}
+		fmt.Printf("  %s %s", mark, item.Name)
+		if item.CheckedBy != "" {
+			fmt.Printf(" (%s)", item.CheckedBy)
+		}
+		fmt.Println()
+	}
+}
+
+// handleCheckCommand runs "check [item]" and "uncheck <item>".
+func handleCheckCommand(command string, args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 0 {
+		if command == "uncheck" {
+			return errors.New("Usage: uncheck <item>")
+		}
+		if _, ok := stagedChecklist(); !ok {
+			info.Println("The staged question has no checklist")
+			return nil
+		}
+		printChecklist()
+		return nil
+	}
+	view, err := setChecklistItem(strings.Join(args, " "), command == "check", origin)
+	if err != nil {
+		return err
+	}
+	if view.Complete {
+		success.Println("Checklist complete, ready to go live")
+		return nil
+	}
+	printChecklist()
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// TestChecklist ticks off the staged entry's checklist over HTTP and the
+// CLI, and checks that going live waits for it unless forced.
+func TestChecklist(t *testing.T) {
+	s := StartTestServer(t)
+	if status := s.Do(t, http.MethodGet, "/checklist", nil, nil); status != http.StatusNoContent {
+		t.Errorf("no staged entry: status %d", status)
+	}
+	var added []QueueEntry
+	s.Do(t, http.MethodPost, "/queue", []map[string]interface{}{
+		{"question": "Čo hrá?", "type": "pomoc", "time_left": 30_000_000_000, "checklist": []string{"mics", " Projektor", "MICS", ""}},
+		{"question": "Kto svieti?", "type": "pomoc", "time_left": 30_000_000_000, "checklist": []string{"svetlo na pódiu"}},
+	}, &added)
+	if len(added) != 2 || fmt.Sprint(added[0].Checklist) != "[mics Projektor]" {
+		t.Fatalf("queued %+v", added)
+	}
+	operator, err := hub.join("sse", true, parseEventTypes("checklist"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.unsubscribe(operator)
+
+	next := func(query string) (int, APIError) {
+		t.Helper()
+		var res struct {
+			Error APIError `json:"error"`
+		}
+		status := s.Do(t, http.MethodPost, "/v2/queue/next"+query, nil, &res)
+		return status, res.Error
+	}
+	if status, e := next(""); status != http.StatusConflict || e.Code != "checklist_incomplete" || fmt.Sprint(e.Details["unchecked"]) != "[mics Projektor]" {
+		t.Errorf("going live unchecked: status %d, %+v", status, e)
+	}
+
+	var view ChecklistView
+	if status := s.Do(t, http.MethodPost, "/checklist/MICS/check", nil, &view); status != http.StatusOK || !view.Items[0].Checked || view.Items[0].CheckedBy != "operator" || view.Complete {
+		t.Errorf("checking mics: status %d, %+v", status, view)
+	}
+	if status := s.Do(t, http.MethodPost, "/checklist/zvuk/check", nil, nil); status != http.StatusNotFound {
+		t.Errorf("an unknown item: status %d", status)
+	}
+	if err := cliCommand(t, "check projektor"); err != nil {
+		t.Fatal(err)
+	}
+	if e := lastAudit(t, "checklist_complete"); e.Operator != *cliOperatorName || e.Details["entry"] != added[0].ID {
+		t.Errorf("completion audited as %+v", e)
+	}
+	s.Do(t, http.MethodPost, "/checklist/mics/uncheck", nil, &view)
+	if view.Complete || view.Items[0].Checked {
+		t.Errorf("after unchecking %+v", view)
+	}
+	for _, want := range []bool{false, true, false} {
+		select {
+		case ev := <-operator.ch:
+			if v, ok := ev.Data.(ChecklistView); !ok || v.Complete != want {
+				t.Errorf("checklist event %+v, complete should be %v", ev.Data, want)
+			}
+		default:
+			t.Fatal("no checklist event")
+		}
+	}
+	if err := cliCommand(t, "check mics"); err != nil {
+		t.Fatal(err)
+	}
+	if status, e := next(""); status != http.StatusOK {
+		t.Fatalf("going live checked: status %d, %+v", status, e)
+	}
+
+	// The next entry starts unticked; forcing skips it, with an audit.
+	s.Do(t, http.MethodGet, "/checklist", nil, &view)
+	if view.EntryID != added[1].ID || view.Complete || view.Items[0].Checked {
+		t.Errorf("second checklist %+v", view)
+	}
+	if status := s.Do(t, http.MethodPost, "/checklist/svetlo%20na%20p%C3%B3diu/uncheck", nil, nil); status != http.StatusOK {
+		t.Errorf("an escaped item: status %d", status)
+	}
+	if status, e := next("?force=true"); status != http.StatusOK {
+		t.Fatalf("forcing: status %d, %+v", status, e)
+	}
+	if e := lastAudit(t, "checklist_forced"); e.Details["entry"] != added[1].ID || fmt.Sprint(e.Details["unchecked"]) != "[svetlo na pódiu]" {
+		t.Errorf("forced go-live audited as %+v", e)
+	}
+	if err := cliCommand(t, "uncheck"); err == nil {
+		t.Error("uncheck without an item")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	maxUnlockFailures = 3
+	unlockBackoff     = 30 * time.Second
+)
+
+var (
+	lockPIN      = flag.String("lock-pin", "", "PIN required to run destructive CLI commands once the CLI is locked")
+	idleLockTime = flag.Duration("idle-lock", 0, "lock destructive CLI commands after this long without input (needs -lock-pin, 0 disables)")
+)
+
+// destructiveCommands lists CLI commands that need the PIN while locked. A
+// nil value means every subcommand is destructive.
+var destructiveCommands = map[string]map[string]bool{
+	"exit":    nil,
+	"restore": nil,
+	"score":   nil,
+	"team":    {"rm": true},
+	"session": {"end": true},
+	"stats":   {"reset": true},
+	"judges":  {"reset": true},
+	"bank":    {"rm": true},
+	"queue":   {"rm": true},
+	"target":  {"rm": true},
+	"targets": {"rm": true},
+}
+
+var (
+	cliLockMutex   sync.Mutex
+	cliLocked      bool
+	lastCLIInput   = clock.Now()
+	unlockFailures int
+	unlockBlocked  time.Time
+)
+
+// noteCLIInput records operator activity and applies the idle lock if the
+// CLI has been untouched for longer than the configured idle time.
+func noteCLIInput() {
+	cliLockMutex.Lock()
+	defer cliLockMutex.Unlock()
+	now := clock.Now()
+	if *lockPIN != "" && *idleLockTime > 0 && !cliLocked && now.Sub(lastCLIInput) >= *idleLockTime {
+		cliLocked = true
+		audit("cli_lock", "cli", map[string]interface{}{"reason": "idle"})
+		color.New(color.FgYellow).Println("CLI locked after inactivity, destructive commands need 'unlock <pin>'")
+	}
+	lastCLIInput = now
+}
+
+// cliCommandBlocked reports whether the lock prevents running command.
+func cliCommandBlocked(command string, args []string) bool {
+	sub, ok := destructiveCommands[command]
+	if !ok {
+		return false
+	}
+	if sub != nil && (len(args) == 0 || !sub[args[0]]) {
+		return false
+	}
+	cliLockMutex.Lock()
+	defer cliLockMutex.Unlock()
+	return cliLocked
+}
+
+func lockCLI() error {
+	cliLockMutex.Lock()
+	defer cliLockMutex.Unlock()
+	if *lockPIN == "" {
+		return fmt.Errorf("no PIN configured, start the server with -lock-pin")
+	}
+	cliLocked = true
+	audit("cli_lock", "cli", map[string]interface{}{"reason": "manual"})
+	return nil
+}
+
+// unlockCLI checks pin and unlocks the CLI. After a few failures further
+// attempts are refused for a while.
+func unlockCLI(pin string) error {
+	cliLockMutex.Lock()
+	defer cliLockMutex.Unlock()
+	if !cliLocked {
+		return fmt.Errorf("CLI is not locked")
+	}
+	now := clock.Now()
+	if now.Before(unlockBlocked) {
+		return fmt.Errorf("too many failed attempts, try again in %s", unlockBlocked.Sub(now).Round(time.Second))
+	}
+	if subtle.ConstantTimeCompare([]byte(pin), []byte(*lockPIN)) != 1 {
+		unlockFailures++
+		audit("cli_unlock_failed", "cli", map[string]interface{}{"failures": unlockFailures})
+		if unlockFailures >= maxUnlockFailures {
+			unlockBlocked = now.Add(unlockBackoff)
+			unlockFailures = 0
+		}
+		return fmt.Errorf("wrong PIN")
+	}
+	cliLocked = false
+	unlockFailures = 0
+	audit("cli_unlock", "cli", nil)
+	return nil
+}
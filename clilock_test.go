@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCLILockBlocks locks the CLI and checks which commands need the PIN.
+func TestCLILockBlocks(t *testing.T) {
+	StartTestServer(t)
+	defer func(pin string) {
+		*lockPIN = pin
+		cliLockMutex.Lock()
+		cliLocked = false
+		cliLockMutex.Unlock()
+	}(*lockPIN)
+	*lockPIN = "4321"
+	if err := addTeam(Team{Name: "Sovy"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lockCLI(); err != nil {
+		t.Fatal(err)
+	}
+
+	blocked := []string{"exit", "restore show.tar.gz", "score Sovy 5", "team rm Sovy", "session end", "stats reset",
+		"judges reset", "bank rm 1", "queue rm 1", "target rm obs", "targets rm obs"}
+	allowed := []string{"stats", "judges", "bank", "queue", "targets", "team add Orly", "session start Finále", "pause"}
+	check := func(lines []string, want bool) {
+		t.Helper()
+		for _, line := range lines {
+			args := strings.Fields(line)
+			if got := cliCommandBlocked(args[0], args[1:]); got != want {
+				t.Errorf("%q blocked %v", line, got)
+			}
+		}
+	}
+	check(blocked, true)
+	check(allowed, false)
+
+	runCommandLine("team rm Sovy", "cli")
+	if _, ok := findTeam("Sovy"); !ok {
+		t.Error("a locked CLI removed a team")
+	}
+
+	if err := unlockCLI("4321"); err != nil {
+		t.Fatal(err)
+	}
+	check(blocked, false)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	clockWatchInterval = time.Second
+	// clockJumpThreshold is how far the wall clock may drift from the
+	// monotonic clock in one tick before it counts as a jump.
+	clockJumpThreshold = 2 * time.Second
+)
+
+// watchClock compares the wall clock with Go's monotonic clock once a
+// second. They part ways when the laptop sleeps (the monotonic clock stops)
+// or when the system time is changed. Timers run on the monotonic clock, so
+// after a jump everything is re-synced from the wall clock.
+func watchClock() {
+	lastMono := time.Now()
+	lastWall := clock.Now()
+	for {
+		time.Sleep(clockWatchInterval)
+		mono := time.Now()
+		wall := clock.Now()
+		jump := wall.Sub(lastWall) - mono.Sub(lastMono)
+		lastMono, lastWall = mono, wall
+		if currentReplay() != nil {
+			continue
+		}
+		if jump > clockJumpThreshold || jump < -clockJumpThreshold {
+			clockJumped(jump)
+		}
+	}
+}
+
+// clockJumped re-arms the expiry timer from the wall-clock deadline and
+// republishes the state so every display picks up the corrected time.
+func clockJumped(jump time.Duration) {
+	notify(SeverityWarning, "clock", "Clock jumped by %s (sleep or time change), timers re-synced", jump.Round(time.Second))
+	audit("clock_jump", "system", map[string]interface{}{"seconds": jump.Seconds()})
+
+	questionMutex.Lock()
+	stateChanged("clock_jump")
+	questionMutex.Unlock()
+	go sendCurrentQuestion()
+}
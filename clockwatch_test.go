@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestClockJumps sets the wall clock back as a time change would, and
+// checks that the jump is reported and re-published, and that it neither
+// adds time to a countdown nor makes a count-up negative.
+func TestClockJumps(t *testing.T) {
+	s := StartTestServer(t)
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Koľko je 2+2?", "type": "pomoc", "time_left": 30_000_000_000})
+	AdvanceClock(t, 10*time.Second)
+	if q := s.MustDo(t, http.MethodGet, "/v2/get-question", nil); q["time_left"] != 20.0 {
+		t.Fatalf("time left %v", q["time_left"])
+	}
+
+	AdvanceClock(t, -time.Minute)
+	t.Cleanup(func() { AdvanceClock(t, time.Minute) })
+	before := currentRevision()
+	clockJumped(-time.Minute)
+	if currentRevision() <= before {
+		t.Error("the jump didn't publish the state")
+	}
+	if e := lastAudit(t, "clock_jump"); e.Origin != "system" || e.Details["seconds"] != -60.0 {
+		t.Errorf("jump audited as %+v", e)
+	}
+	if notes := listNotifications(); len(notes) == 0 || notes[len(notes)-1].Category != "clock" || notes[len(notes)-1].Severity != SeverityWarning {
+		t.Errorf("notifications %+v", notes)
+	}
+	if q := s.MustDo(t, http.MethodGet, "/v2/get-question", nil); q["time_left"] != 30.0 {
+		t.Errorf("a clock set back left %v", q["time_left"])
+	}
+
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Počítame", "type": "pomoc", "count_up": true})
+	AdvanceClock(t, -5*time.Second)
+	clockJumped(-5 * time.Second)
+	if q := s.MustDo(t, http.MethodGet, "/v2/get-question", nil); q["time_left"] != 0.0 {
+		t.Errorf("a count-up set back shows %v", q["time_left"])
+	}
+	AdvanceClock(t, 5*time.Second)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+var stateDir = flag.String("state-dir", "state", "directory for the operator command history (empty keeps it in memory)")
+
+// Every line that reaches runCommandLine, typed, piped or bound to a hotkey,
+// and every socket command is appended to <state-dir>/commands.jsonl with
+// its origin and the error it failed with, if any. "hist" searches it and replays entries. PINs and keys are
+// redacted before anything is written, here and in the readline history.
+
+// CommandRecord is one line of the command history.
+type CommandRecord struct {
+	N       int       `json:"n"`
+	Time    time.Time `json:"time"`
+	Origin  string    `json:"origin"`
+	Command string    `json:"command"`
+	// Result is "ok" or "failed".
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// commandLog holds the command history of this and earlier runs.
+var commandLog struct {
+	mu      sync.Mutex
+	entries []CommandRecord
+	warned  bool
+}
+
+func commandLogPath() string {
+	if *stateDir == "" {
+		return ""
+	}
+	return filepath.Join(*stateDir, "commands.jsonl")
+}
+
+// loadCommandLog loads the command history of earlier runs.
+func loadCommandLog() {
+	path := commandLogPath()
+	if path == "" {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r CommandRecord
+		if json.Unmarshal(scanner.Bytes(), &r) == nil && r.N > 0 {
+			commandLog.entries = append(commandLog.entries, r)
+		}
+	}
+}
+
+// trackCommand starts recording line and returns the function that
+// finishes the record once the line has run, with the error it failed
+// with or nil. "hist" lines are not kept.
+func trackCommand(line, origin string) func(err error) {
+	if f := strings.Fields(line); len(f) > 0 && f[0] == "hist" {
+		return func(error) {}
+	}
+	started := clock.Now()
+	return func(err error) {
+		r := CommandRecord{Time: started, Origin: origin, Command: redactCommand(line), Result: "ok"}
+		if err != nil {
+			r.Result, r.Error = "failed", err.Error()
+		}
+		appendCommandRecord(r)
+	}
+}
+
+func appendCommandRecord(r CommandRecord) {
+	commandLog.mu.Lock()
+	defer commandLog.mu.Unlock()
+	r.N = 1
+	if n := len(commandLog.entries); n > 0 {
+		r.N = commandLog.entries[n-1].N + 1
+	}
+	commandLog.entries = append(commandLog.entries, r)
+
+	path := commandLogPath()
+	if path == "" {
+		return
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	var f *os.File
+	if err == nil {
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	}
+	if err == nil {
+		err = json.NewEncoder(f).Encode(r)
+		f.Close()
+	}
+	if err != nil && !commandLog.warned {
+		commandLog.warned = true
+		asyncPrintf(color.New(color.FgYellow), "Warning: command history not saved, %v\n", err)
+	}
+}
+
+var (
+	// secretArg matches an argument that carries its secret inline, like
+	// --pin=1234 or key=abc.
+	secretArg = regexp.MustCompile(`(?i)^(-{0,2}(?:pin|key|api-?key|token|secret|password)=).+$`)
+	// secretFlag matches a flag whose secret is the next argument.
+	secretFlag = regexp.MustCompile(`(?i)^-{1,2}(?:pin|key|api-?key|token|secret|password)$`)
+)
+
+const redacted = "***"
+
+// redactCommand masks the PINs and keys in a command line: "unlock <pin>",
+// "team pin <name> <pin>" and secret-looking flags.
+func redactCommand(line string) string {
+	var out []string
+	for _, cmd := range strings.Split(line, ";") {
+		args := strings.Fields(cmd)
+		for i, a := range args {
+			switch {
+			case secretArg.MatchString(a):
+				args[i] = secretArg.ReplaceAllString(a, "${1}"+redacted)
+			case i > 0 && secretFlag.MatchString(args[i-1]):
+				args[i] = redacted
+			}
+		}
+		switch {
+		case len(args) == 2 && args[0] == "unlock":
+			args[1] = redacted
+		case len(args) == 4 && args[0] == "team" && args[1] == "pin" && args[3] != "off":
+			args[3] = redacted
+		}
+		out = append(out, strings.Join(args, " "))
+	}
+	return strings.Join(out, "; ")
+}
+
+// fuzzyScore rates how well command matches term: a substring beats the
+// letters of term in order with gaps, fewer gaps first. It reports false
+// when the letters are not all there.
+func fuzzyScore(command, term string) (int, bool) {
+	command, term = strings.ToLower(command), strings.ToLower(term)
+	if strings.Contains(command, term) {
+		return 0, true
+	}
+	gaps, at := 0, 0
+	for _, r := range term {
+		i := strings.IndexRune(command[at:], r)
+		if i < 0 {
+			return 0, false
+		}
+		if i > 0 && at > 0 {
+			gaps++
+		}
+		at += i + len(string(r))
+	}
+	return 1 + gaps, true
+}
+
+func commandByNumber(n int) (CommandRecord, bool) {
+	commandLog.mu.Lock()
+	defer commandLog.mu.Unlock()
+	for _, r := range commandLog.entries {
+		if r.N == n {
+			return r, true
+		}
+	}
+	return CommandRecord{}, false
+}
+
+func printCommandRecord(r CommandRecord) {
+	info := color.New(color.FgYellow)
+	errorC := color.New(color.FgRed)
+	line := fmt.Sprintf("  #%-5d %s  %-7s %s", r.N, r.Time.Local().Format("2006-01-02 15:04:05"), r.Origin, r.Command)
+	if r.Result == "failed" {
+		errorC.Printf("%s  [failed: %s]\n", line, r.Error)
+		return
+	}
+	info.Println(line)
+}
+
+// handleHistCommand runs "hist [search <term>|stats|run <n>]".
+func handleHistCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	commandLog.mu.Lock()
+	entries := append([]CommandRecord(nil), commandLog.entries...)
+	commandLog.mu.Unlock()
+
+	switch {
+	case len(args) == 0:
+		if len(entries) == 0 {
+			info.Println("No commands recorded yet")
+			return nil
+		}
+		for _, r := range entries[max(len(entries)-20, 0):] {
+			printCommandRecord(r)
+		}
+	case args[0] == "search" && len(args) > 1:
+		term := strings.Join(args[1:], " ")
+		type hit struct {
+			r     CommandRecord
+			score int
+		}
+		var hits []hit
+		for _, r := range entries {
+			if s, ok := fuzzyScore(r.Command, term); ok {
+				hits = append(hits, hit{r, s})
+			}
+		}
+		if len(hits) == 0 {
+			info.Printf("No command matches %q\n", term)
+			return nil
+		}
+		sort.SliceStable(hits, func(i, j int) bool {
+			if hits[i].score != hits[j].score {
+				return hits[i].score < hits[j].score
+			}
+			return hits[i].r.N > hits[j].r.N
+		})
+		for _, h := range hits[:min(len(hits), 20)] {
+			printCommandRecord(h.r)
+		}
+		if len(hits) > 20 {
+			info.Printf("  ... and %d more\n", len(hits)-20)
+		}
+	case args[0] == "stats" && len(args) == 1:
+		type usage struct {
+			name          string
+			count, failed int
+		}
+		byName := map[string]*usage{}
+		origins := map[string]int{}
+		for _, r := range entries {
+			origins[r.Origin]++
+			for _, cmd := range strings.Split(r.Command, ";") {
+				f := strings.Fields(cmd)
+				if len(f) == 0 {
+					continue
+				}
+				u := byName[f[0]]
+				if u == nil {
+					u = &usage{name: f[0]}
+					byName[f[0]] = u
+				}
+				u.count++
+				if r.Result == "failed" {
+					u.failed++
+				}
+			}
+		}
+		if len(byName) == 0 {
+			info.Println("No commands recorded yet")
+			return nil
+		}
+		list := make([]*usage, 0, len(byName))
+		for _, u := range byName {
+			list = append(list, u)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].count != list[j].count {
+				return list[i].count > list[j].count
+			}
+			return list[i].name < list[j].name
+		})
+		info.Printf("%d commands recorded\n", len(entries))
+		for _, u := range list[:min(len(list), 15)] {
+			info.Printf("  %-12s %5d  %d failed\n", u.name, u.count, u.failed)
+		}
+		names := make([]string, 0, len(origins))
+		for o := range origins {
+			names = append(names, o)
+		}
+		sort.Strings(names)
+		for i, o := range names {
+			names[i] = fmt.Sprintf("%s %d", o, origins[o])
+		}
+		info.Printf("By origin: %s\n", strings.Join(names, ", "))
+	case args[0] == "run" && len(args) == 2:
+		n, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+		if err != nil {
+			return errors.New("Usage: hist run <n>")
+		}
+		r, ok := commandByNumber(n)
+		if !ok {
+			return fmt.Errorf("No command #%d", n)
+		}
+		if strings.Contains(r.Command, redacted) {
+			return fmt.Errorf("#%d has redacted arguments, type it again", n)
+		}
+		success.Printf("Replaying #%d: %s\n", n, r.Command)
+		runCommandLine(r.Command, "replay")
+	default:
+		return errors.New("Usage: hist [search <term>|stats|run <n>]")
+	}
+	return nil
+}
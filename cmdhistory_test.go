@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// useCommandLog points the command history at a fresh directory for a test.
+func useCommandLog(t *testing.T) {
+	saved := *stateDir
+	*stateDir = t.TempDir()
+	commandLog.mu.Lock()
+	commandLog.entries = nil
+	commandLog.mu.Unlock()
+	t.Cleanup(func() { *stateDir = saved })
+}
+
+func lastCommandRecord(t *testing.T) CommandRecord {
+	t.Helper()
+	commandLog.mu.Lock()
+	defer commandLog.mu.Unlock()
+	if len(commandLog.entries) == 0 {
+		t.Fatal("nothing recorded")
+	}
+	return commandLog.entries[len(commandLog.entries)-1]
+}
+
+func TestCommandHistoryRecordsErrors(t *testing.T) {
+	StartTestServer(t)
+	useCommandLog(t)
+
+	runCommandLine("logging off", "pipe")
+	if r := lastCommandRecord(t); r.N != 1 || r.Origin != "pipe" || r.Command != "logging off" || r.Result != "ok" || r.Error != "" {
+		t.Errorf("a command that worked: %+v", r)
+	}
+
+	// A line keeps the first error of its commands.
+	runCommandLine("type nonsense; logging maybe", "cli")
+	r := lastCommandRecord(t)
+	if r.N != 2 || r.Result != "failed" || !strings.HasPrefix(r.Error, "Invalid type. Must be one of:") {
+		t.Errorf("a command that failed: %+v", r)
+	}
+
+	// Hints stay on the terminal.
+	runCommandLine("frobnicate", "cli")
+	if r := lastCommandRecord(t); r.Result != "failed" || r.Error != "Unknown command: frobnicate" {
+		t.Errorf("an unknown command: %+v", r)
+	}
+
+	runCommandLine("unlock 1234", "cli")
+	if r := lastCommandRecord(t); r.Command != "unlock ***" || r.Result != "failed" {
+		t.Errorf("a PIN: %+v", r)
+	}
+
+	runCommandLine("hist", "cli")
+	if r := lastCommandRecord(t); r.N != 4 {
+		t.Errorf("hist was recorded: %+v", r)
+	}
+
+	f, err := os.Open(commandLogPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var saved []CommandRecord
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		var r CommandRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, r)
+	}
+	if len(saved) != 4 || saved[1].Error != r.Error || strings.Contains(saved[3].Command, "1234") {
+		t.Errorf("saved %+v", saved)
+	}
+}
+
+func TestCommandHistoryRecordsSocketCommands(t *testing.T) {
+	s := StartTestServer(t)
+	useCommandLog(t)
+
+	ws := dialWS(t, s, testKey)
+	wsSend(t, ws, WSCommand{ID: "1", Cmd: "time.adjust", Delta: 10})
+	if r := lastCommandRecord(t); r.Origin != "ws" || r.Command != "adjust 10" || r.Result != "ok" {
+		t.Errorf("adjust: %+v", r)
+	}
+	wsSend(t, ws, WSCommand{ID: "2", Cmd: "resume"})
+	if r := lastCommandRecord(t); r.Command != "resume" || r.Result != "failed" || r.Error == "" {
+		t.Errorf("resuming a running question: %+v", r)
+	}
+}
+
+func TestRedactCommand(t *testing.T) {
+	for in, want := range map[string]string{
+		"unlock 1234":                "unlock ***",
+		"team pin Sovy 4321":         "team pin Sovy ***",
+		"team pin Sovy off":          "team pin Sovy off",
+		"target add x --token abc":   "target add x --token ***",
+		"sync --api-key=abc; status": "sync --api-key=***; status",
+		"question Koľko je   2+2?":   "question Koľko je 2+2?",
+		"backup /tmp/show.tar.gz":    "backup /tmp/show.tar.gz",
+	} {
+		if got := redactCommand(in); got != want {
+			t.Errorf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestPushCoalesce edits the live question three times within the window
+// and checks that only the last state goes out, once, and that a pause
+// goes out without waiting.
+func TestPushCoalesce(t *testing.T) {
+	s := StartTestServer(t)
+	defer func(d time.Duration) { *pushCoalesce = d }(*pushCoalesce)
+	*pushCoalesce = time.Second
+	pushWorkersMutex.RLock()
+	w := pushWorkers["flask"]
+	pushWorkersMutex.RUnlock()
+
+	// Going live doesn't wait for the clock.
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Prvá verzia", "type": "pomoc", "time_left": 30_000_000_000})
+	s.Flask.WaitFor(t, "the question pushed", func(p map[string]interface{}) bool { return p["question"] == "Prvá verzia" })
+	eventually(t, "the question delivered", func() bool { return pendingPushCount() == 0 })
+
+	edit := func(text string) {
+		questionMutex.Lock()
+		question.Question = text
+		questionMutex.Unlock()
+		notifyPushTargets(false)
+	}
+	pushed, coalesced := len(s.Flask.Payloads()), flaskStatus(t).Coalesced
+	edit("Druhá verzia")
+	eventually(t, "the worker waiting", func() bool { return len(w.wake) == 0 })
+	edit("Tretia verzia")
+	edit("Štvrtá verzia")
+	if n := len(s.Flask.Payloads()); n != pushed {
+		t.Fatalf("%d pushes before the window ended", n-pushed)
+	}
+	eventually(t, "the edits pushed", func() bool {
+		if len(s.Flask.Payloads()) == pushed {
+			AdvanceClock(t, *pushCoalesce)
+			return false
+		}
+		return true
+	})
+	eventually(t, "the edits delivered", func() bool { return pendingPushCount() == 0 })
+	payloads := s.Flask.Payloads()[pushed:]
+	if len(payloads) != 1 || payloads[0]["question"] != "Štvrtá verzia" {
+		t.Errorf("pushed %v", payloads)
+	}
+	if c := flaskStatus(t).Coalesced; c != coalesced+1 {
+		t.Errorf("coalesced %d, want %d", c, coalesced+1)
+	}
+
+	// A pause ends a pending wait.
+	edit("Piata verzia")
+	eventually(t, "the worker waiting", func() bool { return len(w.wake) == 0 })
+	s.MustDo(t, http.MethodPost, "/pause", map[string]string{"reason": "tech"})
+	s.Flask.WaitFor(t, "the pause pushed", func(p map[string]interface{}) bool {
+		return p["paused"] == true && p["question"] == "Piata verzia"
+	})
+}
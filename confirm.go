@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// confirmationTTL is how long a confirmation token stays valid.
+const confirmationTTL = 30 * time.Second
+
+// confirmHeader carries the token on the 428 and on the repeated request.
+// /v1 error bodies are only {"error": "..."}, so the header is how a /v1
+// client gets the token.
+const confirmHeader = "X-Confirm-Token"
+
+// maxConfirmedBody caps the body a confirmed request may have, with room
+// for a backup archive.
+const maxConfirmedBody = 64 << 20
+
+// pendingConfirmation is an issued token and the exact operation it allows.
+type pendingConfirmation struct {
+	Op          string
+	Fingerprint string
+	Summary     string
+	Expires     time.Time
+}
+
+var (
+	confirmations      = map[string]pendingConfirmation{}
+	confirmationsMutex sync.Mutex
+)
+
+// issueConfirmation returns a single-use token for op with these exact
+// parameters.
+func issueConfirmation(op, fingerprint, summary, origin string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	confirmationsMutex.Lock()
+	now := clock.Now()
+	for t, p := range confirmations {
+		if now.After(p.Expires) {
+			delete(confirmations, t)
+		}
+	}
+	confirmations[token] = pendingConfirmation{Op: op, Fingerprint: fingerprint, Summary: summary, Expires: now.Add(confirmationTTL)}
+	confirmationsMutex.Unlock()
+
+	audit("confirm_issue", origin, map[string]interface{}{"op": op, "summary": summary})
+	return token
+}
+
+// useConfirmation spends token. It fails if the token is unknown, expired
+// or was issued for a different operation or parameters; the token is gone
+// afterwards either way.
+func useConfirmation(token, op, fingerprint, origin string) error {
+	confirmationsMutex.Lock()
+	p, ok := confirmations[token]
+	delete(confirmations, token)
+	confirmationsMutex.Unlock()
+
+	var err error
+	switch {
+	case !ok:
+		err = fmt.Errorf("unknown or already used confirmation token")
+	case clock.Now().After(p.Expires):
+		err = fmt.Errorf("confirmation token expired")
+	case p.Op != op || p.Fingerprint != fingerprint:
+		err = fmt.Errorf("confirmation token was issued for a different operation")
+	}
+	details := map[string]interface{}{"op": op, "ok": err == nil}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+	audit("confirm_use", origin, details)
+	return err
+}
+
+// fingerprint identifies an operation and its parameters.
+func fingerprint(parts ...[]byte) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// requestFingerprint identifies req by its method, path, query and body.
+// Forms are taken by their fields rather than their bytes: a client picks a
+// new multipart boundary for every request, so the repeated upload of the
+// same archive differs in every boundary line. Files count by their
+// content.
+func requestFingerprint(req *http.Request, body []byte) (string, error) {
+	parts := [][]byte{[]byte(req.Method), []byte(req.URL.Path), []byte(req.URL.Query().Encode())}
+	mediaType, params, _ := mime.ParseMediaType(req.Header.Get(echo.HeaderContentType))
+	switch mediaType {
+	case echo.MIMEMultipartForm:
+		fields, err := multipartFields(body, params["boundary"])
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, fields...)
+	case echo.MIMEApplicationForm:
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, []byte(values.Encode()))
+	default:
+		parts = append(parts, body)
+	}
+	return fingerprint(parts...), nil
+}
+
+// multipartFields lists the fields of a multipart body as name=value, with
+// the SHA-256 of the content for a file, sorted.
+func multipartFields(body []byte, boundary string) ([][]byte, error) {
+	if boundary == "" {
+		return nil, fmt.Errorf("multipart body without a boundary")
+	}
+	r := multipart.NewReader(bytes.NewReader(body), boundary)
+	var fields []string
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, p); err != nil {
+			return nil, err
+		}
+		sum := h.Sum(nil)
+		if p.FileName() != "" {
+			fields = append(fields, p.FormName()+"=file:"+hex.EncodeToString(sum))
+		} else {
+			fields = append(fields, p.FormName()+"=value:"+hex.EncodeToString(sum))
+		}
+	}
+	sort.Strings(fields)
+	out := make([][]byte, len(fields))
+	for i, f := range fields {
+		out[i] = []byte(f)
+	}
+	return out, nil
+}
+
+// requireConfirmation makes a destructive endpoint take two calls. The first
+// is answered with 428, a token and a summary of what would happen; the
+// client repeats the same request with the token in X-Confirm-Token within
+// confirmationTTL to go ahead. The token comes in the X-Confirm-Token
+// response header and, on /v2, in the error details too.
+func requireConfirmation(op string, summarize func(c echo.Context) string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			body, err := io.ReadAll(http.MaxBytesReader(c.Response(), req.Body, maxConfirmedBody))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					return apiError(http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("the request body is larger than %d bytes", tooLarge.Limit))
+				}
+				return badRequest(err.Error())
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			fp, err := requestFingerprint(req, body)
+			if err != nil {
+				return badRequest("malformed form body: " + err.Error())
+			}
+
+			token := req.Header.Get(confirmHeader)
+			if token == "" {
+				summary := summarize(c)
+				issued := issueConfirmation(op, fp, summary, requestOrigin(c))
+				c.Response().Header().Set(confirmHeader, issued)
+				return apiError(http.StatusPreconditionRequired, "confirmation_required", summary).
+					withDetail("token", issued).
+					withDetail("expires_in", confirmationTTL.Seconds())
+			}
+			if err := useConfirmation(token, op, fp, requestOrigin(c)); err != nil {
+				return apiError(http.StatusPreconditionRequired, "invalid_confirmation", err.Error())
+			}
+			return next(c)
+		}
+	}
+}
+
+// confirmCLI runs the same token exchange behind a y/n prompt.
+func confirmCLI(op, summary string, params ...string) bool {
+	parts := make([][]byte, len(params))
+	for i, p := range params {
+		parts[i] = []byte(p)
+	}
+	fp := fingerprint(parts...)
+	token := issueConfirmation(op, fp, summary, "cli")
+	if !promptYesNo(summary + " Continue?") {
+		confirmationsMutex.Lock()
+		delete(confirmations, token)
+		confirmationsMutex.Unlock()
+		audit("confirm_decline", "cli", map[string]interface{}{"op": op})
+		return false
+	}
+	return useConfirmation(token, op, fp, "cli") == nil
+}
+
+func restoreSummary(echo.Context) string {
+	return fmt.Sprintf("This will replace %d teams, %d answers, %d queued questions and the live question with the backup.",
+		len(listTeams()), len(listAnswers()), len(listQueue()))
+}
+
+func teamRemovalSummary(name string) string {
+	t, ok := findTeam(name)
+	if !ok {
+		return fmt.Sprintf("This will remove team %s.", name)
+	}
+	return fmt.Sprintf("This will remove team %s and its %d points.", t.Name, t.Score)
+}
+
+func sessionEndSummary(echo.Context) string {
+	v := currentSessions()
+	if v.Active == nil {
+		return "There is no session to end."
+	}
+	return fmt.Sprintf("This will archive session #%d %s and reset the scores and answers of %d teams.", v.Active.ID, v.Active.Name, len(listTeams()))
+}
+
+func bankRemovalSummary(ids ...int) string {
+	if len(ids) != 1 {
+		return fmt.Sprintf("This will delete %d bank entries.", len(ids))
+	}
+	e, ok := findBankEntry(ids[0])
+	if !ok {
+		return fmt.Sprintf("This will delete bank entry #%d.", ids[0])
+	}
+	return fmt.Sprintf("This will delete bank entry #%d %q.", e.ID, e.Question)
+}
+
+// duplicateRemovalSummary reads the ids from the body and leaves it for the
+// handler.
+func duplicateRemovalSummary(c echo.Context) string {
+	body, _ := io.ReadAll(c.Request().Body)
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
+	var req ResolveDuplicatesRequest
+	json.Unmarshal(body, &req)
+	return bankRemovalSummary(req.Remove...)
+}
+
+func queueRemovalSummary(id int) string {
+	q := listQueue()
+	for i, e := range q {
+		if e.ID != id {
+			continue
+		}
+		if start, end := groupSpan(q, i); end-start > 1 {
+			return fmt.Sprintf("This will remove queue entry #%d %q and the other %d questions of its group.", e.ID, e.Question, end-start-1)
+		}
+		return fmt.Sprintf("This will remove queue entry #%d %q.", e.ID, e.Question)
+	}
+	return fmt.Sprintf("This will remove queue entry #%d.", id)
+}
+
+func statsResetSummary() string {
+	return fmt.Sprintf("This will reset the statistics of %d questions.", currentStats().QuestionsAsked)
+}
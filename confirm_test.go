@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// multipartRequest builds a POST /restore with the given fields and one
+// file, under a boundary of its own like every client picks.
+func multipartRequest(t *testing.T, fields map[string]string, file []byte) (*http.Request, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		w.WriteField(k, v)
+	}
+	fw, err := w.CreateFormFile("backup", "backup.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(file)
+	w.Close()
+	req := httptest.NewRequest(http.MethodPost, "/restore", bytes.NewReader(buf.Bytes()))
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req, buf.Bytes()
+}
+
+func TestRequestFingerprintMultipart(t *testing.T) {
+	fp := func(fields map[string]string, file string) string {
+		req, body := multipartRequest(t, fields, []byte(file))
+		got, err := requestFingerprint(req, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	base := fp(map[string]string{"force": "true"}, "archive")
+	if again := fp(map[string]string{"force": "true"}, "archive"); again != base {
+		t.Error("the same upload under a new boundary has a new fingerprint")
+	}
+	if fp(map[string]string{"force": "false"}, "archive") == base {
+		t.Error("a changed field keeps the fingerprint")
+	}
+	if fp(map[string]string{"force": "true"}, "other archive") == base {
+		t.Error("a changed file keeps the fingerprint")
+	}
+}
+
+func TestRequestFingerprintForm(t *testing.T) {
+	fp := func(target, body string) string {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		got, err := requestFingerprint(req, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	if fp("/restore?a=1&b=2", "x=1&y=2") != fp("/restore?b=2&a=1", "y=2&x=1") {
+		t.Error("field order changes the fingerprint")
+	}
+	if fp("/restore", "x=1") == fp("/restore", "x=2") {
+		t.Error("a changed value keeps the fingerprint")
+	}
+	if fp("/restore", "x=1") == fp("/teams", "x=1") {
+		t.Error("another path keeps the fingerprint")
+	}
+}
+
+func TestConfirmTeamRemoval(t *testing.T) {
+	s := StartTestServer(t)
+	for _, name := range []string{"Owls", "Foxes"} {
+		s.MustDo(t, http.MethodPost, "/v2/teams", map[string]string{"name": name})
+	}
+	remove := func(version, name, token string) *http.Response {
+		req := s.NewRequest(t, http.MethodDelete, version+"/teams/"+name, nil)
+		if token != "" {
+			req.Header.Set(confirmHeader, token)
+		}
+		return s.Send(t, req, nil)
+	}
+
+	// /v1 bodies have no details, so the token comes in the header.
+	resp := remove("/v1", "Owls", "")
+	token := resp.Header.Get(confirmHeader)
+	if resp.StatusCode != http.StatusPreconditionRequired || token == "" {
+		t.Fatalf("first call: status %d, token %q", resp.StatusCode, token)
+	}
+	if _, ok := findTeam("Owls"); !ok {
+		t.Fatal("the team is gone before the confirmation")
+	}
+
+	// A token is for one operation on one team.
+	if resp := remove("/v1", "Foxes", token); resp.StatusCode != http.StatusPreconditionRequired {
+		t.Fatalf("token for another team: status %d", resp.StatusCode)
+	}
+	if _, ok := findTeam("Foxes"); !ok {
+		t.Fatal("a token for Owls removed Foxes")
+	}
+	// That spent it.
+	if resp := remove("/v1", "Owls", token); resp.StatusCode != http.StatusPreconditionRequired {
+		t.Fatalf("spent token: status %d", resp.StatusCode)
+	}
+
+	token = remove("/v2", "Owls", "").Header.Get(confirmHeader)
+	if resp := remove("/v2", "Owls", token); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("confirmed: status %d", resp.StatusCode)
+	}
+	if _, ok := findTeam("Owls"); ok {
+		t.Fatal("the confirmed removal did not happen")
+	}
+
+	token = remove("/v2", "Foxes", "").Header.Get(confirmHeader)
+	AdvanceClock(t, confirmationTTL+time.Second)
+	if resp := remove("/v2", "Foxes", token); resp.StatusCode != http.StatusPreconditionRequired {
+		t.Fatalf("expired token: status %d", resp.StatusCode)
+	}
+}
+
+func TestConfirmationNeedsAuth(t *testing.T) {
+	s := StartTestServer(t)
+	s.MustDo(t, http.MethodPost, "/v2/teams", map[string]string{"name": "Owls"})
+	req := s.NewRequest(t, http.MethodDelete, "/v2/teams/Owls", nil)
+	req.Header.Del("X-API-Key")
+	resp := s.Send(t, req, nil)
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get(confirmHeader) != "" {
+		t.Fatalf("without a key: status %d, token %q", resp.StatusCode, resp.Header.Get(confirmHeader))
+	}
+}
+
+// TestConfirmDestructive checks that ending the session and removing bank
+// and queue entries wait for a confirmation, over HTTP and in the CLI.
+func TestConfirmDestructive(t *testing.T) {
+	s := StartTestServer(t)
+	s.MustDo(t, http.MethodPost, "/session/start", map[string]string{"name": "Jeseň"})
+	var ids []int
+	for _, q := range []string{"Hlavné mesto?", "Najdlhšia rieka?"} {
+		e, err := createBankEntry(BankEntry{Question: q, Type: "pomoc", TimeLeft: FlexDuration(30 * time.Second)}, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, e.ID)
+	}
+	queued, err := enqueue([]QueueEntry{{Question: "Najvyšší vrch?", TimeLeft: 30 * time.Second}}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		body         interface{}
+		summary      string
+		done         func() bool
+	}{
+		{http.MethodPost, "/session/end", nil, "archive session #", func() bool { return currentSessions().Active == nil }},
+		{http.MethodDelete, fmt.Sprintf("/bank/%d", ids[0]), nil, `"Hlavné mesto?"`, func() bool { _, ok := findBankEntry(ids[0]); return !ok }},
+		{http.MethodPost, "/bank/duplicates/resolve", map[string][]int{"remove": {ids[1]}}, `"Najdlhšia rieka?"`, func() bool { _, ok := findBankEntry(ids[1]); return !ok }},
+		{http.MethodDelete, fmt.Sprintf("/queue/%d", queued[0].ID), nil, `"Najvyšší vrch?"`, func() bool { return len(listQueue()) == 0 }},
+	} {
+		var res struct {
+			Error APIError `json:"error"`
+		}
+		resp := s.Send(t, s.NewRequest(t, tc.method, "/v2"+tc.path, tc.body), &res)
+		token := resp.Header.Get(confirmHeader)
+		if resp.StatusCode != http.StatusPreconditionRequired || token == "" || res.Error.Details["token"] != token || !strings.Contains(res.Error.Message, tc.summary) {
+			t.Errorf("%s %s: status %d, %+v", tc.method, tc.path, resp.StatusCode, res.Error)
+		}
+		if tc.done() {
+			t.Fatalf("%s %s went ahead unconfirmed", tc.method, tc.path)
+		}
+		req := s.NewRequest(t, tc.method, "/v2"+tc.path, tc.body)
+		req.Header.Set(confirmHeader, token)
+		if resp := s.Send(t, req, nil); resp.StatusCode/100 != 2 || !tc.done() {
+			t.Errorf("%s %s confirmed: status %d", tc.method, tc.path, resp.StatusCode)
+		}
+	}
+
+	// Without anyone at the terminal to say yes, the CLI declines.
+	s.MustDo(t, http.MethodPost, "/session/start", map[string]string{"name": "Zima"})
+	defer endSession("test")
+	e, err := createBankEntry(BankEntry{Question: "Hlavné mesto?", Type: "pomoc", TimeLeft: FlexDuration(30 * time.Second)}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err = enqueue([]QueueEntry{{Question: "Najvyšší vrch?", TimeLeft: 30 * time.Second}}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	declined := len(auditEntries("confirm_decline"))
+	for _, line := range []string{"session end", "stats reset", fmt.Sprintf("bank rm %d", e.ID), fmt.Sprintf("queue rm %d", queued[0].ID)} {
+		if err := cliCommand(t, line); err == nil || !strings.Contains(err.Error(), "cancelled") {
+			t.Errorf("%q: %v", line, err)
+		}
+	}
+	if n := len(auditEntries("confirm_decline")) - declined; n != 4 {
+		t.Errorf("%d declines audited", n)
+	}
+	if _, ok := findBankEntry(e.ID); !ok || currentSessions().Active == nil || len(listQueue()) != 1 {
+		t.Error("a declined command went ahead")
+	}
+}
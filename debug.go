@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+const maxInternalEvents = 10
+
+// InternalEvent is a state transition kept for diagnostics.
+type InternalEvent struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	Revision uint64    `json:"revision"`
+}
+
+// ArmedTimer is a pending timed action and when it is due.
+type ArmedTimer struct {
+	Name   string    `json:"name"`
+	FireAt time.Time `json:"fire_at"`
+}
+
+// DebugState is the diagnostic document served at /debug/state.
+type DebugState struct {
+	Revision      uint64          `json:"revision"`
+	Timers        []ArmedTimer    `json:"timers"`
+	Subscribers   map[string]int  `json:"subscribers"`
+	Streams       StreamStats     `json:"streams"`
+	PendingPushes int             `json:"pending_pushes"`
+	RecentEvents  []InternalEvent `json:"recent_events"`
+	Modes         map[string]bool `json:"modes"`
+	Workers       []WorkerStatus  `json:"workers"`
+	Goroutines    int             `json:"goroutines"`
+	GoroutineDump string          `json:"goroutine_dump,omitempty"`
+	ServerTime    time.Time       `json:"server_time"`
+}
+
+var (
+	debugMutex     sync.Mutex
+	internalEvents []InternalEvent
+	armedTimers    = map[string]time.Time{}
+)
+
+// noteInternalEvent remembers a state transition for /debug/state and logs
+// it while verbose event logging is on.
+func noteInternalEvent(kind string, rev uint64) {
+	debugMutex.Lock()
+	defer debugMutex.Unlock()
+	ev := InternalEvent{Time: clock.Now(), Kind: kind, Revision: rev}
+	internalEvents = append(internalEvents, ev)
+	logInternalEvent(ev)
+	if len(internalEvents) > maxInternalEvents {
+		internalEvents = internalEvents[len(internalEvents)-maxInternalEvents:]
+	}
+}
+
+// armTimer registers a pending timed action so it shows up in diagnostics.
+func armTimer(name string, at time.Time) {
+	debugMutex.Lock()
+	defer debugMutex.Unlock()
+	armedTimers[name] = at
+}
+
+func disarmTimer(name string) {
+	debugMutex.Lock()
+	defer debugMutex.Unlock()
+	delete(armedTimers, name)
+}
+
+func collectDebugState(withGoroutines bool) DebugState {
+	questionMutex.RLock()
+	rev := revision
+	paused := question.Paused
+	questionMutex.RUnlock()
+
+	state := DebugState{
+		Revision:      rev,
+		Timers:        []ArmedTimer{},
+		Subscribers:   hub.counts(),
+		Streams:       hub.streamStats(),
+		PendingPushes: pendingPushCount(),
+		Modes: map[string]bool{
+			"paused":    paused,
+			"ceremony":  ceremonyActive(),
+			"replay":    currentReplay() != nil,
+			"recording": recorder != nil,
+		},
+		Workers:    workers.statuses(),
+		Goroutines: runtime.NumGoroutine(),
+		ServerTime: clock.Now(),
+	}
+
+	debugMutex.Lock()
+	for name, at := range armedTimers {
+		state.Timers = append(state.Timers, ArmedTimer{Name: name, FireAt: at})
+	}
+	state.RecentEvents = append([]InternalEvent(nil), internalEvents...)
+	debugMutex.Unlock()
+	sort.Slice(state.Timers, func(i, j int) bool { return state.Timers[i].FireAt.Before(state.Timers[j].FireAt) })
+
+	if withGoroutines {
+		var buf bytes.Buffer
+		if p := pprof.Lookup("goroutine"); p != nil {
+			p.WriteTo(&buf, 1)
+		}
+		state.GoroutineDump = buf.String()
+	}
+	return state
+}
+
+func getDebugState(c echo.Context) error {
+	withGoroutines := c.QueryParam("pprof") == "goroutines"
+	return c.JSON(http.StatusOK, collectDebugState(withGoroutines))
+}
+
+func printDebugState() {
+	info := color.New(color.FgYellow)
+	state := collectDebugState(false)
+
+	info.Printf("Revision: %d  Goroutines: %d  Pending pushes: %d\n", state.Revision, state.Goroutines, state.PendingPushes)
+	info.Print("Modes:")
+	for _, m := range []string{"paused", "ceremony", "replay", "recording"} {
+		info.Printf(" %s=%v", m, state.Modes[m])
+	}
+	info.Println()
+	info.Println(streamSummary(state.Streams))
+	for _, w := range state.Workers {
+		switch {
+		case w.Degraded:
+			info.Printf("Worker %-18s DEGRADED after %d restart(s): %s\n", w.Name, w.Restarts, w.LastPanic)
+		case w.Restarts > 0:
+			info.Printf("Worker %-18s running, %d restart(s), last: %s\n", w.Name, w.Restarts, w.LastPanic)
+		}
+	}
+	if len(state.Timers) == 0 {
+		info.Println("Timers: none armed")
+	}
+	for _, t := range state.Timers {
+		info.Printf("Timer %-16s fires in %s\n", t.Name, t.FireAt.Sub(state.ServerTime).Round(time.Millisecond))
+	}
+	for _, ev := range state.RecentEvents {
+		info.Printf("  #%d %-14s %s\n", ev.Revision, ev.Kind, ev.Time.Local().Format("15:04:05.000"))
+	}
+}
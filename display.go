@@ -0,0 +1,140 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// The venue display normally comes from the Flask server. GET /display is
+// a fallback served from this binary for when that server is down: one
+// self-contained page, no external assets, fed by /display/events.
+//
+//go:embed display/index.html
+var displayPage []byte
+
+// DisplayView is what the fallback display shows: the public question and
+// the scoreboard.
+type DisplayView struct {
+	Question   PublicQuestionView `json:"question"`
+	Scoreboard Scoreboard         `json:"scoreboard"`
+}
+
+func displayView(lang string) DisplayView {
+	questionMutex.RLock()
+	q := localizedQuestion(publicQuestion(question), lang)
+	questionMutex.RUnlock()
+	return DisplayView{Question: q, Scoreboard: currentScoreboard()}
+}
+
+func getDisplay(c echo.Context) error {
+	return c.Blob(http.StatusOK, echo.MIMETextHTMLCharsetUTF8, displayPage)
+}
+
+// getDisplayEvents streams the display view as server-sent events, on
+// every change and every countdown tick, which doubles as the heartbeat
+// the page watches for.
+func getDisplayEvents(c echo.Context) error {
+	lang := c.QueryParam("lang")
+	s, err := hub.join("display", false, nil, false)
+	if err != nil {
+		return streamsFull()
+	}
+	defer hub.unsubscribe(s)
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func() bool {
+		body, err := json.Marshal(displayView(lang))
+		if err != nil {
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "event: state\ndata: %s\n\n", body); err != nil {
+			return false
+		}
+		w.Flush()
+		return true
+	}
+	if !send() {
+		return nil
+	}
+
+	refresh := ticks.subscribe()
+	defer ticks.unsubscribe(refresh)
+	ctx := c.Request().Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hub.closing:
+			sendShutdownEvent(w)
+			return nil
+		case <-s.evicted:
+			sendEvictedEvent(w)
+			return nil
+		case <-s.ch:
+		case <-refresh:
+		}
+		if !send() {
+			return nil
+		}
+	}
+}
+
+// displayURLs lists where the fallback display can be opened: the listen
+// address when it names a host, otherwise this machine's addresses.
+func displayURLs() []string {
+	host, port, err := net.SplitHostPort(*listenAddr)
+	if err != nil {
+		return []string{"http://" + *listenAddr + "/display"}
+	}
+	if host != "" && host != "0.0.0.0" && host != "::" {
+		return []string{"http://" + net.JoinHostPort(host, port) + "/display"}
+	}
+	urls := []string{"http://" + net.JoinHostPort("localhost", port) + "/display"}
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.To4() == nil {
+			continue
+		}
+		urls = append(urls, "http://"+net.JoinHostPort(ipnet.IP.String(), port)+"/display")
+	}
+	return urls
+}
+
+// handleFailoverCommand runs "failover display [--no-push]". With
+// --no-push the -sync-target push target, the Flask server, is disabled
+// so its retries stop.
+func handleFailoverCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	noPush := len(args) == 2 && args[1] == "--no-push"
+	if len(args) == 0 || args[0] != "display" || (len(args) == 2 && !noPush) || len(args) > 2 {
+		return errors.New("Usage: failover display [--no-push]")
+	}
+	info.Println("Open the fallback display at:")
+	for _, u := range displayURLs() {
+		info.Printf("  %s\n", u)
+	}
+	if !noPush {
+		return nil
+	}
+	if !setPushTargetEnabled(*syncTarget, false) {
+		return fmt.Errorf("Unknown target: %s", *syncTarget)
+	}
+	audit("target_disable", origin, map[string]interface{}{"name": *syncTarget, "reason": "failover"})
+	success.Printf("Pushes to %s disabled, re-enable with target enable %s\n", *syncTarget, *syncTarget)
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestFallbackDisplay opens the built-in page and its stream without the
+// operator key, and checks that a new question comes down the stream.
+func TestFallbackDisplay(t *testing.T) {
+	s := StartTestServer(t)
+	resp, err := http.Get(s.URL + "/display")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("page: status %d, %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	// Nothing may come from the internet.
+	if strings.Contains(string(page), "http://") || strings.Contains(string(page), "https://") {
+		t.Error("the page loads something from outside")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/display/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("events: status %d, %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	r := bufio.NewReader(resp.Body)
+	next := func() DisplayView {
+		t.Helper()
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				var v DisplayView
+				if err := json.Unmarshal([]byte(data), &v); err != nil {
+					t.Fatal(err)
+				}
+				return v
+			}
+		}
+	}
+	next()
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Hlavné mesto?", "type": "pomoc", "time_left": 30_000_000_000})
+	eventually(t, "the question on the display", func() bool { return next().Question.Question == "Hlavné mesto?" })
+}
+
+// TestFailoverCommand checks the usage and that --no-push disables the
+// Flask target.
+func TestFailoverCommand(t *testing.T) {
+	StartTestServer(t)
+	defer setPushTargetEnabled(*syncTarget, true)
+	for _, line := range []string{"failover", "failover board", "failover display --push", "failover display --no-push x"} {
+		if err := cliCommand(t, line); err == nil {
+			t.Errorf("%q accepted", line)
+		}
+	}
+	if err := cliCommand(t, "failover display"); err != nil {
+		t.Fatal(err)
+	}
+	enabled := func() bool {
+		for _, st := range pushTargetStatuses() {
+			if st.Name == *syncTarget {
+				return st.Enabled
+			}
+		}
+		return false
+	}
+	if !enabled() {
+		t.Fatal("failover display disabled the target")
+	}
+	if err := cliCommand(t, "failover display --no-push"); err != nil {
+		t.Fatal(err)
+	}
+	if enabled() {
+		t.Error("the target is still enabled")
+	}
+	if e := lastAudit(t, "target_disable"); e.Details["name"] != "flask" || e.Details["reason"] != "failover" {
+		t.Errorf("audited as %+v", e)
+	}
+}
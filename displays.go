@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	displayCheckInterval = time.Second
+	// displayLagGrace is how long a display may show an old revision before
+	// it counts as behind. Renders are not instant.
+	displayLagGrace = 5 * time.Second
+)
+
+var (
+	displayTimeout   = flag.Duration("display-timeout", 10*time.Second, "alarm when a critical display hasn't sent a heartbeat for this long")
+	criticalDisplays = flag.String("critical-displays", "", "comma-separated display IDs that must stay in sync")
+)
+
+// Display is a client screen that reports what it is showing.
+type Display struct {
+	ID          string     `json:"id"`
+	Critical    bool       `json:"critical"`
+	LastSeen    *time.Time `json:"last_seen,omitempty"`
+	Revision    uint64     `json:"revision"`
+	Lag         uint64     `json:"lag"`
+	BehindSince *time.Time `json:"behind_since,omitempty"`
+	Alarm       string     `json:"alarm,omitempty"`
+
+	registered time.Time
+}
+
+// HeartbeatRequest is sent by display clients every few seconds.
+type HeartbeatRequest struct {
+	ClientID string `json:"client_id"`
+	Revision uint64 `json:"revision"`
+}
+
+var (
+	displays      = map[string]*Display{}
+	displaysMutex sync.Mutex
+)
+
+func configureDisplays() {
+	for _, id := range strings.Split(*criticalDisplays, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			setDisplayCritical(id, true)
+		}
+	}
+	workers.goWorker("displays", watchDisplays)
+}
+
+func currentRevision() uint64 {
+	questionMutex.RLock()
+	defer questionMutex.RUnlock()
+	return revision
+}
+
+func heartbeat(id string, rev uint64) {
+	now := clock.Now()
+	displaysMutex.Lock()
+	defer displaysMutex.Unlock()
+	d, ok := displays[id]
+	if !ok {
+		d = &Display{ID: id}
+		displays[id] = d
+	}
+	d.LastSeen = &now
+	d.Revision = rev
+}
+
+func setDisplayCritical(id string, critical bool) {
+	displaysMutex.Lock()
+	defer displaysMutex.Unlock()
+	d, ok := displays[id]
+	if !ok {
+		d = &Display{ID: id, registered: clock.Now()}
+		displays[id] = d
+	}
+	d.Critical = critical
+	if !critical {
+		d.Alarm = ""
+	}
+}
+
+// checkDisplays updates lag for every display and raises or clears alarms
+// for the critical ones.
+func checkDisplays() {
+	now := clock.Now()
+	current := currentRevision()
+
+	type change struct {
+		id, alarm, was string
+	}
+	var changes []change
+	alarmed := false
+
+	displaysMutex.Lock()
+	for _, d := range displays {
+		d.Lag = 0
+		if current > d.Revision {
+			d.Lag = current - d.Revision
+		}
+		if d.Lag > 1 {
+			if d.BehindSince == nil {
+				at := now
+				d.BehindSince = &at
+			}
+		} else {
+			d.BehindSince = nil
+		}
+		if !d.Critical {
+			continue
+		}
+
+		alarm := ""
+		switch {
+		case d.LastSeen == nil:
+			if now.Sub(d.registered) > *displayTimeout {
+				alarm = "never checked in"
+			}
+		case now.Sub(*d.LastSeen) > *displayTimeout:
+			alarm = fmt.Sprintf("silent for %s", now.Sub(*d.LastSeen).Round(time.Second))
+		case d.BehindSince != nil && now.Sub(*d.BehindSince) > displayLagGrace:
+			alarm = fmt.Sprintf("%d revisions behind", d.Lag)
+		}
+		if (alarm == "") != (d.Alarm == "") {
+			changes = append(changes, change{d.ID, alarm, d.Alarm})
+		}
+		d.Alarm = alarm
+		if alarm != "" {
+			alarmed = true
+		}
+	}
+	displaysMutex.Unlock()
+
+	for _, c := range changes {
+		if c.alarm != "" {
+			notify(SeverityError, "display", "Display %s is stale: %s", c.id, c.alarm)
+		} else {
+			notify(SeverityInfo, "display", "Display %s is back in sync", c.id)
+		}
+	}
+	setPromptAlarm(alarmed)
+}
+
+func watchDisplays() {
+	for {
+		<-clock.After(displayCheckInterval)
+		checkDisplays()
+	}
+}
+
+func listDisplays() []Display {
+	displaysMutex.Lock()
+	defer displaysMutex.Unlock()
+	out := make([]Display, 0, len(displays))
+	for _, d := range displays {
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func postHeartbeat(c echo.Context) error {
+	req := new(HeartbeatRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if req.ClientID == "" {
+		return badRequest("client_id is required")
+	}
+	heartbeat(req.ClientID, req.Revision)
+	return c.JSON(http.StatusOK, map[string]uint64{"revision": currentRevision()})
+}
+
+func getDisplays(c echo.Context) error {
+	return c.JSON(http.StatusOK, listDisplays())
+}
+
+func handleDisplayCommand(args []string, origin string) error {
+	errorC := color.New(color.FgRed)
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 0 {
+		list := listDisplays()
+		if len(list) == 0 {
+			info.Println("No displays have checked in")
+			return nil
+		}
+		now := clock.Now()
+		for _, d := range list {
+			seen := "never"
+			if d.LastSeen != nil {
+				seen = now.Sub(*d.LastSeen).Round(time.Second).String() + " ago"
+			}
+			c := info
+			if d.Alarm != "" {
+				c = errorC
+			}
+			mark := " "
+			if d.Critical {
+				mark = "!"
+			}
+			c.Printf(" %s %-16s rev %-5d lag %-3d seen %s %s\n", mark, d.ID, d.Revision, d.Lag, seen, d.Alarm)
+		}
+		return nil
+	}
+	if len(args) != 2 || (args[0] != "critical" && args[0] != "normal") {
+		return errors.New("Usage: display [critical|normal <id>]")
+	}
+	setDisplayCritical(args[1], args[0] == "critical")
+	success.Printf("Display %s is now %s\n", args[1], args[0])
+	return nil
+}
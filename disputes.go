@@ -0,0 +1,430 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// A dispute is a team contesting the ruling on a finished question. Opening
+// one freezes the evidence from the question's history record and the audit
+// log, so what the jury looks at can't change under them. The bundle is
+// never edited afterwards; resolving adds the ruling, and a score change
+// for it goes through the adjustments ledger naming the dispute. Disputes
+// belong to the game and are kept in backups and session archives.
+
+// Dispute is one contested ruling.
+type Dispute struct {
+	ID         int                `json:"id"`
+	Team       string             `json:"team"`
+	HistoryID  int                `json:"history_id"`
+	Reason     string             `json:"reason,omitempty"`
+	OpenedAt   time.Time          `json:"opened_at"`
+	Origin     string             `json:"origin"`
+	Evidence   DisputeEvidence    `json:"evidence"`
+	Resolution *DisputeResolution `json:"resolution,omitempty"`
+}
+
+// DisputeEvidence is the frozen bundle: the question as the audience saw
+// it and everything the team did on it. There is no per-team shuffle in
+// this server, every team saw the options in the order listed.
+type DisputeEvidence struct {
+	Question HistoryEntry `json:"question"`
+	// Answer is the team's final answer, Submissions every buzz, answer
+	// and lock of the team while the question was live.
+	Answer      *Answer      `json:"answer,omitempty"`
+	Submissions []AuditEntry `json:"submissions"`
+	BuzzOrder   []string     `json:"buzz_order"`
+	// BuzzPosition is the team's place in BuzzOrder from 1, 0 if it
+	// didn't buzz.
+	BuzzPosition    int             `json:"buzz_position,omitempty"`
+	Eliminated      bool            `json:"eliminated,omitempty"`
+	OptionsRevealed *time.Time      `json:"options_revealed,omitempty"`
+	FloorClosed     *time.Time      `json:"floor_closed,omitempty"`
+	Breakdown       *ScoreBreakdown `json:"breakdown,omitempty"`
+	Verdict         *Verdict        `json:"verdict,omitempty"`
+	ScoreDeltas     []ScoreDelta    `json:"score_deltas"`
+}
+
+// DisputeResolution is the ruling on a dispute.
+type DisputeResolution struct {
+	Ruling     string    `json:"ruling"`
+	Delta      int       `json:"delta,omitempty"`
+	Adjustment int       `json:"adjustment,omitempty"`
+	ResolvedAt time.Time `json:"resolved_at"`
+	Origin     string    `json:"origin"`
+}
+
+var (
+	disputesMutex sync.Mutex
+	disputes      []Dispute
+	nextDisputeID = 1
+)
+
+// disputeActions are the audit actions that count as a team's submissions.
+var disputeActions = map[string]bool{"buzz": true, "answer": true, "answer_lock": true, "answer_unlock": true, "eliminate": true}
+
+// freezeEvidence collects the bundle for team on history entry e.
+func freezeEvidence(e HistoryEntry, team string) DisputeEvidence {
+	r := e.Record
+	ev := DisputeEvidence{
+		Question:        e,
+		Submissions:     []AuditEntry{},
+		BuzzOrder:       r.BuzzOrder,
+		OptionsRevealed: r.OptionsRevealed,
+		FloorClosed:     r.FloorClosed,
+		ScoreDeltas:     []ScoreDelta{},
+	}
+	ev.Question.Record = nil
+	for i := range r.Answers {
+		if r.Answers[i].Team == team {
+			a := r.Answers[i]
+			ev.Answer = &a
+		}
+	}
+	for i, name := range r.BuzzOrder {
+		if name == team && ev.BuzzPosition == 0 {
+			ev.BuzzPosition = i + 1
+		}
+	}
+	for _, name := range r.Eliminated {
+		ev.Eliminated = ev.Eliminated || name == team
+	}
+	for i := range r.Breakdown {
+		if r.Breakdown[i].Team == team {
+			b := r.Breakdown[i]
+			ev.Breakdown = &b
+		}
+	}
+	for i := range r.Verdicts {
+		if r.Verdicts[i].Team == team {
+			v := r.Verdicts[i]
+			ev.Verdict = &v
+		}
+	}
+	for _, d := range r.ScoreDeltas {
+		if d.Team == team {
+			ev.ScoreDeltas = append(ev.ScoreDeltas, d)
+		}
+	}
+	for _, a := range auditEntries("") {
+		if !disputeActions[a.Action] || a.Time.Before(e.StartedAt) || a.Time.After(r.EndedAt) {
+			continue
+		}
+		if t, _ := a.Details["team"].(string); t == team {
+			ev.Submissions = append(ev.Submissions, a)
+		}
+	}
+	return ev
+}
+
+// openDispute freezes the evidence for team on history entry historyID.
+func openDispute(team string, historyID int, reason, origin string) (Dispute, error) {
+	t, ok := findTeam(team)
+	if !ok {
+		return Dispute{}, notFound("unknown team: " + team)
+	}
+	e, ok := findHistoryEntry(historyID)
+	if !ok {
+		return Dispute{}, notFound(fmt.Sprintf("history entry %d not found", historyID))
+	}
+	if e.Record == nil {
+		return Dispute{}, apiError(http.StatusConflict, "question_live", "the question is still live, it can be disputed once it ends")
+	}
+
+	disputesMutex.Lock()
+	for _, d := range disputes {
+		if d.Team == t.Name && d.HistoryID == historyID && d.Resolution == nil {
+			disputesMutex.Unlock()
+			return Dispute{}, apiError(http.StatusConflict, "dispute_open", fmt.Sprintf("%s already disputes question #%d", t.Name, historyID)).withDetail("id", d.ID)
+		}
+	}
+	d := Dispute{
+		ID:        nextDisputeID,
+		Team:      t.Name,
+		HistoryID: historyID,
+		Reason:    strings.TrimSpace(reason),
+		OpenedAt:  clock.Now(),
+		Origin:    origin,
+		Evidence:  freezeEvidence(e, t.Name),
+	}
+	nextDisputeID++
+	disputes = append(disputes, d)
+	disputesMutex.Unlock()
+
+	audit("dispute_open", origin, map[string]interface{}{"id": d.ID, "team": d.Team, "history_id": historyID})
+	hub.broadcastOperator(Event{Type: "dispute_opened", Data: d})
+	return d, nil
+}
+
+// resolveDispute records the ruling on dispute id and, for a non-zero
+// delta, the compensating adjustment.
+func resolveDispute(id int, ruling string, delta int, origin string) (Dispute, error) {
+	if ruling = strings.TrimSpace(ruling); ruling == "" {
+		return Dispute{}, apiError(http.StatusBadRequest, "ruling_required", "a resolution needs a ruling")
+	}
+	// Held throughout, so a dispute can't be resolved twice.
+	disputesMutex.Lock()
+	defer disputesMutex.Unlock()
+	i := -1
+	for j, d := range disputes {
+		if d.ID == id {
+			i = j
+		}
+	}
+	switch {
+	case i < 0:
+		return Dispute{}, notFound(fmt.Sprintf("dispute %d not found", id))
+	case disputes[i].Resolution != nil:
+		return Dispute{}, apiError(http.StatusConflict, "already_resolved", fmt.Sprintf("dispute %d is already resolved", id))
+	}
+	d := disputes[i]
+	res := &DisputeResolution{Ruling: ruling, Delta: delta, ResolvedAt: clock.Now(), Origin: origin}
+	if delta != 0 {
+		adjustmentsMutex.Lock()
+		a, err := addAdjustment(d.Team, delta, fmt.Sprintf("dispute #%d: %s", d.ID, ruling), 0, origin)
+		if err == nil {
+			n := len(adjustments) - 1
+			adjustments[n].Dispute, adjustments[n].QuestionID = d.ID, d.HistoryID
+			a = adjustments[n]
+		}
+		adjustmentsMutex.Unlock()
+		if err != nil {
+			return Dispute{}, adjustmentError(err)
+		}
+		res.Adjustment = a.ID
+	}
+	disputes[i].Resolution = res
+	d = disputes[i]
+
+	audit("dispute_resolve", origin, map[string]interface{}{"id": d.ID, "team": d.Team, "delta": delta, "ruling": ruling})
+	hub.broadcastOperator(Event{Type: "dispute_resolved", Data: d})
+	return d, nil
+}
+
+func listDisputes() []Dispute {
+	disputesMutex.Lock()
+	defer disputesMutex.Unlock()
+	return append([]Dispute{}, disputes...)
+}
+
+func findDispute(id int) (Dispute, bool) {
+	disputesMutex.Lock()
+	defer disputesMutex.Unlock()
+	for _, d := range disputes {
+		if d.ID == id {
+			return d, true
+		}
+	}
+	return Dispute{}, false
+}
+
+// installDisputes replaces the disputes, for restores and new games.
+func installDisputes(list []Dispute) {
+	disputesMutex.Lock()
+	defer disputesMutex.Unlock()
+	disputes = list
+	nextDisputeID = 1
+	for _, d := range list {
+		nextDisputeID = max(nextDisputeID, d.ID+1)
+	}
+}
+
+// DisputeRequest is the body of POST /disputes.
+type DisputeRequest struct {
+	Team      string `json:"team"`
+	HistoryID int    `json:"history_id"`
+	Reason    string `json:"reason"`
+}
+
+// ResolveRequest is the body of POST /disputes/:id/resolve. A non-zero
+// Delta is applied to the team's score.
+type ResolveRequest struct {
+	Ruling string `json:"ruling"`
+	Delta  int    `json:"delta"`
+}
+
+func getDisputes(c echo.Context) error {
+	return c.JSON(http.StatusOK, listDisputes())
+}
+
+func postDispute(c echo.Context) error {
+	req := new(DisputeRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	d, err := openDispute(req.Team, req.HistoryID, req.Reason, requestOrigin(c))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, d)
+}
+
+func getDispute(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return badRequest("invalid dispute id")
+	}
+	d, ok := findDispute(id)
+	if !ok {
+		return notFound(fmt.Sprintf("dispute %d not found", id))
+	}
+	return c.JSON(http.StatusOK, d)
+}
+
+func postResolveDispute(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return badRequest("invalid dispute id")
+	}
+	req := new(ResolveRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	d, err := resolveDispute(id, req.Ruling, req.Delta, requestOrigin(c))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, d)
+}
+
+// printDispute prints a dispute and its evidence bundle.
+func printDispute(d Dispute) {
+	info := color.New(color.FgYellow)
+	success := color.New(color.FgGreen)
+
+	ev := d.Evidence
+	q := ev.Question
+	info.Printf("Dispute #%d: %s on question #%d, opened %s\n", d.ID, d.Team, d.HistoryID, d.OpenedAt.Local().Format("15:04:05"))
+	if d.Reason != "" {
+		info.Printf("  Reason:    %s\n", d.Reason)
+	}
+	info.Printf("  Question:  %s (%s, %s, started %s)\n", q.Question, q.Type, q.TimeLeft, q.StartedAt.Local().Format("15:04:05"))
+	for i, o := range q.Options {
+		info.Printf("    %s) %s\n", optionLabel(i), o)
+	}
+	langs := make([]string, 0, len(q.Variants))
+	for lang := range q.Variants {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	for _, lang := range langs {
+		info.Printf("    [%s] %s\n", lang, q.Variants[lang])
+	}
+	if ev.OptionsRevealed != nil {
+		info.Printf("  Options shown at %s\n", ev.OptionsRevealed.Local().Format("15:04:05.000"))
+	}
+	if ev.Answer != nil {
+		info.Printf("  Answer:    %q at %s, %s in, %s left\n", ev.Answer.Answer, ev.Answer.Time.Local().Format("15:04:05.000"), ev.Answer.Latency.Round(time.Millisecond), ev.Answer.Remaining.Round(time.Millisecond))
+	} else {
+		info.Println("  Answer:    none")
+	}
+	for _, a := range ev.Submissions {
+		detail := ""
+		if text, ok := a.Details["answer"].(string); ok {
+			detail = " " + strconv.Quote(text)
+		}
+		info.Printf("    %s %s\n", a.Time.Local().Format("15:04:05.000"), strings.TrimSpace(fmt.Sprintf("%-13s%s", a.Action, detail)))
+	}
+	if len(ev.BuzzOrder) > 0 {
+		info.Printf("  Buzz order: %s (team %s)\n", strings.Join(ev.BuzzOrder, ", "), buzzPlace(ev.BuzzPosition))
+	}
+	if ev.Eliminated {
+		info.Println("  Eliminated")
+	}
+	if ev.FloorClosed != nil {
+		info.Printf("  Floor closed at %s\n", ev.FloorClosed.Local().Format("15:04:05.000"))
+	}
+	if ev.Verdict != nil {
+		info.Printf("  Verdict:   %s (auto %s)\n", ev.Verdict.Status, ev.Verdict.Auto)
+	}
+	if ev.Breakdown != nil {
+		info.Printf("  Worth:     %d if correct\n", ev.Breakdown.Points)
+	}
+	for _, s := range ev.ScoreDeltas {
+		info.Printf("  Scored:    %+d at %s\n", s.Delta, s.Time.Local().Format("15:04:05"))
+	}
+	if r := d.Resolution; r != nil {
+		success.Printf("  Resolved %s: %s", r.ResolvedAt.Local().Format("15:04:05"), r.Ruling)
+		if r.Delta != 0 {
+			success.Printf(" (%+d, adjustment #%d)", r.Delta, r.Adjustment)
+		}
+		success.Println()
+	}
+}
+
+func buzzPlace(n int) string {
+	if n == 0 {
+		return "did not buzz"
+	}
+	return "#" + strconv.Itoa(n)
+}
+
+// handleDisputeCommand runs "dispute [<team> <history id> [reason] | show
+// <id> | resolve <id> [+/-points] <ruling>]".
+func handleDisputeCommand(args []string, origin string) error {
+	info := color.New(color.FgYellow)
+	success := color.New(color.FgGreen)
+
+	switch {
+	case len(args) == 0:
+		list := listDisputes()
+		if len(list) == 0 {
+			info.Println("No disputes this game")
+			return nil
+		}
+		for _, d := range list {
+			state := "open"
+			if d.Resolution != nil {
+				state = "resolved: " + d.Resolution.Ruling
+			}
+			info.Printf("  #%-3d %-12s question #%-4d %s\n", d.ID, d.Team, d.HistoryID, state)
+		}
+	case args[0] == "show" && len(args) == 2:
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errors.New("Usage: dispute show <id>")
+		}
+		d, ok := findDispute(id)
+		if !ok {
+			return fmt.Errorf("Dispute %d not found", id)
+		}
+		printDispute(d)
+	case args[0] == "resolve" && len(args) >= 3:
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errors.New("Usage: dispute resolve <id> [+/-points] <ruling>")
+		}
+		rest, delta := args[2:], 0
+		if n, err := strconv.Atoi(rest[0]); err == nil && len(rest) > 1 && strings.ContainsAny(rest[0][:1], "+-") {
+			rest, delta = rest[1:], n
+		}
+		d, err := resolveDispute(id, strings.Join(rest, " "), delta, origin)
+		if err != nil {
+			return err
+		}
+		success.Printf("Dispute #%d resolved\n", d.ID)
+		printDispute(d)
+	case len(args) >= 2:
+		id, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+		if err != nil {
+			return errors.New("Usage: dispute <team> <history id> [reason]")
+		}
+		d, err := openDispute(args[0], id, strings.Join(args[2:], " "), origin)
+		if err != nil {
+			return err
+		}
+		printDispute(d)
+	default:
+		return errors.New("Usage: dispute [<team> <history id> [reason] | show <id> | resolve <id> [+/-points] <ruling>]")
+	}
+	return nil
+}
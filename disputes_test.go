@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestDisputes plays a question, disputes it for one team over HTTP and
+// for another through the CLI, and rules on both.
+func TestDisputes(t *testing.T) {
+	s := StartTestServer(t)
+	for _, name := range []string{"Sovy", "Líšky"} {
+		if err := addTeam(Team{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.MustDo(t, http.MethodPost, "/v2/set-question", map[string]interface{}{
+		"question": "Najdlhšia rieka?", "type": "pomoc", "time_left": 30,
+		"options": []AnswerOption{{Text: "Dunaj"}, {Text: "Váh"}, {Text: "Hron"}},
+		"scoring": map[string]interface{}{"mode": "static", "points": 10},
+	})
+	AdvanceClock(t, 2*time.Second)
+	s.MustDo(t, http.MethodPost, "/buzz", TeamRequest{Team: "Líšky"})
+	s.Do(t, http.MethodPost, "/buzz/reset", nil, nil)
+	s.MustDo(t, http.MethodPost, "/buzz", TeamRequest{Team: "Sovy"})
+	s.MustDo(t, http.MethodPost, "/answer", AnswerRequest{Team: "Sovy", Answer: "Váh"})
+	AdvanceClock(t, time.Second)
+	s.MustDo(t, http.MethodPost, "/answer", AnswerRequest{Team: "Sovy", Answer: "Dunaj"})
+	live := liveHistoryID()
+
+	dispute := func(team string, id int) (int, Dispute, APIError) {
+		t.Helper()
+		var res struct {
+			Dispute
+			Error APIError `json:"error"`
+		}
+		status := s.Do(t, http.MethodPost, "/v2/disputes", DisputeRequest{Team: team, HistoryID: id, Reason: "Dunaj sme mali skôr"}, &res)
+		return status, res.Dispute, res.Error
+	}
+	if status, _, e := dispute("Sovy", live); status != http.StatusConflict || e.Code != "question_live" {
+		t.Errorf("a live question: status %d, %+v", status, e)
+	}
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Ďalšia", "type": "pomoc", "time_left": 30_000_000_000})
+	for _, bad := range []struct {
+		team string
+		id   int
+	}{{"Orly", live}, {"Sovy", 99}} {
+		if status, _, _ := dispute(bad.team, bad.id); status != http.StatusNotFound {
+			t.Errorf("%s on #%d: status %d", bad.team, bad.id, status)
+		}
+	}
+
+	status, d, e := dispute("sovy", live)
+	if status != http.StatusCreated {
+		t.Fatalf("opening: status %d, %+v", status, e)
+	}
+	ev := d.Evidence
+	var submissions []string
+	for _, a := range ev.Submissions {
+		submissions = append(submissions, fmt.Sprint(a.Action, " ", a.Details["answer"]))
+	}
+	if d.Team != "Sovy" || ev.Question.Question != "Najdlhšia rieka?" || len(ev.Question.Options) != 3 || ev.Question.Record != nil {
+		t.Errorf("question %+v", ev.Question)
+	}
+	if ev.Answer == nil || ev.Answer.Answer != "Dunaj" || ev.BuzzPosition != 2 || fmt.Sprint(ev.BuzzOrder) != "[Líšky Sovy]" {
+		t.Errorf("answer %+v, buzz #%d of %v", ev.Answer, ev.BuzzPosition, ev.BuzzOrder)
+	}
+	if fmt.Sprint(submissions) != "[buzz <nil> answer Váh answer Dunaj]" {
+		t.Errorf("submissions %v", submissions)
+	}
+	if ev.Breakdown == nil || ev.Breakdown.Points != 10 {
+		t.Errorf("breakdown %+v", ev.Breakdown)
+	}
+	if e := lastAudit(t, "dispute_open"); e.Details["id"] != d.ID || e.Details["team"] != "Sovy" {
+		t.Errorf("audited as %+v", e)
+	}
+	if status, _, e := dispute("Sovy", live); status != http.StatusConflict || e.Code != "dispute_open" {
+		t.Errorf("a second dispute: status %d, %+v", status, e)
+	}
+
+	resolve := func(id int, body ResolveRequest) (int, Dispute, APIError) {
+		t.Helper()
+		var res struct {
+			Dispute
+			Error APIError `json:"error"`
+		}
+		status := s.Do(t, http.MethodPost, fmt.Sprintf("/v2/disputes/%d/resolve", id), body, &res)
+		return status, res.Dispute, res.Error
+	}
+	if status, _, e := resolve(d.ID, ResolveRequest{Delta: 10}); status != http.StatusBadRequest || e.Code != "ruling_required" {
+		t.Errorf("without a ruling: status %d, %+v", status, e)
+	}
+	status, resolved, e := resolve(d.ID, ResolveRequest{Ruling: "uznané", Delta: 10})
+	if status != http.StatusOK || resolved.Resolution == nil || resolved.Resolution.Adjustment == 0 {
+		t.Fatalf("resolving: status %d, %+v, %+v", status, resolved.Resolution, e)
+	}
+	list := listAdjustments()
+	if a := list[len(list)-1]; a.ID != resolved.Resolution.Adjustment || a.Team != "Sovy" || a.Delta != 10 || a.Dispute != d.ID || a.QuestionID != live {
+		t.Errorf("adjustment %+v", a)
+	}
+	if team, _ := findTeam("Sovy"); team.Score != 10 {
+		t.Errorf("score %d", team.Score)
+	}
+	if status, _, e := resolve(d.ID, ResolveRequest{Ruling: "znova"}); status != http.StatusConflict || e.Code != "already_resolved" {
+		t.Errorf("resolving twice: status %d, %+v", status, e)
+	}
+	var got Dispute
+	s.Do(t, http.MethodGet, fmt.Sprintf("/disputes/%d", d.ID), nil, &got)
+	if got.Resolution == nil || got.Evidence.Answer == nil || got.Evidence.Answer.Answer != "Dunaj" {
+		t.Errorf("GET %+v", got)
+	}
+
+	// The CLI opens a dispute for the team that didn't answer and rejects it.
+	if err := cliCommand(t, fmt.Sprintf("dispute Líšky #%d nemali sme čas", live)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cliCommand(t, "dispute resolve 2 zamietnuté"); err != nil {
+		t.Fatal(err)
+	}
+	var all []Dispute
+	s.Do(t, http.MethodGet, "/disputes", nil, &all)
+	if len(all) != 2 || all[1].Reason != "nemali sme čas" || all[1].Evidence.Answer != nil || all[1].Evidence.BuzzPosition != 1 ||
+		all[1].Resolution == nil || all[1].Resolution.Ruling != "zamietnuté" || all[1].Resolution.Adjustment != 0 {
+		t.Errorf("disputes %+v", all)
+	}
+	for _, line := range []string{"dispute show x", "dispute show 9", "dispute Sovy x", "dispute resolve 1"} {
+		if err := cliCommand(t, line); err == nil {
+			t.Errorf("%q accepted", line)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+var checkFlag = flag.Bool("check", false, "run the doctor checks before starting and exit if any of them fail")
+
+const (
+	// doctorSlowTarget is the push latency above which a target is flagged.
+	doctorSlowTarget = 500 * time.Millisecond
+	// doctorSkewWarn and doctorSkewFail bound the clock difference to a
+	// target. The Date header only has whole seconds.
+	doctorSkewWarn = 2 * time.Second
+	doctorSkewFail = 30 * time.Second
+)
+
+// Check outcomes, from best to worst.
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// CheckResult is one row of the doctor table.
+type CheckResult struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+// runDoctor runs every check. live says whether this server is already
+// listening, which changes what the bind check can test.
+func runDoctor(live bool) []CheckResult {
+	results := []CheckResult{checkBind(*listenAddr, live), checkConfig()}
+
+	var targets []PushTarget
+	if live {
+		for _, s := range pushTargetStatuses() {
+			targets = append(targets, s.PushTarget)
+		}
+	} else if configured, err := configuredTargets(); err == nil {
+		targets = configured
+	}
+	client := &http.Client{Timeout: pushTimeout}
+	for _, t := range targets {
+		results = append(results, checkTarget(client, t), checkClockSkew(client, t))
+	}
+
+	results = append(results,
+		checkWritable("history", *historyPath, true),
+		checkWritable("command history", commandLogPath(), true),
+		checkWritable("recording", *recordPath, false),
+		checkWritable("bank", *bankFile, false),
+	)
+	return results
+}
+
+// checkBind makes sure the HTTP address can be used. Before startup it
+// binds the port itself; once running it dials the server instead.
+func checkBind(addr string, live bool) CheckResult {
+	r := CheckResult{Name: "bind " + addr}
+	if live {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			r.Status, r.Detail = checkFail, err.Error()
+			return r
+		}
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), time.Second)
+		if err != nil {
+			r.Status, r.Detail = checkFail, "server is not accepting connections: "+err.Error()
+			return r
+		}
+		conn.Close()
+		r.Status, r.Detail = checkPass, "server is listening"
+		return r
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		r.Status, r.Detail = checkFail, err.Error()
+		return r
+	}
+	ln.Close()
+	r.Status, r.Detail = checkPass, "port is free"
+	return r
+}
+
+// checkConfig validates the flags that are only read later, so mistakes show
+// up before the show rather than during it.
+func checkConfig() CheckResult {
+	r := CheckResult{Name: "config"}
+	var fails, warns []string
+
+	if targets, err := configuredTargets(); err != nil {
+		fails = append(fails, "targets: "+err.Error())
+	} else {
+		for _, t := range targets {
+			if t.SchemaVersion == 0 {
+				t.SchemaVersion = 1
+			}
+			if err := validatePushTarget(t); err != nil {
+				fails = append(fails, fmt.Sprintf("target %s: %v", t.Name, err))
+			}
+		}
+	}
+	if *stormLimit < 0 {
+		fails = append(fails, "-storm-limit is negative")
+	}
+	if *stormLimit > 0 && *stormWindow <= 0 {
+		fails = append(fails, "-storm-window must be positive")
+	}
+	if *autoWaitFlag < 0 {
+		fails = append(fails, "-auto-wait is negative")
+	}
+	if *displayTimeout <= 0 {
+		fails = append(fails, "-display-timeout must be positive")
+	}
+	if *judgeExtension <= 0 && len(judgeFlags) > 0 {
+		fails = append(fails, "-judge-extension must be positive")
+	}
+	if len(judgeFlags) > 0 && len(judgeFlags) < judgeQuorum {
+		warns = append(warns, fmt.Sprintf("%d judge(s) can never reach the quorum of %d", len(judgeFlags), judgeQuorum))
+	}
+	if *idleLockTime > 0 && *lockPIN == "" {
+		warns = append(warns, "-idle-lock has no effect without -lock-pin")
+	}
+	if !operatorsEnabled() {
+		warns = append(warns, "no -api-key, operator endpoints are disabled")
+	}
+
+	switch {
+	case len(fails) > 0:
+		r.Status, r.Detail = checkFail, strings.Join(append(fails, warns...), "; ")
+	case len(warns) > 0:
+		r.Status, r.Detail = checkWarn, strings.Join(warns, "; ")
+	default:
+		r.Status, r.Detail = checkPass, "ok"
+	}
+	return r
+}
+
+// probeTarget sends a HEAD request to the target. It never posts, so the
+// target's state is left alone; any HTTP answer means it is reachable.
+func probeTarget(client *http.Client, t PushTarget) (*http.Response, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodHead, t.URL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if t.AuthHeader != "" {
+		req.Header.Set("Authorization", t.AuthHeader)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp.Body.Close()
+	return resp, time.Since(start), nil
+}
+
+// checkTarget reports whether a push target answers and how quickly.
+func checkTarget(client *http.Client, t PushTarget) CheckResult {
+	r := CheckResult{Name: "target " + t.Name}
+	if !t.Enabled {
+		r.Status, r.Detail = checkWarn, "disabled"
+		return r
+	}
+	resp, latency, err := probeTarget(client, t)
+	if err != nil {
+		r.Status, r.Detail = checkFail, err.Error()
+		return r
+	}
+	latency = latency.Round(time.Millisecond)
+	switch {
+	case resp.StatusCode >= 500:
+		r.Status, r.Detail = checkWarn, fmt.Sprintf("HTTP %d in %s", resp.StatusCode, latency)
+	case latency > doctorSlowTarget:
+		r.Status, r.Detail = checkWarn, fmt.Sprintf("slow, %s", latency)
+	default:
+		r.Status, r.Detail = checkPass, fmt.Sprintf("HTTP %d in %s", resp.StatusCode, latency)
+	}
+	return r
+}
+
+// checkClockSkew compares our clock with the target's Date header. Push
+// targets work out the countdown from start_time, so skew shows on screen.
+func checkClockSkew(client *http.Client, t PushTarget) CheckResult {
+	r := CheckResult{Name: "clock " + t.Name}
+	if !t.Enabled {
+		r.Status, r.Detail = checkWarn, "disabled"
+		return r
+	}
+	sent := clock.Now()
+	resp, latency, err := probeTarget(client, t)
+	if err != nil {
+		r.Status, r.Detail = checkFail, "unreachable"
+		return r
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		r.Status, r.Detail = checkWarn, "no Date header"
+		return r
+	}
+	skew := date.Sub(sent.Add(latency / 2)).Round(time.Second)
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	detail := fmt.Sprintf("%+ds", int(skew.Seconds()))
+	switch {
+	case abs >= doctorSkewFail:
+		r.Status, r.Detail = checkFail, detail
+	case abs >= doctorSkewWarn:
+		r.Status, r.Detail = checkWarn, detail
+	default:
+		r.Status, r.Detail = checkPass, detail
+	}
+	return r
+}
+
+// checkWritable makes sure path can be written without changing it: an
+// existing file is opened for appending, otherwise a scratch file is created
+// next to where it will go. makesDir says the directory is created on use.
+func checkWritable(label, path string, makesDir bool) CheckResult {
+	r := CheckResult{Name: label}
+	if path == "" {
+		r.Status, r.Detail = checkPass, "not configured"
+		return r
+	}
+	r.Name = label + " " + path
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err == nil {
+		f.Close()
+		r.Status, r.Detail = checkPass, "writable"
+		return r
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		r.Status, r.Detail = checkFail, err.Error()
+		return r
+	}
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		if makesDir {
+			r.Status, r.Detail = checkPass, "directory "+dir+" will be created"
+		} else {
+			r.Status, r.Detail = checkFail, "directory "+dir+" does not exist"
+		}
+		return r
+	}
+	tmp, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		r.Status, r.Detail = checkFail, err.Error()
+		return r
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	r.Status, r.Detail = checkPass, "directory is writable"
+	return r
+}
+
+// printDoctor prints the results as a table and returns the number of
+// failed checks.
+func printDoctor(results []CheckResult) int {
+	colors := map[string]*color.Color{
+		checkPass: color.New(color.FgGreen),
+		checkWarn: color.New(color.FgYellow),
+		checkFail: color.New(color.FgRed),
+	}
+	width := 0
+	for _, r := range results {
+		if len(r.Name) > width {
+			width = len(r.Name)
+		}
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Status == checkFail {
+			failed++
+		}
+		colors[r.Status].Printf("  %-4s  %-*s  %s\n", strings.ToUpper(r.Status), width, r.Name, r.Detail)
+	}
+	return failed
+}
+
+// runStartupCheck runs the doctor for -check and exits when a check fails.
+func runStartupCheck() {
+	if failed := printDoctor(runDoctor(false)); failed > 0 {
+		color.New(color.FgRed).Printf("%d check(s) failed\n", failed)
+		os.Exit(1)
+	}
+}
+
+func handleDoctorCommand() error {
+	if failed := printDoctor(runDoctor(true)); failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	color.New(color.FgGreen).Println("All checks passed")
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDoctorWritable(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "history.json")
+	if err := os.WriteFile(existing, []byte("[]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		path     string
+		makesDir bool
+		status   string
+		detail   string
+	}{
+		{"", false, checkPass, "not configured"},
+		{existing, false, checkPass, "writable"},
+		{filepath.Join(dir, "bank.json"), false, checkPass, "directory is writable"},
+		{filepath.Join(dir, "state", "commands.jsonl"), true, checkPass, "will be created"},
+		{filepath.Join(dir, "missing", "show.rec"), false, checkFail, "does not exist"},
+	} {
+		r := checkWritable("file", c.path, c.makesDir)
+		if r.Status != c.status || !strings.Contains(r.Detail, c.detail) {
+			t.Errorf("%q: %+v", c.path, r)
+		}
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "[]" {
+		t.Errorf("the check changed the file: %q", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("the check left files behind: %v", entries)
+	}
+}
+
+func TestDoctorTargets(t *testing.T) {
+	StartTestServer(t)
+	skew := time.Duration(0)
+	var method string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		w.Header().Set("Date", clock.Now().Add(skew).Format(http.TimeFormat))
+	}))
+	defer target.Close()
+	client := &http.Client{Timeout: time.Second}
+	pt := PushTarget{Name: "flask", URL: target.URL, Enabled: true}
+
+	if r := checkTarget(client, pt); r.Status != checkPass || method != http.MethodHead {
+		t.Errorf("a reachable target: %+v, probed with %s", r, method)
+	}
+	for _, c := range []struct {
+		skew   time.Duration
+		status string
+	}{{0, checkPass}, {5 * time.Second, checkWarn}, {-time.Minute, checkFail}} {
+		skew = c.skew
+		if r := checkClockSkew(client, pt); r.Status != c.status {
+			t.Errorf("skew %s: %+v", c.skew, r)
+		}
+	}
+
+	if r := checkTarget(client, PushTarget{Name: "off", URL: target.URL}); r.Status != checkWarn || r.Detail != "disabled" {
+		t.Errorf("a disabled target: %+v", r)
+	}
+	gone := PushTarget{Name: "gone", URL: "http://127.0.0.1:1", Enabled: true}
+	if r := checkTarget(client, gone); r.Status != checkFail {
+		t.Errorf("an unreachable target: %+v", r)
+	}
+}
+
+func TestDoctorBindAndConfig(t *testing.T) {
+	s := StartTestServer(t)
+	addr := strings.TrimPrefix(s.URL, "http://")
+	if r := checkBind(addr, true); r.Status != checkPass {
+		t.Errorf("the running server: %+v", r)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if r := checkBind(ln.Addr().String(), false); r.Status != checkFail {
+		t.Errorf("a port in use: %+v", r)
+	}
+
+	saved := *stormLimit
+	*stormLimit = -1
+	defer func() { *stormLimit = saved }()
+	if r := checkConfig(); r.Status != checkFail || !strings.Contains(r.Detail, "-storm-limit") {
+		t.Errorf("a negative storm limit: %+v", r)
+	}
+}
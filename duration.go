@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDurationSeconds caps bare-number durations. Anything bigger is almost
+// certainly milliseconds or nanoseconds sent by mistake.
+const maxDurationSeconds = 86400
+
+// DurationError reports a time value that could not be understood.
+type DurationError struct {
+	Value  string
+	Reason string
+}
+
+func (e *DurationError) Error() string {
+	return fmt.Sprintf("invalid duration %s: %s", e.Value, e.Reason)
+}
+
+// FlexDuration is a duration in a request body. It accepts a number of
+// seconds, a Go duration string like "1m30s" or "1500ms", or an object
+// {"minutes": 1, "seconds": 30}.
+type FlexDuration time.Duration
+
+func (d *FlexDuration) UnmarshalJSON(b []byte) error {
+	v, err := parseDurationJSON(b)
+	if err != nil {
+		return err
+	}
+	*d = FlexDuration(v)
+	return nil
+}
+
+// MarshalJSON writes a duration string, which UnmarshalJSON reads back.
+func (d FlexDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func parseDurationJSON(b []byte) (time.Duration, error) {
+	b = bytes.TrimSpace(b)
+	raw := string(b)
+	invalid := func(reason string) (time.Duration, error) {
+		return 0, &DurationError{Value: raw, Reason: reason}
+	}
+	if len(b) == 0 || raw == "null" {
+		return 0, nil
+	}
+
+	switch b[0] {
+	case '"':
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return invalid("malformed string")
+		}
+		d, err := parseDurationArg(s)
+		if err != nil {
+			return invalid(err.(*DurationError).Reason)
+		}
+		return d, nil
+	case '{':
+		var parts map[string]json.Number
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&parts); err != nil {
+			return invalid("object fields must be numbers")
+		}
+		var total time.Duration
+		for key, n := range parts {
+			unit, ok := map[string]time.Duration{"minutes": time.Minute, "seconds": time.Second}[key]
+			if !ok {
+				return invalid(fmt.Sprintf("unknown field %q, use minutes and seconds", key))
+			}
+			f, err := n.Float64()
+			if err != nil || f < 0 {
+				return invalid(key + " must be a non-negative number")
+			}
+			total += time.Duration(f * float64(unit))
+		}
+		if total > maxDurationSeconds*time.Second {
+			return invalid("longer than 24 hours")
+		}
+		return total, nil
+	default:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return invalid("expected seconds, a duration string or {minutes, seconds}")
+		}
+		return secondsDuration(raw, f)
+	}
+}
+
+func secondsDuration(raw string, f float64) (time.Duration, error) {
+	switch {
+	case math.IsNaN(f) || f < 0:
+		return 0, &DurationError{Value: raw, Reason: "must not be negative"}
+	case f > maxDurationSeconds:
+		return 0, &DurationError{Value: raw, Reason: "more than 86400 seconds, send a duration string for milliseconds"}
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
+
+// parseDurationArg parses a CLI time argument or a JSON duration string:
+// plain seconds ("90") or a Go duration ("1m30s").
+func parseDurationArg(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return secondsDuration(strconv.Quote(s), f)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, &DurationError{Value: strconv.Quote(s), Reason: "expected seconds or a duration like 1m30s"}
+	}
+	if d < 0 {
+		return 0, &DurationError{Value: strconv.Quote(s), Reason: "must not be negative"}
+	}
+	if d > maxDurationSeconds*time.Second {
+		return 0, &DurationError{Value: strconv.Quote(s), Reason: "longer than 24 hours"}
+	}
+	return d, nil
+}
+
+// On /v1 a duration in a request body is a number of nanoseconds, the way
+// time.Duration encodes it and the way /v1 writes durations back, while a
+// FlexDuration reads a bare number as seconds. So the /v1 decoder takes
+// those numbers out first, and refuses the strings and objects that only
+// /v2 reads: v1Durations finds every number that goes into
+// a FlexDuration and blanks it to a 0 of the same width, so the offsets in
+// Echo's errors still point into the body as sent, and setV1Durations
+// puts the nanoseconds in once the body is decoded.
+
+var flexDurationType = reflect.TypeOf(FlexDuration(0))
+
+// durationStep is one step from a request value towards a FlexDuration in
+// it: a struct field by its index, a slice or array element, or a map
+// value.
+type durationStep struct {
+	kind  reflect.Kind
+	field []int
+	index int
+	key   string
+}
+
+// v1Duration is a FlexDuration of a /v1 body and its nanoseconds.
+type v1Duration struct {
+	path  []durationStep
+	nanos int64
+}
+
+// durationScan walks a body token by token alongside the type it decodes
+// into.
+type durationScan struct {
+	dec   *json.Decoder
+	body  []byte
+	found []v1Duration
+}
+
+// v1Durations returns body with the numbers of its FlexDurations blanked,
+// and those numbers. A value that is not a whole count of nanoseconds is
+// an *json.UnmarshalTypeError like the one time.Duration gives. Malformed
+// JSON is left for the decoder to report.
+func v1Durations(body []byte, t reflect.Type) ([]byte, []v1Duration, error) {
+	s := &durationScan{dec: json.NewDecoder(bytes.NewReader(body)), body: append([]byte(nil), body...)}
+	s.dec.UseNumber()
+	err := s.value(t, nil, "")
+	var terr *json.UnmarshalTypeError
+	if errors.As(err, &terr) {
+		return nil, nil, err
+	}
+	if err != nil {
+		return body, nil, nil
+	}
+	return s.body, s.found, nil
+}
+
+// value scans the next value, which decodes into t. field is its dotted
+// path for errors.
+func (s *durationScan) value(t reflect.Type, path []durationStep, field string) error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == flexDurationType {
+		return s.duration(path, field)
+	}
+	tok, err := s.dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil
+	}
+	if t.Implements(unmarshalerType) || reflect.PointerTo(t).Implements(unmarshalerType) {
+		return s.skip(delim)
+	}
+	step := func(d durationStep) []durationStep {
+		return append(path[:len(path):len(path)], d)
+	}
+	switch {
+	case delim == '{' && t.Kind() == reflect.Struct:
+		known := jsonFields(t)
+		for s.dec.More() {
+			key, err := s.key()
+			if err != nil {
+				return err
+			}
+			f, ok := lookupField(known, key)
+			if !ok {
+				f.Type = reflect.TypeOf((*interface{})(nil)).Elem()
+			}
+			if err := s.value(f.Type, step(durationStep{kind: reflect.Struct, field: f.Index}), joinFieldPath(field, key)); err != nil {
+				return err
+			}
+		}
+	case delim == '{' && t.Kind() == reflect.Map && t.Key().Kind() == reflect.String:
+		for s.dec.More() {
+			key, err := s.key()
+			if err != nil {
+				return err
+			}
+			if err := s.value(t.Elem(), step(durationStep{kind: reflect.Map, key: key}), joinFieldPath(field, key)); err != nil {
+				return err
+			}
+		}
+	case delim == '[' && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+		for i := 0; s.dec.More(); i++ {
+			if err := s.value(t.Elem(), step(durationStep{kind: reflect.Slice, index: i}), field); err != nil {
+				return err
+			}
+		}
+	default:
+		return s.skip(delim)
+	}
+	_, err = s.dec.Token()
+	return err
+}
+
+// duration scans a value that decodes into a FlexDuration. Duration
+// strings and {minutes, seconds} objects are /v2's; /v1 gets the type
+// error time.Duration gave for them.
+func (s *durationScan) duration(path []durationStep, field string) error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return err
+	}
+	end := s.dec.InputOffset()
+	typeError := func(value string) error {
+		return &json.UnmarshalTypeError{Value: value, Type: reflect.TypeOf(time.Duration(0)), Offset: end, Field: field}
+	}
+	switch v := tok.(type) {
+	case json.Delim:
+		if v == '[' {
+			return typeError("array")
+		}
+		return typeError("object")
+	case string:
+		return typeError("string")
+	case bool:
+		return typeError("bool")
+	case json.Number:
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return typeError("number " + string(v))
+		}
+		start := int(end) - len(v)
+		s.body[start] = '0'
+		for i := start + 1; i < int(end); i++ {
+			s.body[i] = ' '
+		}
+		s.found = append(s.found, v1Duration{path: path, nanos: n})
+	}
+	return nil
+}
+
+// key reads an object key.
+func (s *durationScan) key() (string, error) {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return "", err
+	}
+	key, _ := tok.(string)
+	return key, nil
+}
+
+// skip reads up to the end of the object or array opened by delim.
+func (s *durationScan) skip(delim json.Delim) error {
+	if delim == '}' || delim == ']' {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// setV1Durations puts the nanoseconds found by v1Durations into v, the
+// pointer the body was decoded into.
+func setV1Durations(v reflect.Value, found []v1Duration) {
+	for _, d := range found {
+		setV1Duration(v, d.path, d.nanos)
+	}
+}
+
+func setV1Duration(v reflect.Value, path []durationStep, nanos int64) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if len(path) == 0 {
+		if v.Type() == flexDurationType && v.CanSet() {
+			v.SetInt(nanos)
+		}
+		return
+	}
+	step := path[0]
+	switch {
+	case step.kind == reflect.Struct && v.Kind() == reflect.Struct:
+		if f, err := v.FieldByIndexErr(step.field); err == nil {
+			setV1Duration(f, path[1:], nanos)
+		}
+	case step.kind == reflect.Slice && (v.Kind() == reflect.Slice || v.Kind() == reflect.Array):
+		if step.index < v.Len() {
+			setV1Duration(v.Index(step.index), path[1:], nanos)
+		}
+	case step.kind == reflect.Map && v.Kind() == reflect.Map:
+		key := reflect.ValueOf(step.key).Convert(v.Type().Key())
+		cur := v.MapIndex(key)
+		if !cur.IsValid() {
+			return
+		}
+		// Map values can't be set in place.
+		elem := reflect.New(v.Type().Elem()).Elem()
+		elem.Set(cur)
+		setV1Duration(elem, path[1:], nanos)
+		v.SetMapIndex(key, elem)
+	}
+}
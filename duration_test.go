@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDurationJSON(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want time.Duration
+	}{
+		{`30`, 30 * time.Second},
+		{`1.5`, 1500 * time.Millisecond},
+		{`"90"`, 90 * time.Second},
+		{`"1m30s"`, 90 * time.Second},
+		{`"1500ms"`, 1500 * time.Millisecond},
+		{`{"minutes": 1, "seconds": 30}`, 90 * time.Second},
+		{`{"seconds": 0.5}`, 500 * time.Millisecond},
+		{`null`, 0},
+		{`86400`, 24 * time.Hour},
+	} {
+		got, err := parseDurationJSON([]byte(tc.in))
+		if err != nil || got != tc.want {
+			t.Errorf("%s: got %v, %v, want %v", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestParseDurationJSONErrors(t *testing.T) {
+	for _, in := range []string{
+		`-1`,
+		`86401`,
+		`30000000000`,
+		`"-5s"`,
+		`"25h"`,
+		`"soon"`,
+		`{"hours": 1}`,
+		`{"minutes": -1}`,
+		`true`,
+	} {
+		_, err := parseDurationJSON([]byte(in))
+		var de *DurationError
+		if !errors.As(err, &de) {
+			t.Errorf("%s: want a DurationError, got %v", in, err)
+		}
+	}
+}
+
+func TestFlexDurationRoundTrip(t *testing.T) {
+	for _, d := range []time.Duration{0, 1500 * time.Millisecond, 90 * time.Second, 2 * time.Hour} {
+		raw, err := json.Marshal(FlexDuration(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back FlexDuration
+		if err := json.Unmarshal(raw, &back); err != nil || time.Duration(back) != d {
+			t.Errorf("%v: marshalled %s, read back %v, %v", d, raw, time.Duration(back), err)
+		}
+	}
+}
+
+func TestV1DurationsBlanksNumbers(t *testing.T) {
+	body := []byte(`{"question":"x","time_left":30000000000,"options":[],"reading_time":5}`)
+	blanked, found, err := v1Durations(append([]byte(nil), body...), reflect.TypeOf(QuestionRequest{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"question":"x","time_left":0          ,"options":[],"reading_time":0}`
+	if string(blanked) != want {
+		t.Fatalf("blanked body:\n got %s\nwant %s", blanked, want)
+	}
+	if len(found) != 2 || found[0].nanos != 30000000000 || found[1].nanos != 5 {
+		t.Fatalf("found %+v", found)
+	}
+}
+
+func TestV1DurationsTypeErrors(t *testing.T) {
+	for _, tc := range []struct {
+		body, value string
+	}{
+		{`{"time_left":1.5}`, "number 1.5"},
+		{`{"time_left":"30s"}`, "string"},
+		{`{"time_left":{"seconds":30}}`, "object"},
+		{`{"time_left":[30]}`, "array"},
+		{`{"time_left":true}`, "bool"},
+	} {
+		_, _, err := v1Durations([]byte(tc.body), reflect.TypeOf(QuestionRequest{}))
+		var te *json.UnmarshalTypeError
+		if !errors.As(err, &te) || te.Value != tc.value || te.Field != "time_left" {
+			t.Errorf("%s: got %v, want a type error for %s", tc.body, err, tc.value)
+		}
+	}
+}
+
+func TestDurationsOnTheWire(t *testing.T) {
+	s := StartTestServer(t)
+
+	// /v1 and the unversioned paths read and write nanoseconds.
+	for _, path := range []string{"/set-question", "/v1/set-question"} {
+		q := s.MustDo(t, http.MethodPost, path, json.RawMessage(`{"question":"ns","type":"pomoc","time_left":45000000000}`))
+		if q["time_left"] != float64(45*time.Second) {
+			t.Errorf("%s: time_left %v, want 45s in nanoseconds", path, q["time_left"])
+		}
+	}
+	q := s.MustDo(t, http.MethodGet, "/v2/get-question", nil)
+	if q["time_left"] != float64(45) {
+		t.Errorf("/v2 reads back %v, want 45 seconds", q["time_left"])
+	}
+
+	// Strings and objects are /v2's.
+	var body map[string]interface{}
+	status := s.Do(t, http.MethodPost, "/v1/set-question", json.RawMessage(`{"question":"x","type":"pomoc","time_left":"30s"}`), &body)
+	if status != http.StatusBadRequest || body["error"] != "Unmarshal type error: expected=time.Duration, got=string, field=time_left, offset=48" {
+		t.Errorf("/v1 duration string: %d %v", status, body)
+	}
+	for _, tl := range []string{`"1m"`, `60`, `{"minutes":1}`} {
+		q := s.MustDo(t, http.MethodPost, "/v2/set-question", json.RawMessage(`{"question":"x","type":"pomoc","time_left":`+tl+`}`))
+		if q["time_left"] != float64(60) {
+			t.Errorf("/v2 time_left %s: got %v, want 60", tl, q["time_left"])
+		}
+	}
+}
+
+func TestV1QueueDurations(t *testing.T) {
+	s := StartTestServer(t)
+	var entries []map[string]interface{}
+	status := s.Do(t, http.MethodPost, "/v1/queue", json.RawMessage(`[{"question":"a","type":"pomoc","time_left":20000000000,"reading_time":5000000000}]`), &entries)
+	if status/100 != 2 {
+		t.Fatalf("status %d", status)
+	}
+	if len(entries) != 1 || entries[0]["time_left"] != float64(20*time.Second) {
+		t.Fatalf("queued %v", entries)
+	}
+	var queued []map[string]interface{}
+	s.Do(t, http.MethodGet, "/v2/queue", nil, &queued)
+	if len(queued) != 1 || queued[0]["time_left"] != float64(20) || queued[0]["reading_time"] != float64(5) {
+		t.Fatalf("/v2 queue %v", queued)
+	}
+}
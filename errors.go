@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// APIError is a failed request. Handlers return it and httpErrorHandler
+// writes it, for /v2, as the standard envelope:
+//
+//	{"error": {"code": "...", "message": "...", "details": {...}}}
+//
+// /v1 and the legacy paths get {"error": "..."} with the message only.
+type APIError struct {
+	Status  int                    `json:"-"`
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func apiError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// withDetail adds a machine-readable detail to the error.
+func (e *APIError) withDetail(key string, value interface{}) *APIError {
+	if e.Details == nil {
+		e.Details = map[string]interface{}{}
+	}
+	e.Details[key] = value
+	return e
+}
+
+// Shorthands for the common failure classes.
+func badRequest(message string) *APIError {
+	return apiError(http.StatusBadRequest, "bad_request", message)
+}
+
+func notFound(message string) *APIError {
+	return apiError(http.StatusNotFound, "not_found", message)
+}
+
+func conflict(message string) *APIError {
+	return apiError(http.StatusConflict, "conflict", message)
+}
+
+// statusCodes names the errors Echo produces on its own.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusTooManyRequests:       "rate_limited",
+}
+
+func newIncidentID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// httpErrorHandler replaces Echo's default so every failure, including
+// routing errors and recovered panics, uses the error shape of the API
+// version asked for.
+func httpErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	var apiErr *APIError
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.As(err, &httpErr) && httpErr.Internal != nil && errors.As(httpErr.Internal, &apiErr):
+	case errors.As(err, &httpErr):
+		code, ok := statusCodes[httpErr.Code]
+		if !ok {
+			code = "error"
+		}
+		apiErr = apiError(httpErr.Code, code, fmt.Sprint(httpErr.Message))
+		if httpErr.Code >= http.StatusInternalServerError {
+			apiErr = internalError(c, err)
+		}
+	default:
+		apiErr = internalError(c, err)
+	}
+
+	body := errorBody(apiErr, apiVersion(c))
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(apiErr.Status)
+	} else {
+		err = c.JSON(apiErr.Status, body)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
+
+// internalError hides the cause from the client and logs it with an
+// incident ID the operator can search for.
+func internalError(c echo.Context, err error) *APIError {
+	id := newIncidentID()
+	notify(SeverityError, "http", "Incident %s: %s %s: %v", id, c.Request().Method, c.Path(), err)
+	return apiError(http.StatusInternalServerError, "internal", "internal server error").withDetail("incident_id", id)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// TestErrorEnvelope fails a request of every class and checks the /v2
+// envelope, then that the legacy path says the same in its own shape.
+func TestErrorEnvelope(t *testing.T) {
+	s := StartTestServer(t)
+	defer forgetTeamPIN("Sovy")
+	defer func(n int) { *pinAttempts = n }(*pinAttempts)
+	*pinAttempts = 2
+	if err := addTeam(Team{Name: "Sovy"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := setTeamPIN("Sovy", "1234", "test"); err != nil {
+		t.Fatal(err)
+	}
+	entry := s.MustDo(t, http.MethodPost, "/bank", map[string]interface{}{"question": "Hlavné mesto?", "type": "pomoc", "time_left": 30_000_000_000})
+	bankPath := fmt.Sprintf("/bank/%v", entry["id"])
+
+	for _, tc := range []struct {
+		name         string
+		method, path string
+		body         interface{}
+		anonymous    bool
+		status       int
+		code         string
+		detail       string
+	}{
+		{"bind", http.MethodPost, "/time/adjust", map[string]string{"delta_seconds": "päť"}, false, http.StatusBadRequest, "invalid_field", "fields"},
+		{"not found", http.MethodGet, "/bank/999", nil, false, http.StatusNotFound, "not_found", ""},
+		{"conflict", http.MethodPut, bankPath, map[string]interface{}{"question": "Hlavné mesto?", "type": "pomoc", "time_left": 30, "version": 2}, false, http.StatusConflict, "version_conflict", "current_version"},
+		{"unauthorized", http.MethodGet, "/targets", nil, true, http.StatusUnauthorized, "unauthorized", ""},
+		{"forbidden", http.MethodPost, "/teams/Sovy/login", map[string]string{"pin": "0000"}, true, http.StatusForbidden, "wrong_pin", ""},
+		{"rate limited", http.MethodPost, "/teams/Sovy/login", map[string]string{"pin": "1234"}, true, http.StatusTooManyRequests, "pin_locked", "retry_after"},
+	} {
+		send := func(path string) (int, map[string]json.RawMessage) {
+			t.Helper()
+			req := s.NewRequest(t, tc.method, path, tc.body)
+			if tc.anonymous {
+				req.Header.Del("X-API-Key")
+			}
+			var body map[string]json.RawMessage
+			return s.Send(t, req, &body).StatusCode, body
+		}
+		status, body := send("/v2" + tc.path)
+		var e APIError
+		if err := json.Unmarshal(body["error"], &e); err != nil || len(body) != 1 {
+			t.Errorf("%s: body %s, %v", tc.name, body, err)
+			continue
+		}
+		if status != tc.status || e.Code != tc.code || e.Message == "" {
+			t.Errorf("%s: status %d, %+v", tc.name, status, e)
+		}
+		if _, ok := e.Details[tc.detail]; tc.detail != "" && !ok {
+			t.Errorf("%s: details %v, want %s", tc.name, e.Details, tc.detail)
+		}
+
+		// The legacy paths carry the bare message, in Echo's wording for a
+		// bind error. The second wrong PIN there locks the team out.
+		status, body = send(tc.path)
+		var message string
+		err := json.Unmarshal(body["error"], &message)
+		if err != nil || status != tc.status || message == "" || tc.name != "bind" && message != e.Message {
+			t.Errorf("%s, legacy: status %d, body %s", tc.name, status, body)
+		}
+	}
+}
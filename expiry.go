@@ -0,0 +1,157 @@
+package main
+
+import "time"
+
+// expiryWake is poked on every state change so the watcher re-reads the
+// deadline.
+var expiryWake = make(chan struct{}, 1)
+
+func wakeExpiryWatcher() {
+	select {
+	case expiryWake <- struct{}{}:
+	default:
+	}
+}
+
+// countdownDeadline returns when q runs out, if it is a running countdown.
+func countdownDeadline(q Question) (time.Time, bool) {
+	if q.CountUp || q.Paused || q.Reading || q.Type == "end" || q.Type == "waiting" || q.ExpiredFrom != "" {
+		return time.Time{}, false
+	}
+	return q.StartTime.Add(q.TimeLeft), true
+}
+
+// readingDeadline returns when the reading phase of q is over, if it is
+// running.
+func readingDeadline(q Question) (time.Time, bool) {
+	if !q.Reading || q.Paused {
+		return time.Time{}, false
+	}
+	return q.StartTime.Add(q.ReadingTime), true
+}
+
+// watchExpiry ends the reading phase when its time is up, reveals hidden
+// options, closes the answer window, stalls a count-up at its ceiling, and
+// commits "end" when a countdown reaches zero, so the state and every push
+// target agree that the question is over instead of each client working it
+// out on its own. Overtime questions keep their type and only announce the
+// expiry.
+func watchExpiry() {
+	for {
+		questionMutex.RLock()
+		deadline, ok := countdownDeadline(question)
+		readEnd, reading := readingDeadline(question)
+		windowEnd, windowing := answerWindowDeadline(question)
+		stallAt, stalling := countUpCeilingDeadline(question)
+		revealAt, revealing := optionsRevealDeadline(question)
+		questionMutex.RUnlock()
+		if reading {
+			deadline, ok = readEnd, true
+		}
+		// The answer window always ends before the countdown.
+		if windowing {
+			deadline, ok = windowEnd, true
+		}
+		if stalling && (!ok || stallAt.Before(deadline)) {
+			deadline, ok, windowing = stallAt, true, false
+		} else {
+			stalling = false
+		}
+		if revealing && (!ok || revealAt.Before(deadline)) {
+			deadline, ok, windowing, stalling = revealAt, true, false, false
+		} else {
+			revealing = false
+		}
+		if currentReplay() != nil {
+			// The recording already contains its own expiry.
+			ok = false
+		}
+
+		var fire <-chan time.Time
+		if ok {
+			armTimer("expiry", deadline)
+			fire = clock.After(deadline.Sub(clock.Now()))
+		} else {
+			disarmTimer("expiry")
+		}
+		select {
+		case <-expiryWake:
+		case <-fire:
+			switch {
+			case revealing:
+				revealOptions()
+			case stalling:
+				stallCountUp()
+			case windowing:
+				closeAnswerWindow()
+			case reading:
+				startAnswering("timer")
+			default:
+				expireQuestion()
+			}
+		}
+	}
+}
+
+func expireQuestion() {
+	questionMutex.Lock()
+	deadline, ok := countdownDeadline(question)
+	if !ok || clock.Now().Before(deadline) {
+		questionMutex.Unlock()
+		return
+	}
+	question.ExpiredFrom = question.Type
+	overtime := question.AllowOvertime
+	if !overtime {
+		question.Type = "end"
+	}
+	text := question.Question
+	stateChanged("expire")
+	questionMutex.Unlock()
+
+	audit("expire", "timer", map[string]interface{}{"question": text, "overtime": overtime})
+	if overtime {
+		// Sounds and lights still cue on zero.
+		hub.broadcast(Event{Type: "expired", Data: map[string]string{"question": text}})
+	} else {
+		finalizeQuestion("expired")
+	}
+	sendUrgentQuestion()
+	checkRundown()
+}
+
+// reviveExpired restores the type of a question whose countdown ran out,
+// for when the operator gives it more time.
+func reviveExpired(q *Question) {
+	if q.ExpiredFrom != "" && q.Type == "end" {
+		q.Type = q.ExpiredFrom
+	}
+	q.ExpiredFrom = ""
+}
+
+// overtimeOf returns how far q has run past zero, for overtime questions.
+func overtimeOf(q Question) time.Duration {
+	if !q.AllowOvertime || q.CountUp || q.Reading || q.Type == "end" || q.Type == "waiting" {
+		return 0
+	}
+	if q.Paused {
+		if q.TimeLeft < 0 {
+			return -q.TimeLeft
+		}
+		return 0
+	}
+	if over := -timeRemaining(q, clock.Now()); over > 0 {
+		return over
+	}
+	return 0
+}
+
+// recordOvertime audits the overrun of a question the operator is about to
+// end or replace. Call with questionMutex held.
+func recordOvertime(q Question, origin string) {
+	over := overtimeOf(q)
+	if over <= 0 {
+		return
+	}
+	audit("overtime", origin, map[string]interface{}{"question": q.Question, "seconds": over.Seconds()})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+)
+
+// An extraBuilder contributes the "extra" object of the public payload for
+// one question type. Every extra carries its type as "kind", so a client
+// can tell the shapes apart; Schema is the JSON schema of that shape.
+type extraBuilder struct {
+	Build  func(q Question) interface{}
+	Schema map[string]interface{}
+}
+
+// extraBuilders is keyed by question type. A type without a builder, such
+// as one a profile adds, has no extra.
+var extraBuilders = map[string]extraBuilder{
+	"rozstrel": {Build: rozstrelExtra, Schema: extraSchema("rozstrel", map[string]interface{}{
+		"buzz_winner": map[string]interface{}{"type": "string"},
+		"buzz_order":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		"locked":      map[string]interface{}{"type": "boolean"},
+	})},
+	"pomoc": {Build: pomocExtra, Schema: extraSchema("pomoc", map[string]interface{}{
+		"votes": map[string]interface{}{"type": "array", "items": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"option": map[string]interface{}{"type": "integer"},
+				"count":  map[string]interface{}{"type": "integer"},
+			},
+		}},
+		"total": map[string]interface{}{"type": "integer"},
+	})},
+	"picture": {Build: pictureExtra, Schema: extraSchema("picture", map[string]interface{}{
+		"media_url": map[string]interface{}{"type": "string"},
+		"next":      map[string]interface{}{"type": "string", "description": "media URL of the next queued question, to preload"},
+	})},
+}
+
+func extraSchema(kind string, properties map[string]interface{}) map[string]interface{} {
+	properties["kind"] = map[string]interface{}{"const": kind}
+	return map[string]interface{}{"type": "object", "required": []string{"kind"}, "properties": properties}
+}
+
+// questionExtra builds the extra of q, nil for types without one.
+func questionExtra(q Question) interface{} {
+	b, ok := extraBuilders[q.Type]
+	if !ok {
+		return nil
+	}
+	return b.Build(q)
+}
+
+// RozstrelExtra is the buzzer state of a shoot-out.
+type RozstrelExtra struct {
+	Kind       string   `json:"kind"`
+	BuzzWinner string   `json:"buzz_winner,omitempty"`
+	BuzzOrder  []string `json:"buzz_order"`
+	// Locked is set while a winner holds the buzzer.
+	Locked bool `json:"locked"`
+}
+
+func rozstrelExtra(Question) interface{} {
+	roundMutex.Lock()
+	defer roundMutex.Unlock()
+	return RozstrelExtra{Kind: "rozstrel", BuzzWinner: buzzWinner, BuzzOrder: append([]string{}, buzzOrder...), Locked: buzzWinner != ""}
+}
+
+// PomocExtra tallies the answers by option. Votes stay empty until the
+// floor closes, so the count doesn't sway teams still answering.
+type PomocExtra struct {
+	Kind  string       `json:"kind"`
+	Votes []OptionVote `json:"votes"`
+	Total int          `json:"total"`
+}
+
+// OptionVote counts the answers for option Option, numbered from 1.
+type OptionVote struct {
+	Option int `json:"option"`
+	Count  int `json:"count"`
+}
+
+func pomocExtra(q Question) interface{} {
+	roundMutex.Lock()
+	defer roundMutex.Unlock()
+	extra := PomocExtra{Kind: "pomoc", Votes: []OptionVote{}}
+	if floorClosed == nil || len(q.Options) == 0 {
+		return extra
+	}
+	for i := range q.Options {
+		extra.Votes = append(extra.Votes, OptionVote{Option: i + 1})
+	}
+	for _, a := range answers {
+		if i := optionIndex(q.Options, a.Answer); i >= 0 {
+			extra.Votes[i].Count++
+			extra.Total++
+		}
+	}
+	return extra
+}
+
+// optionIndex finds the option an answer picks: by its number from 1, its
+// letter from A, or its text. It is -1 for none.
+func optionIndex(options []AnswerOption, answer string) int {
+	if i := optionPosition(options, answer); i >= 0 {
+		return i
+	}
+	answer = strings.TrimSpace(answer)
+	for i, o := range options {
+		if o.Text != "" && strings.EqualFold(o.Text, answer) {
+			return i
+		}
+	}
+	return -1
+}
+
+// optionPosition finds the option an answer picks by number or letter
+// only. It is -1 for none.
+func optionPosition(options []AnswerOption, answer string) int {
+	answer = strings.TrimSpace(answer)
+	if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+		return n - 1
+	}
+	if len(answer) == 1 {
+		if n := int(unicode.ToUpper(rune(answer[0])) - 'A'); n >= 0 && n < len(options) {
+			return n
+		}
+	}
+	return -1
+}
+
+// PictureExtra is the media of a picture round.
+type PictureExtra struct {
+	Kind     string `json:"kind"`
+	MediaURL string `json:"media_url,omitempty"`
+	Next     string `json:"next,omitempty"`
+}
+
+func pictureExtra(q Question) interface{} {
+	extra := PictureExtra{Kind: "picture", MediaURL: q.MediaURL}
+	if next := currentPreload(); next != nil {
+		extra.Next = next.MediaURL
+	}
+	return extra
+}
+
+// QuestionTypeView is one entry of GET /question-types.
+type QuestionTypeView struct {
+	Name string `json:"name"`
+	// ExtraSchema is the JSON schema of the type's extra, if it has one.
+	ExtraSchema map[string]interface{} `json:"extra_schema,omitempty"`
+}
+
+func getQuestionTypes(c echo.Context) error {
+	out := []QuestionTypeView{}
+	for _, name := range questionTypeNames() {
+		out = append(out, QuestionTypeView{Name: name, ExtraSchema: extraBuilders[name].Schema})
+	}
+	return c.JSON(http.StatusOK, out)
+}
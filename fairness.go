@@ -0,0 +1,272 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var fairnessMargin = flag.Duration("fairness-margin", 2*time.Second, "flag a team in the fairness report when its answer window was this much shorter than the longest")
+
+// The fairness report shows, per question, how long each team could
+// actually have answered: the question's window less its pauses, which
+// cost every team the same, less the time the team's kiosk was not
+// connected. Teams that never had a kiosk up are not tracked and get the
+// whole window, since there is nothing to say otherwise.
+
+// TimeSpan is the time from From up to To.
+type TimeSpan struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+func (s TimeSpan) length() time.Duration {
+	return s.To.Sub(s.From)
+}
+
+// mergeSpans sorts spans and joins those that overlap or touch. Empty and
+// backwards spans are dropped.
+func mergeSpans(spans []TimeSpan) []TimeSpan {
+	sorted := make([]TimeSpan, 0, len(spans))
+	for _, s := range spans {
+		if s.To.After(s.From) {
+			sorted = append(sorted, s)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].From.Before(sorted[j].From) })
+	var out []TimeSpan
+	for _, s := range sorted {
+		if n := len(out); n > 0 && !s.From.After(out[n-1].To) {
+			if s.To.After(out[n-1].To) {
+				out[n-1].To = s.To
+			}
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// subtractSpans returns the parts of a that no span of b covers, merged.
+func subtractSpans(a, b []TimeSpan) []TimeSpan {
+	b = mergeSpans(b)
+	var out []TimeSpan
+	for _, s := range mergeSpans(a) {
+		from := s.From
+		for _, cut := range b {
+			if !cut.To.After(from) || !cut.From.Before(s.To) {
+				continue
+			}
+			if cut.From.After(from) {
+				out = append(out, TimeSpan{From: from, To: cut.From})
+			}
+			from = cut.To
+		}
+		if from.Before(s.To) {
+			out = append(out, TimeSpan{From: from, To: s.To})
+		}
+	}
+	return out
+}
+
+// intersectSpans returns the time covered by both a and b, merged.
+func intersectSpans(a, b []TimeSpan) []TimeSpan {
+	return subtractSpans(a, subtractSpans(a, b))
+}
+
+// spansLength is the total length of spans, which must not overlap.
+func spansLength(spans []TimeSpan) time.Duration {
+	var total time.Duration
+	for _, s := range spans {
+		total += s.length()
+	}
+	return total
+}
+
+// TeamFairness is one team's part of a fairness report.
+type TeamFairness struct {
+	Team string `json:"team"`
+	// Tracked is false for a team that had no kiosk connected by the time
+	// the question closed.
+	Tracked bool `json:"tracked"`
+	// Disconnects are the parts of the question the kiosk was not
+	// connected, pauses included.
+	Disconnects []TimeSpan `json:"disconnects"`
+	// Window is how long the team could have answered; Lost is what the
+	// disconnects took from it and Shortfall how much shorter it was than
+	// the longest window of any team.
+	Window    time.Duration `json:"window"`
+	Lost      time.Duration `json:"lost"`
+	Shortfall time.Duration `json:"shortfall"`
+	// SubmittedAt is when the team's answer arrived, and Used how much of
+	// its window had passed by then.
+	SubmittedAt *time.Time    `json:"submitted_at,omitempty"`
+	Used        time.Duration `json:"used,omitempty"`
+	Flagged     bool          `json:"flagged"`
+}
+
+// FairnessReport compares the teams' effective answer windows on one
+// question.
+type FairnessReport struct {
+	HistoryID int       `json:"history_id"`
+	Question  string    `json:"question"`
+	Opened    time.Time `json:"opened"`
+	// Closed is when answers stopped counting: the end of the question, or
+	// earlier if the floor or the answer window closed.
+	Closed time.Time  `json:"closed"`
+	Pauses []TimeSpan `json:"pauses"`
+	// Window is the question's window less its pauses, the most any team
+	// could have had.
+	Window  time.Duration  `json:"window"`
+	Margin  time.Duration  `json:"margin"`
+	Teams   []TeamFairness `json:"teams"`
+	Flagged []string       `json:"flagged"`
+}
+
+// fairnessReport builds the report for e, which must have its record.
+func fairnessReport(e HistoryEntry) FairnessReport {
+	r := e.Record
+	closed := r.EndedAt
+	for _, t := range []*time.Time{r.FloorClosed, r.WindowClosed} {
+		if t != nil && t.Before(closed) {
+			closed = *t
+		}
+	}
+	if closed.Before(e.StartedAt) {
+		closed = e.StartedAt
+	}
+	span := []TimeSpan{{From: e.StartedAt, To: closed}}
+	window := subtractSpans(span, r.Pauses)
+	rep := FairnessReport{
+		HistoryID: e.ID,
+		Question:  e.Question,
+		Opened:    e.StartedAt,
+		Closed:    closed,
+		Pauses:    intersectSpans(r.Pauses, span),
+		Window:    spansLength(window),
+		Margin:    *fairnessMargin,
+		Teams:     []TeamFairness{},
+		Flagged:   []string{},
+	}
+	if rep.Pauses == nil {
+		rep.Pauses = []TimeSpan{}
+	}
+
+	submitted := map[string]time.Time{}
+	var names []string
+	for _, t := range listTeams() {
+		names = append(names, t.Name)
+	}
+	for _, a := range r.Answers {
+		if _, ok := findTeam(a.Team); !ok {
+			names = append(names, a.Team)
+		}
+		submitted[a.Team] = a.Time
+	}
+
+	var longest time.Duration
+	for _, name := range names {
+		var connected []TimeSpan
+		for _, p := range presenceSpans(name) {
+			if p.From.Before(closed) {
+				connected = append(connected, TimeSpan{From: p.From, To: p.To})
+			}
+		}
+		tf := TeamFairness{Team: name, Tracked: len(connected) > 0, Disconnects: []TimeSpan{}}
+		effective := window
+		if tf.Tracked {
+			if d := subtractSpans(span, connected); d != nil {
+				tf.Disconnects = d
+			}
+			effective = intersectSpans(window, connected)
+		}
+		tf.Window = spansLength(effective)
+		tf.Lost = rep.Window - tf.Window
+		if at, ok := submitted[name]; ok {
+			tf.SubmittedAt = &at
+			tf.Used = spansLength(intersectSpans(effective, []TimeSpan{{From: e.StartedAt, To: at}}))
+		}
+		longest = max(longest, tf.Window)
+		rep.Teams = append(rep.Teams, tf)
+	}
+	for i := range rep.Teams {
+		tf := &rep.Teams[i]
+		tf.Shortfall = longest - tf.Window
+		if tf.Tracked && tf.Shortfall > rep.Margin {
+			tf.Flagged = true
+			rep.Flagged = append(rep.Flagged, tf.Team)
+		}
+	}
+	return rep
+}
+
+// fairnessReports returns the reports of every question that has ended,
+// for the session archive.
+func fairnessReports() []FairnessReport {
+	out := []FairnessReport{}
+	for _, e := range listHistory() {
+		if e.Record != nil {
+			out = append(out, fairnessReport(e))
+		}
+	}
+	return out
+}
+
+// findFairnessReport builds the report for history entry id.
+func findFairnessReport(id int) (FairnessReport, error) {
+	e, ok := findHistoryEntry(id)
+	if !ok {
+		return FairnessReport{}, notFound(fmt.Sprintf("history entry %d not found", id))
+	}
+	if e.Record == nil {
+		return FairnessReport{}, apiError(http.StatusConflict, "question_live", "the question is still live, its fairness report is ready once it ends")
+	}
+	return fairnessReport(e), nil
+}
+
+func getHistoryFairness(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return badRequest("invalid history id")
+	}
+	rep, err := findFairnessReport(id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, rep)
+}
+
+// printFairnessReport prints the report for "history <id> fairness".
+func printFairnessReport(id int) error {
+	info := color.New(color.FgYellow)
+
+	rep, err := findFairnessReport(id)
+	if err != nil {
+		return err
+	}
+	seconds := func(d time.Duration) string {
+		return strconv.FormatFloat(d.Seconds(), 'f', 1, 64) + "s"
+	}
+	info.Printf("Fairness of #%d: window %s, %s to %s, %d pause(s)\n", rep.HistoryID, seconds(rep.Window),
+		rep.Opened.Local().Format("15:04:05"), rep.Closed.Local().Format("15:04:05"), len(rep.Pauses))
+	for _, t := range rep.Teams {
+		line := fmt.Sprintf("  %-12s window %-7s lost %-7s", t.Team, seconds(t.Window), seconds(t.Lost))
+		if t.SubmittedAt != nil {
+			line += " answered after " + seconds(t.Used)
+		}
+		if !t.Tracked {
+			line += " (no kiosk)"
+		}
+		if t.Flagged {
+			line += fmt.Sprintf(" FLAGGED, %s short", seconds(t.Shortfall))
+		}
+		info.Println(line)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// at is testEpoch plus s seconds.
+func at(s float64) time.Time {
+	return testEpoch.Add(time.Duration(s * float64(time.Second)))
+}
+
+func span(from, to float64) TimeSpan {
+	return TimeSpan{From: at(from), To: at(to)}
+}
+
+func TestMergeSpans(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		in, want []TimeSpan
+	}{
+		{"none", nil, nil},
+		{"unsorted", []TimeSpan{span(20, 30), span(0, 10)}, []TimeSpan{span(0, 10), span(20, 30)}},
+		{"overlapping", []TimeSpan{span(0, 10), span(5, 15)}, []TimeSpan{span(0, 15)}},
+		{"touching", []TimeSpan{span(0, 10), span(10, 20)}, []TimeSpan{span(0, 20)}},
+		{"contained", []TimeSpan{span(0, 30), span(5, 10), span(20, 25)}, []TimeSpan{span(0, 30)}},
+		{"empty and backwards", []TimeSpan{span(5, 5), span(10, 3), span(1, 2)}, []TimeSpan{span(1, 2)}},
+		{"chain", []TimeSpan{span(8, 12), span(0, 5), span(4, 9), span(20, 21)}, []TimeSpan{span(0, 12), span(20, 21)}},
+	} {
+		if got := mergeSpans(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSubtractSpans(t *testing.T) {
+	whole := []TimeSpan{span(0, 60)}
+	for _, tc := range []struct {
+		name      string
+		a, b      []TimeSpan
+		want      []TimeSpan
+		wantTotal time.Duration
+	}{
+		{"nothing cut", whole, nil, whole, 60 * time.Second},
+		{"middle", whole, []TimeSpan{span(10, 20)}, []TimeSpan{span(0, 10), span(20, 60)}, 50 * time.Second},
+		{"start and end", whole, []TimeSpan{span(-5, 5), span(55, 70)}, []TimeSpan{span(5, 55)}, 50 * time.Second},
+		{"overlapping cuts", whole, []TimeSpan{span(10, 30), span(20, 40)}, []TimeSpan{span(0, 10), span(40, 60)}, 30 * time.Second},
+		{"all of it", whole, []TimeSpan{span(-1, 61)}, nil, 0},
+		{"outside", whole, []TimeSpan{span(70, 80)}, whole, 60 * time.Second},
+		{"several spans", []TimeSpan{span(0, 10), span(20, 30)}, []TimeSpan{span(5, 25)}, []TimeSpan{span(0, 5), span(25, 30)}, 10 * time.Second},
+	} {
+		got := subtractSpans(tc.a, tc.b)
+		if !reflect.DeepEqual(got, tc.want) || spansLength(got) != tc.wantTotal {
+			t.Errorf("%s: got %v (%v), want %v (%v)", tc.name, got, spansLength(got), tc.want, tc.wantTotal)
+		}
+	}
+}
+
+func TestIntersectSpans(t *testing.T) {
+	a := []TimeSpan{span(0, 10), span(20, 60)}
+	b := []TimeSpan{span(5, 25), span(50, 55), span(52, 70)}
+	want := []TimeSpan{span(5, 10), span(20, 25), span(50, 60)}
+	if got := intersectSpans(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := intersectSpans(a, nil); got != nil {
+		t.Errorf("with nothing: got %v", got)
+	}
+}
+
+func TestFairnessReport(t *testing.T) {
+	StartTestServer(t)
+	for _, name := range []string{"Owls", "Foxes", "Bears", "Hares"} {
+		if err := addTeam(Team{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	installPresence([]PresenceSpan{
+		// Connected throughout.
+		{Team: "Owls", From: at(-5), To: at(70)},
+		// Away for 15 seconds while the clock ran.
+		{Team: "Foxes", From: at(0), To: at(25)},
+		{Team: "Foxes", From: at(40), To: at(70)},
+		// Away only during the pause, which costs every team the same.
+		{Team: "Bears", From: at(0), To: at(12)},
+		{Team: "Bears", From: at(18), To: at(70)},
+	})
+	defer installPresence(nil)
+
+	answered := at(30)
+	e := HistoryEntry{ID: 7, Question: "Q", StartedAt: at(0), Record: &QuestionRecord{
+		EndedAt: at(60),
+		Pauses:  []TimeSpan{span(10, 20)},
+		Answers: []Answer{{Team: "Owls", Time: answered}},
+	}}
+	rep := fairnessReport(e)
+	if rep.Window != 50*time.Second || !reflect.DeepEqual(rep.Pauses, []TimeSpan{span(10, 20)}) {
+		t.Fatalf("window %v, pauses %v", rep.Window, rep.Pauses)
+	}
+
+	byTeam := map[string]TeamFairness{}
+	for _, tf := range rep.Teams {
+		byTeam[tf.Team] = tf
+	}
+	for _, want := range []struct {
+		team             string
+		tracked, flagged bool
+		window, lost     time.Duration
+	}{
+		{"Owls", true, false, 50 * time.Second, 0},
+		{"Foxes", true, true, 35 * time.Second, 15 * time.Second},
+		{"Bears", true, false, 50 * time.Second, 0},
+		{"Hares", false, false, 50 * time.Second, 0},
+	} {
+		tf := byTeam[want.team]
+		if tf.Tracked != want.tracked || tf.Flagged != want.flagged || tf.Window != want.window || tf.Lost != want.lost {
+			t.Errorf("%s: %+v", want.team, tf)
+		}
+	}
+	if got := byTeam["Foxes"].Disconnects; !reflect.DeepEqual(got, []TimeSpan{span(25, 40)}) {
+		t.Errorf("Foxes disconnects %v", got)
+	}
+	if got := byTeam["Bears"].Disconnects; !reflect.DeepEqual(got, []TimeSpan{span(12, 18)}) {
+		t.Errorf("Bears disconnects %v", got)
+	}
+	if shortfall := byTeam["Foxes"].Shortfall; shortfall != 15*time.Second {
+		t.Errorf("Foxes shortfall %v", shortfall)
+	}
+	// Thirty seconds in, less the ten paused.
+	if owls := byTeam["Owls"]; owls.SubmittedAt == nil || !owls.SubmittedAt.Equal(answered) || owls.Used != 20*time.Second {
+		t.Errorf("Owls submitted %v, used %v", owls.SubmittedAt, owls.Used)
+	}
+	if !reflect.DeepEqual(rep.Flagged, []string{"Foxes"}) {
+		t.Errorf("flagged %v", rep.Flagged)
+	}
+}
+
+func TestFairnessReportClosesWithTheFloor(t *testing.T) {
+	StartTestServer(t)
+	if err := addTeam(Team{Name: "Owls"}); err != nil {
+		t.Fatal(err)
+	}
+	installPresence([]PresenceSpan{{Team: "Owls", From: at(0), To: at(35)}})
+	defer installPresence(nil)
+
+	closed := at(40)
+	e := HistoryEntry{ID: 1, StartedAt: at(0), Record: &QuestionRecord{
+		EndedAt:     at(60),
+		FloorClosed: &closed,
+		Pauses:      []TimeSpan{span(30, 50)},
+		// A team that answered without being registered still counts.
+		Answers: []Answer{{Team: "Guests", Time: at(5)}},
+	}}
+	rep := fairnessReport(e)
+	if !rep.Closed.Equal(closed) || rep.Window != 30*time.Second {
+		t.Fatalf("closed %v, window %v", rep.Closed, rep.Window)
+	}
+	// Only the part of the pause before the floor closed counts.
+	if !reflect.DeepEqual(rep.Pauses, []TimeSpan{span(30, 40)}) {
+		t.Errorf("pauses %v", rep.Pauses)
+	}
+	if len(rep.Teams) != 2 || rep.Teams[0].Window != 30*time.Second || rep.Teams[1].Team != "Guests" || rep.Teams[1].Tracked {
+		t.Errorf("teams %+v", rep.Teams)
+	}
+	if rep.Teams[0].Flagged {
+		t.Error("a disconnect during the pause was held against Owls")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+var fileExportDir = flag.String("file-export", "", "directory to keep question.txt, timeleft.txt, type.txt and state.json up to date in, for OBS")
+
+// fileExporter mirrors the public state into plain files for stream
+// overlays that read from disk. It rewrites them on every event and once
+// per countdown tick.
+type fileExporter struct {
+	dir  string
+	stop chan struct{}
+	done chan struct{}
+
+	// last holds what each file was last written with, so unchanged files
+	// are left alone. failing is set after an error has been announced.
+	last    map[string]string
+	failing bool
+}
+
+var (
+	fileExportMutex sync.Mutex
+	activeExporter  *fileExporter
+)
+
+// startFileExport starts exporting to dir, replacing any running exporter.
+func startFileExport(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	stopFileExport()
+
+	x := &fileExporter{dir: dir, stop: make(chan struct{}), done: make(chan struct{}), last: map[string]string{}}
+	fileExportMutex.Lock()
+	activeExporter = x
+	fileExportMutex.Unlock()
+	go x.run(hub.subscribe("file", false))
+	return nil
+}
+
+// stopFileExport stops the exporter and waits for its last write.
+func stopFileExport() bool {
+	fileExportMutex.Lock()
+	x := activeExporter
+	activeExporter = nil
+	fileExportMutex.Unlock()
+	if x == nil {
+		return false
+	}
+	close(x.stop)
+	<-x.done
+	return true
+}
+
+func (x *fileExporter) run(sub *subscriber) {
+	defer close(x.done)
+	defer hub.unsubscribe(sub)
+	tick := ticks.subscribe()
+	defer ticks.unsubscribe(tick)
+	workers.run("file export", func() { x.loop(sub, tick) })
+}
+
+func (x *fileExporter) loop(sub *subscriber, tick <-chan struct{}) {
+	x.export()
+	for {
+		select {
+		case <-x.stop:
+			return
+		case <-sub.ch:
+		case <-tick:
+		}
+		x.export()
+	}
+}
+
+func (x *fileExporter) export() {
+	questionMutex.RLock()
+	q := publicQuestion(question)
+	questionMutex.RUnlock()
+
+	state, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		x.failed(err)
+		return
+	}
+	files := map[string]string{
+		"question.txt": q.Question,
+		"timeleft.txt": q.TimeDisplay.Text,
+		"type.txt":     q.Type,
+		"state.json":   string(state),
+	}
+	for name, content := range files {
+		if x.last[name] == content {
+			continue
+		}
+		if err := writeFileAtomic(filepath.Join(x.dir, name), []byte(content)); err != nil {
+			x.failed(err)
+			return
+		}
+		x.last[name] = content
+	}
+	if x.failing {
+		x.failing = false
+		notify(SeverityInfo, "fileexport", "File export to %s is working again", x.dir)
+	}
+}
+
+// failed announces the first error of a run of failures only.
+func (x *fileExporter) failed(err error) {
+	if x.failing {
+		return
+	}
+	x.failing = true
+	notify(SeverityError, "fileexport", "File export to %s failed: %v", x.dir, err)
+}
+
+// writeFileAtomic replaces path through a rename, so a reader never sees a
+// half-written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func handleFileExportCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	switch {
+	case len(args) == 2 && args[0] == "on":
+		if err := startFileExport(args[1]); err != nil {
+			return err
+		}
+		success.Printf("Exporting to %s\n", args[1])
+	case len(args) == 1 && args[0] == "off":
+		if !stopFileExport() {
+			return errors.New("File export is not running")
+		}
+		success.Println("File export stopped")
+	case len(args) == 0:
+		fileExportMutex.Lock()
+		x := activeExporter
+		fileExportMutex.Unlock()
+		if x == nil {
+			info.Println("File export is off")
+		} else {
+			info.Printf("Exporting to %s\n", x.dir)
+		}
+	default:
+		return errors.New("Usage: fileexport [on <dir>|off]")
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// Startup sync policies.
+const (
+	syncPreferLocal  = "prefer-local"
+	syncPreferRemote = "prefer-remote"
+	syncNewestWins   = "newest-wins"
+)
+
+var (
+	syncOnStart = flag.Bool("sync-on-start", false, "compare the state with the synced push target's server at startup")
+	syncTarget  = flag.String("sync-target", "flask", "push target whose server is compared by -sync-on-start and sync pull")
+	syncPath    = flag.String("sync-path", "/get-current-question", "path on the synced server that returns its current question")
+	syncPolicy  = flag.String("sync-policy", syncNewestWins, "who wins when the states differ: prefer-local, prefer-remote or newest-wins")
+)
+
+// syncBase is what the synced target last acknowledged, so a sync can tell
+// which side changed since.
+var (
+	syncMutex sync.Mutex
+	syncBase  *FlaskQuestion
+)
+
+// SyncResult is the outcome of one comparison with the synced server.
+type SyncResult struct {
+	Action   string         `json:"action"` // in_sync, pushed, adopted or conflict
+	Reason   string         `json:"reason"`
+	Local    FlaskQuestion  `json:"local"`
+	Remote   *FlaskQuestion `json:"remote,omitempty"`
+	Revision uint64         `json:"remote_revision,omitempty"`
+}
+
+func validateSyncPolicy() error {
+	switch *syncPolicy {
+	case syncPreferLocal, syncPreferRemote, syncNewestWins:
+		return nil
+	}
+	return fmt.Errorf("unknown -sync-policy %q (prefer-local, prefer-remote or newest-wins)", *syncPolicy)
+}
+
+// noteSynced remembers a payload the synced target accepted.
+func noteSynced(target string, body []byte) {
+	if target != *syncTarget {
+		return
+	}
+	q, _, err := decodeFlaskState(body)
+	if err != nil {
+		return
+	}
+	syncMutex.Lock()
+	syncBase = &q
+	syncMutex.Unlock()
+}
+
+// decodeFlaskState reads either push schema, returning the revision of a
+// version 2 payload.
+func decodeFlaskState(data []byte) (FlaskQuestion, uint64, error) {
+	var v2 FlaskPushV2
+	if err := json.Unmarshal(data, &v2); err == nil && v2.SchemaVersion == 2 {
+		return v2.Question, v2.Revision, nil
+	}
+	var q FlaskQuestion
+	if err := json.Unmarshal(data, &q); err != nil {
+		return FlaskQuestion{}, 0, err
+	}
+	return q, 0, nil
+}
+
+// sameFlaskState compares the fields a display shows.
+func sameFlaskState(a, b FlaskQuestion) bool {
+	return a.Question == b.Question && a.Type == b.Type && a.TimeLeft == b.TimeLeft &&
+		a.StartTime.Equal(b.StartTime) && a.CountUp == b.CountUp && a.Paused == b.Paused && a.Reading == b.Reading &&
+		a.Stalled == b.Stalled
+}
+
+func syncTargetConfig() (PushTarget, error) {
+	for _, s := range pushTargetStatuses() {
+		if s.Name == *syncTarget {
+			return s.PushTarget, nil
+		}
+	}
+	return PushTarget{}, fmt.Errorf("no push target named %q", *syncTarget)
+}
+
+func fetchRemoteState() (FlaskQuestion, uint64, error) {
+	target, err := syncTargetConfig()
+	if err != nil {
+		return FlaskQuestion{}, 0, err
+	}
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		return FlaskQuestion{}, 0, err
+	}
+	u.Path, u.RawQuery = *syncPath, ""
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return FlaskQuestion{}, 0, err
+	}
+	if target.AuthHeader != "" {
+		req.Header.Set("Authorization", target.AuthHeader)
+	}
+	resp, err := (&http.Client{Timeout: pushTimeout}).Do(req)
+	if err != nil {
+		return FlaskQuestion{}, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return FlaskQuestion{}, 0, fmt.Errorf("%s: status code %d", u, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return FlaskQuestion{}, 0, err
+	}
+	q, rev, err := decodeFlaskState(data)
+	if err != nil {
+		return FlaskQuestion{}, 0, fmt.Errorf("%s: %v", u, err)
+	}
+	return q, rev, nil
+}
+
+// syncPull compares the local state with the synced server and settles the
+// difference by policy. Both sides having changed since the last push is a
+// conflict that is left to the operator unless policy is forced.
+func syncPull(policy string, forced bool, origin string) (SyncResult, error) {
+	remote, rev, err := fetchRemoteState()
+	if err != nil {
+		return SyncResult{}, err
+	}
+	questionMutex.RLock()
+	local := flaskQuestion(question)
+	questionMutex.RUnlock()
+	syncMutex.Lock()
+	base := syncBase
+	syncMutex.Unlock()
+
+	res := SyncResult{Local: local, Remote: &remote, Revision: rev}
+	switch {
+	case sameFlaskState(local, remote):
+		res.Action, res.Reason = "in_sync", "both sides show the same state"
+	case !forced && base != nil && !sameFlaskState(*base, local) && !sameFlaskState(*base, remote):
+		res.Action, res.Reason = "conflict", "both sides changed since the last push"
+	case policy == syncPreferLocal:
+		res.Action, res.Reason = "pushed", "policy prefers the local state"
+	case policy == syncPreferRemote:
+		res.Action, res.Reason = "adopted", "policy prefers the remote state"
+	case remote.StartTime.After(local.StartTime):
+		res.Action, res.Reason = "adopted", "the remote state is newer"
+	default:
+		res.Action, res.Reason = "pushed", "the local state is newer"
+	}
+
+	switch res.Action {
+	case "pushed":
+		forgetDelivery(*syncTarget)
+		notifyPushTargets(true)
+	case "adopted":
+		adoptFlaskState(remote)
+	}
+	audit("sync", origin, map[string]interface{}{"action": res.Action, "reason": res.Reason, "policy": policy})
+	severity := SeverityInfo
+	if res.Action == "conflict" {
+		severity = SeverityWarning
+	}
+	notify(severity, "sync", "Sync with %s: %s (%s)", *syncTarget, res.Action, res.Reason)
+	return res, nil
+}
+
+// adoptFlaskState makes the remote state the live question, timer and all.
+func adoptFlaskState(r FlaskQuestion) {
+	questionMutex.Lock()
+	question = Question{
+		Question:      r.Question,
+		TimeLeft:      r.TimeLeft,
+		Type:          r.Type,
+		StartTime:     r.StartTime,
+		CountUp:       r.CountUp,
+		AllowOvertime: r.AllowOvertime,
+		ReadingTime:   r.ReadingTime,
+		Reading:       r.Reading,
+		Paused:        r.Paused,
+		PauseReason:   r.PauseReason,
+		PauseMessage:  r.PauseMessage,
+		Stalled:       r.Stalled,
+		Variants:      r.Variants,
+		Options:       r.Options,
+		Duration:      r.TimeLeft,
+	}
+	stateChanged("sync")
+	questionMutex.Unlock()
+	// The other targets follow; the synced one already has it.
+	notifyPushTargets(false)
+}
+
+// runStartupSync is -sync-on-start. An unreachable server is only reported.
+func runStartupSync() {
+	if _, err := syncPull(*syncPolicy, false, "startup"); err != nil {
+		notify(SeverityWarning, "sync", "Startup sync with %s failed: %v", *syncTarget, err)
+	}
+}
+
+// handleSyncCommand runs "sync pull [--local|--remote]".
+func handleSyncCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) < 1 || args[0] != "pull" || len(args) > 2 {
+		return errors.New("Usage: sync pull [--local|--remote]")
+	}
+	policy, forced := *syncPolicy, false
+	if len(args) == 2 {
+		switch args[1] {
+		case "--local":
+			policy, forced = syncPreferLocal, true
+		case "--remote":
+			policy, forced = syncPreferRemote, true
+		default:
+			return errors.New("Usage: sync pull [--local|--remote]")
+		}
+	}
+	res, err := syncPull(policy, forced, origin)
+	if err != nil {
+		return fmt.Errorf("Sync failed: %v", err)
+	}
+	switch res.Action {
+	case "conflict":
+		info.Printf("  local:  %s (%s, started %s)\n", res.Local.Question, res.Local.Type, res.Local.StartTime.Local().Format("15:04:05"))
+		info.Printf("  remote: %s (%s, started %s)\n", res.Remote.Question, res.Remote.Type, res.Remote.StartTime.Local().Format("15:04:05"))
+		return withHint(fmt.Errorf("Conflict: %s", res.Reason), "Resolve with sync pull --local or sync pull --remote")
+	default:
+		success.Printf("Sync: %s (%s)\n", res.Action, res.Reason)
+	}
+	return nil
+}
@@ -1,12 +1,16 @@
-module main
+module github.com/DarkBenky/stuskova
 
 go 1.23.3
 
-require github.com/labstack/echo/v4 v4.12.0
+require (
+	github.com/chzyer/readline v1.5.1
+	github.com/fatih/color v1.18.0
+	github.com/labstack/echo/v4 v4.12.0
+	golang.org/x/net v0.24.0
+	golang.org/x/text v0.14.0
+)
 
 require (
-	github.com/chzyer/readline v1.5.1 // indirect
-	github.com/fatih/color v1.18.0 // indirect
 	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
 	github.com/labstack/gommon v0.4.2 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
@@ -14,8 +18,6 @@ require (
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
 	golang.org/x/crypto v0.22.0 // indirect
-	golang.org/x/net v0.24.0 // indirect
 	golang.org/x/sys v0.25.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
 	golang.org/x/time v0.8.0 // indirect
 )
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+var autoWaitGroupFlag = flag.Duration("auto-wait-group", 0, "waiting screen delay between parts of a question group (0 skips the waiting screen there)")
+
+// A question group is a set of entries sharing a GroupID, such as the parts
+// A and B of one question, that must run one after the other. In the queue
+// a group is a block: its members sit next to each other in their order,
+// and moves, removals and picks from the bank take the whole block. All
+// members have the same type.
+
+// groupSpan is the block of queue entries around index i that share its
+// group, [start, end). An entry without a group is a block of its own.
+func groupSpan(entries []QueueEntry, i int) (start, end int) {
+	start, end = i, i+1
+	g := entries[i].GroupID
+	if g == "" {
+		return start, end
+	}
+	for start > 0 && entries[start-1].GroupID == g {
+		start--
+	}
+	for end < len(entries) && entries[end].GroupID == g {
+		end++
+	}
+	return start, end
+}
+
+// groupPart is which part of its group entry i is and how many parts the
+// group has, both 0 outside a group.
+func groupPart(entries []QueueEntry, i int) (part, size int) {
+	if entries[i].GroupID == "" {
+		return 0, 0
+	}
+	start, end := groupSpan(entries, i)
+	return i - start + 1, end - start
+}
+
+// checkQueueGroups verifies that every group is one block of entries of
+// the same type.
+func checkQueueGroups(entries []QueueEntry) error {
+	done := map[string]bool{}
+	for i := 0; i < len(entries); {
+		g := entries[i].GroupID
+		start, end := groupSpan(entries, i)
+		i = end
+		if g == "" {
+			continue
+		}
+		if done[g] {
+			return fmt.Errorf("the entries of group %q must follow each other", g)
+		}
+		done[g] = true
+		for _, e := range entries[start+1 : end] {
+			if e.Type != entries[start].Type {
+				return fmt.Errorf("group %q mixes %s and %s questions", g, entries[start].Type, e.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// checkGroupOrder verifies that reordered keeps the members of each group
+// in the order they had in before.
+func checkGroupOrder(before, reordered []QueueEntry) error {
+	order := map[string][]int{}
+	for _, e := range before {
+		if e.GroupID != "" {
+			order[e.GroupID] = append(order[e.GroupID], e.ID)
+		}
+	}
+	seen := map[string]int{}
+	for _, e := range reordered {
+		if e.GroupID == "" {
+			continue
+		}
+		if order[e.GroupID][seen[e.GroupID]] != e.ID {
+			return fmt.Errorf("the parts of group %q must keep their order", e.GroupID)
+		}
+		seen[e.GroupID]++
+	}
+	return nil
+}
+
+// checkBankGroups verifies that the members of each bank group have the
+// same type. The bank has no order, so that is all there is to check.
+func checkBankGroups(entries []BankEntry) error {
+	types := map[string]string{}
+	for _, e := range entries {
+		if e.GroupID == "" {
+			continue
+		}
+		if t, ok := types[e.GroupID]; ok && t != e.Type {
+			return fmt.Errorf("group %q mixes %s and %s questions", e.GroupID, t, e.Type)
+		}
+		types[e.GroupID] = e.Type
+	}
+	return nil
+}
+
+// withBankGroups adds to ids the other members of their groups, right after
+// the first of them, in bank order, so a group is picked as a whole.
+func withBankGroups(ids []int) []int {
+	bankMutex.RLock()
+	defer bankMutex.RUnlock()
+	var out []int
+	added := map[int]bool{}
+	for _, id := range ids {
+		group := ""
+		for _, e := range bank {
+			if e.ID == id {
+				group = e.GroupID
+			}
+		}
+		if group == "" {
+			if !added[id] {
+				out, added[id] = append(out, id), true
+			}
+			continue
+		}
+		for _, e := range bank {
+			if e.GroupID == group && !added[e.ID] {
+				out, added[e.ID] = append(out, e.ID), true
+			}
+		}
+	}
+	return out
+}
+
+// groupContinues reports whether the next entry to be asked is the next
+// part of the group of the live one.
+func groupContinues() bool {
+	live, ok := liveQueueEntry()
+	if !ok || live.GroupID == "" {
+		return false
+	}
+	next, ok := nextQueueEntry()
+	return ok && next.GroupID == live.GroupID
+}
+
+// waitingGap is the waiting screen delay after the question that just
+// ended: the shorter -auto-wait-group between the parts of a group.
+func waitingGap(delay time.Duration) time.Duration {
+	if delay > 0 && groupContinues() {
+		return *autoWaitGroupFlag
+	}
+	return delay
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// queueOrder is the IDs of the queue entries, in order, and the revision.
+func queueOrder(t *testing.T) ([]int, uint64) {
+	t.Helper()
+	snap := queueSnapshot()
+	ids := make([]int, len(snap.Entries))
+	for i, e := range snap.Entries {
+		ids[i] = e.ID
+	}
+	return ids, snap.Revision
+}
+
+func TestQueueGroups(t *testing.T) {
+	s := StartTestServer(t)
+	entry := func(q, group, typ string) map[string]interface{} {
+		return map[string]interface{}{"question": q, "type": typ, "time_left": 30_000_000_000, "group_id": group}
+	}
+	var added []QueueEntry
+	if status := s.Do(t, http.MethodPost, "/queue", []map[string]interface{}{
+		entry("Prvá", "", "pomoc"),
+		entry("Rieka A", "rieka", "pomoc"),
+		entry("Rieka B", "rieka", "pomoc"),
+		entry("Hora A", "hora", "pomoc"),
+		entry("Hora B", "hora", "pomoc"),
+	}, &added); status != http.StatusOK || len(added) != 5 {
+		t.Fatalf("queueing: status %d, %+v", status, added)
+	}
+	first, riverA, riverB, hillA, hillB := added[0].ID, added[1].ID, added[2].ID, added[3].ID, added[4].ID
+
+	// A group joined again later, or mixing types, is refused.
+	for name, body := range map[string]interface{}{
+		"a split group": entry("Rieka C", "rieka", "pomoc"),
+		"mixed types":   []map[string]interface{}{entry("Les A", "les", "pomoc"), entry("Les B", "les", "end")},
+	} {
+		if status := s.Do(t, http.MethodPost, "/queue", body, nil); status != http.StatusBadRequest {
+			t.Errorf("%s: status %d", name, status)
+		}
+	}
+
+	move := func(id, position int) int {
+		t.Helper()
+		_, rev := queueOrder(t)
+		return s.Do(t, http.MethodPost, "/queue/move", QueueMoveRequest{ID: id, Position: position, Revision: &rev}, nil)
+	}
+	want := func(what string, ids ...int) {
+		t.Helper()
+		if got, _ := queueOrder(t); fmt.Sprint(got) != fmt.Sprint(ids) {
+			t.Errorf("%s: order %v, want %v", what, got, ids)
+		}
+	}
+	// A part moves its whole group, to either end but not into another group.
+	if status := move(riverB, 4); status != http.StatusOK {
+		t.Errorf("moving a group to the end: status %d", status)
+	}
+	want("group at the end", first, hillA, hillB, riverA, riverB)
+	if status := move(hillB, 1); status != http.StatusOK {
+		t.Errorf("moving a group to the front: status %d", status)
+	}
+	want("group at the front", hillA, hillB, first, riverA, riverB)
+	if status := move(first, 2); status != http.StatusBadRequest {
+		t.Errorf("moving into a group: status %d", status)
+	}
+	if status := move(first, 6); status != http.StatusBadRequest {
+		t.Errorf("moving past the end: status %d", status)
+	}
+
+	reorder := func(ids ...int) int {
+		t.Helper()
+		_, rev := queueOrder(t)
+		return s.Do(t, http.MethodPut, "/queue/order", QueueOrderRequest{Order: ids, Revision: &rev}, nil)
+	}
+	if status := reorder(hillA, first, hillB, riverA, riverB); status != http.StatusBadRequest {
+		t.Errorf("a split order: status %d", status)
+	}
+	if status := reorder(hillB, hillA, first, riverA, riverB); status != http.StatusBadRequest {
+		t.Errorf("swapped parts: status %d", status)
+	}
+	if status := reorder(riverA, riverB, first, hillA, hillB); status != http.StatusOK {
+		t.Errorf("a whole-group order: status %d", status)
+	}
+
+	// The metadata counts the parts, and the waiting screen is skipped
+	// between them.
+	s.MustDo(t, http.MethodPost, "/queue/next?force=true", nil)
+	if m := liveMeta(t, s); m.GroupID != "rieka" || m.GroupPart != 1 || m.GroupSize != 2 {
+		t.Errorf("part A: %+v", m)
+	}
+	if gap := waitingGap(10 * time.Second); gap != *autoWaitGroupFlag {
+		t.Errorf("gap inside a group %s", gap)
+	}
+	s.MustDo(t, http.MethodPost, "/queue/next?force=true", nil)
+	if m := liveMeta(t, s); m.GroupPart != 2 || m.GroupSize != 2 {
+		t.Errorf("part B: %+v", m)
+	}
+	if gap := waitingGap(10 * time.Second); gap != 10*time.Second {
+		t.Errorf("gap after a group %s", gap)
+	}
+	s.MustDo(t, http.MethodPost, "/queue/next?force=true", nil)
+	if m := liveMeta(t, s); m.GroupID != "" || m.GroupSize != 0 {
+		t.Errorf("an entry without a group: %+v", m)
+	}
+
+	// Removing a part removes its group.
+	if status := s.DoConfirmed(t, http.MethodDelete, fmt.Sprintf("/queue/%d", hillB), nil, nil); status != http.StatusNoContent {
+		t.Errorf("removing: status %d", status)
+	}
+	want("group removed", riverA, riverB, first)
+	if e := lastAudit(t, "queue_remove"); fmt.Sprint(e.Details["removed"]) != fmt.Sprint([]interface{}{float64(hillA), float64(hillB)}) {
+		t.Errorf("removal audited as %+v", e)
+	}
+}
+
+func TestBankGroups(t *testing.T) {
+	s := StartTestServer(t)
+	add := func(q, group, typ string) (int, int) {
+		t.Helper()
+		var e BankEntry
+		status := s.Do(t, http.MethodPost, "/bank", map[string]interface{}{"question": q, "type": typ, "time_left": 30_000_000_000, "group_id": group}, &e)
+		return e.ID, status
+	}
+	a, _ := add("Rieka A", "rieka", "pomoc")
+	other, _ := add("Samostatná", "", "pomoc")
+	b, _ := add("Rieka B", "rieka", "pomoc")
+	if _, status := add("Rieka C", "rieka", "end"); status != http.StatusBadRequest {
+		t.Errorf("a group of mixed types: status %d", status)
+	}
+	if _, err := parseBank([]byte(`[{"question":"A","type":"pomoc","group_id":"g"},{"question":"B","type":"end","group_id":"g"}]`)); err == nil {
+		t.Error("a bank file with a mixed group loaded")
+	}
+
+	// Queueing part B queues the whole group, in bank order.
+	var added []QueueEntry
+	if status := s.Do(t, http.MethodPost, fmt.Sprintf("/bank/%d/queue", b), nil, &added); status != http.StatusOK {
+		t.Fatalf("queueing: status %d", status)
+	}
+	if len(added) != 2 || added[0].BankID != a || added[1].BankID != b || added[0].GroupID != "rieka" {
+		t.Errorf("queued %+v", added)
+	}
+	if got := withBankGroups([]int{other, b, a}); fmt.Sprint(got) != fmt.Sprint([]int{other, a, b}) {
+		t.Errorf("picked %v", got)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const stormBuckets = 20
+
+var (
+	stormLimit  = flag.Int("storm-limit", 10, "maximum HTTP state mutations allowed per storm window (0 disables the guard)")
+	stormWindow = flag.Duration("storm-window", 2*time.Second, "sliding window used by the mutation storm guard")
+)
+
+// slidingWindow counts events over the last window using fixed sub-buckets,
+// which keeps memory bounded no matter how fast events arrive.
+type slidingWindow struct {
+	mu      sync.Mutex
+	window  time.Duration
+	width   time.Duration
+	buckets [stormBuckets]int
+	starts  [stormBuckets]time.Time
+}
+
+func newSlidingWindow(window time.Duration) *slidingWindow {
+	width := window / stormBuckets
+	if width <= 0 {
+		width = time.Nanosecond
+	}
+	return &slidingWindow{window: window, width: width}
+}
+
+// add records an event at now and returns the number of events in the
+// window ending at now, including this one.
+func (w *slidingWindow) add(now time.Time) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	start := now.Truncate(w.width)
+	i := int(start.UnixNano()/int64(w.width)) % stormBuckets
+	if !w.starts[i].Equal(start) {
+		w.starts[i] = start
+		w.buckets[i] = 0
+	}
+	w.buckets[i]++
+
+	total := 0
+	for j := range w.buckets {
+		if now.Sub(w.starts[j]) < w.window {
+			total += w.buckets[j]
+		}
+	}
+	return total
+}
+
+// mutationGuard rejects HTTP mutations while clients are sending them faster
+// than the configured rate. Every attempt counts, so a client stuck in a
+// loop stays blocked until it slows down.
+type mutationGuard struct {
+	limit   int
+	counter *slidingWindow
+
+	mu       sync.Mutex
+	storming bool
+	rejected int
+}
+
+var stormGuard *mutationGuard
+
+func newMutationGuard(limit int, window time.Duration) *mutationGuard {
+	return &mutationGuard{limit: limit, counter: newSlidingWindow(window)}
+}
+
+func (g *mutationGuard) allow(path, remote string) bool {
+	count := g.counter.add(clock.Now())
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if count <= g.limit {
+		if g.storming {
+			g.storming = false
+			audit("mutation_storm_end", "http", map[string]interface{}{"rejected": g.rejected})
+			notify(SeverityInfo, "storm", "Mutation storm over, %d requests were rejected", g.rejected)
+		}
+		return true
+	}
+	if !g.storming {
+		g.storming = true
+		g.rejected = 0
+		audit("mutation_storm", "http", map[string]interface{}{"path": path, "remote": remote, "rate": count})
+		notify(SeverityWarning, "storm", "Mutation storm from %s on %s, rejecting HTTP changes", remote, path)
+	}
+	g.rejected++
+	return false
+}
+
+// guardMutation is middleware for state-changing endpoints. CLI commands
+// never pass through it.
+func guardMutation(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if stormGuard != nil && !stormGuard.allow(c.Path(), c.RealIP()) {
+			return apiError(http.StatusTooManyRequests, "mutation_storm", "too many state changes, slow down")
+		}
+		return next(c)
+	}
+}
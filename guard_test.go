@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	w := newSlidingWindow(2 * time.Second)
+	for i, want := range []int{1, 2, 3} {
+		if got := w.add(testEpoch); got != want {
+			t.Errorf("event %d: %d in the window", i, got)
+		}
+	}
+	for _, tc := range []struct {
+		at   time.Duration
+		want int
+	}{
+		{1900 * time.Millisecond, 4},
+		// The first bucket has left the window and its slot starts over.
+		{2050 * time.Millisecond, 2},
+		// So does the slot of the one at 1.9s.
+		{3950 * time.Millisecond, 2},
+		{6 * time.Second, 1},
+	} {
+		if got := w.add(testEpoch.Add(tc.at)); got != tc.want {
+			t.Errorf("at %s: %d in the window, want %d", tc.at, got, tc.want)
+		}
+	}
+}
+
+// TestMutationGuard lets three changes through every two seconds and
+// checks the storm is reported once, and its end too.
+func TestMutationGuard(t *testing.T) {
+	StartTestServer(t)
+	notified := len(listNotifications())
+	g := newMutationGuard(3, 2*time.Second)
+	for i := 0; i < 3; i++ {
+		if !g.allow("/pause", "10.0.0.7") {
+			t.Fatalf("change %d rejected", i)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if g.allow("/pause", "10.0.0.7") {
+			t.Fatalf("change %d over the limit allowed", i)
+		}
+	}
+	if e := lastAudit(t, "mutation_storm"); e.Details["path"] != "/pause" || e.Details["remote"] != "10.0.0.7" || e.Details["rate"] != 4 {
+		t.Errorf("audited as %+v", e)
+	}
+
+	AdvanceClock(t, 2*time.Second)
+	if !g.allow("/pause", "10.0.0.7") {
+		t.Fatal("rejected once the window passed")
+	}
+	if e := lastAudit(t, "mutation_storm_end"); e.Details["rejected"] != 2 {
+		t.Errorf("audited as %+v", e)
+	}
+	n := listNotifications()[notified:]
+	if len(n) != 2 || n[0].Severity != SeverityWarning || n[1].Severity != SeverityInfo || n[1].Category != "storm" {
+		t.Errorf("notifications %+v", n)
+	}
+}
+
+// TestGuardMutation storms the REST endpoints and the socket commands,
+// which share one guard.
+func TestGuardMutation(t *testing.T) {
+	s := StartTestServer(t)
+	defer func(g *mutationGuard) { stormGuard = g }(stormGuard)
+	stormGuard = newMutationGuard(2, 2*time.Second)
+
+	adjust := func() (int, APIError) {
+		t.Helper()
+		var res struct {
+			Error APIError `json:"error"`
+		}
+		status := s.Do(t, http.MethodPost, "/v2/time/adjust", map[string]int{"delta_seconds": 1}, &res)
+		return status, res.Error
+	}
+	for i := 0; i < 2; i++ {
+		if status, e := adjust(); status != http.StatusOK {
+			t.Fatalf("change %d: status %d, %+v", i, status, e)
+		}
+	}
+	if status, e := adjust(); status != http.StatusTooManyRequests || e.Code != "mutation_storm" {
+		t.Errorf("over the limit: status %d, %+v", status, e)
+	}
+	// Reads don't count.
+	if status := s.Do(t, http.MethodGet, "/get-question/full", nil, nil); status != http.StatusOK {
+		t.Errorf("a read during the storm: status %d", status)
+	}
+
+	AdvanceClock(t, 2*time.Second)
+	ws := dialWS(t, s, testKey)
+	for i, cmd := range []string{"pause", "resume"} {
+		if res := wsSend(t, ws, WSCommand{ID: cmd, Cmd: cmd}); !res.OK {
+			t.Fatalf("command %d: %+v", i, res.Error)
+		}
+	}
+	if res := wsSend(t, ws, WSCommand{ID: "3", Cmd: "pause"}); res.OK || wsErrorCode(res) != "mutation_storm" {
+		t.Errorf("over the limit on the socket: %+v", res)
+	}
+	if status, _ := adjust(); status != http.StatusTooManyRequests {
+		t.Errorf("REST after the socket storm: status %d", status)
+	}
+}
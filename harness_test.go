@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The integration tests run the real Echo server on a random port, with a
+// fake Flask server as its only push target and the manual clock, so a
+// countdown runs out the moment a test advances it. The server's state is
+// global like in production: there is one server per test binary, and
+// StartTestServer puts it back to a fresh game for each test.
+
+const (
+	testKey = "test-key"
+	// waitTimeout is how long, in real time, a test waits for the server.
+	waitTimeout = 2 * time.Second
+)
+
+var testEpoch = time.Date(2025, 3, 14, 18, 0, 0, 0, time.UTC)
+
+var (
+	testServer     *TestServer
+	testServerOnce sync.Once
+)
+
+// TestServer is the server under test.
+type TestServer struct {
+	URL   string
+	Flask *FakeFlask
+	Clock *ManualClock
+}
+
+// FakeFlask stands in for the Flask server and keeps every payload pushed
+// to it.
+type FakeFlask struct {
+	*httptest.Server
+	mu       sync.Mutex
+	payloads []map[string]interface{}
+}
+
+func newFakeFlask() *FakeFlask {
+	f := &FakeFlask{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.payloads = append(f.payloads, p)
+		f.mu.Unlock()
+	}))
+	return f
+}
+
+// Payloads returns what was pushed so far, oldest first.
+func (f *FakeFlask) Payloads() []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]map[string]interface{}(nil), f.payloads...)
+}
+
+// Last returns the newest payload, or nil before the first.
+func (f *FakeFlask) Last() map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.payloads) == 0 {
+		return nil
+	}
+	return f.payloads[len(f.payloads)-1]
+}
+
+func (f *FakeFlask) reset() {
+	f.mu.Lock()
+	f.payloads = nil
+	f.mu.Unlock()
+}
+
+// WaitFor waits until a payload for which match is true has arrived and
+// returns it.
+func (f *FakeFlask) WaitFor(t *testing.T, what string, match func(p map[string]interface{}) bool) map[string]interface{} {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		for _, p := range f.Payloads() {
+			if match(p) {
+				return p
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s, pushed: %v", what, f.Payloads())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	dir, err := os.MkdirTemp("", "stuskova-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	*stateDir = filepath.Join(dir, "state")
+	*sessionsDir = filepath.Join(dir, "sessions")
+	*mediaDir = filepath.Join(dir, "media")
+	*reportDir = filepath.Join(dir, "reports")
+	*shutdownSnapshot = ""
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// StartTestServer returns the server, started on first use, with a fresh
+// game: the default question, no teams, queue, bank or history, and
+// nothing pushed yet.
+func StartTestServer(t *testing.T) *TestServer {
+	t.Helper()
+	testServerOnce.Do(func() {
+		mc := NewManualClock(testEpoch)
+		clock = mc
+		time.Local = time.UTC
+		*apiKey = testKey
+		// A storm guard would see a test as a storm, and the push worker
+		// would wait for the manual clock to coalesce.
+		*stormLimit = 0
+		*pushCoalesce = 0
+		flask := newFakeFlask()
+		initializeQuestion()
+		if err := setPushTarget(PushTarget{Name: "flask", URL: flask.URL + "/set-current-question", Enabled: true}); err != nil {
+			panic(err)
+		}
+		workers.goWorker("expiry", watchExpiry)
+		workers.goWorker("ticks", ticks.run)
+		srv := httptest.NewServer(setupServer())
+		testServer = &TestServer{URL: srv.URL, Flask: flask, Clock: mc}
+	})
+
+	queueMutex.Lock()
+	queue, liveEntry = nil, 0
+	queueMutex.Unlock()
+	teamsMutex.Lock()
+	teams = nil
+	teamsMutex.Unlock()
+	bankMutex.Lock()
+	installBank(nil)
+	bankMutex.Unlock()
+	resetGame("test")
+	initializeQuestion()
+	sendCurrentQuestion()
+	eventually(t, "the default question pushed", func() bool { return pendingPushCount() == 0 })
+	testServer.Flask.reset()
+	return testServer
+}
+
+// AdvanceClock moves the test server's clock forward by d, firing the
+// timers that are due.
+func AdvanceClock(t *testing.T, d time.Duration) {
+	t.Helper()
+	if testServer == nil {
+		t.Fatal("AdvanceClock before StartTestServer")
+	}
+	testServer.Clock.Advance(d)
+}
+
+// Do sends an operator request with body encoded as JSON, unless it is
+// nil, decodes the response into out, unless that is nil, and returns the
+// status.
+func (s *TestServer) Do(t *testing.T, method, path string, body, out interface{}) int {
+	t.Helper()
+	return s.Send(t, s.NewRequest(t, method, path, body), out).StatusCode
+}
+
+// NewRequest is the request Do sends, for a test that adds headers.
+func (s *TestServer) NewRequest(t *testing.T, method, path string, body interface{}) *http.Request {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequest(method, s.URL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("X-API-Key", testKey)
+	return req
+}
+
+// Send sends req and decodes the response into out, unless that is nil.
+// The body is read and closed.
+func (s *TestServer) Send(t *testing.T, req *http.Request, out interface{}) *http.Response {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil && json.Unmarshal(raw, out) != nil {
+		t.Fatalf("%s %s: status %d, unexpected body %s", req.Method, req.URL.Path, resp.StatusCode, raw)
+	}
+	return resp
+}
+
+// DoConfirmed is Do for an endpoint behind requireConfirmation: it repeats
+// the request with the token the first call was answered with.
+func (s *TestServer) DoConfirmed(t *testing.T, method, path string, body, out interface{}) int {
+	t.Helper()
+	resp := s.Send(t, s.NewRequest(t, method, path, body), nil)
+	if resp.StatusCode != http.StatusPreconditionRequired {
+		t.Fatalf("%s %s: status %d without a confirmation", method, path, resp.StatusCode)
+	}
+	req := s.NewRequest(t, method, path, body)
+	req.Header.Set(confirmHeader, resp.Header.Get(confirmHeader))
+	return s.Send(t, req, out).StatusCode
+}
+
+// MustDo is Do for a JSON object, failing the test unless the status is
+// 2xx.
+func (s *TestServer) MustDo(t *testing.T, method, path string, body interface{}) map[string]interface{} {
+	t.Helper()
+	var out map[string]interface{}
+	if status := s.Do(t, method, path, body, &out); status/100 != 2 {
+		t.Fatalf("%s %s: status %d, body %v", method, path, status, out)
+	}
+	return out
+}
+
+// eventually waits, in real time, for cond to hold. The server reacts to
+// the manual clock on its own goroutines, so a test waits for it.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"sync"
+	"time"
+)
+
+var stallThreshold = flag.Duration("stall-threshold", 2*time.Second, "mark public payloads degraded once the internal heartbeat is this late (0 disables)")
+
+const heartbeatInterval = 250 * time.Millisecond
+
+// A heartbeat goroutine takes the state locks every heartbeatInterval, as
+// handlers do, and notes when it got through. When it is late, handlers are
+// stuck behind a lock or the process isn't being scheduled, and displays
+// would show a frozen clock as if all was well; publicQuestion marks the
+// payload degraded instead. The watcher that raises the notification takes
+// none of the state locks, so it still runs during a deadlock.
+var (
+	heartbeatMutex sync.Mutex
+	lastHeartbeat  time.Time
+	maxStall       time.Duration
+)
+
+// runHeartbeat is the heartbeat. It measures with the monotonic clock:
+// liveness is about this process, not the show's clock.
+func runHeartbeat() {
+	for {
+		questionMutex.Lock()
+		questionMutex.Unlock()
+		roundMutex.Lock()
+		roundMutex.Unlock()
+		now := time.Now()
+		heartbeatMutex.Lock()
+		if !lastHeartbeat.IsZero() {
+			maxStall = max(maxStall, now.Sub(lastHeartbeat)-heartbeatInterval)
+		}
+		lastHeartbeat = now
+		heartbeatMutex.Unlock()
+		time.Sleep(heartbeatInterval)
+	}
+}
+
+// heartbeatStall returns how late the heartbeat is, and whether that is
+// past -stall-threshold.
+func heartbeatStall() (time.Duration, bool) {
+	heartbeatMutex.Lock()
+	defer heartbeatMutex.Unlock()
+	if lastHeartbeat.IsZero() || *stallThreshold <= 0 {
+		return 0, false
+	}
+	age := max(time.Since(lastHeartbeat)-heartbeatInterval, 0)
+	maxStall = max(maxStall, age)
+	return age, age > *stallThreshold
+}
+
+// heartbeatMaxStall is the longest the heartbeat has been late.
+func heartbeatMaxStall() time.Duration {
+	heartbeatStall()
+	heartbeatMutex.Lock()
+	defer heartbeatMutex.Unlock()
+	return maxStall
+}
+
+// watchHeartbeat notifies when the heartbeat stalls and when it is back.
+func watchHeartbeat() {
+	var stalled time.Duration
+	for {
+		time.Sleep(heartbeatInterval)
+		stalled = checkHeartbeat(stalled)
+	}
+}
+
+// checkHeartbeat notifies a stall as it starts and ends, given how late the
+// heartbeat was at the last check, and returns how late it is now, 0 when
+// it isn't.
+func checkHeartbeat(stalled time.Duration) time.Duration {
+	age, late := heartbeatStall()
+	if late {
+		if stalled == 0 {
+			notify(SeverityError, "heartbeat", "State has not updated for %s, displays are marked degraded", age.Round(time.Millisecond))
+		}
+		return age
+	}
+	if stalled > 0 {
+		notify(SeverityInfo, "heartbeat", "State updates again after a stall of about %s", stalled.Round(100*time.Millisecond))
+		audit("stall", "system", map[string]interface{}{"seconds": stalled.Seconds()})
+	}
+	return 0
+}
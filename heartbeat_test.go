@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestHeartbeatStall sets the heartbeat back as a stalled one would leave
+// it, and checks the payload, the notifications, the audit and the metric.
+func TestHeartbeatStall(t *testing.T) {
+	s := StartTestServer(t)
+	beat := func(ago time.Duration) {
+		heartbeatMutex.Lock()
+		lastHeartbeat = time.Now().Add(-ago)
+		heartbeatMutex.Unlock()
+	}
+	defer func(d time.Duration) {
+		*stallThreshold = d
+		heartbeatMutex.Lock()
+		lastHeartbeat, maxStall = time.Time{}, 0
+		heartbeatMutex.Unlock()
+	}(*stallThreshold)
+	*stallThreshold = 2 * time.Second
+
+	public := func() PublicQuestionView {
+		t.Helper()
+		// The poll cache keeps a payload for a moment of the show's clock.
+		AdvanceClock(t, time.Second)
+		var q PublicQuestionView
+		s.Do(t, http.MethodGet, "/get-question", nil, &q)
+		return q
+	}
+	notified := len(listNotifications())
+	beat(0)
+	if q := public(); q.Degraded || checkHeartbeat(0) != 0 {
+		t.Errorf("a live heartbeat: degraded %v", q.Degraded)
+	}
+
+	beat(3 * time.Second)
+	q := public()
+	if !q.Degraded || q.StallAge < 2700*time.Millisecond || q.StallAge > 3*time.Second {
+		t.Errorf("stalled: degraded %v, age %s", q.Degraded, q.StallAge)
+	}
+	stalled := checkHeartbeat(0)
+	stalled = checkHeartbeat(stalled)
+	if stalled < 2700*time.Millisecond {
+		t.Errorf("stalled for %s", stalled)
+	}
+	func() {
+		defer func(d time.Duration) { *stallThreshold = d }(*stallThreshold)
+		*stallThreshold = 0
+		if public().Degraded {
+			t.Error("degraded with -stall-threshold 0")
+		}
+	}()
+
+	beat(0)
+	if public().Degraded || checkHeartbeat(stalled) != 0 {
+		t.Error("still degraded after the stall")
+	}
+	n := listNotifications()[notified:]
+	if len(n) != 2 || n[0].Severity != SeverityError || n[1].Severity != SeverityInfo || n[1].Category != "heartbeat" {
+		t.Errorf("notifications %+v", n)
+	}
+	if e := lastAudit(t, "stall"); e.Details["seconds"] != stalled.Seconds() {
+		t.Errorf("audited as %+v", e)
+	}
+
+	resp, err := http.DefaultClient.Do(s.NewRequest(t, http.MethodGet, "/metrics", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`(?m)^quiz_stall_max_seconds (\S+)$`).FindSubmatch(body)
+	if m == nil {
+		t.Fatalf("no quiz_stall_max_seconds in\n%s", body)
+	}
+	if v, _ := strconv.ParseFloat(string(m[1]), 64); v < 2.7 {
+		t.Errorf("quiz_stall_max_seconds %s", m[1])
+	}
+}
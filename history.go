@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// HistoryEntry is one question asked this session. Record is filled in
+// once, when the question ends, and never changes afterwards.
+type HistoryEntry struct {
+	ID        int             `json:"id"`
+	Question  string          `json:"question"`
+	Type      string          `json:"type"`
+	TimeLeft  time.Duration   `json:"time_left"`
+	CountUp   bool            `json:"count_up"`
+	StartedAt time.Time       `json:"started_at"`
+	Scoring   *ScoringPolicy  `json:"scoring,omitempty"`
+	Matching  *MatchRules     `json:"matching,omitempty"`
+	Record    *QuestionRecord `json:"record,omitempty"`
+	// AnswerWindow is how long answers counted, if less than the countdown.
+	AnswerWindow time.Duration `json:"answer_window,omitempty"`
+	// BankID is the bank entry the question came from and BankHash the
+	// hash of that entry's text when it was asked, see bankstats.go.
+	BankID   int    `json:"bank_id,omitempty"`
+	BankHash string `json:"bank_hash,omitempty"`
+	// Round and RoundName are the queue round the question was asked in.
+	Round     int    `json:"round,omitempty"`
+	RoundName string `json:"round_name,omitempty"`
+	// Options, Variants and MediaURL are what the audience was shown with
+	// the question.
+	Options         []AnswerOption    `json:"options,omitempty"`
+	OptionsRevealAt time.Duration     `json:"options_reveal_at,omitempty"`
+	Variants        map[string]string `json:"variants,omitempty"`
+	MediaURL        string            `json:"media_url,omitempty"`
+}
+
+// QuestionRecord is the frozen outcome of a question, kept for disputes.
+type QuestionRecord struct {
+	EndedAt     time.Time     `json:"ended_at"`
+	Reason      string        `json:"reason"`
+	Answers     []Answer      `json:"answers"`
+	BuzzOrder   []string      `json:"buzz_order"`
+	Eliminated  []string      `json:"eliminated"`
+	ScoreDeltas []ScoreDelta  `json:"score_deltas"`
+	Paused      time.Duration `json:"paused"`
+	FloorClosed *time.Time    `json:"floor_closed,omitempty"`
+	// WindowClosed is when the answer window actually closed the floor.
+	WindowClosed *time.Time `json:"window_closed,omitempty"`
+	// Breakdown is what each answer earned under the question's scoring
+	// policy if judged correct; ScoreDeltas shows what was awarded.
+	Breakdown []ScoreBreakdown `json:"breakdown"`
+	// Verdicts are the answer classifications for a free-text question,
+	// with the operator's decisions.
+	Verdicts []Verdict `json:"verdicts,omitempty"`
+	// OptionsRevealed is when hidden options were shown, see
+	// optionsreveal.go.
+	OptionsRevealed *time.Time `json:"options_revealed,omitempty"`
+	// Pauses are the spans the question was paused, for the fairness
+	// report.
+	Pauses []TimeSpan `json:"pauses,omitempty"`
+}
+
+// ScoreDelta is a score change made while the question was live.
+type ScoreDelta struct {
+	Team  string    `json:"team"`
+	Delta int       `json:"delta"`
+	Time  time.Time `json:"time"`
+}
+
+// history holds every question of the session; historyCurrent is the live
+// one until it is finalized. Score changes and pauses are collected from the
+// audit log as they happen, like the statistics.
+var (
+	historyMutex   sync.Mutex
+	history        []*HistoryEntry
+	historyNextID  = 1
+	historyCurrent *HistoryEntry
+	historyScores  []ScoreDelta
+	historyPaused  time.Duration
+	historyPauses  []TimeSpan
+	// historyPauseFrom is when the pause in progress began, or zero.
+	historyPauseFrom time.Time
+)
+
+// startHistoryEntry opens an entry for a question that has just gone live.
+func startHistoryEntry(q Question) {
+	if q.Type == "end" || q.Type == "waiting" {
+		return
+	}
+	bankHash := askedBankHash(q.BankID)
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	e := &HistoryEntry{
+		ID:        historyNextID,
+		Question:  q.Question,
+		Type:      q.Type,
+		TimeLeft:  q.TimeLeft,
+		CountUp:   q.CountUp,
+		StartedAt: q.StartTime,
+		Scoring:   q.Scoring,
+		Matching:  q.Matching,
+
+		AnswerWindow:    q.AnswerWindow,
+		BankID:          q.BankID,
+		BankHash:        bankHash,
+		Options:         q.Options,
+		OptionsRevealAt: q.OptionsRevealAt,
+		Variants:        q.Variants,
+		MediaURL:        q.MediaURL,
+	}
+	if q.Meta != nil {
+		e.Round, e.RoundName = q.Meta.Round, q.Meta.RoundName
+	}
+	historyNextID++
+	history = append(history, e)
+	historyCurrent = e
+	historyScores = nil
+	historyPaused = 0
+	historyPauses = nil
+	historyPauseFrom = time.Time{}
+}
+
+// finalizeQuestion freezes the record of the live question. Every way a
+// question ends goes through here: expiry, setting the type to end or
+// waiting, and a new question replacing it. Only the first call for an
+// entry writes the record, so a question that is revived with more time and
+// ends again keeps its original record.
+func finalizeQuestion(reason string) {
+	roundMutex.Lock()
+	answered := make([]Answer, 0, len(answers))
+	for _, a := range answers {
+		answered = append(answered, a)
+	}
+	buzzes := append([]string{}, buzzOrder...)
+	out := append([]string{}, eliminated...)
+	closed, windowAt, revealed := floorClosed, windowClosed, optionsRevealed
+	decided := make(map[string]Verdict, len(adjudications))
+	for k, v := range adjudications {
+		decided[k] = v
+	}
+	roundMutex.Unlock()
+	sort.Slice(answered, func(i, j int) bool { return answered[i].Time.Before(answered[j].Time) })
+
+	historyMutex.Lock()
+	e := historyCurrent
+	if e == nil || e.Record != nil {
+		historyMutex.Unlock()
+		return
+	}
+	now := clock.Now()
+	pauses := append([]TimeSpan{}, historyPauses...)
+	if !historyPauseFrom.IsZero() {
+		pauses = append(pauses, TimeSpan{From: historyPauseFrom, To: now})
+	}
+	e.Record = &QuestionRecord{
+		EndedAt:     now,
+		Reason:      reason,
+		Answers:     answered,
+		BuzzOrder:   buzzes,
+		Eliminated:  out,
+		ScoreDeltas: append([]ScoreDelta{}, historyScores...),
+		Paused:      historyPaused,
+		FloorClosed: closed,
+		Breakdown:   scoreBreakdown(e.Scoring, answered),
+		Verdicts:    classifyAnswers(e.Matching, answered, decided),
+
+		WindowClosed:    windowAt,
+		OptionsRevealed: revealed,
+		Pauses:          pauses,
+	}
+	historyCurrent = nil
+	id := e.ID
+	outcomes := teamOutcomes(e.Record)
+	historyMutex.Unlock()
+
+	audit("finalize", "server", map[string]interface{}{"history_id": id, "reason": reason, "answers": len(answered), "outcomes": outcomes})
+}
+
+// observeHistory collects score changes and pauses for the live entry.
+func observeHistory(e AuditEntry) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if historyCurrent == nil {
+		return
+	}
+	switch e.Action {
+	case "score":
+		team, _ := e.Details["team"].(string)
+		delta, _ := e.Details["delta"].(int)
+		historyScores = append(historyScores, ScoreDelta{Team: team, Delta: delta, Time: e.Time})
+	case "pause":
+		historyPauseFrom = e.Time
+	case "resume":
+		paused := detailSeconds(e.Details["duration"])
+		historyPaused += paused
+		from := historyPauseFrom
+		if from.IsZero() {
+			from = e.Time.Add(-paused)
+		}
+		historyPauses = append(historyPauses, TimeSpan{From: from, To: e.Time})
+		historyPauseFrom = time.Time{}
+	}
+}
+
+// liveHistoryID is the ID of the live question's entry, or 0.
+func liveHistoryID() int {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if historyCurrent == nil {
+		return 0
+	}
+	return historyCurrent.ID
+}
+
+// listHistory returns copies of the entries, oldest first.
+func listHistory() []HistoryEntry {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	out := make([]HistoryEntry, len(history))
+	for i, e := range history {
+		out[i] = *e
+	}
+	return out
+}
+
+func findHistoryEntry(id int) (HistoryEntry, bool) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	for _, e := range history {
+		if e.ID == id {
+			return *e, true
+		}
+	}
+	return HistoryEntry{}, false
+}
+
+// installHistory replaces the history, as restored from a backup. Nothing
+// restored is live, so no entry is current.
+func installHistory(entries []HistoryEntry) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	history = nil
+	historyNextID = 1
+	for i := range entries {
+		e := entries[i]
+		history = append(history, &e)
+		if e.ID >= historyNextID {
+			historyNextID = e.ID + 1
+		}
+	}
+	historyCurrent = nil
+}
+
+// resumeHistoryEntry makes the last entry the live one again if it never
+// got its record, as after recovering from a crash mid-question.
+func resumeHistoryEntry() {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if n := len(history); n > 0 && history[n-1].Record == nil {
+		historyCurrent = history[n-1]
+	}
+}
+
+// getHistory lists the entries without their records.
+func getHistory(c echo.Context) error {
+	entries := listHistory()
+	for i := range entries {
+		entries[i].Record = nil
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+func getHistoryEntry(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return badRequest("invalid history id")
+	}
+	e, ok := findHistoryEntry(id)
+	if !ok {
+		return notFound(fmt.Sprintf("history entry %d not found", id))
+	}
+	return c.JSON(http.StatusOK, e)
+}
+
+func handleHistoryCommand(args []string, origin string) error {
+	info := color.New(color.FgYellow)
+
+	if len(args) == 0 {
+		entries := listHistory()
+		if len(entries) == 0 {
+			info.Println("No questions asked yet")
+			return nil
+		}
+		for _, e := range entries {
+			state := "live"
+			if e.Record != nil {
+				state = e.Record.Reason
+			}
+			info.Printf("  #%-4d %s %-8s %-9s %s\n", e.ID, e.StartedAt.Local().Format("15:04:05"), e.Type, state, e.Question)
+		}
+		return nil
+	}
+	id, err := strconv.Atoi(args[0])
+	if len(args) == 2 && args[1] == "fairness" && err == nil {
+		return printFairnessReport(id)
+	}
+	if len(args) != 1 || err != nil {
+		return errors.New("Usage: history [<id> [fairness]]")
+	}
+	e, ok := findHistoryEntry(id)
+	if !ok {
+		return fmt.Errorf("History entry %d not found", id)
+	}
+	info.Printf("#%d %s (%s, started %s)\n", e.ID, e.Question, e.Type, e.StartedAt.Local().Format("15:04:05"))
+	if e.Record == nil {
+		info.Println("Still live, nothing recorded yet")
+		return nil
+	}
+	r := e.Record
+	info.Printf("Ended %s (%s), paused %s\n", r.EndedAt.Local().Format("15:04:05"), r.Reason, r.Paused.Round(time.Second))
+	for _, a := range r.Answers {
+		info.Printf("  %s %-12s %s\n", a.Time.Local().Format("15:04:05"), a.Team, a.Answer)
+	}
+	if len(r.BuzzOrder) > 0 {
+		info.Printf("Buzz order: %v\n", r.BuzzOrder)
+	}
+	for _, d := range r.ScoreDeltas {
+		info.Printf("  %-12s %+d\n", d.Team, d.Delta)
+	}
+	return nil
+}
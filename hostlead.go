@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+var hostLeadFlag = flag.Duration("host-lead", 0, "how far the host's private countdown runs ahead of the audience clock")
+
+// The host lead is how much earlier the host's countdown reaches zero than
+// the one on screen, so they can wrap up before the buzzer. It is set per
+// show; a question's own HostLead, when set, wins over it. Only operator
+// payloads carry the host clock, and nothing acts on it reaching zero.
+var (
+	hostLeadMutex sync.Mutex
+	hostLead      time.Duration
+)
+
+// configureHostLead applies -host-lead.
+func configureHostLead() {
+	hostLeadMutex.Lock()
+	hostLead = max(*hostLeadFlag, 0)
+	hostLeadMutex.Unlock()
+}
+
+func showHostLead() time.Duration {
+	hostLeadMutex.Lock()
+	defer hostLeadMutex.Unlock()
+	return hostLead
+}
+
+func setHostLead(d time.Duration, origin string) {
+	hostLeadMutex.Lock()
+	changed := hostLead != d
+	hostLead = d
+	hostLeadMutex.Unlock()
+	if !changed {
+		return
+	}
+	audit("host_lead", origin, map[string]interface{}{"seconds": d.Seconds()})
+	questionMutex.Lock()
+	stateChanged("host_lead")
+	questionMutex.Unlock()
+}
+
+// questionHostLead is the lead for q.
+func questionHostLead(q Question) time.Duration {
+	if q.HostLead > 0 {
+		return q.HostLead
+	}
+	return showHostLead()
+}
+
+// hostTimeLeft is what the host's countdown shows for the derived payload
+// q: its time left less lead, never below zero. Only countdowns in their
+// answer time have one.
+func hostTimeLeft(q PublicQuestionView, lead time.Duration) *time.Duration {
+	if q.CountUp || q.Reading || q.Type == "end" || q.Type == "waiting" {
+		return nil
+	}
+	left := max(q.TimeLeft-lead, 0)
+	return &left
+}
+
+// HostClock is the operator-only host_clock event, sent with every state
+// change.
+type HostClock struct {
+	HostTimeLeft *time.Duration `json:"host_time_left"`
+	HostLead     time.Duration  `json:"host_lead"`
+}
+
+// announceHostClock sends the host clock of the derived payload pub of q.
+// Call with questionMutex held.
+func announceHostClock(q Question, pub PublicQuestionView) {
+	lead := questionHostLead(q)
+	hub.broadcastOperator(Event{Type: "host_clock", Revision: revision, Data: HostClock{HostTimeLeft: hostTimeLeft(pub, lead), HostLead: lead}})
+}
+
+// handleHostLeadCommand runs "hostlead [seconds]".
+func handleHostLeadCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 0 {
+		info.Printf("Host lead: %s\n", showHostLead())
+		return nil
+	}
+	if len(args) != 1 {
+		return errors.New("Usage: hostlead [seconds]")
+	}
+	d, err := parseDurationArg(args[0])
+	if err != nil {
+		return err
+	}
+	setHostLead(d, origin)
+	success.Printf("Host countdown now runs %s ahead\n", d)
+	return nil
+}
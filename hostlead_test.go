@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHostLead runs a countdown with the host's clock ahead of the public
+// one and checks that only operators see it, through pauses, adjustments
+// and a question's own lead.
+func TestHostLead(t *testing.T) {
+	s := StartTestServer(t)
+	defer setHostLead(0, "test")
+	if err := cliCommand(t, "hostlead 5"); err != nil {
+		t.Fatal(err)
+	}
+	if e := lastAudit(t, "host_lead"); e.Details["seconds"] != 5.0 {
+		t.Errorf("audited as %+v", e)
+	}
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Hlavné mesto?", "type": "pomoc", "time_left": 30_000_000_000})
+	AdvanceClock(t, 10*time.Second)
+
+	var full OperatorQuestionView
+	host := func() time.Duration {
+		t.Helper()
+		full = OperatorQuestionView{}
+		s.Do(t, http.MethodGet, "/get-question/full", nil, &full)
+		if full.HostTimeLeft == nil {
+			t.Fatal("no host clock")
+		}
+		return *full.HostTimeLeft
+	}
+	if left := host(); left != 15*time.Second || full.HostLead != 5*time.Second || full.TimeLeft != 20*time.Second {
+		t.Errorf("host %s with lead %s, public %s", left, full.HostLead, full.TimeLeft)
+	}
+	var public map[string]interface{}
+	s.Do(t, http.MethodGet, "/get-question", nil, &public)
+	for field := range public {
+		if strings.HasPrefix(field, "host_") {
+			t.Errorf("the public payload has %s", field)
+		}
+	}
+
+	// The host clock freezes with the public one and follows adjustments.
+	s.MustDo(t, http.MethodPost, "/pause", map[string]string{"reason": "tech"})
+	AdvanceClock(t, time.Minute)
+	if left := host(); left != 15*time.Second {
+		t.Errorf("paused host clock %s", left)
+	}
+	s.MustDo(t, http.MethodPost, "/resume", nil)
+	s.MustDo(t, http.MethodPost, "/time/adjust", map[string]interface{}{"delta_seconds": -10})
+	if left := host(); left != 5*time.Second {
+		t.Errorf("host clock after -10s: %s", left)
+	}
+	// It stops at zero while the audience still has time.
+	AdvanceClock(t, 7*time.Second)
+	if left := host(); left != 0 || full.TimeLeft != 3*time.Second {
+		t.Errorf("host %s, public %s", left, full.TimeLeft)
+	}
+
+	// Operators hear the host clock on every change, viewers don't.
+	viewer, err := hub.join("sse", false, parseEventTypes("host_clock"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.unsubscribe(viewer)
+	operator, err := hub.join("sse", true, parseEventTypes("host_clock"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.unsubscribe(operator)
+
+	// A question's own lead wins over the show's.
+	s.MustDo(t, http.MethodPost, "/v2/set-question", map[string]interface{}{"question": "Koľko je 2+2?", "type": "pomoc", "time_left": 30, "host_lead": "8s"})
+	if left := host(); left != 22*time.Second || full.HostLead != 8*time.Second {
+		t.Errorf("host %s with lead %s", left, full.HostLead)
+	}
+	select {
+	case ev := <-operator.ch:
+		if c, ok := ev.Data.(HostClock); !ok || c.HostLead != 8*time.Second || c.HostTimeLeft == nil || *c.HostTimeLeft != 22*time.Second {
+			t.Errorf("host_clock %+v", ev.Data)
+		}
+	default:
+		t.Error("no host_clock for the operator")
+	}
+	if got := drain(viewer); got != nil {
+		t.Errorf("a viewer got %v", got)
+	}
+	if status := s.Do(t, http.MethodPost, "/v2/set-question", map[string]interface{}{"question": "Záporné?", "type": "pomoc", "time_left": 30, "host_lead": -1}, nil); status != http.StatusBadRequest {
+		t.Errorf("a negative lead: status %d", status)
+	}
+
+	// Count-ups have no host clock.
+	if err := cliCommand(t, "time countUp"); err != nil {
+		t.Fatal(err)
+	}
+	full = OperatorQuestionView{}
+	s.Do(t, http.MethodGet, "/get-question/full", nil, &full)
+	if full.HostTimeLeft != nil {
+		t.Errorf("a count-up with a host clock %s", *full.HostTimeLeft)
+	}
+}
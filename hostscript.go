@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/fatih/color"
+)
+
+// HostScriptEvent is the host_script event: the cue card lines of the
+// question that just went live.
+type HostScriptEvent struct {
+	Question string   `json:"question"`
+	Lines    []string `json:"lines"`
+}
+
+// renderHostScript substitutes variables into each script line.
+func renderHostScript(lines []string) ([]string, error) {
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		rendered, err := renderQuestionText(line)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = rendered
+	}
+	return out, nil
+}
+
+// deliverHostScript sends the script of a question going live to operator
+// streams only; the audience never sees it.
+func deliverHostScript(q Question) {
+	if len(q.HostScript) == 0 {
+		return
+	}
+	hub.broadcastOperator(Event{Type: "host_script", Data: HostScriptEvent{Question: q.Question, Lines: q.HostScript}})
+}
+
+// printHostScript is the "script" command.
+func printHostScript() {
+	info := color.New(color.FgYellow)
+
+	questionMutex.RLock()
+	lines := append([]string{}, question.HostScript...)
+	questionMutex.RUnlock()
+	if len(lines) == 0 {
+		info.Println("No host script for this question")
+		return
+	}
+	for _, line := range lines {
+		info.Printf("  > %s\n", line)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/fatih/color"
+)
+
+// hotkeyBindings maps single keys to the command line they run. They go
+// through runCommandLine like typed commands, so they are audited the same.
+var hotkeyBindings = map[rune]string{
+	' ': "time pause",
+	'n': "next",
+	'r': "reveal",
+	'+': "adjust +5",
+	'=': "adjust +5",
+	'-': "adjust -5",
+	'b': "buzz reset",
+	'.': "page",
+}
+
+const hotkeyStatusInterval = 200 * time.Millisecond
+
+// hotkeysOn is guarded by cliOutputMutex, like the prompt it replaces.
+var (
+	hotkeysOn   bool
+	hotkeysStop chan struct{}
+)
+
+func hotkeysActive() bool {
+	cliOutputMutex.Lock()
+	defer cliOutputMutex.Unlock()
+	return hotkeysOn
+}
+
+// setHotkeys switches hotkey mode. While it is on the prompt becomes a
+// status bar with the live countdown.
+func setHotkeys(on bool) error {
+	cliOutputMutex.Lock()
+	defer cliOutputMutex.Unlock()
+	if activeReadline == nil {
+		return fmt.Errorf("hotkeys need an interactive terminal")
+	}
+	if on == hotkeysOn {
+		return nil
+	}
+	hotkeysOn = on
+	if on {
+		hotkeysStop = make(chan struct{})
+		go renderHotkeyStatus(hotkeysStop)
+		return nil
+	}
+	close(hotkeysStop)
+	if promptAlarmOn {
+		activeReadline.SetPrompt(promptAlarm)
+	} else {
+		activeReadline.SetPrompt(promptNormal)
+	}
+	activeReadline.Refresh()
+	return nil
+}
+
+func renderHotkeyStatus(stop chan struct{}) {
+	ticker := time.NewTicker(hotkeyStatusInterval)
+	defer ticker.Stop()
+	for {
+		questionMutex.RLock()
+		q := publicQuestion(question)
+		questionMutex.RUnlock()
+		status := fmt.Sprintf("[hot] %s %s %s | space pause, n next, r reveal, +/- 5s, b buzz, q/Esc exit ", q.TimeDisplay.Text, q.Type, q.Phase)
+		prompt := "\033[36;1m" + status + "\033[0m"
+		if q.Phase == "overtime" || q.Phase == "ended" {
+			prompt = "\033[31;1m" + status + "\033[0m"
+		}
+
+		cliOutputMutex.Lock()
+		select {
+		case <-stop:
+			cliOutputMutex.Unlock()
+			return
+		default:
+		}
+		if activeReadline != nil {
+			activeReadline.SetPrompt(prompt)
+			activeReadline.Refresh()
+		}
+		cliOutputMutex.Unlock()
+		<-ticker.C
+	}
+}
+
+// hotkeyFilter is readline's input filter. Outside hotkey mode it passes
+// every key through; inside it runs the bound command and swallows the key.
+// A lone Esc reaches the filter only when pressed twice, hence q as well.
+func hotkeyFilter(r rune) (rune, bool) {
+	if !hotkeysActive() {
+		return r, true
+	}
+	switch {
+	case r == readline.CharInterrupt:
+		return r, true
+	case r == readline.CharEsc || r == 'q' || r < 0:
+		setHotkeys(false)
+		asyncPrintf(color.New(color.FgYellow), "Hotkeys off\n")
+		return r, false
+	}
+	if line, ok := hotkeyBindings[r]; ok {
+		runCommandLine(line, "hotkey")
+	}
+	return r, false
+}
+
+func handleHotkeysCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return errors.New("Usage: hotkeys <on|off>")
+	}
+	if err := setHotkeys(args[0] == "on"); err != nil {
+		return err
+	}
+	if args[0] == "on" {
+		success.Println("Hotkeys on: keys act immediately, q or Esc leaves")
+	}
+	return nil
+}
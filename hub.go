@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	subscriberBuffer  = 32
+	keepaliveInterval = 15 * time.Second
+	// streamWarnShare of -max-streams raises a notification.
+	streamWarnShare = 0.8
+)
+
+var maxStreams = flag.Int("max-streams", 0, "most concurrent SSE, WebSocket and kiosk streams (0 means no limit)")
+
+var errStreamsFull = errors.New("too many open streams")
+
+// cappedTransports count against -max-streams. The file exporter is part of
+// the server and never does.
+var cappedTransports = map[string]bool{"sse": true, "ws": true, "kiosk": true}
+
+// Event is a message pushed to stream subscribers.
+type Event struct {
+	Type     string      `json:"type"`
+	Revision uint64      `json:"revision,omitempty"`
+	Time     time.Time   `json:"time"`
+	Data     interface{} `json:"data"`
+}
+
+type subscriber struct {
+	ch            chan Event
+	transport     string
+	authenticated bool
+	// types are the event types the subscriber wants; nil means all.
+	types map[string]bool
+	// active is when the client was last heard from, for eviction.
+	active time.Time
+	// evicted is closed when the hub drops the subscriber to make room.
+	evicted chan struct{}
+}
+
+func (s *subscriber) wants(typ string) bool {
+	return s.types == nil || s.types[typ]
+}
+
+// latestEvent is the last event of one type, kept for catch-up.
+type latestEvent struct {
+	ev           Event
+	operatorOnly bool
+}
+
+// eventHub fans events out to every connected stream. Slow subscribers lose
+// events instead of blocking the sender.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+	// latest holds the most recent event of each type.
+	latest map[string]latestEvent
+
+	evictions  uint64
+	rejections uint64
+	// warned is set while the streams are above streamWarnShare of the cap.
+	warned bool
+
+	// closing is closed on shutdown; every stream then says goodbye with
+	// closeReason and returns.
+	closing     chan struct{}
+	closeOnce   sync.Once
+	closeReason string
+}
+
+var hub = &eventHub{subs: map[*subscriber]struct{}{}, latest: map[string]latestEvent{}, closing: make(chan struct{})}
+
+// subscribe adds a subscriber for every event type, outside the stream cap.
+func (h *eventHub) subscribe(transport string, authenticated bool) *subscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.add(transport, authenticated, nil)
+}
+
+// join adds a client stream for the given event types; with catchUp the
+// latest event of each is queued first. When -max-streams is reached an
+// operator makes room by evicting the longest-idle unauthenticated stream,
+// and anyone else gets errStreamsFull. Kiosks receive operator events but
+// are team devices: they are never evicted and don't evict others.
+func (h *eventHub) join(transport string, authenticated bool, types map[string]bool, catchUp bool) (*subscriber, error) {
+	h.mu.Lock()
+	if *maxStreams > 0 && h.streams() >= *maxStreams {
+		victim := h.idlest()
+		if !authenticated || transport == "kiosk" || victim == nil {
+			h.rejections++
+			h.mu.Unlock()
+			return nil, errStreamsFull
+		}
+		delete(h.subs, victim)
+		close(victim.evicted)
+		h.evictions++
+	}
+	s := h.add(transport, authenticated, types)
+	if catchUp {
+		h.catchUp(s)
+	}
+	n := h.streams()
+	warn := *maxStreams > 0 && !h.warned && float64(n) >= streamWarnShare*float64(*maxStreams)
+	if warn {
+		h.warned = true
+	}
+	h.mu.Unlock()
+	if warn {
+		notify(SeverityWarning, "streams", "%d of %d streams open, new viewers will be turned away at the limit", n, *maxStreams)
+	}
+	return s, nil
+}
+
+// add registers a subscriber. Callers hold h.mu.
+func (h *eventHub) add(transport string, authenticated bool, types map[string]bool) *subscriber {
+	s := &subscriber{
+		ch:            make(chan Event, subscriberBuffer),
+		transport:     transport,
+		authenticated: authenticated,
+		types:         types,
+		active:        clock.Now(),
+		evicted:       make(chan struct{}),
+	}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+// streams counts the subscribers under the cap. Callers hold h.mu.
+func (h *eventHub) streams() int {
+	n := 0
+	for s := range h.subs {
+		if cappedTransports[s.transport] {
+			n++
+		}
+	}
+	return n
+}
+
+// idlest is the unauthenticated stream heard from least recently, or nil.
+// Callers hold h.mu.
+func (h *eventHub) idlest() *subscriber {
+	var victim *subscriber
+	for s := range h.subs {
+		if !cappedTransports[s.transport] || s.authenticated {
+			continue
+		}
+		if victim == nil || s.active.Before(victim.active) {
+			victim = s
+		}
+	}
+	return victim
+}
+
+// touch notes that the client of s sent something.
+func (h *eventHub) touch(s *subscriber) {
+	h.mu.Lock()
+	s.active = clock.Now()
+	h.mu.Unlock()
+}
+
+// setFilter changes the types s receives, optionally catching up on the
+// newly chosen ones.
+func (h *eventHub) setFilter(s *subscriber, types map[string]bool, catchUp bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s.types = types
+	if catchUp {
+		h.catchUp(s)
+	}
+}
+
+// catchUp queues the latest event of each type s wants, oldest first.
+// Callers hold h.mu.
+func (h *eventHub) catchUp(s *subscriber) {
+	var evs []Event
+	for typ, l := range h.latest {
+		if !s.wants(typ) || (l.operatorOnly && !s.authenticated) {
+			continue
+		}
+		evs = append(evs, l.ev)
+	}
+	sort.Slice(evs, func(i, j int) bool { return evs[i].Time.Before(evs[j].Time) })
+	for _, ev := range evs {
+		select {
+		case s.ch <- ev:
+		default:
+		}
+	}
+}
+
+func (h *eventHub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	if h.warned && float64(h.streams()) < streamWarnShare*float64(*maxStreams) {
+		h.warned = false
+	}
+	h.mu.Unlock()
+}
+
+func (h *eventHub) broadcast(ev Event) {
+	h.send(ev, false)
+}
+
+// broadcastOperator sends an event only to authenticated subscribers.
+func (h *eventHub) broadcastOperator(ev Event) {
+	h.send(ev, true)
+}
+
+func (h *eventHub) send(ev Event, operatorOnly bool) {
+	if ev.Time.IsZero() {
+		ev.Time = clock.Now()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latest[ev.Type] = latestEvent{ev: ev, operatorOnly: operatorOnly}
+	for s := range h.subs {
+		if (operatorOnly && !s.authenticated) || !s.wants(ev.Type) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+		}
+	}
+}
+
+// closeStreams tells every stream to close with reason and waits for them
+// to unsubscribe. It returns how many there were.
+func (h *eventHub) closeStreams(reason string) int {
+	h.mu.Lock()
+	n := len(h.subs)
+	h.mu.Unlock()
+	h.closeOnce.Do(func() {
+		h.closeReason = reason
+		close(h.closing)
+	})
+	for {
+		h.mu.Lock()
+		left := len(h.subs)
+		h.mu.Unlock()
+		if left == 0 {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// counts returns the number of subscribers per transport.
+func (h *eventHub) counts() map[string]int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := map[string]int{}
+	for s := range h.subs {
+		out[s.transport]++
+	}
+	return out
+}
+
+// StreamStats is the stream cap and how close the server is to it.
+type StreamStats struct {
+	Open     int    `json:"open"`
+	Cap      int    `json:"cap"`
+	Evicted  uint64 `json:"evicted"`
+	Rejected uint64 `json:"rejected"`
+}
+
+func (h *eventHub) streamStats() StreamStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return StreamStats{Open: h.streams(), Cap: *maxStreams, Evicted: h.evictions, Rejected: h.rejections}
+}
+
+func streamSummary(st StreamStats) string {
+	limit := "no limit"
+	if st.Cap > 0 {
+		limit = fmt.Sprintf("limit %d", st.Cap)
+	}
+	return fmt.Sprintf("Streams: %d open (%s, %d evicted, %d turned away)", st.Open, limit, st.Evicted, st.Rejected)
+}
+
+// streamsFull is the 503 for a client over the cap. Displays can still
+// poll the question instead.
+func streamsFull() *APIError {
+	return apiError(http.StatusServiceUnavailable, "too_many_streams", "the server has no room for more streams, poll /get-question instead").withDetail("poll", "/get-question")
+}
+
+// parseEventTypes reads a comma-separated list of event types. An empty
+// list or "*" means every type.
+func parseEventTypes(list string) map[string]bool {
+	var types map[string]bool
+	for _, typ := range strings.Split(list, ",") {
+		typ = strings.TrimSpace(typ)
+		if typ == "*" {
+			return nil
+		}
+		if typ == "" {
+			continue
+		}
+		if types == nil {
+			types = map[string]bool{}
+		}
+		types[typ] = true
+	}
+	return types
+}
+
+// getEvents streams hub events as server-sent events. ?types=a,b limits
+// the stream to those event types and ?catch_up=true starts it with the
+// latest event of each. ?since=<revision> leaves out state the client
+// already has, as after GET /bootstrap.
+func getEvents(c echo.Context) error {
+	types := parseEventTypes(c.QueryParam("types"))
+	var since uint64
+	if s := c.QueryParam("since"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return badRequest("since must be a revision number")
+		}
+		since = n
+	}
+	catchUp := c.QueryParam("catch_up") == "true"
+	s, err := hub.join("sse", isAuthenticated(c), types, catchUp)
+	if err != nil {
+		return streamsFull()
+	}
+	defer hub.unsubscribe(s)
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	// Without a flush the client sees nothing until the first event.
+	w.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	ctx := c.Request().Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hub.closing:
+			sendShutdownEvent(w)
+			return nil
+		case <-s.evicted:
+			sendEvictedEvent(w)
+			return nil
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+			w.Flush()
+		case ev := <-s.ch:
+			if ev.Revision != 0 && ev.Revision <= since {
+				continue
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return nil
+			}
+			w.Flush()
+		}
+	}
+}
+
+// sendShutdownEvent is the last message of an SSE stream before the server
+// exits.
+func sendShutdownEvent(w *echo.Response) {
+	data, _ := json.Marshal(map[string]string{"reason": hub.closeReason})
+	fmt.Fprintf(w, "event: shutdown\ndata: %s\n\n", data)
+	w.Flush()
+}
+
+// sendEvictedEvent ends an SSE stream dropped to make room for an operator.
+func sendEvictedEvent(w *echo.Response) {
+	data, _ := json.Marshal(map[string]string{"reason": "server at capacity", "poll": "/get-question"})
+	fmt.Fprintf(w, "event: evicted\ndata: %s\n\n", data)
+	w.Flush()
+}
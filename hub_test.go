@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// drain returns the types of the events queued for s.
+func drain(s *subscriber) []string {
+	var types []string
+	for {
+		select {
+		case ev := <-s.ch:
+			types = append(types, ev.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func TestParseEventTypes(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want map[string]bool
+	}{
+		{"", nil},
+		{"*", nil},
+		{"tick, pause,", map[string]bool{"tick": true, "pause": true}},
+		{"tick,*", nil},
+		{" , ", nil},
+	} {
+		if got := parseEventTypes(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+// TestStreamFilters checks that subscribers get only the types they asked
+// for, and that catching up replays the latest of each, oldest first,
+// without operator events for viewers.
+func TestStreamFilters(t *testing.T) {
+	StartTestServer(t)
+	hub.broadcast(Event{Type: "test_b"})
+	AdvanceClock(t, time.Second)
+	hub.broadcast(Event{Type: "test_a", Data: 1})
+	AdvanceClock(t, time.Second)
+	hub.broadcast(Event{Type: "test_a", Data: 2})
+	AdvanceClock(t, time.Second)
+	hub.broadcastOperator(Event{Type: "test_op"})
+
+	viewer, err := hub.join("sse", false, parseEventTypes("test_a,test_b,test_op"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.unsubscribe(viewer)
+	operator, err := hub.join("sse", true, parseEventTypes("test_a,test_op"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.unsubscribe(operator)
+	if got := drain(viewer); !reflect.DeepEqual(got, []string{"test_b", "test_a"}) {
+		t.Errorf("viewer caught up on %v", got)
+	}
+	if got := drain(operator); !reflect.DeepEqual(got, []string{"test_a", "test_op"}) {
+		t.Errorf("operator caught up on %v", got)
+	}
+
+	hub.broadcast(Event{Type: "test_b"})
+	hub.broadcast(Event{Type: "test_c"})
+	hub.broadcastOperator(Event{Type: "test_op"})
+	if got := drain(viewer); !reflect.DeepEqual(got, []string{"test_b"}) {
+		t.Errorf("viewer got %v", got)
+	}
+	if got := drain(operator); !reflect.DeepEqual(got, []string{"test_op"}) {
+		t.Errorf("operator got %v", got)
+	}
+
+	// Changing the filter without catching up replays nothing.
+	hub.setFilter(viewer, parseEventTypes("test_c"), false)
+	hub.broadcast(Event{Type: "test_b"})
+	if got := drain(viewer); got != nil {
+		t.Errorf("viewer got %v after the change", got)
+	}
+	hub.setFilter(viewer, nil, true)
+	if got := drain(viewer); len(got) < 3 {
+		t.Errorf("catching up on everything gave %v", got)
+	}
+}
+
+func TestWSSubscribe(t *testing.T) {
+	s := StartTestServer(t)
+	hub.broadcast(Event{Type: "test_a", Data: "latest"})
+
+	ws := dialWS(t, s, "")
+	if err := websocket.JSON.Send(ws, WSCommand{ID: "1", Cmd: "subscribe", Value: "test_a", CatchUp: true}); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(waitTimeout))
+	var subscribed, caughtUp bool
+	for !subscribed || !caughtUp {
+		var msg struct {
+			Type string      `json:"type"`
+			ID   string      `json:"id"`
+			OK   bool        `json:"ok"`
+			Data interface{} `json:"data"`
+		}
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Fatalf("subscribed %v, caught up %v: %v", subscribed, caughtUp, err)
+		}
+		switch msg.Type {
+		case "response":
+			subscribed = msg.ID == "1" && msg.OK
+		case "test_a":
+			caughtUp = msg.Data == "latest"
+		default:
+			t.Errorf("an unwanted %s event", msg.Type)
+		}
+	}
+}
+
+// TestEventsStreamOpens checks that /events answers before there is
+// anything to send, then delivers events as they come.
+func TestEventsStreamOpens(t *testing.T) {
+	s := StartTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/events?types=test_open", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	hub.broadcast(Event{Type: "test_open"})
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "event: test_open\n" {
+		t.Errorf("read %q, %v", line, err)
+	}
+}
+
+// openStreams waits for the streams of earlier tests to close.
+func openStreams(t *testing.T) {
+	t.Helper()
+	eventually(t, "earlier streams closed", func() bool { return hub.streamStats().Open == 0 })
+}
+
+// TestStreamCap fills the streams to -max-streams and checks who is turned
+// away and who makes room.
+func TestStreamCap(t *testing.T) {
+	s := StartTestServer(t)
+	openStreams(t)
+	defer func(n int) { *maxStreams = n }(*maxStreams)
+	*maxStreams = 3
+	before := hub.streamStats()
+	notified := len(listNotifications())
+
+	var viewers []*subscriber
+	for i := 0; i < 3; i++ {
+		v, err := hub.join("sse", false, nil, false)
+		if err != nil {
+			t.Fatalf("viewer %d: %v", i, err)
+		}
+		defer hub.unsubscribe(v)
+		viewers = append(viewers, v)
+		AdvanceClock(t, time.Second)
+	}
+	// The file exporter doesn't count.
+	exporter := hub.subscribe("file", false)
+	defer hub.unsubscribe(exporter)
+	if n := listNotifications(); len(n) != notified+1 || n[len(n)-1].Category != "streams" || n[len(n)-1].Severity != SeverityWarning {
+		t.Errorf("notifications %+v", n[notified:])
+	}
+
+	if _, err := hub.join("ws", false, nil, false); err != errStreamsFull {
+		t.Errorf("a fourth viewer: %v", err)
+	}
+	if _, err := hub.join("kiosk", true, nil, false); err != errStreamsFull {
+		t.Errorf("a kiosk over the cap: %v", err)
+	}
+	var full struct {
+		Error APIError `json:"error"`
+	}
+	req := s.NewRequest(t, http.MethodGet, "/v2/events", nil)
+	req.Header.Del("X-API-Key")
+	if resp := s.Send(t, req, &full); resp.StatusCode != http.StatusServiceUnavailable || full.Error.Code != "too_many_streams" {
+		t.Errorf("/events over the cap: status %d, %+v", resp.StatusCode, full)
+	}
+
+	// An operator evicts the viewer heard from least recently.
+	hub.touch(viewers[0])
+	operator, err := hub.join("ws", true, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.unsubscribe(operator)
+	select {
+	case <-viewers[1].evicted:
+	default:
+		t.Error("the idlest viewer was not evicted")
+	}
+	for _, v := range []*subscriber{viewers[0], viewers[2]} {
+		select {
+		case <-v.evicted:
+			t.Error("a more recent viewer was evicted")
+		default:
+		}
+	}
+	hub.unsubscribe(viewers[1])
+
+	// With only operators and kiosks left nobody can be evicted.
+	hub.unsubscribe(viewers[0])
+	hub.unsubscribe(viewers[2])
+	for i := 0; i < 2; i++ {
+		k, err := hub.join("kiosk", true, nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer hub.unsubscribe(k)
+	}
+	if _, err := hub.join("sse", true, nil, false); err != errStreamsFull {
+		t.Errorf("an operator with no one to evict: %v", err)
+	}
+
+	st := hub.streamStats()
+	if st.Open != 3 || st.Cap != 3 || st.Evicted != before.Evicted+1 || st.Rejected != before.Rejected+4 {
+		t.Errorf("stats %+v, before %+v", st, before)
+	}
+	resp, err := http.DefaultClient.Do(s.NewRequest(t, http.MethodGet, "/metrics", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := string(body)
+	for _, want := range []string{"quiz_streams_open 3\n", "quiz_streams_cap 3\n", `quiz_subscribers{transport="kiosk"} 2`} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics lack %q:\n%s", want, metrics)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCountdownExpiryPushesEnd(t *testing.T) {
+	s := StartTestServer(t)
+	s.MustDo(t, http.MethodPost, "/v2/set-question", map[string]interface{}{
+		"question": "Capital of France?", "type": "pomoc", "time_left": "30s",
+	})
+	s.Flask.WaitFor(t, "the question pushed", func(p map[string]interface{}) bool {
+		return p["question"] == "Capital of France?" && p["type"] == "pomoc"
+	})
+
+	for elapsed := 5; elapsed < 30; elapsed += 5 {
+		AdvanceClock(t, 5*time.Second)
+		q := s.MustDo(t, http.MethodGet, "/v2/get-question", nil)
+		if q["type"] != "pomoc" || q["time_left"] != float64(30-elapsed) {
+			t.Fatalf("after %ds: type %v, time_left %v", elapsed, q["type"], q["time_left"])
+		}
+	}
+	AdvanceClock(t, 5*time.Second)
+	eventually(t, "the countdown to end", func() bool {
+		return s.MustDo(t, http.MethodGet, "/v2/get-question", nil)["type"] == "end"
+	})
+	s.Flask.WaitFor(t, `"end" pushed`, func(p map[string]interface{}) bool {
+		return p["question"] == "Capital of France?" && p["type"] == "end"
+	})
+}
+
+func TestPauseResumeTiming(t *testing.T) {
+	s := StartTestServer(t)
+	s.MustDo(t, http.MethodPost, "/v2/set-question", map[string]interface{}{
+		"question": "Longest river?", "type": "pomoc", "time_left": 60,
+	})
+	timeLeft := func() interface{} {
+		return s.MustDo(t, http.MethodGet, "/v2/get-question", nil)["time_left"]
+	}
+
+	AdvanceClock(t, 10*time.Second)
+	q := s.MustDo(t, http.MethodPost, "/v2/pause", nil)
+	if q["paused"] != true || q["time_left"] != float64(50) {
+		t.Fatalf("after pause: paused %v, time_left %v", q["paused"], q["time_left"])
+	}
+	s.Flask.WaitFor(t, "the pause pushed", func(p map[string]interface{}) bool {
+		return p["paused"] == true && p["time_left"] == float64(50*time.Second)
+	})
+
+	AdvanceClock(t, 30*time.Second)
+	if got := timeLeft(); got != float64(50) {
+		t.Fatalf("while paused: time_left %v, want 50", got)
+	}
+	if status := s.Do(t, http.MethodPost, "/v2/pause", nil, nil); status != http.StatusConflict {
+		t.Fatalf("second pause: status %d, want 409", status)
+	}
+
+	s.MustDo(t, http.MethodPost, "/v2/resume", nil)
+	AdvanceClock(t, 5*time.Second)
+	if got := timeLeft(); got != float64(45) {
+		t.Fatalf("after resume: time_left %v, want 45", got)
+	}
+	AdvanceClock(t, 45*time.Second)
+	s.Flask.WaitFor(t, `"end" pushed`, func(p map[string]interface{}) bool {
+		return p["question"] == "Longest river?" && p["type"] == "end"
+	})
+}
+
+func TestQueueNext(t *testing.T) {
+	s := StartTestServer(t)
+	status := s.Do(t, http.MethodPost, "/v2/queue", []map[string]interface{}{
+		{"question": "First", "type": "pomoc", "time_left": "20s"},
+		{"question": "Second", "type": "rozstrel", "time_left": "40s"},
+	}, nil)
+	if status/100 != 2 {
+		t.Fatalf("queueing: status %d", status)
+	}
+
+	for _, want := range []struct {
+		question, kind string
+		timeLeft       float64
+	}{{"First", "pomoc", 20}, {"Second", "rozstrel", 40}} {
+		entry := s.MustDo(t, http.MethodPost, "/v2/queue/next", nil)
+		if entry["question"] != want.question {
+			t.Fatalf("next: got %v, want %s", entry["question"], want.question)
+		}
+		q := s.MustDo(t, http.MethodGet, "/v2/get-question", nil)
+		if q["question"] != want.question || q["type"] != want.kind || q["time_left"] != want.timeLeft {
+			t.Fatalf("live after next: %v %v %v", q["question"], q["type"], q["time_left"])
+		}
+		s.Flask.WaitFor(t, want.question+" pushed", func(p map[string]interface{}) bool {
+			return p["question"] == want.question && p["type"] == want.kind
+		})
+	}
+	if status := s.Do(t, http.MethodPost, "/v2/queue/next", nil, nil); status != http.StatusConflict {
+		t.Fatalf("next on an empty queue: status %d, want 409", status)
+	}
+}
+
+func TestConcurrentSetters(t *testing.T) {
+	s := StartTestServer(t)
+	const setters = 20
+	var wg sync.WaitGroup
+	statuses := make([]int, setters)
+	for i := 0; i < setters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses[i] = s.Do(t, http.MethodPost, "/v2/set-question", map[string]interface{}{
+				"question": fmt.Sprintf("Question %d", i), "type": "pomoc", "time_left": 30,
+			}, nil)
+		}(i)
+	}
+	wg.Wait()
+	for i, status := range statuses {
+		if status != http.StatusOK {
+			t.Fatalf("setter %d: status %d", i, status)
+		}
+	}
+
+	// Every setter went live once, and the last one to do so is what is
+	// live and what Flask ends up with.
+	if n := len(listHistory()); n != setters {
+		t.Fatalf("history has %d entries, want %d", n, setters)
+	}
+	live := s.MustDo(t, http.MethodGet, "/v2/get-question", nil)["question"]
+	eventually(t, "the live question pushed last", func() bool {
+		last := s.Flask.Last()
+		return last != nil && last["question"] == live && pendingPushCount() == 0
+	})
+}
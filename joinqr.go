@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"image/png"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var joinURLTemplate = flag.String("join-url", "", "audience join URL, shown as a QR code at startup, by qr and at /join-qr.png; {{host}} and {{port}} are this server's address, other {{names}} template vars (empty disables)")
+
+// The join QR code gets phones onto the audience page: printed in the
+// terminal at startup and by "qr", and served as a PNG big enough for the
+// projector. The URL is a template so it can follow the server's address
+// and the show's variables.
+
+const (
+	joinQRDefaultSize = 1024
+	joinQRMaxSize     = 4096
+)
+
+// joinHost is the address phones reach this server on: the host of -addr
+// when it names one, else the first non-loopback IPv4 address.
+func joinHost() (string, string) {
+	host, port, err := net.SplitHostPort(*listenAddr)
+	if err != nil {
+		return *listenAddr, ""
+	}
+	if host != "" && host != "0.0.0.0" && host != "::" {
+		return host, port
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			return ipnet.IP.String(), port
+		}
+	}
+	return "localhost", port
+}
+
+// joinURL renders -join-url. It fails when it is not set or names a
+// variable that isn't.
+func joinURL() (string, error) {
+	if *joinURLTemplate == "" {
+		return "", fmt.Errorf("no join URL configured, start the server with -join-url")
+	}
+	src := bareVarPattern.ReplaceAllString(*joinURLTemplate, "{{.$1}}")
+	tmpl, err := template.New("join").Option("missingkey=error").Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("invalid -join-url: %v", err)
+	}
+	data := listTemplateVars()
+	data["host"], data["port"] = joinHost()
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		if m := missingVarPattern.FindStringSubmatch(err.Error()); m != nil {
+			return "", &UnknownVariableError{Name: m[1]}
+		}
+		return "", fmt.Errorf("rendering -join-url: %v", err)
+	}
+	return sb.String(), nil
+}
+
+// printJoinQR prints the join URL and its QR code, or says why it can't.
+func printJoinQR() error {
+	info := color.New(color.FgYellow)
+
+	u, err := joinURL()
+	if err != nil {
+		return err
+	}
+	q, err := encodeQR([]byte(u))
+	if err != nil {
+		return fmt.Errorf("Join URL %s: %v", u, err)
+	}
+	fmt.Print(q.terminal())
+	info.Printf("Join at %s\n", u)
+	return nil
+}
+
+// getJoinQR serves the join QR code as a PNG, ?size= pixels square. The
+// ETag follows the URL and size, so projectors and phones can cache it
+// and revalidate cheaply.
+func getJoinQR(c echo.Context) error {
+	size := joinQRDefaultSize
+	if s := c.QueryParam("size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 64 || n > joinQRMaxSize {
+			return badRequest(fmt.Sprintf("size must be 64 to %d pixels", joinQRMaxSize))
+		}
+		size = n
+	}
+	u, err := joinURL()
+	if err != nil {
+		return notFound(err.Error())
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%d", u, size)))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	h := c.Response().Header()
+	h.Set("ETag", etag)
+	h.Set(echo.HeaderCacheControl, "public, max-age=300")
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+	q, err := encodeQR([]byte(u))
+	if err != nil {
+		return apiError(http.StatusUnprocessableEntity, "join_url_too_long", err.Error())
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, q.image(size)); err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, "image/png", buf.Bytes())
+}
+
+// handleQRCommand runs "qr [url]": the join QR code, or one for url.
+func handleQRCommand(args []string, origin string) error {
+	info := color.New(color.FgYellow)
+
+	switch len(args) {
+	case 0:
+		return printJoinQR()
+	case 1:
+		q, err := encodeQR([]byte(args[0]))
+		if err != nil {
+			return err
+		}
+		fmt.Print(q.terminal())
+		info.Println(args[0])
+	default:
+		return errors.New("Usage: qr [url]")
+	}
+	return nil
+}
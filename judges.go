@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// judgeQuorum is how many judges must agree on an extension.
+	judgeQuorum = 2
+	// judgeWindow is how close together their requests must be.
+	judgeWindow = 10 * time.Second
+)
+
+var (
+	judgeExtension  = flag.Duration("judge-extension", 15*time.Second, "time added when the judges grant an extension")
+	judgeExtensions = flag.Int("judge-extensions", 1, "extensions each judge may grant per game")
+)
+
+// Judge is a configured judge. The token is never shown.
+type Judge struct {
+	Name      string `json:"name"`
+	Remaining int    `json:"remaining"`
+	token     string
+}
+
+// judgeFlag collects repeated -judge name=token flags.
+type judgeFlag []Judge
+
+var judgeFlags judgeFlag
+
+func (f *judgeFlag) String() string {
+	names := make([]string, len(*f))
+	for i, j := range *f {
+		names[i] = j.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *judgeFlag) Set(v string) error {
+	name, token, ok := strings.Cut(v, "=")
+	if !ok || name == "" || token == "" {
+		return fmt.Errorf("expected name=token")
+	}
+	*f = append(*f, Judge{Name: name, token: token})
+	return nil
+}
+
+func init() {
+	flag.Var(&judgeFlags, "judge", "judge as name=token, may be repeated")
+}
+
+var (
+	judgesMutex sync.Mutex
+	judges      []*Judge
+	// extendRequests holds when each judge last asked for an extension that
+	// has not been granted yet.
+	extendRequests = map[string]time.Time{}
+)
+
+func configureJudges() {
+	judgesMutex.Lock()
+	defer judgesMutex.Unlock()
+	judges = nil
+	for _, j := range judgeFlags {
+		j := j
+		j.Remaining = *judgeExtensions
+		judges = append(judges, &j)
+	}
+}
+
+func judgeByToken(token string) *Judge {
+	for _, j := range judges {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(j.token)) == 1 {
+			return j
+		}
+	}
+	return nil
+}
+
+func listJudges() []Judge {
+	judgesMutex.Lock()
+	defer judgesMutex.Unlock()
+	out := make([]Judge, len(judges))
+	for i, j := range judges {
+		out[i] = Judge{Name: j.Name, Remaining: j.Remaining}
+	}
+	return out
+}
+
+// resetJudges gives every judge their extensions back.
+func resetJudges(origin string) {
+	judgesMutex.Lock()
+	for _, j := range judges {
+		j.Remaining = *judgeExtensions
+	}
+	extendRequests = map[string]time.Time{}
+	judgesMutex.Unlock()
+	audit("judges_reset", origin, nil)
+}
+
+// ExtendResult reports a judge's extension request.
+type ExtendResult struct {
+	Granted bool     `json:"granted"`
+	Judges  []string `json:"judges"`
+	Seconds float64  `json:"seconds,omitempty"`
+}
+
+// requestExtension records judge's request. When judgeQuorum judges have
+// asked within judgeWindow of each other, the countdown is extended and
+// each of them spends one extension.
+func requestExtension(judge *Judge) (ExtendResult, error) {
+	questionMutex.RLock()
+	q := question
+	questionMutex.RUnlock()
+	if _, ok := countdownDeadline(q); !ok || !questionInProgress() {
+		return ExtendResult{}, fmt.Errorf("no countdown is running")
+	}
+
+	judgesMutex.Lock()
+	if judge.Remaining <= 0 {
+		judgesMutex.Unlock()
+		return ExtendResult{}, fmt.Errorf("%s has no extensions left", judge.Name)
+	}
+	now := clock.Now()
+	extendRequests[judge.Name] = now
+	var agreeing []string
+	for name, at := range extendRequests {
+		if now.Sub(at) > judgeWindow {
+			delete(extendRequests, name)
+			continue
+		}
+		agreeing = append(agreeing, name)
+	}
+	sort.Strings(agreeing)
+	if len(agreeing) < judgeQuorum {
+		judgesMutex.Unlock()
+		audit("judge_extend", judge.Name, map[string]interface{}{"granted": false})
+		return ExtendResult{Judges: agreeing}, nil
+	}
+	for _, j := range judges {
+		if _, ok := extendRequests[j.Name]; ok {
+			j.Remaining--
+		}
+	}
+	extendRequests = map[string]time.Time{}
+	judgesMutex.Unlock()
+
+	if _, err := adjustTimeLeft(*judgeExtension, false, "judges"); err != nil {
+		return ExtendResult{}, err
+	}
+	audit("judge_extend", judge.Name, map[string]interface{}{"granted": true, "judges": agreeing, "seconds": judgeExtension.Seconds()})
+	hub.broadcast(Event{Type: "extension", Data: map[string]interface{}{"judges": agreeing, "seconds": judgeExtension.Seconds()}})
+	return ExtendResult{Granted: true, Judges: agreeing, Seconds: judgeExtension.Seconds()}, nil
+}
+
+// postJudgeExtend takes the judge's token as a bearer token or in
+// X-Judge-Token.
+func postJudgeExtend(c echo.Context) error {
+	token := c.Request().Header.Get("X-Judge-Token")
+	if token == "" {
+		token = strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	}
+	judgesMutex.Lock()
+	judge := judgeByToken(token)
+	judgesMutex.Unlock()
+	if token == "" || judge == nil {
+		return apiError(http.StatusUnauthorized, "unauthorized", "invalid or missing judge token")
+	}
+	res, err := requestExtension(judge)
+	if err != nil {
+		return conflict(err.Error())
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func getJudges(c echo.Context) error {
+	return c.JSON(http.StatusOK, listJudges())
+}
+
+func handleJudgesCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 1 && args[0] == "reset" {
+		resetJudges(origin)
+		success.Println("Judge extensions reset")
+		return nil
+	}
+	list := listJudges()
+	if len(list) == 0 {
+		info.Println("No judges configured (use -judge name=token)")
+		return nil
+	}
+	for _, j := range list {
+		info.Printf("  %-12s %d extension(s) left\n", j.Name, j.Remaining)
+	}
+	return nil
+}
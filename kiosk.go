@@ -0,0 +1,166 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed kiosk/index.html
+var kioskPage []byte
+
+// KioskTeam is what a podium screen may know about its own team.
+type KioskTeam struct {
+	Name       string `json:"name"`
+	ShortName  string `json:"short_name"`
+	Color      string `json:"color"`
+	Score      int    `json:"score"`
+	Eliminated bool   `json:"eliminated"`
+	Answered   bool   `json:"answered"`
+	BuzzWinner bool   `json:"buzz_winner"`
+	Locked     bool   `json:"locked"`
+}
+
+// KioskView is the whole payload of a podium screen: the public question
+// fields it shows and its own team. Nothing about other teams is included.
+type KioskView struct {
+	Question     string       `json:"question"`
+	Type         string       `json:"type"`
+	Phase        string       `json:"phase"`
+	TimeDisplay  *TimeDisplay `json:"time_display"`
+	Paused       bool         `json:"paused"`
+	PauseMessage string       `json:"pause_message,omitempty"`
+	Team         KioskTeam    `json:"team"`
+}
+
+// kioskView builds the payload for team, or false when it doesn't exist.
+func kioskView(team, lang string) (KioskView, bool) {
+	t, ok := findTeam(team)
+	if !ok {
+		return KioskView{}, false
+	}
+	questionMutex.RLock()
+	q := localizedQuestion(publicQuestion(question), lang)
+	questionMutex.RUnlock()
+
+	return KioskView{
+		Question:     q.Question,
+		Type:         q.Type,
+		Phase:        q.Phase,
+		TimeDisplay:  q.TimeDisplay,
+		Paused:       q.Paused,
+		PauseMessage: q.PauseMessage,
+		Team:         kioskTeam(t),
+	}, true
+}
+
+// kioskTeam is t with its part in the live question.
+func kioskTeam(t Team) KioskTeam {
+	roundMutex.Lock()
+	a, answered := answers[t.Name]
+	out := isEliminated(t.Name)
+	winner := buzzWinner == t.Name
+	roundMutex.Unlock()
+
+	return KioskTeam{
+		Name:       t.Name,
+		ShortName:  t.ShortName,
+		Color:      t.Color,
+		Score:      t.Score,
+		Eliminated: out,
+		Answered:   answered,
+		Locked:     a.LockedAt != nil,
+		BuzzWinner: winner,
+	}
+}
+
+func getKiosk(c echo.Context) error {
+	if _, ok := findTeam(c.Param("team")); !ok {
+		return notFound("unknown team: " + c.Param("team"))
+	}
+	return c.Blob(http.StatusOK, echo.MIMETextHTMLCharsetUTF8, kioskPage)
+}
+
+// getKioskEvents streams one team's kiosk view as server-sent events. The
+// filtering happens here: hub events are only used as a cue to rebuild the
+// view and never forwarded. Changes to the team's own state are announced
+// as eliminated and answer_received events for the page's overlays.
+func getKioskEvents(c echo.Context) error {
+	team, lang := c.Param("team"), c.QueryParam("lang")
+	view, ok := kioskView(team, lang)
+	if !ok {
+		return notFound("unknown team: " + team)
+	}
+
+	// Operator events include answers, which the view needs to notice; they
+	// are only used to rebuild it.
+	s, err := hub.join("kiosk", true, nil, false)
+	if err != nil {
+		return streamsFull()
+	}
+	defer hub.unsubscribe(s)
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// The stream is the team's presence, see presence.go.
+	var seen *PresenceSpan
+	send := func(kind string, data interface{}) bool {
+		body, err := json.Marshal(data)
+		if err != nil {
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", kind, body); err != nil {
+			return false
+		}
+		w.Flush()
+		if seen != nil {
+			presenceSeen(seen)
+		}
+		return true
+	}
+	if !send("state", view) {
+		return nil
+	}
+	seen = presenceOpen(view.Team.Name)
+	defer presenceClose(seen)
+
+	// Every countdown tick resends the state, even when nothing happened.
+	// It keeps the countdown moving and doubles as the heartbeat the page
+	// watches for.
+	refresh := ticks.subscribe()
+	defer ticks.unsubscribe(refresh)
+	ctx := c.Request().Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hub.closing:
+			sendShutdownEvent(w)
+			return nil
+		case <-s.ch:
+		case <-refresh:
+		}
+		next, ok := kioskView(team, lang)
+		if !ok {
+			send("removed", map[string]string{"team": team})
+			return nil
+		}
+		if next.Team.Eliminated && !view.Team.Eliminated && !send("eliminated", next.Team) {
+			return nil
+		}
+		if next.Team.Answered && !view.Team.Answered && !send("answer_received", next.Team) {
+			return nil
+		}
+		view = next
+		if !send("state", view) {
+			return nil
+		}
+	}
+}
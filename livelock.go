@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// lockWhileLive makes /set-question and the CLI question command refuse to
+// replace a running question unless told to override.
+var (
+	liveLockMutex sync.Mutex
+	lockWhileLive bool
+)
+
+func liveLockOn() bool {
+	liveLockMutex.Lock()
+	defer liveLockMutex.Unlock()
+	return lockWhileLive
+}
+
+func setLiveLock(on bool, origin string) {
+	liveLockMutex.Lock()
+	lockWhileLive = on
+	liveLockMutex.Unlock()
+	audit("live_lock", origin, map[string]interface{}{"on": on})
+}
+
+// questionRunning reports whether q is mid-question: not waiting, not ended
+// and not paused with the countdown already at zero.
+func questionRunning(q Question) bool {
+	v := publicQuestion(q)
+	switch v.Phase {
+	case "waiting", "ended":
+		return false
+	case "paused":
+		return v.CountUp || v.TimeLeft > 0
+	}
+	return true
+}
+
+// checkLiveLock returns a 423 question_locked error when the live question
+// may not be replaced. An override goes through but is audited and announced.
+func checkLiveLock(override bool, origin string) error {
+	if !liveLockOn() {
+		return nil
+	}
+	questionMutex.RLock()
+	q := question
+	questionMutex.RUnlock()
+	if !questionRunning(q) {
+		return nil
+	}
+	if !override {
+		return apiError(http.StatusLocked, "question_locked", "a question is running and lock while live is on, use override to replace it")
+	}
+	audit("lock_override", origin, map[string]interface{}{"replaced": q.Question})
+	notify(SeverityWarning, "lock", "Running question %q replaced with override (%s)", q.Question, origin)
+	return nil
+}
+
+func handleLiveLockCommand(arg, origin string) error {
+	success := color.New(color.FgGreen)
+
+	if arg != "on" && arg != "off" {
+		return errors.New("Usage: lock [on|off]")
+	}
+	setLiveLock(arg == "on", origin)
+	if arg == "on" {
+		success.Println("Lock while live on: running questions need question --override")
+	} else {
+		success.Println("Lock while live off")
+	}
+	return nil
+}
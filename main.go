@@ -1,18 +1,15 @@
 package main
 
 import (
-	"bytes"
-	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/chzyer/readline"
@@ -24,59 +21,281 @@ import (
 const (
 	flaskServerURL = "http://localhost:5000"
 	serverPort     = ":8050"
-	historyFile    = "/tmp/readline.tmp"
 )
 
-// Question represents the question data structure.
+// Question is the internal question state. It is never sent as is: see
+// views.go for the payloads built from it and for how each field is
+// classified.
 type Question struct {
 	Question  string        `json:"question"`
 	TimeLeft  time.Duration `json:"time_left"`
 	Type      string        `json:"type"`
 	StartTime time.Time     `json:"start_time"`
 	CountUp   bool          `json:"count_up"`
+	// AllowOvertime keeps the question running past zero, counting the
+	// overrun, until the operator ends it.
+	AllowOvertime bool `json:"allow_overtime"`
+	// AnswerWindow is how long into the answer time answers count, if
+	// shorter than the countdown; see window.go.
+	AnswerWindow time.Duration `json:"answer_window,omitempty"`
+	// OptionsRevealAt is how long into the answer time the options stay
+	// hidden, if they do; see optionsreveal.go.
+	OptionsRevealAt time.Duration `json:"options_reveal_at,omitempty"`
+	// ReadingTime is how long the question is read out before the answer
+	// countdown starts. While Reading, StartTime is when reading began.
+	ReadingTime time.Duration `json:"reading_time,omitempty"`
+	Reading     bool          `json:"reading,omitempty"`
+
+	Paused       bool   `json:"paused"`
+	PauseReason  string `json:"pause_reason,omitempty"`
+	PauseMessage string `json:"pause_message,omitempty"`
+
+	// Stalled is set when a count-up reached -count-up-ceiling; TimeLeft
+	// then holds the ceiling and the clock no longer runs. See stall.go.
+	Stalled bool `json:"stalled,omitempty"`
+
+	// Duration is the full length the countdown was last set to; see
+	// timer.go for how TimeLeft and StartTime follow from it.
+	Duration time.Duration `json:"duration,omitempty"`
+
+	// AriaLiveText is the latest time-left announcement for screen readers,
+	// changed only at the milestones in announce.go.
+	AriaLiveText string `json:"aria_live_text,omitempty"`
+
+	// Variants are translations of the question text, keyed by locale.
+	// They share the question's timer, type and answers.
+	Variants map[string]string `json:"variants,omitempty"`
+
+	// MediaURL is an image or sound shown with the question. While the
+	// question waits in the queue, displays get it as a preload hint.
+	MediaURL string `json:"media_url,omitempty"`
+	// MediaDisplayURL is the downscaled rendition of an uploaded MediaURL,
+	// and MediaFallbackText what displays show if audio or video fails to
+	// play. See media.go.
+	MediaDisplayURL   string `json:"media_display_url,omitempty"`
+	MediaFallbackText string `json:"media_fallback_text,omitempty"`
+	// Options are the choices of a multiple-choice question, see options.go.
+	Options []AnswerOption `json:"options,omitempty"`
+
+	// Page is the shown page of a question too long for one, from 0. See
+	// paging.go.
+	Page int `json:"page,omitempty"`
+
+	// Meta places the question in the show for overlays, see meta.go.
+	Meta *QuestionMeta `json:"meta,omitempty"`
+
+	// Notes are host notes, shown to the operator only.
+	Notes string `json:"notes,omitempty"`
+	// HostLead overrides the show's host lead for this question, see
+	// hostlead.go.
+	HostLead time.Duration `json:"host_lead,omitempty"`
+	// HostScript are cue card lines for the host, see hostscript.go.
+	HostScript []string `json:"host_script,omitempty"`
+	// Scoring is how correct answers are awarded; nil means static.
+	Scoring *ScoringPolicy `json:"scoring,omitempty"`
+	// Matching holds the accepted answers of a free-text question.
+	Matching *MatchRules `json:"matching,omitempty"`
+
+	// BankID is the bank entry the question was queued from, if any; see
+	// bankstats.go.
+	BankID int `json:"bank_id,omitempty"`
+
+	// Template is the question text before variable substitution.
+	Template string `json:"-"`
+	// ExpiredFrom is the type the question had when its countdown ran out,
+	// so setting a new time brings it back. It is also set, with the type
+	// unchanged, once an overtime question passes zero.
+	ExpiredFrom string `json:"-"`
 }
 
 var (
 	question       = Question{}
 	questionMutex  sync.RWMutex
-	pause          = false
 	loggingEnabled = false
+	revision       uint64
+)
+
+var (
+	recordPath = flag.String("record", "", "append every state change to this session recording file")
+	listenAddr = flag.String("addr", serverPort, "address the HTTP server listens on")
 )
 
 func main() {
+	flag.Parse()
+	loadCommandLog()
+
+	if *checkFlag {
+		runStartupCheck()
+	}
+	if *verifyWAL {
+		runVerifyWAL()
+	}
+	if *checkWire != "" {
+		runWireCheck()
+	}
+	if *mirrorURL != "" {
+		runMirror()
+	}
+
+	if *recordPath != "" {
+		if err := startRecording(*recordPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening session recording: %v\n", err)
+			os.Exit(1)
+		}
+		defer stopRecording()
+	}
+
+	configureAutoWait()
+	configureHostLead()
+	configureDisplays()
+	configureJudges()
+	if err := configureAnnouncements(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error configuring announcements: %v\n", err)
+		os.Exit(1)
+	}
+	if err := configureWebhooks(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error configuring webhooks: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Initialize the question with default values.
 	initializeQuestion()
 
+	if err := configurePushTargets(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error configuring push targets: %v\n", err)
+		os.Exit(1)
+	}
+	if err := configureUDPBoard(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error in -udp-scoreboard: %v\n", err)
+		os.Exit(1)
+	}
+	if *unlockedFraction < 0 || *unlockedFraction > 1 {
+		fmt.Fprintf(os.Stderr, "Error: -unlocked-fraction must be between 0 and 1\n")
+		os.Exit(1)
+	}
+	if err := loadBank(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading question bank: %v\n", err)
+		os.Exit(1)
+	}
+	if err := loadMedia(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading media: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := parseThresholds(*calibrateThresholds); err != nil {
+		fmt.Fprintf(os.Stderr, "Error in -calibrate-thresholds: %v\n", err)
+		os.Exit(1)
+	}
+	if err := configureProfile(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading profile: %v\n", err)
+		os.Exit(1)
+	}
+	if err := configureSessions(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading sessions: %v\n", err)
+		os.Exit(1)
+	}
+	if *walPath != "" {
+		if err := startWAL(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting write-ahead log: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := validateSyncPolicy(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *syncOnStart {
+		runStartupSync()
+	}
+	if *fileExportDir != "" {
+		if err := startFileExport(*fileExportDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting file export: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	workers.goWorker("expiry", watchExpiry)
+	workers.goWorker("ticks", ticks.run)
+	workers.goWorker("announcer", watchAnnouncements)
+	workers.goWorker("clock watch", watchClock)
+	workers.goWorker("debug signals", watchDebugSignals)
+	workers.goWorker("operator watchdog", watchOperator)
+	workers.goWorker("heartbeat", runHeartbeat)
+	workers.goWorker("heartbeat watch", watchHeartbeat)
+
 	// Start the HTTP server.
 	e := setupServer()
 	startServer(e)
 
+	// Shut down gracefully on OS signals, also while the CLI is running.
+	go waitForShutdown()
+
+	// Without -join-url there is no code to show, and nothing to say.
+	if err := printJoinQR(); err != nil && *joinURLTemplate != "" {
+		color.New(color.FgRed).Println(err)
+	}
+
 	// Start the command-line interface.
 	startCLI()
 
-	// Wait for OS signals to gracefully shut down.
-	waitForShutdown(e)
+	// Without a terminal, run until a signal arrives.
+	select {}
 }
 
 func initializeQuestion() {
 	questionMutex.Lock()
 	defer questionMutex.Unlock()
 	question = Question{
-		Question:  "Default question",
-		TimeLeft:  time.Second * 30,
-		Type:      "pomoc",
-		StartTime: time.Now(),
-		CountUp:   false,
+		Question: "Default question",
+		Type:     "pomoc",
 	}
+	setTimer(&question, time.Second*30)
+	stateChanged("init")
+}
+
+// stateChanged bumps the revision and records the new state. It must be
+// called with questionMutex held for writing.
+func stateChanged(kind string) {
+	revision++
+	recordEvent(kind)
+	noteInternalEvent(kind, revision)
+	wakeExpiryWatcher()
+	wakeAnnouncer()
+	wakeTicks()
+	autoWaitStateChanged(question, revision)
+	wakeWAL()
+	pub := publicQuestion(question)
+	hub.broadcast(Event{Type: "state", Revision: revision, Data: pub})
+	announceHostClock(question, pub)
+}
+
+// newQuestionStarted does the per-question bookkeeping once a new question
+// text went live. It must be called without questionMutex held.
+func newQuestionStarted(q Question, origin string) {
+	audit("question", origin, map[string]interface{}{
+		"question":  q.Question,
+		"type":      q.Type,
+		"time_left": q.TimeLeft.Seconds(),
+		"count_up":  q.CountUp,
+	})
+	startHistoryEntry(q)
+	clearLiveEntry()
+	rebuildScoreboard()
+	checkRundown()
+	deliverHostScript(q)
 }
 
 func setupServer() *echo.Echo {
 	e := echo.New()
+	e.HTTPErrorHandler = httpErrorHandler
+	e.JSONSerializer = versionedJSON{}
+	e.Pre(apiVersions)
 
 	// Configure middleware.
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"*"},
-		AllowMethods: []string{http.MethodGet, http.MethodPost},
+		AllowMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+		// Browser dashboards read the confirmation token of a 428 from it.
+		ExposeHeaders: []string{confirmHeader},
 	}))
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
 		Skipper: func(c echo.Context) bool {
@@ -84,108 +303,480 @@ func setupServer() *echo.Echo {
 		},
 	}))
 	e.Use(middleware.Recover())
+	e.Use(walMiddleware)
+
+	if *stormLimit > 0 {
+		stormGuard = newMutationGuard(*stormLimit, *stormWindow)
+	}
 
 	// Define endpoints.
-	e.GET("/get-question", getQuestion)
-	e.POST("/set-question", setQuestion)
+	e.GET("/get-question", getQuestion, shedPolls)
+	e.GET("/bootstrap", getBootstrap, shedPolls)
+	e.GET("/time-sync", getTimeSync)
+	e.GET("/healthz", getHealthz)
+	e.GET("/readyz", getReadyz)
+	e.POST("/operator/keepalive", postKeepalive, requireAuth)
+	e.DELETE("/operator/keepalive", deleteKeepalive, requireAuth)
+	e.GET("/get-question/full", getQuestionFull, requireAuth)
+	e.POST("/set-question", setQuestion, guardMutation)
+	e.POST("/pause", postPause, requireAuth, guardMutation)
+	e.POST("/time", postTime, requireAuth, guardMutation)
+	e.POST("/time/adjust", postTimeAdjust, requireAuth, guardMutation)
+	e.POST("/resume", postResume, requireAuth, guardMutation)
+	e.GET("/undo", getUndo, requireAuth)
+	e.POST("/undo", postUndo, requireAuth, guardMutation)
+	e.POST("/redo", postRedo, requireAuth, guardMutation)
+	e.POST("/start-answering", postStartAnswering, requireAuth, guardMutation)
+	e.POST("/page/next", postPageNext, requireAuth, guardMutation)
+	e.GET("/time.txt", getTimeText)
+	e.GET("/pauses", getPauses)
+	e.GET("/question-types", getQuestionTypes)
+	e.GET("/openapi.json", getOpenAPI)
+	e.GET("/media", getMedia, requireAuth)
+	e.POST("/media", postMedia, requireAuth, guardMutation)
+	e.GET("/media/:name", getMediaFile)
+	e.GET("/events", getEvents)
+	e.GET("/kiosk/:team", getKiosk)
+	e.GET("/kiosk/:team/events", getKioskEvents)
+	e.GET("/display", getDisplay)
+	e.GET("/display/events", getDisplayEvents)
+	e.GET("/join-qr.png", getJoinQR)
+	e.GET("/ws", getWS)
+	e.GET("/scoreboard", getScoreboard)
+	e.GET("/stats", getStats)
+	e.GET("/profile", getProfile, requireAuth)
+	e.GET("/history", getHistory, requireAuth)
+	e.GET("/history/:id", getHistoryEntry, requireAuth)
+	e.GET("/history/:id/fairness", getHistoryFairness, requireAuth)
+	e.POST("/buzz", postBuzz)
+	e.POST("/buzz/reset", postBuzzReset, requireAuth, guardMutation)
+	e.POST("/answer", postAnswer)
+	e.POST("/answer/lock", postAnswerLock)
+	e.POST("/answer/unlock", postAnswerUnlock, requireAuth, guardMutation)
+	e.GET("/answers", getAnswers, requireAuth)
+	e.POST("/answers/close", postAnswersClose, requireAuth, guardMutation)
+	e.POST("/answers/open", postAnswersOpen, requireAuth, guardMutation)
+	e.POST("/answers/award", postAward, requireAuth, guardMutation)
+	e.POST("/answers/:team/adjudicate", postAdjudicate, requireAuth, guardMutation)
+	e.POST("/eliminate", postEliminate, requireAuth, guardMutation)
+	e.POST("/options/shuffle", postOptionsShuffle, requireAuth, guardMutation)
+	e.POST("/options/fifty-fifty", postFiftyFifty, requireAuth, guardMutation)
+	e.GET("/sync-status", getSyncStatus, requireAuth)
+	e.GET("/operators", getOperators, requireAuth)
+	e.GET("/targets", getTargets, requireAuth)
+	e.POST("/targets", postTarget, requireAuth, guardMutation)
+	e.DELETE("/targets/:name", deleteTarget, requireAuth, guardMutation)
+	e.GET("/backup", getBackup, requireAuth)
+	e.POST("/restore", postRestore, requireAuth, guardMutation, requireConfirmation("restore", restoreSummary))
+	e.POST("/heartbeat", postHeartbeat)
+	e.GET("/displays", getDisplays, requireAuth)
+	e.GET("/presets", getPresets)
+	e.POST("/presets", postPreset, requireAuth, guardMutation)
+	e.DELETE("/presets/:name", deletePresetHandler, requireAuth, guardMutation)
+	e.GET("/vars", getVars)
+	e.POST("/vars", postVars, requireAuth, guardMutation)
+	e.GET("/teams", getTeams)
+	e.POST("/teams", postTeam, requireAuth, guardMutation)
+	e.DELETE("/teams/:name", deleteTeam, requireAuth, guardMutation, requireConfirmation("team_remove", func(c echo.Context) string {
+		return teamRemovalSummary(c.Param("name"))
+	}))
+	e.POST("/teams/:name/score", postScore, requireAuth, guardMutation)
+	e.POST("/teams/:name/login", postTeamLogin)
+	e.POST("/teams/:name/lifeline", postLifeline, requireCaptain, guardMutation)
+	e.GET("/adjustments", getAdjustments, requireAuth)
+	e.POST("/adjustments", postAdjustment, requireAuth, guardMutation)
+	e.POST("/adjustments/:id/revert", postRevertAdjustment, requireAuth, guardMutation)
+	e.GET("/disputes", getDisputes, requireAuth)
+	e.POST("/disputes", postDispute, requireAuth, guardMutation)
+	e.GET("/disputes/:id", getDispute, requireAuth)
+	e.POST("/disputes/:id/resolve", postResolveDispute, requireAuth, guardMutation)
+	e.GET("/teams/:name/timeline", getTeamTimeline)
+	e.GET("/timeline", getTimeline)
+	e.GET("/queue", getQueue, requireAuth)
+	e.POST("/queue", postQueue, requireAuth, guardMutation)
+	e.POST("/stage/parse", postStageParse, requireAuth, guardMutation)
+	e.DELETE("/queue/:id", deleteQueueEntry, requireAuth, guardMutation, requireConfirmation("queue_remove", func(c echo.Context) string {
+		id, _ := strconv.Atoi(c.Param("id"))
+		return queueRemovalSummary(id)
+	}))
+	e.POST("/queue/next", postQueueNext, requireAuth, guardMutation)
+	e.GET("/checklist", getChecklist, requireAuth)
+	e.POST("/checklist/:item/check", postChecklistItem(true), requireAuth, guardMutation)
+	e.POST("/checklist/:item/uncheck", postChecklistItem(false), requireAuth, guardMutation)
+	e.POST("/queue/adjust-time", postQueueAdjustTime, requireAuth, guardMutation)
+	e.POST("/queue/move", postQueueMove, requireAuth, guardMutation)
+	e.POST("/queue/build", postQueueBuild, requireAuth, guardMutation)
+	e.PUT("/queue/order", putQueueOrder, requireAuth, guardMutation)
+	e.GET("/bank", getBank, requireAuth)
+	e.GET("/bank/export", getBankExport, requireAuth)
+	e.GET("/bank/duplicates", getBankDuplicates, requireAuth)
+	e.GET("/bank/search", getBankSearch, requireAuth)
+	e.POST("/bank/duplicates/resolve", postResolveDuplicates, requireAuth, guardMutation, requireConfirmation("bank_remove", duplicateRemovalSummary))
+	e.GET("/bank/:id", getBankEntry, requireAuth)
+	e.GET("/bank/:id/stats", getBankStats, requireAuth)
+	e.POST("/bank/:id/queue", postBankQueue, requireAuth, guardMutation)
+	e.POST("/bank", postBankEntry, requireAuth, guardMutation)
+	e.PUT("/bank/:id", putBankEntry, requireAuth, guardMutation)
+	e.DELETE("/bank/:id", deleteBankEntryHandler, requireAuth, guardMutation, requireConfirmation("bank_remove", func(c echo.Context) string {
+		id, _ := strconv.Atoi(c.Param("id"))
+		return bankRemovalSummary(id)
+	}))
+	e.POST("/judge/extend", postJudgeExtend, guardMutation)
+	e.GET("/judges", getJudges)
+	e.GET("/rundown", getRundown, requireAuth)
+	e.POST("/rundown", postRundown, requireAuth, guardMutation)
+	e.POST("/rundown/:n/mark", postRundownMark, requireAuth, guardMutation)
+	e.GET("/debug/state", getDebugState, requireAuth)
+	e.GET("/metrics", getMetrics)
+	e.GET("/notifications", getNotifications, requireAuth)
+	e.POST("/notifications/:id/ack", postNotificationAck, requireAuth)
+	e.POST("/session/start", postSessionStart, requireAuth, guardMutation)
+	e.POST("/session/end", postSessionEnd, requireAuth, guardMutation, requireConfirmation("session_end", sessionEndSummary))
+	e.GET("/sessions", getSessions, requireAuth)
+	e.GET("/sessions/:id/export", getSessionExport, requireAuth)
+	e.GET("/report", getReport, requireAuth)
+	e.POST("/report/send", postReportSend, requireAuth, guardMutation)
+	e.GET("/ceremony", getCeremony, requireAuth)
+	e.POST("/ceremony/start", postCeremonyStart, requireAuth, guardMutation)
+	e.POST("/ceremony/reveal-next", postCeremonyRevealNext, requireAuth, guardMutation)
+	e.POST("/ceremony/exit", postCeremonyExit, requireAuth, guardMutation)
 
 	return e
 }
 
 func startServer(e *echo.Echo) {
+	httpServer = e
 	go func() {
-		if err := e.Start(serverPort); err != nil && err != http.ErrServerClosed {
+		if err := e.Start(*listenAddr); err != nil && err != http.ErrServerClosed {
 			e.Logger.Fatalf("Error starting server: %v", err)
 		}
 	}()
 }
 
 func getQuestion(c echo.Context) error {
-	questionMutex.RLock()
-	defer questionMutex.RUnlock()
+	body, err := cachedPublicQuestion(c.QueryParam("lang"), apiVersion(c))
+	if err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, body)
+}
 
-	q := question
+// publicQuestion derives the payload clients see from the stored question:
+// remaining (or elapsed) time as of now and the public ceremony state.
+func publicQuestion(stored Question) PublicQuestionView {
+	q := PublicQuestionView{
+		Question:          stored.Question,
+		TimeLeft:          stored.TimeLeft,
+		Type:              stored.Type,
+		StartTime:         stored.StartTime,
+		CountUp:           stored.CountUp,
+		AllowOvertime:     stored.AllowOvertime,
+		AnswerWindow:      stored.AnswerWindow,
+		OptionsRevealAt:   stored.OptionsRevealAt,
+		ReadingTime:       stored.ReadingTime,
+		Reading:           stored.Reading,
+		Paused:            stored.Paused,
+		Stalled:           stored.Stalled,
+		PauseReason:       stored.PauseReason,
+		PauseMessage:      stored.PauseMessage,
+		AriaLiveText:      stored.AriaLiveText,
+		Variants:          stored.Variants,
+		MediaURL:          stored.MediaURL,
+		MediaDisplayURL:   stored.MediaDisplayURL,
+		MediaFallbackText: stored.MediaFallbackText,
+		Options:           stored.Options,
+		NextMedia:         currentPreload(),
+		Meta:              stored.Meta,
+		Ceremony:          publicCeremony(),
+		Round:             publicRound(),
+		Extra:             questionExtra(stored),
+	}
+	applyPage(&q, stored)
+	if age, late := heartbeatStall(); late {
+		q.Degraded, q.StallAge = true, age
+	}
 
-	if pause {
-		return c.JSON(http.StatusOK, q)
+	now := clock.Now()
+	if left, hidden := optionsHiddenFor(stored, now); hidden {
+		q.Options, q.OptionsRevealIn = nil, left
 	}
 
-	if q.CountUp {
-		q.TimeLeft = time.Since(q.StartTime)
-	} else {
-		q.TimeLeft = q.TimeLeft - time.Since(q.StartTime)
+	// A paused clock holds its frozen value, see freezeClock.
+	elapsed := clockElapsed(stored, now)
+	switch {
+	case q.Reading:
+		// The answer time hasn't started, only the reading time runs.
+		q.ReadingTime -= elapsed
+		if q.ReadingTime < 0 {
+			q.ReadingTime = 0
+		}
+	case q.CountUp:
+		if !q.Paused && !q.Stalled {
+			q.TimeLeft = elapsed
+		}
+	default:
+		q.TimeLeft = timeRemaining(stored, now)
 		if q.TimeLeft < 0 {
+			if q.AllowOvertime {
+				q.Overtime = -q.TimeLeft
+			} else {
+				q.Type, q.Extra = "end", nil
+			}
 			q.TimeLeft = 0
-			q.Type = "end"
 		}
 	}
 
-	return c.JSON(http.StatusOK, q)
+	deriveDisplay(&q)
+	return q
+}
+
+// deriveDisplay fills in what follows from the times of q: the formatted
+// time, the phase and the offset.
+func deriveDisplay(q *PublicQuestionView) {
+	td := formatTimeDisplay(q.TimeLeft, q.CountUp)
+	if q.Reading {
+		td = formatTimeDisplay(q.ReadingTime, false)
+	} else if q.Overtime > 0 {
+		td = formatTimeDisplay(q.Overtime, true)
+		td.Text = "+" + td.Text
+	}
+	q.TimeDisplay = &td
+	q.Phase = questionPhase(*q)
+	q.UTCOffset = clock.Now().Local().Format("-07:00")
+}
+
+// questionPhase names where a derived payload is in its lifecycle.
+func questionPhase(q PublicQuestionView) string {
+	switch {
+	case q.Type == "waiting":
+		return "waiting"
+	case q.Type == "end":
+		return "ended"
+	case q.Reading:
+		return "reading"
+	case q.Paused:
+		return "paused"
+	case q.Overtime > 0:
+		return "overtime"
+	case q.CountUp:
+		return "counting_up"
+	}
+	return "running"
+}
+
+// operatorQuestion is the public payload plus the operator-only fields.
+func operatorQuestion(q Question) OperatorQuestionView {
+	lock := liveLockOn()
+	pub := publicQuestion(q)
+	lead := questionHostLead(q)
+	// Operators see hidden options; OptionsRevealIn still says when the
+	// audience will.
+	pub.Options = q.Options
+	return OperatorQuestionView{
+		PublicQuestionView: pub,
+		HostLead:           lead,
+		HostTimeLeft:       hostTimeLeft(pub, lead),
+		Notes:              q.Notes,
+		HostScript:         q.HostScript,
+		FullQuestion:       fullQuestion(q),
+		Scoring:            q.Scoring,
+		Breakdown:          scoreBreakdown(q.Scoring, listAnswers()),
+		Matching:           q.Matching,
+		Verdicts:           verdictsFor(q),
+		LockWhileLive:      lock,
+		Locked:             lock && questionRunning(q),
+	}
+}
+
+// questionView picks the payload the caller of c may see.
+func questionView(c echo.Context, q Question) interface{} {
+	if isAuthenticated(c) {
+		return operatorQuestion(q)
+	}
+	return publicQuestion(q)
+}
+
+func getQuestionFull(c echo.Context) error {
+	questionMutex.RLock()
+	defer questionMutex.RUnlock()
+
+	return c.JSON(http.StatusOK, operatorQuestion(question))
+}
+
+// QuestionRequest is the body of /set-question. Only these fields can be
+// set by clients; the rest of Question is server state.
+type QuestionRequest struct {
+	Question string       `json:"question"`
+	TimeLeft FlexDuration `json:"time_left"`
+	Type     string       `json:"type"`
+	CountUp  bool         `json:"count_up"`
+	Notes    string       `json:"notes"`
+	HostLead FlexDuration `json:"host_lead"`
+	// HostScript lines may use template variables like the question.
+	HostScript []string `json:"host_script"`
+
+	AllowOvertime bool         `json:"allow_overtime"`
+	AnswerWindow  FlexDuration `json:"answer_window"`
+	// OptionsRevealAt hides the options for this long into the answer
+	// time.
+	OptionsRevealAt FlexDuration      `json:"options_reveal_at"`
+	ReadingTime     FlexDuration      `json:"reading_time"`
+	Variants        map[string]string `json:"variants"`
+	MediaURL        string            `json:"media_url"`
+	Options         []AnswerOption    `json:"options"`
+	// MediaFallbackText is required with audio and video media.
+	MediaFallbackText string         `json:"media_fallback_text"`
+	Scoring           *ScoringPolicy `json:"scoring"`
+	Matching          *MatchRules    `json:"matching"`
+
+	// Override replaces a running question despite lock while live. It
+	// may also be given as ?override=true.
+	Override bool `json:"override"`
+}
+
+func (r QuestionRequest) toQuestion() Question {
+	return Question{Question: r.Question, TimeLeft: time.Duration(r.TimeLeft), Type: r.Type, CountUp: r.CountUp, Notes: r.Notes, HostLead: time.Duration(r.HostLead), HostScript: r.HostScript, AllowOvertime: r.AllowOvertime, AnswerWindow: time.Duration(r.AnswerWindow), OptionsRevealAt: time.Duration(r.OptionsRevealAt), ReadingTime: time.Duration(r.ReadingTime), Variants: r.Variants, MediaURL: r.MediaURL, MediaFallbackText: r.MediaFallbackText, Options: r.Options, Scoring: r.Scoring, Matching: r.Matching}
 }
 
 func setQuestion(c echo.Context) error {
-	newQuestion := new(Question)
-	if err := c.Bind(newQuestion); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	req := new(QuestionRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
 	}
 
-	if err := validateQuestion(*newQuestion); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	newQuestion := req.toQuestion()
+	if err := validateQuestion(newQuestion); err != nil {
+		return badRequest(err.Error())
+	}
+	if err := probeQuestionMedia(newQuestion.MediaURL, newQuestion.MediaFallbackText); err != nil {
+		return mediaError(err)
+	}
+	if err := checkLiveLock(req.Override || c.QueryParam("override") == "true", requestOrigin(c)); err != nil {
+		return err
+	}
+	live, err := goLive(newQuestion, "set-question", requestOrigin(c))
+	if err == errCeremonyActive {
+		return conflict(err.Error())
+	}
+	if err != nil {
+		return templateError(err)
+	}
+	return c.JSON(http.StatusOK, questionView(c, live))
+}
+
+var errCeremonyActive = fmt.Errorf("exit the results ceremony before starting a new question")
+
+// goLive renders q and installs it as the live question, carrying over the
+// pause state, and pushes it to the targets.
+func goLive(q Question, kind, origin string) (Question, error) {
+	if ceremonyActive() {
+		return Question{}, errCeremonyActive
+	}
+	rendered, err := renderQuestionText(q.Question)
+	if err != nil {
+		return Question{}, err
+	}
+	q.Template = q.Question
+	q.Question = rendered
+	q.MediaDisplayURL = mediaDisplayURL(q.MediaURL)
+	if q.Variants, err = renderVariants(q.Variants); err != nil {
+		return Question{}, err
+	}
+	if q.HostScript, err = renderHostScript(q.HostScript); err != nil {
+		return Question{}, err
+	}
+	if !q.CountUp && q.Type != "end" && q.Type != "waiting" {
+		rememberTime(q.TimeLeft)
 	}
 
+	resetRound()
 	questionMutex.Lock()
-	question = *newQuestion
-	question.StartTime = time.Now()
+	recordOvertime(question, origin)
+	q.Paused = question.Paused
+	q.PauseReason = question.PauseReason
+	q.PauseMessage = question.PauseMessage
+	prev := question
+	replaced := frozenQuestion(prev)
+	question = q
+	// A new question restarts the timer.
+	question.Duration = question.TimeLeft
+	restartTimer(&question)
 	if question.Type == "end" {
 		question.Question = "END"
 	}
+	promoteMeta(&question, prev)
+	question.Reading = question.ReadingTime > 0 && question.Type != "end" && question.Type != "waiting"
+	if !question.Reading {
+		startAnswerClock(question.StartTime)
+	}
+	stateChanged(kind)
+	live := question
 	questionMutex.Unlock()
+	newQuestionStarted(live, origin)
+	recordQuestionUndo(replaced, live.Question, origin)
+	if live.Type == "end" {
+		webhookRoundPlayed(0)
+	}
 
 	// Send the current question to the Flask server.
-	go sendCurrentQuestion()
-
-	return c.JSON(http.StatusOK, question)
+	go sendUrgentQuestion()
+	return live, nil
 }
 
 func validateQuestion(q Question) error {
 	if q.TimeLeft < 0 {
 		return fmt.Errorf("time_left must be non-negative")
 	}
-	validTypes := map[string]bool{
-		"pomoc":    true,
-		"rozstrel": true,
-		"waiting":  true,
-		"end":      true,
+	if q.ReadingTime < 0 {
+		return fmt.Errorf("reading_time must be non-negative")
 	}
-	if !validTypes[q.Type] {
-		return fmt.Errorf("invalid type. Must be one of: pomoc, rozstrel, waiting, end")
+	if q.HostLead < 0 {
+		return fmt.Errorf("host_lead must be non-negative")
 	}
-	return nil
+	if err := validateMediaURL(q.MediaURL); err != nil {
+		return err
+	}
+	if err := validateMedia(q); err != nil {
+		return err
+	}
+	if err := validateOptions(q.Options); err != nil {
+		return err
+	}
+	if q.AnswerWindow < 0 {
+		return fmt.Errorf("answer_window must be non-negative")
+	}
+	if !q.CountUp && q.AnswerWindow > q.TimeLeft {
+		return fmt.Errorf("answer_window must not exceed time_left")
+	}
+	if err := validateOptionsReveal(q); err != nil {
+		return err
+	}
+	if err := validateScoring(q.Scoring); err != nil {
+		return err
+	}
+	if err := validateMatching(q.Matching); err != nil {
+		return err
+	}
+	if !validQuestionType(q.Type) {
+		return fmt.Errorf("invalid type. Must be one of: %s", strings.Join(questionTypeNames(), ", "))
+	}
+	return validateVariants(q)
 }
 
+// sendCurrentQuestion queues the current question for every enabled push
+// target. Delivery and retries happen on each target's own worker, which
+// waits -push-coalesce so quick edits go out as one push.
 func sendCurrentQuestion() {
-	questionMutex.RLock()
-	jsonData, err := json.Marshal(question)
-	questionMutex.RUnlock()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error marshaling JSON: %v\n", err)
-		return
-	}
-
-	resp, err := http.Post(flaskServerURL+"/set-current-question", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error sending POST request: %v\n", err)
-		return
-	}
-	defer resp.Body.Close()
+	notifyPushTargets(false)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintf(os.Stderr, "Failed to send question, status code: %d\n", resp.StatusCode)
-	}
+// sendUrgentQuestion is sendCurrentQuestion for transitions the displays
+// must show at once, such as a question going live or expiring.
+func sendUrgentQuestion() {
+	notifyPushTargets(true)
 }
 
 func startCLI() {
-	success := color.New(color.FgGreen)
 	errorC := color.New(color.FgRed)
 	info := color.New(color.FgYellow)
 
@@ -193,41 +784,223 @@ func startCLI() {
 		readline.PcItem("question"),
 		readline.PcItem("time",
 			readline.PcItem("last"),
+			readline.PcItem("preset",
+				readline.PcItemDynamic(func(string) []string { return presetNames() }),
+			),
 			readline.PcItem("pause"),
 			readline.PcItem("countUp"),
 		),
 		readline.PcItem("type",
-			readline.PcItem("pomoc"),
-			readline.PcItem("rozstrel"),
-			readline.PcItem("waiting"),
-			readline.PcItem("end"),
+			readline.PcItemDynamic(func(string) []string { return questionTypeNames() }),
 		),
 		readline.PcItem("status"),
+		readline.PcItem("pauses"),
+		readline.PcItem("pause"),
+		readline.PcItem("resume"),
 		readline.PcItem("logging",
 			readline.PcItem("on"),
 			readline.PcItem("off"),
 		),
+		readline.PcItem("team",
+			readline.PcItem("add"),
+			readline.PcItem("rm"),
+			readline.PcItem("list"),
+			readline.PcItem("pin"),
+		),
+		readline.PcItem("score",
+			readline.PcItem("log"),
+			readline.PcItem("revert"),
+		),
+		readline.PcItem("ceremony",
+			readline.PcItem("start"),
+			readline.PcItem("exit"),
+		),
+		readline.PcItem("reveal",
+			readline.PcItem("next"),
+		),
+		readline.PcItem("backup"),
+		readline.PcItem("restore"),
+		readline.PcItem("replay",
+			readline.PcItem("pause"),
+			readline.PcItem("stop"),
+		),
+		readline.PcItem("var"),
+		readline.PcItem("vars"),
+		readline.PcItem("lock",
+			readline.PcItem("on"),
+			readline.PcItem("off"),
+		),
+		readline.PcItem("unlock"),
+		readline.PcItem("debug"),
+		readline.PcItem("script"),
+		readline.PcItem("next",
+			readline.PcItem("--force"),
+		),
+		readline.PcItem("check"),
+		readline.PcItem("uncheck"),
+		readline.PcItem("close",
+			readline.PcItem("--stop"),
+		),
+		readline.PcItem("open"),
+		readline.PcItem("adjust",
+			readline.PcItem("--force"),
+		),
+		readline.PcItem("hostlead"),
+		readline.PcItem("who"),
+		readline.PcItem("build"),
+		readline.PcItem("undo"),
+		readline.PcItem("redo"),
+		readline.PcItem("report",
+			readline.PcItem("send"),
+			readline.PcItem("render"),
+		),
+		readline.PcItem("hotkeys",
+			readline.PcItem("on"),
+			readline.PcItem("off"),
+		),
+		readline.PcItem("doctor"),
+		readline.PcItem("fileexport",
+			readline.PcItem("on"),
+			readline.PcItem("off"),
+		),
+		readline.PcItem("go",
+			readline.PcItem("answers"),
+		),
+		readline.PcItem("history"),
+		readline.PcItem("dispute",
+			readline.PcItem("show"),
+			readline.PcItem("resolve"),
+		),
+		readline.PcItem("qr"),
+		readline.PcItem("hist",
+			readline.PcItem("search"),
+			readline.PcItem("stats"),
+			readline.PcItem("run"),
+		),
+		readline.PcItem("timeline"),
+		readline.PcItem("page"),
+		readline.PcItem("session",
+			readline.PcItem("start"),
+			readline.PcItem("end"),
+			readline.PcItem("list"),
+			readline.PcItem("resume"),
+			readline.PcItem("discard"),
+		),
+		readline.PcItem("stats",
+			readline.PcItem("reset"),
+		),
+		readline.PcItem("judges",
+			readline.PcItem("reset"),
+		),
+		readline.PcItem("bank",
+			readline.PcItem("list"),
+			readline.PcItem("show"),
+			readline.PcItem("rm"),
+			readline.PcItem("queue"),
+			readline.PcItem("dedupe"),
+			readline.PcItem("calibrate",
+				readline.PcItem("--apply"),
+				readline.PcItem("--min"),
+			),
+		),
+		readline.PcItem("find",
+			readline.PcItem("--tag"),
+		),
+		readline.PcItem("stage"),
+		readline.PcItem("pick"),
+		readline.PcItem("paste"),
+		readline.PcItem("queue",
+			readline.PcItem("add"),
+			readline.PcItem("rm"),
+			readline.PcItem("time"),
+			readline.PcItem("move"),
+		),
+		readline.PcItem("preview"),
+		readline.PcItem("autowait"),
+		readline.PcItem("profile",
+			readline.PcItem("load"),
+			readline.PcItem("save"),
+		),
+		readline.PcItem("announce",
+			readline.PcItem("at"),
+			readline.PcItem("lang"),
+			readline.PcItem("off"),
+		),
+		readline.PcItem("display",
+			readline.PcItem("critical"),
+			readline.PcItem("normal"),
+		),
+		readline.PcItem("preset",
+			readline.PcItem("list"),
+			readline.PcItem("save"),
+			readline.PcItem("rm",
+				readline.PcItemDynamic(func(string) []string { return presetNames() }),
+			),
+		),
+		readline.PcItem("rundown",
+			readline.PcItem("mark"),
+		),
+		readline.PcItem("notifications"),
+		readline.PcItem("notify",
+			readline.PcItem("test"),
+		),
+		readline.PcItem("ack",
+			readline.PcItem("all"),
+		),
+		readline.PcItem("eliminate"),
+		readline.PcItem("options",
+			readline.PcItem("shuffle"),
+			readline.PcItem("5050"),
+		),
+		readline.PcItem("lockin",
+			readline.PcItem("undo"),
+		),
+		readline.PcItem("award",
+			readline.PcItem("--correct"),
+		),
+		readline.PcItem("review"),
+		readline.PcItem("buzz",
+			readline.PcItem("reset"),
+		),
+		readline.PcItem("sync",
+			readline.PcItem("pull",
+				readline.PcItem("--local"),
+				readline.PcItem("--remote"),
+			),
+		),
+		readline.PcItem("failover",
+			readline.PcItem("display",
+				readline.PcItem("--no-push"),
+			),
+		),
+		readline.PcItem("target",
+			readline.PcItem("list"),
+			readline.PcItem("add"),
+			readline.PcItem("show"),
+			readline.PcItem("rm"),
+			readline.PcItem("enable"),
+			readline.PcItem("disable"),
+		),
 		readline.PcItem("help"),
 		readline.PcItem("exit"),
 	)
 
-	rl, err := readline.NewEx(&readline.Config{
-		Prompt:          "\033[32m> \033[0m",
-		AutoComplete:    &MultiCommandCompleter{completer},
-		HistoryFile:     historyFile,
-		InterruptPrompt: "^C",
-		EOFPrompt:       "exit",
-	})
+	rl, err := newReadline(completer)
 	if err != nil {
 		errorC.Printf("Error initializing readline: %v\n", err)
-		os.Exit(1)
+		printHeadlessNote()
+		return
+	}
+	if rl == nil {
+		runScannerCLI()
+		return
 	}
 	defer rl.Close()
+	setActiveReadline(rl)
+	defer setActiveReadline(nil)
 
 	info.Println("Server started. Type 'help' for available commands.")
 
-	var lastTime int
-
 	for {
 		line, err := rl.Readline()
 		if err != nil {
@@ -244,165 +1017,618 @@ func startCLI() {
 				continue
 			}
 		}
-		input := strings.TrimSpace(line)
-		if input == "" {
+		if strings.TrimSpace(line) != "" {
+			rl.SaveHistory(redactCommand(strings.TrimSpace(line)))
+		}
+		runCommandLine(line, "cli")
+	}
+}
+
+// runCommandLine executes one line of CLI input, which may hold several
+// commands separated by semicolons, and prints the error of each one that
+// fails. origin says where it came from for the command history, which
+// keeps the first error; all of them are the operator at the terminal.
+func runCommandLine(line, origin string) {
+	errorC := color.New(color.FgRed)
+
+	input := strings.TrimSpace(line)
+	if input == "" {
+		return
+	}
+	noteCLIInput()
+	defer walCommit()
+	var failed error
+	finish := trackCommand(input, origin)
+	defer func() { finish(failed) }()
+
+	// Handle multiple commands separated by semicolons.
+	for _, cmd := range strings.Split(input, ";") {
+		args := strings.Fields(cmd)
+		if len(args) == 0 {
 			continue
 		}
+		var err error
+		if cliCommandBlocked(args[0], args[1:]) {
+			err = fmt.Errorf("CLI is locked, '%s' needs 'unlock <pin>' first", args[0])
+		} else {
+			_, err = runCommand(args, "cli")
+		}
+		if err == nil {
+			continue
+		}
+		if failed == nil {
+			failed = err
+		}
+		errorC.Println(err)
+		var h *commandHint
+		if errors.As(err, &h) {
+			errorC.Println(h.hint)
+		}
+	}
+}
 
-		// Handle multiple commands separated by semicolons.
-		commands := strings.Split(input, ";")
-		for _, cmd := range commands {
-			cmd = strings.TrimSpace(cmd)
-			if cmd == "" {
-				continue
-			}
-			args := strings.Fields(cmd)
-			command := args[0]
-			switch command {
-			case "logging":
-				if len(args) != 2 {
-					errorC.Println("Usage: logging <on/off>")
-					continue
-				}
-				switch args[1] {
-				case "on":
-					loggingEnabled = true
-					success.Println("Request logging enabled")
-				case "off":
-					loggingEnabled = false
-					success.Println("Request logging disabled")
-				default:
-					errorC.Println("Invalid option. Use 'on' or 'off'")
-				}
-			case "exit":
-				success.Println("Shutting down server...")
-				os.Exit(0)
-			case "question":
-				if len(args) < 2 {
-					errorC.Println("Usage: question <text>")
-					continue
-				}
-				questionMutex.Lock()
-				question.Question = strings.Join(args[1:], " ")
-				question.StartTime = time.Now()
-				questionMutex.Unlock()
-				success.Printf("Question set to: %s\n", question.Question)
-
-				// Send the current question to the Flask server.
-				go sendCurrentQuestion()
-			case "time":
-				if len(args) != 2 {
-					errorC.Println("Usage: time <seconds|last|pause|countUp>")
-					continue
-				}
-				switch args[1] {
-				case "last":
-					questionMutex.Lock()
-					question.TimeLeft = time.Duration(lastTime) * time.Second
-					question.StartTime = time.Now()
-					question.CountUp = false
-					questionMutex.Unlock()
-					success.Printf("Time left set to: %d seconds\n", lastTime)
-				case "pause":
-					pause = !pause
-					if pause {
-						success.Println("Question paused")
-					} else {
-						success.Println("Question unpaused")
-						questionMutex.Lock()
-						question.StartTime = time.Now()
-						questionMutex.Unlock()
-					}
-				case "countUp":
-					questionMutex.Lock()
-					question.StartTime = time.Now()
-					question.CountUp = true
-					questionMutex.Unlock()
-					success.Println("Counting up")
-				default:
-					timeLeft, err := strconv.Atoi(args[1])
-					if err != nil || timeLeft < 0 {
-						errorC.Println("Time must be a non-negative integer")
-						continue
-					}
-					lastTime = timeLeft
-					questionMutex.Lock()
-					question.TimeLeft = time.Duration(timeLeft) * time.Second
-					question.StartTime = time.Now()
-					question.CountUp = false
-					questionMutex.Unlock()
-					success.Printf("Time left set to: %d seconds\n", timeLeft)
-				}
-			case "type":
-				if len(args) != 2 {
-					errorC.Println("Usage: type <pomoc/rozstrel/waiting/end>")
-					continue
-				}
-				validTypes := map[string]bool{
-					"pomoc":    true,
-					"rozstrel": true,
-					"waiting":  true,
-					"end":      true,
-				}
-				if !validTypes[args[1]] {
-					errorC.Println("Invalid type. Must be: pomoc, rozstrel, waiting, or end")
-					continue
-				}
-				questionMutex.Lock()
-				question.Type = args[1]
-				if question.Type == "end" {
-					question.Question = "END"
-				}
-				questionMutex.Unlock()
-				success.Printf("Type set to: %s\n", args[1])
-			case "status":
-				questionMutex.RLock()
-				info.Println("Current question status:")
-				info.Printf("Question: %s\n", question.Question)
-				if question.CountUp {
-					elapsedTime := time.Since(question.StartTime)
-					info.Printf("Elapsed time: %d seconds\n", int(elapsedTime.Seconds()))
-				} else {
-					timeLeft := question.TimeLeft - time.Since(question.StartTime)
-					if timeLeft < 0 {
-						timeLeft = 0
-					}
-					info.Printf("Time left: %d seconds\n", int(timeLeft.Seconds()))
+// commandHint is a command error with advice on what to type instead,
+// which the terminal prints under it.
+type commandHint struct {
+	error
+	hint string
+}
+
+func (h *commandHint) Unwrap() error { return h.error }
+
+func withHint(err error, hint string) error {
+	return &commandHint{error: err, hint: hint}
+}
+
+// runCommand runs one command, args[0] being its name, and is what every
+// surface that takes CLI commands goes through. Changes are attributed to
+// origin: "cli" at the terminal, "ws:<operator>" from the dashboard socket.
+// Output is printed as the command goes; the result is for callers that
+// don't read the terminal.
+func runCommand(args []string, origin string) (interface{}, error) {
+	success := color.New(color.FgGreen)
+	errorC := color.New(color.FgRed)
+	info := color.New(color.FgYellow)
+
+	switch command := args[0]; command {
+	case "logging":
+		if len(args) != 2 {
+			return nil, errors.New("Usage: logging <on/off>")
+		}
+		switch args[1] {
+		case "on":
+			loggingEnabled = true
+			success.Println("Request logging enabled")
+		case "off":
+			loggingEnabled = false
+			success.Println("Request logging disabled")
+		default:
+			return nil, errors.New("Invalid option. Use 'on' or 'off'")
+		}
+	case "exit":
+		shutdown("cli exit")
+		os.Exit(0)
+	case "question":
+		override := len(args) > 1 && args[1] == "--override"
+		if override {
+			args = append(args[:1:1], args[2:]...)
+		}
+		if len(args) < 2 {
+			return nil, errors.New("Usage: question [--override] <text> [| <option> | <option> ...]")
+		}
+		if ceremonyActive() {
+			return nil, errors.New("Exit the results ceremony first (ceremony exit)")
+		}
+		if err := checkLiveLock(override, origin); err != nil {
+			return nil, errors.New("Question is running and lock while live is on (question --override <text> replaces it)")
+		}
+		raw := strings.Join(args[1:], " ")
+		// "question text | A | *B" gives the options too.
+		parts := strings.Split(raw, "|")
+		if raw = strings.TrimSpace(parts[0]); raw == "" {
+			return nil, errors.New("Usage: question [--override] <text> [| <option> | <option> ...]")
+		}
+		options, correct, err := parseTypedOptions(parts[1:])
+		if err != nil {
+			return nil, err
+		}
+		text, err := renderQuestionText(raw)
+		if err != nil {
+			return nil, err
+		}
+		resetRound()
+		questionMutex.Lock()
+		prev := question
+		replaced := frozenQuestion(prev)
+		question.Question = text
+		question.Template = raw
+		question.Variants = nil
+		question.HostScript = nil
+		question.MediaURL, question.MediaDisplayURL, question.MediaFallbackText = "", "", ""
+		question.Options = options
+		if correct >= 0 {
+			question.Matching = optionMatching(options, correct)
+		}
+		question.Page = 0
+		question.Meta = nil
+		question.BankID = 0
+		promoteMeta(&question, prev)
+		// New text is a new question: the timer starts over.
+		restartTimer(&question)
+		stateChanged("question")
+		live := question
+		questionMutex.Unlock()
+		newQuestionStarted(live, origin)
+		recordQuestionUndo(replaced, text, origin)
+		success.Printf("Question set to: %s\n", text)
+		for i, o := range options {
+			info.Printf("  %s) %s\n", optionLabel(i), o)
+		}
+
+		// Send the current question to the Flask server.
+		go sendCurrentQuestion()
+	case "time":
+		force := len(args) > 2 && args[len(args)-1] == "--force" && args[1] != "pause"
+		if force {
+			args = args[:len(args)-1]
+		}
+		if len(args) != 2 && !(len(args) > 2 && (args[1] == "pause" || args[1] == "preset")) {
+			return nil, errors.New("Usage: time <seconds|last|preset <name>|pause [reason] [\"message\"]|countUp> [--force]")
+		}
+		switch args[1] {
+		case "last", "preset":
+			name := lastPreset
+			if args[1] == "preset" {
+				if len(args) != 3 {
+					return nil, errors.New("Usage: time preset <name>")
 				}
-				info.Printf("Type: %s\n", question.Type)
-				info.Printf("Logging: %v\n", loggingEnabled)
-				questionMutex.RUnlock()
-			case "help":
-				printHelp()
-			default:
-				errorC.Printf("Unknown command: %s\n", command)
-				errorC.Println("Type 'help' for available commands")
+				name = args[2]
+			}
+			timeLeft, err := lookupPreset(name)
+			if err != nil {
+				return nil, err
+			}
+			if err := setTimeConfirmed(timeLeft, force, origin); err != nil {
+				return nil, err
+			}
+			success.Printf("Time left set to: %s\n", timeLeft)
+		case "pause":
+			questionMutex.RLock()
+			paused := question.Paused
+			questionMutex.RUnlock()
+			if paused {
+				return runCommand([]string{"resume"}, origin)
 			}
+			return runCommand(append([]string{"pause"}, args[2:]...), origin)
+		case "countUp":
+			questionMutex.Lock()
+			startCountUp(&question)
+			question.Reading = false
+			stateChanged("time")
+			questionMutex.Unlock()
+			success.Println("Counting up")
+		default:
+			timeLeft, err := parseDurationArg(args[1])
+			if err != nil {
+				return nil, err
+			}
+			if err := setTimeConfirmed(timeLeft, force, origin); err != nil {
+				return nil, err
+			}
+			rememberTime(timeLeft)
+			success.Printf("Time left set to: %s\n", timeLeft)
+		}
+
+		// The Flask server works the time out from the new clock.
+		go sendCurrentQuestion()
+	case "pause":
+		reason, message := parsePauseArgs(args[1:])
+		if err := pauseQuestion(reason, message, origin); err != nil {
+			return nil, err
+		}
+		success.Println("Question paused")
+	case "resume":
+		if len(args) != 1 {
+			return nil, errors.New("Usage: resume")
+		}
+		if err := resumeQuestion(origin); err != nil {
+			return nil, err
+		}
+		success.Println("Question unpaused")
+	case "type":
+		if len(args) != 2 {
+			return nil, errors.New("Usage: type <pomoc/rozstrel/waiting/end>")
+		}
+		if !validQuestionType(args[1]) {
+			return nil, fmt.Errorf("Invalid type. Must be one of: %s", strings.Join(questionTypeNames(), ", "))
+		}
+		questionMutex.Lock()
+		recordOvertime(question, origin)
+		wasScreen := question.Type == "end" || question.Type == "waiting"
+		question.Type = args[1]
+		question.ExpiredFrom = ""
+		// Switching to a screen clears the timer and switching back
+		// restarts it; between question types it carries on.
+		switch {
+		case args[1] == "end" || args[1] == "waiting":
+			clearTimer(&question)
+		case wasScreen:
+			restartTimer(&question)
+		}
+		if question.Type == "end" {
+			question.Question = "END"
+		}
+		stateChanged("type")
+		questionMutex.Unlock()
+		if args[1] == "end" || args[1] == "waiting" {
+			finalizeQuestion("ended")
+		}
+		if args[1] == "end" {
+			webhookRoundPlayed(0)
+		}
+		success.Printf("Type set to: %s\n", args[1])
+	case "status":
+		questionMutex.RLock()
+		info.Println("Current question status:")
+		info.Printf("Question: %s\n", question.Question)
+		display := publicQuestion(question).TimeDisplay
+		if question.Reading {
+			info.Printf("Reading: %s left, then %s to answer\n", display.Text, question.TimeLeft)
+		} else if question.CountUp {
+			info.Printf("Elapsed time: %s\n", display.Text)
+		} else {
+			info.Printf("Time left: %s\n", display.Text)
+		}
+		info.Printf("Type: %s\n", question.Type)
+		if overtimeOf(question) > 0 {
+			errorC.Printf("Overtime: %s\n", display.Text)
 		}
+		if question.Notes != "" {
+			info.Printf("Notes: %s\n", question.Notes)
+		}
+		if lead := questionHostLead(question); lead > 0 {
+			if left := hostTimeLeft(publicQuestion(question), lead); left != nil {
+				info.Printf("Host clock: %s (%s ahead)\n", formatTimeDisplay(*left, false).Text, lead)
+			}
+		}
+		if n := len(question.HostScript); n > 0 {
+			info.Printf("Host script: %d line(s) (script shows them)\n", n)
+		}
+		if question.Paused {
+			info.Printf("Paused: %s %q\n", question.PauseReason, question.PauseMessage)
+		}
+		if question.Stalled {
+			errorC.Printf("Stalled: count-up frozen at %s, set a time or a new question to carry on\n", question.TimeLeft)
+		}
+		if liveLockOn() {
+			info.Printf("Lock while live: on (locked: %v)\n", questionRunning(question))
+		}
+		info.Printf("Logging: %v\n", loggingEnabled)
+		questionMutex.RUnlock()
+		if line := watchdogStatus(); line != "" {
+			info.Println(line)
+		}
+		if line := undoStatus(); line != "" {
+			info.Println(line)
+		}
+		info.Println(streamSummary(hub.streamStats()))
+		if recorder != nil {
+			info.Printf("Recording to: %s\n", recorder.path)
+		}
+		if r := currentReplay(); r != nil {
+			pos, total, paused := r.status()
+			info.Printf("Replaying %s: event %d/%d (paused: %v)\n", r.path, pos, total, paused)
+		}
+		if warnings, errs := unackedCounts(); warnings+errs > 0 {
+			errorC.Printf("Unacknowledged: %d errors, %d warnings (see 'notifications')\n", errs, warnings)
+		}
+	case "pauses":
+		for _, t := range pauseTotals() {
+			reason := t.Reason
+			if reason == "" {
+				reason = "(none)"
+			}
+			info.Printf("  %-20s %3d× %s\n", reason, t.Count, t.Duration.Round(time.Second))
+		}
+	case "score":
+		return nil, handleScoreCommand(args[1:], origin)
+	case "ceremony":
+		return nil, handleCeremonyCommand(args[1:], origin)
+	case "reveal":
+		return nil, handleRevealCommand(args[1:], origin)
+	case "backup":
+		return nil, handleBackupCommand(args[1:], origin)
+	case "restore":
+		return nil, handleRestoreCommand(args[1:], origin)
+	case "replay":
+		return nil, handleReplayCommand(args[1:], origin)
+	case "team":
+		return nil, handleTeamCommand(args[1:], origin)
+	case "var":
+		return nil, handleVarCommand(args[1:], origin)
+	case "vars":
+		printTemplateVars()
+	case "lock":
+		if len(args) == 2 {
+			return nil, handleLiveLockCommand(args[1], origin)
+		}
+		if err := lockCLI(); err != nil {
+			return nil, err
+		}
+		success.Println("CLI locked")
+	case "unlock":
+		if len(args) != 2 {
+			return nil, errors.New("Usage: unlock <pin>")
+		}
+		if err := unlockCLI(args[1]); err != nil {
+			return nil, err
+		}
+		success.Println("CLI unlocked")
+	case "next":
+		entry, err := handleNextCommand(args[1:], origin)
+		return entry, err
+	case "check", "uncheck":
+		return nil, handleCheckCommand(command, args[1:], origin)
+	case "queue":
+		return nil, handleQueueCommand(args[1:], origin)
+	case "paste":
+		return nil, handlePasteCommand(origin)
+	case "preset", "presets":
+		return nil, handlePresetCommand(args[1:], origin)
+	case "display", "displays":
+		return nil, handleDisplayCommand(args[1:], origin)
+	case "autowait":
+		return nil, handleAutoWaitCommand(args[1:], origin)
+	case "announce":
+		return nil, handleAnnounceCommand(args[1:], origin)
+	case "profile":
+		return nil, handleProfileCommand(args[1:], origin)
+	case "preview":
+		handlePreviewCommand()
+	case "rundown":
+		return nil, handleRundownCommand(args[1:], origin)
+	case "notify":
+		return nil, handleNotifyCommand(args[1:], origin)
+	case "notifications":
+		printNotifications()
+	case "ack":
+		return nil, handleAckCommand(args[1:], origin)
+	case "award":
+		return nil, handleAwardCommand(args[1:], origin)
+	case "review":
+		return nil, handleReviewCommand(args[1:], origin)
+	case "eliminate":
+		t, err := handleEliminateCommand(args[1:], origin)
+		return t, err
+	case "options":
+		return nil, handleOptionsCommand(args[1:], origin)
+	case "lockin":
+		return nil, handleLockinCommand(args[1:], origin)
+	case "buzz":
+		return nil, handleBuzzCommand(args[1:], origin)
+	case "close", "open":
+		return nil, handleFloorCommand(command, args[1:], origin)
+	case "adjust":
+		left, err := handleAdjustCommand(args[1:], origin)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]float64{"time_left": left.Seconds()}, nil
+	case "build":
+		return nil, handleBuildCommand(origin)
+	case "who":
+		handleWhoCommand()
+	case "hostlead":
+		return nil, handleHostLeadCommand(args[1:], origin)
+	case "undo", "redo":
+		return nil, handleUndoCommand(command, origin)
+	case "report":
+		return nil, handleReportCommand(args[1:], origin)
+	case "hotkeys":
+		return nil, handleHotkeysCommand(args[1:], origin)
+	case "history":
+		return nil, handleHistoryCommand(args[1:], origin)
+	case "dispute", "disputes":
+		return nil, handleDisputeCommand(args[1:], origin)
+	case "qr":
+		return nil, handleQRCommand(args[1:], origin)
+	case "hist":
+		return nil, handleHistCommand(args[1:], origin)
+	case "timeline":
+		return nil, handleTimelineCommand(args[1:], origin)
+	case "page":
+		return nil, handlePageCommand(args[1:], origin)
+	case "session":
+		return nil, handleSessionCommand(args[1:], origin)
+	case "go":
+		return nil, handleGoCommand(args[1:], origin)
+	case "fileexport":
+		return nil, handleFileExportCommand(args[1:], origin)
+	case "doctor":
+		return nil, handleDoctorCommand()
+	case "stats":
+		return nil, handleStatsCommand(args[1:], origin)
+	case "judges":
+		return nil, handleJudgesCommand(args[1:], origin)
+	case "bank":
+		return nil, handleBankCommand(args[1:], origin)
+	case "find":
+		return nil, handleFindCommand(args[1:], origin)
+	case "stage", "pick":
+		return nil, handleStageCommand(command, args[1:], origin)
+	case "target", "targets":
+		return nil, handleTargetCommand(args[1:], origin)
+	case "failover":
+		return nil, handleFailoverCommand(args[1:], origin)
+	case "sync":
+		return nil, handleSyncCommand(args[1:], origin)
+	case "debug":
+		printDebugState()
+	case "script":
+		printHostScript()
+	case "help":
+		printHelp()
+	default:
+		if locale := strings.TrimPrefix(command, "question."); locale != command {
+			return nil, handleVariantCommand(locale, args[1:], origin)
+		}
+		return nil, withHint(fmt.Errorf("Unknown command: %s", command), "Type 'help' for available commands")
 	}
+	return nil, nil
 }
 
-func waitForShutdown(e *echo.Echo) {
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	fmt.Println("Shutting down server...")
+func handleReplayCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	if len(args) == 0 {
+		return errors.New("Usage: replay <file> [--speed N] [--live] | replay pause | replay stop")
+	}
+	switch args[0] {
+	case "pause", "stop":
+		r := currentReplay()
+		if r == nil {
+			return errors.New("No replay is running")
+		}
+		cmd := args[0]
+		if cmd == "pause" {
+			if _, _, paused := r.status(); paused {
+				cmd = "resume"
+			}
+		}
+		if !r.control(cmd) {
+			return errors.New("Replay already finished")
+		}
+		switch cmd {
+		case "pause":
+			success.Println("Replay paused")
+		case "resume":
+			success.Println("Replay resumed")
+		default:
+			success.Println("Replay stopped")
+		}
+		return nil
+	}
+
+	path := args[0]
+	speed := 1.0
+	live := false
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "--live":
+			live = true
+		case "--speed":
+			if i+1 >= len(args) {
+				return errors.New("--speed needs a value")
+			}
+			i++
+			s, err := strconv.ParseFloat(args[i], 64)
+			if err != nil || s <= 0 {
+				return errors.New("Speed must be a positive number")
+			}
+			speed = s
+		default:
+			return fmt.Errorf("Unknown replay option: %s", args[i])
+		}
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := e.Shutdown(ctx); err != nil {
-		e.Logger.Fatal("Server Shutdown Failed:", err)
+	r, err := startReplay(path, speed, live)
+	if err != nil {
+		return fmt.Errorf("Error starting replay: %v", err)
 	}
+	success.Printf("Replaying %d events from %s at %gx speed (live: %v)\n", len(r.events), path, speed, live)
+	return nil
 }
 
 func printHelp() {
 	help := color.New(color.FgCyan)
 	help.Println("Available commands:")
-	help.Println("  question <text>          - Set new question")
-	help.Println("  time <seconds|last|pause|countUp> - Set time left or control timer")
-	help.Println("  type <type>              - Set type (pomoc/rozstrel/waiting/end)")
+	help.Println("  question [--override] <text> [| <option> ...] - Set new question (--override replaces a locked running one)")
+	help.Println("                           options are a text, img:<file> or both, * marks the correct one")
+	help.Println("  question.<locale> <text> - Set a translated variant (empty text removes it)")
+	help.Println("  time <seconds|last|pause|countUp> [--force] - Set time left (90 or 1m30s) or control timer")
+	help.Println("  time pause [reason] [\"message\"] - Pause with an on-screen message, or resume")
+	help.Println("  pause [reason] [\"message\"] | resume - Pause or resume the question")
+	help.Println("  pauses                   - Show total paused time per reason")
+	help.Println("  type <type>              - Set type (pomoc/rozstrel/waiting/end, or the profile's types)")
 	help.Println("  status                   - Show current question status")
 	help.Println("  logging <on/off>         - Enable/disable request logging")
+	help.Println("  team add <name> [#color] [short] - Register a team")
+	help.Println("  team rm <name> | team list - Remove or list teams")
+	help.Println("  team pin <name> <pin>|off - Set, rotate or remove a team's captain PIN")
+	help.Println("  score <team> <+n|-n> [reason] - Adjust a team's score, with the reason for the ledger")
+	help.Println("  score log                - List this game's manual score changes")
+	help.Println("  score revert <id> [reason] - Undo a manual change with a compensating one")
+	help.Println("  timeline [team]          - Show score sparklines (and one team's changes)")
+	help.Println("  ceremony [start|exit]    - Show standings, start or leave the results ceremony")
+	help.Println("  reveal next              - Reveal the next placement in the ceremony")
+	help.Println("  backup <path>            - Write the show configuration to a tar.gz")
+	help.Println("  restore <path> [--force] - Restore a backup archive")
+	help.Println("  replay <file> [--speed N] [--live] - Replay a session recording")
+	help.Println("  replay pause|stop        - Pause/resume or stop the running replay")
+	help.Println("  var <name> \"value\"       - Set a question text variable, used as {{name}}")
+	help.Println("  vars                     - List question text variables")
+	help.Println("  lock | unlock <pin>      - Lock or unlock destructive commands")
+	help.Println("  lock on|off              - Refuse to replace a running question without --override")
+	help.Println("  next [--force]           - Put the next queued question on air (--force skips its checklist)")
+	help.Println("  check [item]             - Show the staged question's checklist or tick an item off")
+	help.Println("  uncheck <item>           - Untick a checklist item")
+	help.Println("  close [--stop]           - Stop accepting answers (--stop also pauses the countdown)")
+	help.Println("  open                     - Accept answers again")
+	help.Println("  adjust <+/-seconds> [--force] - Add or take time from the running countdown")
+	help.Println("  hostlead [seconds]       - Show or set how far the host's private countdown runs ahead")
+	help.Println("  who                      - List the operators active on the show")
+	help.Println("  undo / redo              - Reverse the last score, pause, time adjust or question, or apply it again")
+	help.Println("  report [send|render dir] - Show the last post-show report, save and mail one now, or render it to dir")
+	help.Println("  hotkeys <on|off>         - Single-key control: space pause, n next, r reveal, +/- 5s, b buzz reset")
+	help.Println("  history [id]             - List asked questions or show one's final record")
+	help.Println("  history <id> fairness    - Compare the teams' effective answer windows on a question")
+	help.Println("  hist [search <term>|stats|run <n>] - Show, search, count or replay past commands")
+	help.Println("  qr [url]                 - Show the audience join QR code, or one for url")
+	help.Println("  dispute <team> <id> [reason] - Freeze the evidence on history entry id and print it")
+	help.Println("  dispute [show <id>|resolve <id> [+/-points] <ruling>] - List, show or rule on disputes")
+	help.Println("  go answers               - End the reading time and start the answer countdown")
+	help.Println("  page                     - Show the next page of a long question")
+	help.Println("  fileexport [on <dir>|off] - Keep text files for OBS up to date in dir")
+	help.Println("  doctor                   - Check the port, push targets, clocks and files")
+	help.Println("  stats [reset]            - Show (or restart) session statistics")
+	help.Println("  session [start <name>|end|resume|discard] - List, start or archive named game sessions")
+	help.Println("  judges [reset]           - Show (or restore) judge extensions left")
+	help.Println("  bank [list|show <id>|rm <id>] - Browse or prune the question bank")
+	help.Println("  bank dedupe [threshold]  - List exact and near-duplicate bank questions")
+	help.Println("  bank queue <id>          - Queue a bank question, so its stats are kept")
+	help.Println("  bank calibrate [--apply] [--min n] - Suggest difficulties from archived accuracy")
+	help.Println("  find <words> [--tag t]   - Search the bank as you type, results numbered #1, #2, ...")
+	help.Println("  stage #n / pick #n       - Queue a find result at the end, or as the next question")
+	help.Println("  queue [add <round> <seconds> <text>|rm <id>] - Show or edit the question queue")
+	help.Println("  queue time <±seconds> [--min <seconds>] - Shift the time of every question still queued")
+	help.Println("  queue move <id> <position> - Move a queued question, counting from 1")
+	help.Println("  paste                    - Queue a question pasted as text, ended by a line with \".\"")
+	help.Println("  build                    - Build the queue from the bank by round counts, tag quotas and difficulty")
+	help.Println("  time preset <name>       - Set time left from a saved preset")
+	help.Println("  preset save <name> <time> | rm <name> | list - Manage time presets")
+	help.Println("  display [critical|normal <id>] - List displays or mark one as critical")
+	help.Println("  autowait <seconds> [\"message\"] - Show the waiting screen after each question (0 = off)")
+	help.Println("  announce [at <list>|lang <code>|off] - Screen reader time-left announcements")
+	help.Println("  profile [load <name>|save <name>] - Show, switch or save the show profile")
+	help.Println("  preview                  - Show the next queued question with its notes")
+	help.Println("  rundown [mark <n>]       - Show schedule drift or mark a checkpoint reached")
+	help.Println("  notifications            - List operator notifications, * marks unacknowledged")
+	help.Println("  notify test              - Post a test message to the -webhook chat")
+	help.Println("  ack <id|all>             - Acknowledge a notification")
+	help.Println("  eliminate <team>         - Knock a team out of the current question")
+	help.Println("  options [shuffle|5050]   - Type the options of the current question, shuffle them or play the 50:50")
+	help.Println("  lockin [undo <team>]     - Show which teams locked in, or let one change its answer again")
+	help.Println("  award [teams|--correct]  - Show what each answer earns, or award the correct teams")
+	help.Println("  review [team verdict]    - Walk through free-text answers that need review, or set one")
+	help.Println("  buzz [reset]             - Show the buzz winner or reopen the buzzer")
+	help.Println("  debug                    - Show timers, revision and recent internal events")
+	help.Println("  script                   - Show the host script of the live question")
+	help.Println("  target [list]            - Show push targets and their delivery state")
+	help.Println("  target add <name> <url>  - Push the question to another server as well")
+	help.Println("  target show <name>       - Show a target's payload fields and a sample payload")
+	help.Println("  target rm|enable|disable <name> - Remove or toggle a push target")
+	help.Println("  failover display [--no-push] - Show the built-in fallback display's URL (--no-push stops pushing to Flask)")
+	help.Println("  sync pull [--local|--remote] - Compare with the synced server and settle differences")
 	help.Println("  help                     - Show this help")
 	help.Println("  exit                     - Exit the program")
 }
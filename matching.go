@@ -0,0 +1,347 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Verdict statuses.
+const (
+	verdictCorrect     = "correct"
+	verdictIncorrect   = "incorrect"
+	verdictNeedsReview = "needs_review"
+)
+
+// MatchRules are the accepted answers of a free-text question and how
+// submissions are compared with them. A submission within MaxDistance edits
+// of an accepted answer, after normalization, is correct; one within
+// ReviewDistance is left to the operator. ReviewDistance defaults to one
+// more than MaxDistance.
+type MatchRules struct {
+	Accepted          []string `json:"accepted"`
+	IgnoreCase        bool     `json:"ignore_case"`
+	StripDiacritics   bool     `json:"strip_diacritics"`
+	IgnorePunctuation bool     `json:"ignore_punctuation"`
+	MaxDistance       int      `json:"max_distance"`
+	ReviewDistance    int      `json:"review_distance,omitempty"`
+}
+
+// Verdict is the classification of one team's answer. Auto is what the
+// rules decided; Status differs from it once the operator adjudicates.
+type Verdict struct {
+	Team      string     `json:"team"`
+	Answer    string     `json:"answer"`
+	Status    string     `json:"status"`
+	Auto      string     `json:"auto"`
+	Matched   string     `json:"matched,omitempty"`
+	Distance  int        `json:"distance"`
+	DecidedBy string     `json:"decided_by,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+}
+
+// adjudications holds the operator's decisions for the live question by
+// team. A decision only applies while the team's answer is the one it was
+// made for. It is guarded by roundMutex and cleared with the round.
+var adjudications = map[string]Verdict{}
+
+func validateMatching(m *MatchRules) error {
+	if m == nil {
+		return nil
+	}
+	if len(m.Accepted) == 0 {
+		return fmt.Errorf("matching.accepted must list at least one answer")
+	}
+	for _, a := range m.Accepted {
+		if m.normalize(a) == "" {
+			return fmt.Errorf("accepted answer %q is empty after normalization", a)
+		}
+	}
+	if m.MaxDistance < 0 || m.ReviewDistance < 0 {
+		return fmt.Errorf("matching distances must be non-negative")
+	}
+	if m.ReviewDistance != 0 && m.ReviewDistance < m.MaxDistance {
+		return fmt.Errorf("matching.review_distance must not be below max_distance")
+	}
+	return nil
+}
+
+// normalize applies the rules' options to s. Whitespace is always trimmed
+// and collapsed.
+func (m *MatchRules) normalize(s string) string {
+	if m.StripDiacritics {
+		var b strings.Builder
+		for _, r := range norm.NFD.String(s) {
+			if !unicode.Is(unicode.Mn, r) {
+				b.WriteRune(r)
+			}
+		}
+		s = norm.NFC.String(b.String())
+	}
+	if m.IgnoreCase {
+		s = strings.ToLower(s)
+	}
+	if m.IgnorePunctuation {
+		s = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return ' '
+		}, s)
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func (m *MatchRules) reviewDistance() int {
+	if m.ReviewDistance == 0 {
+		return m.MaxDistance + 1
+	}
+	return m.ReviewDistance
+}
+
+// levenshtein is the edit distance between a and b in runes.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// classify compares one answer with the closest accepted answer.
+func (m *MatchRules) classify(a Answer) Verdict {
+	v := Verdict{Team: a.Team, Answer: a.Answer, Status: verdictIncorrect}
+	given := m.normalize(a.Answer)
+	if given == "" {
+		v.Auto = v.Status
+		return v
+	}
+	v.Distance = -1
+	for _, accepted := range m.Accepted {
+		d := levenshtein(given, m.normalize(accepted))
+		if v.Distance < 0 || d < v.Distance {
+			v.Distance, v.Matched = d, accepted
+		}
+	}
+	switch {
+	case v.Distance <= m.MaxDistance:
+		v.Status = verdictCorrect
+	case v.Distance <= m.reviewDistance():
+		v.Status = verdictNeedsReview
+	}
+	v.Auto = v.Status
+	return v
+}
+
+// classifyAnswers classifies every answer under m, earliest first, applying
+// the decisions that still match their answers. It returns nil without rules.
+func classifyAnswers(m *MatchRules, answered []Answer, decided map[string]Verdict) []Verdict {
+	if m == nil {
+		return nil
+	}
+	sorted := append([]Answer(nil), answered...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+	out := make([]Verdict, 0, len(sorted))
+	for _, a := range sorted {
+		v := m.classify(a)
+		if d, ok := decided[a.Team]; ok && d.Answer == a.Answer {
+			v.Status, v.DecidedBy, v.DecidedAt = d.Status, d.DecidedBy, d.DecidedAt
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// answersFinal reports whether the answers to q can no longer change, which
+// is when they are revealed and classified: the floor has closed or the
+// question has ended.
+func answersFinal(q Question) bool {
+	roundMutex.Lock()
+	closed := floorClosed != nil
+	roundMutex.Unlock()
+	return closed || !questionInProgressAt(q, clock.Now())
+}
+
+// liveVerdicts classifies the live question's answers once they are final.
+// It must be called with questionMutex held.
+func liveVerdicts() []Verdict {
+	return verdictsFor(question)
+}
+
+// verdictsFor classifies the current answers under the rules of q, which is
+// the live question or a copy of it.
+func verdictsFor(q Question) []Verdict {
+	if q.Matching == nil || !answersFinal(q) {
+		return nil
+	}
+	roundMutex.Lock()
+	decided := make(map[string]Verdict, len(adjudications))
+	for k, v := range adjudications {
+		decided[k] = v
+	}
+	roundMutex.Unlock()
+	return classifyAnswers(q.Matching, listAnswers(), decided)
+}
+
+// adjudicate records the operator's decision on a team's answer.
+func adjudicate(name, status, origin string) (Verdict, error) {
+	if status != verdictCorrect && status != verdictIncorrect {
+		return Verdict{}, fmt.Errorf("verdict must be correct or incorrect")
+	}
+	questionMutex.RLock()
+	rules := question.Matching
+	final := rules != nil && answersFinal(question)
+	questionMutex.RUnlock()
+	if rules == nil {
+		return Verdict{}, &RoundError{Code: "no_matching", Message: "the question has no accepted answers"}
+	}
+	if !final {
+		return Verdict{}, &RoundError{Code: "not_revealed", Message: "answers are classified once the floor closes"}
+	}
+	t, ok := findTeam(name)
+	if !ok {
+		return Verdict{}, &RoundError{Code: "unknown_team", Message: "unknown team: " + name}
+	}
+	roundMutex.Lock()
+	a, ok := answers[t.Name]
+	if !ok {
+		roundMutex.Unlock()
+		return Verdict{}, &RoundError{Code: "no_answer", Message: t.Name + " has not answered"}
+	}
+	now := clock.Now()
+	adjudications[t.Name] = Verdict{Answer: a.Answer, Status: status, DecidedBy: origin, DecidedAt: &now}
+	roundMutex.Unlock()
+
+	v := rules.classify(a)
+	v.Status, v.DecidedBy, v.DecidedAt = status, origin, &now
+	audit("adjudicate", origin, map[string]interface{}{"team": t.Name, "answer": a.Answer, "auto": v.Auto, "verdict": status})
+	roundChanged("adjudicate")
+	return v, nil
+}
+
+// correctTeams names the teams judged correct, refusing while any answer
+// still needs review.
+func correctTeams() ([]string, error) {
+	questionMutex.RLock()
+	rules := question.Matching
+	final := rules != nil && answersFinal(question)
+	verdicts := liveVerdicts()
+	questionMutex.RUnlock()
+	switch {
+	case rules == nil:
+		return nil, &RoundError{Code: "no_matching", Message: "the question has no accepted answers"}
+	case !final:
+		return nil, &RoundError{Code: "not_revealed", Message: "answers are classified once the floor closes"}
+	}
+	var names []string
+	for _, v := range verdicts {
+		switch v.Status {
+		case verdictNeedsReview:
+			return nil, &RoundError{Code: "pending_review", Message: v.Team + "'s answer still needs review"}
+		case verdictCorrect:
+			names = append(names, v.Team)
+		}
+	}
+	return names, nil
+}
+
+// AdjudicateRequest is the body of POST /answers/:team/adjudicate.
+type AdjudicateRequest struct {
+	Verdict string `json:"verdict"`
+}
+
+func postAdjudicate(c echo.Context) error {
+	req := new(AdjudicateRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	v, err := adjudicate(c.Param("team"), req.Verdict, requestOrigin(c))
+	if err != nil {
+		return roundError(err)
+	}
+	return c.JSON(http.StatusOK, v)
+}
+
+// handleReviewCommand runs "review [<team> correct|incorrect]". Without
+// arguments it walks through the answers that need review.
+func handleReviewCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	errorC := color.New(color.FgRed)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 2 {
+		v, err := adjudicate(args[0], args[1], origin)
+		if err != nil {
+			return err
+		}
+		success.Printf("%s: %s\n", v.Team, v.Status)
+		return nil
+	}
+	if len(args) != 0 {
+		return errors.New("Usage: review [<team> correct|incorrect]")
+	}
+
+	questionMutex.RLock()
+	rules := question.Matching
+	final := rules != nil && answersFinal(question)
+	verdicts := liveVerdicts()
+	questionMutex.RUnlock()
+	switch {
+	case rules == nil:
+		return errors.New("The question has no accepted answers")
+	case !final:
+		return errors.New("Answers are classified once the floor closes")
+	}
+	pending := 0
+	for _, v := range verdicts {
+		if v.Status != verdictNeedsReview {
+			continue
+		}
+		pending++
+		info.Printf("%s answered %q (closest: %q, %d edit(s))\n", v.Team, v.Answer, v.Matched, v.Distance)
+		line, ok := promptLine("Correct, incorrect or skip? [c/i/S]")
+		if !ok {
+			return nil
+		}
+		status := ""
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "c", "correct":
+			status = verdictCorrect
+		case "i", "incorrect":
+			status = verdictIncorrect
+		default:
+			info.Println("  skipped")
+			continue
+		}
+		if _, err := adjudicate(v.Team, status, origin); err != nil {
+			errorC.Println(err)
+			continue
+		}
+		success.Printf("  %s: %s\n", v.Team, status)
+	}
+	if pending == 0 {
+		info.Println("No answers need review")
+	}
+	return nil
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	mediaDir          = flag.String("media-dir", "media", "directory uploaded question media is stored in")
+	mediaMaxSide      = flag.Int("media-max-side", 1920, "images wider or taller than this get a downscaled JPEG for the displays")
+	mediaMaxUpload    = flag.Int64("media-max-upload", 64<<20, "largest media upload accepted, in bytes")
+	probeMedia        = flag.Bool("probe-media", true, "check the type of external media URLs when questions are set (turn off at offline venues)")
+	mediaProbeTimeout = flag.Duration("media-probe-timeout", 2*time.Second, "how long probing an external media URL may take")
+)
+
+// mediaPrefix is where uploads are served.
+const mediaPrefix = "/media/"
+
+// renderableMedia are the content types every display can show. Audio and
+// video also need a media_fallback_text, for when playback fails.
+var renderableMedia = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+	"audio/mpeg": true,
+	"audio/ogg":  true,
+	"audio/wav":  true,
+	"video/mp4":  true,
+	"video/webm": true,
+}
+
+// mediaTypesByExt guesses types from extensions, for URLs that aren't
+// probed. It includes types the displays can't render, such as the HEIC of
+// phone photos, so those are refused too.
+var mediaTypesByExt = map[string]string{
+	".jpg": "image/jpeg", ".jpeg": "image/jpeg", ".png": "image/png", ".gif": "image/gif", ".webp": "image/webp",
+	".mp3": "audio/mpeg", ".ogg": "audio/ogg", ".oga": "audio/ogg", ".wav": "audio/wav",
+	".mp4": "video/mp4", ".m4v": "video/mp4", ".webm": "video/webm",
+	".heic": "image/heic", ".heif": "image/heif", ".tif": "image/tiff", ".tiff": "image/tiff", ".bmp": "image/bmp",
+	".avi": "video/x-msvideo", ".mov": "video/quicktime", ".wma": "audio/x-ms-wma", ".flac": "audio/flac",
+}
+
+var extByMediaType = map[string]string{
+	"image/jpeg": ".jpg", "image/png": ".png", "image/gif": ".gif", "image/webp": ".webp",
+	"audio/mpeg": ".mp3", "audio/ogg": ".ogg", "audio/wav": ".wav", "video/mp4": ".mp4", "video/webm": ".webm",
+}
+
+// MediaFile is an uploaded asset. DisplayURL is set when the original was
+// too large and a downscaled JPEG was made for the displays.
+type MediaFile struct {
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	Type       string `json:"type"`
+	Size       int64  `json:"size"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	DisplayURL string `json:"display_url,omitempty"`
+	// File is the name the upload was last sent as, which "img:" tokens
+	// use, see options.go.
+	File string `json:"file,omitempty"`
+}
+
+// mediaNamesFile in -media-dir keeps the names uploads were sent as, as a
+// map to the stored names, so they still resolve after a restart.
+const mediaNamesFile = "names.json"
+
+var (
+	mediaMutex sync.RWMutex
+	mediaFiles = map[string]MediaFile{}
+	mediaNames = map[string]string{}
+)
+
+// MediaTypeError is media the displays can't render.
+type MediaTypeError struct {
+	Type string
+}
+
+func (e *MediaTypeError) Error() string {
+	if e.Type == "" {
+		return "the media type could not be recognized, upload JPEG, PNG, GIF, WebP, MP3, OGG, WAV, MP4 or WebM"
+	}
+	return fmt.Sprintf("%s can't be shown on the displays, convert it to JPEG, PNG, GIF, WebP, MP3, OGG, WAV, MP4 or WebM", e.Type)
+}
+
+// sniffMediaType finds the type from the first bytes of a file. HEIC and
+// HEIF, which the standard sniffer doesn't know, are named so the error can
+// say what the file is.
+func sniffMediaType(head []byte) string {
+	if len(head) >= 12 && string(head[4:8]) == "ftyp" {
+		switch brand := string(head[8:12]); brand {
+		case "heic", "heix", "hevc", "heim", "heis":
+			return "image/heic"
+		case "mif1", "msf1":
+			return "image/heif"
+		}
+	}
+	t := http.DetectContentType(head)
+	if i := strings.IndexByte(t, ';'); i >= 0 {
+		t = t[:i]
+	}
+	switch t {
+	case "audio/wave", "audio/x-wav":
+		return "audio/wav"
+	case "application/ogg":
+		return "audio/ogg"
+	case "application/octet-stream":
+		return ""
+	}
+	return t
+}
+
+// mediaKind is the type of the media at raw as far as it is known without
+// fetching it: an upload's sniffed type, or a guess from the extension.
+func mediaKind(raw string) string {
+	if f, ok := uploadedMedia(raw); ok {
+		return f.Type
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return mediaTypesByExt[strings.ToLower(path.Ext(u.Path))]
+}
+
+func isPlayback(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/")
+}
+
+// checkMediaType refuses types the displays can't render and playable
+// media without fallback text.
+func checkMediaType(mediaType, fallback string) error {
+	if mediaType == "" {
+		return nil
+	}
+	if !renderableMedia[mediaType] {
+		return &MediaTypeError{Type: mediaType}
+	}
+	if isPlayback(mediaType) && strings.TrimSpace(fallback) == "" {
+		return fmt.Errorf("media_fallback_text is required for %s media, so the display has something to show if playback fails", mediaType)
+	}
+	return nil
+}
+
+// validateMedia checks the media of q without any network access.
+func validateMedia(q Question) error {
+	if q.MediaURL == "" {
+		return nil
+	}
+	return checkMediaType(mediaKind(q.MediaURL), q.MediaFallbackText)
+}
+
+// probeQuestionMedia fetches the type of an external media URL and checks
+// it like an upload. A URL that can't be reached in time is let through
+// with a warning, as the displays may still reach it.
+func probeQuestionMedia(raw, fallback string) error {
+	if !*probeMedia || raw == "" || !strings.HasPrefix(raw, "http") {
+		return nil
+	}
+	if _, ok := uploadedMedia(raw); ok {
+		return nil
+	}
+	mediaType, err := probeMediaURL(raw)
+	if err != nil {
+		if _, ok := err.(*MediaStatusError); ok {
+			return err
+		}
+		notify(SeverityWarning, "media", "Media %s could not be checked: %v", raw, err)
+		return nil
+	}
+	return checkMediaType(mediaType, fallback)
+}
+
+// MediaStatusError is a probed URL that answered with an error.
+type MediaStatusError struct {
+	URL    string
+	Status int
+}
+
+func (e *MediaStatusError) Error() string {
+	return fmt.Sprintf("media_url %s answered %d %s", e.URL, e.Status, http.StatusText(e.Status))
+}
+
+// probeMediaURL reads the first bytes of raw and sniffs them, falling back
+// to the Content-Type header.
+func probeMediaURL(raw string) (string, error) {
+	client := &http.Client{Timeout: *mediaProbeTimeout}
+	req, err := http.NewRequest(http.MethodGet, raw, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Range", "bytes=0-511")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", &MediaStatusError{URL: raw, Status: resp.StatusCode}
+	}
+	head, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if t := sniffMediaType(head); t != "" && t != "text/plain" {
+		return t, nil
+	}
+	t := resp.Header.Get("Content-Type")
+	if i := strings.IndexByte(t, ';'); i >= 0 {
+		t = t[:i]
+	}
+	return strings.TrimSpace(t), nil
+}
+
+// uploadedMedia finds the upload a media URL points at.
+func uploadedMedia(raw string) (MediaFile, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || !strings.HasPrefix(u.Path, mediaPrefix) {
+		return MediaFile{}, false
+	}
+	mediaMutex.RLock()
+	defer mediaMutex.RUnlock()
+	f, ok := mediaFiles[strings.TrimPrefix(u.Path, mediaPrefix)]
+	return f, ok
+}
+
+// resolveMediaName finds the URL of the upload sent as name, or stored as
+// name, ignoring case if nothing matches exactly.
+func resolveMediaName(name string) (string, error) {
+	mediaMutex.RLock()
+	defer mediaMutex.RUnlock()
+	if _, ok := mediaFiles[name]; ok {
+		return mediaPrefix + name, nil
+	}
+	if stored, ok := mediaNames[name]; ok {
+		return mediaPrefix + stored, nil
+	}
+	for file, stored := range mediaNames {
+		if strings.EqualFold(file, name) {
+			return mediaPrefix + stored, nil
+		}
+	}
+	return "", fmt.Errorf("no uploaded media named %q, upload it to /media first", name)
+}
+
+// nameMedia records that f was sent as file and saves the names.
+func nameMedia(f *MediaFile, file string) error {
+	file = filepath.Base(file)
+	if file == "." || file == string(filepath.Separator) {
+		return nil
+	}
+	f.File = file
+	mediaMutex.Lock()
+	defer mediaMutex.Unlock()
+	mediaNames[file] = f.Name
+	mediaFiles[f.Name] = *f
+	data, err := json.MarshalIndent(mediaNames, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(*mediaDir, mediaNamesFile), data)
+}
+
+// mediaDisplayURL is the URL displays should load for media_url: the
+// downscaled rendition if there is one.
+func mediaDisplayURL(raw string) string {
+	if f, ok := uploadedMedia(raw); ok && f.DisplayURL != "" {
+		return f.DisplayURL
+	}
+	return ""
+}
+
+// displayName is the file of the downscaled rendition of name.
+func displayName(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name)) + ".display.jpg"
+}
+
+// storeMedia saves an upload under its content hash, so uploading a file
+// twice keeps one copy, and makes a rendition if it is a large image.
+func storeMedia(data []byte) (MediaFile, error) {
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	mediaType := sniffMediaType(head)
+	if !renderableMedia[mediaType] {
+		return MediaFile{}, &MediaTypeError{Type: mediaType}
+	}
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:8]) + extByMediaType[mediaType]
+	f := MediaFile{Name: name, URL: mediaPrefix + name, Type: mediaType, Size: int64(len(data))}
+
+	if err := os.MkdirAll(*mediaDir, 0o755); err != nil {
+		return MediaFile{}, err
+	}
+	if err := writeFileAtomic(filepath.Join(*mediaDir, name), data); err != nil {
+		return MediaFile{}, err
+	}
+	if err := addRendition(&f, data); err != nil {
+		return MediaFile{}, err
+	}
+	mediaMutex.Lock()
+	mediaFiles[name] = f
+	mediaMutex.Unlock()
+	return f, nil
+}
+
+// addRendition records the size of an image and, when it is larger than
+// -media-max-side, writes a downscaled JPEG next to it. WebP can't be
+// decoded here, so WebP images are used as they are.
+func addRendition(f *MediaFile, data []byte) error {
+	if !strings.HasPrefix(f.Type, "image/") || f.Type == "image/webp" {
+		return nil
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("the image could not be read: %v", err)
+	}
+	f.Width, f.Height = cfg.Width, cfg.Height
+	limit := *mediaMaxSide
+	if limit <= 0 || (cfg.Width <= limit && cfg.Height <= limit) {
+		return nil
+	}
+	// An animated GIF would lose its animation, so it stays as it is.
+	if f.Type == "image/gif" {
+		return nil
+	}
+	display := filepath.Join(*mediaDir, displayName(f.Name))
+	if _, err := os.Stat(display); err != nil {
+		src, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("the image could not be read: %v", err)
+		}
+		var out bytes.Buffer
+		if err := jpeg.Encode(&out, downscale(src, limit), &jpeg.Options{Quality: 85}); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(display, out.Bytes()); err != nil {
+			return err
+		}
+	}
+	f.DisplayURL = mediaPrefix + displayName(f.Name)
+	return nil
+}
+
+// downscale shrinks src to fit in a limit×limit box, averaging the source
+// pixels each target pixel covers.
+func downscale(src image.Image, limit int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w >= h {
+		w, h = limit, max(h*limit/w, 1)
+	} else {
+		w, h = max(w*limit/h, 1), limit
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+max((y+1)*b.Dy()/h, y*b.Dy()/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+max((x+1)*b.Dx()/w, x*b.Dx()/w+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// loadMedia indexes the uploads already in -media-dir.
+func loadMedia() error {
+	entries, err := os.ReadDir(*mediaDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || name == mediaNamesFile || strings.HasSuffix(name, ".display.jpg") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(*mediaDir, name))
+		if err != nil {
+			return err
+		}
+		f := MediaFile{Name: name, URL: mediaPrefix + name, Size: int64(len(data))}
+		head := data
+		if len(head) > 512 {
+			head = head[:512]
+		}
+		if f.Type = sniffMediaType(head); !renderableMedia[f.Type] {
+			continue
+		}
+		if err := addRendition(&f, data); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		mediaMutex.Lock()
+		mediaFiles[name] = f
+		mediaMutex.Unlock()
+	}
+	return loadMediaNames()
+}
+
+// loadMediaNames reads the names uploads were sent as.
+func loadMediaNames() error {
+	data, err := os.ReadFile(filepath.Join(*mediaDir, mediaNamesFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	names := map[string]string{}
+	if err := json.Unmarshal(data, &names); err != nil {
+		return fmt.Errorf("%s: %v", mediaNamesFile, err)
+	}
+	mediaMutex.Lock()
+	defer mediaMutex.Unlock()
+	for file, stored := range names {
+		f, ok := mediaFiles[stored]
+		if !ok {
+			continue
+		}
+		mediaNames[file] = stored
+		f.File = file
+		mediaFiles[stored] = f
+	}
+	return nil
+}
+
+func mediaError(err error) error {
+	if te, ok := err.(*MediaTypeError); ok {
+		return apiError(http.StatusUnsupportedMediaType, "unsupported_media", te.Error()).withDetail("type", te.Type)
+	}
+	return badRequest(err.Error())
+}
+
+// postMedia stores the upload in the "file" form field.
+func postMedia(c echo.Context) error {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return badRequest("the upload needs a file field")
+	}
+	if fh.Size > *mediaMaxUpload {
+		return apiError(http.StatusRequestEntityTooLarge, "too_large", fmt.Sprintf("uploads are limited to %d bytes", *mediaMaxUpload))
+	}
+	src, err := fh.Open()
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	defer src.Close()
+	data, err := io.ReadAll(io.LimitReader(src, *mediaMaxUpload+1))
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	f, err := storeMedia(data)
+	if err != nil {
+		return mediaError(err)
+	}
+	if err := nameMedia(&f, fh.Filename); err != nil {
+		return err
+	}
+	audit("media_upload", requestOrigin(c), map[string]interface{}{"name": f.Name, "file": fh.Filename, "type": f.Type, "size": f.Size})
+	return c.JSON(http.StatusCreated, f)
+}
+
+func getMedia(c echo.Context) error {
+	mediaMutex.RLock()
+	out := make([]MediaFile, 0, len(mediaFiles))
+	for _, f := range mediaFiles {
+		out = append(out, f)
+	}
+	mediaMutex.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return c.JSON(http.StatusOK, out)
+}
+
+// getMediaFile serves an upload or its rendition to the displays.
+func getMediaFile(c echo.Context) error {
+	name := c.Param("name")
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return notFound("no such media")
+	}
+	p := filepath.Join(*mediaDir, name)
+	if _, err := os.Stat(p); err != nil {
+		return notFound("no such media")
+	}
+	return c.File(p)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSniffMediaType(t *testing.T) {
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 1, 1)))
+	for _, tc := range []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"png", img.Bytes(), "image/png"},
+		{"heic", []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), "image/heic"},
+		{"heif", []byte("\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00"), "image/heif"},
+		{"wav", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), "audio/wav"},
+		{"ogg", []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00"), "audio/ogg"},
+		{"mp3", []byte("ID3\x04\x00\x00\x00\x00\x00\x00"), "audio/mpeg"},
+		{"binary", []byte{0, 1, 2, 3, 4, 5}, ""},
+	} {
+		if got := sniffMediaType(tc.head); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestMediaUploads uploads a large picture and a phone photo and plays a
+// question with the picture.
+func TestMediaUploads(t *testing.T) {
+	s := StartTestServer(t)
+	var big bytes.Buffer
+	if err := png.Encode(&big, image.NewGray(image.Rect(0, 0, 3000, 1000))); err != nil {
+		t.Fatal(err)
+	}
+	var f MediaFile
+	if status := uploadMedia(t, s, "panorama.png", big.Bytes(), &f); status != http.StatusCreated {
+		t.Fatalf("upload: status %d", status)
+	}
+	if f.Type != "image/png" || f.Width != 3000 || f.Height != 1000 || f.DisplayURL == "" || !strings.HasPrefix(f.URL, mediaPrefix) {
+		t.Fatalf("upload %+v", f)
+	}
+	resp, err := http.Get(s.URL + f.DisplayURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendition, err := jpeg.DecodeConfig(resp.Body)
+	resp.Body.Close()
+	if err != nil || rendition.Width != *mediaMaxSide || rendition.Height != 640 {
+		t.Errorf("rendition %+v, %v", rendition, err)
+	}
+	var listed []MediaFile
+	s.Do(t, http.MethodGet, "/media", nil, &listed)
+	if len(listed) == 0 {
+		t.Error("GET /media lists nothing")
+	}
+
+	// The same bytes are stored once, and a small picture needs no rendition.
+	var again MediaFile
+	uploadMedia(t, s, "panorama-copy.png", big.Bytes(), &again)
+	if again.Name != f.Name {
+		t.Errorf("the same upload stored as %s and %s", f.Name, again.Name)
+	}
+	if small := uploadPicture(t, s, "small.png"); small.DisplayURL != "" {
+		t.Errorf("a small picture got %s", small.DisplayURL)
+	}
+
+	var refused struct {
+		Error string `json:"error"`
+	}
+	heic := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00rest of the photo")
+	if status := uploadMedia(t, s, "IMG_0001.HEIC", heic, &refused); status != http.StatusUnsupportedMediaType || !strings.Contains(refused.Error, "image/heic") {
+		t.Errorf("a HEIC upload: status %d, %+v", status, refused)
+	}
+
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Kde to je?", "type": "pomoc", "time_left": 30_000_000_000, "media_url": f.URL})
+	var q PublicQuestionView
+	s.Do(t, http.MethodGet, "/get-question", nil, &q)
+	if q.MediaURL != f.URL || q.MediaDisplayURL != f.DisplayURL {
+		t.Errorf("question media %s, display %s", q.MediaURL, q.MediaDisplayURL)
+	}
+}
+
+// TestQuestionMediaChecks sets questions with external media: probed for
+// their type, refused on an error status or without fallback text for
+// sound, and let through with a warning when the server can't be reached.
+func TestQuestionMediaChecks(t *testing.T) {
+	s := StartTestServer(t)
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 2, 2)))
+	media := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/obrazok":
+			w.Write(img.Bytes())
+		case "/zvuk":
+			w.Write([]byte("ID3\x04\x00\x00\x00\x00\x00\x00 the song"))
+		case "/stranka":
+			w.Write([]byte("<!DOCTYPE html><html></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer media.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := "http://" + closed.Addr().String() + "/obrazok.png"
+	closed.Close()
+
+	set := func(path, mediaURL, fallback string) (int, APIError) {
+		t.Helper()
+		var res struct {
+			Error APIError `json:"error"`
+		}
+		status := s.Do(t, http.MethodPost, path, map[string]interface{}{
+			"question": "Čo to je?", "type": "pomoc", "time_left": 30, "media_url": mediaURL, "media_fallback_text": fallback,
+		}, &res)
+		return status, res.Error
+	}
+	for _, tc := range []struct {
+		url, fallback string
+		status        int
+		code          string
+	}{
+		{media.URL + "/obrazok", "", http.StatusOK, ""},
+		{media.URL + "/zvuk", "", http.StatusBadRequest, "bad_request"},
+		{media.URL + "/zvuk", "Pieseň", http.StatusOK, ""},
+		{media.URL + "/stranka", "", http.StatusUnsupportedMediaType, "unsupported_media"},
+		{media.URL + "/chyba.png", "", http.StatusBadRequest, "bad_request"},
+	} {
+		if status, e := set("/v2/set-question", tc.url, tc.fallback); status != tc.status || e.Code != tc.code {
+			t.Errorf("%s: status %d, %+v", tc.url, status, e)
+		}
+	}
+
+	notified := len(listNotifications())
+	if status, e := set("/v2/set-question", unreachable, ""); status != http.StatusOK {
+		t.Errorf("an unreachable URL: status %d, %+v", status, e)
+	}
+	if n := listNotifications(); len(n) != notified+1 || n[notified].Category != "media" {
+		t.Errorf("notifications %+v", n[notified:])
+	}
+
+	// Without probing only the extension is checked.
+	defer func(p bool) { *probeMedia = p }(*probeMedia)
+	*probeMedia = false
+	if status, _ := set("/v2/set-question", media.URL+"/chyba.png", ""); status != http.StatusOK {
+		t.Errorf("an unprobed URL: status %d", status)
+	}
+	if status, e := set("/v2/set-question", unreachable+".heic", ""); status == http.StatusOK || !strings.Contains(e.Message, "image/heic") {
+		t.Errorf("a HEIC URL: status %d, %+v", status, e)
+	}
+	if status, e := set("/v2/queue", media.URL+"/film.mov", ""); status == http.StatusOK || !strings.Contains(e.Message, "video/quicktime") {
+		t.Errorf("queueing a MOV: status %d, %+v", status, e)
+	}
+}
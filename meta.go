@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"sync"
+)
+
+var sessionName = flag.String("session-name", "", "name of the show, sent to overlays in the question metadata")
+
+// QuestionMeta says where the live question sits in the show, for "question
+// N of M" overlays. Ordinal counts the session's questions; the round
+// fields come from the queue and stay as they were for a question set
+// directly. A bank question asked before keeps its first ordinal and is
+// marked Repeat; one set directly is new every time.
+type QuestionMeta struct {
+	Session      string `json:"session,omitempty"`
+	Ordinal      int    `json:"ordinal"`
+	Round        int    `json:"round,omitempty"`
+	RoundName    string `json:"round_name,omitempty"`
+	RoundOrdinal int    `json:"round_ordinal,omitempty"`
+	RoundTotal   int    `json:"round_total,omitempty"`
+	// GroupPart and GroupSize give "part 2 of 2" for a question of a
+	// group, see groups.go.
+	GroupID   string `json:"group_id,omitempty"`
+	GroupPart int    `json:"group_part,omitempty"`
+	GroupSize int    `json:"group_size,omitempty"`
+	Repeat    bool   `json:"repeat,omitempty"`
+}
+
+// metaLast is the metadata of the last question asked, metaOrdinal the
+// last ordinal given out and metaAsked the ordinal of each bank entry asked
+// so far, by ID. The ID rather than the text, so two bank questions that
+// read the same are counted apart and an edited one is still a repeat.
+var (
+	metaMutex   sync.Mutex
+	metaLast    *QuestionMeta
+	metaOrdinal int
+	metaAsked   = map[int]int{}
+)
+
+// queueMeta is the round part of the metadata for a queue entry about to
+// go live.
+func queueMeta(entry QueueEntry) *QuestionMeta {
+	queueMutex.RLock()
+	defer queueMutex.RUnlock()
+	m := &QuestionMeta{Round: entry.Round, RoundName: entry.RoundName}
+	for i, e := range queue {
+		if e.ID == entry.ID && e.GroupID != "" {
+			m.GroupID = e.GroupID
+			m.GroupPart, m.GroupSize = groupPart(queue, i)
+		}
+		if e.Round != entry.Round {
+			continue
+		}
+		m.RoundTotal++
+		if e.Asked {
+			m.RoundOrdinal++
+		}
+		if m.RoundName == "" {
+			m.RoundName = e.RoundName
+		}
+	}
+	m.RoundOrdinal++
+	return m
+}
+
+// promoteMeta fills in the metadata of q, which replaces prev on air. It
+// must be called with questionMutex held, once per question going live.
+func promoteMeta(q *Question, prev Question) {
+	if q.Type == "end" || q.Type == "waiting" {
+		q.Meta = nil
+		return
+	}
+	metaMutex.Lock()
+	defer metaMutex.Unlock()
+	// A question restored from a backup carries the count on.
+	if prev.Meta != nil && prev.Meta.Ordinal > metaOrdinal {
+		metaLast, metaOrdinal = prev.Meta, prev.Meta.Ordinal
+	}
+	m := q.Meta
+	if m == nil {
+		// A question set directly stays in the round it interrupts.
+		m = &QuestionMeta{}
+		if metaLast != nil {
+			m.Round, m.RoundName, m.RoundOrdinal, m.RoundTotal = metaLast.Round, metaLast.RoundName, metaLast.RoundOrdinal, metaLast.RoundTotal
+		}
+	}
+	m.Session = *sessionName
+	if ordinal, ok := metaAsked[q.BankID]; ok && q.BankID != 0 {
+		m.Ordinal, m.Repeat = ordinal, true
+	} else {
+		metaOrdinal++
+		m.Ordinal = metaOrdinal
+		if q.BankID != 0 {
+			metaAsked[q.BankID] = metaOrdinal
+		}
+	}
+	q.Meta = m
+	metaLast = m
+}
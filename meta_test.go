@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// liveMeta is the metadata of the question on air.
+func liveMeta(t *testing.T, s *TestServer) QuestionMeta {
+	t.Helper()
+	var q struct {
+		Meta *QuestionMeta `json:"meta"`
+	}
+	s.Do(t, http.MethodGet, "/get-question", nil, &q)
+	if q.Meta == nil {
+		t.Fatal("no metadata on the live question")
+	}
+	return *q.Meta
+}
+
+func TestMetaRepeatsFollowBankEntries(t *testing.T) {
+	s := StartTestServer(t)
+	var ids []int
+	for i := 0; i < 2; i++ {
+		e := s.MustDo(t, http.MethodPost, "/bank", map[string]interface{}{
+			"question": "Hlavné mesto?", "type": "pomoc", "time_left": 30_000_000_000,
+		})
+		ids = append(ids, int(e["id"].(float64)))
+	}
+	ask := func(id int) QuestionMeta {
+		t.Helper()
+		if status := s.Do(t, http.MethodPost, fmt.Sprintf("/bank/%d/queue", id), nil, nil); status != http.StatusOK {
+			t.Fatalf("staging #%d: status %d", id, status)
+		}
+		s.MustDo(t, http.MethodPost, "/queue/next?force=true", nil)
+		return liveMeta(t, s)
+	}
+
+	// Two entries that read the same are two questions.
+	if m := ask(ids[0]); m.Ordinal != 1 || m.Repeat {
+		t.Errorf("first entry: %+v", m)
+	}
+	if m := ask(ids[1]); m.Ordinal != 2 || m.Repeat {
+		t.Errorf("second entry with the same text: %+v", m)
+	}
+
+	// Asking an entry again is a repeat, edited or not.
+	s.MustDo(t, http.MethodPut, fmt.Sprintf("/bank/%d", ids[0]), map[string]interface{}{
+		"question": "Hlavné mesto Slovenska?", "type": "pomoc", "time_left": 30_000_000_000, "version": 1,
+	})
+	if m := ask(ids[0]); m.Ordinal != 1 || !m.Repeat {
+		t.Errorf("first entry again: %+v", m)
+	}
+
+	// A question set directly is new every time, whatever its text.
+	for want := 3; want <= 4; want++ {
+		s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Hlavné mesto?", "type": "pomoc", "time_left": 30_000_000_000})
+		if m := liveMeta(t, s); m.Ordinal != want || m.Repeat {
+			t.Errorf("set directly: %+v, want ordinal %d", m, want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// getMetrics serves stream counts and tick punctuality in the Prometheus
+// text format.
+func getMetrics(c echo.Context) error {
+	var b strings.Builder
+	counts := hub.counts()
+	transports := make([]string, 0, len(counts))
+	for t := range counts {
+		transports = append(transports, t)
+	}
+	sort.Strings(transports)
+
+	b.WriteString("# HELP quiz_subscribers Open event subscribers by transport.\n# TYPE quiz_subscribers gauge\n")
+	for _, t := range transports {
+		fmt.Fprintf(&b, "quiz_subscribers{transport=%q} %d\n", t, counts[t])
+	}
+	st := hub.streamStats()
+	fmt.Fprintf(&b, "# HELP quiz_streams_open Open streams counted against the cap.\n# TYPE quiz_streams_open gauge\nquiz_streams_open %d\n", st.Open)
+	fmt.Fprintf(&b, "# HELP quiz_streams_cap Stream cap, 0 when unlimited.\n# TYPE quiz_streams_cap gauge\nquiz_streams_cap %d\n", st.Cap)
+	fmt.Fprintf(&b, "# HELP quiz_streams_evicted_total Streams dropped to make room for operators.\n# TYPE quiz_streams_evicted_total counter\nquiz_streams_evicted_total %d\n", st.Evicted)
+	fmt.Fprintf(&b, "# HELP quiz_streams_rejected_total Streams turned away at the cap.\n# TYPE quiz_streams_rejected_total counter\nquiz_streams_rejected_total %d\n", st.Rejected)
+	tk := ticks.stats()
+	fmt.Fprintf(&b, "# HELP quiz_tick_lag_max_seconds Largest delay of a countdown tick past its second boundary.\n# TYPE quiz_tick_lag_max_seconds gauge\nquiz_tick_lag_max_seconds %g\n", tk.MaxLag.Seconds())
+	fmt.Fprintf(&b, "# HELP quiz_ticks_late_total Countdown ticks later than -tick-late.\n# TYPE quiz_ticks_late_total counter\nquiz_ticks_late_total %d\n", tk.Late)
+	fmt.Fprintf(&b, "# HELP quiz_stall_max_seconds Longest the internal heartbeat has been late.\n# TYPE quiz_stall_max_seconds gauge\nquiz_stall_max_seconds %g\n", heartbeatMaxStall().Seconds())
+	return c.String(http.StatusOK, b.String())
+}
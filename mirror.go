@@ -0,0 +1,617 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+var mirrorURL = flag.String("mirror", "", "run as a read-only mirror of the primary server at this URL, e.g. http://primary:8050")
+
+const (
+	mirrorMinBackoff = 500 * time.Millisecond
+	mirrorMaxBackoff = 30 * time.Second
+	// mirrorIdle drops a stream of the primary that sent nothing, not even
+	// a keepalive, for this long.
+	mirrorIdle = 2 * keepaliveInterval
+	// mirrorMaxLine bounds one line of a stream, a whole event.
+	mirrorMaxLine = 1 << 20
+)
+
+// A mirror is the server for a network that must not reach the primary's
+// controls, such as the audience Wi-Fi. It follows the primary's public
+// event stream, as any display would, keeps a copy of the question and the
+// scoreboard, and serves them, the event stream and the kiosk pages from
+// that copy. It has no state of its own to change: every other method is
+// answered with 405. Nothing flows back to the primary.
+
+// mirrorClient has no timeout, the streams it reads are open for hours.
+// mirrorIdle notices a stream that went quiet instead.
+var mirrorClient = &http.Client{}
+
+// mirrorFeed follows one event stream of the primary, reconnecting with
+// backoff, and hands each event to handle.
+type mirrorFeed struct {
+	path   string
+	handle func(typ string, data []byte, received time.Time)
+
+	mu         sync.Mutex
+	connected  bool
+	since      time.Time // when connected last changed
+	lastHeard  time.Time
+	reconnects uint64
+	lastError  string
+}
+
+func (f *mirrorFeed) run() {
+	backoff := mirrorMinBackoff
+	for {
+		heard, err := f.follow()
+		f.setConnected(false, err)
+		if heard {
+			backoff = mirrorMinBackoff
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > mirrorMaxBackoff {
+			backoff = mirrorMaxBackoff
+		}
+	}
+}
+
+// follow reads the stream until it ends. It reports whether anything came.
+func (f *mirrorFeed) follow() (bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *mirrorURL+f.path, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := mirrorClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s: status code %d", f.path, resp.StatusCode)
+	}
+	f.setConnected(true, nil)
+
+	idle := time.AfterFunc(mirrorIdle, cancel)
+	defer idle.Stop()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), mirrorMaxLine)
+	var typ string
+	var data []byte
+	for scanner.Scan() {
+		idle.Reset(mirrorIdle)
+		f.heard()
+		line := scanner.Bytes()
+		switch {
+		case len(line) == 0:
+			if typ != "" && data != nil {
+				f.handle(typ, data, clock.Now())
+			}
+			typ, data = "", nil
+		case bytes.HasPrefix(line, []byte("event: ")):
+			typ = string(line[len("event: "):])
+		case bytes.HasPrefix(line, []byte("data: ")):
+			data = append([]byte{}, line[len("data: "):]...)
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return true, err
+	}
+	if ctx.Err() != nil {
+		return true, fmt.Errorf("%s: nothing heard for %s", f.path, mirrorIdle)
+	}
+	return true, fmt.Errorf("%s: stream closed", f.path)
+}
+
+func (f *mirrorFeed) heard() {
+	f.mu.Lock()
+	f.lastHeard = clock.Now()
+	f.mu.Unlock()
+}
+
+func (f *mirrorFeed) setConnected(connected bool, err error) {
+	f.mu.Lock()
+	changed := f.connected != connected
+	if changed {
+		f.connected = connected
+		f.since = clock.Now()
+		if connected && !f.lastHeard.IsZero() {
+			f.reconnects++
+		}
+	}
+	if err != nil {
+		f.lastError = err.Error()
+	} else if connected {
+		f.lastError = ""
+	}
+	f.mu.Unlock()
+	if changed && f == mirrorEvents {
+		mirrorConnectionChanged(connected, err)
+	}
+}
+
+// connectedAt is when the current stream was opened.
+func (f *mirrorFeed) connectedAt() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.since
+}
+
+// staleFor is how long the feed has been disconnected, zero while it is
+// connected.
+func (f *mirrorFeed) staleFor() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.connected {
+		return 0
+	}
+	return clock.Since(f.since)
+}
+
+// mirrorCopy is the primary's public state as last received.
+var mirrorCopy struct {
+	sync.RWMutex
+	question   *PublicQuestionView
+	questionAt time.Time
+	board      *Scoreboard
+	boardAt    time.Time
+	// lastEvent is the time of the newest event passed on, so the catch-up
+	// of a reconnect doesn't replay events clients already had.
+	lastEvent time.Time
+	lag       time.Duration
+}
+
+// mirrorEvents follows every public event of the primary. runMirror sets
+// its handler, mirrorEvent.
+var mirrorEvents = &mirrorFeed{path: "/v1/events?catch_up=true"}
+
+// mirrorEvent takes an event of the primary's stream into the copy and
+// passes it on to the mirror's own streams.
+func mirrorEvent(typ string, data []byte, received time.Time) {
+	var ev struct {
+		Type     string          `json:"type"`
+		Revision uint64          `json:"revision"`
+		Time     time.Time       `json:"time"`
+		Data     json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return
+	}
+	mirrorCopy.Lock()
+	switch typ {
+	case "state":
+		var q PublicQuestionView
+		// The times in it are as of when the primary sent it.
+		if json.Unmarshal(ev.Data, &q) == nil {
+			mirrorCopy.question, mirrorCopy.questionAt = &q, ev.Time
+		}
+	case "scoreboard":
+		var b Scoreboard
+		if json.Unmarshal(ev.Data, &b) == nil {
+			mirrorCopy.board, mirrorCopy.boardAt = &b, received
+		}
+	}
+	fresh := ev.Time.After(mirrorCopy.lastEvent)
+	if fresh {
+		mirrorCopy.lastEvent = ev.Time
+		// The catch-up at connect is as old as it is, not late.
+		if ev.Time.After(mirrorEvents.connectedAt()) {
+			mirrorCopy.lag = received.Sub(ev.Time)
+		}
+	}
+	mirrorCopy.Unlock()
+	if fresh {
+		hub.broadcast(Event{Type: typ, Revision: ev.Revision, Time: ev.Time, Data: ev.Data})
+	}
+}
+
+// fetchMirrorScoreboard reads the primary's scoreboard, which only has an
+// event once a score changed.
+func fetchMirrorScoreboard() error {
+	resp, err := mirrorClient.Get(*mirrorURL + "/v1/scoreboard")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("/scoreboard: status code %d", resp.StatusCode)
+	}
+	var b Scoreboard
+	if err := json.NewDecoder(resp.Body).Decode(&b); err != nil {
+		return err
+	}
+	mirrorCopy.Lock()
+	if mirrorCopy.board == nil || b.UpdatedAt.After(mirrorCopy.board.UpdatedAt) {
+		mirrorCopy.board, mirrorCopy.boardAt = &b, clock.Now()
+	}
+	mirrorCopy.Unlock()
+	return nil
+}
+
+// mirrorConnectionChanged tells the mirror's streams and its console that
+// the copy went stale or is live again.
+func mirrorConnectionChanged(connected bool, err error) {
+	if connected {
+		go fetchMirrorScoreboard()
+		color.New(color.FgGreen).Printf("Mirroring %s\n", *mirrorURL)
+	} else {
+		color.New(color.FgRed).Printf("Lost the primary (%v), serving the last-known state\n", err)
+	}
+	hub.broadcast(Event{Type: "mirror", Data: mirrorHealth()})
+}
+
+// ageQuestion moves the times of q on by age, as publicQuestion would have
+// on the primary, since state events only come on changes. Age is counted
+// from the event's time on the primary, so like the lag it assumes the two
+// clocks agree.
+func ageQuestion(q *PublicQuestionView, age time.Duration) {
+	if q.Paused || q.Stalled || q.Type == "end" || q.Type == "waiting" {
+		return
+	}
+	offset := q.UTCOffset
+	switch {
+	case q.Reading:
+		q.ReadingTime = max(q.ReadingTime-age, 0)
+	case q.CountUp:
+		q.TimeLeft += age
+	case q.Overtime > 0:
+		q.Overtime += age
+	default:
+		left := q.TimeLeft - age
+		if left < 0 {
+			if q.AllowOvertime {
+				q.Overtime = -left
+			} else {
+				q.Type = "end"
+			}
+			left = 0
+		}
+		q.TimeLeft = left
+	}
+	deriveDisplay(q)
+	q.UTCOffset = offset
+}
+
+// MirroredQuestion is the question as a mirror serves it. While the
+// primary is out of reach it is the last-known state, marked Stale, with
+// StaleFor how long the primary has been gone.
+type MirroredQuestion struct {
+	PublicQuestionView
+	Stale    bool          `json:"stale,omitempty"`
+	StaleFor time.Duration `json:"stale_for,omitempty"`
+}
+
+// MirroredScoreboard is the scoreboard as a mirror serves it.
+type MirroredScoreboard struct {
+	Scoreboard
+	Stale    bool          `json:"stale,omitempty"`
+	StaleFor time.Duration `json:"stale_for,omitempty"`
+}
+
+var errMirrorNotReady = apiError(http.StatusServiceUnavailable, "mirror_not_ready", "the mirror hasn't heard from the primary yet")
+
+func getMirrorQuestion(c echo.Context) error {
+	mirrorCopy.RLock()
+	stored, at := mirrorCopy.question, mirrorCopy.questionAt
+	mirrorCopy.RUnlock()
+	if stored == nil {
+		return errMirrorNotReady
+	}
+	q := *stored
+	ageQuestion(&q, clock.Since(at))
+	out := MirroredQuestion{PublicQuestionView: localizedQuestion(q, c.QueryParam("lang"))}
+	if stale := mirrorEvents.staleFor(); stale > 0 {
+		out.Stale, out.StaleFor = true, stale
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+func getMirrorScoreboard(c echo.Context) error {
+	mirrorCopy.RLock()
+	stored, at := mirrorCopy.board, mirrorCopy.boardAt
+	mirrorCopy.RUnlock()
+	if stored == nil {
+		return errMirrorNotReady
+	}
+	out := MirroredScoreboard{Scoreboard: *stored}
+	if clock.Since(at) >= *highlightWindow {
+		out.Highlight = []string{}
+	}
+	if stale := mirrorEvents.staleFor(); stale > 0 {
+		out.Stale, out.StaleFor = true, stale
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+// mirrorTeamKnown reports whether the mirrored scoreboard has team.
+func mirrorTeamKnown(team string) bool {
+	mirrorCopy.RLock()
+	defer mirrorCopy.RUnlock()
+	if mirrorCopy.board == nil {
+		return false
+	}
+	for _, e := range mirrorCopy.board.Entries {
+		if strings.EqualFold(e.Name, team) || strings.EqualFold(e.ShortName, team) {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrorKiosk relays the primary's kiosk stream of one team to the
+// mirror's podium screens, so the primary serves one stream per team
+// however many screens there are. It runs from the first screen of the team
+// until the mirror exits.
+type mirrorKiosk struct {
+	feed *mirrorFeed
+
+	mu     sync.Mutex
+	latest []byte
+	subs   map[chan kioskMessage]struct{}
+}
+
+type kioskMessage struct {
+	typ  string
+	data []byte
+}
+
+var (
+	mirrorKiosksMutex sync.Mutex
+	mirrorKiosks      = map[string]*mirrorKiosk{}
+)
+
+func kioskRelay(team, lang string) *mirrorKiosk {
+	key := strings.ToLower(team) + "?" + lang
+	mirrorKiosksMutex.Lock()
+	defer mirrorKiosksMutex.Unlock()
+	if k, ok := mirrorKiosks[key]; ok {
+		return k
+	}
+	k := &mirrorKiosk{subs: map[chan kioskMessage]struct{}{}}
+	path := "/v1/kiosk/" + url.PathEscape(team) + "/events"
+	if lang != "" {
+		path += "?lang=" + url.QueryEscape(lang)
+	}
+	k.feed = &mirrorFeed{path: path, handle: k.relay}
+	mirrorKiosks[key] = k
+	workers.goWorker("mirror kiosk "+team, k.feed.run)
+	return k
+}
+
+func (k *mirrorKiosk) relay(typ string, data []byte, _ time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if typ == "state" {
+		k.latest = data
+	}
+	for ch := range k.subs {
+		select {
+		case ch <- kioskMessage{typ, data}:
+		default:
+		}
+	}
+}
+
+func (k *mirrorKiosk) subscribe() (chan kioskMessage, []byte) {
+	ch := make(chan kioskMessage, subscriberBuffer)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.subs[ch] = struct{}{}
+	return ch, k.latest
+}
+
+func (k *mirrorKiosk) unsubscribe(ch chan kioskMessage) {
+	k.mu.Lock()
+	delete(k.subs, ch)
+	k.mu.Unlock()
+}
+
+func getMirrorKiosk(c echo.Context) error {
+	if !mirrorTeamKnown(c.Param("team")) {
+		return notFound("unknown team: " + c.Param("team"))
+	}
+	return c.Blob(http.StatusOK, echo.MIMETextHTMLCharsetUTF8, kioskPage)
+}
+
+// getMirrorKioskEvents streams the relayed kiosk view. While the primary is
+// gone the screen gets nothing, which its heartbeat watch shows as offline.
+func getMirrorKioskEvents(c echo.Context) error {
+	team := c.Param("team")
+	if !mirrorTeamKnown(team) {
+		return notFound("unknown team: " + team)
+	}
+	s, err := hub.join("kiosk", false, map[string]bool{}, false)
+	if err != nil {
+		return streamsFull()
+	}
+	defer hub.unsubscribe(s)
+	k := kioskRelay(team, c.QueryParam("lang"))
+	ch, latest := k.subscribe()
+	defer k.unsubscribe(ch)
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	send := func(typ string, data []byte) bool {
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typ, data); err != nil {
+			return false
+		}
+		w.Flush()
+		return true
+	}
+	if latest != nil && !send("state", latest) {
+		return nil
+	}
+	ctx := c.Request().Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hub.closing:
+			sendShutdownEvent(w)
+			return nil
+		case m := <-ch:
+			if !send(m.typ, m.data) {
+				return nil
+			}
+		}
+	}
+}
+
+// MirrorHealth is the mirror part of GET /healthz. Lag is how long the
+// newest event took from the primary's clock to the mirror's, so it
+// includes any difference between the two clocks.
+type MirrorHealth struct {
+	Primary    string        `json:"primary"`
+	Connected  bool          `json:"connected"`
+	StaleFor   time.Duration `json:"stale_for,omitempty"`
+	Lag        time.Duration `json:"lag"`
+	LastEvent  *time.Time    `json:"last_event,omitempty"`
+	LastHeard  *time.Time    `json:"last_heard,omitempty"`
+	Reconnects uint64        `json:"reconnects"`
+	LastError  string        `json:"last_error,omitempty"`
+}
+
+func mirrorHealth() MirrorHealth {
+	f := mirrorEvents
+	f.mu.Lock()
+	h := MirrorHealth{Primary: *mirrorURL, Connected: f.connected, Reconnects: f.reconnects, LastError: f.lastError}
+	if !f.connected {
+		h.StaleFor = clock.Since(f.since)
+	}
+	if !f.lastHeard.IsZero() {
+		heard := f.lastHeard
+		h.LastHeard = &heard
+	}
+	f.mu.Unlock()
+	mirrorCopy.RLock()
+	h.Lag = mirrorCopy.lag
+	if !mirrorCopy.lastEvent.IsZero() {
+		last := mirrorCopy.lastEvent
+		h.LastEvent = &last
+	}
+	mirrorCopy.RUnlock()
+	return h
+}
+
+// Health is the body of GET /healthz.
+type Health struct {
+	Status string        `json:"status"`
+	Mirror *MirrorHealth `json:"mirror,omitempty"`
+}
+
+// getHealthz is "ok" on a primary. A mirror is "stale" while it serves the
+// last-known state and "starting" until it first reached the primary.
+func getHealthz(c echo.Context) error {
+	if *mirrorURL == "" {
+		return c.JSON(http.StatusOK, Health{Status: "ok"})
+	}
+	h := mirrorHealth()
+	status := "ok"
+	switch {
+	case h.LastEvent == nil:
+		status = "starting"
+	case !h.Connected:
+		status = "stale"
+	}
+	return c.JSON(http.StatusOK, Health{Status: status, Mirror: &h})
+}
+
+// mirrorReadOnly answers every method but GET and HEAD with 405: a mirror
+// has nothing to change. The primary's address is not given out.
+func mirrorReadOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		switch c.Request().Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return next(c)
+		}
+		c.Response().Header().Set(echo.HeaderAllow, "GET, HEAD")
+		return apiError(http.StatusMethodNotAllowed, "read_only_mirror", "this server is a read-only mirror, changes can only be made on the primary")
+	}
+}
+
+func setupMirrorServer() *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = httpErrorHandler
+	e.JSONSerializer = versionedJSON{}
+	e.Pre(apiVersions)
+	e.Pre(mirrorReadOnly)
+	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins: []string{"*"},
+		AllowMethods: []string{http.MethodGet, http.MethodHead},
+	}))
+	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
+		Skipper: func(c echo.Context) bool {
+			return !loggingEnabled
+		},
+	}))
+	e.Use(middleware.Recover())
+
+	e.GET("/get-question", getMirrorQuestion)
+	e.GET("/time-sync", getTimeSync)
+	e.GET("/events", getEvents)
+	e.GET("/scoreboard", getMirrorScoreboard)
+	e.GET("/kiosk/:team", getMirrorKiosk)
+	e.GET("/kiosk/:team/events", getMirrorKioskEvents)
+	e.GET("/healthz", getHealthz)
+	e.GET("/readyz", getReadyz)
+	return e
+}
+
+// mirrorConflicts are the flags that give a server state to write, which a
+// mirror doesn't have.
+var mirrorConflicts = []string{"wal", "record", "push-target", "targets-file", "file-export"}
+
+// runMirror is main for -mirror. It never returns.
+func runMirror() {
+	u, err := url.Parse(*mirrorURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fmt.Fprintf(os.Stderr, "Error in -mirror: %q is not an http(s) URL\n", *mirrorURL)
+		os.Exit(1)
+	}
+	*mirrorURL = strings.TrimRight(*mirrorURL, "/")
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, name := range mirrorConflicts {
+		if set[name] {
+			fmt.Fprintf(os.Stderr, "Error: -%s can't be used with -mirror, a mirror has no state of its own\n", name)
+			os.Exit(1)
+		}
+	}
+
+	mirrorEvents.handle = mirrorEvent
+	workers.goWorker("mirror", mirrorEvents.run)
+	startServer(setupMirrorServer())
+	color.New(color.FgYellow).Printf("Read-only mirror of %s on %s\n", *mirrorURL, *listenAddr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	hub.closeStreams(shutdownReason)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	httpServer.Shutdown(ctx)
+	os.Exit(0)
+}
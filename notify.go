@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+const maxNotifications = 200
+
+// Notification severities.
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// Notification is an operator-facing message from a background component.
+type Notification struct {
+	ID       int       `json:"id"`
+	Time     time.Time `json:"time"`
+	Severity string    `json:"severity"`
+	Category string    `json:"category"`
+	Message  string    `json:"message"`
+	Acked    bool      `json:"acked"`
+}
+
+var (
+	notifications      []Notification
+	notificationsMutex sync.Mutex
+	nextNotificationID = 1
+)
+
+// notify records a notification, sends it to operator streams and prints it
+// in the CLI without garbling the prompt.
+func notify(severity, category, format string, args ...interface{}) Notification {
+	notificationsMutex.Lock()
+	n := Notification{
+		ID:       nextNotificationID,
+		Time:     clock.Now(),
+		Severity: severity,
+		Category: category,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	nextNotificationID++
+	notifications = append(notifications, n)
+	if len(notifications) > maxNotifications {
+		notifications = notifications[len(notifications)-maxNotifications:]
+	}
+	notificationsMutex.Unlock()
+
+	hub.broadcastOperator(Event{Type: "notification", Time: n.Time, Data: n})
+	asyncPrintf(severityColor(severity), "[%d %s/%s] %s\n", n.ID, n.Severity, n.Category, n.Message)
+	return n
+}
+
+func severityColor(severity string) *color.Color {
+	switch severity {
+	case SeverityError:
+		return color.New(color.FgRed, color.Bold)
+	case SeverityWarning:
+		return color.New(color.FgYellow)
+	default:
+		return color.New(color.FgCyan)
+	}
+}
+
+func ackNotification(id int) error {
+	notificationsMutex.Lock()
+	defer notificationsMutex.Unlock()
+	for i := range notifications {
+		if notifications[i].ID == id {
+			notifications[i].Acked = true
+			return nil
+		}
+	}
+	return fmt.Errorf("no notification %d", id)
+}
+
+func listNotifications() []Notification {
+	notificationsMutex.Lock()
+	defer notificationsMutex.Unlock()
+	return append([]Notification{}, notifications...)
+}
+
+// unackedCounts returns the unacknowledged warnings and errors.
+func unackedCounts() (warnings, errors int) {
+	notificationsMutex.Lock()
+	defer notificationsMutex.Unlock()
+	for _, n := range notifications {
+		if n.Acked {
+			continue
+		}
+		switch n.Severity {
+		case SeverityWarning:
+			warnings++
+		case SeverityError:
+			errors++
+		}
+	}
+	return warnings, errors
+}
+
+func getNotifications(c echo.Context) error {
+	return c.JSON(http.StatusOK, listNotifications())
+}
+
+func postNotificationAck(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return badRequest("invalid notification id")
+	}
+	if err := ackNotification(id); err != nil {
+		return notFound(err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func handleAckCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	if len(args) != 1 {
+		return errors.New("Usage: ack <id|all>")
+	}
+	if args[0] == "all" {
+		for _, n := range listNotifications() {
+			ackNotification(n.ID)
+		}
+		success.Println("All notifications acknowledged")
+		return nil
+	}
+	id, err := strconv.Atoi(args[0])
+	if err == nil {
+		err = ackNotification(id)
+	}
+	if err != nil {
+		return fmt.Errorf("Unknown notification: %s", args[0])
+	}
+	success.Printf("Notification %d acknowledged\n", id)
+	return nil
+}
+
+func printNotifications() {
+	list := listNotifications()
+	if len(list) == 0 {
+		color.New(color.FgYellow).Println("No notifications")
+		return
+	}
+	for _, n := range list {
+		mark := " "
+		if !n.Acked {
+			mark = "*"
+		}
+		severityColor(n.Severity).Printf(" %s %3d %s %-7s %-8s %s\n", mark, n.ID, n.Time.Local().Format("15:04:05"), n.Severity, n.Category, n.Message)
+	}
+}
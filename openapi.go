@@ -0,0 +1,248 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GET /openapi.json describes the /v2 API. The paths come from the router,
+// so no route is left out; the question payload is spelled out from the
+// view types, its extra as one schema per type from extraBuilders.
+
+var (
+	timeType         = reflect.TypeOf(time.Time{})
+	answerOptionType = reflect.TypeOf(AnswerOption{})
+)
+
+// typeSchemas are the schemas of types that marshal themselves.
+var typeSchemas = map[reflect.Type]map[string]interface{}{
+	timeType:     {"type": "string", "format": "date-time"},
+	durationType: {"type": "number", "description": "seconds"},
+	flexDurationType: {"oneOf": []interface{}{
+		map[string]interface{}{"type": "number", "description": "seconds"},
+		map[string]interface{}{"type": "string", "description": `a duration such as "1m30s"`},
+	}},
+	answerOptionType: {"oneOf": []interface{}{
+		map[string]interface{}{"type": "string", "description": "a text option"},
+		map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+			"text":      map[string]interface{}{"type": "string"},
+			"image_url": map[string]interface{}{"type": "string"},
+		}},
+	}},
+}
+
+// jsonSchema describes how encoding/json writes t. Struct fields without
+// omitempty are required.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	return schemaFor(t, map[reflect.Type]bool{})
+}
+
+func schemaFor(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	if s, ok := typeSchemas[t]; ok {
+		return s
+	}
+	if t.Implements(marshalerType) {
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem(), seen)
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]interface{}{}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		properties := map[string]interface{}{}
+		var required []string
+		schemaFields(t, seen, properties, &required)
+		s := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			sort.Strings(required)
+			s["required"] = required
+		}
+		return s
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), seen)}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	}
+	return map[string]interface{}{}
+}
+
+// schemaFields adds the fields of t and then those of its embedded
+// structs that t doesn't have itself.
+func schemaFields(t reflect.Type, seen map[reflect.Type]bool, properties map[string]interface{}, required *[]string) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, f.Type)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = schemaFor(f.Type, seen)
+		if !strings.Contains(","+opts+",", ",omitempty,") {
+			*required = append(*required, name)
+		}
+	}
+	for _, et := range embedded {
+		inner := map[string]interface{}{}
+		var innerRequired []string
+		schemaFields(et, seen, inner, &innerRequired)
+		added := map[string]bool{}
+		for name, s := range inner {
+			if _, ok := properties[name]; !ok {
+				properties[name], added[name] = s, true
+			}
+		}
+		for _, name := range innerRequired {
+			if added[name] {
+				*required = append(*required, name)
+			}
+		}
+	}
+}
+
+// extraSchemaName is the component name of the extra of a question type.
+func extraSchemaName(qtype string) string {
+	r := []rune(qtype)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r) + "Extra"
+}
+
+// openAPIDocument describes the API served by routes.
+func openAPIDocument(routes []*echo.Route) map[string]interface{} {
+	schemas := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"error": jsonSchema(reflect.TypeOf(APIError{}))},
+			"required":   []string{"error"},
+		},
+	}
+
+	// The extra is the schema of the question's type, told apart by kind.
+	// Types without a builder have none.
+	var kinds []string
+	for qtype := range extraBuilders {
+		kinds = append(kinds, qtype)
+	}
+	sort.Strings(kinds)
+	var oneOf []interface{}
+	mapping := map[string]string{}
+	for _, qtype := range kinds {
+		name := extraSchemaName(qtype)
+		schemas[name] = extraBuilders[qtype].Schema
+		ref := "#/components/schemas/" + name
+		oneOf = append(oneOf, map[string]interface{}{"$ref": ref})
+		mapping[qtype] = ref
+	}
+	question := jsonSchema(reflect.TypeOf(PublicQuestionView{}))
+	question["properties"].(map[string]interface{})["extra"] = map[string]interface{}{
+		"description":   "what only the question's type has; types without one leave it out",
+		"oneOf":         oneOf,
+		"discriminator": map[string]interface{}{"propertyName": "kind", "mapping": mapping},
+	}
+	schemas["PublicQuestion"] = question
+	schemas["QuestionRequest"] = jsonSchema(reflect.TypeOf(QuestionRequest{}))
+
+	jsonContent := func(ref string) map[string]interface{} {
+		return map[string]interface{}{"application/json": map[string]interface{}{
+			"schema": map[string]interface{}{"$ref": "#/components/schemas/" + ref},
+		}}
+	}
+	paths := map[string]interface{}{}
+	operationIDs := map[string]bool{}
+	for _, r := range routes {
+		if r.Method == echo.RouteNotFound || strings.HasSuffix(r.Path, "/*") {
+			continue
+		}
+		var params []interface{}
+		segments := strings.Split(r.Path, "/")
+		for i, seg := range segments {
+			if strings.HasPrefix(seg, ":") {
+				segments[i] = "{" + seg[1:] + "}"
+				params = append(params, map[string]interface{}{
+					"name": seg[1:], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+				})
+			}
+		}
+		path := strings.Join(segments, "/")
+		op := map[string]interface{}{
+			"responses": map[string]interface{}{
+				"2XX":     map[string]interface{}{"description": "success"},
+				"default": map[string]interface{}{"description": "an error", "content": jsonContent("Error")},
+			},
+		}
+		// Closures have no name, and a handler on several routes names the
+		// first.
+		if name := r.Name[strings.LastIndex(r.Name, ".")+1:]; !strings.HasPrefix(name, "func") && !operationIDs[name] {
+			op["operationId"], operationIDs[name] = name, true
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		switch {
+		case r.Method == http.MethodGet && path == "/get-question":
+			op["responses"].(map[string]interface{})["200"] = map[string]interface{}{"description": "the live question", "content": jsonContent("PublicQuestion")}
+		case r.Method == http.MethodPost && path == "/set-question":
+			op["requestBody"] = map[string]interface{}{"required": true, "content": jsonContent("QuestionRequest")}
+		}
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		item[strings.ToLower(r.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":       "Stuskova timer API",
+			"version":     "2",
+			"description": "Every path is also served under /v1, with durations in nanoseconds and errors as {\"error\": message}. Operators send X-API-Key; reads of public state need no key.",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": "/v2"}},
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{}, map[string]interface{}{"apiKey": []string{}}},
+		"paths":    paths,
+	}
+}
+
+func getOpenAPI(c echo.Context) error {
+	return c.JSON(http.StatusOK, openAPIDocument(c.Echo().Routes()))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func schemaKeys(schema map[string]interface{}) []string {
+	var keys []string
+	for k := range schema["properties"].(map[string]interface{}) {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	s := StartTestServer(t)
+	var doc struct {
+		OpenAPI    string                                       `json:"openapi"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	if status := s.Do(t, http.MethodGet, "/openapi.json", nil, &doc); status != http.StatusOK || doc.OpenAPI != "3.1.0" {
+		t.Fatalf("status %d, openapi %q", status, doc.OpenAPI)
+	}
+	for path, method := range map[string]string{"/get-question": "get", "/set-question": "post", "/teams/{name}": "delete", "/options/fifty-fifty": "post", "/openapi.json": "get"} {
+		if _, ok := doc.Paths[path][method]; !ok {
+			t.Errorf("%s %s is not documented", method, path)
+		}
+	}
+	if params, _ := doc.Paths["/teams/{name}"]["delete"]["parameters"].([]interface{}); len(params) != 1 {
+		t.Errorf("path parameters %v", params)
+	}
+
+	question := doc.Components.Schemas["PublicQuestion"]
+	props := question["properties"].(map[string]interface{})
+	if tl := props["time_left"].(map[string]interface{}); tl["type"] != "number" {
+		t.Errorf("time_left %v", tl)
+	}
+	extra := props["extra"].(map[string]interface{})
+	mapping := extra["discriminator"].(map[string]interface{})["mapping"].(map[string]interface{})
+	if len(extra["oneOf"].([]interface{})) != len(extraBuilders) || len(mapping) != len(extraBuilders) {
+		t.Errorf("extra %v", extra)
+	}
+	for qtype, b := range extraBuilders {
+		name := extraSchemaName(qtype)
+		if mapping[qtype] != "#/components/schemas/"+name {
+			t.Errorf("%s maps to %v", qtype, mapping[qtype])
+		}
+		kind := doc.Components.Schemas[name]["properties"].(map[string]interface{})["kind"].(map[string]interface{})
+		if kind["const"] != qtype {
+			t.Errorf("%s kind %v", name, kind)
+		}
+		// The fragment lists exactly what the builder writes.
+		built := jsonSchema(reflect.TypeOf(b.Build(Question{Type: qtype})))
+		if got, want := schemaKeys(b.Schema), schemaKeys(built); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s schema has %v, the extra %v", qtype, got, want)
+		}
+	}
+}
+
+// TestExtraPerType checks that each type's payload carries its own extra
+// and nothing of the others', and that a type without one has none.
+func TestExtraPerType(t *testing.T) {
+	s := StartTestServer(t)
+	questionTypesMutex.Lock()
+	saved := questionTypes
+	questionTypes = []string{"pomoc", "rozstrel", "picture", "hadanka"}
+	questionTypesMutex.Unlock()
+	t.Cleanup(func() {
+		questionTypesMutex.Lock()
+		questionTypes = saved
+		questionTypesMutex.Unlock()
+	})
+
+	for _, qtype := range []string{"pomoc", "rozstrel", "picture", "hadanka"} {
+		s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Otázka", "type": qtype, "time_left": 30_000_000_000})
+		var q map[string]json.RawMessage
+		s.Do(t, http.MethodGet, "/v2/get-question", nil, &q)
+		b, ok := extraBuilders[qtype]
+		if !ok {
+			if raw, ok := q["extra"]; ok {
+				t.Errorf("%s has an extra: %s", qtype, raw)
+			}
+			continue
+		}
+		var extra map[string]interface{}
+		if err := json.Unmarshal(q["extra"], &extra); err != nil {
+			t.Fatalf("%s: %v", qtype, err)
+		}
+		if extra["kind"] != qtype {
+			t.Errorf("%s extra of kind %v", qtype, extra["kind"])
+		}
+		own := b.Schema["properties"].(map[string]interface{})
+		for key := range extra {
+			if _, ok := own[key]; !ok {
+				t.Errorf("%s extra has %q", qtype, key)
+			}
+		}
+		for _, key := range b.Schema["required"].([]string) {
+			if _, ok := extra[key]; !ok {
+				t.Errorf("%s extra lacks %q", qtype, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	operatorKeys     operatorKeyFlag
+	cliOperatorName  = flag.String("operator-name", "console", "name the server's own CLI is shown and audited under")
+	operatorIdleTime = flag.Duration("operator-idle", 5*time.Minute, "how long an operator stays listed without activity")
+)
+
+// operatorNameHeader names the operator using the shared -api-key, as
+// asked for by the client's login prompt.
+const operatorNameHeader = "X-Operator-Name"
+
+// operatorKey is one -operator-key label=key: a key of its own for one
+// operator, who is named by the label.
+type operatorKey struct {
+	Label string
+	Key   string
+}
+
+// operatorKeyFlag collects repeated -operator-key label=key flags.
+type operatorKeyFlag []operatorKey
+
+func (f *operatorKeyFlag) String() string {
+	labels := make([]string, len(*f))
+	for i, k := range *f {
+		labels[i] = k.Label
+	}
+	return strings.Join(labels, ",")
+}
+
+func (f *operatorKeyFlag) Set(v string) error {
+	label, key, ok := strings.Cut(v, "=")
+	if !ok || strings.TrimSpace(label) == "" || key == "" {
+		return fmt.Errorf("expected label=key")
+	}
+	*f = append(*f, operatorKey{Label: strings.TrimSpace(label), Key: key})
+	return nil
+}
+
+func init() {
+	flag.Var(&operatorKeys, "operator-key", "operator key as label=key, may be repeated; the label names the operator")
+}
+
+// Commands from an operator carry the name in their origin, as
+// "<transport>:<name>", so the name reaches the audit log through the same
+// origin argument as the transport. audit splits it again; everything else
+// sees the plain transport. CLI commands are the -operator-name operator.
+
+// operatorDisplayName is the name for a key with label, or for the shared
+// key the name the client gave.
+func operatorDisplayName(label, given string) string {
+	if label != "" {
+		return label
+	}
+	if given = strings.TrimSpace(given); given != "" {
+		return given
+	}
+	return "operator"
+}
+
+// requestOperator is the operator sending c, ok unset for a request
+// without a valid key.
+func requestOperator(c echo.Context) (name string, ok bool) {
+	label, ok := keyOperator(requestAPIKey(c))
+	if !ok {
+		return "", false
+	}
+	return operatorDisplayName(label, c.Request().Header.Get(operatorNameHeader)), true
+}
+
+// requestOrigin is the origin to pass on for c: "http", or "http:<name>"
+// from an operator, whose activity it records.
+func requestOrigin(c echo.Context) string {
+	name, ok := requestOperator(c)
+	if !ok {
+		return "http"
+	}
+	touchOperator(name, "http", c.RealIP())
+	return "http:" + name
+}
+
+// splitOrigin separates the transport and the operator name of origin.
+func splitOrigin(origin string) (transport, operator string) {
+	if origin == "cli" {
+		return origin, *cliOperatorName
+	}
+	if t, name, ok := strings.Cut(origin, ":"); ok && (t == "http" || t == "ws") {
+		return t, name
+	}
+	return origin, ""
+}
+
+// OperatorSession is an operator seen on one transport from one address.
+type OperatorSession struct {
+	Name       string    `json:"name"`
+	Transport  string    `json:"transport"`
+	Remote     string    `json:"remote,omitempty"`
+	Since      time.Time `json:"since"`
+	LastSeen   time.Time `json:"last_seen"`
+	LastAction string    `json:"last_action,omitempty"`
+}
+
+var (
+	operatorMutex    sync.Mutex
+	operatorSessions = map[string]*OperatorSession{}
+	// consoleAction is the last action of the CLI operator, whose activity
+	// is the CLI input.
+	consoleAction string
+)
+
+func operatorSessionKey(name, transport, remote string) string {
+	return transport + "\x00" + name + "\x00" + remote
+}
+
+// touchOperator records activity of name.
+func touchOperator(name, transport, remote string) {
+	now := clock.Now()
+	operatorMutex.Lock()
+	defer operatorMutex.Unlock()
+	key := operatorSessionKey(name, transport, remote)
+	s, ok := operatorSessions[key]
+	if !ok || now.Sub(s.LastSeen) >= *operatorIdleTime {
+		s = &OperatorSession{Name: name, Transport: transport, Remote: remote, Since: now}
+		operatorSessions[key] = s
+	}
+	s.LastSeen = now
+}
+
+// activeOperators lists the operators active within -operator-idle, the
+// most recent first, and forgets the others.
+func activeOperators() []OperatorSession {
+	now := clock.Now()
+	cliLockMutex.Lock()
+	cliSeen := lastCLIInput
+	cliLockMutex.Unlock()
+
+	operatorMutex.Lock()
+	var out []OperatorSession
+	if now.Sub(cliSeen) < *operatorIdleTime {
+		out = append(out, OperatorSession{Name: *cliOperatorName, Transport: "cli", Since: processStart, LastSeen: cliSeen, LastAction: consoleAction})
+	}
+	for key, s := range operatorSessions {
+		if now.Sub(s.LastSeen) >= *operatorIdleTime {
+			delete(operatorSessions, key)
+			continue
+		}
+		out = append(out, *s)
+	}
+	operatorMutex.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].LastSeen.After(out[j].LastSeen) })
+	return out
+}
+
+// OperatorAction is the operator_action event sent to operator streams for
+// every audited action of an operator, so each console can show what the
+// others just did.
+type OperatorAction struct {
+	AuditID   uint64                 `json:"audit_id"`
+	Operator  string                 `json:"operator"`
+	Transport string                 `json:"transport"`
+	Action    string                 `json:"action"`
+	Summary   string                 `json:"summary"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// announceOperatorAction sends e, if an operator did it.
+func announceOperatorAction(e AuditEntry) {
+	if e.Operator == "" {
+		return
+	}
+	summary := describeAction(e) + " by " + e.Operator
+	operatorMutex.Lock()
+	if e.Origin == "cli" {
+		consoleAction = summary
+	}
+	for _, s := range operatorSessions {
+		if s.Name == e.Operator && s.Transport == e.Origin {
+			s.LastAction = summary
+		}
+	}
+	operatorMutex.Unlock()
+	hub.broadcastOperator(Event{Type: "operator_action", Time: e.Time, Data: OperatorAction{
+		AuditID:   e.ID,
+		Operator:  e.Operator,
+		Transport: e.Origin,
+		Action:    e.Action,
+		Summary:   summary,
+		Details:   e.Details,
+	}})
+}
+
+// describeAction says in a few words what e did, such as "time +10s".
+func describeAction(e AuditEntry) string {
+	d := e.Details
+	switch e.Action {
+	case "time_adjust":
+		return fmt.Sprintf("time %+gs", d["delta"])
+	case "question":
+		return fmt.Sprintf("question %q", d["question"])
+	case "score":
+		return fmt.Sprintf("score %v %+d", d["team"], d["delta"])
+	case "buzz", "answer", "eliminate", "adjudicate":
+		return fmt.Sprintf("%s %v", e.Action, d["team"])
+	}
+	return strings.ReplaceAll(e.Action, "_", " ")
+}
+
+func getOperators(c echo.Context) error {
+	return c.JSON(http.StatusOK, activeOperators())
+}
+
+// handleWhoCommand runs "who".
+func handleWhoCommand() {
+	info := color.New(color.FgYellow)
+	list := activeOperators()
+	if len(list) == 0 {
+		info.Println("No active operators")
+		return
+	}
+	now := clock.Now()
+	for _, s := range list {
+		where := s.Transport
+		if s.Remote != "" {
+			where += " from " + s.Remote
+		}
+		fmt.Printf("  %-12s %-22s active %s ago", s.Name, where, now.Sub(s.LastSeen).Round(time.Second))
+		if s.LastAction != "" {
+			fmt.Printf(", last: %s", s.LastAction)
+		}
+		fmt.Println()
+	}
+}
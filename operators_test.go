@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestOperatorAttribution acts as three operators, one with a key of their
+// own, one named by the login prompt and one on the WebSocket, and checks
+// the audit log, the operator_action events and GET /operators.
+func TestOperatorAttribution(t *testing.T) {
+	s := StartTestServer(t)
+	defer func(k operatorKeyFlag) { operatorKeys = k }(operatorKeys)
+	if err := operatorKeys.Set("Katka=katka-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := operatorKeys.Set("bez kľúča"); err == nil {
+		t.Error("an operator key without a key was accepted")
+	}
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Hlavné mesto?", "type": "pomoc", "time_left": 30_000_000_000})
+
+	viewer, err := hub.join("sse", false, parseEventTypes("operator_action"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.unsubscribe(viewer)
+	operator, err := hub.join("sse", true, parseEventTypes("operator_action"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.unsubscribe(operator)
+
+	adjust := func(key, name string) {
+		t.Helper()
+		req := s.NewRequest(t, http.MethodPost, "/time/adjust", map[string]interface{}{"delta_seconds": 10})
+		req.Header.Set("X-API-Key", key)
+		if name != "" {
+			req.Header.Set(operatorNameHeader, name)
+		}
+		if res := s.Send(t, req, nil); res.StatusCode != http.StatusOK {
+			t.Fatalf("adjusting as %q: status %d", name, res.StatusCode)
+		}
+	}
+	for _, tc := range []struct{ key, name, want string }{
+		{"katka-key", "Podvodník", "Katka"},
+		{testKey, "Jano", "Jano"},
+		{testKey, "", "operator"},
+	} {
+		adjust(tc.key, tc.name)
+		if e := lastAudit(t, "time_adjust"); e.Origin != "http" || e.Operator != tc.want {
+			t.Errorf("audited as %s by %q, want %q", e.Origin, e.Operator, tc.want)
+		}
+		select {
+		case ev := <-operator.ch:
+			if a, ok := ev.Data.(OperatorAction); !ok || a.Operator != tc.want || a.Summary != "time +10s by "+tc.want {
+				t.Errorf("operator_action %+v", ev.Data)
+			}
+		default:
+			t.Errorf("no operator_action for %s", tc.want)
+		}
+	}
+	if got := drain(viewer); got != nil {
+		t.Errorf("a viewer got %v", got)
+	}
+
+	ws := dialWS(t, s, "")
+	if res := wsSend(t, ws, WSCommand{ID: "1", Cmd: "auth", Key: testKey, Name: "Zuzka"}); !res.OK {
+		t.Fatalf("auth: %+v", res.Error)
+	}
+	if res := wsSend(t, ws, WSCommand{ID: "2", Cmd: "pause", Value: "tech"}); !res.OK {
+		t.Fatalf("pause: %+v", res.Error)
+	}
+	if e := lastAudit(t, "pause"); e.Origin != "ws" || e.Operator != "Zuzka" {
+		t.Errorf("pause audited as %s by %q", e.Origin, e.Operator)
+	}
+
+	remote := func() map[string]string {
+		t.Helper()
+		var list []OperatorSession
+		s.Do(t, http.MethodGet, "/operators", nil, &list)
+		seen := map[string]string{}
+		for _, o := range list {
+			if o.Transport != "cli" {
+				seen[o.Name] = o.Transport + ", " + o.LastAction
+			}
+		}
+		return seen
+	}
+	seen := remote()
+	if seen["Katka"] != "http, time +10s by Katka" || seen["Jano"] != "http, time +10s by Jano" || seen["Zuzka"] != "ws, pause by Zuzka" {
+		t.Errorf("operators %v", seen)
+	}
+	// The list request itself keeps its sender listed.
+	AdvanceClock(t, *operatorIdleTime)
+	if seen := remote(); len(seen) != 1 || seen["operator"] == "" {
+		t.Errorf("operators after a quiet spell %v", seen)
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// AnswerOption is one choice of a multiple-choice question: a text, an
+// image, or both. In JSON a text-only option is a plain string.
+type AnswerOption struct {
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+type answerOptionObject AnswerOption
+
+func (o *AnswerOption) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '"' {
+		o.ImageURL = ""
+		return json.Unmarshal(b, &o.Text)
+	}
+	return json.Unmarshal(b, (*answerOptionObject)(o))
+}
+
+// MarshalJSON keeps the shape the option was given in, so payloads show
+// text options as strings and picture options as objects.
+func (o AnswerOption) MarshalJSON() ([]byte, error) {
+	if o.ImageURL == "" {
+		return json.Marshal(o.Text)
+	}
+	return json.Marshal(answerOptionObject(o))
+}
+
+// validateOptions requires a text or an image on every option and checks
+// the image URLs like media_url.
+func validateOptions(options []AnswerOption) error {
+	for i, o := range options {
+		if o.Text == "" && o.ImageURL == "" {
+			return fmt.Errorf("option %d needs a text or an image_url", i+1)
+		}
+		if err := validateMediaURL(o.ImageURL); err != nil {
+			return fmt.Errorf("option %d: image_url must be an http(s) URL or an absolute path", i+1)
+		}
+	}
+	return nil
+}
+
+// String shows o as the CLI takes it: its text, its image as an img:
+// token, or both.
+func (o AnswerOption) String() string {
+	if o.ImageURL == "" {
+		return o.Text
+	}
+	img := imageToken + path.Base(o.ImageURL)
+	if f, ok := uploadedMedia(o.ImageURL); ok && f.File != "" {
+		img = imageToken + f.File
+	}
+	if o.Text == "" {
+		return img
+	}
+	return o.Text + " " + img
+}
+
+// imageToken starts a picture in typed options: "img:rim.jpg" names an
+// upload by the file it was sent as, see resolveMediaName.
+const imageToken = "img:"
+
+// parseOptionInput reads one option as typed at the CLI or pasted: a text,
+// an img: token, or both, as in "Rím img:rim.jpg". A "*" before or after
+// it marks the correct option.
+func parseOptionInput(s string) (AnswerOption, bool, error) {
+	s = strings.TrimSpace(s)
+	star := false
+	if strings.HasPrefix(s, "*") {
+		s, star = strings.TrimSpace(strings.TrimPrefix(s, "*")), true
+	}
+	if strings.HasSuffix(s, "*") {
+		s, star = strings.TrimSpace(strings.TrimSuffix(s, "*")), true
+	}
+	if !strings.Contains(strings.ToLower(s), imageToken) {
+		return AnswerOption{Text: s}, star, nil
+	}
+	var o AnswerOption
+	var words []string
+	for _, w := range strings.Fields(s) {
+		if !strings.HasPrefix(strings.ToLower(w), imageToken) {
+			words = append(words, w)
+			continue
+		}
+		if o.ImageURL != "" {
+			return o, star, fmt.Errorf("%q has two images, an option takes one", s)
+		}
+		u, err := resolveMediaName(w[len(imageToken):])
+		if err != nil {
+			return o, star, err
+		}
+		o.ImageURL = u
+	}
+	o.Text = strings.Join(words, " ")
+	return o, star, nil
+}
+
+// parseTypedOptions reads the options of "question ... | ..." and the
+// options wizard. It returns the option marked correct, -1 for none.
+func parseTypedOptions(typed []string) ([]AnswerOption, int, error) {
+	var options []AnswerOption
+	correct := -1
+	for _, s := range typed {
+		o, star, err := parseOptionInput(s)
+		if err != nil {
+			return nil, -1, err
+		}
+		if star {
+			if correct >= 0 {
+				return nil, -1, errors.New("More than one option is marked correct, mark one")
+			}
+			correct = len(options)
+		}
+		options = append(options, o)
+	}
+	if len(options) == 1 {
+		return nil, -1, errors.New("Only one option given, a question needs two or none")
+	}
+	if err := validateOptions(options); err != nil {
+		return nil, -1, err
+	}
+	return options, correct, nil
+}
+
+// optionMatching accepts option c by its letter, number and text, if it
+// has one.
+func optionMatching(options []AnswerOption, c int) *MatchRules {
+	m := &MatchRules{Accepted: []string{optionLabel(c), strconv.Itoa(c + 1)}, IgnoreCase: true}
+	if t := options[c].Text; t != "" {
+		m.Accepted = append(m.Accepted, t)
+	}
+	return m
+}
+
+// Options can be shuffled before anyone answers, and a 50:50 takes away
+// every wrong option but one. Both go by the matching rules, which accept
+// the correct option by letter or number whatever it shows, so picture
+// options play like text ones.
+
+// removedOptions are the options the 50:50 took away from the live
+// question, numbered from 1. It is guarded by roundMutex and cleared by
+// resetRound.
+var removedOptions []int
+
+var errOptionRemoved = &RoundError{Code: "option_removed", Message: "that option was taken away by the 50:50"}
+
+// isRemovedOption reports whether option i, from 0, was taken away.
+// roundMutex must be held.
+func isRemovedOption(i int) bool {
+	for _, n := range removedOptions {
+		if n == i+1 {
+			return true
+		}
+	}
+	return false
+}
+
+// optionAccepted reports whether m accepts option i: by its exact letter or
+// number, or by its text under the rules.
+func optionAccepted(m *MatchRules, options []AnswerOption, i int) bool {
+	for _, a := range m.Accepted {
+		if optionPosition(options, a) == i {
+			return true
+		}
+	}
+	return options[i].Text != "" && m.classify(Answer{Answer: options[i].Text}).Status == verdictCorrect
+}
+
+// correctOption is the one option q's matching accepts, -1 if none or
+// several are.
+func correctOption(q Question) int {
+	if q.Matching == nil {
+		return -1
+	}
+	found := -1
+	for i := range q.Options {
+		if !optionAccepted(q.Matching, q.Options, i) {
+			continue
+		}
+		if found >= 0 {
+			return -1
+		}
+		found = i
+	}
+	return found
+}
+
+// reorderMatching moves the letters and numbers m accepts along with the
+// options, order[new] being the old position. Texts stay as they are.
+func reorderMatching(m *MatchRules, options []AnswerOption, order []int) *MatchRules {
+	if m == nil {
+		return nil
+	}
+	moved := *m
+	moved.Accepted = make([]string, len(m.Accepted))
+	for j, a := range m.Accepted {
+		moved.Accepted[j] = a
+		old := optionPosition(options, a)
+		if old < 0 {
+			continue
+		}
+		for n, o := range order {
+			if o != old {
+				continue
+			}
+			if _, err := strconv.Atoi(strings.TrimSpace(a)); err == nil {
+				moved.Accepted[j] = strconv.Itoa(n + 1)
+			} else {
+				moved.Accepted[j] = optionLabel(n)
+			}
+		}
+	}
+	return &moved
+}
+
+// optionsInPlay refuses to move options that answers or a 50:50 already
+// refer to by position. questionMutex must be held.
+func optionsInPlay() error {
+	roundMutex.Lock()
+	defer roundMutex.Unlock()
+	if len(answers) > 0 || len(removedOptions) > 0 {
+		return &RoundError{Code: "options_in_play", Message: "answers or the 50:50 already refer to the options by position"}
+	}
+	return nil
+}
+
+// setLiveOptions replaces the options of the live question. The option
+// marked correct, if any, becomes the accepted answer.
+func setLiveOptions(options []AnswerOption, correct int, origin string) error {
+	if !questionInProgress() {
+		return &RoundError{Code: "no_question", Message: "no question is running"}
+	}
+	questionMutex.Lock()
+	if err := optionsInPlay(); err != nil {
+		questionMutex.Unlock()
+		return err
+	}
+	q := question
+	q.Options = options
+	if correct >= 0 {
+		q.Matching = optionMatching(options, correct)
+	}
+	if err := validateOptionsReveal(q); err != nil {
+		questionMutex.Unlock()
+		return err
+	}
+	question = q
+	stateChanged("options")
+	questionMutex.Unlock()
+
+	audit("options", origin, map[string]interface{}{"count": len(options), "correct": correct + 1})
+	go sendCurrentQuestion()
+	return nil
+}
+
+// shuffleOptions puts the options of the live question in a random order,
+// moving the accepted letters and numbers with them.
+func shuffleOptions(origin string) ([]AnswerOption, error) {
+	if !questionInProgress() {
+		return nil, &RoundError{Code: "no_question", Message: "no question is running"}
+	}
+	questionMutex.Lock()
+	if len(question.Options) < 2 {
+		questionMutex.Unlock()
+		return nil, &RoundError{Code: "no_options", Message: "the question has no options to shuffle"}
+	}
+	if err := optionsInPlay(); err != nil {
+		questionMutex.Unlock()
+		return nil, err
+	}
+	order := rand.Perm(len(question.Options))
+	shuffled := make([]AnswerOption, len(order))
+	for n, old := range order {
+		shuffled[n] = question.Options[old]
+	}
+	question.Matching = reorderMatching(question.Matching, question.Options, order)
+	question.Options = shuffled
+	stateChanged("options_shuffle")
+	questionMutex.Unlock()
+
+	moved := make([]string, len(order))
+	for n, old := range order {
+		moved[n] = optionLabel(old)
+	}
+	audit("options_shuffle", origin, map[string]interface{}{"order": strings.Join(moved, "")})
+	go sendCurrentQuestion()
+	return shuffled, nil
+}
+
+// fiftyFifty takes away every wrong option of the live question but one,
+// picked at random. It needs matching rules that accept one option.
+func fiftyFifty(origin string) ([]int, error) {
+	if !questionInProgress() {
+		return nil, &RoundError{Code: "no_question", Message: "no question is running"}
+	}
+	questionMutex.RLock()
+	q := question
+	questionMutex.RUnlock()
+	if len(q.Options) < 3 {
+		return nil, &RoundError{Code: "too_few_options", Message: "a 50:50 needs three or more options"}
+	}
+	correct := correctOption(q)
+	if correct < 0 {
+		return nil, &RoundError{Code: "no_correct_option", Message: "no single option is accepted as correct, mark one first"}
+	}
+
+	roundMutex.Lock()
+	if floorClosed != nil {
+		roundMutex.Unlock()
+		return nil, &RoundError{Code: "floor_closed", Message: "answers are closed for this question"}
+	}
+	if len(removedOptions) > 0 {
+		roundMutex.Unlock()
+		return nil, &RoundError{Code: "fifty_fifty_used", Message: "the 50:50 was already played on this question"}
+	}
+	var wrong []int
+	for i := range q.Options {
+		if i != correct {
+			wrong = append(wrong, i)
+		}
+	}
+	kept := wrong[rand.Intn(len(wrong))]
+	var removed []int
+	for _, i := range wrong {
+		if i != kept {
+			removed = append(removed, i+1)
+		}
+	}
+	removedOptions = removed
+	roundMutex.Unlock()
+
+	audit("fifty_fifty", origin, map[string]interface{}{"removed": removed})
+	hub.broadcast(Event{Type: "options_removed", Data: map[string]interface{}{"removed": removed}})
+	roundChanged("fifty_fifty")
+	go sendCurrentQuestion()
+	return removed, nil
+}
+
+func postOptionsShuffle(c echo.Context) error {
+	options, err := shuffleOptions(requestOrigin(c))
+	if err != nil {
+		return roundError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"options": options})
+}
+
+func postFiftyFifty(c echo.Context) error {
+	if _, err := fiftyFifty(requestOrigin(c)); err != nil {
+		return roundError(err)
+	}
+	return c.JSON(http.StatusOK, publicRound())
+}
+
+// handleOptionsCommand runs "options [shuffle|5050]". Without arguments it
+// asks for the options of the live question one by one.
+func handleOptionsCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	switch {
+	case len(args) == 1 && args[0] == "shuffle":
+		options, err := shuffleOptions(origin)
+		if err != nil {
+			return err
+		}
+		success.Println("Options shuffled:")
+		for i, o := range options {
+			info.Printf("  %s) %s\n", optionLabel(i), o)
+		}
+		return nil
+	case len(args) == 1 && args[0] == "5050":
+		removed, err := fiftyFifty(origin)
+		if err != nil {
+			return err
+		}
+		labels := make([]string, len(removed))
+		for i, n := range removed {
+			labels[i] = optionLabel(n - 1)
+		}
+		success.Printf("50:50 took away %s\n", strings.Join(labels, ", "))
+		return nil
+	case len(args) > 0:
+		return errors.New("Usage: options [shuffle|5050]")
+	}
+
+	info.Println("Type the options one per line: a text, img:<file> or both, * marks the correct one. An empty line ends.")
+	var typed []string
+	for {
+		line, ok := promptLine(optionLabel(len(typed)) + ")")
+		if !ok {
+			return errors.New("Input ended, the options are unchanged")
+		}
+		if strings.TrimSpace(line) == "" {
+			break
+		}
+		typed = append(typed, line)
+	}
+	options, correct, err := parseTypedOptions(typed)
+	if err != nil {
+		return err
+	}
+	if err := setLiveOptions(options, correct, origin); err != nil {
+		return err
+	}
+	success.Printf("%d options set\n", len(options))
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// uploadPicture sends a small PNG to /media as file.
+func uploadPicture(t *testing.T, s *TestServer, file string) MediaFile {
+	t.Helper()
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+	var f MediaFile
+	if status := uploadMedia(t, s, file, img.Bytes(), &f); status != http.StatusCreated {
+		t.Fatalf("upload: status %d", status)
+	}
+	return f
+}
+
+// uploadMedia sends data to /media as file and decodes the response into
+// out.
+func uploadMedia(t *testing.T, s *TestServer, file string, data []byte, out interface{}) int {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	w.Close()
+	req, err := http.NewRequest(http.MethodPost, s.URL+"/media", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("X-API-Key", testKey)
+	return s.Send(t, req, out).StatusCode
+}
+
+func cliCommand(t *testing.T, line string) error {
+	t.Helper()
+	_, err := runCommand(strings.Fields(line), "cli")
+	return err
+}
+
+func liveOptions() ([]AnswerOption, *MatchRules) {
+	questionMutex.RLock()
+	defer questionMutex.RUnlock()
+	return question.Options, question.Matching
+}
+
+// TestPictureOptions plays a question with a picture option through the
+// CLI syntax, the shuffle and the 50:50, judging answers along the way.
+func TestPictureOptions(t *testing.T) {
+	s := StartTestServer(t)
+	f := uploadPicture(t, s, "rim.png")
+	if f.File != "rim.png" {
+		t.Errorf("upload %+v", f)
+	}
+	if err := addTeam(Team{Name: "Sovy"}); err != nil {
+		t.Fatal(err)
+	}
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Rozohrievačka", "type": "pomoc", "time_left": 30_000_000_000})
+
+	if err := cliCommand(t, "question Ktoré mesto? | Paríž | img:chyba.png"); err == nil || !strings.Contains(err.Error(), "chyba.png") {
+		t.Errorf("an image that wasn't uploaded: %v", err)
+	}
+	if err := cliCommand(t, "question Ktoré mesto? | Paríž"); err == nil {
+		t.Error("a single option was taken")
+	}
+	if err := cliCommand(t, "question Ktoré mesto je na obrázku? | Paríž | *img:RIM.png | Viedeň | Berlín Brána"); err != nil {
+		t.Fatal(err)
+	}
+	options, m := liveOptions()
+	if len(options) != 4 || options[1] != (AnswerOption{ImageURL: f.URL}) || options[3].Text != "Berlín Brána" {
+		t.Fatalf("options %+v", options)
+	}
+	if m == nil || strings.Join(m.Accepted, ",") != "B,2" || correctOption(Question{Options: options, Matching: m}) != 1 {
+		t.Fatalf("matching %+v", m)
+	}
+
+	// The public payload keeps text options as strings.
+	var raw struct {
+		Options []json.RawMessage `json:"options"`
+	}
+	s.Do(t, http.MethodGet, "/get-question", nil, &raw)
+	if len(raw.Options) != 4 || string(raw.Options[0]) != `"Paríž"` || !strings.Contains(string(raw.Options[1]), `"image_url":"`+f.URL+`"`) {
+		t.Errorf("public options %s", raw.Options)
+	}
+
+	// The shuffle moves the accepted letter with the picture.
+	var shuffled struct {
+		Options []AnswerOption `json:"options"`
+	}
+	if status := s.Do(t, http.MethodPost, "/options/shuffle", nil, &shuffled); status != http.StatusOK || len(shuffled.Options) != 4 {
+		t.Fatalf("shuffle: status %d, %+v", status, shuffled)
+	}
+	options, m = liveOptions()
+	picture := -1
+	for i, o := range options {
+		if o.ImageURL != "" {
+			picture = i
+		}
+	}
+	if picture < 0 || correctOption(Question{Options: options, Matching: m}) != picture {
+		t.Fatalf("after the shuffle: options %+v, matching %+v", options, m)
+	}
+	if v := m.classify(Answer{Answer: optionLabel(picture)}); v.Status != verdictCorrect {
+		t.Errorf("the picture's new letter judged %s", v.Status)
+	}
+
+	// The 50:50 keeps the picture and one wrong option.
+	var round RoundView
+	if status := s.Do(t, http.MethodPost, "/options/fifty-fifty", nil, &round); status != http.StatusOK || len(round.RemovedOptions) != 2 {
+		t.Fatalf("50:50: status %d, %+v", status, round)
+	}
+	for _, n := range round.RemovedOptions {
+		if n-1 == picture {
+			t.Errorf("the 50:50 took the correct option %v", round.RemovedOptions)
+		}
+	}
+	var failed struct {
+		Error APIError `json:"error"`
+	}
+	if status := s.Do(t, http.MethodPost, "/v2/options/fifty-fifty", nil, &failed); status != http.StatusConflict || failed.Error.Code != "fifty_fifty_used" {
+		t.Errorf("a second 50:50: status %d, %+v", status, failed)
+	}
+	if status := s.Do(t, http.MethodPost, "/v2/answer", map[string]string{"team": "Sovy", "answer": optionLabel(round.RemovedOptions[0] - 1)}, &failed); status != http.StatusConflict || failed.Error.Code != "option_removed" {
+		t.Errorf("answering a removed option: status %d, %+v", status, failed)
+	}
+	s.MustDo(t, http.MethodPost, "/answer", map[string]string{"team": "Sovy", "answer": optionLabel(picture)})
+	if err := cliCommand(t, "options shuffle"); err == nil {
+		t.Error("shuffled after an answer")
+	}
+	if v := m.classify(listAnswers()[0]); v.Status != verdictCorrect {
+		t.Errorf("answering the picture judged %s", v.Status)
+	}
+}
+
+func TestPastedPictureOption(t *testing.T) {
+	s := StartTestServer(t)
+	f := uploadPicture(t, s, "viedenska-opera.png")
+
+	// Names survive a restart.
+	mediaMutex.Lock()
+	mediaFiles, mediaNames = map[string]MediaFile{}, map[string]string{}
+	mediaMutex.Unlock()
+	if err := loadMedia(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := parsePastedQuestion("Ktorá budova je na obrázku?\nA) Národné divadlo\n*B) img:viedenska-opera.png\nC) Opera img:viedenska-opera.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := p.Entry.Options
+	if len(o) != 3 || o[1] != (AnswerOption{ImageURL: f.URL}) || o[2] != (AnswerOption{Text: "Opera", ImageURL: f.URL}) {
+		t.Fatalf("options %+v", o)
+	}
+	if m := p.Entry.Matching; m == nil || strings.Join(m.Accepted, ",") != "B,2" {
+		t.Errorf("matching %+v", m)
+	}
+	if _, err := parsePastedQuestion("Otázka?\nA) Áno\nB) img:viedenska-opera.png img:viedenska-opera.png"); err == nil || !strings.Contains(err.Error(), "two images") {
+		t.Error("an option with two images was taken")
+	}
+}
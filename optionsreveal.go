@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// The options of a question with OptionsRevealAt stay hidden for that long
+// into the answer time, pauses excluded, rewarding teams who know the
+// answer outright. The expiry watcher reveals them with an
+// options_revealed event, so pausing or changing the countdown moves the
+// reveal with the answer clock. Once shown they stay shown.
+
+// optionsRevealed is when the options of the live question were revealed.
+// It is guarded by roundMutex and cleared by resetRound.
+var optionsRevealed *time.Time
+
+var errOptionsHidden = &RoundError{Code: "options_hidden", Message: "the options are not shown yet, answer with the text instead"}
+
+func validateOptionsReveal(q Question) error {
+	if q.OptionsRevealAt < 0 {
+		return fmt.Errorf("options_reveal_at must be non-negative")
+	}
+	if q.OptionsRevealAt > 0 && len(q.Options) == 0 {
+		return fmt.Errorf("options_reveal_at needs options")
+	}
+	if !q.CountUp && q.OptionsRevealAt > q.TimeLeft {
+		return fmt.Errorf("options_reveal_at must not exceed time_left")
+	}
+	return nil
+}
+
+// optionsHiddenFor reports whether the options of q are still hidden at
+// now, and how long until they show. Call with questionMutex held.
+func optionsHiddenFor(q Question, now time.Time) (time.Duration, bool) {
+	if q.OptionsRevealAt <= 0 || len(q.Options) == 0 || q.Type == "end" || q.Type == "waiting" {
+		return 0, false
+	}
+	roundMutex.Lock()
+	defer roundMutex.Unlock()
+	if optionsRevealed != nil {
+		return 0, false
+	}
+	if answeringAt.IsZero() {
+		// The reveal comes after what is left of the reading time.
+		left := q.OptionsRevealAt
+		if q.Reading {
+			left += max(q.ReadingTime-clockElapsed(q, now), 0)
+		}
+		return left, true
+	}
+	// Past the point but not yet revealed by the watcher counts as shown,
+	// so the payload never lags behind the event.
+	left := q.OptionsRevealAt - answerLatency(now, q.Paused, pausedAt)
+	return left, left > 0
+}
+
+// optionsRevealDeadline returns when the options of q are due, if they are
+// hidden and the answer clock runs. Call with questionMutex held.
+func optionsRevealDeadline(q Question) (time.Time, bool) {
+	if q.OptionsRevealAt <= 0 || len(q.Options) == 0 || q.Paused || q.Reading || q.Type == "end" || q.Type == "waiting" {
+		return time.Time{}, false
+	}
+	roundMutex.Lock()
+	defer roundMutex.Unlock()
+	if optionsRevealed != nil || answeringAt.IsZero() {
+		return time.Time{}, false
+	}
+	return answeringAt.Add(answerPaused + q.OptionsRevealAt), true
+}
+
+// revealOptions shows the options once they are due and tells every
+// client with an options_revealed event.
+func revealOptions() {
+	questionMutex.RLock()
+	deadline, ok := optionsRevealDeadline(question)
+	options := question.Options
+	questionMutex.RUnlock()
+	if !ok || clock.Now().Before(deadline) {
+		return
+	}
+	roundMutex.Lock()
+	if optionsRevealed != nil {
+		roundMutex.Unlock()
+		return
+	}
+	now := clock.Now()
+	optionsRevealed = &now
+	roundMutex.Unlock()
+
+	audit("options_reveal", "timer", map[string]interface{}{"late": now.Sub(deadline).Seconds()})
+	hub.broadcast(Event{Type: "options_revealed", Data: map[string]interface{}{"time": now, "options": options}})
+	roundChanged("options_reveal")
+	go sendUrgentQuestion()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestOptionsReveal hides the options for 15s of a 45s question and checks
+// the public payload, answers by letter, a pause and the reveal.
+func TestOptionsReveal(t *testing.T) {
+	s := StartTestServer(t)
+	for _, name := range []string{"Sovy", "Líšky"} {
+		if err := addTeam(Team{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	options := []AnswerOption{{Text: "Dunaj"}, {Text: "Váh"}, {Text: "Hron"}}
+	if status := s.Do(t, http.MethodPost, "/v2/set-question", map[string]interface{}{
+		"question": "Najdlhšia rieka?", "type": "pomoc", "time_left": 10, "options_reveal_at": 15, "options": options,
+	}, nil); status != http.StatusBadRequest {
+		t.Errorf("a reveal after the end: status %d", status)
+	}
+	sub := hub.subscribe("test", false)
+	defer hub.unsubscribe(sub)
+	s.MustDo(t, http.MethodPost, "/v2/set-question", map[string]interface{}{
+		"question": "Najdlhšia rieka?", "type": "pomoc", "time_left": 45, "options_reveal_at": 15, "options": options,
+	})
+
+	public := func() PublicQuestionView {
+		t.Helper()
+		var q PublicQuestionView
+		s.Do(t, http.MethodGet, "/get-question", nil, &q)
+		return q
+	}
+	if q := public(); q.Options != nil || q.OptionsRevealIn != 15*time.Second {
+		t.Errorf("hidden: options %v, in %s", q.Options, q.OptionsRevealIn)
+	}
+	var op OperatorQuestionView
+	s.Do(t, http.MethodGet, "/get-question/full", nil, &op)
+	if len(op.Options) != 3 {
+		t.Errorf("the operator sees %v", op.Options)
+	}
+	s.Flask.WaitFor(t, "the question pushed without options", func(p map[string]interface{}) bool {
+		return p["question"] == "Najdlhšia rieka?" && p["options"] == nil
+	})
+
+	answer := func(text string) (int, string) {
+		t.Helper()
+		req := s.NewRequest(t, http.MethodPost, "/v2/answer", map[string]string{"team": "Sovy", "answer": text})
+		req.Header.Del("X-API-Key")
+		var res struct {
+			Error APIError `json:"error"`
+		}
+		return s.Send(t, req, &res).StatusCode, res.Error.Code
+	}
+	for _, pick := range []string{"A", "2"} {
+		if status, code := answer(pick); status != http.StatusConflict || code != "options_hidden" {
+			t.Errorf("%s while hidden: status %d, %s", pick, status, code)
+		}
+	}
+	if status, code := answer("Dunaj"); status != http.StatusOK {
+		t.Errorf("the text while hidden: status %d, %s", status, code)
+	}
+
+	// Ten seconds in, a minute of pause and more time leave five to go.
+	AdvanceClock(t, 10*time.Second)
+	s.MustDo(t, http.MethodPost, "/pause", map[string]string{"reason": "tech"})
+	AdvanceClock(t, time.Minute)
+	s.MustDo(t, http.MethodPost, "/resume", nil)
+	s.MustDo(t, http.MethodPost, "/time/adjust", map[string]int{"delta_seconds": 10})
+	if q := public(); q.Options != nil || q.OptionsRevealIn != 5*time.Second {
+		t.Errorf("after the pause: options %v, in %s", q.Options, q.OptionsRevealIn)
+	}
+	drain(sub)
+
+	// The watcher may still be waiting for the old deadline.
+	eventually(t, "the options revealed", func() bool {
+		AdvanceClock(t, time.Second)
+		return public().Options != nil
+	})
+	if got := fmt.Sprint(public().Options); got != "[Dunaj Váh Hron]" {
+		t.Errorf("revealed %s", got)
+	}
+	revealed := false
+	for _, ty := range drain(sub) {
+		revealed = revealed || ty == "options_revealed"
+	}
+	if !revealed {
+		t.Error("no options_revealed event")
+	}
+	lastAudit(t, "options_reveal")
+	s.Flask.WaitFor(t, "the options pushed", func(p map[string]interface{}) bool {
+		options, _ := p["options"].([]interface{})
+		return len(options) == 3
+	})
+	if status, code := answer("B"); status != http.StatusOK {
+		t.Errorf("a letter once shown: status %d, %s", status, code)
+	}
+}
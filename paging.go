@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	pageChars     = flag.Int("page-chars", 0, "split question text longer than this many characters into pages (0 disables)")
+	pageLines     = flag.Int("page-lines", 0, "split question text that wraps to more than this many lines into pages (0 disables)")
+	pageLineWidth = flag.Int("page-line-width", 40, "characters per display line, for -page-lines")
+)
+
+// paginate splits text into pages that fit the display budget, breaking at
+// word boundaries. A word too long for a page on its own is cut.
+func paginate(text string, chars, lines, width int) []string {
+	limit := pageRuneLimit(chars, lines, width)
+	if limit == 0 || fitsPage(text, chars, lines, width) {
+		return []string{text}
+	}
+	var pages []string
+	var page []string
+	for _, word := range strings.Fields(text) {
+		for _, piece := range cutWord(word, limit) {
+			if len(page) > 0 && !fitsPage(strings.Join(append(page, piece), " "), chars, lines, width) {
+				pages = append(pages, strings.Join(page, " "))
+				page = nil
+			}
+			page = append(page, piece)
+		}
+	}
+	if len(page) > 0 {
+		pages = append(pages, strings.Join(page, " "))
+	}
+	if len(pages) == 0 {
+		return []string{text}
+	}
+	return pages
+}
+
+// pageRuneLimit is the most characters a single word may have on a page,
+// or 0 when paging is off.
+func pageRuneLimit(chars, lines, width int) int {
+	limit := 0
+	if chars > 0 {
+		limit = chars
+	}
+	if lines > 0 && width > 0 && (limit == 0 || lines*width < limit) {
+		limit = lines * width
+	}
+	return limit
+}
+
+func fitsPage(text string, chars, lines, width int) bool {
+	if chars > 0 && utf8.RuneCountInString(text) > chars {
+		return false
+	}
+	return lines <= 0 || width <= 0 || wrappedLines(text, width) <= lines
+}
+
+// wrappedLines estimates how many lines text takes when wrapped at width,
+// the way a browser wraps words.
+func wrappedLines(text string, width int) int {
+	n, used := 0, 0
+	for _, word := range strings.Fields(text) {
+		l := utf8.RuneCountInString(word)
+		switch {
+		case used > 0 && used+1+l <= width:
+			used += 1 + l
+		case l > width:
+			n += (l + width - 1) / width
+			used = l % width
+			if used == 0 {
+				used = width
+			}
+		default:
+			n++
+			used = l
+		}
+	}
+	return n
+}
+
+// cutWord splits word into pieces of at most limit characters.
+func cutWord(word string, limit int) []string {
+	r := []rune(word)
+	if len(r) <= limit {
+		return []string{word}
+	}
+	var out []string
+	for len(r) > limit {
+		out = append(out, string(r[:limit]))
+		r = r[limit:]
+	}
+	return append(out, string(r))
+}
+
+func questionPages(text string) []string {
+	return paginate(text, *pageChars, *pageLines, *pageLineWidth)
+}
+
+// applyPage replaces the view's text with the stored question's current
+// page. A question that fits on one page is left alone.
+func applyPage(q *PublicQuestionView, stored Question) {
+	pages := questionPages(stored.Question)
+	if len(pages) < 2 {
+		return
+	}
+	page := min(stored.Page, len(pages)-1)
+	q.Question = pages[page]
+	q.Page = page + 1
+	q.TotalPages = len(pages)
+	if len(stored.Variants) > 0 {
+		q.Variants = make(map[string]string, len(stored.Variants))
+		for locale, text := range stored.Variants {
+			vp := questionPages(text)
+			q.Variants[locale] = vp[min(page, len(vp)-1)]
+		}
+	}
+}
+
+// nextPage shows the next page of the live question. The timer is not
+// touched.
+func nextPage(origin string) (int, int, error) {
+	questionMutex.Lock()
+	total := len(questionPages(question.Question))
+	if question.Page+1 >= total {
+		questionMutex.Unlock()
+		return 0, total, fmt.Errorf("the question is already on its last page")
+	}
+	question.Page++
+	page := question.Page + 1
+	stateChanged("page")
+	questionMutex.Unlock()
+
+	audit("page", origin, map[string]interface{}{"page": page, "total": total})
+	go sendCurrentQuestion()
+	return page, total, nil
+}
+
+// fullQuestion is q's text for the operator when the audience sees only a
+// page of it.
+func fullQuestion(q Question) string {
+	if len(questionPages(q.Question)) < 2 {
+		return ""
+	}
+	return q.Question
+}
+
+func postPageNext(c echo.Context) error {
+	if _, _, err := nextPage(requestOrigin(c)); err != nil {
+		return conflict(err.Error())
+	}
+	questionMutex.RLock()
+	defer questionMutex.RUnlock()
+	return c.JSON(http.StatusOK, questionView(c, question))
+}
+
+func handlePageCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	if len(args) != 0 {
+		return errors.New("Usage: page")
+	}
+	page, total, err := nextPage(origin)
+	if err != nil {
+		return err
+	}
+	success.Printf("Showing page %d of %d\n", page, total)
+	return nil
+}
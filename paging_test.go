@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	for _, tc := range []struct {
+		text                string
+		chars, lines, width int
+		want                []string
+	}{
+		{"Krátka otázka", 0, 0, 40, []string{"Krátka otázka"}},
+		{"Krátka otázka", 20, 0, 40, []string{"Krátka otázka"}},
+		// Pages break between words and count characters, not bytes.
+		{"Ktorá rieka tečie cez Bratislavu?", 12, 0, 40, []string{"Ktorá rieka", "tečie cez", "Bratislavu?"}},
+		// At ten characters a line "Ktorá rieka" wraps to two lines, and so
+		// does "Bratislavu?" on its own.
+		{"Ktorá rieka tečie cez Bratislavu?", 0, 2, 10, []string{"Ktorá rieka", "tečie cez", "Bratislavu?"}},
+		// A word longer than a page is cut.
+		{"Pneumonoultramicroscopic", 10, 0, 40, []string{"Pneumonoul", "tramicrosc", "opic"}},
+	} {
+		if got := paginate(tc.text, tc.chars, tc.lines, tc.width); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q at %d/%d/%d: got %q, want %q", tc.text, tc.chars, tc.lines, tc.width, got, tc.want)
+		}
+	}
+}
+
+// TestQuestionPages shows a long question a page at a time to the audience
+// and whole to the operator.
+func TestQuestionPages(t *testing.T) {
+	s := StartTestServer(t)
+	defer func(chars int) { *pageChars = chars }(*pageChars)
+	*pageChars = 12
+	text := "Ktorá rieka tečie cez Bratislavu?"
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{
+		"question": text, "type": "pomoc", "time_left": 30_000_000_000,
+		"variants": map[string]string{"en": "Which river flows through Bratislava?"},
+	})
+
+	var public PublicQuestionView
+	get := func(path string) {
+		t.Helper()
+		req := s.NewRequest(t, http.MethodGet, path, nil)
+		req.Header.Del("X-API-Key")
+		public = PublicQuestionView{}
+		s.Send(t, req, &public)
+	}
+	get("/get-question")
+	if public.Question != "Ktorá rieka" || public.Page != 1 || public.TotalPages != 3 {
+		t.Fatalf("first page %q, %d of %d", public.Question, public.Page, public.TotalPages)
+	}
+	get("/get-question?lang=en")
+	if public.Question != "Which river" {
+		t.Errorf("first English page %q", public.Question)
+	}
+
+	var operator OperatorQuestionView
+	s.Do(t, http.MethodPost, "/page/next", nil, &operator)
+	if operator.Page != 2 || operator.Question != "tečie cez" || operator.FullQuestion != text {
+		t.Errorf("the operator sees page %d %q of %q", operator.Page, operator.Question, operator.FullQuestion)
+	}
+	if err := cliCommand(t, "page"); err != nil {
+		t.Fatal(err)
+	}
+	get("/get-question")
+	if public.Question != "Bratislavu?" || public.Page != 3 {
+		t.Errorf("last page %q, %d", public.Question, public.Page)
+	}
+	if e := lastAudit(t, "page"); e.Origin != "cli" {
+		t.Errorf("page audited as %+v", e)
+	}
+	if status := s.Do(t, http.MethodPost, "/page/next", nil, nil); status != http.StatusConflict {
+		t.Errorf("past the last page: status %d", status)
+	}
+	s.Flask.WaitFor(t, "the last page pushed", func(p map[string]interface{}) bool {
+		return p["question"] == "Bratislavu?" && p["page"] == 3.0 && p["total_pages"] == 3.0
+	})
+
+	req := s.NewRequest(t, http.MethodPost, "/page/next", nil)
+	req.Header.Del("X-API-Key")
+	if resp := s.Send(t, req, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("paging without a key: status %d", resp.StatusCode)
+	}
+
+	// A new question starts on its first page.
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": text + " A prečo?", "type": "pomoc", "time_left": 30_000_000_000})
+	get("/get-question")
+	if public.Page != 1 || public.TotalPages != 4 {
+		t.Errorf("new question on page %d of %d", public.Page, public.TotalPages)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// Writers send questions as loose text blocks: the question first, then
+// options like "A) Paris", "b. Rím" or "C) img:rim.jpg", the correct one
+// marked with an asterisk, and a time such as "30s" anywhere. "paste" and POST
+// /stage/parse turn such a block into a queue entry.
+
+// defaultPasteTime is the time of a pasted question that gives none when
+// no preset is named after its type.
+const defaultPasteTime = 30 * time.Second
+
+// PastedQuestion is what parsePastedQuestion made of a block. Understood
+// lists what was read as written, Guessed what was assumed or filled in.
+type PastedQuestion struct {
+	Entry      QueueEntry `json:"entry"`
+	Correct    *int       `json:"correct,omitempty"`
+	Understood []string   `json:"understood"`
+	Guessed    []string   `json:"guessed"`
+	Staged     bool       `json:"staged"`
+}
+
+var (
+	// pasteOption matches an option line: an optional Slovak or English
+	// word, a letter or number, then ")", ".", ":" or "-".
+	pasteOption = regexp.MustCompile(`(?i)^(?:(?:možnosť|moznost|odpoveď|odpoved|option)\s+)?(\*\s*)?([a-f]|[1-6])\s*[).:\-]\s*(.*)$`)
+	// pasteTime matches a time like "30s", "45 sek", "2 min" or "1:30".
+	pasteTime = regexp.MustCompile(`(?i)(?:^|[\s(\[])((\d+)\s*(sekúnd|sekundy|sekunda|sekund|sek|sec|s|minúty|minút|minúta|minuty|minut|minuta|min)|(\d{1,2}):(\d{2}))\.?(?:$|[\s)\],;])`)
+	// pasteClock matches a line that is only a time like "1:30".
+	pasteClock = regexp.MustCompile(`^\d{1,2}:\d{2}$`)
+	// pasteField matches a "label: value" line for the type or time.
+	pasteField = regexp.MustCompile(`(?i)^(typ|type|čas|cas|time)\s*[:=]\s*(.+)$`)
+)
+
+// parsePastedQuestion reads a pasted block. It fails when the block can't
+// make a question; whatever it had to assume is reported in Guessed.
+func parsePastedQuestion(block string) (PastedQuestion, error) {
+	out := PastedQuestion{Understood: []string{}, Guessed: []string{}}
+	e := &out.Entry
+
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(block, "\r\n", "\n"), "\n") {
+		if line = strings.TrimSpace(strings.TrimSuffix(line, "\r")); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return out, fmt.Errorf("the block is empty")
+	}
+
+	var timeLeft time.Duration
+	var qtype string
+	var question, notes []string
+	var marked []int
+	for i, line := range lines {
+		if m := pasteField.FindStringSubmatch(line); m != nil {
+			switch strings.ToLower(m[1]) {
+			case "typ", "type":
+				t := strings.ToLower(strings.TrimSpace(m[2]))
+				if !validQuestionType(t) {
+					return out, fmt.Errorf("line %d: unknown type %q, valid: %s", i+1, t, strings.Join(questionTypeNames(), ", "))
+				}
+				qtype = t
+				out.Understood = append(out.Understood, "type "+t)
+			default:
+				// "čas: 45 sek" reads like a time anywhere else.
+				value := strings.TrimSpace(m[2])
+				d, rest, ok := cutPastedTime(value)
+				if !ok || rest != "" {
+					var err error
+					if d, err = parseDurationArg(value); err != nil {
+						return out, fmt.Errorf("line %d: %v", i+1, err)
+					}
+				}
+				timeLeft = d
+				out.Understood = append(out.Understood, "time "+d.String())
+			}
+			continue
+		}
+		// The first line is the question even if it looks like an option.
+		// Nor is a line that is only a time: "1:30" is not option 1.
+		var m []string
+		if (i > 0 || len(question) > 0) && !pasteClock.MatchString(line) {
+			m = pasteOption.FindStringSubmatch(line)
+		}
+		if m != nil {
+			o, star, err := parseOptionInput(m[3])
+			if err != nil {
+				return out, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			star = star || m[1] != ""
+			if o.Text == "" && o.ImageURL == "" {
+				return out, fmt.Errorf("line %d: option %s has no text or image", i+1, m[2])
+			}
+			if want := optionLabel(len(e.Options)); !strings.EqualFold(m[2], want) && m[2] != strconv.Itoa(len(e.Options)+1) {
+				out.Guessed = append(out.Guessed, fmt.Sprintf("option %s taken as %s, the options are kept in the order given", m[2], want))
+			}
+			if star {
+				marked = append(marked, len(e.Options))
+			}
+			e.Options = append(e.Options, o)
+			continue
+		}
+		// Options are left alone: "C) 30 s" is an answer, not the time.
+		if timeLeft == 0 {
+			if d, rest, ok := cutPastedTime(line); ok {
+				timeLeft = d
+				out.Understood = append(out.Understood, fmt.Sprintf("time %s from line %d", d, i+1))
+				if line = rest; line == "" {
+					continue
+				}
+			}
+		}
+		if len(e.Options) > 0 {
+			notes = append(notes, line)
+			continue
+		}
+		if len(question) > 0 {
+			out.Guessed = append(out.Guessed, fmt.Sprintf("line %d joined to the question", i+1))
+		}
+		question = append(question, line)
+	}
+
+	e.Question = strings.Join(question, " ")
+	if e.Question == "" {
+		return out, fmt.Errorf("the block has no question text")
+	}
+	out.Understood = append(out.Understood, "question "+strconv.Quote(e.Question))
+
+	if qtype == "" {
+		qtype = "pomoc"
+		out.Guessed = append(out.Guessed, "type pomoc, none given")
+	}
+	e.Type = qtype
+	if timeLeft == 0 {
+		if d, err := lookupPreset(qtype); err == nil {
+			timeLeft = d
+			out.Guessed = append(out.Guessed, fmt.Sprintf("time %s from the %s preset, none given", d, qtype))
+		} else {
+			timeLeft = defaultPasteTime
+			out.Guessed = append(out.Guessed, fmt.Sprintf("time %s, none given", timeLeft))
+		}
+	}
+	e.TimeLeft = timeLeft
+
+	switch {
+	case len(e.Options) == 0:
+		out.Guessed = append(out.Guessed, "no options, staged as an open question")
+	case len(e.Options) == 1:
+		return out, fmt.Errorf("only one option found, a question needs two or none")
+	default:
+		labels := make([]string, len(e.Options))
+		for i, o := range e.Options {
+			labels[i] = optionLabel(i) + ") " + o.String()
+		}
+		out.Understood = append(out.Understood, fmt.Sprintf("%d options: %s", len(e.Options), strings.Join(labels, ", ")))
+	}
+	switch {
+	case len(marked) > 1:
+		return out, fmt.Errorf("%d options are marked correct, mark one", len(marked))
+	case len(marked) == 1:
+		c := marked[0]
+		out.Correct = &c
+		e.Matching = optionMatching(e.Options, c)
+		out.Understood = append(out.Understood, fmt.Sprintf("correct answer %s) %s", optionLabel(c), e.Options[c]))
+	case len(e.Options) > 0:
+		out.Guessed = append(out.Guessed, "no option marked correct, answers are judged by hand")
+	}
+	if len(notes) > 0 {
+		e.Notes = strings.Join(notes, "\n")
+		out.Guessed = append(out.Guessed, fmt.Sprintf("%d line(s) after the options kept as notes", len(notes)))
+	}
+	return out, nil
+}
+
+// cutPastedTime finds a time in line, returning it and the line without it.
+func cutPastedTime(line string) (time.Duration, string, bool) {
+	loc := pasteTime.FindStringSubmatchIndex(line)
+	if loc == nil {
+		return 0, line, false
+	}
+	sub := func(n int) string {
+		if loc[2*n] < 0 {
+			return ""
+		}
+		return line[loc[2*n]:loc[2*n+1]]
+	}
+	var d time.Duration
+	if sub(2) != "" {
+		n, _ := strconv.Atoi(sub(2))
+		d = time.Duration(n) * time.Second
+		if u := strings.ToLower(sub(3)); strings.HasPrefix(u, "m") {
+			d = time.Duration(n) * time.Minute
+		}
+	} else {
+		m, _ := strconv.Atoi(sub(4))
+		s, _ := strconv.Atoi(sub(5))
+		d = time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	}
+	if d <= 0 {
+		return 0, line, false
+	}
+	rest := line[:loc[2]] + line[loc[3]:]
+	rest = strings.TrimSpace(strings.Trim(strings.TrimSpace(rest), "()[],;"))
+	return d, rest, true
+}
+
+func optionLabel(i int) string {
+	return string(rune('A' + i))
+}
+
+// stagePasted parses block and, unless dryRun, adds it to the queue.
+func stagePasted(block string, dryRun bool, origin string) (PastedQuestion, error) {
+	p, err := parsePastedQuestion(block)
+	if err != nil {
+		return p, err
+	}
+	if err := validateQuestion(p.Entry.question()); err != nil {
+		return p, err
+	}
+	if dryRun {
+		return p, nil
+	}
+	added, err := enqueue([]QueueEntry{p.Entry}, origin)
+	if err != nil {
+		return p, err
+	}
+	p.Entry, p.Staged = added[0], true
+	audit("paste", origin, map[string]interface{}{"id": p.Entry.ID, "guessed": len(p.Guessed)})
+	return p, nil
+}
+
+// PasteRequest is the body of POST /stage/parse: the block as written.
+// DryRun, also accepted as ?dry_run=true, only parses it.
+type PasteRequest struct {
+	Text   string `json:"text"`
+	DryRun bool   `json:"dry_run"`
+}
+
+func postStageParse(c echo.Context) error {
+	req := new(PasteRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	p, err := stagePasted(req.Text, req.DryRun || c.QueryParam("dry_run") == "true", requestOrigin(c))
+	if err != nil {
+		return apiError(http.StatusUnprocessableEntity, "unparsable_question", err.Error()).
+			withDetail("understood", p.Understood).withDetail("guessed", p.Guessed)
+	}
+	return c.JSON(http.StatusOK, p)
+}
+
+// handlePasteCommand runs "paste": it reads lines up to a lone "." and
+// stages what they say.
+func handlePasteCommand(origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	info.Println("Paste the question, then a line with a single \".\"")
+	var lines []string
+	for {
+		line, ok := promptLine(">")
+		if !ok {
+			return errors.New("Input ended before the closing \".\", nothing staged")
+		}
+		if strings.TrimSpace(line) == "." {
+			break
+		}
+		lines = append(lines, line)
+	}
+	p, err := stagePasted(strings.Join(lines, "\n"), false, origin)
+	for _, s := range p.Understood {
+		info.Printf("  understood: %s\n", s)
+	}
+	for _, s := range p.Guessed {
+		info.Printf("  guessed:    %s\n", s)
+	}
+	if err != nil {
+		return err
+	}
+	success.Printf("Queued as #%d\n", p.Entry.ID)
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePastedQuestion(t *testing.T) {
+	if err := savePreset("rozstrel", 20*time.Second, "test"); err != nil {
+		t.Fatal(err)
+	}
+	defer deletePreset("rozstrel", "test")
+
+	// summary reads a parsed block as question|type|time|options|correct.
+	summary := func(p PastedQuestion) string {
+		var options []string
+		for _, o := range p.Entry.Options {
+			options = append(options, o.String())
+		}
+		correct := "-"
+		if p.Correct != nil {
+			correct = optionLabel(*p.Correct)
+		}
+		return fmt.Sprintf("%s|%s|%s|%s|%s", p.Entry.Question, p.Entry.Type, p.Entry.TimeLeft, strings.Join(options, ","), correct)
+	}
+	for _, tc := range []struct {
+		name, block string
+		want        string
+		guessed     int
+	}{
+		{"marked option", "Hlavné mesto Francúzska? 30s\nA) Paríž*\nB) Rím\nC) Berlín", "Hlavné mesto Francúzska?|pomoc|30s|Paríž,Rím,Berlín|A", 1},
+		{"Windows and Slovak", "Koľko nôh má pavúk?\r\nMožnosť a: šesť\r\nMožnosť b: *osem\r\nčas: 45 sek\r\n", "Koľko nôh má pavúk?|pomoc|45s|šesť,osem|B", 1},
+		{"numbers and a preset", "typ: rozstrel\nKto napísal Hamleta?\n1- Shakespeare *\n2- Goethe", "Kto napísal Hamleta?|rozstrel|20s|Shakespeare,Goethe|A", 1},
+		{"open question", "Ako sa volá\nnajvyšší vrch Slovenska?", "Ako sa volá najvyšší vrch Slovenska?|pomoc|30s||-", 4},
+		{"time line", "Ktorá rieka?\n1:30\nA) Dunaj\nB) Váh", "Ktorá rieka?|pomoc|1m30s|Dunaj,Váh|-", 2},
+		{"option time", "Koľko trvá polčas? (2 min)\na. 45 min\nb. 30 s *", "Koľko trvá polčas?|pomoc|2m0s|45 min,30 s|B", 1},
+		{"letters out of order", "Farba neba?\nA) modrá*\nC) zelená\nZdroj: učebnica", "Farba neba?|pomoc|30s|modrá,zelená|A", 4},
+	} {
+		p, err := parsePastedQuestion(tc.block)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := summary(p); got != tc.want || len(p.Guessed) != tc.guessed {
+			t.Errorf("%s: %s, guessed %q\nwant %s, %d guessed", tc.name, got, p.Guessed, tc.want, tc.guessed)
+		}
+	}
+
+	for _, block := range []string{
+		"",
+		"\r\n\r\n",
+		"typ: pomoc\n30s",
+		"Otázka?\nA) jedna",
+		"Otázka?\nA) jedna*\nB) dve*",
+		"typ: kviz\nOtázka?",
+		"Otázka?\nčas: dlho",
+	} {
+		if p, err := parsePastedQuestion(block); err == nil {
+			t.Errorf("%q parsed as %s", block, summary(p))
+		}
+	}
+}
+
+// TestStageParse pastes over HTTP, first as a dry run.
+func TestStageParse(t *testing.T) {
+	s := StartTestServer(t)
+	block := "Hlavné mesto?\nA) Bratislava *\nB) Košice"
+	var p PastedQuestion
+	if status := s.Do(t, http.MethodPost, "/stage/parse?dry_run=true", PasteRequest{Text: block}, &p); status != http.StatusOK || p.Staged || len(queueSnapshot().Entries) != 0 {
+		t.Errorf("a dry run: status %d, %+v", status, p)
+	}
+	s.Do(t, http.MethodPost, "/stage/parse", PasteRequest{Text: block}, &p)
+	entries := queueSnapshot().Entries
+	if !p.Staged || len(entries) != 1 || entries[0].ID != p.Entry.ID || entries[0].Matching == nil {
+		t.Errorf("staged %+v, queue %+v", p, entries)
+	}
+	if e := lastAudit(t, "paste"); e.Details["id"] != p.Entry.ID {
+		t.Errorf("audited as %+v", e)
+	}
+
+	req := s.NewRequest(t, http.MethodPost, "/v2/stage/parse", PasteRequest{Text: "Otázka?\nA) jedna"})
+	var res struct {
+		Error APIError `json:"error"`
+	}
+	if status := s.Send(t, req, &res).StatusCode; status != http.StatusUnprocessableEntity || res.Error.Code != "unparsable_question" || res.Error.Details["understood"] == nil {
+		t.Errorf("one option: status %d, %+v", status, res.Error)
+	}
+}
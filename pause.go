@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// PauseRequest is the body accepted by POST /pause.
+type PauseRequest struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// PauseTotal is the accumulated paused time for one pause reason.
+type PauseTotal struct {
+	Reason   string        `json:"reason"`
+	Count    int           `json:"count"`
+	Duration time.Duration `json:"duration"`
+}
+
+// pausedAt is when the current pause began. Guarded by questionMutex.
+var pausedAt time.Time
+
+func pauseQuestion(reason, message, origin string) error {
+	questionMutex.Lock()
+	defer questionMutex.Unlock()
+	if question.Paused {
+		return fmt.Errorf("question is already paused")
+	}
+	freezeClock(&question)
+	question.Paused = true
+	question.PauseReason = reason
+	question.PauseMessage = message
+	pausedAt = clock.Now()
+	stateChanged("pause")
+	audit("pause", origin, map[string]interface{}{
+		"reason":  reason,
+		"message": message,
+	})
+	recordPauseUndo(reason, message, origin)
+	go sendUrgentQuestion()
+	return nil
+}
+
+// freezeClock stores the running clock of q in it as of now: what is left
+// of the reading or answer time, or the elapsed time when counting up. A
+// paused question shows that value and resume carries on from it.
+func freezeClock(q *Question) {
+	now := clock.Now()
+	elapsed := clockElapsed(*q, now)
+	switch {
+	case q.Reading:
+		q.ReadingTime -= elapsed
+		if q.ReadingTime < 0 {
+			q.ReadingTime = 0
+		}
+	case q.CountUp:
+		if !q.Stalled {
+			q.TimeLeft = elapsed
+		}
+	case q.Type != "end" && q.Type != "waiting":
+		q.TimeLeft = timeRemaining(*q, now)
+		if q.TimeLeft < 0 && !q.AllowOvertime {
+			q.TimeLeft = 0
+		}
+	}
+}
+
+func resumeQuestion(origin string) error {
+	questionMutex.Lock()
+	defer questionMutex.Unlock()
+	if !question.Paused {
+		return fmt.Errorf("question is not paused")
+	}
+	paused := clock.Since(pausedAt)
+	answerClockResumed(pausedAt, clock.Now())
+	reason, message := question.PauseReason, question.PauseMessage
+	question.Paused = false
+	question.PauseReason = ""
+	question.PauseMessage = ""
+	resumeTimer(&question)
+	stateChanged("resume")
+	audit("resume", origin, map[string]interface{}{
+		"reason":   reason,
+		"duration": paused.Seconds(),
+	})
+	recordResumeUndo(reason, message, origin)
+	go sendUrgentQuestion()
+	return nil
+}
+
+// endedPause is a pause as its resume was audited.
+type endedPause struct {
+	at       time.Time
+	reason   string
+	duration time.Duration
+}
+
+// endedPauses keeps the finished pauses of the game, oldest first, for the
+// totals. resetGame clears it.
+var (
+	endedPauses      []endedPause
+	endedPausesMutex sync.Mutex
+)
+
+// observePauses notes a pause when its resume is audited.
+func observePauses(e AuditEntry) {
+	if e.Action != "resume" {
+		return
+	}
+	reason, _ := e.Details["reason"].(string)
+	endedPausesMutex.Lock()
+	endedPauses = append(endedPauses, endedPause{at: e.Time, reason: reason, duration: detailSeconds(e.Details["duration"])})
+	endedPausesMutex.Unlock()
+}
+
+func resetPauseTotals() {
+	endedPausesMutex.Lock()
+	endedPauses = nil
+	endedPausesMutex.Unlock()
+}
+
+// pauseTotals sums paused time per reason, including the pause that is
+// currently in progress.
+func pauseTotals() []PauseTotal {
+	return pauseTotalsSince(time.Time{})
+}
+
+// pauseTotalsSince is pauseTotals for the pauses that ended after since.
+func pauseTotalsSince(since time.Time) []PauseTotal {
+	totals := map[string]*PauseTotal{}
+	add := func(reason string, d time.Duration) {
+		t, ok := totals[reason]
+		if !ok {
+			t = &PauseTotal{Reason: reason}
+			totals[reason] = t
+		}
+		t.Count++
+		t.Duration += d
+	}
+	endedPausesMutex.Lock()
+	for _, p := range endedPauses {
+		if !p.at.Before(since) {
+			add(p.reason, p.duration)
+		}
+	}
+	endedPausesMutex.Unlock()
+
+	questionMutex.RLock()
+	if question.Paused {
+		add(question.PauseReason, clock.Since(pausedAt))
+	}
+	questionMutex.RUnlock()
+
+	out := make([]PauseTotal, 0, len(totals))
+	for _, t := range totals {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Duration > out[j].Duration })
+	return out
+}
+
+// parsePauseArgs splits the CLI arguments of `time pause` into a reason and a
+// message. A leading quoted string is the message alone; otherwise the first
+// word is the reason and the rest is the message.
+func parsePauseArgs(args []string) (reason, message string) {
+	if len(args) == 0 {
+		return "", ""
+	}
+	if !strings.HasPrefix(args[0], `"`) {
+		reason, args = args[0], args[1:]
+	}
+	message = strings.Trim(strings.Join(args, " "), `"`)
+	return reason, message
+}
+
+func postPause(c echo.Context) error {
+	req := new(PauseRequest)
+	if err := bindOptionalJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if err := pauseQuestion(req.Reason, req.Message, requestOrigin(c)); err != nil {
+		return conflict(err.Error())
+	}
+	return getQuestion(c)
+}
+
+func postResume(c echo.Context) error {
+	if err := resumeQuestion(requestOrigin(c)); err != nil {
+		return conflict(err.Error())
+	}
+	return getQuestion(c)
+}
+
+func getPauses(c echo.Context) error {
+	return c.JSON(http.StatusOK, pauseTotals())
+}
@@ -0,0 +1,172 @@
+// Package client has helpers for programs that talk to the quiz server.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyncSamples is how many samples Sync takes per estimate.
+const SyncSamples = 5
+
+// TimeSyncReply is the body of GET /time-sync.
+type TimeSyncReply struct {
+	Nonce    string `json:"n,omitempty"`
+	Index    int    `json:"i,omitempty"`
+	Receive  int64  `json:"rx"`
+	Transmit int64  `json:"tx"`
+}
+
+// Transport performs one time-sync exchange with the server.
+type Transport func(ctx context.Context, index int, nonce string) (TimeSyncReply, error)
+
+// HTTPTransport queries GET /time-sync on base, e.g. "http://10.0.0.2:8080".
+// A nil client means http.DefaultClient.
+func HTTPTransport(base string, client *http.Client) Transport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	base = strings.TrimRight(base, "/")
+	return func(ctx context.Context, index int, nonce string) (TimeSyncReply, error) {
+		q := url.Values{"n": {nonce}, "i": {strconv.Itoa(index)}}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/time-sync?"+q.Encode(), nil)
+		if err != nil {
+			return TimeSyncReply{}, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return TimeSyncReply{}, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return TimeSyncReply{}, fmt.Errorf("time-sync: %s", resp.Status)
+		}
+		var reply TimeSyncReply
+		if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+			return TimeSyncReply{}, err
+		}
+		return reply, nil
+	}
+}
+
+// Sample is one round trip: the client sent at T0 and got the reply at T3,
+// the server received at T1 and replied at T2.
+type Sample struct {
+	T0, T1, T2, T3 time.Time
+}
+
+// Offset is how far the server clock is ahead of the client's, assuming
+// the request and the reply took equally long.
+func (s Sample) Offset() time.Duration {
+	return (s.T1.Sub(s.T0) + s.T2.Sub(s.T3)) / 2
+}
+
+// Delay is the time spent on the network, the server's own time excluded.
+// The offset of a sample can be wrong by up to half of it.
+func (s Sample) Delay() time.Duration {
+	return s.T3.Sub(s.T0) - s.T2.Sub(s.T1)
+}
+
+// Estimate is the result of a sync. Used is how many samples were left
+// once the outliers were discarded.
+type Estimate struct {
+	Offset time.Duration
+	Delay  time.Duration
+	Used   int
+}
+
+var errNoSamples = errors.New("no usable time-sync samples")
+
+// Filter estimates the offset from samples. A sample whose delay is more
+// than twice the median delay was held up on the way, on Wi-Fi usually in
+// one direction only, so it is discarded; the estimate is the median offset
+// of the rest.
+func Filter(samples []Sample) (Estimate, error) {
+	if len(samples) == 0 {
+		return Estimate{}, errNoSamples
+	}
+	delays := make([]time.Duration, len(samples))
+	for i, s := range samples {
+		delays[i] = s.Delay()
+	}
+	limit := 2 * median(delays)
+	var offsets, kept []time.Duration
+	for _, s := range samples {
+		if d := s.Delay(); d <= limit {
+			offsets = append(offsets, s.Offset())
+			kept = append(kept, d)
+		}
+	}
+	return Estimate{Offset: median(offsets), Delay: median(kept), Used: len(offsets)}, nil
+}
+
+func median(values []time.Duration) time.Duration {
+	sorted := append([]time.Duration{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// Syncer measures the offset between the local clock and the server's.
+// Now defaults to time.Now.
+type Syncer struct {
+	Transport Transport
+	Now       func() time.Time
+}
+
+// Sync takes SyncSamples samples back to back and filters them. Failed
+// exchanges and replies that don't echo the sample's nonce are skipped;
+// Sync fails only if no sample came back.
+func (s *Syncer) Sync(ctx context.Context) (Estimate, error) {
+	now := s.Now
+	if now == nil {
+		now = time.Now
+	}
+	var samples []Sample
+	var lastErr error
+	for i := 0; i < SyncSamples; i++ {
+		nonce, err := newNonce()
+		if err != nil {
+			return Estimate{}, err
+		}
+		t0 := now()
+		reply, err := s.Transport(ctx, i, nonce)
+		t3 := now()
+		if err != nil {
+			if ctx.Err() != nil {
+				return Estimate{}, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		if reply.Nonce != nonce || reply.Index != i {
+			continue
+		}
+		samples = append(samples, Sample{T0: t0, T1: time.Unix(0, reply.Receive), T2: time.Unix(0, reply.Transmit), T3: t3})
+	}
+	if len(samples) == 0 && lastErr != nil {
+		return Estimate{}, lastErr
+	}
+	return Filter(samples)
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2025, 3, 14, 18, 0, 0, 0, time.UTC)
+
+// jitteryNet simulates a venue Wi-Fi: each leg of an exchange takes 4 to
+// 6 milliseconds, and the samples in held have one leg, in either
+// direction, held up for much longer. The server clock is offset ahead of
+// the client's.
+type jitteryNet struct {
+	rand   *rand.Rand
+	now    time.Time
+	offset time.Duration
+	held   map[int]bool
+}
+
+func (n *jitteryNet) leg(held bool) time.Duration {
+	d := time.Duration(4+n.rand.Intn(3)) * time.Millisecond
+	if held {
+		d += time.Duration(200+n.rand.Intn(300)) * time.Millisecond
+	}
+	return d
+}
+
+func (n *jitteryNet) clientNow() time.Time { return n.now }
+
+func (n *jitteryNet) transport(ctx context.Context, index int, nonce string) (TimeSyncReply, error) {
+	up := n.held[index] && n.rand.Intn(2) == 0
+	down := n.held[index] && !up
+	n.now = n.now.Add(n.leg(up))
+	rx := n.now.Add(n.offset)
+	n.now = n.now.Add(50 * time.Microsecond)
+	tx := n.now.Add(n.offset)
+	n.now = n.now.Add(n.leg(down))
+	return TimeSyncReply{Nonce: nonce, Index: index, Receive: rx.UnixNano(), Transmit: tx.UnixNano()}, nil
+}
+
+func TestSampleOffsetAndDelay(t *testing.T) {
+	// 10ms up, 1ms at the server, 30ms down, server 5s ahead.
+	s := Sample{
+		T0: epoch,
+		T1: epoch.Add(5*time.Second + 10*time.Millisecond),
+		T2: epoch.Add(5*time.Second + 11*time.Millisecond),
+		T3: epoch.Add(41 * time.Millisecond),
+	}
+	if got := s.Delay(); got != 40*time.Millisecond {
+		t.Errorf("delay %v, want 40ms", got)
+	}
+	// The asymmetry is half of what is off: (10-30)/2.
+	if got := s.Offset(); got != 5*time.Second-10*time.Millisecond {
+		t.Errorf("offset %v, want 4.99s", got)
+	}
+}
+
+func TestFilterDropsOutliers(t *testing.T) {
+	sample := func(offset, up, down time.Duration) Sample {
+		return Sample{T0: epoch, T1: epoch.Add(offset + up), T2: epoch.Add(offset + up), T3: epoch.Add(up + down)}
+	}
+	samples := []Sample{
+		sample(time.Second, 5*time.Millisecond, 5*time.Millisecond),
+		sample(time.Second, 6*time.Millisecond, 4*time.Millisecond),
+		// Held up on the way back: the offset is off by 200ms.
+		sample(time.Second, 5*time.Millisecond, 405*time.Millisecond),
+		sample(time.Second, 4*time.Millisecond, 6*time.Millisecond),
+		// Held up on the way there.
+		sample(time.Second, 305*time.Millisecond, 5*time.Millisecond),
+	}
+	est, err := Filter(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est.Used != 3 {
+		t.Errorf("used %d samples, want 3", est.Used)
+	}
+	if est.Offset != time.Second {
+		t.Errorf("offset %v, want 1s", est.Offset)
+	}
+	if est.Delay != 10*time.Millisecond {
+		t.Errorf("delay %v, want 10ms", est.Delay)
+	}
+	if _, err := Filter(nil); err == nil {
+		t.Error("no samples gave no error")
+	}
+}
+
+func TestSyncOverJitteryTransport(t *testing.T) {
+	for seed := int64(1); seed <= 200; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		// Up to two of the five samples are held up, which the median
+		// delay still sees through.
+		held := map[int]bool{}
+		for len(held) < int(seed%3) {
+			held[r.Intn(SyncSamples)] = true
+		}
+		net := &jitteryNet{rand: r, now: epoch, offset: 1234 * time.Millisecond, held: held}
+		s := &Syncer{Transport: net.transport, Now: net.clientNow}
+		est, err := s.Sync(context.Background())
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if est.Used != SyncSamples-len(held) {
+			t.Errorf("seed %d: used %d samples with %d held up", seed, est.Used, len(held))
+		}
+		// A normal exchange is at most 2ms out of balance, so its offset is
+		// off by at most half of that.
+		if off := est.Offset - net.offset; off < -time.Millisecond || off > time.Millisecond {
+			t.Errorf("seed %d: offset off by %v", seed, off)
+		}
+		if est.Delay > 12*time.Millisecond {
+			t.Errorf("seed %d: delay %v", seed, est.Delay)
+		}
+	}
+}
+
+func TestSyncSkipsBadReplies(t *testing.T) {
+	net := &jitteryNet{rand: rand.New(rand.NewSource(1)), now: epoch, offset: time.Second}
+	calls := 0
+	s := &Syncer{Now: net.clientNow, Transport: func(ctx context.Context, index int, nonce string) (TimeSyncReply, error) {
+		calls++
+		reply, _ := net.transport(ctx, index, nonce)
+		switch index {
+		case 0:
+			return TimeSyncReply{}, errors.New("connection reset")
+		case 1:
+			// A late reply to an earlier sample.
+			reply.Nonce = "stale"
+		}
+		return reply, nil
+	}}
+	est, err := s.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != SyncSamples || est.Used > SyncSamples-2 {
+		t.Errorf("%d calls, %d samples used", calls, est.Used)
+	}
+}
+
+func TestSyncFailsWithoutReplies(t *testing.T) {
+	down := errors.New("network is unreachable")
+	s := &Syncer{Transport: func(context.Context, int, string) (TimeSyncReply, error) {
+		return TimeSyncReply{}, down
+	}}
+	if _, err := s.Sync(context.Background()); !errors.Is(err, down) {
+		t.Errorf("got %v, want the transport's error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Transport = func(ctx context.Context, _ int, _ string) (TimeSyncReply, error) {
+		return TimeSyncReply{}, ctx.Err()
+	}
+	if _, err := s.Sync(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled: got %v", err)
+	}
+}
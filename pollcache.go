@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// pollCacheMaxAge bounds how stale the derived time fields of a cached
+// payload may get while the revision stays the same.
+const pollCacheMaxAge = 100 * time.Millisecond
+
+var maxPolls = flag.Int("max-polls", 512, "concurrent /get-question requests served before the rest get 503 (0 disables shedding)")
+
+type cachedPayload struct {
+	built time.Time
+	body  []byte
+}
+
+// pollCache holds the encoded public payloads per API version and variant
+// locale ("" for the default text), all for pollCacheRevision.
+var (
+	pollCacheMutex    sync.Mutex
+	pollCacheRevision uint64
+	pollCache         = map[pollCacheKey]cachedPayload{}
+)
+
+type pollCacheKey struct {
+	version int
+	lang    string
+	// bootstrap marks the GET /bootstrap document, see bootstrap.go.
+	bootstrap bool
+}
+
+// encodePublicQuestion builds the /get-question body for lang exactly as
+// c.JSON would for version, trailing newline included.
+func encodePublicQuestion(lang string, version int) ([]byte, uint64, error) {
+	questionMutex.RLock()
+	view := localizedQuestion(publicQuestion(question), lang)
+	rev := revision
+	questionMutex.RUnlock()
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(wireValue(view, version)); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), rev, nil
+}
+
+// cachedPublicQuestion returns the encoded /get-question payload for lang.
+func cachedPublicQuestion(lang string, version int) ([]byte, error) {
+	return cachedPoll(pollCacheKey{version: version}, lang, encodePublicQuestion)
+}
+
+// cachedPoll returns the payload of key for lang, rebuilding it with encode
+// when the state changed or the cached copy is older than pollCacheMaxAge.
+// Holding pollCacheMutex while rebuilding means a burst of polls waits for
+// one rebuild instead of each doing its own.
+func cachedPoll(key pollCacheKey, lang string, encode func(lang string, version int) ([]byte, uint64, error)) ([]byte, error) {
+	questionMutex.RLock()
+	rev := revision
+	if _, ok := question.Variants[lang]; !ok {
+		lang = ""
+	}
+	questionMutex.RUnlock()
+
+	pollCacheMutex.Lock()
+	defer pollCacheMutex.Unlock()
+	if rev != pollCacheRevision {
+		pollCache = map[pollCacheKey]cachedPayload{}
+		pollCacheRevision = rev
+	}
+	key.lang = lang
+	// A clock set back makes the age negative; that copy is rebuilt too.
+	if c, ok := pollCache[key]; ok {
+		if age := clock.Since(c.built); age >= 0 && age < pollCacheMaxAge {
+			return c.body, nil
+		}
+	}
+	body, built, err := encode(lang, key.version)
+	if err != nil {
+		return nil, err
+	}
+	if built == pollCacheRevision {
+		pollCache[key] = cachedPayload{built: clock.Now(), body: body}
+	}
+	return body, nil
+}
+
+var (
+	pollsMutex    sync.Mutex
+	pollsInFlight int
+)
+
+// shedPolls answers 503 with Retry-After once more than -max-polls
+// requests are being served, so a flood of phones can't queue up behind
+// the operator's requests.
+func shedPolls(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if *maxPolls <= 0 {
+			return next(c)
+		}
+		pollsMutex.Lock()
+		if pollsInFlight >= *maxPolls {
+			pollsMutex.Unlock()
+			c.Response().Header().Set("Retry-After", "1")
+			return apiError(http.StatusServiceUnavailable, "overloaded", "too many requests, retry shortly")
+		}
+		pollsInFlight++
+		pollsMutex.Unlock()
+		defer func() {
+			pollsMutex.Lock()
+			pollsInFlight--
+			pollsMutex.Unlock()
+		}()
+		return next(c)
+	}
+}
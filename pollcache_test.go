@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPollCacheRebuilds(t *testing.T) {
+	StartTestServer(t)
+	builds := 0
+	encode := func(lang string, version int) ([]byte, uint64, error) {
+		builds++
+		return []byte("{}"), currentRevision(), nil
+	}
+	poll := func() {
+		t.Helper()
+		if _, err := cachedPoll(pollCacheKey{version: -1}, "", encode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	poll()
+	poll()
+	if builds != 1 {
+		t.Errorf("%d builds for two polls of the same state", builds)
+	}
+	AdvanceClock(t, pollCacheMaxAge)
+	poll()
+	if builds != 2 {
+		t.Errorf("%d builds once the copy aged", builds)
+	}
+	questionMutex.Lock()
+	stateChanged("test")
+	questionMutex.Unlock()
+	poll()
+	if builds != 3 {
+		t.Errorf("%d builds after a state change", builds)
+	}
+	AdvanceClock(t, -time.Second)
+	defer AdvanceClock(t, time.Second)
+	poll()
+	if builds != 4 {
+		t.Errorf("%d builds after the clock was set back", builds)
+	}
+}
+
+func TestPollShedding(t *testing.T) {
+	s := StartTestServer(t)
+	saved := *maxPolls
+	*maxPolls = 1
+	pollsMutex.Lock()
+	pollsInFlight++
+	pollsMutex.Unlock()
+	defer func() {
+		pollsMutex.Lock()
+		pollsInFlight--
+		pollsMutex.Unlock()
+		*maxPolls = saved
+	}()
+
+	resp := s.Send(t, s.NewRequest(t, http.MethodGet, "/get-question", nil), nil)
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "1" {
+		t.Errorf("a poll over the limit: status %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if status := s.Do(t, http.MethodGet, "/teams", nil, nil); status != http.StatusOK {
+		t.Errorf("other requests are shed too: status %d", status)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// MediaHint names the asset of the next queued question so displays can
+// fetch it before the question goes live. It never carries any text of the
+// question.
+type MediaHint struct {
+	MediaURL string `json:"media_url"`
+	QueueID  int    `json:"queue_id"`
+}
+
+// PreloadEvent is the preload event. A nil NextMedia withdraws the hint
+// sent before it.
+type PreloadEvent struct {
+	NextMedia *MediaHint `json:"next_media"`
+}
+
+// preloadHint is the hint last announced, nil when there is none.
+var (
+	preloadMutex sync.Mutex
+	preloadHint  *MediaHint
+)
+
+// validateMediaURL accepts an http(s) URL or a path on the display's own
+// server.
+func validateMediaURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https" && !strings.HasPrefix(raw, "/")) {
+		return fmt.Errorf("media_url must be an http(s) URL or an absolute path")
+	}
+	return nil
+}
+
+func upcomingMedia() *MediaHint {
+	e, ok := nextQueueEntry()
+	if !ok || e.MediaURL == "" {
+		return nil
+	}
+	if display := mediaDisplayURL(e.MediaURL); display != "" {
+		return &MediaHint{MediaURL: display, QueueID: e.ID}
+	}
+	return &MediaHint{MediaURL: e.MediaURL, QueueID: e.ID}
+}
+
+// currentPreload is the next_media of the payloads.
+func currentPreload() *MediaHint {
+	preloadMutex.Lock()
+	defer preloadMutex.Unlock()
+	return preloadHint
+}
+
+// checkPreload re-reads the next queue entry after the queue changed and,
+// when its media differs from what was announced, sends a preload event
+// with the new hint or withdraws the old one.
+func checkPreload() {
+	hint := upcomingMedia()
+	preloadMutex.Lock()
+	same := hint == nil && preloadHint == nil || hint != nil && preloadHint != nil && *hint == *preloadHint
+	preloadHint = hint
+	preloadMutex.Unlock()
+	if same {
+		return
+	}
+	hub.broadcast(Event{Type: "preload", Data: PreloadEvent{NextMedia: hint}})
+	roundChanged("preload")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPreloadHint changes the queue under a cached payload and checks that
+// the next poll and a preload event carry the new hint at once.
+func TestPreloadHint(t *testing.T) {
+	s := StartTestServer(t)
+	sub := hub.subscribe("test", false)
+	defer hub.unsubscribe(sub)
+	queued, err := enqueue([]QueueEntry{
+		{Question: "secret-A", TimeLeft: 30 * time.Second, MediaURL: "/media/a.png"},
+		{Question: "secret-B", TimeLeft: 30 * time.Second, MediaURL: "/media/b.png"},
+	}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := queued[0].ID, queued[1].ID
+
+	// Polls within pollCacheMaxAge get the cached payload, so only a state
+	// change can show them a new hint.
+	next := func() *MediaHint {
+		t.Helper()
+		var q PublicQuestionView
+		s.Do(t, http.MethodGet, "/get-question", nil, &q)
+		return q.NextMedia
+	}
+	hints := func() []*MediaHint {
+		t.Helper()
+		var out []*MediaHint
+		for draining := true; draining; {
+			select {
+			case ev := <-sub.ch:
+				if ev.Type != "preload" {
+					continue
+				}
+				raw, _ := json.Marshal(ev.Data)
+				if strings.Contains(string(raw), "secret-") {
+					t.Errorf("a preload event with question text: %s", raw)
+				}
+				out = append(out, ev.Data.(PreloadEvent).NextMedia)
+			default:
+				draining = false
+			}
+		}
+		return out
+	}
+	want := func(what string, id int, url string) {
+		t.Helper()
+		if h := next(); h == nil || h.QueueID != id || h.MediaURL != url {
+			t.Errorf("%s: next media %+v, want #%d %s", what, h, id, url)
+		}
+		if h := hints(); len(h) != 1 || h[0] == nil || h[0].QueueID != id {
+			t.Errorf("%s: preload events %+v", what, h)
+		}
+	}
+	want("queued", a, "/media/a.png")
+
+	if status := s.Do(t, http.MethodPut, "/queue/order", map[string]interface{}{"order": []int{b, a}, "revision": queueSnapshot().Revision}, nil); status != http.StatusOK {
+		t.Fatalf("reordering: status %d", status)
+	}
+	want("reordered", b, "/media/b.png")
+
+	// A bank entry staged behind them takes over once both are gone.
+	e, err := createBankEntry(BankEntry{Question: "secret-C", Type: "pomoc", TimeLeft: FlexDuration(30 * time.Second), MediaURL: "/media/c.png"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var staged []QueueEntry
+	if status := s.Do(t, http.MethodPost, fmt.Sprintf("/bank/%d/queue", e.ID), nil, &staged); status != http.StatusOK || len(staged) != 1 {
+		t.Fatalf("staging: status %d, %+v", status, staged)
+	}
+	if h := hints(); len(h) != 0 {
+		t.Errorf("staging behind the next entry sent %+v", h)
+	}
+	for _, id := range []int{b, a} {
+		if status := s.DoConfirmed(t, http.MethodDelete, fmt.Sprintf("/queue/%d", id), nil, nil); status != http.StatusNoContent {
+			t.Fatalf("removing #%d: status %d", id, status)
+		}
+	}
+	if h := next(); h == nil || h.QueueID != staged[0].ID || h.MediaURL != "/media/c.png" {
+		t.Errorf("the staged entry: next media %+v", h)
+	}
+	if h := hints(); len(h) != 2 || h[1] == nil || h[1].QueueID != staged[0].ID {
+		t.Errorf("removing: preload events %+v", h)
+	}
+
+	// Once it is live there is nothing to preload.
+	s.MustDo(t, http.MethodPost, "/queue/next?force=true", nil)
+	if h := next(); h != nil {
+		t.Errorf("nothing left: next media %+v", h)
+	}
+	if h := hints(); len(h) != 1 || h[0] != nil {
+		t.Errorf("withdrawn: preload events %+v", h)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// PresenceSpan is one kiosk stream of a team: from when it opened to the
+// last state the server got through to it. Kiosk streams send a state every
+// tick, so those sends are the team's heartbeat. A page that loses its
+// connection is only noticed when a write fails, and writes into a dead
+// connection can succeed until the socket buffer fills, so a drop may look
+// a few seconds shorter than it was.
+type PresenceSpan struct {
+	Team string    `json:"team"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	Open bool      `json:"open,omitempty"`
+}
+
+var (
+	presenceMutex sync.Mutex
+	presence      []*PresenceSpan
+)
+
+// presenceOpen starts a span for a kiosk stream of team that has just sent
+// its first state.
+func presenceOpen(team string) *PresenceSpan {
+	now := clock.Now()
+	p := &PresenceSpan{Team: team, From: now, To: now, Open: true}
+	presenceMutex.Lock()
+	presence = append(presence, p)
+	presenceMutex.Unlock()
+	return p
+}
+
+// presenceSeen notes that a state reached the stream of p.
+func presenceSeen(p *PresenceSpan) {
+	presenceMutex.Lock()
+	p.To = clock.Now()
+	presenceMutex.Unlock()
+}
+
+// presenceClose ends the span of a stream that is gone.
+func presenceClose(p *PresenceSpan) {
+	presenceMutex.Lock()
+	p.Open = false
+	presenceMutex.Unlock()
+}
+
+// presenceSpans returns copies of team's spans, oldest first, or of every
+// team's when team is empty. Open spans reach to now.
+func presenceSpans(team string) []PresenceSpan {
+	now := clock.Now()
+	presenceMutex.Lock()
+	defer presenceMutex.Unlock()
+	out := []PresenceSpan{}
+	for _, p := range presence {
+		if team != "" && p.Team != team {
+			continue
+		}
+		s := *p
+		if s.Open {
+			s.To = now
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// installPresence replaces the recorded spans, as restored from a backup or
+// cleared for a new game. Streams open in this process carry on.
+func installPresence(spans []PresenceSpan) {
+	presenceMutex.Lock()
+	defer presenceMutex.Unlock()
+	var open []*PresenceSpan
+	for _, p := range presence {
+		if p.Open {
+			open = append(open, p)
+		}
+	}
+	presence = nil
+	for i := range spans {
+		p := spans[i]
+		p.Open = false
+		presence = append(presence, &p)
+	}
+	presence = append(presence, open...)
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// lastPreset is the implicit preset holding the last explicit time set from
+// any control surface.
+const lastPreset = "last"
+
+var (
+	presets      = map[string]time.Duration{}
+	presetsMutex sync.RWMutex
+)
+
+// rememberTime updates the "last" preset.
+func rememberTime(d time.Duration) {
+	presetsMutex.Lock()
+	presets[lastPreset] = d
+	presetsMutex.Unlock()
+}
+
+func savePreset(name string, d time.Duration, origin string) error {
+	if !varNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid preset name %q", name)
+	}
+	if name == lastPreset {
+		return fmt.Errorf("%q is updated automatically and can't be saved", lastPreset)
+	}
+	presetsMutex.Lock()
+	presets[name] = d
+	presetsMutex.Unlock()
+	audit("preset", origin, map[string]interface{}{"name": name, "seconds": d.Seconds()})
+	return nil
+}
+
+func deletePreset(name, origin string) bool {
+	presetsMutex.Lock()
+	_, ok := presets[name]
+	delete(presets, name)
+	presetsMutex.Unlock()
+	if ok {
+		audit("preset_remove", origin, map[string]interface{}{"name": name})
+	}
+	return ok
+}
+
+func presetNames() []string {
+	presetsMutex.RLock()
+	defer presetsMutex.RUnlock()
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func listPresets() map[string]time.Duration {
+	presetsMutex.RLock()
+	defer presetsMutex.RUnlock()
+	out := make(map[string]time.Duration, len(presets))
+	for k, v := range presets {
+		out[k] = v
+	}
+	return out
+}
+
+func lookupPreset(name string) (time.Duration, error) {
+	presetsMutex.RLock()
+	d, ok := presets[name]
+	presetsMutex.RUnlock()
+	if !ok {
+		names := presetNames()
+		if len(names) == 0 {
+			return 0, fmt.Errorf("unknown preset %q, no presets are saved", name)
+		}
+		return 0, fmt.Errorf("unknown preset %q, available: %s", name, strings.Join(names, ", "))
+	}
+	return d, nil
+}
+
+// errWouldExpire refuses a time change that would end the live question on
+// the spot. Forced, the change goes ahead and the expiry watcher ends the
+// question the usual way.
+var errWouldExpire = apiError(http.StatusConflict, "would_expire_immediately", "the new time would end the question immediately (force to go ahead)")
+
+// setTimeLeft restarts the live question as a countdown of d. A running
+// question set to zero is refused unless force is given.
+func setTimeLeft(d time.Duration, force bool, origin string) error {
+	questionMutex.Lock()
+	if d <= 0 && questionInProgressAt(question, clock.Now()) {
+		if !force {
+			questionMutex.Unlock()
+			return errWouldExpire
+		}
+		audit("time_force", origin, map[string]interface{}{"left": 0, "count_up": question.CountUp})
+	}
+	setTimer(&question, d)
+	question.Reading = false
+	reviveExpired(&question)
+	stateChanged("time")
+	questionMutex.Unlock()
+	return nil
+}
+
+// adjustTimeLeft adds delta (which may be negative) to what is left of the
+// live countdown. During the reading phase it changes the answer time that
+// follows. Taking all that is left of a running countdown needs force.
+func adjustTimeLeft(delta time.Duration, force bool, origin string) (time.Duration, error) {
+	questionMutex.Lock()
+	if question.CountUp || question.Type == "waiting" || (question.Type == "end" && question.ExpiredFrom == "") {
+		questionMutex.Unlock()
+		return 0, fmt.Errorf("no countdown is running")
+	}
+	left := timeRemaining(question, clock.Now())
+	if left < 0 {
+		left = 0
+	}
+	if left > 0 && left+delta <= 0 && !question.Reading {
+		if !force {
+			questionMutex.Unlock()
+			return left, errWouldExpire
+		}
+		audit("time_force", origin, map[string]interface{}{"left": 0, "delta": delta.Seconds()})
+	}
+	before := left
+	left += delta
+	if left < 0 {
+		left = 0
+	}
+	if question.Reading {
+		// StartTime belongs to the reading time, which carries on.
+		question.TimeLeft = left
+		question.Duration = left
+	} else {
+		setTimer(&question, left)
+	}
+	if left > 0 {
+		reviveExpired(&question)
+	}
+	stateChanged("time_adjust")
+	questionMutex.Unlock()
+
+	audit("time_adjust", origin, map[string]interface{}{"delta": delta.Seconds(), "left": left.Seconds()})
+	recordTimeAdjustUndo(left-before, force, origin)
+	go sendCurrentQuestion()
+	return left, nil
+}
+
+// handleAdjustCommand runs "adjust <±seconds|±duration> [--force]" and
+// returns the time left.
+func handleAdjustCommand(args []string, origin string) (time.Duration, error) {
+	success := color.New(color.FgGreen)
+
+	force := len(args) == 2 && args[1] == "--force"
+	if len(args) != 1 && !force {
+		return 0, errors.New("Usage: adjust <+seconds|-seconds> [--force]")
+	}
+	delta, err := time.ParseDuration(args[0])
+	if f, ferr := strconv.ParseFloat(args[0], 64); ferr == nil {
+		delta, err = time.Duration(f*float64(time.Second)), nil
+	}
+	if err != nil {
+		return 0, errors.New("Usage: adjust <+seconds|-seconds> [--force]")
+	}
+	left, err := adjustTimeLeft(delta, force, origin)
+	if err == errWouldExpire && atTerminal(origin) {
+		if !confirmExpiry() {
+			return 0, errors.New("Time unchanged")
+		}
+		left, err = adjustTimeLeft(delta, true, origin)
+	}
+	if err != nil {
+		return 0, err
+	}
+	success.Printf("Time left: %s\n", left.Round(time.Second))
+	return left, nil
+}
+
+// setTimeConfirmed is "time <seconds>": at the terminal it asks before
+// ending the question, elsewhere that takes force.
+func setTimeConfirmed(d time.Duration, force bool, origin string) error {
+	err := setTimeLeft(d, force, origin)
+	if err == errWouldExpire && atTerminal(origin) {
+		if !confirmExpiry() {
+			return errors.New("Time unchanged")
+		}
+		err = setTimeLeft(d, true, origin)
+	}
+	return err
+}
+
+// confirmExpiry asks the operator whether a time change refused with
+// errWouldExpire should be forced.
+func confirmExpiry() bool {
+	return promptYesNo("This ends the question right away. Continue?")
+}
+
+// TimeRequest is the body of POST /time and POST /time/adjust. Force, also
+// accepted as ?force=true, allows a change that ends the question at once.
+type TimeRequest struct {
+	TimeLeft     FlexDuration `json:"time_left"`
+	DeltaSeconds float64      `json:"delta_seconds"`
+	Force        bool         `json:"force"`
+}
+
+func bindTimeRequest(c echo.Context) (*TimeRequest, error) {
+	req := new(TimeRequest)
+	if err := bindJSON(c, req); err != nil {
+		return nil, bindError(err)
+	}
+	req.Force = req.Force || c.QueryParam("force") == "true"
+	return req, nil
+}
+
+func postTime(c echo.Context) error {
+	req, err := bindTimeRequest(c)
+	if err != nil {
+		return err
+	}
+	d := time.Duration(req.TimeLeft)
+	if err := setTimeLeft(d, req.Force, requestOrigin(c)); err != nil {
+		return err
+	}
+	rememberTime(d)
+	go sendCurrentQuestion()
+	return getQuestion(c)
+}
+
+func postTimeAdjust(c echo.Context) error {
+	req, err := bindTimeRequest(c)
+	if err != nil {
+		return err
+	}
+	if _, err := adjustTimeLeft(time.Duration(req.DeltaSeconds*float64(time.Second)), req.Force, requestOrigin(c)); err != nil {
+		if err == errWouldExpire {
+			return err
+		}
+		return conflict(err.Error())
+	}
+	return getQuestion(c)
+}
+
+// PresetRequest saves a named time preset.
+type PresetRequest struct {
+	Name     string       `json:"name"`
+	TimeLeft FlexDuration `json:"time_left"`
+}
+
+func getPresets(c echo.Context) error {
+	return c.JSON(http.StatusOK, listPresets())
+}
+
+func postPreset(c echo.Context) error {
+	req := new(PresetRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if err := savePreset(req.Name, time.Duration(req.TimeLeft), requestOrigin(c)); err != nil {
+		return badRequest(err.Error())
+	}
+	return c.JSON(http.StatusOK, listPresets())
+}
+
+func deletePresetHandler(c echo.Context) error {
+	if !deletePreset(c.Param("name"), requestOrigin(c)) {
+		return notFound("preset not found")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func handlePresetCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 0 || args[0] == "list" {
+		all := listPresets()
+		if len(all) == 0 {
+			info.Println("No presets saved")
+			return nil
+		}
+		for _, name := range presetNames() {
+			info.Printf("  %-12s %s\n", name, all[name])
+		}
+		return nil
+	}
+	switch args[0] {
+	case "save":
+		if len(args) != 3 {
+			return errors.New("Usage: preset save <name> <time>")
+		}
+		d, err := parseDurationArg(args[2])
+		if err == nil {
+			err = savePreset(args[1], d, origin)
+		}
+		if err != nil {
+			return err
+		}
+		success.Printf("Preset %s = %s\n", args[1], d)
+	case "rm":
+		if len(args) != 2 || !deletePreset(args[1], origin) {
+			return errors.New("Usage: preset rm <name>")
+		}
+		success.Printf("Preset %s removed\n", args[1])
+	default:
+		return errors.New("Usage: preset [list|save <name> <time>|rm <name>]")
+	}
+	return nil
+}
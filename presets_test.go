@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestWouldExpire ends a running question through each way of changing its
+// time and checks they refuse alike, force alike and expire it once.
+func TestWouldExpire(t *testing.T) {
+	s := StartTestServer(t)
+	rest := func(path string, body map[string]interface{}) func(force bool) (bool, string) {
+		return func(force bool) (bool, string) {
+			t.Helper()
+			if force {
+				body["force"] = true
+			}
+			var res struct {
+				Error APIError `json:"error"`
+			}
+			status := s.Do(t, http.MethodPost, path, body, &res)
+			return status == http.StatusOK, res.Error.Code
+		}
+	}
+	restQuery := func(path string, body map[string]interface{}) func(force bool) (bool, string) {
+		return func(force bool) (bool, string) {
+			t.Helper()
+			p := path
+			if force {
+				p += "?force=true"
+			}
+			return rest(p, body)(false)
+		}
+	}
+	socket := func(cmd WSCommand) func(force bool) (bool, string) {
+		return func(force bool) (bool, string) {
+			t.Helper()
+			cmd.Force = force
+			cmd.ID = fmt.Sprint(force)
+			res := wsSend(t, dialWS(t, s, testKey), cmd)
+			return res.OK, wsErrorCode(res)
+		}
+	}
+
+	var first string
+	for _, tc := range []struct {
+		name   string
+		change func(force bool) (bool, string)
+	}{
+		{"POST /time/adjust", rest("/v2/time/adjust", map[string]interface{}{"delta_seconds": -60})},
+		{"POST /time/adjust?force=true", restQuery("/v2/time/adjust", map[string]interface{}{"delta_seconds": -60})},
+		{"POST /time", rest("/v2/time", map[string]interface{}{"time_left": 0})},
+		{"POST /time?force=true", restQuery("/v2/time", map[string]interface{}{"time_left": 0})},
+		{"ws time.adjust", socket(WSCommand{Cmd: "time.adjust", Delta: -60})},
+		{"ws time.set", socket(WSCommand{Cmd: "time.set", Value: "0"})},
+	} {
+		StartTestServer(t)
+		s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Hlavné mesto?", "type": "pomoc", "time_left": 30_000_000_000})
+		AdvanceClock(t, 10*time.Second)
+		forced, expiries := len(auditEntries("time_force")), len(auditEntries("expire"))
+
+		if ok, code := tc.change(false); ok || code != "would_expire_immediately" {
+			t.Errorf("%s: ok %v, %s", tc.name, ok, code)
+		}
+		questionMutex.RLock()
+		left := timeRemaining(question, clock.Now())
+		questionMutex.RUnlock()
+		if left != 20*time.Second || len(auditEntries("time_force")) != forced {
+			t.Errorf("%s: refused, but %s left", tc.name, left)
+		}
+
+		if ok, code := tc.change(true); !ok {
+			t.Errorf("%s forced: %s", tc.name, code)
+		}
+		lastAudit(t, "time_force")
+		eventually(t, tc.name+" expiring the question", func() bool {
+			AdvanceClock(t, 100*time.Millisecond)
+			var q OperatorQuestionView
+			s.Do(t, http.MethodGet, "/get-question/full", nil, &q)
+			return q.Type == "end" && len(auditEntries("expire")) > expiries
+		})
+		s.Flask.WaitFor(t, tc.name+" pushing the end", func(p map[string]interface{}) bool { return p["type"] == "end" })
+		// Give the watcher a chance to expire it a second time.
+		AdvanceClock(t, 5*time.Second)
+		time.Sleep(50 * time.Millisecond)
+		expired := len(auditEntries("expire")) - expiries
+		if expired != 1 {
+			t.Errorf("%s: expired %d times", tc.name, expired)
+		}
+		outcome := fmt.Sprintf("%d expired, %s", expired, lastAudit(t, "time_force").Details["left"])
+		if first == "" {
+			first = outcome
+		} else if outcome != first {
+			t.Errorf("%s: %s, the first way %s", tc.name, outcome, first)
+		}
+	}
+}
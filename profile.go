@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	profilesDir = flag.String("profiles-dir", "profiles", "directory holding show profiles, one <name>.json each")
+	profileFlag = flag.String("profile", "", "show profile to load at startup")
+)
+
+var errGameInProgress = errors.New("a question is in progress, finish it before switching profiles")
+
+// Profile is the runtime configuration of one show format. Loading it
+// replaces all of these settings at once.
+type Profile struct {
+	// Types are the question types besides the waiting and end screens,
+	// which every profile has.
+	Types           []string                `json:"types"`
+	Presets         map[string]FlexDuration `json:"presets"`
+	AutoWait        FlexDuration            `json:"auto_wait"`
+	AutoWaitMessage string                  `json:"auto_wait_message"`
+	AnnounceAt      []FlexDuration          `json:"announce_at"`
+	AnnounceLang    string                  `json:"announce_lang"`
+	LockWhileLive   bool                    `json:"lock_while_live"`
+	HostLead        FlexDuration            `json:"host_lead,omitempty"`
+	Targets         []PushTarget            `json:"targets"`
+}
+
+// ProfileView is the body of GET /profile.
+type ProfileView struct {
+	Name     string  `json:"name,omitempty"`
+	Settings Profile `json:"settings"`
+}
+
+// ShowDefaults are the settings of a profile that new questions and the
+// announcer fall back to. Backups keep them in defaults.json.
+type ShowDefaults struct {
+	Types           []string       `json:"types"`
+	AutoWait        FlexDuration   `json:"auto_wait"`
+	AutoWaitMessage string         `json:"auto_wait_message"`
+	AnnounceAt      []FlexDuration `json:"announce_at"`
+	AnnounceLang    string         `json:"announce_lang"`
+}
+
+// ShowConfig is the rest of the running setup that backups keep, in
+// config.json: the profile it came from, the live lock and the targets.
+type ShowConfig struct {
+	Profile       string       `json:"profile,omitempty"`
+	LockWhileLive bool         `json:"lock_while_live"`
+	Targets       []PushTarget `json:"targets"`
+}
+
+var (
+	profileMutex  sync.Mutex
+	activeProfile string
+
+	questionTypesMutex sync.RWMutex
+	questionTypes      = []string{"pomoc", "rozstrel"}
+)
+
+// validQuestionType reports whether t is a type live questions may have.
+func validQuestionType(t string) bool {
+	if t == "waiting" || t == "end" {
+		return true
+	}
+	questionTypesMutex.RLock()
+	defer questionTypesMutex.RUnlock()
+	for _, known := range questionTypes {
+		if known == t {
+			return true
+		}
+	}
+	return false
+}
+
+// questionTypeNames lists every valid type, screens last.
+func questionTypeNames() []string {
+	questionTypesMutex.RLock()
+	defer questionTypesMutex.RUnlock()
+	return append(append([]string{}, questionTypes...), "waiting", "end")
+}
+
+// currentProfile captures the settings in effect now.
+func currentProfile() Profile {
+	d, c := currentShowDefaults(), currentShowConfig()
+	p := Profile{
+		Types:           d.Types,
+		Presets:         map[string]FlexDuration{},
+		AutoWait:        d.AutoWait,
+		AutoWaitMessage: d.AutoWaitMessage,
+		AnnounceAt:      d.AnnounceAt,
+		AnnounceLang:    d.AnnounceLang,
+		LockWhileLive:   c.LockWhileLive,
+		HostLead:        FlexDuration(showHostLead()),
+		Targets:         c.Targets,
+	}
+	for name, d := range listPresets() {
+		if name != lastPreset {
+			p.Presets[name] = FlexDuration(d)
+		}
+	}
+	return p
+}
+
+func currentShowDefaults() ShowDefaults {
+	delay, message := autoWaitSettings()
+	milestones, lang := announceSettings()
+	questionTypesMutex.RLock()
+	types := append([]string{}, questionTypes...)
+	questionTypesMutex.RUnlock()
+	d := ShowDefaults{Types: types, AutoWait: FlexDuration(delay), AutoWaitMessage: message, AnnounceAt: []FlexDuration{}, AnnounceLang: lang}
+	for _, m := range milestones {
+		d.AnnounceAt = append(d.AnnounceAt, FlexDuration(m))
+	}
+	return d
+}
+
+func currentShowConfig() ShowConfig {
+	profileMutex.Lock()
+	name := activeProfile
+	profileMutex.Unlock()
+	c := ShowConfig{Profile: name, LockWhileLive: liveLockOn(), Targets: []PushTarget{}}
+	for _, s := range pushTargetStatuses() {
+		c.Targets = append(c.Targets, s.PushTarget)
+	}
+	return c
+}
+
+func profilePath(name string) (string, error) {
+	if !varNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid profile name %q", name)
+	}
+	return filepath.Join(*profilesDir, name+".json"), nil
+}
+
+func readProfile(name string) (Profile, error) {
+	path, err := profilePath(name)
+	if err != nil {
+		return Profile{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Profile{}, err
+	}
+	var p Profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Profile{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return p, validateProfile(p)
+}
+
+func validateProfile(p Profile) error {
+	if len(p.Types) == 0 {
+		return fmt.Errorf("types must list at least one question type")
+	}
+	for _, t := range p.Types {
+		if t == "" || t == "waiting" || t == "end" {
+			return fmt.Errorf("invalid question type %q", t)
+		}
+	}
+	for name := range p.Presets {
+		if !varNamePattern.MatchString(name) || name == lastPreset {
+			return fmt.Errorf("invalid preset name %q", name)
+		}
+	}
+	for _, m := range p.AnnounceAt {
+		if m <= 0 {
+			return fmt.Errorf("announce_at milestones must be longer than zero")
+		}
+	}
+	return validatePushTargets(p.Targets)
+}
+
+func validatePushTargets(targets []PushTarget) error {
+	seen := map[string]bool{}
+	for _, t := range targets {
+		if t.SchemaVersion == 0 {
+			t.SchemaVersion = 1
+		}
+		if err := validatePushTarget(t); err != nil {
+			return fmt.Errorf("target %s: %v", t.Name, err)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("target %s is listed twice", t.Name)
+		}
+		seen[t.Name] = true
+	}
+	return nil
+}
+
+// applyProfile installs a validated profile. Nothing in it can fail, so
+// the show never ends up with half of one profile and half of another.
+func applyProfile(name string, p Profile, origin string) {
+	installShowDefaults(ShowDefaults{Types: p.Types, AutoWait: p.AutoWait, AutoWaitMessage: p.AutoWaitMessage, AnnounceAt: p.AnnounceAt, AnnounceLang: p.AnnounceLang})
+
+	presetsMutex.Lock()
+	last, hasLast := presets[lastPreset]
+	presets = map[string]time.Duration{}
+	for k, d := range p.Presets {
+		presets[k] = time.Duration(d)
+	}
+	if hasLast {
+		presets[lastPreset] = last
+	}
+	presetsMutex.Unlock()
+
+	setHostLead(time.Duration(p.HostLead), origin)
+	installShowConfig(ShowConfig{Profile: name, LockWhileLive: p.LockWhileLive, Targets: p.Targets})
+	audit("profile_load", origin, map[string]interface{}{"name": name})
+}
+
+// installShowDefaults replaces the question types, the waiting screen and
+// the announcer settings.
+func installShowDefaults(d ShowDefaults) {
+	questionTypesMutex.Lock()
+	questionTypes = append([]string{}, d.Types...)
+	questionTypesMutex.Unlock()
+
+	autoWaitMutex.Lock()
+	autoWaitDelay = time.Duration(d.AutoWait)
+	autoWaitMessage = d.AutoWaitMessage
+	autoWaitMutex.Unlock()
+
+	var milestones []time.Duration
+	for _, m := range d.AnnounceAt {
+		milestones = append(milestones, time.Duration(m))
+	}
+	sort.Slice(milestones, func(i, j int) bool { return milestones[i] > milestones[j] })
+	lang := d.AnnounceLang
+	if lang == "" {
+		_, lang = announceSettings()
+	}
+	setAnnounceSettings(milestones, lang)
+}
+
+// installShowConfig replaces the live lock and the push targets, dropping
+// targets c doesn't list.
+func installShowConfig(c ShowConfig) {
+	liveLockMutex.Lock()
+	lockWhileLive = c.LockWhileLive
+	liveLockMutex.Unlock()
+
+	keep := map[string]bool{}
+	for _, t := range c.Targets {
+		keep[t.Name] = true
+		setPushTarget(t)
+	}
+	for _, s := range pushTargetStatuses() {
+		if !keep[s.Name] {
+			removePushTarget(s.Name)
+		}
+	}
+
+	profileMutex.Lock()
+	activeProfile = c.Profile
+	profileMutex.Unlock()
+}
+
+// loadProfile switches to the named profile between questions.
+func loadProfile(name, origin string) error {
+	if questionInProgress() {
+		return errGameInProgress
+	}
+	p, err := readProfile(name)
+	if err != nil {
+		return err
+	}
+	applyProfile(name, p, origin)
+	return nil
+}
+
+// saveProfile writes the current settings as a new profile.
+func saveProfile(name, origin string) (string, error) {
+	path, err := profilePath(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("profile %s already exists", name)
+	}
+	data, err := json.MarshalIndent(currentProfile(), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(*profilesDir, 0o755); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return "", err
+	}
+	audit("profile_save", origin, map[string]interface{}{"name": name, "path": path})
+	return path, nil
+}
+
+// configureProfile loads -profile, if given, over the flag defaults.
+func configureProfile() error {
+	if *profileFlag == "" {
+		return nil
+	}
+	p, err := readProfile(*profileFlag)
+	if err != nil {
+		return err
+	}
+	applyProfile(*profileFlag, p, "startup")
+	return nil
+}
+
+func getProfile(c echo.Context) error {
+	profileMutex.Lock()
+	name := activeProfile
+	profileMutex.Unlock()
+	return c.JSON(http.StatusOK, ProfileView{Name: name, Settings: currentProfile()})
+}
+
+// handleProfileCommand runs "profile [load <name>|save <name>]".
+func handleProfileCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	switch {
+	case len(args) == 0:
+		profileMutex.Lock()
+		name := activeProfile
+		profileMutex.Unlock()
+		if name == "" {
+			name = "none (flag defaults)"
+		}
+		p := currentProfile()
+		info.Printf("Profile: %s\n", name)
+		info.Printf("  types:    %s\n", strings.Join(p.Types, ", "))
+		info.Printf("  targets:  %d\n", len(p.Targets))
+		info.Printf("  presets:  %d\n", len(p.Presets))
+		info.Printf("  announce: %d milestone(s) in %s\n", len(p.AnnounceAt), p.AnnounceLang)
+	case len(args) == 2 && args[0] == "load":
+		if err := loadProfile(args[1], origin); err != nil {
+			return err
+		}
+		success.Printf("Profile %s loaded\n", args[1])
+	case len(args) == 2 && args[0] == "save":
+		path, err := saveProfile(args[1], origin)
+		if err != nil {
+			return err
+		}
+		success.Printf("Profile saved to %s\n", path)
+	default:
+		return errors.New("Usage: profile [load <name>|save <name>]")
+	}
+	return nil
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	pushTimeout    = 5 * time.Second
+	pushMinBackoff = 500 * time.Millisecond
+	pushMaxBackoff = 30 * time.Second
+)
+
+var pushCoalesce = flag.Duration("push-coalesce", 300*time.Millisecond, "collect state changes this long into one push (urgent ones go out at once)")
+
+// PushTarget is a downstream server that receives the current question
+// whenever it changes.
+type PushTarget struct {
+	Name          string `json:"name"`
+	URL           string `json:"url"`
+	AuthHeader    string `json:"auth_header,omitempty"`
+	Enabled       bool   `json:"enabled"`
+	SchemaVersion int    `json:"schema_version"`
+	// HostScript opts an operator-facing target in to the host script.
+	HostScript bool `json:"host_script,omitempty"`
+	// Fields limits the question to these fields, and Exclude drops some;
+	// see targetfields.go.
+	Fields  []string `json:"fields,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// TargetStatus is the delivery state of one push target.
+type TargetStatus struct {
+	PushTarget
+	Pending   bool   `json:"pending"`
+	Attempts  int    `json:"attempts"`
+	Delivered uint64 `json:"delivered"`
+	// Skipped counts states not sent because only the derived time
+	// changed since the last delivery, Coalesced changes folded into a
+	// push of a later state.
+	Skipped     uint64     `json:"skipped"`
+	Coalesced   uint64     `json:"coalesced"`
+	Failures    uint64     `json:"failures"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// pushWorker delivers to a single target. Each worker has its own goroutine
+// and retry loop so a dead target never delays the others. Pending changes
+// coalesce: a change waits -push-coalesce for more to follow, and every
+// delivery reads the state after the wait, so an older state never goes
+// out after a newer one. An urgent change ends the wait at once.
+type pushWorker struct {
+	wake   chan struct{}
+	urgent chan struct{}
+	cancel chan struct{}
+	client *http.Client
+
+	mu     sync.Mutex
+	status TargetStatus
+	// sent is the pushKey of the last delivery and sentTo its URL.
+	sent   []byte
+	sentTo string
+}
+
+var (
+	pushWorkers      = map[string]*pushWorker{}
+	pushWorkersMutex sync.RWMutex
+
+	pushTargetFlags targetFlag
+	targetsFile     = flag.String("targets-file", "", "JSON file with a list of push targets")
+)
+
+// targetFlag collects repeated -push-target name=url flags.
+type targetFlag []PushTarget
+
+func (f *targetFlag) String() string {
+	names := make([]string, len(*f))
+	for i, t := range *f {
+		names[i] = t.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *targetFlag) Set(v string) error {
+	name, u, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("expected name=url")
+	}
+	*f = append(*f, PushTarget{Name: name, URL: u, Enabled: true, SchemaVersion: 1})
+	return nil
+}
+
+func init() {
+	flag.Var(&pushTargetFlags, "push-target", "push target as name=url, may be repeated (default flask="+flaskServerURL+"/set-current-question)")
+}
+
+// configuredTargets returns the targets given on the command line or in the
+// targets file, falling back to the local Flask server.
+func configuredTargets() ([]PushTarget, error) {
+	targets := append([]PushTarget(nil), pushTargetFlags...)
+	if *targetsFile != "" {
+		data, err := os.ReadFile(*targetsFile)
+		if err != nil {
+			return nil, err
+		}
+		var fromFile []PushTarget
+		if err := json.Unmarshal(data, &fromFile); err != nil {
+			return nil, fmt.Errorf("%s: %v", *targetsFile, err)
+		}
+		targets = append(targets, fromFile...)
+	}
+	if len(targets) == 0 {
+		targets = []PushTarget{{Name: "flask", URL: flaskServerURL + "/set-current-question", Enabled: true, SchemaVersion: 1}}
+	}
+	return targets, nil
+}
+
+// configurePushTargets starts a worker for each configured target.
+func configurePushTargets() error {
+	targets, err := configuredTargets()
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		// The synced server's state is compared before anything overwrites it.
+		push := !(*syncOnStart && t.Name == *syncTarget)
+		if err := startPushTarget(t, push); err != nil {
+			return fmt.Errorf("target %s: %v", t.Name, err)
+		}
+	}
+	return nil
+}
+
+func validatePushTarget(t PushTarget) error {
+	if t.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http(s) URL")
+	}
+	if t.SchemaVersion != 1 && t.SchemaVersion != 2 {
+		return fmt.Errorf("schema_version must be 1 or 2")
+	}
+	return validatePushFields(t)
+}
+
+// setPushTarget adds a target or replaces the one with the same name. A
+// replaced target's pending retries are cancelled, and an enabled target is
+// sent the current state straight away.
+func setPushTarget(t PushTarget) error {
+	return startPushTarget(t, true)
+}
+
+// startPushTarget is setPushTarget, optionally without the first push.
+func startPushTarget(t PushTarget, push bool) error {
+	if t.SchemaVersion == 0 {
+		t.SchemaVersion = 1
+	}
+	if err := validatePushTarget(t); err != nil {
+		return err
+	}
+	w := &pushWorker{
+		wake:   make(chan struct{}, 1),
+		urgent: make(chan struct{}, 1),
+		cancel: make(chan struct{}),
+		client: &http.Client{Timeout: pushTimeout},
+		status: TargetStatus{PushTarget: t, Pending: t.Enabled && push},
+	}
+	if t.Enabled && push {
+		w.wake <- struct{}{}
+	}
+
+	pushWorkersMutex.Lock()
+	if old, ok := pushWorkers[t.Name]; ok {
+		close(old.cancel)
+	}
+	pushWorkers[t.Name] = w
+	pushWorkersMutex.Unlock()
+
+	workers.goWorker("push "+t.Name, w.run)
+	return nil
+}
+
+// removePushTarget stops a target's worker, dropping any pending retry.
+func removePushTarget(name string) bool {
+	pushWorkersMutex.Lock()
+	defer pushWorkersMutex.Unlock()
+	w, ok := pushWorkers[name]
+	if !ok {
+		return false
+	}
+	close(w.cancel)
+	delete(pushWorkers, name)
+	return true
+}
+
+func setPushTargetEnabled(name string, enabled bool) bool {
+	pushWorkersMutex.RLock()
+	w, ok := pushWorkers[name]
+	pushWorkersMutex.RUnlock()
+	if !ok {
+		return false
+	}
+	w.mu.Lock()
+	w.status.Enabled = enabled
+	w.mu.Unlock()
+	return true
+}
+
+func pushTargetStatuses() []TargetStatus {
+	pushWorkersMutex.RLock()
+	defer pushWorkersMutex.RUnlock()
+	out := make([]TargetStatus, 0, len(pushWorkers))
+	for _, w := range pushWorkers {
+		w.mu.Lock()
+		out = append(out, w.status)
+		w.mu.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// redactedAuthHeader stands in for a target's credentials in HTTP
+// responses, which show that a target has them but never what they are.
+const redactedAuthHeader = "[redacted]"
+
+// publicTargetStatuses is pushTargetStatuses with the credentials masked.
+func publicTargetStatuses() []TargetStatus {
+	out := pushTargetStatuses()
+	for i := range out {
+		if out[i].AuthHeader != "" {
+			out[i].AuthHeader = redactedAuthHeader
+		}
+	}
+	return out
+}
+
+// pendingPushCount returns how many targets have an undelivered state.
+func pendingPushCount() int {
+	n := 0
+	for _, s := range pushTargetStatuses() {
+		if s.Pending {
+			n++
+		}
+	}
+	return n
+}
+
+// notifyPushTargets marks the current state as pending on every enabled
+// target without waiting for delivery. An urgent state skips the
+// coalescing wait.
+func notifyPushTargets(urgent bool) {
+	pushWorkersMutex.RLock()
+	defer pushWorkersMutex.RUnlock()
+	for _, w := range pushWorkers {
+		w.mu.Lock()
+		enabled := w.status.Enabled
+		if enabled {
+			w.status.Pending = true
+		}
+		w.mu.Unlock()
+		if !enabled {
+			continue
+		}
+		wake := w.wake
+		if urgent {
+			wake = w.urgent
+		}
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// forgetDelivery makes the next push to target go out even if its state is
+// the one delivered last, for when the target is known to show another.
+func forgetDelivery(target string) {
+	pushWorkersMutex.RLock()
+	w, ok := pushWorkers[target]
+	pushWorkersMutex.RUnlock()
+	if !ok {
+		return
+	}
+	w.mu.Lock()
+	w.sent = nil
+	w.mu.Unlock()
+}
+
+func (w *pushWorker) run() {
+	for {
+		urgent := false
+		select {
+		case <-w.cancel:
+			return
+		case <-w.wake:
+		case <-w.urgent:
+			urgent = true
+		}
+		if !urgent && *pushCoalesce > 0 {
+			select {
+			case <-w.cancel:
+				return
+			case <-w.urgent:
+			case <-clock.After(*pushCoalesce):
+			}
+		}
+		// What changed while waiting is in the state delivered now. A
+		// change after this point wakes the worker again.
+		w.settle()
+
+		backoff := pushMinBackoff
+		for {
+			err := w.deliver()
+			if err == nil {
+				break
+			}
+			select {
+			case <-w.cancel:
+				return
+			case <-clock.After(backoff):
+			}
+			if backoff *= 2; backoff > pushMaxBackoff {
+				backoff = pushMaxBackoff
+			}
+		}
+	}
+}
+
+// settle takes the changes that came in during the wait, which the coming
+// delivery includes.
+func (w *pushWorker) settle() {
+	folded := false
+	for _, ch := range []chan struct{}{w.wake, w.urgent} {
+		select {
+		case <-ch:
+			folded = true
+		default:
+		}
+	}
+	if folded {
+		w.mu.Lock()
+		w.status.Coalesced++
+		w.mu.Unlock()
+	}
+}
+
+// deliver sends the newest state to the target once.
+func (w *pushWorker) deliver() error {
+	w.mu.Lock()
+	target := w.status.PushTarget
+	now := clock.Now()
+	w.status.Attempts++
+	w.status.LastAttempt = &now
+	w.mu.Unlock()
+
+	body, err := pushPayload(target)
+	var key []byte
+	if err == nil {
+		key = pushKey(body)
+		w.mu.Lock()
+		same := w.sentTo == target.URL && bytes.Equal(w.sent, key)
+		if same {
+			w.status.Pending = false
+			w.status.Attempts = 0
+			w.status.Skipped++
+		}
+		w.mu.Unlock()
+		if same {
+			return nil
+		}
+		err = w.post(target, body)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		if w.status.LastError == "" {
+			notify(SeverityError, "push", "Push to %s failed: %v", target.Name, err)
+			postWebhook("push_failed", map[string]string{"target": target.Name, "error": err.Error()})
+		}
+		w.status.Failures++
+		w.status.LastError = err.Error()
+		return err
+	}
+	if w.status.LastError != "" {
+		notify(SeverityInfo, "push", "Push to %s recovered after %d attempts", target.Name, w.status.Attempts)
+	}
+	// A filtered payload isn't the whole state a sync compares.
+	if !filtersFields(target) {
+		noteSynced(target.Name, body)
+	}
+	w.sent, w.sentTo = key, target.URL
+	w.status.Pending = false
+	w.status.Attempts = 0
+	w.status.Delivered++
+	w.status.LastSuccess = &now
+	w.status.LastError = ""
+	return nil
+}
+
+func (w *pushWorker) post(target PushTarget, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if target.AuthHeader != "" {
+		req.Header.Set("Authorization", target.AuthHeader)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// pushKey is body without the derived time_left_ms, so that a state in
+// which only the clock ran on compares equal to the one delivered before.
+// Targets work the time out from start_time themselves.
+func pushKey(body []byte) []byte {
+	var m map[string]interface{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return body
+	}
+	delete(m, "time_left_ms")
+	if q, ok := m["question"].(map[string]interface{}); ok {
+		delete(q, "time_left_ms")
+	}
+	key, err := json.Marshal(m)
+	if err != nil {
+		return body
+	}
+	return key
+}
+
+// pushPayload encodes the current state in the target's schema version.
+// Version 1 is the raw question the Flask server has always received.
+func pushPayload(target PushTarget) ([]byte, error) {
+	questionMutex.RLock()
+	q := flaskQuestion(question)
+	if target.HostScript {
+		q.HostScript = question.HostScript
+	}
+	var derived map[string]interface{}
+	if wantsDerivedFields(target) {
+		view := publicQuestion(question)
+		derived = map[string]interface{}{"phase": view.Phase, "time_left_ms": view.TimeLeft.Milliseconds()}
+	}
+	rev := revision
+	questionMutex.RUnlock()
+
+	if filtersFields(target) {
+		selected, err := selectFields(target, q, derived)
+		if err != nil {
+			return nil, err
+		}
+		if target.SchemaVersion == 2 {
+			return json.Marshal(map[string]interface{}{"schema_version": 2, "revision": rev, "question": selected})
+		}
+		return json.Marshal(selected)
+	}
+	if target.SchemaVersion == 2 {
+		return json.Marshal(FlaskPushV2{SchemaVersion: 2, Revision: rev, Question: q})
+	}
+	return json.Marshal(q)
+}
+
+func getTargets(c echo.Context) error {
+	return c.JSON(http.StatusOK, publicTargetStatuses())
+}
+
+func getSyncStatus(c echo.Context) error {
+	statuses := publicTargetStatuses()
+	questionMutex.RLock()
+	rev := revision
+	questionMutex.RUnlock()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"revision": rev,
+		"pending":  pendingPushCount(),
+		"targets":  statuses,
+	})
+}
+
+func postTarget(c echo.Context) error {
+	t := PushTarget{Enabled: true, SchemaVersion: 1}
+	if err := bindJSON(c, &t); err != nil {
+		return bindError(err)
+	}
+	if err := setPushTarget(t); err != nil {
+		return badRequest(err.Error())
+	}
+	audit("target_set", requestOrigin(c), map[string]interface{}{"name": t.Name, "url": t.URL})
+	return c.JSON(http.StatusOK, publicTargetStatuses())
+}
+
+func deleteTarget(c echo.Context) error {
+	if !removePushTarget(c.Param("name")) {
+		return notFound("target not found")
+	}
+	audit("target_remove", requestOrigin(c), map[string]interface{}{"name": c.Param("name")})
+	return c.NoContent(http.StatusNoContent)
+}
+
+func handleTargetCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 0 || args[0] == "list" {
+		for _, s := range pushTargetStatuses() {
+			state := "ok"
+			if !s.Enabled {
+				state = "disabled"
+			} else if s.LastError != "" {
+				state = "failing: " + s.LastError
+			} else if s.Pending {
+				state = "pending"
+			}
+			info.Printf("  %-12s v%d %-40s %s\n", s.Name, s.SchemaVersion, s.URL, state)
+		}
+		return nil
+	}
+	switch args[0] {
+	case "add":
+		if len(args) != 3 {
+			return errors.New("Usage: target add <name> <url>")
+		}
+		if err := setPushTarget(PushTarget{Name: args[1], URL: args[2], Enabled: true}); err != nil {
+			return fmt.Errorf("Invalid target: %v", err)
+		}
+		audit("target_set", origin, map[string]interface{}{"name": args[1], "url": args[2]})
+		success.Printf("Target %s added\n", args[1])
+	case "show":
+		if len(args) != 2 {
+			return errors.New("Usage: target show <name>")
+		}
+		return showPushTarget(args[1])
+	case "rm", "enable", "disable":
+		if len(args) != 2 {
+			return fmt.Errorf("Usage: target %s <name>", args[0])
+		}
+		var ok bool
+		if args[0] == "rm" {
+			ok = removePushTarget(args[1])
+		} else {
+			ok = setPushTargetEnabled(args[1], args[0] == "enable")
+		}
+		if !ok {
+			return fmt.Errorf("Unknown target: %s", args[1])
+		}
+		audit("target_"+args[0], origin, map[string]interface{}{"name": args[1]})
+		success.Printf("Target %s: %s done\n", args[1], args[0])
+	default:
+		return errors.New("Usage: target [list|add <name> <url>|show|rm|enable|disable <name>]")
+	}
+	return nil
+}
+
+// showPushTarget prints the field set a target receives and what it would
+// be sent now.
+func showPushTarget(name string) error {
+	info := color.New(color.FgYellow)
+
+	for _, s := range pushTargetStatuses() {
+		if s.Name != name {
+			continue
+		}
+		info.Printf("%s: %s (schema version %d)\n", s.Name, s.URL, s.SchemaVersion)
+		info.Printf("Fields: %s\n", strings.Join(effectiveFields(s.PushTarget), ", "))
+		body, err := pushPayload(s.PushTarget)
+		if err != nil {
+			return fmt.Errorf("Building the payload failed: %v", err)
+		}
+		var pretty bytes.Buffer
+		json.Indent(&pretty, body, "  ", "  ")
+		info.Printf("Sample payload:\n  %s\n", pretty.String())
+		return nil
+	}
+	return fmt.Errorf("Unknown target: %s", name)
+}
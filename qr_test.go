@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// The QR tests decode what the encoder drew, with a reader written from
+// the standard rather than from the encoder: read the format bits, unmask,
+// follow the zigzag, split the blocks, check each block's Reed-Solomon
+// syndromes and parse the byte-mode segment. Versions 1 to 10 cover every
+// join URL that fits on a projector.
+
+// Level M block layout per version: error correction codewords per block
+// and the data codewords of each block.
+var qrTestBlocks = [11]struct {
+	ecc  int
+	data []int
+}{
+	1:  {10, []int{16}},
+	2:  {16, []int{28}},
+	3:  {26, []int{44}},
+	4:  {18, []int{32, 32}},
+	5:  {24, []int{43, 43}},
+	6:  {16, []int{27, 27, 27, 27}},
+	7:  {18, []int{31, 31, 31, 31}},
+	8:  {22, []int{38, 38, 39, 39}},
+	9:  {22, []int{36, 36, 36, 37, 37}},
+	10: {26, []int{43, 43, 43, 43, 44}},
+}
+
+var qrTestAlignment = [11][]int{
+	2: {6, 18}, 3: {6, 22}, 4: {6, 26}, 5: {6, 30}, 6: {6, 34},
+	7: {6, 22, 38}, 8: {6, 24, 42}, 9: {6, 26, 46}, 10: {6, 28, 50},
+}
+
+// qrTestFunction reports whether (x, y) of a symbol of version v is a
+// function module.
+func qrTestFunction(v, x, y int) bool {
+	size := 4*v + 17
+	switch {
+	case x < 9 && y < 9, x >= size-8 && y < 9, x < 9 && y >= size-8:
+		return true
+	case x == 6 || y == 6:
+		return true
+	case v >= 7 && ((x >= size-11 && x < size-8 && y < 6) || (y >= size-11 && y < size-8 && x < 6)):
+		return true
+	}
+	pos := qrTestAlignment[v]
+	for i, ax := range pos {
+		for j, ay := range pos {
+			corner := (i == 0 && j == 0) || (i == 0 && j == len(pos)-1) || (i == len(pos)-1 && j == 0)
+			if !corner && abs(x-ax) <= 2 && abs(y-ay) <= 2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func qrTestMask(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (y+x)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (y+x)%3 == 0
+	case 4:
+		return (y/2+x/3)%2 == 0
+	case 5:
+		return (y*x)%2+(y*x)%3 == 0
+	case 6:
+		return ((y*x)%2+(y*x)%3)%2 == 0
+	default:
+		return ((y+x)%2+(y*x)%3)%2 == 0
+	}
+}
+
+// qrTestGF holds exp and log tables of GF(2^8) with the QR polynomial.
+var qrTestExp, qrTestLog = func() ([512]byte, [256]int) {
+	var exp [512]byte
+	var log [256]int
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = byte(x), byte(x)
+		log[x] = i
+		if x <<= 1; x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	return exp, log
+}()
+
+// qrTestSyndromesZero checks that block, data then error correction, is a
+// codeword: the polynomial vanishes at α^0 … α^(ecc-1).
+func qrTestSyndromesZero(block []byte, ecc int) bool {
+	for j := 0; j < ecc; j++ {
+		var s byte
+		for _, c := range block {
+			if s != 0 {
+				s = qrTestExp[qrTestLog[s]+j]
+			}
+			s ^= c
+		}
+		if s != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeQRMatrix reads the byte-mode data from a level M symbol.
+func decodeQRMatrix(m [][]bool) ([]byte, error) {
+	size := len(m)
+	v := (size - 17) / 4
+	if v < 1 || v > 10 || 4*v+17 != size {
+		return nil, fmt.Errorf("size %d is not a version 1 to 10 symbol", size)
+	}
+	dark := func(x, y int) bool { return m[y][x] }
+
+	// Format: 15 bits next to the top left finder, repeated by the other
+	// two.
+	var format, copy2 int
+	pos1 := [][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8}, {7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}}
+	for i, p := range pos1 {
+		if dark(p[0], p[1]) {
+			format |= 1 << i
+		}
+	}
+	for i := 0; i < 15; i++ {
+		x, y := size-1-i, 8
+		if i >= 8 {
+			x, y = 8, size-15+i
+		}
+		if dark(x, y) {
+			copy2 |= 1 << i
+		}
+	}
+	if format != copy2 {
+		return nil, fmt.Errorf("format copies differ: %015b and %015b", format, copy2)
+	}
+	info := format ^ 0x5412
+	if level := info >> 13; level != 0 {
+		return nil, fmt.Errorf("error correction level bits %02b, want M", level)
+	}
+	mask := info >> 10 & 7
+	if !dark(8, size-8) {
+		return nil, fmt.Errorf("no dark module")
+	}
+
+	blocks := qrTestBlocks[v]
+	total := 0
+	for _, n := range blocks.data {
+		total += n + blocks.ecc
+	}
+	var raw []byte
+	var cur, nbits int
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = size - 1 - vert
+				}
+				if qrTestFunction(v, x, y) || len(raw) == total {
+					continue
+				}
+				bit := dark(x, y) != qrTestMask(mask, x, y)
+				cur <<= 1
+				if bit {
+					cur |= 1
+				}
+				if nbits++; nbits == 8 {
+					raw = append(raw, byte(cur))
+					cur, nbits = 0, 0
+				}
+			}
+		}
+	}
+	if len(raw) != total {
+		return nil, fmt.Errorf("read %d codewords, want %d", len(raw), total)
+	}
+
+	split := make([][]byte, len(blocks.data))
+	k := 0
+	for i := 0; i < blocks.data[len(blocks.data)-1]; i++ {
+		for b, n := range blocks.data {
+			if i < n {
+				split[b] = append(split[b], raw[k])
+				k++
+			}
+		}
+	}
+	var data []byte
+	for _, b := range split {
+		data = append(data, b...)
+	}
+	for i := 0; i < blocks.ecc; i++ {
+		for b := range split {
+			split[b] = append(split[b], raw[k])
+			k++
+		}
+	}
+	for b, block := range split {
+		if !qrTestSyndromesZero(block, blocks.ecc) {
+			return nil, fmt.Errorf("block %d is not a Reed-Solomon codeword", b)
+		}
+	}
+
+	bit := func(i int) int { return int(data[i/8] >> (7 - i%8) & 1) }
+	read := func(at, n int) int {
+		x := 0
+		for i := 0; i < n; i++ {
+			x = x<<1 | bit(at+i)
+		}
+		return x
+	}
+	if mode := read(0, 4); mode != 0b0100 {
+		return nil, fmt.Errorf("mode %04b, want byte mode", mode)
+	}
+	countBits := 8
+	if v >= 10 {
+		countBits = 16
+	}
+	n := read(4, countBits)
+	start := 4 + countBits
+	if start+8*n > 8*len(data) {
+		return nil, fmt.Errorf("length %d does not fit", n)
+	}
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = byte(read(start+8*i, 8))
+	}
+	return out, nil
+}
+
+// parseQRTerminal turns the half-block rendering back into modules,
+// checking the two-module border is light.
+func parseQRTerminal(t *testing.T, s string, size int) [][]bool {
+	t.Helper()
+	const border = 2
+	m := make([][]bool, size)
+	for i := range m {
+		m[i] = make([]bool, size)
+	}
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if want := (size + 2*border + 1) / 2; len(lines) != want {
+		t.Fatalf("%d lines, want %d", len(lines), want)
+	}
+	for row, line := range lines {
+		cells := []rune(line)
+		if len(cells) != size+2*border {
+			t.Fatalf("line %d is %d wide, want %d", row, len(cells), size+2*border)
+		}
+		for col, r := range cells {
+			var top, bottom bool // light
+			switch r {
+			case '█':
+				top, bottom = true, true
+			case '▀':
+				top = true
+			case '▄':
+				bottom = true
+			case ' ':
+			default:
+				t.Fatalf("unexpected %q at line %d", r, row)
+			}
+			x := col - border
+			for i, light := range []bool{top, bottom} {
+				y := 2*row - border + i
+				if y >= size+border {
+					continue
+				}
+				if x < 0 || x >= size || y < 0 || y >= size {
+					if !light {
+						t.Fatalf("dark module in the border at %d,%d", x, y)
+					}
+					continue
+				}
+				m[y][x] = !light
+			}
+		}
+	}
+	return m
+}
+
+var qrTestURLs = []string{
+	"http://q.sk",
+	"http://192.168.1.20:8080/",
+	"http://192.168.1.20:8080/audience?room=finale&lang=sk",
+	"https://kviz.example.com/join/" + strings.Repeat("x", 60),
+	"https://kviz.example.com/join/" + strings.Repeat("y", 120),
+	"https://kviz.example.com/join/" + strings.Repeat("z", 180),
+}
+
+func TestQRDecodes(t *testing.T) {
+	versions := map[int]bool{}
+	for _, u := range qrTestURLs {
+		q, err := encodeQR([]byte(u))
+		if err != nil {
+			t.Fatalf("%s: %v", u, err)
+		}
+		versions[q.version] = true
+		got, err := decodeQRMatrix(q.modules)
+		if err != nil {
+			t.Fatalf("%s (version %d): %v", u, q.version, err)
+		}
+		if string(got) != u {
+			t.Errorf("version %d decodes to %q, want %q", q.version, got, u)
+		}
+	}
+	if len(versions) < 5 {
+		t.Errorf("only versions %v covered", versions)
+	}
+}
+
+func TestQRPicksSmallestVersion(t *testing.T) {
+	// Byte-mode capacity at level M of versions 1 to 3.
+	for _, tc := range []struct{ n, version int }{{14, 1}, {15, 2}, {26, 2}, {27, 3}} {
+		q, err := encodeQR(bytes.Repeat([]byte("a"), tc.n))
+		if err != nil || q.version != tc.version {
+			t.Errorf("%d bytes: version %v, %v, want %d", tc.n, q.version, err, tc.version)
+		}
+	}
+	if _, err := encodeQR(make([]byte, 3000)); err == nil {
+		t.Error("3000 bytes fit")
+	}
+}
+
+func TestQRTerminalDecodes(t *testing.T) {
+	for _, u := range qrTestURLs[:3] {
+		q, err := encodeQR([]byte(u))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeQRMatrix(parseQRTerminal(t, q.terminal(), q.size))
+		if err != nil || string(got) != u {
+			t.Errorf("terminal rendering of %s decodes to %q, %v", u, got, err)
+		}
+	}
+}
+
+func TestJoinQRPNG(t *testing.T) {
+	s := StartTestServer(t)
+	defer func(old string) { *joinURLTemplate = old }(*joinURLTemplate)
+	*joinURLTemplate = "http://quiz.local/audience?room={{room}}"
+	if err := setTemplateVar("room", "finale", "test"); err != nil {
+		t.Fatal(err)
+	}
+	const u = "http://quiz.local/audience?room=finale"
+
+	req := s.NewRequest(t, http.MethodGet, "/join-qr.png?size=512", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("status %d, content type %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	img, err := png.Decode(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sample the middle of every module's pixels.
+	q, _ := encodeQR([]byte(u))
+	modules := q.size + 2*qrQuietZone
+	scale := img.Bounds().Dx() / modules
+	if scale < 1 || img.Bounds().Dx() > 512 {
+		t.Fatalf("%d pixels for %d modules", img.Bounds().Dx(), modules)
+	}
+	m := make([][]bool, q.size)
+	for y := range m {
+		m[y] = make([]bool, q.size)
+		for x := range m[y] {
+			r, _, _, _ := img.At((x+qrQuietZone)*scale+scale/2, (y+qrQuietZone)*scale+scale/2).RGBA()
+			m[y][x] = r < 0x8000
+		}
+	}
+	if got, err := decodeQRMatrix(m); err != nil || string(got) != u {
+		t.Fatalf("PNG decodes to %q, %v", got, err)
+	}
+
+	etag := resp.Header.Get("ETag")
+	req = s.NewRequest(t, http.MethodGet, "/join-qr.png?size=512", nil)
+	req.Header.Set("If-None-Match", etag)
+	if resp := s.Send(t, req, nil); etag == "" || resp.StatusCode != http.StatusNotModified {
+		t.Errorf("revalidating with %q: status %d", etag, resp.StatusCode)
+	}
+}
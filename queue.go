@@ -0,0 +1,529 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// QueueEntry is a prepared question waiting to go live.
+type QueueEntry struct {
+	ID       int           `json:"id"`
+	Question string        `json:"question"`
+	TimeLeft time.Duration `json:"time_left"`
+	Type     string        `json:"type"`
+	CountUp  bool          `json:"count_up"`
+	Round    int           `json:"round"`
+	Notes    string        `json:"notes,omitempty"`
+	Asked    bool          `json:"asked"`
+	// RoundName names the round for overlays; one entry of the round is
+	// enough.
+	RoundName string `json:"round_name,omitempty"`
+	// GroupID joins entries that must run one after the other, see
+	// groups.go.
+	GroupID string `json:"group_id,omitempty"`
+	// Checklist is what the operator must tick off before the entry goes
+	// live, see checklist.go.
+	Checklist []string `json:"checklist,omitempty"`
+
+	// AllowOvertime, AnswerWindow, OptionsRevealAt, ReadingTime, Variants,
+	// MediaURL, MediaFallbackText, Options, Scoring, Matching, HostScript
+	// and BankID are copied onto the question when it goes live.
+	AllowOvertime     bool              `json:"allow_overtime,omitempty"`
+	AnswerWindow      time.Duration     `json:"answer_window,omitempty"`
+	OptionsRevealAt   time.Duration     `json:"options_reveal_at,omitempty"`
+	ReadingTime       time.Duration     `json:"reading_time,omitempty"`
+	Variants          map[string]string `json:"variants,omitempty"`
+	MediaURL          string            `json:"media_url,omitempty"`
+	MediaFallbackText string            `json:"media_fallback_text,omitempty"`
+	Options           []AnswerOption    `json:"options,omitempty"`
+	Scoring           *ScoringPolicy    `json:"scoring,omitempty"`
+	Matching          *MatchRules       `json:"matching,omitempty"`
+	HostScript        []string          `json:"host_script,omitempty"`
+	BankID            int               `json:"bank_id,omitempty"`
+}
+
+var (
+	queue       []QueueEntry
+	queueMutex  sync.RWMutex
+	nextQueueID = 1
+
+	// liveEntry is the queue entry currently on screen, or 0 when the live
+	// question was set directly.
+	liveEntry int
+
+	// queueRevision goes up on every change of the queue, see
+	// queueorder.go.
+	queueRevision uint64
+)
+
+// QueueEntryRequest is one entry in POST /queue.
+type QueueEntryRequest struct {
+	QuestionRequest
+	Round     int      `json:"round"`
+	RoundName string   `json:"round_name,omitempty"`
+	GroupID   string   `json:"group_id,omitempty"`
+	Checklist []string `json:"checklist,omitempty"`
+}
+
+func (e QueueEntry) question() Question {
+	return Question{Question: e.Question, TimeLeft: e.TimeLeft, Type: e.Type, CountUp: e.CountUp, Notes: e.Notes, HostScript: e.HostScript, AllowOvertime: e.AllowOvertime, AnswerWindow: e.AnswerWindow, OptionsRevealAt: e.OptionsRevealAt, ReadingTime: e.ReadingTime, Variants: e.Variants, MediaURL: e.MediaURL, MediaFallbackText: e.MediaFallbackText, Options: e.Options, Scoring: e.Scoring, Matching: e.Matching, BankID: e.BankID}
+}
+
+func enqueue(entries []QueueEntry, origin string) ([]QueueEntry, error) {
+	for i, e := range entries {
+		if strings.TrimSpace(e.Question) == "" {
+			return nil, fmt.Errorf("entry %d: question is required", i)
+		}
+		if e.Type == "" {
+			e.Type = "pomoc"
+		}
+		if err := validateQuestion(e.question()); err != nil {
+			return nil, fmt.Errorf("entry %d: %v", i, err)
+		}
+		entries[i].Type = e.Type
+		entries[i].Checklist = cleanNames(e.Checklist)
+	}
+
+	queueMutex.Lock()
+	if err := checkQueueGroups(append(append([]QueueEntry{}, queue...), entries...)); err != nil {
+		queueMutex.Unlock()
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].ID = nextQueueID
+		entries[i].Asked = false
+		nextQueueID++
+		queue = append(queue, entries[i])
+	}
+	queueRevision++
+	queueMutex.Unlock()
+	audit("queue_add", origin, map[string]interface{}{"count": len(entries)})
+	announceQueue("add")
+	checkPreload()
+	return entries, nil
+}
+
+// removeQueueEntry removes entry id, and with it the rest of its group. It
+// returns the IDs removed.
+func removeQueueEntry(id int, origin string) []int {
+	queueMutex.Lock()
+	var removed []int
+	for i, e := range queue {
+		if e.ID == id {
+			start, end := groupSpan(queue, i)
+			for _, r := range queue[start:end] {
+				removed = append(removed, r.ID)
+			}
+			queue = append(queue[:start], queue[end:]...)
+			queueRevision++
+			break
+		}
+	}
+	queueMutex.Unlock()
+	if len(removed) > 0 {
+		audit("queue_remove", origin, map[string]interface{}{"id": id, "removed": removed})
+		announceQueue("remove")
+		checkPreload()
+	}
+	return removed
+}
+
+// clearLiveEntry forgets the live queue entry when a question is set
+// directly.
+func clearLiveEntry() {
+	queueMutex.Lock()
+	liveEntry = 0
+	queueMutex.Unlock()
+}
+
+func listQueue() []QueueEntry {
+	queueMutex.RLock()
+	defer queueMutex.RUnlock()
+	return append([]QueueEntry{}, queue...)
+}
+
+// liveQueueEntry returns the entry on screen, if the live question came
+// from the queue.
+func liveQueueEntry() (QueueEntry, bool) {
+	queueMutex.RLock()
+	defer queueMutex.RUnlock()
+	for _, e := range queue {
+		if e.ID == liveEntry {
+			return e, true
+		}
+	}
+	return QueueEntry{}, false
+}
+
+// nextQuestion puts the first entry that hasn't been asked yet on air. An
+// entry with a checklist needs every item ticked off, unless forced.
+func nextQuestion(force bool, origin string) (QueueEntry, error) {
+	if ceremonyActive() {
+		return QueueEntry{}, errCeremonyActive
+	}
+	entry, ok := nextQueueEntry()
+	if !ok {
+		return QueueEntry{}, fmt.Errorf("the queue is empty")
+	}
+	if err := checkChecklist(entry, force, origin); err != nil {
+		return entry, err
+	}
+
+	q := entry.question()
+	q.Meta = queueMeta(entry)
+	if _, err := goLive(q, "next", origin); err != nil {
+		return entry, err
+	}
+
+	queueMutex.Lock()
+	for i := range queue {
+		if queue[i].ID == entry.ID {
+			queue[i].Asked = true
+		}
+	}
+	liveEntry = entry.ID
+	queueRevision++
+	queueMutex.Unlock()
+	entry.Asked = true
+	announceQueue("next")
+	checkPreload()
+	checkRundown()
+	webhookRoundPlayed(entry.Round)
+	return entry, nil
+}
+
+// getQueue lists the queue. X-Queue-Revision carries the revision moves
+// are checked against.
+func getQueue(c echo.Context) error {
+	snap := queueSnapshot()
+	c.Response().Header().Set(headerQueueRevision, strconv.FormatUint(snap.Revision, 10))
+	return c.JSON(http.StatusOK, snap.Entries)
+}
+
+// postQueue appends one entry or an array of entries.
+func postQueue(c echo.Context) error {
+	var raw json.RawMessage
+	if err := bindJSON(c, &raw); err != nil {
+		return bindError(err)
+	}
+	var reqs []QueueEntryRequest
+	if len(raw) > 0 && raw[0] == '[' {
+		if err := decodeBody(c, raw, &reqs); err != nil {
+			return bindError(err)
+		}
+	} else {
+		var one QueueEntryRequest
+		if err := decodeBody(c, raw, &one); err != nil {
+			return bindError(err)
+		}
+		reqs = []QueueEntryRequest{one}
+	}
+	entries := make([]QueueEntry, len(reqs))
+	for i, r := range reqs {
+		if err := probeQuestionMedia(r.MediaURL, r.MediaFallbackText); err != nil {
+			return mediaError(err)
+		}
+		entries[i] = QueueEntry{
+			Question: r.Question,
+			TimeLeft: time.Duration(r.TimeLeft),
+			Type:     r.Type,
+			CountUp:  r.CountUp,
+			Round:    r.Round,
+			Notes:    r.Notes,
+
+			RoundName:         r.RoundName,
+			GroupID:           r.GroupID,
+			Checklist:         r.Checklist,
+			AllowOvertime:     r.AllowOvertime,
+			AnswerWindow:      time.Duration(r.AnswerWindow),
+			OptionsRevealAt:   time.Duration(r.OptionsRevealAt),
+			ReadingTime:       time.Duration(r.ReadingTime),
+			Variants:          r.Variants,
+			MediaURL:          r.MediaURL,
+			MediaFallbackText: r.MediaFallbackText,
+			Options:           r.Options,
+			Scoring:           r.Scoring,
+			Matching:          r.Matching,
+			HostScript:        r.HostScript,
+		}
+	}
+	added, err := enqueue(entries, requestOrigin(c))
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	checkRundown()
+	return c.JSON(http.StatusOK, added)
+}
+
+func deleteQueueEntry(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return badRequest("invalid queue entry id")
+	}
+	if len(removeQueueEntry(id, requestOrigin(c))) == 0 {
+		return notFound("queue entry not found")
+	}
+	checkRundown()
+	return c.NoContent(http.StatusNoContent)
+}
+
+// TimeAdjustRequest is the body of POST /queue/adjust-time. MinSeconds
+// defaults to 1 so no entry is left without time.
+type TimeAdjustRequest struct {
+	DeltaSeconds int  `json:"delta_seconds"`
+	MinSeconds   *int `json:"min_seconds"`
+}
+
+// TimeAdjustResult summarizes a bulk time adjustment of the queue.
+type TimeAdjustResult struct {
+	Changed     int           `json:"changed"`
+	Clamped     int           `json:"clamped"`
+	TotalBefore time.Duration `json:"total_before"`
+	TotalAfter  time.Duration `json:"total_after"`
+}
+
+// adjustQueueTime adds delta to the time of every entry not asked yet, in
+// one step, never going below floor; an entry already shorter than that is
+// left as it is. Count-up entries have no time to adjust. An answer window
+// is shortened along with the time if it would no longer fit.
+func adjustQueueTime(delta, floor time.Duration, origin string) TimeAdjustResult {
+	var res TimeAdjustResult
+	queueMutex.Lock()
+	for i := range queue {
+		e := &queue[i]
+		if e.Asked || e.CountUp {
+			continue
+		}
+		res.TotalBefore += e.TimeLeft
+		t := e.TimeLeft + delta
+		if lowest := min(e.TimeLeft, floor); t < lowest {
+			t = lowest
+			res.Clamped++
+		}
+		if t != e.TimeLeft {
+			res.Changed++
+		}
+		e.TimeLeft = t
+		if e.AnswerWindow > t {
+			e.AnswerWindow = t
+		}
+		if e.OptionsRevealAt > t {
+			e.OptionsRevealAt = t
+		}
+		res.TotalAfter += t
+	}
+	if res.Changed > 0 {
+		queueRevision++
+	}
+	queueMutex.Unlock()
+	audit("queue_time", origin, map[string]interface{}{
+		"delta":        delta.Seconds(),
+		"min":          floor.Seconds(),
+		"changed":      res.Changed,
+		"clamped":      res.Clamped,
+		"total_before": res.TotalBefore.Seconds(),
+		"total_after":  res.TotalAfter.Seconds(),
+	})
+	if res.Changed > 0 {
+		announceQueue("time")
+	}
+	checkRundown()
+	return res
+}
+
+func postQueueAdjustTime(c echo.Context) error {
+	req := new(TimeAdjustRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	floor := 1
+	if req.MinSeconds != nil {
+		floor = *req.MinSeconds
+	}
+	if floor < 0 {
+		return badRequest("min_seconds must be non-negative")
+	}
+	res := adjustQueueTime(time.Duration(req.DeltaSeconds)*time.Second, time.Duration(floor)*time.Second, requestOrigin(c))
+	return c.JSON(http.StatusOK, res)
+}
+
+func postQueueNext(c echo.Context) error {
+	entry, err := nextQuestion(c.QueryParam("force") == "true", requestOrigin(c))
+	if err == errCeremonyActive {
+		return conflict(err.Error())
+	}
+	if cerr, ok := err.(*ChecklistError); ok {
+		return checklistError(cerr)
+	}
+	if _, unknown := err.(*UnknownVariableError); unknown {
+		return templateError(err)
+	}
+	if err != nil {
+		return conflict(err.Error())
+	}
+	return c.JSON(http.StatusOK, entry)
+}
+
+// handleNextCommand runs "next [--force]".
+func handleNextCommand(args []string, origin string) (QueueEntry, error) {
+	success := color.New(color.FgGreen)
+
+	force := len(args) == 1 && args[0] == "--force"
+	if len(args) > 0 && !force {
+		return QueueEntry{}, errors.New("Usage: next [--force]")
+	}
+	entry, err := nextQuestion(force, origin)
+	if err != nil {
+		if _, ok := err.(*ChecklistError); ok {
+			return QueueEntry{}, withHint(err, "Tick them off with 'check <item>', or go live anyway with 'next --force'")
+		}
+		return QueueEntry{}, err
+	}
+	success.Printf("Round %d, question %d: %s\n", entry.Round, entry.ID, entry.Question)
+	questionMutex.RLock()
+	m := question.Meta
+	questionMutex.RUnlock()
+	if m != nil && m.GroupSize > 0 {
+		success.Printf("Part %d of %d of %s\n", m.GroupPart, m.GroupSize, m.GroupID)
+	}
+	return entry, nil
+}
+
+// nextQueueEntry returns the first entry that hasn't been asked yet.
+func nextQueueEntry() (QueueEntry, bool) {
+	queueMutex.RLock()
+	defer queueMutex.RUnlock()
+	for _, e := range queue {
+		if !e.Asked {
+			return e, true
+		}
+	}
+	return QueueEntry{}, false
+}
+
+func handlePreviewCommand() {
+	info := color.New(color.FgYellow)
+
+	e, ok := nextQueueEntry()
+	if !ok {
+		info.Println("The queue is empty")
+		return
+	}
+	info.Printf("Next (round %d, %s, %s): %s\n", e.Round, e.Type, e.TimeLeft, e.Question)
+	if e.Notes != "" {
+		info.Printf("Notes: %s\n", e.Notes)
+	}
+	if e.MediaURL != "" {
+		info.Printf("Media: %s\n", e.MediaURL)
+	}
+	printChecklist()
+}
+
+func handleQueueCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 0 {
+		list := listQueue()
+		if len(list) == 0 {
+			info.Println("The queue is empty")
+			return nil
+		}
+		live, _ := liveQueueEntry()
+		for _, e := range list {
+			mark := " "
+			if e.ID == live.ID {
+				mark = ">"
+			} else if e.Asked {
+				mark = "x"
+			}
+			group := ""
+			if e.GroupID != "" {
+				group = " [" + e.GroupID + "]"
+			}
+			info.Printf(" %s %3d  R%d  %4ds  %-8s %s%s\n", mark, e.ID, e.Round, int(e.TimeLeft/time.Second), e.Type, e.Question, group)
+		}
+		return nil
+	}
+	switch args[0] {
+	case "add":
+		if len(args) < 4 {
+			return errors.New("Usage: queue add <round> <time> <text>")
+		}
+		round, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errors.New("Round must be an integer")
+		}
+		timeLeft, err := parseDurationArg(args[2])
+		if err != nil {
+			return err
+		}
+		added, err := enqueue([]QueueEntry{{
+			Question: strings.Join(args[3:], " "),
+			TimeLeft: timeLeft,
+			Round:    round,
+		}}, origin)
+		if err != nil {
+			return err
+		}
+		checkRundown()
+		success.Printf("Queued as %d\n", added[0].ID)
+	case "rm":
+		id, err := strconv.Atoi(strings.Join(args[1:], ""))
+		if err != nil {
+			return errors.New("Usage: queue rm <id>")
+		}
+		if !confirmCLI("queue_remove", queueRemovalSummary(id), strconv.Itoa(id)) {
+			return errors.New("Queue entry removal cancelled")
+		}
+		removed := removeQueueEntry(id, origin)
+		if len(removed) == 0 {
+			return errors.New("Usage: queue rm <id>")
+		}
+		checkRundown()
+		if len(removed) > 1 {
+			success.Printf("Removed %d and the rest of its group %v from the queue\n", id, removed)
+			return nil
+		}
+		success.Printf("Removed %d from the queue\n", id)
+	case "time":
+		return handleQueueTimeCommand(args[1:], origin)
+	case "move":
+		return handleQueueMoveCommand(args[1:], origin)
+	default:
+		return errors.New("Usage: queue [add <round> <seconds> <text>|rm <id>|time <±seconds> [--min <seconds>]|move <id> <position>]")
+	}
+	return nil
+}
+
+// handleQueueTimeCommand runs "queue time <±seconds> [--min <seconds>]".
+func handleQueueTimeCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	usage := "Usage: queue time <±seconds> [--min <seconds>]"
+	if len(args) != 1 && !(len(args) == 3 && args[1] == "--min") {
+		return errors.New(usage)
+	}
+	delta, err := strconv.Atoi(args[0])
+	if err != nil {
+		return errors.New(usage)
+	}
+	floor := time.Second
+	if len(args) == 3 {
+		if floor, err = parseDurationArg(args[2]); err != nil {
+			return err
+		}
+	}
+	res := adjustQueueTime(time.Duration(delta)*time.Second, floor, origin)
+	success.Printf("Adjusted %d entries (%d clamped at %s): %s -> %s in total\n", res.Changed, res.Clamped, floor, res.TotalBefore, res.TotalAfter)
+	return nil
+}
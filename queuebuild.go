@@ -0,0 +1,678 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// maxBuildSteps bounds the backtracking of the queue builder, so a bank
+// that can't meet the constraints fails in time.
+const maxBuildSteps = 50000
+
+// unratedDifficulty places unrated questions on the difficulty curve.
+const unratedDifficulty = (maxDifficulty + 1) / 2
+
+// BuildRequest is the constraints of POST /queue/build. Without Confirm it
+// only proposes a queue; with Confirm it installs the proposal in place of
+// the questions not yet asked.
+type BuildRequest struct {
+	// Total is the number of questions, the sum of Rounds when unset.
+	Total int `json:"total"`
+	// Rounds is how many questions to take from each bank round.
+	Rounds map[int]int `json:"rounds,omitempty"`
+	// Tags is at least how many questions carry each tag.
+	Tags map[string]int `json:"tags,omitempty"`
+	// Curve "rising" orders each round from easy to hard.
+	Curve string `json:"curve,omitempty"`
+	// ExcludeSessions leaves out what was asked in the last this many
+	// archived sessions.
+	ExcludeSessions int  `json:"exclude_sessions,omitempty"`
+	Confirm         bool `json:"confirm,omitempty"`
+	// Proposal is the proposal reviewed before confirming. When the bank
+	// changed since and the build comes out different, nothing is
+	// installed.
+	Proposal string `json:"proposal,omitempty"`
+}
+
+// BuildProposal is a built queue.
+type BuildProposal struct {
+	Proposal  string         `json:"proposal"`
+	Entries   []BuildPick    `json:"entries"`
+	Tags      map[string]int `json:"tags,omitempty"`
+	Excluded  int            `json:"excluded"`
+	Installed bool           `json:"installed"`
+}
+
+// BuildPick is one bank entry of a proposal.
+type BuildPick struct {
+	BankID     int        `json:"bank_id"`
+	Question   string     `json:"question"`
+	Type       string     `json:"type"`
+	Round      int        `json:"round"`
+	Difficulty int        `json:"difficulty,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	GroupID    string     `json:"group_id,omitempty"`
+	LastAsked  *time.Time `json:"last_asked,omitempty"`
+}
+
+// BuildError is a constraint the bank can't meet: Need questions for it,
+// at most Have to be found.
+type BuildError struct {
+	Constraint string
+	Need       int
+	Have       int
+}
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("%s needs %d question(s), only %d can be found (%d short)", e.Constraint, e.Need, e.Have, e.Need-e.Have)
+}
+
+// cleanNames trims names, such as tags, and drops empty and repeated
+// ones, which compare without case.
+func cleanNames(names []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, t := range names {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[strings.ToLower(t)] {
+			continue
+		}
+		seen[strings.ToLower(t)] = true
+		out = append(out, t)
+	}
+	return out
+}
+
+// buildUnit is what the builder picks: one bank entry, or all entries of a
+// group, which go into the queue together.
+type buildUnit struct {
+	entries    []BankEntry
+	round      int
+	tags       map[string]int
+	difficulty float64
+	lastAsked  time.Time
+}
+
+func (u *buildUnit) size() int {
+	return len(u.entries)
+}
+
+// buildBucket is the questions wanted from one round, or from any round
+// when no round counts are given.
+type buildBucket struct {
+	name  string
+	need  int
+	units []*buildUnit
+}
+
+type queueBuilder struct {
+	buckets []*buildBucket
+	quota   map[string]int
+	have    map[string]int
+	picked  [][]*buildUnit
+	steps   int
+
+	// best is the fewest tag questions missing at a full pick, and
+	// bestHave the tag counts there; deepest and deepestFill how far the
+	// search got filling the buckets. pruned is the closest a branch cut
+	// for a tag quota came to meeting it.
+	best        int
+	bestHave    map[string]int
+	deepest     int
+	deepestFill int
+	pruned      *BuildError
+}
+
+// lastAskedEntry is when e was last asked in an archived session, in any
+// wording.
+func lastAskedEntry(e BankEntry) time.Time {
+	var last time.Time
+	for _, s := range e.Stats {
+		if s.LastAsked.After(last) {
+			last = s.LastAsked
+		}
+	}
+	return last
+}
+
+// recentSessionsSince is when the oldest of the last n archived sessions
+// started, zero when n is 0 or nothing was archived.
+func recentSessionsSince(n int) time.Time {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	var since time.Time
+	for i := len(sessionIndex) - 1; i >= 0 && n > 0; i-- {
+		if sessionIndex[i].EndedAt == nil {
+			continue
+		}
+		since = sessionIndex[i].StartedAt
+		n--
+	}
+	return since
+}
+
+// buildUnits turns the bank into units, leaving out entries asked in the
+// running game or after since. It returns how many entries it left out.
+func buildUnits(since time.Time) ([]*buildUnit, int) {
+	asked := map[int]bool{}
+	for _, e := range listQueue() {
+		if e.Asked && e.BankID != 0 {
+			asked[e.BankID] = true
+		}
+	}
+	entries := listBank()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+
+	// A group goes out whole when one of its entries was asked.
+	skip := map[int]bool{}
+	skipGroup := map[string]bool{}
+	for _, e := range entries {
+		if asked[e.ID] || (!since.IsZero() && !lastAskedEntry(e).Before(since)) {
+			skip[e.ID] = true
+			if e.GroupID != "" {
+				skipGroup[e.GroupID] = true
+			}
+		}
+	}
+
+	var units []*buildUnit
+	groups := map[string]*buildUnit{}
+	excluded := 0
+	for _, e := range entries {
+		if skip[e.ID] || (e.GroupID != "" && skipGroup[e.GroupID]) {
+			excluded++
+			continue
+		}
+		u := groups[e.GroupID]
+		if u == nil {
+			u = &buildUnit{round: e.Round, tags: map[string]int{}}
+			units = append(units, u)
+			if e.GroupID != "" {
+				groups[e.GroupID] = u
+			}
+		}
+		u.entries = append(u.entries, e)
+		for _, t := range e.Tags {
+			u.tags[strings.ToLower(t)]++
+		}
+		if last := lastAskedEntry(e); last.After(u.lastAsked) {
+			u.lastAsked = last
+		}
+	}
+	for _, u := range units {
+		sum := 0
+		for _, e := range u.entries {
+			if e.Difficulty > 0 {
+				sum += e.Difficulty
+			} else {
+				sum += unratedDifficulty
+			}
+		}
+		u.difficulty = float64(sum) / float64(u.size())
+	}
+	return units, excluded
+}
+
+// buildQueue proposes a queue that meets req, or tells which constraint
+// the bank can't meet.
+func buildQueue(req BuildRequest) (BuildProposal, error) {
+	if req.Total < 0 || req.ExcludeSessions < 0 {
+		return BuildProposal{}, fmt.Errorf("total and exclude_sessions must not be negative")
+	}
+	if req.Curve != "" && req.Curve != "rising" && req.Curve != "flat" {
+		return BuildProposal{}, fmt.Errorf("unknown curve %q (rising or flat)", req.Curve)
+	}
+	sum := 0
+	for round, n := range req.Rounds {
+		if n < 0 || round < 0 {
+			return BuildProposal{}, fmt.Errorf("round counts must not be negative")
+		}
+		sum += n
+	}
+	switch {
+	case len(req.Rounds) > 0 && req.Total == 0:
+		req.Total = sum
+	case len(req.Rounds) > 0 && sum != req.Total:
+		return BuildProposal{}, fmt.Errorf("the round counts add up to %d, not the total of %d", sum, req.Total)
+	case req.Total == 0:
+		return BuildProposal{}, fmt.Errorf("total or rounds is required")
+	}
+	quota := map[string]int{}
+	for t, n := range req.Tags {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" && n > 0 {
+			quota[t] += n
+		}
+	}
+
+	units, excluded := buildUnits(recentSessionsSince(req.ExcludeSessions))
+	b := &queueBuilder{quota: quota, have: map[string]int{}, best: -1}
+	if len(req.Rounds) == 0 {
+		b.buckets = []*buildBucket{{name: "total", need: req.Total, units: units}}
+	} else {
+		rounds := make([]int, 0, len(req.Rounds))
+		for round := range req.Rounds {
+			rounds = append(rounds, round)
+		}
+		sort.Ints(rounds)
+		for _, round := range rounds {
+			bucket := &buildBucket{name: fmt.Sprintf("round %d", round), need: req.Rounds[round]}
+			for _, u := range units {
+				if u.round == round {
+					bucket.units = append(bucket.units, u)
+				}
+			}
+			b.buckets = append(b.buckets, bucket)
+		}
+	}
+	if err := b.precheck(); err != nil {
+		return BuildProposal{}, err
+	}
+	for _, bucket := range b.buckets {
+		b.order(bucket)
+	}
+	b.picked = make([][]*buildUnit, len(b.buckets))
+	if !b.search(0, 0, b.buckets[0].need) {
+		return BuildProposal{}, b.failure()
+	}
+
+	p := BuildProposal{Entries: []BuildPick{}, Tags: map[string]int{}, Excluded: excluded}
+	var ids []string
+	for _, picked := range b.picked {
+		if req.Curve == "rising" {
+			sort.SliceStable(picked, func(i, j int) bool { return picked[i].difficulty < picked[j].difficulty })
+		}
+		for _, u := range picked {
+			for _, e := range u.entries {
+				pick := BuildPick{BankID: e.ID, Question: e.Question, Type: e.Type, Round: e.Round, Difficulty: e.Difficulty, Tags: e.Tags, GroupID: e.GroupID}
+				if last := lastAskedEntry(e); !last.IsZero() {
+					pick.LastAsked = &last
+				}
+				p.Entries = append(p.Entries, pick)
+				ids = append(ids, strconv.Itoa(e.ID))
+			}
+		}
+	}
+	for t, n := range b.have {
+		if quota[t] > 0 {
+			p.Tags[t] = n
+		}
+	}
+	p.Proposal = textHash(strings.Join(ids, ","))
+	return p, nil
+}
+
+// precheck finds the constraints the bank can't meet even with every
+// question it has, so they fail with an exact shortfall.
+func (b *queueBuilder) precheck() error {
+	tagged := map[string]int{}
+	for _, bucket := range b.buckets {
+		have := 0
+		for _, u := range bucket.units {
+			have += u.size()
+			for t, n := range u.tags {
+				tagged[t] += n
+			}
+		}
+		if have < bucket.need {
+			return &BuildError{Constraint: bucket.name, Need: bucket.need, Have: have}
+		}
+	}
+	for _, t := range sortedTags(b.quota) {
+		if tagged[t] < b.quota[t] {
+			return &BuildError{Constraint: "tag " + t, Need: b.quota[t], Have: tagged[t]}
+		}
+	}
+	return nil
+}
+
+// order puts the units the search tries first in front: those with the
+// most quota tags, then the longest unasked, then bank order.
+func (b *queueBuilder) order(bucket *buildBucket) {
+	useful := func(u *buildUnit) int {
+		n := 0
+		for t, c := range u.tags {
+			if b.quota[t] > 0 {
+				n += c
+			}
+		}
+		return n
+	}
+	sort.SliceStable(bucket.units, func(i, j int) bool {
+		ui, uj := bucket.units[i], bucket.units[j]
+		if a, b := useful(ui), useful(uj); a != b {
+			return a > b
+		}
+		return ui.lastAsked.Before(uj.lastAsked)
+	})
+}
+
+// search fills bucket bi from its units at index from on, left questions
+// still to go, then the buckets after it. It reports whether it met every
+// constraint, the picks being in b.picked.
+func (b *queueBuilder) search(bi, from, left int) bool {
+	b.steps++
+	if b.steps > maxBuildSteps {
+		return false
+	}
+	bucket := b.buckets[bi]
+	if fill := bucket.need - left; bi > b.deepest || (bi == b.deepest && fill > b.deepestFill) {
+		b.deepest, b.deepestFill = bi, fill
+	}
+	if left == 0 {
+		if bi+1 < len(b.buckets) {
+			return b.search(bi+1, 0, b.buckets[bi+1].need)
+		}
+		missing := b.missing()
+		if b.best < 0 || missing < b.best {
+			b.best, b.bestHave = missing, copyCounts(b.have)
+		}
+		return missing == 0
+	}
+	if !b.reachable(bi, from, left) {
+		return false
+	}
+	for i := from; i < len(bucket.units); i++ {
+		u := bucket.units[i]
+		if u.size() > left {
+			continue
+		}
+		b.take(bi, u, 1)
+		if b.search(bi, i+1, left-u.size()) {
+			return true
+		}
+		b.take(bi, u, -1)
+		if b.steps > maxBuildSteps {
+			return false
+		}
+	}
+	return false
+}
+
+// reachable prunes a branch that can no longer fill the bucket or meet
+// the tag quotas.
+func (b *queueBuilder) reachable(bi, from, left int) bool {
+	bucket := b.buckets[bi]
+	supply, slots := 0, left
+	tagged := map[string]int{}
+	for _, u := range bucket.units[from:] {
+		supply += u.size()
+		for t, n := range u.tags {
+			tagged[t] += n
+		}
+	}
+	if supply < left {
+		return false
+	}
+	for _, later := range b.buckets[bi+1:] {
+		slots += later.need
+		for _, u := range later.units {
+			for t, n := range u.tags {
+				tagged[t] += n
+			}
+		}
+	}
+	for _, t := range sortedTags(b.quota) {
+		n := b.quota[t]
+		if short := n - b.have[t]; short > 0 && (short > tagged[t] || short > slots) {
+			if have := b.have[t] + min(tagged[t], slots); b.pruned == nil || have > b.pruned.Have {
+				b.pruned = &BuildError{Constraint: "tag " + t, Need: n, Have: have}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+func (b *queueBuilder) take(bi int, u *buildUnit, sign int) {
+	if sign > 0 {
+		b.picked[bi] = append(b.picked[bi], u)
+	} else {
+		b.picked[bi] = b.picked[bi][:len(b.picked[bi])-1]
+	}
+	for t, n := range u.tags {
+		b.have[t] += sign * n
+	}
+}
+
+// missing is how many tagged questions the picks lack for the quotas.
+func (b *queueBuilder) missing() int {
+	n := 0
+	for t, want := range b.quota {
+		n += max(want-b.have[t], 0)
+	}
+	return n
+}
+
+// failure explains a search that found nothing: the tag furthest from its
+// quota at the best full pick, the tag that cut off every full pick, or
+// the bucket the groups didn't let it fill.
+func (b *queueBuilder) failure() error {
+	if b.best < 0 && b.pruned != nil {
+		return b.pruned
+	}
+	if b.best < 0 {
+		bucket := b.buckets[b.deepest]
+		return &BuildError{Constraint: bucket.name, Need: bucket.need, Have: b.deepestFill}
+	}
+	var worst *BuildError
+	for _, t := range sortedTags(b.quota) {
+		if short := b.quota[t] - b.bestHave[t]; short > 0 && (worst == nil || short > worst.Need-worst.Have) {
+			worst = &BuildError{Constraint: "tag " + t, Need: b.quota[t], Have: b.bestHave[t]}
+		}
+	}
+	return worst
+}
+
+func sortedTags(counts map[string]int) []string {
+	tags := make([]string, 0, len(counts))
+	for t := range counts {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// installBuild replaces the questions not yet asked with the proposal p.
+func installBuild(p BuildProposal, origin string) ([]QueueEntry, error) {
+	var entries []QueueEntry
+	for _, pick := range p.Entries {
+		e, ok := findBankEntry(pick.BankID)
+		if !ok {
+			return nil, errBankEntryNotFound
+		}
+		entries = append(entries, e.queueEntry())
+	}
+
+	queueMutex.Lock()
+	var kept []QueueEntry
+	for _, e := range queue {
+		if e.Asked {
+			kept = append(kept, e)
+		}
+	}
+	replaced := len(queue) - len(kept)
+	if err := checkQueueGroups(append(append([]QueueEntry{}, kept...), entries...)); err != nil {
+		queueMutex.Unlock()
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].ID = nextQueueID
+		nextQueueID++
+	}
+	queue = append(kept, entries...)
+	queueRevision++
+	queueMutex.Unlock()
+	audit("queue_build", origin, map[string]interface{}{"count": len(entries), "replaced": replaced, "proposal": p.Proposal})
+	announceQueue("build")
+	checkPreload()
+	checkRundown()
+	return entries, nil
+}
+
+// runBuild builds the queue for req and installs it when confirmed.
+func runBuild(req BuildRequest, origin string) (BuildProposal, error) {
+	p, err := buildQueue(req)
+	if err != nil || !req.Confirm {
+		return p, err
+	}
+	if req.Proposal != "" && req.Proposal != p.Proposal {
+		return p, errStaleProposal
+	}
+	if _, err := installBuild(p, origin); err != nil {
+		return p, err
+	}
+	p.Installed = true
+	return p, nil
+}
+
+// errStaleProposal is a confirmation of a proposal the bank no longer
+// gives.
+var errStaleProposal = errors.New("the bank changed since the proposal, review the new one")
+
+func postQueueBuild(c echo.Context) error {
+	req := new(BuildRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if c.QueryParam("confirm") == "true" {
+		req.Confirm = true
+	}
+	p, err := runBuild(*req, requestOrigin(c))
+	var buildErr *BuildError
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, p)
+	case errors.As(err, &buildErr):
+		return apiError(http.StatusUnprocessableEntity, "infeasible", err.Error()).
+			withDetail("constraint", buildErr.Constraint).
+			withDetail("need", buildErr.Need).
+			withDetail("have", buildErr.Have).
+			withDetail("short", buildErr.Need-buildErr.Have)
+	case err == errStaleProposal:
+		return conflict(err.Error()).withDetail("proposal", p)
+	case err == errBankEntryNotFound:
+		return conflict(err.Error())
+	default:
+		return badRequest(err.Error())
+	}
+}
+
+// handleBuildCommand runs "build", asking for the constraints one by one.
+func handleBuildCommand(origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	var req BuildRequest
+	ask := func(question string) (string, bool) {
+		line, ok := promptLine(question)
+		return strings.TrimSpace(line), ok
+	}
+	line, ok := ask("Questions per round, as round=count (empty for any round):")
+	if !ok {
+		return nil
+	}
+	rounds, err := parseCounts(line)
+	if err != nil {
+		return err
+	}
+	for k, n := range rounds {
+		round, err := strconv.Atoi(k)
+		if err != nil {
+			return fmt.Errorf("Invalid round %q", k)
+		}
+		if req.Rounds == nil {
+			req.Rounds = map[int]int{}
+		}
+		req.Rounds[round] = n
+	}
+	if len(req.Rounds) == 0 {
+		if line, ok = ask("Total questions [40]:"); !ok {
+			return nil
+		}
+		req.Total = 40
+		if line != "" {
+			if req.Total, err = strconv.Atoi(line); err != nil {
+				return errors.New("Invalid number of questions")
+			}
+		}
+	}
+	if line, ok = ask("Tag quotas, as tag=at-least (empty for none):"); !ok {
+		return nil
+	}
+	if req.Tags, err = parseCounts(line); err != nil {
+		return err
+	}
+	if line, ok = ask("Difficulty curve, rising or flat [rising]:"); !ok {
+		return nil
+	}
+	req.Curve = "rising"
+	if line != "" {
+		req.Curve = line
+	}
+	if line, ok = ask("Leave out questions asked in the last how many sessions [0]:"); !ok {
+		return nil
+	}
+	if line != "" {
+		if req.ExcludeSessions, err = strconv.Atoi(line); err != nil {
+			return errors.New("Invalid number of sessions")
+		}
+	}
+
+	p, err := buildQueue(req)
+	if err != nil {
+		return err
+	}
+	info.Printf("  %-3s %-5s %-5s %-4s %-20s %s\n", "#", "bank", "round", "diff", "tags", "question")
+	for i, e := range p.Entries {
+		diff := "-"
+		if e.Difficulty > 0 {
+			diff = strconv.Itoa(e.Difficulty)
+		}
+		question := e.Question
+		if e.GroupID != "" {
+			question = "[" + e.GroupID + "] " + question
+		}
+		fmt.Printf("  %-3d #%-4d %-5d %-4s %-20s %s\n", i+1, e.BankID, e.Round, diff, strings.Join(e.Tags, ","), question)
+	}
+	if p.Excluded > 0 {
+		info.Printf("%d recently asked question(s) left out\n", p.Excluded)
+	}
+	pending := 0
+	for _, e := range listQueue() {
+		if !e.Asked {
+			pending++
+		}
+	}
+	if !promptYesNo(fmt.Sprintf("Install these %d questions in place of the %d not yet asked?", len(p.Entries), pending)) {
+		info.Println("Queue left as it was")
+		return nil
+	}
+	added, err := installBuild(p, origin)
+	if err != nil {
+		return err
+	}
+	success.Printf("Queue built with %d questions\n", len(added))
+	return nil
+}
+
+// parseCounts reads "name=n name=n", also separated by commas.
+func parseCounts(line string) (map[string]int, error) {
+	counts := map[string]int{}
+	for _, f := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' }) {
+		name, n, ok := strings.Cut(f, "=")
+		count, err := strconv.Atoi(n)
+		if !ok || name == "" || err != nil || count < 0 {
+			return nil, fmt.Errorf("expected name=count, got %q", f)
+		}
+		counts[name] = count
+	}
+	return counts, nil
+}
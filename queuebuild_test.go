@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// buildBank fills the bank for the builder: two rounds, some songs, and a
+// two-part group in round 1. It returns the IDs by question.
+func buildBank(t *testing.T, s *TestServer) map[string]int {
+	t.Helper()
+	ids := map[string]int{}
+	for _, e := range []struct {
+		question   string
+		round      int
+		difficulty int
+		tags       []string
+		group      string
+	}{
+		{"A", 1, 3, []string{"hudba"}, ""},
+		{"B", 1, 1, nil, ""},
+		{"C", 1, 2, []string{"Hudba", " hudba "}, ""},
+		{"G1", 1, 4, nil, "g"},
+		{"G2", 1, 4, nil, "g"},
+		{"D", 2, 5, nil, ""},
+		{"E", 2, 1, []string{"hudba"}, ""},
+		{"F", 2, 2, nil, ""},
+	} {
+		var created BankEntry
+		if status := s.Do(t, http.MethodPost, "/bank", map[string]interface{}{
+			"question": e.question, "type": "pomoc", "time_left": 30_000_000_000,
+			"round": e.round, "difficulty": e.difficulty, "tags": e.tags, "group_id": e.group,
+		}, &created); status != http.StatusCreated {
+			t.Fatalf("adding %s: status %d", e.question, status)
+		}
+		ids[e.question] = created.ID
+	}
+	return ids
+}
+
+func picked(p BuildProposal) string {
+	var names []string
+	for _, e := range p.Entries {
+		names = append(names, e.Question)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestQueueBuild(t *testing.T) {
+	s := StartTestServer(t)
+	ids := buildBank(t, s)
+	build := func(req BuildRequest) (BuildProposal, int, APIError) {
+		t.Helper()
+		var res struct {
+			BuildProposal
+			Error APIError `json:"error"`
+		}
+		status := s.Do(t, http.MethodPost, "/v2/queue/build", req, &res)
+		return res.BuildProposal, status, res.Error
+	}
+
+	// The songs are picked first, and each round rises in difficulty.
+	p, status, e := build(BuildRequest{Rounds: map[int]int{1: 3, 2: 2}, Tags: map[string]int{"HUDBA": 3}, Curve: "rising"})
+	if status != http.StatusOK {
+		t.Fatalf("build: status %d, %+v", status, e)
+	}
+	if got := picked(p); got != "B,C,A,E,D" || p.Tags["hudba"] != 3 || p.Installed {
+		t.Errorf("proposed %s with tags %v", got, p.Tags)
+	}
+	if e, ok := findBankEntry(ids["C"]); !ok || len(e.Tags) != 1 {
+		t.Errorf("tags of C %v", e.Tags)
+	}
+
+	// A group is picked whole and stays together on the curve.
+	p, _, _ = build(BuildRequest{Rounds: map[int]int{1: 5}, Curve: "rising"})
+	if got := picked(p); got != "B,C,A,G1,G2" {
+		t.Errorf("proposed %s", got)
+	}
+	if _, status, e = build(BuildRequest{Rounds: map[int]int{1: 4}, Tags: map[string]int{"hudba": 1}}); status != http.StatusOK {
+		t.Errorf("four of round 1: status %d, %+v", status, e)
+	}
+
+	for _, tc := range []struct {
+		req        BuildRequest
+		constraint string
+		short      float64
+	}{
+		{BuildRequest{Total: 5, Tags: map[string]int{"hudba": 4}}, "tag hudba", 1},
+		{BuildRequest{Rounds: map[int]int{2: 4}}, "round 2", 1},
+		{BuildRequest{Rounds: map[int]int{1: 1, 2: 1}, Tags: map[string]int{"hudba": 3}}, "tag hudba", 1},
+		// Round 1 has two songs but room for one.
+		{BuildRequest{Rounds: map[int]int{1: 1, 2: 2}, Tags: map[string]int{"hudba": 3}}, "tag hudba", 1},
+	} {
+		_, status, e := build(tc.req)
+		if status != http.StatusUnprocessableEntity || e.Code != "infeasible" || e.Details["constraint"] != tc.constraint || e.Details["short"] != tc.short {
+			t.Errorf("%+v: status %d, %+v", tc.req, status, e)
+		}
+	}
+	for _, req := range []BuildRequest{{}, {Total: 3, Rounds: map[int]int{1: 2}}, {Total: 2, Curve: "falling"}} {
+		if _, status, _ := build(req); status != http.StatusBadRequest {
+			t.Errorf("%+v: status %d", req, status)
+		}
+	}
+
+	// Confirming installs the reviewed proposal in place of what wasn't
+	// asked yet.
+	s.Do(t, http.MethodPost, "/queue", map[string]interface{}{"question": "Navyše", "type": "pomoc", "time_left": 30_000_000_000}, nil)
+	proposal, _, _ := build(BuildRequest{Total: 2, Curve: "rising"})
+	stale := BuildRequest{Total: 2, Curve: "rising", Confirm: true, Proposal: "0000"}
+	if _, status, e := build(stale); status != http.StatusConflict {
+		t.Errorf("a stale proposal: status %d, %+v", status, e)
+	}
+	p, status, e = build(BuildRequest{Total: 2, Curve: "rising", Confirm: true, Proposal: proposal.Proposal})
+	if status != http.StatusOK || !p.Installed || picked(p) != picked(proposal) {
+		t.Fatalf("confirm: status %d, %+v, %+v", status, p, e)
+	}
+	var queued []string
+	for _, q := range listQueue() {
+		queued = append(queued, q.Question)
+	}
+	if got := strings.Join(queued, ","); got != picked(p) {
+		t.Errorf("queue %s", got)
+	}
+	if a := lastAudit(t, "queue_build"); a.Details["count"] != 2 || a.Details["replaced"] != 1 {
+		t.Errorf("audited as %+v", a)
+	}
+
+	// What this game asked is left out of the next build, and the asked
+	// entry stays in the queue.
+	s.MustDo(t, http.MethodPost, "/queue/next?force=true", nil)
+	first := listQueue()[0]
+	p, _, _ = build(BuildRequest{Total: 7, Confirm: true})
+	if p.Excluded != 1 || strings.Contains(","+picked(p)+",", ","+first.Question+",") {
+		t.Errorf("rebuilt %s with %d excluded after asking %s", picked(p), p.Excluded, first.Question)
+	}
+	if q := listQueue(); len(q) != 8 || q[0].ID != first.ID || !q[0].Asked {
+		t.Errorf("queue after the rebuild %+v", q)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// headerQueueRevision carries the queue revision on GET /queue.
+const headerQueueRevision = "X-Queue-Revision"
+
+// QueueSnapshot is the queue at one revision. It is the body of the
+// queue_changed event, the answer to a reorder and the detail of the 409
+// a reorder based on an older revision gets, so a client can rebase.
+type QueueSnapshot struct {
+	Revision uint64       `json:"revision"`
+	Entries  []QueueEntry `json:"entries"`
+	Reason   string       `json:"reason,omitempty"`
+}
+
+func queueSnapshot() QueueSnapshot {
+	queueMutex.RLock()
+	defer queueMutex.RUnlock()
+	return QueueSnapshot{Revision: queueRevision, Entries: append([]QueueEntry{}, queue...)}
+}
+
+// announceQueue sends the queue to operator streams after a change. The
+// queue holds questions not asked yet, so the audience never gets it.
+func announceQueue(reason string) {
+	snap := queueSnapshot()
+	snap.Reason = reason
+	hub.broadcastOperator(Event{Type: "queue_changed", Data: snap})
+	announceChecklist()
+}
+
+// StaleQueueError is a reorder based on a revision that is no longer
+// current.
+type StaleQueueError struct {
+	Current QueueSnapshot
+}
+
+func (e *StaleQueueError) Error() string {
+	return fmt.Sprintf("the queue changed since revision was read, it is now at revision %d", e.Current.Revision)
+}
+
+var errQueueEntryNotFound = fmt.Errorf("queue entry not found")
+
+// checkQueueRevision fails when revision is set and not the current one.
+// Call with queueMutex held.
+func checkQueueRevision(revision *uint64) error {
+	if revision == nil || *revision == queueRevision {
+		return nil
+	}
+	return &StaleQueueError{Current: QueueSnapshot{Revision: queueRevision, Entries: append([]QueueEntry{}, queue...)}}
+}
+
+// moveQueueEntry moves entry id to position, counted from 1 over the whole
+// queue, asked entries included. An entry of a group moves with its group,
+// the block starting at position, which may not fall inside another group.
+// A nil revision skips the check, for the CLI.
+func moveQueueEntry(id, position int, revision *uint64, origin string) (QueueSnapshot, error) {
+	queueMutex.Lock()
+	if err := checkQueueRevision(revision); err != nil {
+		queueMutex.Unlock()
+		return QueueSnapshot{}, err
+	}
+	from := -1
+	for i, e := range queue {
+		if e.ID == id {
+			from = i
+		}
+	}
+	if from < 0 {
+		queueMutex.Unlock()
+		return QueueSnapshot{}, errQueueEntryNotFound
+	}
+	start, end := groupSpan(queue, from)
+	block := append([]QueueEntry{}, queue[start:end]...)
+	rest := append(append([]QueueEntry{}, queue[:start]...), queue[end:]...)
+	if position < 1 || position > len(rest)+1 {
+		queueMutex.Unlock()
+		return QueueSnapshot{}, fmt.Errorf("position must be between 1 and %d", len(rest)+1)
+	}
+	to := position - 1
+	if to > 0 && to < len(rest) && rest[to].GroupID != "" && rest[to-1].GroupID == rest[to].GroupID {
+		queueMutex.Unlock()
+		return QueueSnapshot{}, fmt.Errorf("position %d is inside group %q", position, rest[to].GroupID)
+	}
+	queue = append(append(append([]QueueEntry{}, rest[:to]...), block...), rest[to:]...)
+	queueRevision++
+	queueMutex.Unlock()
+
+	audit("queue_move", origin, map[string]interface{}{"id": id, "position": position})
+	return queueReordered("move"), nil
+}
+
+// reorderQueue puts the queue in the order given, which must name every
+// entry exactly once and keep every group together and in order.
+func reorderQueue(order []int, revision *uint64, origin string) (QueueSnapshot, error) {
+	queueMutex.Lock()
+	if err := checkQueueRevision(revision); err != nil {
+		queueMutex.Unlock()
+		return QueueSnapshot{}, err
+	}
+	byID := make(map[int]QueueEntry, len(queue))
+	for _, e := range queue {
+		byID[e.ID] = e
+	}
+	if len(order) != len(queue) {
+		queueMutex.Unlock()
+		return QueueSnapshot{}, fmt.Errorf("order must list all %d queue entries, got %d", len(queue), len(order))
+	}
+	reordered := make([]QueueEntry, 0, len(order))
+	for _, id := range order {
+		e, ok := byID[id]
+		if !ok {
+			queueMutex.Unlock()
+			return QueueSnapshot{}, fmt.Errorf("order names entry %d twice or it is not in the queue", id)
+		}
+		delete(byID, id)
+		reordered = append(reordered, e)
+	}
+	if err := checkQueueGroups(reordered); err != nil {
+		queueMutex.Unlock()
+		return QueueSnapshot{}, err
+	}
+	if err := checkGroupOrder(queue, reordered); err != nil {
+		queueMutex.Unlock()
+		return QueueSnapshot{}, err
+	}
+	queue = reordered
+	queueRevision++
+	queueMutex.Unlock()
+
+	audit("queue_order", origin, map[string]interface{}{"order": order})
+	return queueReordered("order"), nil
+}
+
+// queueReordered announces a new order and refreshes what depends on it.
+func queueReordered(reason string) QueueSnapshot {
+	announceQueue(reason)
+	checkPreload()
+	checkRundown()
+	return queueSnapshot()
+}
+
+// QueueMoveRequest is the body of POST /queue/move.
+type QueueMoveRequest struct {
+	ID       int     `json:"id"`
+	Position int     `json:"position"`
+	Revision *uint64 `json:"revision"`
+}
+
+// QueueOrderRequest is the body of PUT /queue/order: every entry ID, in
+// the new order.
+type QueueOrderRequest struct {
+	Order    []int   `json:"order"`
+	Revision *uint64 `json:"revision"`
+}
+
+var errRevisionRequired = badRequest("revision is required, GET /queue returns it in " + headerQueueRevision)
+
+func queueOrderError(err error) error {
+	if stale, ok := err.(*StaleQueueError); ok {
+		return apiError(http.StatusConflict, "queue_changed", stale.Error()).
+			withDetail("revision", stale.Current.Revision).
+			withDetail("queue", stale.Current.Entries)
+	}
+	if err == errQueueEntryNotFound {
+		return notFound(err.Error())
+	}
+	return badRequest(err.Error())
+}
+
+func postQueueMove(c echo.Context) error {
+	req := new(QueueMoveRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if req.Revision == nil {
+		return errRevisionRequired
+	}
+	snap, err := moveQueueEntry(req.ID, req.Position, req.Revision, requestOrigin(c))
+	if err != nil {
+		return queueOrderError(err)
+	}
+	return c.JSON(http.StatusOK, snap)
+}
+
+func putQueueOrder(c echo.Context) error {
+	req := new(QueueOrderRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if req.Revision == nil {
+		return errRevisionRequired
+	}
+	snap, err := reorderQueue(req.Order, req.Revision, requestOrigin(c))
+	if err != nil {
+		return queueOrderError(err)
+	}
+	return c.JSON(http.StatusOK, snap)
+}
+
+// handleQueueMoveCommand runs "queue move <id> <position>".
+func handleQueueMoveCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	if len(args) != 2 {
+		return errors.New("Usage: queue move <id> <position>")
+	}
+	id, err1 := strconv.Atoi(args[0])
+	position, err2 := strconv.Atoi(args[1])
+	if err1 != nil || err2 != nil {
+		return errors.New("Usage: queue move <id> <position>")
+	}
+	if _, err := moveQueueEntry(id, position, nil, origin); err != nil {
+		return err
+	}
+	success.Printf("Moved %d to position %d\n", id, position)
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// readingPhase reports whether the live question is still being read out.
+func readingPhase() bool {
+	questionMutex.RLock()
+	defer questionMutex.RUnlock()
+	return question.Reading
+}
+
+// startAnswering ends the reading phase and starts the answer countdown.
+// The timer calls it when reading runs out; the operator may call it early.
+// A paused question stays paused with its full answer time.
+func startAnswering(origin string) error {
+	questionMutex.Lock()
+	if !question.Reading {
+		questionMutex.Unlock()
+		return fmt.Errorf("the question is not in the reading phase")
+	}
+	if origin == "timer" {
+		// The operator may have paused or cut reading short meanwhile.
+		if deadline, ok := readingDeadline(question); !ok || clock.Now().Before(deadline) {
+			questionMutex.Unlock()
+			return nil
+		}
+	}
+	question.Reading = false
+	question.ReadingTime = 0
+	setTimer(&question, question.TimeLeft)
+	startAnswerClock(question.StartTime)
+	stateChanged("answering")
+	questionMutex.Unlock()
+
+	audit("start_answering", origin, nil)
+	hub.broadcast(Event{Type: "phase", Data: map[string]string{"phase": "answering", "origin": origin}})
+	go sendCurrentQuestion()
+	return nil
+}
+
+func postStartAnswering(c echo.Context) error {
+	if err := startAnswering(requestOrigin(c)); err != nil {
+		return conflict(err.Error())
+	}
+	questionMutex.RLock()
+	defer questionMutex.RUnlock()
+	return c.JSON(http.StatusOK, questionView(c, question))
+}
+
+func handleGoCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	if len(args) != 1 || args[0] != "answers" {
+		return errors.New("Usage: go answers")
+	}
+	if err := startAnswering(origin); err != nil {
+		return err
+	}
+	success.Println("Answering started")
+	return nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Session recordings are JSON lines files. The first line is a header naming
+// the format and its version, every following line is one state change.
+// Readers ignore fields they don't know about, so a recording written by a
+// newer build with the same version stays replayable by an older one.
+const (
+	recordingFormat  = "stuskova-session"
+	recordingVersion = 1
+)
+
+type recordingHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Started time.Time `json:"started"`
+}
+
+// RecordedEvent is a single state change in a session recording.
+type RecordedEvent struct {
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	Question Question  `json:"question"`
+	Paused   bool      `json:"paused"`
+}
+
+type sessionRecorder struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	enc  *json.Encoder
+	seq  uint64
+}
+
+// recorder is nil unless the server was started with -record.
+var recorder *sessionRecorder
+
+func startRecording(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r := &sessionRecorder{path: path, f: f, enc: json.NewEncoder(f)}
+	if info.Size() == 0 {
+		header := recordingHeader{Format: recordingFormat, Version: recordingVersion, Started: clock.Now()}
+		if err := r.enc.Encode(header); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	recorder = r
+	return nil
+}
+
+// recordEvent appends the current state to the session recording. It must be
+// called with questionMutex held.
+func recordEvent(kind string) {
+	if recorder == nil {
+		return
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.seq++
+	ev := RecordedEvent{
+		Seq:      recorder.seq,
+		Time:     clock.Now(),
+		Kind:     kind,
+		Question: question,
+		Paused:   question.Paused,
+	}
+	if err := recorder.enc.Encode(ev); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing session recording: %v\n", err)
+	}
+}
+
+func stopRecording() {
+	if recorder == nil {
+		return
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.f.Close()
+}
+
+func readRecording(path string) ([]RecordedEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("empty recording")
+	}
+	var header recordingHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, fmt.Errorf("invalid recording header: %v", err)
+	}
+	if header.Format != recordingFormat {
+		return nil, fmt.Errorf("not a session recording")
+	}
+	if header.Version > recordingVersion {
+		return nil, fmt.Errorf("recording version %d is newer than supported version %d", header.Version, recordingVersion)
+	}
+
+	var events []RecordedEvent
+	for line := 2; scanner.Scan(); line++ {
+		var ev RecordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		events = append(events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// replayer feeds a recording back into the live state at scaled real time.
+type replayer struct {
+	path   string
+	events []RecordedEvent
+	speed  float64
+	live   bool
+	ctl    chan string
+	done   chan struct{}
+	// real is the question the replay displaced. Backups and the
+	// write-ahead log keep it instead of the replayed one, and it is put
+	// back when the replay ends, so a replay never reaches the real
+	// session's persistence.
+	real Question
+
+	mu       sync.Mutex
+	paused   bool
+	position int
+}
+
+var (
+	activeReplay *replayer
+	replayMutex  sync.Mutex
+)
+
+func startReplay(path string, speed float64, live bool) (*replayer, error) {
+	if speed <= 0 {
+		return nil, fmt.Errorf("speed must be positive")
+	}
+	events, err := readRecording(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("recording has no events")
+	}
+
+	replayMutex.Lock()
+	defer replayMutex.Unlock()
+	if activeReplay != nil {
+		return nil, fmt.Errorf("a replay is already running")
+	}
+	r := &replayer{
+		path:   path,
+		events: events,
+		speed:  speed,
+		live:   live,
+		ctl:    make(chan string),
+		done:   make(chan struct{}),
+	}
+	questionMutex.RLock()
+	r.real = question
+	questionMutex.RUnlock()
+	activeReplay = r
+	go r.run()
+	return r, nil
+}
+
+func currentReplay() *replayer {
+	replayMutex.Lock()
+	defer replayMutex.Unlock()
+	return activeReplay
+}
+
+func (r *replayer) run() {
+	defer func() {
+		// The real question is back before the replay is over, so nothing
+		// persists the last replayed state in between.
+		questionMutex.Lock()
+		question = r.real
+		stateChanged("replay_end")
+		questionMutex.Unlock()
+		replayMutex.Lock()
+		activeReplay = nil
+		replayMutex.Unlock()
+		close(r.done)
+		wakeExpiryWatcher()
+		go sendCurrentQuestion()
+	}()
+
+	for i, ev := range r.events {
+		if i > 0 {
+			gap := ev.Time.Sub(r.events[i-1].Time)
+			if !r.wait(time.Duration(float64(gap) / r.speed)) {
+				return
+			}
+		}
+		applyReplayedEvent(ev, r.live)
+		r.mu.Lock()
+		r.position = i + 1
+		r.mu.Unlock()
+	}
+}
+
+// wait sleeps for d on the injected clock, honoring pause and stop requests.
+// It reports false if the replay was stopped.
+func (r *replayer) wait(d time.Duration) bool {
+	defer disarmTimer("replay")
+	for d > 0 {
+		start := clock.Now()
+		armTimer("replay", start.Add(d))
+		select {
+		case <-clock.After(d):
+			return true
+		case cmd := <-r.ctl:
+			d -= clock.Since(start)
+			if cmd == "stop" {
+				return false
+			}
+			if cmd == "pause" {
+				r.setPaused(true)
+				for cmd != "resume" {
+					if cmd = <-r.ctl; cmd == "stop" {
+						return false
+					}
+				}
+				r.setPaused(false)
+			}
+		}
+	}
+	return true
+}
+
+func (r *replayer) setPaused(p bool) {
+	r.mu.Lock()
+	r.paused = p
+	r.mu.Unlock()
+}
+
+func (r *replayer) status() (position, total int, paused bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.position, len(r.events), r.paused
+}
+
+// control sends pause/resume/stop to a running replay. It reports false if
+// the replay already finished.
+func (r *replayer) control(cmd string) bool {
+	select {
+	case r.ctl <- cmd:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// replayedQuestion returns the real question while a replay has it set
+// aside, for backups and the write-ahead log.
+func replayedQuestion() (Question, bool) {
+	if r := currentReplay(); r != nil {
+		return r.real, true
+	}
+	return Question{}, false
+}
+
+// applyReplayedEvent installs a recorded state as the live one. It keeps the
+// countdown offset the recorded question had at the time of the event and
+// deliberately bypasses the recorder, the audit log and the write-ahead
+// log. With live, SSE and WebSocket clients and the push targets get it.
+func applyReplayedEvent(ev RecordedEvent, live bool) {
+	questionMutex.Lock()
+	q := ev.Question
+	q.StartTime = clock.Now().Add(-ev.Time.Sub(ev.Question.StartTime))
+	q.Paused = q.Paused || ev.Paused
+	question = q
+	revision++
+	rev := revision
+	noteInternalEvent("replay", rev)
+	var pub PublicQuestionView
+	if live {
+		pub = publicQuestion(question)
+	}
+	questionMutex.Unlock()
+	wakeExpiryWatcher()
+
+	if live {
+		hub.broadcast(Event{Type: "state", Revision: rev, Data: pub})
+		wakeTicks()
+		go sendCurrentQuestion()
+	}
+}
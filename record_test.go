@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeRecording writes a recording of questions, a second apart.
+func writeRecording(t *testing.T, questions ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.Encode(recordingHeader{Format: recordingFormat, Version: recordingVersion, Started: testEpoch})
+	for i, text := range questions {
+		at := testEpoch.Add(time.Duration(i) * time.Second)
+		enc.Encode(RecordedEvent{Seq: uint64(i + 1), Time: at, Kind: "question", Question: Question{Question: text, Type: "pomoc", TimeLeft: 30 * time.Second, StartTime: at}})
+	}
+	return path
+}
+
+// TestReplayIsolation replays with --live and checks that clients get the
+// replayed states while backups keep the real question, which is back once
+// the replay ends.
+func TestReplayIsolation(t *testing.T) {
+	s := StartTestServer(t)
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Skutočná otázka", "type": "pomoc", "time_left": 30_000_000_000})
+	audited := len(auditEntries(""))
+	sub := hub.subscribe("test", false)
+	defer hub.unsubscribe(sub)
+
+	r, err := startReplay(writeRecording(t, "Prvá", "Druhá"), 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the first event replayed", func() bool { pos, _, _ := r.status(); return pos == 1 })
+	dumped := func() string {
+		t.Helper()
+		v, err := backupSections[0].dump()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v.(Question).Question
+	}
+	if got := liveQuestionText(); got != "Prvá" || dumped() != "Skutočná otázka" {
+		t.Errorf("replaying: live %q, backed up %q", got, dumped())
+	}
+	replayed := false
+	for draining := true; draining; {
+		select {
+		case ev := <-sub.ch:
+			pub, ok := ev.Data.(PublicQuestionView)
+			replayed = replayed || ok && ev.Type == "state" && pub.Question == "Prvá"
+		default:
+			draining = false
+		}
+	}
+	if !replayed {
+		t.Error("no state event for the replayed question")
+	}
+	s.Flask.WaitFor(t, "the replayed question pushed", func(p map[string]interface{}) bool { return p["question"] == "Prvá" })
+
+	// The replay waits on the clock for the second event, then ends.
+	eventually(t, "the replay finished", func() bool {
+		AdvanceClock(t, time.Second)
+		return currentReplay() == nil
+	})
+	if got := liveQuestionText(); got != "Skutočná otázka" || dumped() != got {
+		t.Errorf("after the replay: live %q, backed up %q", got, dumped())
+	}
+	if n := len(auditEntries("")); n != audited {
+		t.Errorf("the replay wrote %d audit entries", n-audited)
+	}
+}
+
+func liveQuestionText() string {
+	questionMutex.RLock()
+	defer questionMutex.RUnlock()
+	return question.Question
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed report/report.html
+var reportTemplateText string
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"accuracy": formatAccuracy,
+	"minutes":  func(d time.Duration) string { return d.Round(time.Second).String() },
+}).Parse(reportTemplateText))
+
+var (
+	reportDir      = flag.String("report-dir", "reports", "directory post-show reports are saved in")
+	reportOnEnd    = flag.Bool("report-on-end", true, "save, and mail if -smtp-addr is set, a report when a session ends")
+	reportTo       = flag.String("report-to", "", "comma-separated addresses the post-show report is mailed to")
+	smtpAddr       = flag.String("smtp-addr", "", "SMTP server as host:port for mailing reports (empty only saves them)")
+	smtpUser       = flag.String("smtp-user", "", "SMTP user name, if the server needs authentication")
+	smtpPassword   = flag.String("smtp-password", "", "SMTP password, defaults to $SMTP_PASSWORD")
+	smtpFrom       = flag.String("smtp-from", "", "sender address of report mails (default -smtp-user)")
+	reportAttempts = 3
+	reportBackoff  = 10 * time.Second
+)
+
+// Report is what the organizer gets after a show: the standings, a line
+// per round and per question, and the pauses.
+type Report struct {
+	Title     string
+	From, To  time.Time
+	Standings []ReportStanding
+	Rounds    []ReportRound
+	Questions []ReportQuestion
+	Pauses    []PauseTotal
+	Paused    time.Duration
+}
+
+type ReportStanding struct {
+	Place int
+	Team  string
+	Score int
+}
+
+// ReportRound sums the questions of one queue round. Round 0 collects the
+// questions set outside the queue's rounds.
+type ReportRound struct {
+	Round     int
+	Name      string
+	Questions int
+	Answers   int
+	Judged    int
+	Correct   int
+}
+
+// Label names the round for the report.
+func (r ReportRound) Label() string {
+	switch {
+	case r.Round == 0:
+		return "No round"
+	case r.Name != "":
+		return fmt.Sprintf("%d. %s", r.Round, r.Name)
+	}
+	return strconv.Itoa(r.Round)
+}
+
+type ReportQuestion struct {
+	N        int
+	Round    int
+	Question string
+	Type     string
+	AskedAt  time.Time
+	Answers  int
+	Judged   int
+	Correct  int
+	Paused   time.Duration
+}
+
+// formatAccuracy is the share of judged answers that were right.
+func formatAccuracy(judged, correct int) string {
+	if judged == 0 {
+		return "–"
+	}
+	return fmt.Sprintf("%.0f%%", float64(correct)*100/float64(judged))
+}
+
+// buildReport collects the report of the current game. It is called before
+// endSession clears the game.
+func buildReport(title string) Report {
+	since := gameSince()
+	rep := Report{Title: title, From: since, To: clock.Now(), Pauses: pauseTotalsSince(since)}
+	for _, st := range rankTeams(listTeams()) {
+		rep.Standings = append(rep.Standings, ReportStanding{Place: st.Place, Team: st.Team.Name, Score: st.Team.Score})
+	}
+	rounds := map[int]*ReportRound{}
+	for i, h := range listHistory() {
+		q := ReportQuestion{N: i + 1, Round: h.Round, Question: h.Question, Type: h.Type, AskedAt: h.StartedAt}
+		if h.Record != nil {
+			q.Answers = len(h.Record.Answers)
+			q.Judged, q.Correct = answerOutcomes(h.Record)
+			q.Paused = h.Record.Paused
+		}
+		if rep.From.IsZero() || q.AskedAt.Before(rep.From) {
+			rep.From = q.AskedAt
+		}
+		rep.Questions = append(rep.Questions, q)
+		r, ok := rounds[h.Round]
+		if !ok {
+			r = &ReportRound{Round: h.Round, Name: h.RoundName}
+			rounds[h.Round] = r
+		}
+		r.Questions++
+		r.Answers += q.Answers
+		r.Judged += q.Judged
+		r.Correct += q.Correct
+	}
+	for _, r := range rounds {
+		rep.Rounds = append(rep.Rounds, *r)
+	}
+	sort.Slice(rep.Rounds, func(i, j int) bool { return rep.Rounds[i].Round < rep.Rounds[j].Round })
+	// Without a queue every question is in round 0, which says nothing.
+	if len(rep.Rounds) == 1 && rep.Rounds[0].Round == 0 {
+		rep.Rounds = nil
+	}
+	for _, p := range rep.Pauses {
+		rep.Paused += p.Duration
+	}
+	return rep
+}
+
+// reportFiles renders the report: the HTML page first, then the CSVs.
+func reportFiles(rep Report) ([]string, map[string][]byte, error) {
+	var page bytes.Buffer
+	if err := reportTemplate.Execute(&page, rep); err != nil {
+		return nil, nil, err
+	}
+	files := map[string][]byte{"report.html": page.Bytes()}
+
+	standings := [][]string{{"place", "team", "score"}}
+	for _, s := range rep.Standings {
+		standings = append(standings, []string{strconv.Itoa(s.Place), s.Team, strconv.Itoa(s.Score)})
+	}
+	rounds := [][]string{{"round", "name", "questions", "answers", "judged", "correct"}}
+	for _, r := range rep.Rounds {
+		rounds = append(rounds, []string{strconv.Itoa(r.Round), r.Name, strconv.Itoa(r.Questions), strconv.Itoa(r.Answers), strconv.Itoa(r.Judged), strconv.Itoa(r.Correct)})
+	}
+	questions := [][]string{{"n", "round", "question", "type", "asked_at", "answers", "judged", "correct", "paused_seconds"}}
+	for _, q := range rep.Questions {
+		questions = append(questions, []string{strconv.Itoa(q.N), strconv.Itoa(q.Round), q.Question, q.Type, q.AskedAt.UTC().Format(time.RFC3339),
+			strconv.Itoa(q.Answers), strconv.Itoa(q.Judged), strconv.Itoa(q.Correct), strconv.FormatFloat(q.Paused.Seconds(), 'f', 1, 64)})
+	}
+	pauses := [][]string{{"reason", "count", "seconds"}}
+	for _, p := range rep.Pauses {
+		pauses = append(pauses, []string{p.Reason, strconv.Itoa(p.Count), strconv.FormatFloat(p.Duration.Seconds(), 'f', 1, 64)})
+	}
+	order := []string{"report.html", "standings.csv", "rounds.csv", "questions.csv", "pauses.csv"}
+	for name, rows := range map[string][][]string{"standings.csv": standings, "rounds.csv": rounds, "questions.csv": questions, "pauses.csv": pauses} {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.WriteAll(rows)
+		if err := w.Error(); err != nil {
+			return nil, nil, err
+		}
+		files[name] = buf.Bytes()
+	}
+	return order, files, nil
+}
+
+// saveReport renders rep into dir, which it creates.
+func saveReport(rep Report, dir string) error {
+	_, files, err := reportFiles(rep)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReportStatus is how the last report went.
+type ReportStatus struct {
+	Title      string    `json:"title"`
+	Dir        string    `json:"dir"`
+	SavedAt    time.Time `json:"saved_at"`
+	Recipients []string  `json:"recipients,omitempty"`
+	// State is "saved" when there is no SMTP server to mail it with, then
+	// "sending", "sent" or "failed".
+	State    string `json:"state"`
+	Attempts int    `json:"attempts,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+var (
+	reportMutex sync.Mutex
+	lastReport  *ReportStatus
+)
+
+func setReportStatus(s ReportStatus) {
+	reportMutex.Lock()
+	lastReport = &s
+	reportMutex.Unlock()
+}
+
+func reportRecipients() []string {
+	var out []string
+	for _, r := range strings.Split(*reportTo, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// publishReport saves rep under -report-dir as name and mails it when SMTP
+// is configured. The mail is retried a few times; the saved copy is there
+// either way, and the notification says where.
+func publishReport(rep Report, name string) (ReportStatus, error) {
+	dir := filepath.Join(*reportDir, name)
+	status := ReportStatus{Title: rep.Title, Dir: dir, SavedAt: clock.Now(), State: "saved"}
+	if err := saveReport(rep, dir); err != nil {
+		notify(SeverityError, "report", "Report %q could not be saved in %s: %v", rep.Title, dir, err)
+		return ReportStatus{}, err
+	}
+	to := reportRecipients()
+	if *smtpAddr == "" || len(to) == 0 {
+		setReportStatus(status)
+		notify(SeverityInfo, "report", "Report %q saved in %s", rep.Title, dir)
+		return status, nil
+	}
+	status.State, status.Recipients = "sending", to
+	setReportStatus(status)
+	go mailReport(rep, status)
+	return status, nil
+}
+
+func mailReport(rep Report, status ReportStatus) {
+	msg, err := reportMessage(rep, status.Recipients)
+	for err == nil {
+		status.Attempts++
+		if err = sendReportMail(status.Recipients, msg); err == nil || status.Attempts == reportAttempts {
+			break
+		}
+		status.Error = err.Error()
+		setReportStatus(status)
+		<-clock.After(reportBackoff * time.Duration(status.Attempts))
+		err = nil
+	}
+	if err != nil {
+		status.State, status.Error = "failed", err.Error()
+		setReportStatus(status)
+		notify(SeverityError, "report", "Report %q could not be mailed after %d attempts: %v; it is saved in %s", rep.Title, status.Attempts, err, status.Dir)
+		return
+	}
+	status.State, status.Error = "sent", ""
+	setReportStatus(status)
+	notify(SeverityInfo, "report", "Report %q mailed to %s", rep.Title, strings.Join(status.Recipients, ", "))
+}
+
+func reportSender() string {
+	if *smtpFrom != "" {
+		return *smtpFrom
+	}
+	return *smtpUser
+}
+
+func sendReportMail(to []string, msg []byte) error {
+	var auth smtp.Auth
+	if *smtpUser != "" {
+		password := *smtpPassword
+		if password == "" {
+			password = os.Getenv("SMTP_PASSWORD")
+		}
+		host := *smtpAddr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", *smtpUser, password, host)
+	}
+	return smtp.SendMail(*smtpAddr, auth, reportSender(), to, msg)
+}
+
+// reportMessage is the mail: the HTML report as the body and the CSVs as
+// attachments.
+func reportMessage(rep Report, to []string) ([]byte, error) {
+	order, files, err := reportFiles(rep)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for i, name := range order {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Transfer-Encoding", "base64")
+		switch {
+		case i == 0:
+			h.Set("Content-Type", "text/html; charset=utf-8")
+		default:
+			h.Set("Content-Type", mime.FormatMediaType("text/csv", map[string]string{"charset": "utf-8", "name": name}))
+			h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		}
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		writeBase64Lines(part, files[name])
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", reportSender())
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Report: "+rep.Title))
+	fmt.Fprintf(&msg, "Date: %s\r\n", clock.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", w.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// writeBase64Lines writes data in base64 with lines of 76 characters, as
+// mail wants.
+func writeBase64Lines(w interface{ Write([]byte) (int, error) }, data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		fmt.Fprintf(w, "%s\r\n", enc[:76])
+		enc = enc[76:]
+	}
+	fmt.Fprintf(w, "%s\r\n", enc)
+}
+
+// reportName is the directory name of a report made now, outside a
+// session end.
+func reportName() string {
+	return "report-" + clock.Now().Local().Format("20060102-150405")
+}
+
+// currentReportTitle is the running session's name, or the show's.
+func currentReportTitle() string {
+	if s := currentSessions().Active; s != nil {
+		return s.Name
+	}
+	if *sessionName != "" {
+		return *sessionName
+	}
+	return "Quiz"
+}
+
+func getReport(c echo.Context) error {
+	reportMutex.Lock()
+	defer reportMutex.Unlock()
+	if lastReport == nil {
+		return notFound("no report has been made yet")
+	}
+	return c.JSON(http.StatusOK, lastReport)
+}
+
+// postReportSend reports on the current game, as "report send" does.
+func postReportSend(c echo.Context) error {
+	status, err := publishReport(buildReport(currentReportTitle()), reportName())
+	if err != nil {
+		return apiError(http.StatusInternalServerError, "report_failed", err.Error())
+	}
+	return c.JSON(http.StatusAccepted, status)
+}
+
+// handleReportCommand runs "report [send|render <dir>]".
+func handleReportCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	switch {
+	case len(args) == 0:
+		reportMutex.Lock()
+		s := lastReport
+		reportMutex.Unlock()
+		if s == nil {
+			info.Println("No report made yet (report send)")
+			return nil
+		}
+		info.Printf("Report %q in %s: %s", s.Title, s.Dir, s.State)
+		if s.Error != "" {
+			info.Printf(" (%s)", s.Error)
+		}
+		info.Println()
+	case args[0] == "send" && len(args) == 1:
+		status, err := publishReport(buildReport(currentReportTitle()), reportName())
+		if err != nil {
+			return err
+		}
+		if status.State == "sending" {
+			success.Printf("Report saved in %s, mailing it to %s\n", status.Dir, strings.Join(status.Recipients, ", "))
+			return nil
+		}
+		success.Printf("Report saved in %s (set -smtp-addr and -report-to to mail it)\n", status.Dir)
+	case args[0] == "render" && len(args) == 2:
+		if err := saveReport(buildReport(currentReportTitle()), args[1]); err != nil {
+			return err
+		}
+		success.Printf("Report written to %s\n", args[1])
+	default:
+		return errors.New("Usage: report [send|render <dir>]")
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts mail on a local port and hands every message over on
+// the returned channel.
+func fakeSMTP(t *testing.T) (string, <-chan []byte) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	msgs := make(chan []byte, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, msgs)
+		}
+	}()
+	return l.Addr().String(), msgs
+}
+
+func serveSMTP(conn net.Conn, msgs chan<- []byte) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+	reply("220 fake")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+		case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+			reply("250 ok")
+		case "DATA":
+			reply("354 go on")
+			var msg strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				msg.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msgs <- []byte(msg.String())
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 no")
+		}
+	}
+}
+
+// playReportGame plays two questions, one of them paused, for two teams.
+func playReportGame(t *testing.T, s *TestServer) {
+	t.Helper()
+	for _, name := range []string{"Sovy", "Líšky"} {
+		if err := addTeam(Team{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{
+		"question": "Hlavné mesto?", "type": "pomoc", "time_left": 30_000_000_000,
+		"matching": map[string]interface{}{"accepted": []string{"Bratislava"}, "ignore_case": true},
+	})
+	s.MustDo(t, http.MethodPost, "/answer", map[string]string{"team": "Sovy", "answer": "bratislava"})
+	s.MustDo(t, http.MethodPost, "/answer", map[string]string{"team": "Líšky", "answer": "Košice"})
+	if _, err := adjustManually("Sovy", 2, "správne", "test"); err != nil {
+		t.Fatal(err)
+	}
+	s.MustDo(t, http.MethodPost, "/pause", map[string]string{"reason": "tech"})
+	AdvanceClock(t, 45*time.Second)
+	s.MustDo(t, http.MethodPost, "/resume", nil)
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Koľko je 2+2?", "type": "pomoc", "time_left": 30_000_000_000})
+}
+
+func TestReportRender(t *testing.T) {
+	s := StartTestServer(t)
+	playReportGame(t, s)
+
+	dir := filepath.Join(t.TempDir(), "show")
+	if err := cliCommand(t, "report render "+dir); err != nil {
+		t.Fatal(err)
+	}
+	read := func(name string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if got := read("standings.csv"); got != "place,team,score\n1,Sovy,2\n2,Líšky,0\n" {
+		t.Errorf("standings.csv:\n%s", got)
+	}
+	questions := strings.Split(strings.TrimSpace(read("questions.csv")), "\n")
+	if len(questions) != 3 || !strings.HasPrefix(questions[1], "1,0,Hlavné mesto?,pomoc,") || !strings.HasSuffix(questions[1], ",2,2,1,45.0") {
+		t.Errorf("questions.csv:\n%s", strings.Join(questions, "\n"))
+	}
+	if got := read("pauses.csv"); got != "reason,count,seconds\ntech,1,45.0\n" {
+		t.Errorf("pauses.csv:\n%s", got)
+	}
+	// No queue, so no rounds.
+	if got := read("rounds.csv"); got != "round,name,questions,answers,judged,correct\n" {
+		t.Errorf("rounds.csv:\n%s", got)
+	}
+	page := read("report.html")
+	for _, want := range []string{"Sovy", "Hlavné mesto?", "50%", "tech"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("report.html lacks %q", want)
+		}
+	}
+
+	// Without SMTP a report is only saved.
+	defer func(d string) { *reportDir = d }(*reportDir)
+	*reportDir = t.TempDir()
+	var status ReportStatus
+	if code := s.Do(t, http.MethodPost, "/report/send", nil, &status); code != http.StatusAccepted || status.State != "saved" {
+		t.Fatalf("send: status %d, %+v", code, status)
+	}
+	if _, err := os.Stat(filepath.Join(status.Dir, "report.html")); err != nil {
+		t.Error(err)
+	}
+	if n := listNotifications(); n[len(n)-1].Category != "report" || !strings.Contains(n[len(n)-1].Message, status.Dir) {
+		t.Errorf("notification %+v", n[len(n)-1])
+	}
+}
+
+// TestReportMail ends a session with SMTP set up and reads the mail, then
+// has the mail fail and checks the retries and the error.
+func TestReportMail(t *testing.T) {
+	s := StartTestServer(t)
+	addr, msgs := fakeSMTP(t)
+	defer func(dir, to, smtp, from string) { *reportDir, *reportTo, *smtpAddr, *smtpFrom = dir, to, smtp, from }(*reportDir, *reportTo, *smtpAddr, *smtpFrom)
+	*reportDir, *reportTo, *smtpAddr, *smtpFrom = t.TempDir(), "org@example.com, host@example.com", addr, "quiz@example.com"
+
+	s.MustDo(t, http.MethodPost, "/session/start", map[string]string{"name": "Štúsková jeseň"})
+	playReportGame(t, s)
+	if status := s.DoConfirmed(t, http.MethodPost, "/session/end", nil, nil); status != http.StatusOK {
+		t.Fatalf("ending the session: status %d", status)
+	}
+
+	var raw []byte
+	select {
+	case raw = <-msgs:
+	case <-time.After(waitTimeout):
+		t.Fatal("no mail")
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); subject != "Report: Štúsková jeseň" || msg.Header.Get("To") != "org@example.com, host@example.com" {
+		t.Errorf("headers %v", msg.Header)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var names []string
+	for {
+		p, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := p.FileName()
+		if name == "" {
+			name = "body"
+			if !strings.Contains(string(data), "Hlavné mesto?") {
+				t.Errorf("the body lacks the questions")
+			}
+		}
+		if name == "standings.csv" && !strings.Contains(string(data), "1,Sovy,2") {
+			t.Errorf("standings.csv attached as %s", data)
+		}
+		names = append(names, name)
+	}
+	if strings.Join(names, ",") != "body,standings.csv,rounds.csv,questions.csv,pauses.csv" {
+		t.Errorf("parts %v", names)
+	}
+	eventually(t, "the report sent", func() bool {
+		reportMutex.Lock()
+		defer reportMutex.Unlock()
+		return lastReport != nil && lastReport.State == "sent"
+	})
+
+	// A server that refuses the connection is tried three times.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*smtpAddr = l.Addr().String()
+	l.Close()
+	var status ReportStatus
+	s.Do(t, http.MethodPost, "/report/send", nil, &status)
+	if status.State != "sending" || len(status.Recipients) != 2 {
+		t.Fatalf("send %+v", status)
+	}
+	var last ReportStatus
+	eventually(t, "the mail given up", func() bool {
+		s.Do(t, http.MethodGet, "/report", nil, &last)
+		if last.State != "failed" {
+			AdvanceClock(t, reportBackoff)
+		}
+		return last.State == "failed"
+	})
+	if last.Attempts != reportAttempts || last.Error == "" {
+		t.Errorf("failed report %+v", last)
+	}
+	n := listNotifications()
+	if e := n[len(n)-1]; e.Severity != SeverityError || !strings.Contains(e.Message, status.Dir) || !strings.Contains(e.Message, "after 3 attempts") {
+		t.Errorf("notification %+v", e)
+	}
+	if _, err := os.Stat(filepath.Join(status.Dir, "questions.csv")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var questionGap = flag.Duration("question-gap", 20*time.Second, "expected pause between two questions, used for rundown projections")
+
+// Checkpoint is a planned point in the show, reached when its round is over.
+type Checkpoint struct {
+	Name    string     `json:"name"`
+	Round   int        `json:"round"`
+	Planned time.Time  `json:"planned"`
+	Reached *time.Time `json:"reached,omitempty"`
+}
+
+// CheckpointView is a checkpoint with its projected time. A positive drift
+// means the show is behind schedule.
+type CheckpointView struct {
+	Checkpoint
+	Projected    time.Time `json:"projected"`
+	DriftSeconds int       `json:"drift_seconds"`
+}
+
+// RundownView is the whole schedule plus the drift of the next checkpoint.
+type RundownView struct {
+	Checkpoints  []CheckpointView `json:"checkpoints"`
+	Next         string           `json:"next,omitempty"`
+	DriftSeconds int              `json:"drift_seconds"`
+}
+
+// CheckpointRequest is one checkpoint in POST /rundown. Planned is either a
+// wall-clock time like "19:40" (today) or RFC 3339.
+type CheckpointRequest struct {
+	Name    string `json:"name"`
+	Round   int    `json:"round"`
+	Planned string `json:"planned"`
+}
+
+var (
+	rundown      []Checkpoint
+	rundownMutex sync.Mutex
+)
+
+func parsePlannedTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	hm, err := time.Parse("15:04", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("planned time %q must be HH:MM or RFC 3339", s)
+	}
+	// HH:MM is the operator's local time.
+	now := clock.Now().Local()
+	return time.Date(now.Year(), now.Month(), now.Day(), hm.Hour(), hm.Minute(), 0, 0, time.Local).UTC(), nil
+}
+
+func setRundown(reqs []CheckpointRequest, origin string) error {
+	list := make([]Checkpoint, 0, len(reqs))
+	for i, r := range reqs {
+		if r.Name == "" {
+			return fmt.Errorf("checkpoint %d: name is required", i+1)
+		}
+		planned, err := parsePlannedTime(r.Planned)
+		if err != nil {
+			return fmt.Errorf("checkpoint %d: %v", i+1, err)
+		}
+		list = append(list, Checkpoint{Name: r.Name, Round: r.Round, Planned: planned})
+	}
+	rundownMutex.Lock()
+	rundown = list
+	rundownMutex.Unlock()
+	audit("rundown", origin, map[string]interface{}{"checkpoints": len(list)})
+	checkRundown()
+	return nil
+}
+
+// markCheckpoint marks the n-th (1-based) checkpoint reached now.
+func markCheckpoint(n int, origin string) error {
+	rundownMutex.Lock()
+	if n < 1 || n > len(rundown) {
+		rundownMutex.Unlock()
+		return fmt.Errorf("no checkpoint %d", n)
+	}
+	now := clock.Now()
+	rundown[n-1].Reached = &now
+	name := rundown[n-1].Name
+	rundownMutex.Unlock()
+	audit("checkpoint", origin, map[string]interface{}{"name": name})
+	broadcastRundown()
+	return nil
+}
+
+// roundRemaining is the projected time until every queued question of the
+// given round and earlier has been played, or false once they all have.
+func roundRemaining(round int, now time.Time) (time.Duration, bool) {
+	live, fromQueue := liveQueueEntry()
+	questionMutex.RLock()
+	q := question
+	questionMutex.RUnlock()
+
+	var total time.Duration
+	pending := false
+	if fromQueue && live.Round <= round {
+		deadline, running := countdownDeadline(q)
+		switch {
+		case running:
+			pending = true
+			total += deadline.Sub(now)
+		case q.Type == "end" || q.Type == "waiting":
+		case q.Paused && !q.CountUp:
+			// A paused countdown restarts from TimeLeft on resume.
+			pending = true
+			total += q.TimeLeft
+		case q.CountUp:
+			pending = true
+		}
+	}
+	for _, e := range listQueue() {
+		if !e.Asked && e.Round <= round {
+			pending = true
+			total += e.TimeLeft + *questionGap
+		}
+	}
+	return total, pending
+}
+
+// roundQueued reports whether the queue has any questions for round.
+func roundQueued(round int) bool {
+	for _, e := range listQueue() {
+		if e.Round == round {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRundown marks checkpoints whose rounds are over and sends the new
+// projection to operator streams.
+func checkRundown() {
+	now := clock.Now()
+	rundownMutex.Lock()
+	var reached []string
+	for i, cp := range rundown {
+		if cp.Reached != nil || cp.Round == 0 {
+			continue
+		}
+		if _, pending := roundRemaining(cp.Round, now); !pending && roundQueued(cp.Round) {
+			at := now
+			rundown[i].Reached = &at
+			reached = append(reached, cp.Name)
+		}
+	}
+	rundownMutex.Unlock()
+	for _, name := range reached {
+		audit("checkpoint", "auto", map[string]interface{}{"name": name})
+	}
+	broadcastRundown()
+}
+
+func currentRundown() RundownView {
+	now := clock.Now()
+	rundownMutex.Lock()
+	list := append([]Checkpoint(nil), rundown...)
+	rundownMutex.Unlock()
+
+	view := RundownView{Checkpoints: []CheckpointView{}}
+	for _, cp := range list {
+		v := CheckpointView{Checkpoint: cp}
+		if cp.Reached != nil {
+			v.Projected = *cp.Reached
+		} else {
+			remaining, _ := roundRemaining(cp.Round, now)
+			v.Projected = now.Add(remaining)
+		}
+		v.DriftSeconds = int(v.Projected.Sub(cp.Planned).Round(time.Second) / time.Second)
+		if cp.Reached == nil && view.Next == "" {
+			view.Next = cp.Name
+			view.DriftSeconds = v.DriftSeconds
+		}
+		view.Checkpoints = append(view.Checkpoints, v)
+	}
+	return view
+}
+
+func broadcastRundown() {
+	rundownMutex.Lock()
+	empty := len(rundown) == 0
+	rundownMutex.Unlock()
+	if !empty {
+		hub.broadcastOperator(Event{Type: "rundown", Data: currentRundown()})
+	}
+}
+
+func getRundown(c echo.Context) error {
+	return c.JSON(http.StatusOK, currentRundown())
+}
+
+func postRundown(c echo.Context) error {
+	var req struct {
+		Checkpoints []CheckpointRequest `json:"checkpoints"`
+	}
+	if err := bindJSON(c, &req); err != nil {
+		return bindError(err)
+	}
+	if err := setRundown(req.Checkpoints, requestOrigin(c)); err != nil {
+		return badRequest(err.Error())
+	}
+	return c.JSON(http.StatusOK, currentRundown())
+}
+
+func postRundownMark(c echo.Context) error {
+	n, err := strconv.Atoi(c.Param("n"))
+	if err == nil {
+		err = markCheckpoint(n, requestOrigin(c))
+	}
+	if err != nil {
+		return notFound("checkpoint not found")
+	}
+	return c.JSON(http.StatusOK, currentRundown())
+}
+
+// formatDrift renders a drift like "+4m10s behind".
+func formatDrift(seconds int) string {
+	switch {
+	case seconds > 0:
+		return fmt.Sprintf("+%s behind", time.Duration(seconds)*time.Second)
+	case seconds < 0:
+		return fmt.Sprintf("%s ahead", time.Duration(-seconds)*time.Second)
+	default:
+		return "on time"
+	}
+}
+
+func handleRundownCommand(args []string, origin string) error {
+	errorC := color.New(color.FgRed)
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 2 && args[0] == "mark" {
+		n, err := strconv.Atoi(args[1])
+		if err == nil {
+			err = markCheckpoint(n, origin)
+		}
+		if err != nil {
+			return fmt.Errorf("Unknown checkpoint: %s", args[1])
+		}
+		success.Printf("Checkpoint %d reached\n", n)
+		return nil
+	}
+	if len(args) != 0 {
+		return errors.New("Usage: rundown [mark <n>]")
+	}
+
+	view := currentRundown()
+	if len(view.Checkpoints) == 0 {
+		info.Println("No rundown loaded (POST /rundown)")
+		return nil
+	}
+	for i, cp := range view.Checkpoints {
+		c := success
+		if cp.DriftSeconds > 0 {
+			c = errorC
+		}
+		state := "projected"
+		if cp.Reached != nil {
+			state = "reached"
+		}
+		c.Printf(" %d. %-16s R%d  planned %s  %s %s  %s\n", i+1, cp.Name, cp.Round,
+			cp.Planned.Local().Format("15:04"), state, cp.Projected.Local().Format("15:04"), formatDrift(cp.DriftSeconds))
+	}
+	return nil
+}
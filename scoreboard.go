@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var highlightWindow = flag.Duration("highlight-window", 5*time.Second, "how long a team stays highlighted on the scoreboard after its score changes")
+
+// ScoreboardEntry is one row of the public scoreboard.
+type ScoreboardEntry struct {
+	Rank      int    `json:"rank"`
+	Name      string `json:"name"`
+	ShortName string `json:"short_name"`
+	Color     string `json:"color"`
+	LogoURL   string `json:"logo_url,omitempty"`
+	Score     int    `json:"score"`
+	Delta     int    `json:"delta"`
+
+	changedAt time.Time
+}
+
+// Scoreboard is the audience view of the standings.
+type Scoreboard struct {
+	Entries   []ScoreboardEntry `json:"entries"`
+	Highlight []string          `json:"highlight"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+var (
+	scoreboard      Scoreboard
+	scoreboardMutex sync.RWMutex
+)
+
+// questionDeltas are the score changes and last change times per team,
+// lowercased, since the latest question. observeScoreboard keeps them from
+// the audited score changes.
+var questionDeltas = struct {
+	sync.Mutex
+	deltas  map[string]int
+	changed map[string]time.Time
+}{deltas: map[string]int{}, changed: map[string]time.Time{}}
+
+// observeScoreboard starts the deltas over on a new question and adds each
+// score change to them.
+func observeScoreboard(e AuditEntry) {
+	questionDeltas.Lock()
+	defer questionDeltas.Unlock()
+	switch e.Action {
+	case "question":
+		questionDeltas.deltas = map[string]int{}
+		questionDeltas.changed = map[string]time.Time{}
+	case "score":
+		name, _ := e.Details["team"].(string)
+		key := strings.ToLower(name)
+		questionDeltas.deltas[key] += detailInt(e.Details["delta"])
+		questionDeltas.changed[key] = e.Time
+	}
+}
+
+// rebuildScoreboard recomputes the standings with the per-team deltas since
+// the last question, then pushes the result to stream subscribers. It runs
+// on score changes so GET /scoreboard stays cheap.
+func rebuildScoreboard() {
+	list := listTeams()
+
+	questionDeltas.Lock()
+	deltas, changed := map[string]int{}, map[string]time.Time{}
+	for k, v := range questionDeltas.deltas {
+		deltas[k] = v
+	}
+	for k, v := range questionDeltas.changed {
+		changed[k] = v
+	}
+	questionDeltas.Unlock()
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Score > list[j].Score })
+	board := Scoreboard{Entries: make([]ScoreboardEntry, len(list)), UpdatedAt: clock.Now()}
+	for i, t := range list {
+		rank := i + 1
+		if i > 0 && t.Score == list[i-1].Score {
+			rank = board.Entries[i-1].Rank
+		}
+		key := strings.ToLower(t.Name)
+		board.Entries[i] = ScoreboardEntry{
+			Rank:      rank,
+			Name:      t.Name,
+			ShortName: t.ShortName,
+			Color:     t.Color,
+			LogoURL:   t.LogoURL,
+			Score:     t.Score,
+			Delta:     deltas[key],
+			changedAt: changed[key],
+		}
+	}
+
+	scoreboardMutex.Lock()
+	scoreboard = board
+	scoreboardMutex.Unlock()
+
+	hub.broadcast(Event{Type: "scoreboard", Data: currentScoreboard()})
+}
+
+// currentScoreboard returns the precomputed scoreboard with the highlight
+// list evaluated against the current time.
+func currentScoreboard() Scoreboard {
+	scoreboardMutex.RLock()
+	defer scoreboardMutex.RUnlock()
+	board := scoreboard
+	board.Entries = append([]ScoreboardEntry{}, scoreboard.Entries...)
+	board.Highlight = []string{}
+	now := clock.Now()
+	for _, e := range board.Entries {
+		if !e.changedAt.IsZero() && now.Sub(e.changedAt) < *highlightWindow {
+			board.Highlight = append(board.Highlight, e.Name)
+		}
+	}
+	return board
+}
+
+func getScoreboard(c echo.Context) error {
+	return c.JSON(http.StatusOK, currentScoreboard())
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var awardPoints = flag.Int("award-points", 10, "points a correct answer earns when the question's scoring policy doesn't say")
+
+// ScoringPolicy says how many points a correct answer earns. Static, the
+// default, gives every correct team the full points. Decay starts at the
+// full points and falls to Floor at the deadline, linearly or, with Steps,
+// in that many equal steps.
+type ScoringPolicy struct {
+	Mode   string `json:"mode"`
+	Points int    `json:"points,omitempty"`
+	Floor  int    `json:"floor,omitempty"`
+	Steps  int    `json:"steps,omitempty"`
+}
+
+// ScoreBreakdown is what one team's answer would earn if judged correct.
+type ScoreBreakdown struct {
+	Team    string        `json:"team"`
+	Latency time.Duration `json:"latency"`
+	Points  int           `json:"points"`
+	Locked  bool          `json:"locked,omitempty"`
+}
+
+func validateScoring(p *ScoringPolicy) error {
+	if p == nil {
+		return nil
+	}
+	switch p.Mode {
+	case "", "static", "decay":
+	default:
+		return fmt.Errorf("scoring.mode must be static or decay")
+	}
+	if p.Points < 0 || p.Floor < 0 || p.Steps < 0 {
+		return fmt.Errorf("scoring values must be non-negative")
+	}
+	if p.Mode == "decay" && p.Floor > fullPoints(p) {
+		return fmt.Errorf("scoring.floor must not exceed the full points")
+	}
+	return nil
+}
+
+func fullPoints(p *ScoringPolicy) int {
+	if p == nil || p.Points == 0 {
+		return *awardPoints
+	}
+	return p.Points
+}
+
+// earnedPoints applies p to an answer given latency into the answer time
+// with remaining still left on the countdown. Count-up questions have no
+// deadline and always give the full points.
+func earnedPoints(p *ScoringPolicy, latency, remaining time.Duration) int {
+	full := fullPoints(p)
+	window := latency + remaining
+	if p == nil || p.Mode != "decay" || window <= 0 {
+		return full
+	}
+	frac := float64(latency) / float64(window)
+	if frac > 1 {
+		frac = 1
+	}
+	if p.Steps > 1 {
+		step := math.Min(math.Floor(frac*float64(p.Steps)), float64(p.Steps-1))
+		frac = step / float64(p.Steps-1)
+	} else if p.Steps == 1 {
+		frac = 0
+	}
+	return int(math.Round(float64(full) - float64(full-p.Floor)*frac))
+}
+
+// scoreBreakdown computes every answer's points under p, earliest first.
+func scoreBreakdown(p *ScoringPolicy, answered []Answer) []ScoreBreakdown {
+	sorted := append([]Answer(nil), answered...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+	out := make([]ScoreBreakdown, 0, len(sorted))
+	for _, a := range sorted {
+		out = append(out, ScoreBreakdown{Team: a.Team, Latency: a.Latency, Points: answerPoints(p, a), Locked: a.LockedAt != nil})
+	}
+	return out
+}
+
+// liveBreakdown is the breakdown for the live question. It must be called
+// with questionMutex held.
+func liveBreakdown() []ScoreBreakdown {
+	return scoreBreakdown(question.Scoring, listAnswers())
+}
+
+// awardTeams adds each team's computed points to its score. The teams are
+// the ones the host judged correct; all must have answered.
+func awardTeams(names []string, origin string) ([]ScoreBreakdown, error) {
+	questionMutex.RLock()
+	breakdown := liveBreakdown()
+	questionMutex.RUnlock()
+
+	byTeam := map[string]ScoreBreakdown{}
+	for _, b := range breakdown {
+		byTeam[b.Team] = b
+	}
+	var awarded []ScoreBreakdown
+	for _, name := range names {
+		t, ok := findTeam(name)
+		if !ok {
+			return nil, &RoundError{Code: "unknown_team", Message: "unknown team: " + name}
+		}
+		b, ok := byTeam[t.Name]
+		if !ok {
+			return nil, &RoundError{Code: "no_answer", Message: t.Name + " has not answered"}
+		}
+		awarded = append(awarded, b)
+	}
+	for _, b := range awarded {
+		if _, err := adjustScore(b.Team, b.Points, causeAward, origin); err != nil {
+			return nil, err
+		}
+	}
+	return awarded, nil
+}
+
+// AwardRequest is the body of POST /answers/award. Correct awards every
+// team whose free-text answer was judged correct instead of naming them.
+type AwardRequest struct {
+	Teams   []string `json:"teams"`
+	Correct bool     `json:"correct"`
+}
+
+func postAward(c echo.Context) error {
+	req := new(AwardRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if req.Correct {
+		names, err := correctTeams()
+		if err != nil {
+			return roundError(err)
+		}
+		if len(names) == 0 {
+			return c.JSON(http.StatusOK, []ScoreBreakdown{})
+		}
+		req.Teams = names
+	}
+	if len(req.Teams) == 0 {
+		return badRequest("teams must list at least one team")
+	}
+	awarded, err := awardTeams(req.Teams, requestOrigin(c))
+	if err != nil {
+		return roundError(err)
+	}
+	return c.JSON(http.StatusOK, awarded)
+}
+
+// handleAwardCommand runs "award [<team>...|--correct]".
+func handleAwardCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 0 {
+		questionMutex.RLock()
+		breakdown := liveBreakdown()
+		questionMutex.RUnlock()
+		if len(breakdown) == 0 {
+			info.Println("No answers yet")
+			return nil
+		}
+		for _, b := range breakdown {
+			lock := ""
+			if b.Locked {
+				lock = "  locked"
+			}
+			info.Printf("  %-20s %6.1fs  %d points%s\n", b.Team, b.Latency.Seconds(), b.Points, lock)
+		}
+		info.Println("Award the correct teams with award <team>... or award --correct")
+		return nil
+	}
+	if len(args) == 1 && args[0] == "--correct" {
+		names, err := correctTeams()
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			info.Println("No answers were judged correct")
+			return nil
+		}
+		args = names
+	}
+	awarded, err := awardTeams(args, origin)
+	if err != nil {
+		return err
+	}
+	for _, b := range awarded {
+		success.Printf("%s earned %d points\n", b.Team, b.Points)
+	}
+	return nil
+}
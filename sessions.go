@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var sessionsDir = flag.String("sessions-dir", "sessions", "directory for named game sessions and their archives")
+
+const (
+	sessionIndexFile    = "index.json"
+	sessionSnapshotFile = "active.tar.gz"
+	// sessionArchiveEntry is the extra file in a session archive that
+	// describes the session.
+	sessionArchiveEntry = "session.json"
+	// sessionFairnessEntry holds the fairness reports of the session's
+	// questions, see fairness.go. Restores ignore it.
+	sessionFairnessEntry = "fairness.json"
+)
+
+// SessionRecord is one named game of the evening.
+type SessionRecord struct {
+	ID        int             `json:"id"`
+	Name      string          `json:"name"`
+	StartedAt time.Time       `json:"started_at"`
+	EndedAt   *time.Time      `json:"ended_at,omitempty"`
+	Summary   *SessionSummary `json:"summary,omitempty"`
+}
+
+// SessionSummary is what GET /sessions shows of a finished session.
+type SessionSummary struct {
+	Questions int      `json:"questions"`
+	Answers   int      `json:"answers"`
+	Teams     int      `json:"teams"`
+	Winners   []string `json:"winners,omitempty"`
+	TopScore  int      `json:"top_score"`
+}
+
+// activeSession is the running session, if any; pendingSession is an
+// unclosed one found at startup, waiting to be resumed or discarded.
+// sessionSince is when the current game's data starts, see timelines.
+var (
+	sessionMutex   sync.Mutex
+	sessionIndex   []SessionRecord
+	activeSession  *SessionRecord
+	pendingSession *SessionRecord
+	sessionSince   time.Time
+)
+
+var errNoSession = errors.New("no session is running")
+
+func sessionPath(name string) string {
+	return filepath.Join(*sessionsDir, name)
+}
+
+func sessionArchivePath(id int) string {
+	return sessionPath(fmt.Sprintf("session-%d.tar.gz", id))
+}
+
+// configureSessions loads the session index and looks for a session the
+// last run left open.
+func configureSessions() error {
+	data, err := os.ReadFile(sessionPath(sessionIndexFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &sessionIndex); err != nil {
+			return fmt.Errorf("%s: %v", sessionPath(sessionIndexFile), err)
+		}
+	}
+	s, err := readSessionSnapshot()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	sessionMutex.Lock()
+	pendingSession = s
+	sessionMutex.Unlock()
+	notify(SeverityWarning, "session", "Session %q (started %s) was not closed; 'session resume' restores it from its snapshot, 'session discard' drops it",
+		s.Name, s.StartedAt.Local().Format("15:04"))
+	return nil
+}
+
+func readSessionSnapshot() (*SessionRecord, error) {
+	f, err := os.Open(sessionPath(sessionSnapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	files, _, err := readBackupArchive(f)
+	if err != nil {
+		return nil, err
+	}
+	var s SessionRecord
+	if err := json.Unmarshal(files[sessionArchiveEntry], &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", sessionArchiveEntry, err)
+	}
+	return &s, nil
+}
+
+// writeSessionArchive writes a backup archive of the live state with s
+// added to it.
+func writeSessionArchive(path string, s SessionRecord) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*sessionsDir, 0o755); err != nil {
+		return err
+	}
+	fairness, err := json.MarshalIndent(fairnessReports(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeArchiveFileWith(path, 0, map[string][]byte{sessionArchiveEntry: data, sessionFairnessEntry: fairness})
+}
+
+// snapshotSession refreshes the running session's snapshot, from which it
+// can be resumed after a crash.
+func snapshotSession() error {
+	sessionMutex.Lock()
+	s := activeSession
+	sessionMutex.Unlock()
+	if s == nil {
+		return nil
+	}
+	return writeSessionArchive(sessionPath(sessionSnapshotFile), *s)
+}
+
+// observeSessions snapshots the session whenever a question ends. It runs
+// inside audit, so the snapshot is written elsewhere.
+func observeSessions(e AuditEntry) {
+	if e.Action != "finalize" {
+		return
+	}
+	go func() {
+		if err := snapshotSession(); err != nil {
+			notify(SeverityWarning, "session", "Session snapshot failed: %v", err)
+		}
+	}()
+}
+
+func startSession(name, origin string) (SessionRecord, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return SessionRecord{}, fmt.Errorf("a session needs a name")
+	}
+	sessionMutex.Lock()
+	if activeSession != nil {
+		active := activeSession.Name
+		sessionMutex.Unlock()
+		return SessionRecord{}, fmt.Errorf("session %q is still running, end it first", active)
+	}
+	if pendingSession != nil {
+		pending := pendingSession.Name
+		sessionMutex.Unlock()
+		return SessionRecord{}, fmt.Errorf("session %q was not closed, resume or discard it first", pending)
+	}
+	id := 1
+	for _, s := range sessionIndex {
+		id = max(id, s.ID+1)
+	}
+	s := &SessionRecord{ID: id, Name: name, StartedAt: clock.Now()}
+	activeSession = s
+	sessionSince = s.StartedAt
+	sessionMutex.Unlock()
+
+	audit("session_start", origin, map[string]interface{}{"id": s.ID, "name": s.Name})
+	if err := snapshotSession(); err != nil {
+		notify(SeverityWarning, "session", "Session snapshot failed: %v", err)
+	}
+	return *s, nil
+}
+
+// endSession archives the running session and clears the game state for
+// the next one. Teams, the queue, the bank and settings stay.
+func endSession(origin string) (SessionRecord, error) {
+	sessionMutex.Lock()
+	s := activeSession
+	sessionMutex.Unlock()
+	if s == nil {
+		return SessionRecord{}, errNoSession
+	}
+
+	finalizeQuestion("session_end")
+	var report Report
+	if *reportOnEnd {
+		report = buildReport(s.Name)
+	}
+	ended := *s
+	now := clock.Now()
+	ended.EndedAt = &now
+	ended.Summary = summarizeSession()
+	if err := writeSessionArchive(sessionArchivePath(ended.ID), ended); err != nil {
+		return SessionRecord{}, fmt.Errorf("writing the session archive: %v", err)
+	}
+	if err := recordBankStats(listHistory(), origin); err != nil {
+		notify(SeverityError, "bank", "Bank question stats could not be saved: %v", err)
+	}
+
+	sessionMutex.Lock()
+	sessionIndex = append(sessionIndex, ended)
+	err := saveSessionIndex()
+	activeSession = nil
+	sessionSince = now
+	sessionMutex.Unlock()
+	if err != nil {
+		notify(SeverityError, "session", "Session index could not be saved: %v", err)
+	}
+	os.Remove(sessionPath(sessionSnapshotFile))
+
+	resetGame(origin)
+	audit("session_end", origin, map[string]interface{}{"id": ended.ID, "name": ended.Name})
+	if *reportOnEnd {
+		go publishReport(report, fmt.Sprintf("session-%d", ended.ID))
+	}
+	return ended, nil
+}
+
+// saveSessionIndex writes the index. Callers hold sessionMutex.
+func saveSessionIndex() error {
+	data, err := json.MarshalIndent(sessionIndex, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*sessionsDir, 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(sessionPath(sessionIndexFile), data)
+}
+
+func summarizeSession() *SessionSummary {
+	sum := &SessionSummary{}
+	for _, e := range listHistory() {
+		sum.Questions++
+		if e.Record != nil {
+			sum.Answers += len(e.Record.Answers)
+		}
+	}
+	list := listTeams()
+	sum.Teams = len(list)
+	for _, st := range rankTeams(list) {
+		if st.Place == 1 {
+			sum.Winners = append(sum.Winners, st.Team.Name)
+			sum.TopScore = st.Team.Score
+		}
+	}
+	return sum
+}
+
+// resetGame clears what belongs to one game: history, the round, scores,
+// statistics and the question ordinals.
+func resetGame(origin string) {
+	undoBarrier("reset_game", origin)
+	resetRound()
+	installHistory(nil)
+	installAdjustments(nil)
+	installDisputes(nil)
+	installPresence(nil)
+	teamsMutex.Lock()
+	for i := range teams {
+		teams[i].Score = 0
+	}
+	teamsMutex.Unlock()
+	rebuildScoreboard()
+	resetTimelines()
+	resetPauseTotals()
+	resetStats("session")
+	metaMutex.Lock()
+	metaLast, metaOrdinal, metaAsked = nil, 0, map[int]int{}
+	metaMutex.Unlock()
+	roundChanged("session")
+}
+
+// resumeSession restores the unclosed session found at startup from its
+// snapshot and carries on with it.
+func resumeSession(origin string) (SessionRecord, error) {
+	sessionMutex.Lock()
+	s := pendingSession
+	sessionMutex.Unlock()
+	if s == nil {
+		return SessionRecord{}, fmt.Errorf("there is no unclosed session")
+	}
+	if _, err := restoreBackupFile(sessionPath(sessionSnapshotFile), true, origin); err != nil {
+		return SessionRecord{}, err
+	}
+	sessionMutex.Lock()
+	pendingSession = nil
+	activeSession = s
+	sessionSince = s.StartedAt
+	sessionMutex.Unlock()
+	audit("session_resume", origin, map[string]interface{}{"id": s.ID, "name": s.Name})
+	go sendCurrentQuestion()
+	return *s, nil
+}
+
+// discardSession drops the unclosed session found at startup.
+func discardSession(origin string) (SessionRecord, error) {
+	sessionMutex.Lock()
+	s := pendingSession
+	pendingSession = nil
+	sessionMutex.Unlock()
+	if s == nil {
+		return SessionRecord{}, fmt.Errorf("there is no unclosed session")
+	}
+	if err := os.Remove(sessionPath(sessionSnapshotFile)); err != nil && !os.IsNotExist(err) {
+		return SessionRecord{}, err
+	}
+	audit("session_discard", origin, map[string]interface{}{"id": s.ID, "name": s.Name})
+	return *s, nil
+}
+
+// gameSince is when the current game's data starts.
+func gameSince() time.Time {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	return sessionSince
+}
+
+// SessionsView is the answer of GET /sessions.
+type SessionsView struct {
+	Active   *SessionRecord  `json:"active,omitempty"`
+	Unclosed *SessionRecord  `json:"unclosed,omitempty"`
+	Sessions []SessionRecord `json:"sessions"`
+}
+
+func currentSessions() SessionsView {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	v := SessionsView{Sessions: append([]SessionRecord{}, sessionIndex...)}
+	if activeSession != nil {
+		a := *activeSession
+		v.Active = &a
+	}
+	if pendingSession != nil {
+		p := *pendingSession
+		v.Unclosed = &p
+	}
+	return v
+}
+
+// SessionStartRequest is the body of POST /session/start.
+type SessionStartRequest struct {
+	Name string `json:"name"`
+}
+
+func postSessionStart(c echo.Context) error {
+	req := new(SessionStartRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return badRequest("name is required")
+	}
+	s, err := startSession(req.Name, requestOrigin(c))
+	if err != nil {
+		return conflict(err.Error())
+	}
+	return c.JSON(http.StatusOK, s)
+}
+
+func postSessionEnd(c echo.Context) error {
+	s, err := endSession(requestOrigin(c))
+	if err == errNoSession {
+		return conflict(err.Error())
+	}
+	if err != nil {
+		return apiError(http.StatusInternalServerError, "archive_failed", err.Error())
+	}
+	return c.JSON(http.StatusOK, s)
+}
+
+func getSessions(c echo.Context) error {
+	return c.JSON(http.StatusOK, currentSessions())
+}
+
+func getSessionExport(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return badRequest("invalid session id")
+	}
+	found := false
+	for _, s := range currentSessions().Sessions {
+		found = found || s.ID == id
+	}
+	if !found {
+		return notFound(fmt.Sprintf("session %d not found", id))
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filepath.Base(sessionArchivePath(id))))
+	return c.File(sessionArchivePath(id))
+}
+
+// handleSessionCommand runs "session [start <name>|end|list|resume|discard]".
+func handleSessionCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	errorC := color.New(color.FgRed)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 0 || args[0] == "list" {
+		v := currentSessions()
+		for _, s := range v.Sessions {
+			sum := s.Summary
+			if sum == nil {
+				sum = &SessionSummary{}
+			}
+			info.Printf("  #%-3d %-20s %s  %d questions, %s %d\n", s.ID, s.Name, s.StartedAt.Local().Format("15:04"),
+				sum.Questions, strings.Join(sum.Winners, ", "), sum.TopScore)
+		}
+		if v.Active != nil {
+			success.Printf("Running: %s (since %s)\n", v.Active.Name, v.Active.StartedAt.Local().Format("15:04"))
+		} else {
+			info.Println("No session running")
+		}
+		if v.Unclosed != nil {
+			errorC.Printf("Unclosed: %s (session resume | session discard)\n", v.Unclosed.Name)
+		}
+		return nil
+	}
+
+	var s SessionRecord
+	var err error
+	switch {
+	case args[0] == "start" && len(args) > 1:
+		s, err = startSession(strings.Join(args[1:], " "), origin)
+	case args[0] == "end" && len(args) == 1:
+		if !confirmCLI("session_end", sessionEndSummary(nil)) {
+			return errors.New("Ending the session cancelled")
+		}
+		s, err = endSession(origin)
+	case args[0] == "resume" && len(args) == 1:
+		s, err = resumeSession(origin)
+	case args[0] == "discard" && len(args) == 1:
+		s, err = discardSession(origin)
+	default:
+		return errors.New("Usage: session [start <name>|end|list|resume|discard]")
+	}
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "start":
+		success.Printf("Session #%d %s started\n", s.ID, s.Name)
+	case "end":
+		success.Printf("Session #%d %s archived to %s\n", s.ID, s.Name, sessionArchivePath(s.ID))
+	case "resume":
+		success.Printf("Session #%d %s resumed\n", s.ID, s.Name)
+	case "discard":
+		success.Printf("Session #%d %s discarded\n", s.ID, s.Name)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// shutdownReason is what streaming clients are told when the server exits.
+const shutdownReason = "server shutting down"
+
+var shutdownSnapshot = flag.String("shutdown-snapshot", "shutdown-snapshot.tar.gz", "backup archive written on exit (empty disables)")
+
+var (
+	processStart = time.Now()
+	httpServer   *echo.Echo
+	shutdownOnce sync.Once
+)
+
+// shutdownStep is one stage of the exit sequence. Steps run in order, each
+// bounded by its own timeout so a stuck one can't use up the others' time;
+// together they fit the 10 seconds a supervisor usually waits.
+type shutdownStep struct {
+	name    string
+	timeout time.Duration
+	run     func() (string, error)
+}
+
+// ShutdownStepResult is the outcome of one step as shown in the report.
+type ShutdownStepResult struct {
+	Name    string
+	Outcome string
+	OK      bool
+	Took    time.Duration
+}
+
+// ShutdownReport summarises the exit for the operator.
+type ShutdownReport struct {
+	Reason         string
+	Uptime         time.Duration
+	QuestionsAsked int
+	Abandoned      []string
+	Snapshot       string
+	Steps          []ShutdownStepResult
+}
+
+// waitForShutdown shuts down gracefully on SIGINT or SIGTERM.
+func waitForShutdown() {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	shutdown(sig.String())
+	os.Exit(0)
+}
+
+// shutdown runs the exit sequence once and prints its report. A second
+// caller, such as a signal arriving during "exit", waits for the first.
+func shutdown(reason string) {
+	shutdownOnce.Do(func() {
+		fmt.Println("Shutting down server...")
+		printShutdownReport(runShutdown(reason))
+	})
+}
+
+func runShutdown(reason string) ShutdownReport {
+	report := ShutdownReport{Reason: reason, QuestionsAsked: currentStats().QuestionsAsked}
+	steps := []shutdownStep{
+		{"file export", time.Second, func() (string, error) {
+			if !stopFileExport() {
+				return "not running", nil
+			}
+			return "stopped", nil
+		}},
+		{"streams", time.Second, func() (string, error) {
+			n := hub.closeStreams(shutdownReason)
+			return fmt.Sprintf("closed %d client(s)", n), nil
+		}},
+		{"http server", 2 * time.Second, func() (string, error) {
+			if httpServer == nil {
+				return "not started", nil
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			if err := httpServer.Shutdown(ctx); err != nil {
+				return "", err
+			}
+			return "stopped", nil
+		}},
+		{"audit", 500 * time.Millisecond, func() (string, error) {
+			finalizeQuestion("shutdown")
+			audit("shutdown", "server", map[string]interface{}{"reason": reason})
+			questionMutex.RLock()
+			recordEvent("shutdown")
+			questionMutex.RUnlock()
+			return "recorded", nil
+		}},
+		{"session", time.Second, func() (string, error) {
+			if currentSessions().Active == nil {
+				return "none running", nil
+			}
+			if err := snapshotSession(); err != nil {
+				return "", err
+			}
+			return "snapshot written", nil
+		}},
+		{"wal", time.Second, func() (string, error) {
+			if wal == nil {
+				return "disabled", nil
+			}
+			if err := stopWAL(); err != nil {
+				return "", err
+			}
+			return "committed", nil
+		}},
+		{"push queue", 3 * time.Second, func() (string, error) {
+			if pending := drainPushes(2900 * time.Millisecond); len(pending) > 0 {
+				return "", fmt.Errorf("abandoned %s", strings.Join(pending, ", "))
+			}
+			return "drained", nil
+		}},
+		{"snapshot", 1500 * time.Millisecond, func() (string, error) {
+			if *shutdownSnapshot == "" {
+				return "disabled", nil
+			}
+			if err := writeBackupFile(*shutdownSnapshot); err != nil {
+				return "", err
+			}
+			return "written", nil
+		}},
+		{"recording", time.Second, func() (string, error) {
+			if recorder == nil {
+				return "not recording", nil
+			}
+			stopRecording()
+			return "closed", nil
+		}},
+	}
+	for _, s := range steps {
+		result := runShutdownStep(s)
+		if s.name == "snapshot" && result.OK && *shutdownSnapshot != "" {
+			report.Snapshot = *shutdownSnapshot
+		}
+		report.Steps = append(report.Steps, result)
+	}
+	// Whatever is still pending now is lost, whether the drain finished or
+	// timed out.
+	report.Abandoned = pendingTargets()
+	report.Uptime = time.Since(processStart)
+	return report
+}
+
+func runShutdownStep(s shutdownStep) ShutdownStepResult {
+	type outcome struct {
+		text string
+		err  error
+	}
+	start := time.Now()
+	done := make(chan outcome, 1)
+	go func() {
+		text, err := s.run()
+		done <- outcome{text, err}
+	}()
+	result := ShutdownStepResult{Name: s.name}
+	select {
+	case o := <-done:
+		result.OK = o.err == nil
+		result.Outcome = o.text
+		if o.err != nil {
+			result.Outcome = o.err.Error()
+		}
+	case <-time.After(s.timeout):
+		result.Outcome = fmt.Sprintf("timed out after %s", s.timeout)
+	}
+	result.Took = time.Since(start)
+	return result
+}
+
+// drainPushes waits up to timeout for every target to take the latest
+// state and returns the names of those that still hold undelivered changes.
+func drainPushes(timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		pending := pendingTargets()
+		if len(pending) == 0 || time.Now().After(deadline) {
+			return pending
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// pendingTargets names the enabled targets with an undelivered state.
+func pendingTargets() []string {
+	var names []string
+	for _, s := range pushTargetStatuses() {
+		if s.Pending && s.Enabled {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
+
+func printShutdownReport(r ShutdownReport) {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+	errorC := color.New(color.FgRed)
+
+	info.Printf("Shutdown (%s)\n", r.Reason)
+	info.Printf("  uptime:          %s\n", r.Uptime.Round(time.Second))
+	info.Printf("  questions asked: %d\n", r.QuestionsAsked)
+	if len(r.Abandoned) > 0 {
+		errorC.Printf("  unsynced pushes: %d abandoned (%s)\n", len(r.Abandoned), strings.Join(r.Abandoned, ", "))
+	} else {
+		info.Println("  unsynced pushes: none")
+	}
+	if r.Snapshot != "" {
+		info.Printf("  snapshot:        %s\n", r.Snapshot)
+	} else {
+		info.Println("  snapshot:        none")
+	}
+	for _, s := range r.Steps {
+		c, status := success, "ok"
+		if !s.OK {
+			c, status = errorC, "FAILED"
+		}
+		c.Printf("  %-12s %-6s %s (%s)\n", s.Name, status, s.Outcome, s.Took.Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+var countUpCeiling = flag.Duration("count-up-ceiling", 6*time.Hour, "freeze a count-up question once it has run this long (0 disables)")
+
+// countUpCeilingDeadline returns when q, a running count-up, reaches
+// -count-up-ceiling. A count-up left running overnight would otherwise show
+// ever larger values that displays can't parse.
+func countUpCeilingDeadline(q Question) (time.Time, bool) {
+	if *countUpCeiling <= 0 || !q.CountUp || q.Stalled || q.Paused || q.Reading || q.Type == "end" || q.Type == "waiting" {
+		return time.Time{}, false
+	}
+	return q.StartTime.Add(*countUpCeiling), true
+}
+
+// stallCountUp freezes the live count-up at the ceiling. The tick scheduler
+// sleeps while the question is stalled; setting a time, a count-up or a new
+// question gives it a running clock again.
+func stallCountUp() {
+	questionMutex.Lock()
+	deadline, ok := countUpCeilingDeadline(question)
+	if !ok || clock.Now().Before(deadline) {
+		questionMutex.Unlock()
+		return
+	}
+	question.TimeLeft = *countUpCeiling
+	question.Stalled = true
+	text := question.Question
+	stateChanged("stall")
+	questionMutex.Unlock()
+
+	audit("count_up_stall", "timer", map[string]interface{}{"question": text, "ceiling": countUpCeiling.Seconds()})
+	notify(SeverityWarning, "timer", "Count-up of %q has run for %s and was stopped; set a time or a new question to carry on", text, *countUpCeiling)
+	sendCurrentQuestion()
+}
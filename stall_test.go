@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func flaskStatus(t *testing.T) TargetStatus {
+	t.Helper()
+	for _, s := range pushTargetStatuses() {
+		if s.Name == "flask" {
+			return s
+		}
+	}
+	t.Fatal("no flask target")
+	return TargetStatus{}
+}
+
+// TestCountUpStall jumps a count-up past -count-up-ceiling and checks that
+// it freezes there, stops ticking and pushing, and runs again once given a
+// new clock.
+func TestCountUpStall(t *testing.T) {
+	s := StartTestServer(t)
+	defer func(d time.Duration) { *countUpCeiling = d }(*countUpCeiling)
+	*countUpCeiling = time.Minute
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Koľko to trvá?", "type": "pomoc", "count_up": true, "time_left": 0})
+	stalled := func() bool {
+		questionMutex.RLock()
+		defer questionMutex.RUnlock()
+		return question.Stalled
+	}
+
+	AdvanceClock(t, 30*time.Second)
+	if stalled() {
+		t.Fatal("stalled before the ceiling")
+	}
+	notified := len(listNotifications())
+	AdvanceClock(t, 50*time.Second)
+	eventually(t, "the count-up stalled", stalled)
+
+	var q PublicQuestionView
+	s.Do(t, http.MethodGet, "/get-question", nil, &q)
+	if !q.Stalled || q.TimeLeft != time.Minute {
+		t.Errorf("public view stalled %v at %s", q.Stalled, q.TimeLeft)
+	}
+	if e := lastAudit(t, "count_up_stall"); e.Details["question"] != "Koľko to trvá?" {
+		t.Errorf("stall audited as %+v", e)
+	}
+	if n := listNotifications(); len(n) != notified+1 || n[notified].Severity != SeverityWarning || !strings.Contains(n[notified].Message, "Koľko to trvá?") {
+		t.Errorf("notifications %+v", n[notified:])
+	}
+	eventually(t, "the ticks stopped", func() bool { return armedTick().IsZero() })
+	s.Flask.WaitFor(t, "the stall pushed", func(p map[string]interface{}) bool { return p["stalled"] == true })
+	eventually(t, "the stall delivered", func() bool { return pendingPushCount() == 0 })
+
+	// Time going by changes nothing, and resending the state is skipped.
+	pushed, skipped := len(s.Flask.Payloads()), flaskStatus(t).Skipped
+	AdvanceClock(t, time.Hour)
+	s.Do(t, http.MethodGet, "/get-question", nil, &q)
+	if q.TimeLeft != time.Minute {
+		t.Errorf("a stalled count-up moved to %s", q.TimeLeft)
+	}
+	sendCurrentQuestion()
+	eventually(t, "the resend skipped", func() bool { return flaskStatus(t).Skipped == skipped+1 })
+	if n := len(s.Flask.Payloads()); n != pushed {
+		t.Errorf("%d pushes while stalled", n-pushed)
+	}
+	if !armedTick().IsZero() {
+		t.Error("a stalled count-up ticks")
+	}
+
+	if err := cliCommand(t, "time 30"); err != nil {
+		t.Fatal(err)
+	}
+	if stalled() {
+		t.Error("a new time kept the stall")
+	}
+	eventually(t, "the ticks started", func() bool { return !armedTick().IsZero() })
+	s.Flask.WaitFor(t, "the new time pushed", func(p map[string]interface{}) bool { return p["stalled"] == nil && p["time_left"] == 30e9 })
+}
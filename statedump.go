@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/fatih/color"
+)
+
+var dumpDir = flag.String("dump-dir", "dumps", "directory for state dumps taken on SIGUSR1")
+
+// verboseEvents logs every internal state transition to the console. It is
+// toggled with SIGUSR2.
+var verboseEvents atomic.Bool
+
+// writeStateDump writes the /debug/state document, goroutines included, to
+// a timestamped file. The state is collected first, each part under its
+// own lock for only as long as it takes to copy it, and written after, so a
+// slow disk never holds up requests.
+func writeStateDump() (string, error) {
+	state := collectDebugState(true)
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(*dumpDir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(*dumpDir, "state-"+state.ServerTime.Local().Format("20060102-150405.000")+".json")
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// dumpState is the SIGUSR1 action.
+func dumpState() {
+	path, err := writeStateDump()
+	if err != nil {
+		notify(SeverityError, "debug", "State dump failed: %v", err)
+		return
+	}
+	notify(SeverityInfo, "debug", "State dumped to %s", path)
+}
+
+// toggleVerboseEvents is the SIGUSR2 action.
+func toggleVerboseEvents() {
+	on := !verboseEvents.Load()
+	verboseEvents.Store(on)
+	state := "off"
+	if on {
+		state = "on"
+	}
+	notify(SeverityInfo, "debug", "Verbose event logging %s", state)
+}
+
+// logInternalEvent prints a state transition while verbose logging is on.
+func logInternalEvent(ev InternalEvent) {
+	if verboseEvents.Load() {
+		asyncPrintf(color.New(color.FgCyan), "[event] #%d %s %s\n", ev.Revision, ev.Kind, ev.Time.Local().Format("15:04:05.000"))
+	}
+}
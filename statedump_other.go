@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// watchDebugSignals does nothing where there are no user signals.
+func watchDebugSignals() {}
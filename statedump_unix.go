@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchDebugSignals dumps the state on SIGUSR1 and toggles verbose event
+// logging on SIGUSR2.
+func watchDebugSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	for sig := range sigs {
+		if sig == syscall.SIGUSR1 {
+			dumpState()
+		} else {
+			toggleVerboseEvents()
+		}
+	}
+}
@@ -0,0 +1,91 @@
+//go:build unix
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestStateDump takes a dump as SIGUSR1 does, then one into a pipe nobody
+// reads yet, and checks the state stays free while the write waits.
+func TestStateDump(t *testing.T) {
+	StartTestServer(t)
+	defer func(dir string) { *dumpDir = dir }(*dumpDir)
+	*dumpDir = filepath.Join(t.TempDir(), "dumps")
+
+	notified := len(listNotifications())
+	dumpState()
+	n := listNotifications()[notified:]
+	if len(n) != 1 || n[0].Severity != SeverityInfo || !strings.HasPrefix(n[0].Message, "State dumped to "+*dumpDir) {
+		t.Fatalf("notifications %+v", n)
+	}
+	data, err := os.ReadFile(strings.TrimPrefix(n[0].Message, "State dumped to "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dumped DebugState
+	if err := json.Unmarshal(data, &dumped); err != nil {
+		t.Fatal(err)
+	}
+	questionMutex.RLock()
+	rev := revision
+	questionMutex.RUnlock()
+	running := false
+	for _, w := range dumped.Workers {
+		running = running || w.Name == "expiry" && w.Running
+	}
+	if dumped.Revision != rev || !dumped.ServerTime.Equal(clock.Now()) || !running || !strings.Contains(dumped.GoroutineDump, "goroutine ") {
+		t.Errorf("dumped revision %d of %d at %s, workers %+v", dumped.Revision, rev, dumped.ServerTime, dumped.Workers)
+	}
+
+	// Opening a pipe for writing waits for a reader.
+	AdvanceClock(t, time.Second)
+	pipe := filepath.Join(*dumpDir, "state-"+clock.Now().Local().Format("20060102-150405.000")+".json")
+	if err := syscall.Mkfifo(pipe, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := writeStateDump()
+		written <- err
+	}()
+	eventually(t, "the dump waiting on the pipe", func() bool {
+		buf := make([]byte, 1<<20)
+		stacks := string(buf[:runtime.Stack(buf, true)])
+		return strings.Contains(stacks, "os.WriteFile") && strings.Contains(stacks, "writeStateDump")
+	})
+	changed := make(chan struct{})
+	go func() {
+		roundChanged("test_dump")
+		close(changed)
+	}()
+	select {
+	case <-changed:
+	case <-time.After(waitTimeout):
+		t.Fatal("a state change waited for the dump's write")
+	}
+
+	f, err := os.Open(pipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err = io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &dumped); err != nil || dumped.Revision != rev {
+		t.Errorf("piped dump at revision %d, want %d: %v", dumped.Revision, rev, err)
+	}
+}
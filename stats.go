@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// Stats summarises the session so far for the host.
+type Stats struct {
+	SessionStart    time.Time      `json:"session_start"`
+	SessionDuration time.Duration  `json:"session_duration"`
+	QuestionsAsked  int            `json:"questions_asked"`
+	PerType         map[string]int `json:"per_type"`
+	// The timing figures cover countdown questions that were advanced from.
+	AverageUsed     time.Duration  `json:"average_used"`
+	MedianUsed      time.Duration  `json:"median_used"`
+	AverageAllotted time.Duration  `json:"average_allotted"`
+	LongestPause    time.Duration  `json:"longest_pause"`
+	Buzzes          map[string]int `json:"buzzes"`
+	Answers         map[string]int `json:"answers"`
+	// Accuracy counts the judged answers of each team, by the rules of
+	// teamOutcomes, once their question is over.
+	Accuracy map[string]TeamAccuracy `json:"accuracy"`
+}
+
+// TeamAccuracy is how many of a team's judged answers were right. Rate is
+// Correct over Judged.
+type TeamAccuracy struct {
+	Judged  int     `json:"judged"`
+	Correct int     `json:"correct"`
+	Rate    float64 `json:"rate"`
+}
+
+// statsQuestion is the question being timed.
+type statsQuestion struct {
+	start    time.Time
+	allotted time.Duration
+	paused   time.Duration
+	ended    time.Time
+}
+
+// The counters are updated from each audit entry as it is written, so
+// GET /stats never scans the log.
+var (
+	statsMutex    sync.Mutex
+	stats         = newStats()
+	statsCurrent  *statsQuestion
+	statsUsed     []time.Duration
+	statsAllotted time.Duration
+)
+
+func newStats() Stats {
+	return Stats{
+		SessionStart: clock.Now(),
+		PerType:      map[string]int{},
+		Buzzes:       map[string]int{},
+		Answers:      map[string]int{},
+		Accuracy:     map[string]TeamAccuracy{},
+	}
+}
+
+func resetStats(origin string) {
+	statsMutex.Lock()
+	stats = newStats()
+	statsCurrent = nil
+	statsUsed = nil
+	statsAllotted = 0
+	statsMutex.Unlock()
+	audit("stats_reset", origin, nil)
+}
+
+func detailSeconds(v interface{}) time.Duration {
+	f, _ := v.(float64)
+	return time.Duration(f * float64(time.Second))
+}
+
+// observeStats folds one audit entry into the statistics.
+func observeStats(e AuditEntry) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	switch e.Action {
+	case "question":
+		finishStatsQuestion(e.Time)
+		typ, _ := e.Details["type"].(string)
+		stats.PerType[typ]++
+		if typ != "end" && typ != "waiting" {
+			stats.QuestionsAsked++
+		}
+		if countUp, _ := e.Details["count_up"].(bool); !countUp && typ != "end" && typ != "waiting" {
+			statsCurrent = &statsQuestion{start: e.Time, allotted: detailSeconds(e.Details["time_left"])}
+		}
+	case "expire":
+		if statsCurrent != nil && statsCurrent.ended.IsZero() {
+			statsCurrent.ended = e.Time
+		}
+	case "resume":
+		d := detailSeconds(e.Details["duration"])
+		if d > stats.LongestPause {
+			stats.LongestPause = d
+		}
+		if statsCurrent != nil {
+			statsCurrent.paused += d
+		}
+	case "buzz":
+		if team, ok := e.Details["team"].(string); ok {
+			stats.Buzzes[team]++
+		}
+	case "answer":
+		if team, ok := e.Details["team"].(string); ok {
+			stats.Answers[team]++
+		}
+	case "finalize":
+		outcomes, _ := e.Details["outcomes"].(map[string]bool)
+		for team, correct := range outcomes {
+			a := stats.Accuracy[team]
+			a.Judged++
+			if correct {
+				a.Correct++
+			}
+			a.Rate = float64(a.Correct) / float64(a.Judged)
+			stats.Accuracy[team] = a
+		}
+	}
+}
+
+// finishStatsQuestion records how long the timed question actually ran.
+// Call with statsMutex held.
+func finishStatsQuestion(now time.Time) {
+	if statsCurrent == nil {
+		return
+	}
+	end := now
+	if !statsCurrent.ended.IsZero() {
+		end = statsCurrent.ended
+	}
+	used := end.Sub(statsCurrent.start) - statsCurrent.paused
+	if used < 0 {
+		used = 0
+	}
+	statsUsed = append(statsUsed, used)
+	statsAllotted += statsCurrent.allotted
+	statsCurrent = nil
+}
+
+func currentStats() Stats {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	out := stats
+	out.SessionDuration = clock.Since(stats.SessionStart)
+	out.PerType = copyCounts(stats.PerType)
+	out.Buzzes = copyCounts(stats.Buzzes)
+	out.Answers = copyCounts(stats.Answers)
+	out.Accuracy = make(map[string]TeamAccuracy, len(stats.Accuracy))
+	for team, a := range stats.Accuracy {
+		out.Accuracy[team] = a
+	}
+	if n := len(statsUsed); n > 0 {
+		sorted := append([]time.Duration{}, statsUsed...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		var total time.Duration
+		for _, d := range sorted {
+			total += d
+		}
+		out.AverageUsed = total / time.Duration(n)
+		out.AverageAllotted = statsAllotted / time.Duration(n)
+		out.MedianUsed = sorted[n/2]
+		if n%2 == 0 {
+			out.MedianUsed = (sorted[n/2-1] + sorted[n/2]) / 2
+		}
+	}
+	return out
+}
+
+func copyCounts(m map[string]int) map[string]int {
+	out := make(map[string]int, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func getStats(c echo.Context) error {
+	return c.JSON(http.StatusOK, currentStats())
+}
+
+func handleStatsCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 1 && args[0] == "reset" {
+		if !confirmCLI("stats_reset", statsResetSummary()) {
+			return errors.New("Statistics reset cancelled")
+		}
+		resetStats(origin)
+		success.Println("Statistics reset")
+		return nil
+	}
+	s := currentStats()
+	round := func(d time.Duration) time.Duration { return d.Round(time.Second) }
+	info.Printf("Session: %s (since %s)\n", round(s.SessionDuration), s.SessionStart.Local().Format("15:04"))
+	info.Printf("Questions asked: %d\n", s.QuestionsAsked)
+	for typ, n := range s.PerType {
+		info.Printf("  %-10s %d\n", typ, n)
+	}
+	if s.AverageUsed > 0 {
+		info.Printf("Time used: average %s, median %s (average allotted %s)\n", round(s.AverageUsed), round(s.MedianUsed), round(s.AverageAllotted))
+	}
+	if s.LongestPause > 0 {
+		info.Printf("Longest pause: %s\n", round(s.LongestPause))
+	}
+	seen := map[string]bool{}
+	var teams []string
+	for _, m := range []map[string]int{s.Buzzes, s.Answers} {
+		for team := range m {
+			if !seen[team] {
+				seen[team] = true
+				teams = append(teams, team)
+			}
+		}
+	}
+	for team := range s.Accuracy {
+		if !seen[team] {
+			seen[team] = true
+			teams = append(teams, team)
+		}
+	}
+	sort.Strings(teams)
+	for _, team := range teams {
+		line := fmt.Sprintf("  %-12s %d buzz(es), %d answer(s)", team, s.Buzzes[team], s.Answers[team])
+		if a, ok := s.Accuracy[team]; ok {
+			line += fmt.Sprintf(", %.0f%% of %d judged correct", a.Rate*100, a.Judged)
+		}
+		info.Println(line)
+	}
+	return nil
+}
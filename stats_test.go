@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStatsAccuracyPerTeam(t *testing.T) {
+	s := StartTestServer(t)
+	for _, name := range []string{"Sovy", "Líšky"} {
+		if err := addTeam(Team{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	answer := func(team, text string) {
+		t.Helper()
+		s.MustDo(t, http.MethodPost, "/answer", map[string]string{"team": team, "answer": text})
+	}
+
+	// A free-text question is judged by its verdicts.
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{
+		"question": "Hlavné mesto?", "type": "pomoc", "time_left": 30_000_000_000,
+		"matching": map[string]interface{}{"accepted": []string{"Bratislava"}, "ignore_case": true},
+	})
+	answer("Sovy", "bratislava")
+	answer("Líšky", "Košice")
+
+	// Any other question by who scored on it.
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Koľko je 2+2?", "type": "pomoc", "time_left": 30_000_000_000})
+	answer("Sovy", "4")
+	answer("Líšky", "5")
+	if _, err := adjustManually("Sovy", 1, "správne", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if st := currentStats(); len(st.Accuracy) != 2 || st.Accuracy["Sovy"].Judged != 1 {
+		t.Errorf("accuracy before the question ended: %+v", st.Accuracy)
+	}
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Ďalšia", "type": "pomoc", "time_left": 30_000_000_000})
+
+	var st Stats
+	s.Do(t, http.MethodGet, "/stats", nil, &st)
+	if a := st.Accuracy["Sovy"]; a.Judged != 2 || a.Correct != 2 || a.Rate != 1 {
+		t.Errorf("Sovy %+v", a)
+	}
+	if a := st.Accuracy["Líšky"]; a.Judged != 2 || a.Correct != 0 || a.Rate != 0 {
+		t.Errorf("Líšky %+v", a)
+	}
+	if err := handleStatsCommand(nil, "cli"); err != nil {
+		t.Error(err)
+	}
+
+	resetGame("test")
+	if st := currentStats(); len(st.Accuracy) != 0 {
+		t.Errorf("accuracy after a reset: %+v", st.Accuracy)
+	}
+}
@@ -0,0 +1,106 @@
+//go:build load
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStreamCapUnderLoad opens hundreds of viewer streams at once and
+// checks that no more than -max-streams get in, and that an operator still
+// does. Run it with go test -tags load -run TestStreamCapUnderLoad.
+func TestStreamCapUnderLoad(t *testing.T) {
+	const streamCap, clients = 200, 500
+	s := StartTestServer(t)
+	openStreams(t)
+	defer func(n int) { *maxStreams = n }(*maxStreams)
+	*maxStreams = streamCap
+	before := hub.streamStats()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	open := func(key string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/events?types=none", nil)
+		if err != nil {
+			return nil, err
+		}
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		return client.Do(req)
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		statuses = map[int]int{}
+		streams  []*http.Response
+		failures []error
+	)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := open("")
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures = append(failures, err)
+				return
+			}
+			statuses[resp.StatusCode]++
+			if resp.StatusCode == http.StatusOK {
+				streams = append(streams, resp)
+			} else {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	defer func() {
+		for _, resp := range streams {
+			resp.Body.Close()
+		}
+	}()
+	if len(failures) > 0 {
+		t.Fatalf("%d connections failed, first: %v", len(failures), failures[0])
+	}
+	if statuses[http.StatusOK] != streamCap || statuses[http.StatusServiceUnavailable] != clients-streamCap {
+		t.Fatalf("statuses %v", statuses)
+	}
+	if st := hub.streamStats(); st.Open != streamCap || st.Rejected != before.Rejected+clients-streamCap {
+		t.Errorf("stats %+v", st)
+	}
+
+	// An operator gets in by evicting a viewer, which is told so.
+	resp, err := open(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("operator: status %d", resp.StatusCode)
+	}
+	if st := hub.streamStats(); st.Open != streamCap || st.Evicted != before.Evicted+1 {
+		t.Errorf("stats after the operator %+v", st)
+	}
+	evicted := make(chan struct{}, len(streams))
+	for _, r := range streams {
+		go func(r *http.Response) {
+			if line, _ := bufio.NewReader(r.Body).ReadString('\n'); line == "event: evicted\n" {
+				evicted <- struct{}{}
+			}
+		}(r)
+	}
+	select {
+	case <-evicted:
+	case <-time.After(waitTimeout):
+		t.Error("no viewer was sent an evicted event")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	workerRestarts = flag.Int("worker-restarts", 5, "how many times in a row a crashed background worker is restarted before its subsystem is marked degraded")
+	workerBackoff  = flag.Duration("worker-backoff", time.Second, "delay before restarting a crashed background worker, doubling with each crash in a row")
+)
+
+const (
+	// maxWorkerBackoff caps the restart delay.
+	maxWorkerBackoff = time.Minute
+	// workerStableAfter is how long a worker must run before a crash no
+	// longer counts as one in a row.
+	workerStableAfter = time.Minute
+)
+
+// WorkerStatus is a supervised background worker, as /debug/state and
+// /readyz show it.
+type WorkerStatus struct {
+	Name     string `json:"name"`
+	Running  bool   `json:"running"`
+	Restarts int    `json:"restarts"`
+	// Degraded is set once the worker crashed -worker-restarts times in a
+	// row; it is not restarted again.
+	Degraded    bool       `json:"degraded"`
+	LastPanic   string     `json:"last_panic,omitempty"`
+	LastPanicAt *time.Time `json:"last_panic_at,omitempty"`
+	Stack       string     `json:"stack,omitempty"`
+}
+
+// supervisor runs background workers, recovering their panics and
+// restarting them with backoff. Echo's Recover covers the handlers; this
+// covers the goroutines that keep the show going.
+type supervisor struct {
+	mu      sync.Mutex
+	workers map[string]*WorkerStatus
+	// onPanic reports a crash; the restart delay is 0 when the worker was
+	// given up.
+	onPanic func(name string, value interface{}, stack []byte, restartIn time.Duration)
+}
+
+func newSupervisor() *supervisor {
+	return &supervisor{workers: map[string]*WorkerStatus{}, onPanic: reportWorkerPanic}
+}
+
+var workers = newSupervisor()
+
+// goWorker runs fn on its own goroutine under the supervisor.
+func (s *supervisor) goWorker(name string, fn func()) {
+	go s.run(name, fn)
+}
+
+// run runs fn until it returns, restarting it after a panic. It returns
+// when fn returns or when the worker is given up.
+func (s *supervisor) run(name string, fn func()) {
+	st := &WorkerStatus{Name: name, Running: true}
+	s.mu.Lock()
+	s.workers[name] = st
+	s.mu.Unlock()
+
+	inRow := 0
+	for {
+		started := clock.Now()
+		value, stack, crashed := runRecovered(fn)
+		if !crashed {
+			// A worker that is done, like the one of a removed push
+			// target, is forgotten unless a new one took its name.
+			s.mu.Lock()
+			if s.workers[name] == st {
+				delete(s.workers, name)
+			}
+			s.mu.Unlock()
+			return
+		}
+		if clock.Since(started) >= workerStableAfter {
+			inRow = 0
+		}
+		inRow++
+
+		now := clock.Now()
+		wait := restartDelay(inRow)
+		s.mu.Lock()
+		st.LastPanic, st.LastPanicAt, st.Stack = fmt.Sprint(value), &now, string(stack)
+		giveUp := inRow > *workerRestarts
+		if giveUp {
+			st.Running, st.Degraded = false, true
+			wait = 0
+		} else {
+			st.Restarts++
+		}
+		s.mu.Unlock()
+		s.onPanic(name, value, stack, wait)
+		if giveUp {
+			return
+		}
+		<-clock.After(wait)
+	}
+}
+
+// runRecovered calls fn and reports whether it panicked, and with what.
+func runRecovered(fn func()) (value interface{}, stack []byte, crashed bool) {
+	defer func() {
+		if value = recover(); value != nil {
+			stack, crashed = debug.Stack(), true
+		}
+	}()
+	fn()
+	return nil, nil, false
+}
+
+// restartDelay is -worker-backoff doubled for each crash in a row after
+// the first.
+func restartDelay(inRow int) time.Duration {
+	d := *workerBackoff
+	for i := 1; i < inRow && d < maxWorkerBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxWorkerBackoff)
+}
+
+func reportWorkerPanic(name string, value interface{}, stack []byte, restartIn time.Duration) {
+	asyncPrintf(color.New(color.FgRed), "Worker %s panicked: %v\n%s", name, value, stack)
+	if restartIn == 0 {
+		notify(SeverityError, "worker", "Worker %s crashed %d times in a row and was stopped: %v", name, *workerRestarts+1, value)
+		return
+	}
+	notify(SeverityError, "worker", "Worker %s crashed, restarting in %s: %v", name, restartIn, value)
+}
+
+// statuses lists the workers by name.
+func (s *supervisor) statuses() []WorkerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]WorkerStatus, 0, len(s.workers))
+	for _, st := range s.workers {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// degraded lists the workers given up.
+func (s *supervisor) degraded() []string {
+	var names []string
+	for _, st := range s.statuses() {
+		if st.Degraded {
+			names = append(names, st.Name)
+		}
+	}
+	return names
+}
+
+// Readiness is the answer of /readyz.
+type Readiness struct {
+	Status   string   `json:"status"`
+	Degraded []string `json:"degraded,omitempty"`
+}
+
+// getReadyz is 200 "ready" while every worker runs, and 503 "degraded"
+// naming the workers given up.
+func getReadyz(c echo.Context) error {
+	if names := workers.degraded(); len(names) > 0 {
+		return c.JSON(http.StatusServiceUnavailable, Readiness{Status: "degraded", Degraded: names})
+	}
+	return c.JSON(http.StatusOK, Readiness{Status: "ready"})
+}
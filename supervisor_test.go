@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRestartDelay(t *testing.T) {
+	defer func(d time.Duration) { *workerBackoff = d }(*workerBackoff)
+	*workerBackoff = 10 * time.Second
+	for inRow, want := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second, 4: maxWorkerBackoff, 9: maxWorkerBackoff} {
+		if got := restartDelay(inRow); got != want {
+			t.Errorf("crash %d in a row: %s, want %s", inRow, got, want)
+		}
+	}
+}
+
+// TestWorkerPanics runs a worker that always panics: it is restarted with
+// a growing delay until it has crashed -worker-restarts times in a row,
+// then given up, which /readyz reports.
+func TestWorkerPanics(t *testing.T) {
+	s := StartTestServer(t)
+	defer func(n int, d time.Duration) { *workerRestarts, *workerBackoff = n, d }(*workerRestarts, *workerBackoff)
+	*workerRestarts, *workerBackoff = 2, 100*time.Millisecond
+	defer func() {
+		workers.mu.Lock()
+		delete(workers.workers, "rozbitý")
+		workers.mu.Unlock()
+	}()
+
+	var calls atomic.Int32
+	notified := len(listNotifications())
+	workers.goWorker("rozbitý", func() {
+		calls.Add(1)
+		panic("rozbité")
+	})
+	for i, want := range []string{"restarting in 100ms: rozbité", "restarting in 200ms: rozbité", "crashed 3 times in a row and was stopped: rozbité"} {
+		eventually(t, "the crash reported", func() bool {
+			if len(listNotifications()) > notified+i {
+				return true
+			}
+			// The worker may be waiting out the previous delay.
+			AdvanceClock(t, 100*time.Millisecond)
+			return false
+		})
+		if n := listNotifications()[notified+i]; n.Severity != SeverityError || !strings.HasSuffix(n.Message, want) {
+			t.Errorf("notification %+v, want %q", n, want)
+		}
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d runs", n)
+	}
+
+	var state struct {
+		Workers []WorkerStatus `json:"workers"`
+	}
+	s.Do(t, http.MethodGet, "/debug/state", nil, &state)
+	var st WorkerStatus
+	for _, w := range state.Workers {
+		if w.Name == "rozbitý" {
+			st = w
+		}
+	}
+	if !st.Degraded || st.Running || st.Restarts != 2 || st.LastPanic != "rozbité" || st.Stack == "" {
+		t.Errorf("worker %+v", st)
+	}
+	var ready Readiness
+	if status := s.Do(t, http.MethodGet, "/readyz", nil, &ready); status != http.StatusServiceUnavailable || len(ready.Degraded) != 1 || ready.Degraded[0] != "rozbitý" {
+		t.Errorf("readyz: status %d, %+v", status, ready)
+	}
+
+	// A worker that is done is forgotten.
+	done := make(chan struct{})
+	workers.goWorker("hotový", func() { close(done) })
+	<-done
+	eventually(t, "the finished worker forgotten", func() bool {
+		for _, w := range workers.statuses() {
+			if w.Name == "hotový" {
+				return false
+			}
+		}
+		return true
+	})
+}
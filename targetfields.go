@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// derivedPushFields are worked out per push, for targets that select them,
+// instead of being stored on the question.
+var derivedPushFields = []string{"phase", "time_left_ms"}
+
+// envelopeFields wrap the version 2 payload and always stay. Field
+// selection applies to the question inside, where "question" is its text.
+var envelopeFields = map[string]bool{"schema_version": true, "revision": true}
+
+// questionPushFields are the JSON names of FlaskQuestion, in payload order.
+func questionPushFields() []string {
+	t := reflect.TypeOf(FlaskQuestion{})
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		names = append(names, name)
+	}
+	return names
+}
+
+// validatePushFields checks a target's fields and exclude lists.
+func validatePushFields(t PushTarget) error {
+	known := map[string]bool{}
+	for _, f := range append(questionPushFields(), derivedPushFields...) {
+		known[f] = true
+	}
+	for _, list := range [][]string{t.Fields, t.Exclude} {
+		for _, f := range list {
+			if envelopeFields[f] {
+				return fmt.Errorf("%s is part of the payload envelope and can't be selected or removed", f)
+			}
+			if !known[f] {
+				return fmt.Errorf("unknown payload field %q", f)
+			}
+		}
+	}
+	return nil
+}
+
+// effectiveFields is what a target receives of the question: its fields
+// list, or every stored field when it has none, less anything excluded.
+// Derived fields are only sent when listed.
+func effectiveFields(t PushTarget) []string {
+	listed := map[string]bool{}
+	for _, f := range t.Fields {
+		listed[f] = true
+	}
+	excluded := map[string]bool{}
+	for _, f := range t.Exclude {
+		excluded[f] = true
+	}
+	var out []string
+	for _, f := range questionPushFields() {
+		if (len(t.Fields) == 0 || listed[f]) && !excluded[f] {
+			out = append(out, f)
+		}
+	}
+	for _, f := range derivedPushFields {
+		if listed[f] && !excluded[f] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func filtersFields(t PushTarget) bool {
+	return len(t.Fields) > 0 || len(t.Exclude) > 0
+}
+
+func wantsDerivedFields(t PushTarget) bool {
+	for _, f := range t.Fields {
+		for _, d := range derivedPushFields {
+			if f == d {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectFields reduces q, plus the derived values, to the target's field
+// set. Fields the question leaves empty stay out as before.
+func selectFields(t PushTarget, q FlaskQuestion, derived map[string]interface{}) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+	all := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	for name, v := range derived {
+		if all[name], err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	out := map[string]json.RawMessage{}
+	for _, f := range effectiveFields(t) {
+		if v, ok := all[f]; ok {
+			out[f] = v
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// revisionField matches the v2 envelope's revision.
+var revisionField = regexp.MustCompile(`"revision":\d+`)
+
+var updateTargetGolden = flag.Bool("update-targets", false, "rewrite testdata/targets from the current payloads")
+
+// goldenTargets are the field selections locked by testdata/targets.
+var goldenTargets = []PushTarget{
+	{Name: "flask", SchemaVersion: 1},
+	{Name: "flask-v2", SchemaVersion: 2},
+	{Name: "lighting", SchemaVersion: 2, Fields: []string{"type", "phase", "time_left_ms"}},
+	{Name: "overlay", SchemaVersion: 1, Fields: []string{"question", "options", "time_left", "time_left_ms"}, Exclude: []string{"time_left"}},
+	{Name: "no-options", SchemaVersion: 1, Exclude: []string{"options", "variants"}},
+}
+
+// TestTargetFieldsGolden builds every golden target's payload for the same
+// question. The clock is set to a fixed time first so start_time doesn't
+// depend on the tests run before, and the revision, which does, is zeroed.
+func TestTargetFieldsGolden(t *testing.T) {
+	s := StartTestServer(t)
+	AdvanceClock(t, testEpoch.Add(time.Hour).Sub(clock.Now()))
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{
+		"question": "Ktorá rieka tečie cez Bratislavu?", "type": "pomoc", "time_left": 30_000_000_000,
+		"options":  []string{"Dunaj", "Váh", "Morava"},
+		"variants": map[string]string{"en": "Which river flows through Bratislava?"},
+	})
+	AdvanceClock(t, 12*time.Second)
+
+	for _, target := range goldenTargets {
+		if err := validatePushFields(target); err != nil {
+			t.Fatalf("%s: %v", target.Name, err)
+		}
+		body, err := pushPayload(target)
+		if err != nil {
+			t.Fatalf("%s: %v", target.Name, err)
+		}
+		body = revisionField.ReplaceAll(body, []byte(`"revision":0`))
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, body, "", "  "); err != nil {
+			t.Fatal(err)
+		}
+		got := append(pretty.Bytes(), '\n')
+
+		path := filepath.Join("testdata", "targets", target.Name+".golden")
+		if *updateTargetGolden {
+			if err := os.WriteFile(path, got, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s payload changed, run with -update-targets if that is intended:\n%s", target.Name, got)
+		}
+	}
+}
+
+func TestTargetFieldsValidation(t *testing.T) {
+	s := StartTestServer(t)
+	for _, tc := range []struct {
+		target PushTarget
+		err    string
+	}{
+		{PushTarget{Fields: []string{"type", "schema_version"}}, "envelope"},
+		{PushTarget{Exclude: []string{"revision"}}, "envelope"},
+		{PushTarget{Fields: []string{"answers"}}, `unknown payload field "answers"`},
+		{PushTarget{Exclude: []string{"phase", "typo"}}, `"typo"`},
+	} {
+		if err := validatePushFields(tc.target); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%+v: %v", tc.target, err)
+		}
+	}
+	if status := s.Do(t, http.MethodPost, "/targets", map[string]interface{}{
+		"name": "lighting", "url": "http://127.0.0.1:1/", "schema_version": 2, "exclude": []string{"schema_version"},
+	}, nil); status != http.StatusBadRequest {
+		t.Errorf("adding a target without the envelope: status %d", status)
+	}
+
+	// Derived fields are sent only when listed, and excluding wins.
+	fields := strings.Join(effectiveFields(PushTarget{Fields: []string{"type", "phase", "time_left_ms"}, Exclude: []string{"time_left_ms"}}), ",")
+	if fields != "type,phase" {
+		t.Errorf("effective fields %s", fields)
+	}
+	if fields := effectiveFields(PushTarget{Exclude: []string{"type"}}); len(fields) != len(questionPushFields())-1 {
+		t.Errorf("excluding one field leaves %v", fields)
+	}
+	if err := cliCommand(t, "target show flask"); err != nil {
+		t.Error(err)
+	}
+}
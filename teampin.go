@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	pinAttempts = flag.Int("pin-attempts", 5, "wrong team PINs allowed per team within -pin-window before further tries are refused")
+	pinWindow   = flag.Duration("pin-window", time.Minute, "window over which wrong team PINs are counted")
+)
+
+// A team may have a captain PIN, set at registration or with "team pin".
+// Privileged team actions, those behind requireCaptain or checkCaptain,
+// then need the PIN in X-Team-PIN or a captain token from
+// POST /teams/:name/login in X-Captain-Token. Operators pass with their
+// key. Answers stay open to every member, and a team without a PIN needs
+// nothing. PINs and tokens live in memory only.
+const (
+	teamPINHeader      = "X-Team-PIN"
+	captainTokenHeader = "X-Captain-Token"
+)
+
+type teamPIN struct {
+	hash [sha256.Size]byte
+	// tokens are the captain tokens issued with this PIN; a new PIN
+	// drops them.
+	tokens map[string]time.Time
+	// failures are the times of recent wrong PINs.
+	failures []time.Time
+}
+
+var (
+	teamPINsMutex sync.Mutex
+	// teamPINs is keyed by team name in lower case.
+	teamPINs = map[string]*teamPIN{}
+)
+
+var (
+	errWrongPIN = apiError(http.StatusForbidden, "wrong_pin", "wrong team PIN")
+	errNoPIN    = apiError(http.StatusBadRequest, "no_pin", "this team has no PIN, it needs no login")
+)
+
+// validatePIN checks a new PIN: 4 to 8 digits.
+func validatePIN(pin string) error {
+	if len(pin) < 4 || len(pin) > 8 {
+		return fmt.Errorf("a PIN has 4 to 8 digits")
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return fmt.Errorf("a PIN has digits only")
+		}
+	}
+	return nil
+}
+
+func hashPIN(team, pin string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(strings.ToLower(team) + "\x00" + pin))
+}
+
+// setTeamPIN sets or rotates the PIN of team, signing its captains out;
+// an empty pin removes it.
+func setTeamPIN(team, pin, origin string) error {
+	t, ok := findTeam(team)
+	if !ok {
+		return fmt.Errorf("unknown team: %s", team)
+	}
+	if pin != "" {
+		if err := validatePIN(pin); err != nil {
+			return err
+		}
+	}
+	key := strings.ToLower(t.Name)
+	teamPINsMutex.Lock()
+	_, had := teamPINs[key]
+	if pin == "" {
+		delete(teamPINs, key)
+	} else {
+		teamPINs[key] = &teamPIN{hash: hashPIN(t.Name, pin), tokens: map[string]time.Time{}}
+	}
+	teamPINsMutex.Unlock()
+
+	action := "team_pin_set"
+	switch {
+	case pin == "":
+		action = "team_pin_cleared"
+	case had:
+		action = "team_pin_rotated"
+	}
+	audit(action, origin, map[string]interface{}{"team": t.Name})
+	return nil
+}
+
+// forgetTeamPIN drops the PIN and tokens of a removed team.
+func forgetTeamPIN(team string) {
+	teamPINsMutex.Lock()
+	delete(teamPINs, strings.ToLower(team))
+	teamPINsMutex.Unlock()
+}
+
+func teamHasPIN(team string) bool {
+	teamPINsMutex.Lock()
+	defer teamPINsMutex.Unlock()
+	_, ok := teamPINs[strings.ToLower(team)]
+	return ok
+}
+
+// checkTeamPIN verifies pin for team, counting and auditing a wrong one.
+// Once -pin-attempts wrong PINs fall within -pin-window, every try is
+// refused until the oldest of them leaves it.
+func checkTeamPIN(team, pin, origin string) error {
+	now := clock.Now()
+	teamPINsMutex.Lock()
+	p, ok := teamPINs[strings.ToLower(team)]
+	if !ok {
+		teamPINsMutex.Unlock()
+		return errNoPIN
+	}
+	recent := p.failures[:0]
+	for _, at := range p.failures {
+		if now.Sub(at) < *pinWindow {
+			recent = append(recent, at)
+		}
+	}
+	p.failures = recent
+	if len(p.failures) >= *pinAttempts {
+		retry := p.failures[0].Add(*pinWindow).Sub(now)
+		teamPINsMutex.Unlock()
+		return apiError(http.StatusTooManyRequests, "pin_locked", "too many wrong PINs, try again later").
+			withDetail("retry_after", int(retry.Seconds())+1)
+	}
+	want := hashPIN(team, pin)
+	if subtle.ConstantTimeCompare(want[:], p.hash[:]) == 1 {
+		teamPINsMutex.Unlock()
+		return nil
+	}
+	p.failures = append(p.failures, now)
+	failures := len(p.failures)
+	teamPINsMutex.Unlock()
+
+	audit("team_pin_failed", origin, map[string]interface{}{"team": team, "failures": failures})
+	if failures == *pinAttempts {
+		notify(SeverityWarning, "teams", "Team %s: %d wrong PINs, refusing more for %s", team, failures, *pinWindow)
+	}
+	return errWrongPIN
+}
+
+// loginCaptain checks pin and issues a captain token for team.
+func loginCaptain(team, pin, origin string) (string, error) {
+	if err := checkTeamPIN(team, pin, origin); err != nil {
+		return "", err
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	teamPINsMutex.Lock()
+	if p, ok := teamPINs[strings.ToLower(team)]; ok {
+		p.tokens[token] = clock.Now()
+	}
+	teamPINsMutex.Unlock()
+	audit("team_login", origin, map[string]interface{}{"team": team})
+	return token, nil
+}
+
+func captainTokenValid(team, token string) bool {
+	if token == "" {
+		return false
+	}
+	teamPINsMutex.Lock()
+	defer teamPINsMutex.Unlock()
+	p, ok := teamPINs[strings.ToLower(team)]
+	if !ok {
+		return false
+	}
+	_, ok = p.tokens[token]
+	return ok
+}
+
+// captainTokenTeam is the team token was issued to.
+func captainTokenTeam(token string) (Team, bool) {
+	if token == "" {
+		return Team{}, false
+	}
+	teamPINsMutex.Lock()
+	name := ""
+	for key, p := range teamPINs {
+		if _, ok := p.tokens[token]; ok {
+			name = key
+		}
+	}
+	teamPINsMutex.Unlock()
+	if name == "" {
+		return Team{}, false
+	}
+	return findTeam(name)
+}
+
+// requireCaptain guards a privileged action of the team in :name.
+func requireCaptain(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		t, ok := findTeam(c.Param("name"))
+		if !ok {
+			return notFound("team not found")
+		}
+		if err := checkCaptain(c, t.Name); err != nil {
+			return err
+		}
+		return next(c)
+	}
+}
+
+// checkCaptain lets a request act as the captain of team, for routes that
+// name the team in the body rather than the path.
+func checkCaptain(c echo.Context, team string) error {
+	if !teamHasPIN(team) {
+		return nil
+	}
+	if _, ok := keyOperator(requestAPIKey(c)); ok {
+		return nil
+	}
+	if captainTokenValid(team, c.Request().Header.Get(captainTokenHeader)) {
+		return nil
+	}
+	pin := c.Request().Header.Get(teamPINHeader)
+	if pin == "" {
+		return apiError(http.StatusUnauthorized, "captain_required", "this action needs the team's PIN or a captain token")
+	}
+	if err := checkTeamPIN(team, pin, requestOrigin(c)); err != nil {
+		return pinRefused(c, err)
+	}
+	return nil
+}
+
+// CaptainLoginRequest is the body of POST /teams/:name/login.
+type CaptainLoginRequest struct {
+	PIN string `json:"pin"`
+}
+
+// CaptainLogin is the answer of a login.
+type CaptainLogin struct {
+	Team  string `json:"team"`
+	Token string `json:"token"`
+}
+
+func postTeamLogin(c echo.Context) error {
+	req := new(CaptainLoginRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	t, ok := findTeam(c.Param("name"))
+	if !ok {
+		return notFound("team not found")
+	}
+	token, err := loginCaptain(t.Name, req.PIN, requestOrigin(c))
+	if err != nil {
+		return pinRefused(c, err)
+	}
+	return c.JSON(http.StatusOK, CaptainLogin{Team: t.Name, Token: token})
+}
+
+// pinRefused is err, with Retry-After set when the team is locked out.
+func pinRefused(c echo.Context, err error) error {
+	if apiErr, ok := err.(*APIError); ok && apiErr.Code == "pin_locked" {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(apiErr.Details["retry_after"].(int)))
+	}
+	return err
+}
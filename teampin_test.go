@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestCaptainPIN guards a team's lifeline with its PIN: by header, by a
+// captain token, after a rotation and through a lockout.
+func TestCaptainPIN(t *testing.T) {
+	s := StartTestServer(t)
+	defer forgetTeamPIN("Sovy")
+	defer func(n int, d time.Duration) { *pinAttempts, *pinWindow = n, d }(*pinAttempts, *pinWindow)
+	*pinAttempts, *pinWindow = 2, time.Minute
+
+	if status := s.Do(t, http.MethodPost, "/v2/teams", map[string]string{"name": "Sovy", "pin": "12ab"}, nil); status != http.StatusBadRequest {
+		t.Errorf("a PIN with letters: status %d", status)
+	}
+	if status := s.Do(t, http.MethodPost, "/v2/teams", map[string]string{"name": "Sovy", "pin": "1234"}, nil); status != http.StatusCreated {
+		t.Fatalf("registering: status %d", status)
+	}
+	if err := addTeam(Team{Name: "Líšky"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// captain sends a request without the operator key.
+	type answer struct {
+		Error APIError `json:"error"`
+		Token string   `json:"token"`
+	}
+	captain := func(method, path string, body interface{}, headers map[string]string) (*http.Response, answer) {
+		t.Helper()
+		req := s.NewRequest(t, method, path, body)
+		req.Header.Del("X-API-Key")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		var res answer
+		return s.Send(t, req, &res), res
+	}
+	lifeline := func(team string, headers map[string]string) (int, string) {
+		t.Helper()
+		resp, res := captain(http.MethodPost, "/v2/teams/"+team+"/lifeline", nil, headers)
+		return resp.StatusCode, res.Error.Code
+	}
+	if status, code := lifeline("Sovy", nil); status != http.StatusUnauthorized || code != "captain_required" {
+		t.Errorf("without the PIN: status %d, %s", status, code)
+	}
+	if status, _ := lifeline("Sovy", map[string]string{teamPINHeader: "1234"}); status != http.StatusOK {
+		t.Errorf("with the PIN: status %d", status)
+	}
+	if status, _ := lifeline("Líšky", nil); status != http.StatusOK {
+		t.Errorf("a team without a PIN: status %d", status)
+	}
+	if status := s.Do(t, http.MethodPost, "/v2/teams/Sovy/lifeline", nil, nil); status != http.StatusOK {
+		t.Errorf("an operator: status %d", status)
+	}
+	if team, _ := findTeam("Sovy"); team.LifelinesUsed != 2 {
+		t.Errorf("lifelines used %d", team.LifelinesUsed)
+	}
+
+	login := func(pin string) (*http.Response, answer) {
+		t.Helper()
+		return captain(http.MethodPost, "/v2/teams/Sovy/login", map[string]string{"pin": pin}, nil)
+	}
+	resp, res := login("1234")
+	token := res.Token
+	if resp.StatusCode != http.StatusOK || token == "" {
+		t.Fatalf("login: status %d, %+v", resp.StatusCode, res)
+	}
+	if status, _ := lifeline("Sovy", map[string]string{captainTokenHeader: token}); status != http.StatusOK {
+		t.Errorf("with the token: status %d", status)
+	}
+	if resp, res := captain(http.MethodPost, "/v2/teams/Líšky/login", map[string]string{"pin": "1234"}, nil); resp.StatusCode != http.StatusBadRequest || res.Error.Code != "no_pin" {
+		t.Errorf("logging in without a PIN: status %d, %+v", resp.StatusCode, res.Error)
+	}
+
+	// A new PIN signs the captains out.
+	if err := cliCommand(t, "team pin Sovy 5678"); err != nil {
+		t.Fatal(err)
+	}
+	lastAudit(t, "team_pin_rotated")
+	if status, _ := lifeline("Sovy", map[string]string{captainTokenHeader: token}); status != http.StatusUnauthorized {
+		t.Errorf("with the old token: status %d", status)
+	}
+
+	// Two wrong PINs lock the team out for the window, the right one too.
+	for i := 0; i < 2; i++ {
+		if resp, res := login("1234"); resp.StatusCode != http.StatusForbidden || res.Error.Code != "wrong_pin" {
+			t.Errorf("wrong PIN: status %d, %+v", resp.StatusCode, res.Error)
+		}
+	}
+	if e := lastAudit(t, "team_pin_failed"); e.Details["team"] != "Sovy" || e.Details["failures"] != 2 {
+		t.Errorf("audited as %+v", e)
+	}
+	AdvanceClock(t, 20*time.Second)
+	resp, res = login("5678")
+	if resp.StatusCode != http.StatusTooManyRequests || res.Error.Code != "pin_locked" || resp.Header.Get("Retry-After") != "41" {
+		t.Errorf("locked out: status %d, Retry-After %q, %+v", resp.StatusCode, resp.Header.Get("Retry-After"), res.Error)
+	}
+	if status, code := lifeline("Sovy", map[string]string{teamPINHeader: "5678"}); status != http.StatusTooManyRequests || code != "pin_locked" {
+		t.Errorf("the PIN header locked out: status %d, %s", status, code)
+	}
+	AdvanceClock(t, 40*time.Second)
+	if resp, res := login("5678"); resp.StatusCode != http.StatusOK {
+		t.Errorf("after the window: status %d, %+v", resp.StatusCode, res.Error)
+	}
+
+	if err := cliCommand(t, "team pin Sovy off"); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := lifeline("Sovy", nil); status != http.StatusOK {
+		t.Errorf("with the PIN removed: status %d", status)
+	}
+}
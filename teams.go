@@ -0,0 +1,425 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+const maxShortNameLen = 4
+
+// Team represents a registered team and the branding the overlay uses for it.
+type Team struct {
+	Name      string `json:"name"`
+	ShortName string `json:"short_name"`
+	Color     string `json:"color"`
+	LogoURL   string `json:"logo_url,omitempty"`
+
+	Score         int           `json:"score"`
+	LifelinesUsed int           `json:"lifelines_used"`
+	AnswerTime    time.Duration `json:"answer_time"`
+}
+
+// FieldError describes why a single request field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects field-level problems found while validating input.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) add(field, code, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: message})
+}
+
+var (
+	teams      []Team
+	teamsMutex sync.RWMutex
+
+	hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+)
+
+// validateTeam checks t against itself and the already registered teams. It
+// must be called with teamsMutex held.
+func validateTeam(t Team) error {
+	verr := &ValidationError{}
+	if strings.TrimSpace(t.Name) == "" {
+		verr.add("name", "required", "name is required")
+	}
+	if !hexColorPattern.MatchString(t.Color) {
+		verr.add("color", "invalid_hex", "color must be a hex value like #ff0000")
+	}
+	if t.ShortName == "" {
+		verr.add("short_name", "required", "short_name is required")
+	} else if utf8.RuneCountInString(t.ShortName) > maxShortNameLen {
+		verr.add("short_name", "too_long", "short_name must be at most 4 characters")
+	}
+	if t.LogoURL != "" {
+		u, err := url.Parse(t.LogoURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			verr.add("logo_url", "invalid_url", "logo_url must be an http(s) URL")
+		}
+	}
+	for _, other := range teams {
+		if strings.EqualFold(other.Name, t.Name) {
+			verr.add("name", "duplicate", "a team with this name already exists")
+		}
+		if t.ShortName != "" && strings.EqualFold(other.ShortName, t.ShortName) {
+			verr.add("short_name", "duplicate", "short_name is already used by "+other.Name)
+		}
+	}
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// defaultShortName derives a short name from the first letters of the team name.
+func defaultShortName(name string) string {
+	runes := []rune(strings.ToUpper(strings.ReplaceAll(name, " ", "")))
+	if len(runes) > maxShortNameLen {
+		runes = runes[:maxShortNameLen]
+	}
+	return string(runes)
+}
+
+func addTeam(t Team) error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.ShortName == "" {
+		t.ShortName = defaultShortName(t.Name)
+	}
+	if t.Color == "" {
+		t.Color = "#ffffff"
+	}
+
+	teamsMutex.Lock()
+	err := validateTeam(t)
+	if err == nil {
+		teams = append(teams, t)
+	}
+	teamsMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	rebuildScoreboard()
+	return nil
+}
+
+func removeTeam(name string) bool {
+	teamsMutex.Lock()
+	removed := false
+	for i, t := range teams {
+		if strings.EqualFold(t.Name, name) {
+			teams = append(teams[:i], teams[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	teamsMutex.Unlock()
+	if removed {
+		forgetTeamPIN(name)
+		rebuildScoreboard()
+	}
+	return removed
+}
+
+func findTeam(name string) (Team, bool) {
+	teamsMutex.RLock()
+	defer teamsMutex.RUnlock()
+	for _, t := range teams {
+		if strings.EqualFold(t.Name, name) || strings.EqualFold(t.ShortName, name) {
+			return t, true
+		}
+	}
+	return Team{}, false
+}
+
+// Score change causes, recorded with each change for the timeline.
+const (
+	causeManual = "manual"
+	causeAward  = "award"
+	// causeRevert undoes a manual change, see adjustments.go.
+	causeRevert = "revert"
+)
+
+// adjustScore adds delta to the team's score and returns the new score.
+func adjustScore(name string, delta int, cause, origin string) (int, error) {
+	return adjustScoreNoting(name, delta, cause, origin, nil)
+}
+
+// adjustScoreNoting is adjustScore with extra details for the audit entry.
+func adjustScoreNoting(name string, delta int, cause, origin string, extra map[string]interface{}) (int, error) {
+	if ceremonyActive() {
+		return 0, fmt.Errorf("scoring is frozen during the results ceremony")
+	}
+	score, err := applyScore(name, delta, cause, origin, extra)
+	if err != nil {
+		return 0, err
+	}
+	rebuildScoreboard()
+	return score, nil
+}
+
+func applyScore(name string, delta int, cause, origin string, extra map[string]interface{}) (int, error) {
+	questionID := liveHistoryID()
+	teamsMutex.Lock()
+	defer teamsMutex.Unlock()
+	for i := range teams {
+		if strings.EqualFold(teams[i].Name, name) || strings.EqualFold(teams[i].ShortName, name) {
+			teams[i].Score += delta
+			details := map[string]interface{}{
+				"team":  teams[i].Name,
+				"delta": delta,
+				"score": teams[i].Score,
+				"cause": cause,
+			}
+			if questionID != 0 {
+				details["question_id"] = questionID
+			}
+			for k, v := range extra {
+				details[k] = v
+			}
+			audit("score", origin, details)
+			return teams[i].Score, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown team: %s", name)
+}
+
+func listTeams() []Team {
+	teamsMutex.RLock()
+	defer teamsMutex.RUnlock()
+	return append([]Team(nil), teams...)
+}
+
+func validationError(err error) error {
+	if verr, ok := err.(*ValidationError); ok {
+		return apiError(http.StatusBadRequest, "validation_failed", verr.Error()).withDetail("fields", verr.Fields)
+	}
+	return apiError(http.StatusBadRequest, "validation_failed", err.Error())
+}
+
+func getTeams(c echo.Context) error {
+	return c.JSON(http.StatusOK, listTeams())
+}
+
+// TeamRegistration is the body of POST /teams: the team and, optionally,
+// its captain PIN, see teampin.go.
+type TeamRegistration struct {
+	Team
+	PIN string `json:"pin"`
+}
+
+func postTeam(c echo.Context) error {
+	req := new(TeamRegistration)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if req.PIN != "" {
+		if err := validatePIN(req.PIN); err != nil {
+			return validationError(&ValidationError{Fields: []FieldError{{Field: "pin", Code: "invalid", Message: err.Error()}}})
+		}
+	}
+	if err := addTeam(req.Team); err != nil {
+		return validationError(err)
+	}
+	created, _ := findTeam(req.Name)
+	if req.PIN != "" {
+		setTeamPIN(created.Name, req.PIN, requestOrigin(c))
+	}
+	return c.JSON(http.StatusCreated, created)
+}
+
+func deleteTeam(c echo.Context) error {
+	if !removeTeam(c.Param("name")) {
+		return notFound("team not found")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ScoreRequest is the body accepted by POST /teams/:name/score.
+type ScoreRequest struct {
+	Delta  int    `json:"delta"`
+	Reason string `json:"reason"`
+}
+
+func postScore(c echo.Context) error {
+	req := new(ScoreRequest)
+	if err := bindJSON(c, req); err != nil {
+		return bindError(err)
+	}
+	if _, err := adjustManually(c.Param("name"), req.Delta, req.Reason, requestOrigin(c)); err != nil {
+		return adjustmentError(err)
+	}
+	t, _ := findTeam(c.Param("name"))
+	return c.JSON(http.StatusOK, t)
+}
+
+// useLifeline counts a lifeline used by the team.
+func useLifeline(name, origin string) (Team, error) {
+	teamsMutex.Lock()
+	var used *Team
+	for i := range teams {
+		if strings.EqualFold(teams[i].Name, name) || strings.EqualFold(teams[i].ShortName, name) {
+			teams[i].LifelinesUsed++
+			used = &teams[i]
+			break
+		}
+	}
+	var t Team
+	if used != nil {
+		t = *used
+	}
+	teamsMutex.Unlock()
+	if used == nil {
+		return Team{}, fmt.Errorf("unknown team: %s", name)
+	}
+	audit("lifeline", origin, map[string]interface{}{"team": t.Name, "lifelines_used": t.LifelinesUsed})
+	rebuildScoreboard()
+	return t, nil
+}
+
+// postLifeline uses a lifeline, a privileged team action.
+func postLifeline(c echo.Context) error {
+	t, err := useLifeline(c.Param("name"), requestOrigin(c))
+	if err != nil {
+		return notFound(err.Error())
+	}
+	return c.JSON(http.StatusOK, t)
+}
+
+// handleScoreCommand runs "score <team> <+n|-n> [reason]", asking for the
+// reason when it is left out, and "score log|revert".
+func handleScoreCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	if len(args) == 1 && args[0] == "log" {
+		printAdjustments()
+		return nil
+	}
+	if len(args) >= 2 && args[0] == "revert" {
+		id, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+		if err != nil {
+			return errors.New("Usage: score revert <id> [reason]")
+		}
+		a, err := revertAdjustment(id, strings.Trim(strings.Join(args[2:], " "), `"`), origin)
+		if err != nil {
+			return err
+		}
+		t, _ := findTeam(a.Team)
+		success.Printf("Adjustment #%d reverted by #%d, %s now has %d points\n", id, a.ID, t.Name, t.Score)
+		return nil
+	}
+	if len(args) < 2 {
+		return errors.New("Usage: score <team> <+n|-n> [reason] | score log | score revert <id> [reason]")
+	}
+	delta, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errors.New("Score change must be an integer like +2 or -1")
+	}
+	reason := strings.Trim(strings.Join(args[2:], " "), `"`)
+	if strings.TrimSpace(reason) == "" {
+		reason, _ = promptLine("Reason for the change (e.g. judges overturned q12):")
+		if strings.TrimSpace(reason) == "" {
+			return errors.New("Score change cancelled, manual changes need a reason")
+		}
+	}
+	a, err := adjustManually(args[0], delta, reason, origin)
+	if err != nil {
+		return err
+	}
+	t, _ := findTeam(a.Team)
+	success.Printf("%s now has %d points (adjustment #%d)\n", t.Name, t.Score, a.ID)
+	return nil
+}
+
+func handleTeamCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+
+	if len(args) == 0 {
+		return errors.New("Usage: team add <name> [#color] [short] | team rm <name> | team list | team pin <name> <pin>|off")
+	}
+	switch args[0] {
+	case "add":
+		if len(args) < 2 || len(args) > 4 {
+			return errors.New("Usage: team add <name> [#color] [short]")
+		}
+		t := Team{Name: args[1]}
+		if len(args) > 2 {
+			t.Color = args[2]
+		}
+		if len(args) > 3 {
+			t.ShortName = args[3]
+		}
+		if err := addTeam(t); err != nil {
+			return fmt.Errorf("Invalid team: %v", err)
+		}
+		success.Printf("Team %s added\n", t.Name)
+	case "rm":
+		if len(args) != 2 {
+			return errors.New("Usage: team rm <name>")
+		}
+		if !confirmCLI("team_remove", teamRemovalSummary(args[1]), args[1]) {
+			return errors.New("Team removal cancelled")
+		}
+		if !removeTeam(args[1]) {
+			return fmt.Errorf("Unknown team: %s", args[1])
+		}
+		success.Printf("Team %s removed\n", args[1])
+	case "list":
+		list := listTeams()
+		if len(list) == 0 {
+			info.Println("No teams registered")
+			return nil
+		}
+		for _, t := range list {
+			pin := ""
+			if teamHasPIN(t.Name) {
+				pin = "PIN"
+			}
+			info.Printf("  %-4s %-20s %s %4d %-3s %s\n", t.ShortName, t.Name, t.Color, t.Score, pin, t.LogoURL)
+		}
+	case "pin":
+		if len(args) != 3 {
+			return errors.New("Usage: team pin <name> <pin>|off")
+		}
+		pin := args[2]
+		if pin == "off" {
+			pin = ""
+		}
+		if err := setTeamPIN(args[1], pin, origin); err != nil {
+			return err
+		}
+		if pin == "" {
+			success.Printf("Team %s no longer has a PIN\n", args[1])
+		} else {
+			success.Printf("PIN of team %s set, its captains must log in again\n", args[1])
+		}
+	default:
+		return fmt.Errorf("Unknown team command: %s", args[0])
+	}
+	return nil
+}
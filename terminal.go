@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/chzyer/readline"
+	"github.com/fatih/color"
+)
+
+var historyPath = flag.String("history", defaultHistoryPath(), "readline history file (empty disables history)")
+
+const (
+	promptNormal = "\033[32m> \033[0m"
+	promptAlarm  = "\033[31;1m! > \033[0m"
+)
+
+// activeReadline is the running prompt, if any, so background checks can
+// recolor it.
+var (
+	activeReadline *readline.Instance
+	promptAlarmOn  bool
+)
+
+// cliOutput is where messages from background goroutines are written. While
+// the readline prompt is up it is the prompt's own writer, which redraws the
+// prompt and the half-typed line around the message.
+var (
+	cliOutput      io.Writer = os.Stdout
+	cliOutputMutex sync.Mutex
+)
+
+// setPromptAlarm turns the prompt red while something needs attention.
+func setPromptAlarm(on bool) {
+	cliOutputMutex.Lock()
+	defer cliOutputMutex.Unlock()
+	if on == promptAlarmOn {
+		return
+	}
+	promptAlarmOn = on
+	if activeReadline == nil || hotkeysOn {
+		return
+	}
+	if on {
+		activeReadline.SetPrompt(promptAlarm)
+	} else {
+		activeReadline.SetPrompt(promptNormal)
+	}
+	activeReadline.Refresh()
+}
+
+func setActiveReadline(rl *readline.Instance) {
+	cliOutputMutex.Lock()
+	defer cliOutputMutex.Unlock()
+	activeReadline = rl
+	if rl != nil {
+		cliOutput = rl.Stdout()
+	} else {
+		cliOutput = os.Stdout
+	}
+}
+
+// atTerminal reports whether a command run as origin has the operator at
+// the terminal to answer a prompt. Commands from elsewhere take flags
+// instead.
+func atTerminal(origin string) bool {
+	return origin == "cli"
+}
+
+// promptYesNo asks the operator a question from inside a command and
+// reports whether they answered yes. Without any input to read it says no.
+func promptYesNo(question string) bool {
+	answer, _ := promptLine(question + " [y/N]")
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// promptLine reads one line of input from inside a command. It reports
+// false when there is no input to read.
+func promptLine(question string) (string, bool) {
+	cliOutputMutex.Lock()
+	rl := activeReadline
+	prompt := promptNormal
+	if promptAlarmOn {
+		prompt = promptAlarm
+	}
+	cliOutputMutex.Unlock()
+
+	switch {
+	case rl != nil:
+		rl.SetPrompt(question + " ")
+		line, err := rl.Readline()
+		rl.SetPrompt(prompt)
+		if err != nil {
+			return "", false
+		}
+		return line, true
+	case scannerInput != nil:
+		fmt.Printf("%s ", question)
+		if !scannerInput.Scan() {
+			return "", false
+		}
+		return scannerInput.Text(), true
+	}
+	return "", false
+}
+
+// asyncPrintf prints a message from outside the command loop.
+func asyncPrintf(c *color.Color, format string, args ...interface{}) {
+	cliOutputMutex.Lock()
+	defer cliOutputMutex.Unlock()
+	c.Fprintf(cliOutput, format, args...)
+}
+
+// defaultHistoryPath puts the history in the user cache dir so it survives
+// reboots, falling back to the temp dir when there is no cache dir.
+func defaultHistoryPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "stuskova", "readline_history")
+}
+
+// usableHistoryPath returns path if the history file can be created and
+// written, or "" otherwise.
+func usableHistoryPath(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return path, nil
+}
+
+// newReadline sets up the interactive prompt. It returns a nil instance
+// without error when stdin is not a terminal, in which case the caller
+// should fall back to the line scanner. History problems only disable
+// history instead of failing.
+func newReadline(completer readline.PrefixCompleterInterface) (*readline.Instance, error) {
+	if !readline.DefaultIsTerminal() {
+		return nil, nil
+	}
+
+	history, err := usableHistoryPath(*historyPath)
+	if err != nil {
+		color.New(color.FgYellow).Printf("Warning: command history disabled, %v\n", err)
+	}
+	cfg := &readline.Config{
+		Prompt:       promptNormal,
+		AutoComplete: &MultiCommandCompleter{completer},
+		HistoryFile:  history,
+		// The loop saves each line itself, redacted.
+		DisableAutoSaveHistory: true,
+		InterruptPrompt:        "^C",
+		EOFPrompt:              "exit",
+
+		FuncFilterInputRune: hotkeyFilter,
+	}
+	rl, err := readline.NewEx(cfg)
+	if err != nil && cfg.HistoryFile != "" {
+		color.New(color.FgYellow).Printf("Warning: readline failed with history (%v), retrying without it\n", err)
+		cfg.HistoryFile = ""
+		rl, err = readline.NewEx(cfg)
+	}
+	return rl, err
+}
+
+// scannerInput is the non-interactive input, so prompts can read the answer
+// from the next line.
+var scannerInput *bufio.Scanner
+
+// runScannerCLI reads commands line by line from a non-interactive stdin,
+// e.g. when the server runs under a service manager or with piped input.
+func runScannerCLI() {
+	color.New(color.FgYellow).Println("Server started. Reading commands from non-interactive input.")
+	scanner := bufio.NewScanner(os.Stdin)
+	scannerInput = scanner
+	for scanner.Scan() {
+		runCommandLine(scanner.Text(), "cli")
+	}
+	if err := scanner.Err(); err != nil {
+		color.New(color.FgRed).Printf("Error reading input: %v\n", err)
+	}
+}
+
+// printHeadlessNote tells the operator the CLI is gone but the show can still
+// be driven over HTTP.
+func printHeadlessNote() {
+	fmt.Fprintf(os.Stderr, "The command line is unavailable, but the HTTP API keeps running on %s.\n", *listenAddr)
+	fmt.Fprintln(os.Stderr, "Control the show with POST /set-question, /pause and /resume, or restart in a working terminal.")
+}
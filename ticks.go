@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	tickInterval = time.Second
+	// tickLogEvery limits how often late ticks are logged.
+	tickLogEvery = 10 * time.Second
+)
+
+var tickLate = flag.Duration("tick-late", 50*time.Millisecond, "log countdown ticks that fire later than this after their second boundary")
+
+// tickSource wakes the countdown displays (kiosks, file export) when the
+// shown second changes. Ticks are scheduled against absolute deadlines on
+// the question's own second boundaries, so a slow wakeup doesn't push the
+// following ones back, and ticks missed while the process stalled are
+// coalesced into one instead of bursting.
+type tickSource struct {
+	mu        sync.Mutex
+	listeners map[chan struct{}]struct{}
+
+	maxLag  time.Duration
+	late    uint64
+	worst   time.Duration // largest lag since the last log line
+	lastLog time.Time
+	wake    chan struct{}
+}
+
+var ticks = &tickSource{listeners: map[chan struct{}]struct{}{}, wake: make(chan struct{}, 1)}
+
+// subscribe returns a channel that receives a tick on every boundary. It
+// holds at most one, so a listener that falls behind sees one tick, not a
+// backlog.
+func (t *tickSource) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	t.mu.Lock()
+	t.listeners[ch] = struct{}{}
+	t.mu.Unlock()
+	return ch
+}
+
+func (t *tickSource) unsubscribe(ch chan struct{}) {
+	t.mu.Lock()
+	delete(t.listeners, ch)
+	t.mu.Unlock()
+}
+
+// wakeTicks makes the scheduler re-read the question's boundaries, as after
+// the timer was changed.
+func wakeTicks() {
+	select {
+	case ticks.wake <- struct{}{}:
+	default:
+	}
+}
+
+// tickAnchor is a time the countdown's second boundaries are whole seconds
+// away from: its deadline, or its start when counting up. Without a running
+// clock the wall clock's seconds are used.
+func tickAnchor(q Question) time.Time {
+	if end, ok := readingDeadline(q); ok {
+		return end
+	}
+	if deadline, ok := countdownDeadline(q); ok {
+		return deadline
+	}
+	if q.CountUp && !q.Paused && !q.Stalled {
+		return q.StartTime
+	}
+	return time.Unix(0, 0)
+}
+
+// nextTick is the first boundary after now.
+func nextTick(anchor, now time.Time) time.Time {
+	off := now.Sub(anchor) % tickInterval
+	if off < 0 {
+		off += tickInterval
+	}
+	return now.Add(tickInterval - off)
+}
+
+// run is the scheduler loop.
+func (t *tickSource) run() {
+	for {
+		questionMutex.RLock()
+		anchor := tickAnchor(question)
+		stalled := question.Stalled
+		questionMutex.RUnlock()
+		if stalled {
+			// The shown time is frozen, so there is nothing to tick until
+			// the operator gives the question a new clock.
+			disarmTimer("tick")
+			<-t.wake
+			continue
+		}
+		at := nextTick(anchor, clock.Now())
+		armTimer("tick", at)
+
+		// Sleep until the deadline and re-check after waking, since timers
+		// may fire early or the boundary may have moved meanwhile.
+		woken := false
+		for !woken {
+			wait := at.Sub(clock.Now())
+			if wait <= 0 {
+				break
+			}
+			select {
+			case <-t.wake:
+				woken = true
+			case <-clock.After(wait):
+			}
+		}
+		if woken {
+			continue
+		}
+		t.fire(at, clock.Now())
+	}
+}
+
+// fire sends one tick for the boundary at and notes how late it is.
+func (t *tickSource) fire(at, now time.Time) {
+	lag := now.Sub(at)
+	t.mu.Lock()
+	if lag > t.maxLag {
+		t.maxLag = lag
+	}
+	logLine := ""
+	if lag > *tickLate {
+		t.late++
+		t.worst = max(t.worst, lag)
+		if now.Sub(t.lastLog) >= tickLogEvery {
+			logLine = fmt.Sprintf("Countdown tick late by %s (%d late so far)", t.worst.Round(time.Millisecond), t.late)
+			t.lastLog, t.worst = now, 0
+		}
+	}
+	for ch := range t.listeners {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	t.mu.Unlock()
+	if logLine != "" {
+		fmt.Fprintln(os.Stderr, logLine)
+	}
+}
+
+// TickStats is how punctual the countdown ticks have been.
+type TickStats struct {
+	MaxLag time.Duration `json:"max_lag"`
+	Late   uint64        `json:"late"`
+}
+
+func (t *tickSource) stats() TickStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return TickStats{MaxLag: t.maxLag, Late: t.late}
+}
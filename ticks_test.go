@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNextTick(t *testing.T) {
+	anchor := testEpoch.Add(30*time.Second + 250*time.Millisecond)
+	for _, tc := range []struct {
+		now, want time.Duration
+	}{
+		{0, 250 * time.Millisecond},
+		{250 * time.Millisecond, 1250 * time.Millisecond},
+		{900 * time.Millisecond, 1250 * time.Millisecond},
+		// Past the anchor, as when counting up.
+		{40*time.Second + 300*time.Millisecond, 41*time.Second + 250*time.Millisecond},
+	} {
+		if got := nextTick(anchor, testEpoch.Add(tc.now)).Sub(testEpoch); got != tc.want {
+			t.Errorf("at %s: next tick at %s, want %s", tc.now, got, tc.want)
+		}
+	}
+}
+
+func armedTick() time.Time {
+	debugMutex.Lock()
+	defer debugMutex.Unlock()
+	return armedTimers["tick"]
+}
+
+// TestTicksCoalesce drives the scheduler with a clock that wakes it late,
+// once by a little and once by several seconds, and checks that each wakeup
+// is one tick, that the next one is still on the question's second
+// boundary, and that the lag is counted.
+func TestTicksCoalesce(t *testing.T) {
+	s := StartTestServer(t)
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Tik tak", "type": "pomoc", "time_left": 30_000_000_000})
+	questionMutex.RLock()
+	start := question.StartTime
+	questionMutex.RUnlock()
+	eventually(t, "the first tick armed", func() bool { return armedTick().Equal(start.Add(time.Second)) })
+
+	ch := ticks.subscribe()
+	defer ticks.unsubscribe(ch)
+	before := ticks.stats()
+	for _, step := range []struct {
+		advance, next time.Duration
+	}{
+		{time.Second, 2 * time.Second},
+		{1500 * time.Millisecond, 3 * time.Second},
+		{5 * time.Second, 8 * time.Second},
+	} {
+		AdvanceClock(t, step.advance)
+		eventually(t, "the next tick armed", func() bool { return armedTick().Equal(start.Add(step.next)) })
+		select {
+		case <-ch:
+		default:
+			t.Fatalf("no tick after %s", step.advance)
+		}
+		select {
+		case <-ch:
+			t.Errorf("a burst of ticks after %s", step.advance)
+		default:
+		}
+	}
+	st := ticks.stats()
+	if st.Late != before.Late+2 || st.MaxLag < 4500*time.Millisecond {
+		t.Errorf("stats %+v, before %+v", st, before)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// tenthsThreshold is the remaining time below which countdowns show tenths.
+// Above it tenths stay zero so the payload doesn't change ten times a second.
+const tenthsThreshold = 10 * time.Second
+
+// TimeDisplay is the remaining (or elapsed) time split into display parts.
+type TimeDisplay struct {
+	Minutes int    `json:"minutes"`
+	Seconds int    `json:"seconds"`
+	Tenths  int    `json:"tenths"`
+	Text    string `json:"mm_ss"`
+}
+
+// formatTimeDisplay splits d for display. Countdowns under tenthsThreshold
+// carry tenths; everything else is whole seconds.
+func formatTimeDisplay(d time.Duration, countUp bool) TimeDisplay {
+	if d < 0 {
+		d = 0
+	}
+	total := int(d / time.Second)
+	td := TimeDisplay{
+		Minutes: total / 60,
+		Seconds: total % 60,
+	}
+	if !countUp && d < tenthsThreshold {
+		td.Tenths = int(d%time.Second) / int(100*time.Millisecond)
+	}
+	td.Text = fmt.Sprintf("%02d:%02d", td.Minutes, td.Seconds)
+	return td
+}
+
+// getTimeText serves the current time as plain mm:ss, for displays that
+// can only read text.
+func getTimeText(c echo.Context) error {
+	questionMutex.RLock()
+	q := publicQuestion(question)
+	questionMutex.RUnlock()
+	return c.String(http.StatusOK, q.TimeDisplay.Text)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatTimeDisplay(t *testing.T) {
+	for _, tc := range []struct {
+		d       time.Duration
+		countUp bool
+		want    TimeDisplay
+	}{
+		{0, false, TimeDisplay{0, 0, 0, "00:00"}},
+		{90 * time.Second, false, TimeDisplay{1, 30, 0, "01:30"}},
+		// Partial seconds are cut off, not rounded, so 00:00 means time is up.
+		{59999 * time.Millisecond, false, TimeDisplay{0, 59, 0, "00:59"}},
+		{10 * time.Second, false, TimeDisplay{0, 10, 0, "00:10"}},
+		{9990 * time.Millisecond, false, TimeDisplay{0, 9, 9, "00:09"}},
+		{4250 * time.Millisecond, false, TimeDisplay{0, 4, 2, "00:04"}},
+		{99 * time.Millisecond, false, TimeDisplay{0, 0, 0, "00:00"}},
+		// Counting up, and in overtime, there are never tenths.
+		{4250 * time.Millisecond, true, TimeDisplay{0, 4, 0, "00:04"}},
+		{125 * time.Minute, true, TimeDisplay{125, 0, 0, "125:00"}},
+		{-3 * time.Second, false, TimeDisplay{0, 0, 0, "00:00"}},
+		{-3 * time.Second, true, TimeDisplay{0, 0, 0, "00:00"}},
+	} {
+		if got := formatTimeDisplay(tc.d, tc.countUp); got != tc.want {
+			t.Errorf("%s, count up %v: %+v, want %+v", tc.d, tc.countUp, got, tc.want)
+		}
+	}
+}
+
+func TestDeriveDisplay(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		q    PublicQuestionView
+		want string
+	}{
+		{"countdown", PublicQuestionView{TimeLeft: 75 * time.Second}, "01:15"},
+		{"overtime", PublicQuestionView{Overtime: 5500 * time.Millisecond}, "+00:05"},
+		{"reading", PublicQuestionView{Reading: true, ReadingTime: 8 * time.Second, TimeLeft: 30 * time.Second}, "00:08"},
+	} {
+		deriveDisplay(&tc.q)
+		if got := tc.q.TimeDisplay; got.Text != tc.want {
+			t.Errorf("%s: %+v, want %s", tc.name, got, tc.want)
+		}
+	}
+}
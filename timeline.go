@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultTimelinePoints = 200
+	sparklineWidth        = 60
+)
+
+// TimelinePoint is a team's score after one change. The first point of a
+// series is the score before the first change and has no cause.
+type TimelinePoint struct {
+	T          time.Time `json:"t"`
+	Score      int       `json:"score"`
+	Delta      int       `json:"delta,omitempty"`
+	Cause      string    `json:"cause,omitempty"`
+	QuestionID int       `json:"question_id,omitempty"`
+}
+
+// TeamTimeline is one team's score series, oldest first.
+type TeamTimeline struct {
+	Team   string          `json:"team"`
+	Points []TimelinePoint `json:"points"`
+}
+
+// scoreChange is an audited score change, for the timelines.
+type scoreChange struct {
+	team  string
+	point TimelinePoint
+}
+
+// scoreChanges are the score changes of this run, oldest first. The audit
+// log drops old entries in a long show; this keeps only the few fields a
+// timeline needs, and resetGame clears it.
+var (
+	scoreChanges      []scoreChange
+	scoreChangesMutex sync.Mutex
+)
+
+// observeTimeline keeps each audited score change.
+func observeTimeline(e AuditEntry) {
+	if e.Action != "score" {
+		return
+	}
+	team, _ := e.Details["team"].(string)
+	p := TimelinePoint{
+		T:          e.Time,
+		Score:      detailInt(e.Details["score"]),
+		Delta:      detailInt(e.Details["delta"]),
+		QuestionID: detailInt(e.Details["question_id"]),
+	}
+	p.Cause, _ = e.Details["cause"].(string)
+	scoreChangesMutex.Lock()
+	scoreChanges = append(scoreChanges, scoreChange{team: team, point: p})
+	scoreChangesMutex.Unlock()
+}
+
+func resetTimelines() {
+	scoreChangesMutex.Lock()
+	scoreChanges = nil
+	scoreChangesMutex.Unlock()
+}
+
+// timelines builds every team's series from the score changes since the
+// current game started, in team order.
+func timelines() []TeamTimeline {
+	byTeam := map[string][]TimelinePoint{}
+	since := gameSince()
+	scoreChangesMutex.Lock()
+	for _, c := range scoreChanges {
+		p := c.point
+		if p.T.Before(since) {
+			continue
+		}
+		if len(byTeam[c.team]) == 0 {
+			byTeam[c.team] = append(byTeam[c.team], TimelinePoint{T: p.T, Score: p.Score - p.Delta})
+		}
+		byTeam[c.team] = append(byTeam[c.team], p)
+	}
+	scoreChangesMutex.Unlock()
+	now := clock.Now()
+	var out []TeamTimeline
+	for _, t := range listTeams() {
+		points := byTeam[t.Name]
+		if len(points) == 0 {
+			points = []TimelinePoint{{T: now, Score: t.Score}}
+		}
+		out = append(out, TeamTimeline{Team: t.Name, Points: points})
+	}
+	return out
+}
+
+func detailInt(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+func teamTimeline(name string) (TeamTimeline, bool) {
+	t, ok := findTeam(name)
+	if !ok {
+		return TeamTimeline{}, false
+	}
+	for _, tl := range timelines() {
+		if tl.Team == t.Name {
+			return tl, true
+		}
+	}
+	return TeamTimeline{Team: t.Name, Points: []TimelinePoint{{T: clock.Now(), Score: t.Score}}}, true
+}
+
+// downsample reduces points to at most n with largest-triangle-three-
+// buckets, which keeps the first and last point and the visible peaks.
+func downsample(points []TimelinePoint, n int) []TimelinePoint {
+	if n < 3 || len(points) <= n {
+		return points
+	}
+	x := func(i int) float64 { return float64(points[i].T.UnixNano()) }
+	y := func(i int) float64 { return float64(points[i].Score) }
+
+	out := []TimelinePoint{points[0]}
+	bucket := float64(len(points)-2) / float64(n-2)
+	prev := 0
+	for b := 0; b < n-2; b++ {
+		start := int(float64(b)*bucket) + 1
+		end := int(float64(b+1)*bucket) + 1
+
+		// The next bucket's average is the third corner of the triangle.
+		nextStart, nextEnd := end, int(float64(b+2)*bucket)+1
+		if nextEnd > len(points) {
+			nextEnd = len(points)
+		}
+		var ax, ay float64
+		for i := nextStart; i < nextEnd; i++ {
+			ax += x(i)
+			ay += y(i)
+		}
+		ax /= float64(nextEnd - nextStart)
+		ay /= float64(nextEnd - nextStart)
+
+		best, bestArea := start, -1.0
+		for i := start; i < end; i++ {
+			area := math.Abs((x(prev)-ax)*(y(i)-y(prev)) - (x(prev)-x(i))*(ay-y(prev)))
+			if area > bestArea {
+				best, bestArea = i, area
+			}
+		}
+		out = append(out, points[best])
+		prev = best
+	}
+	return append(out, points[len(points)-1])
+}
+
+// timelinePoints reads ?points, the most points per series.
+func timelinePoints(c echo.Context) (int, error) {
+	raw := c.QueryParam("points")
+	if raw == "" {
+		return defaultTimelinePoints, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 3 {
+		return 0, badRequest("points must be an integer of at least 3")
+	}
+	return n, nil
+}
+
+// getTeamTimeline returns every score change of one team. ?points limits
+// the series; without it the full series is returned.
+func getTeamTimeline(c echo.Context) error {
+	tl, ok := teamTimeline(c.Param("name"))
+	if !ok {
+		return notFound("team not found")
+	}
+	if c.QueryParam("points") != "" {
+		n, err := timelinePoints(c)
+		if err != nil {
+			return err
+		}
+		tl.Points = downsample(tl.Points, n)
+	}
+	return c.JSON(http.StatusOK, tl)
+}
+
+// ChartPoint is a TimelinePoint reduced to what a chart plots.
+type ChartPoint struct {
+	T     time.Time `json:"t"`
+	Score int       `json:"score"`
+}
+
+// getTimeline returns every team's series for charting, each downsampled
+// to at most ?points points.
+func getTimeline(c echo.Context) error {
+	n, err := timelinePoints(c)
+	if err != nil {
+		return err
+	}
+	out := map[string][]ChartPoint{}
+	for _, tl := range timelines() {
+		points := downsample(tl.Points, n)
+		chart := make([]ChartPoint, len(points))
+		for i, p := range points {
+			chart[i] = ChartPoint{T: p.T, Score: p.Score}
+		}
+		out[tl.Team] = chart
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws scores with one block character each.
+func sparkline(points []TimelinePoint) string {
+	lo, hi := points[0].Score, points[0].Score
+	for _, p := range points {
+		lo = min(lo, p.Score)
+		hi = max(hi, p.Score)
+	}
+	var b strings.Builder
+	for _, p := range points {
+		i := 0
+		if hi > lo {
+			i = (p.Score - lo) * (len(sparkBlocks) - 1) / (hi - lo)
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
+
+// handleTimelineCommand runs "timeline [team]".
+func handleTimelineCommand(args []string, origin string) error {
+	info := color.New(color.FgYellow)
+
+	var list []TeamTimeline
+	switch len(args) {
+	case 0:
+		list = timelines()
+	case 1:
+		tl, ok := teamTimeline(args[0])
+		if !ok {
+			return fmt.Errorf("Unknown team: %s", args[0])
+		}
+		list = []TeamTimeline{tl}
+	default:
+		return errors.New("Usage: timeline [team]")
+	}
+	if len(list) == 0 {
+		info.Println("No teams registered")
+		return nil
+	}
+	width := 0
+	for _, tl := range list {
+		width = max(width, len(tl.Team))
+	}
+	for _, tl := range list {
+		points := downsample(tl.Points, sparklineWidth)
+		last := tl.Points[len(tl.Points)-1]
+		info.Printf("  %-*s %s %d (%d changes)\n", width, tl.Team, sparkline(points), last.Score, len(tl.Points)-1)
+	}
+	if len(list) == 1 {
+		printTimelineChanges(list[0])
+	}
+	return nil
+}
+
+func printTimelineChanges(tl TeamTimeline) {
+	info := color.New(color.FgYellow)
+	for _, p := range tl.Points[1:] {
+		cause := p.Cause
+		if p.QuestionID != 0 {
+			cause = fmt.Sprintf("%s, question #%d", cause, p.QuestionID)
+		}
+		info.Printf("    %s %+d -> %d (%s)\n", p.T.Local().Format("15:04:05"), p.Delta, p.Score, cause)
+	}
+}
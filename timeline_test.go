@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestAggregatesOutlastAuditCap fills the audit log past its cap and checks
+// that the timelines, the scoreboard deltas and the pause totals still
+// count what scrolled out of it.
+func TestAggregatesOutlastAuditCap(t *testing.T) {
+	s := StartTestServer(t)
+	if err := addTeam(Team{Name: "Sovy"}); err != nil {
+		t.Fatal(err)
+	}
+	s.MustDo(t, http.MethodPost, "/pause", map[string]string{"reason": "tech"})
+	AdvanceClock(t, 90*time.Second)
+	s.MustDo(t, http.MethodPost, "/resume", nil)
+	if _, err := adjustManually("Sovy", 3, "bonus", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i <= maxAuditEntries; i++ {
+		audit("test_filler", "test", nil)
+	}
+	if len(auditEntries("score")) != 0 || len(auditEntries("resume")) != 0 {
+		t.Fatal("the audit log kept the early entries")
+	}
+
+	if _, err := adjustManually("Sovy", 2, "bonus", "test"); err != nil {
+		t.Fatal(err)
+	}
+	tl, ok := teamTimeline("Sovy")
+	if !ok || len(tl.Points) != 3 || tl.Points[0].Score != 0 || tl.Points[1].Score != 3 || tl.Points[2].Score != 5 {
+		t.Errorf("timeline %+v", tl.Points)
+	}
+
+	var board Scoreboard
+	s.Do(t, http.MethodGet, "/scoreboard", nil, &board)
+	if len(board.Entries) != 1 || board.Entries[0].Delta != 5 || len(board.Highlight) != 1 {
+		t.Errorf("scoreboard %+v", board)
+	}
+
+	var pauses []PauseTotal
+	s.Do(t, http.MethodGet, "/pauses", nil, &pauses)
+	if len(pauses) != 1 || pauses[0].Reason != "tech" || pauses[0].Count != 1 {
+		t.Errorf("pause totals %+v", pauses)
+	}
+	if got := pauseTotals(); len(got) != 1 || got[0].Duration != 90*time.Second {
+		t.Errorf("paused %+v", got)
+	}
+
+	// A new question starts the deltas over, a new game the timelines.
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Ďalšia otázka", "type": "pomoc", "time_left": 30_000_000_000})
+	s.Do(t, http.MethodGet, "/scoreboard", nil, &board)
+	if board.Entries[0].Delta != 0 || board.Entries[0].Score != 5 {
+		t.Errorf("scoreboard after a question %+v", board.Entries)
+	}
+	resetGame("test")
+	if tl, _ := teamTimeline("Sovy"); len(tl.Points) != 1 || tl.Points[0].Score != 0 {
+		t.Errorf("timeline after a reset %+v", tl.Points)
+	}
+}
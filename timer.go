@@ -0,0 +1,85 @@
+package main
+
+import "time"
+
+// The countdown model. A question's clock is StartTime plus TimeLeft: a
+// countdown runs out at StartTime+TimeLeft (see countdownDeadline), a
+// count-up has run since StartTime, and while paused or reading TimeLeft
+// holds the frozen value instead. Duration is the full length the countdown
+// was last set to.
+//
+// Every change to the live question either restarts the timer (setTimer,
+// restartTimer, startCountUp), clears it (clearTimer) or preserves it, and
+// the code says which. Remaining time is only ever worked out by
+// timeRemaining, so no path can combine a new TimeLeft with the StartTime
+// of an earlier question.
+
+// setTimer restarts the countdown of q at d from now.
+func setTimer(q *Question, d time.Duration) {
+	q.TimeLeft = d
+	q.Duration = d
+	q.StartTime = clock.Now()
+	q.CountUp = false
+	q.Stalled = false
+}
+
+// restartTimer runs the clock of q again from the start: the full Duration
+// for a countdown, zero for a count-up.
+func restartTimer(q *Question) {
+	if q.CountUp {
+		startCountUp(q)
+		return
+	}
+	setTimer(q, q.Duration)
+}
+
+// startCountUp makes q count up from zero.
+func startCountUp(q *Question) {
+	q.CountUp = true
+	q.TimeLeft = 0
+	q.StartTime = clock.Now()
+	q.Stalled = false
+}
+
+// clearTimer stops the clock for a waiting or end screen. Duration is kept
+// so a question type set afterwards can restart it.
+func clearTimer(q *Question) {
+	q.TimeLeft = 0
+	q.StartTime = clock.Now()
+	q.CountUp = false
+	q.Stalled = false
+	q.Reading = false
+	q.ReadingTime = 0
+}
+
+// resumeTimer carries on with the value freezeClock stored at the pause.
+func resumeTimer(q *Question) {
+	q.StartTime = clock.Now()
+	if q.CountUp && !q.Reading {
+		q.StartTime = q.StartTime.Add(-q.TimeLeft)
+	}
+}
+
+// clockElapsed is how long the clock of q has run since StartTime: zero
+// while paused, and never negative when the wall clock was set backwards.
+func clockElapsed(q Question, now time.Time) time.Duration {
+	if q.Paused {
+		return 0
+	}
+	elapsed := now.Sub(q.StartTime)
+	if elapsed < 0 {
+		return 0
+	}
+	return elapsed
+}
+
+// timeRemaining is what is left of the answer countdown of q at now. It is
+// negative once the countdown runs past zero; callers clamp it unless they
+// show overtime. It never exceeds TimeLeft, which the transitions above
+// keep at or below Duration.
+func timeRemaining(q Question, now time.Time) time.Duration {
+	if q.Reading {
+		return q.TimeLeft
+	}
+	return q.TimeLeft - clockElapsed(q, now)
+}
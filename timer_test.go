@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// timerOps are the paths that change the live clock, each with a random
+// argument.
+var timerOps = []struct {
+	name string
+	run  func(r *rand.Rand)
+}{
+	{"go live", func(r *rand.Rand) {
+		kinds := []string{"pomoc", "rozstrel", "waiting", "end"}
+		q := Question{Question: "Question", Type: kinds[r.Intn(len(kinds))], TimeLeft: randomDuration(r, 90*time.Second)}
+		if r.Intn(4) == 0 {
+			q.ReadingTime = randomDuration(r, 10*time.Second)
+		}
+		goLive(q, "question", "test")
+	}},
+	{"set time", func(r *rand.Rand) { setTimeLeft(randomDuration(r, 90*time.Second), true, "test") }},
+	{"adjust time", func(r *rand.Rand) { adjustTimeLeft(randomDuration(r, 60*time.Second)-30*time.Second, true, "test") }},
+	{"pause", func(*rand.Rand) { pauseQuestion("", "", "test") }},
+	{"resume", func(*rand.Rand) { resumeQuestion("test") }},
+	{"start answering", func(*rand.Rand) { startAnswering("test") }},
+	{"type", func(r *rand.Rand) {
+		kinds := []string{"pomoc", "rozstrel", "waiting", "end"}
+		runCommandLine("type "+kinds[r.Intn(len(kinds))], "test")
+	}},
+	{"tick", func(r *rand.Rand) { testServer.Clock.Advance(randomDuration(r, 40*time.Second)) }},
+	// The wall clock may be set back under a running question.
+	{"clock back", func(r *rand.Rand) { testServer.Clock.Advance(-randomDuration(r, 20*time.Second)) }},
+}
+
+func randomDuration(r *rand.Rand, max time.Duration) time.Duration {
+	return time.Duration(r.Int63n(int64(max/time.Millisecond)+1)) * time.Millisecond
+}
+
+// TestRemainingTimeInRange runs random sequences of clock changes and
+// checks after each step that the countdown shown is between zero and the
+// length it was last set to.
+func TestRemainingTimeInRange(t *testing.T) {
+	StartTestServer(t)
+	for seed := int64(1); seed <= 100; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		var steps []string
+		for i := 0; i < 40; i++ {
+			op := timerOps[r.Intn(len(timerOps))]
+			op.run(r)
+			steps = append(steps, op.name)
+
+			questionMutex.RLock()
+			q := question
+			view := publicQuestion(q)
+			questionMutex.RUnlock()
+			if q.CountUp {
+				continue
+			}
+			if view.TimeLeft < 0 || view.TimeLeft > q.Duration {
+				t.Fatalf("seed %d after %v: time_left %v outside [0, %v]", seed, steps, view.TimeLeft, q.Duration)
+			}
+			if view.Reading && (view.ReadingTime < 0 || view.ReadingTime > q.ReadingTime) {
+				t.Fatalf("seed %d after %v: reading_time %v outside [0, %v]", seed, steps, view.ReadingTime, q.ReadingTime)
+			}
+		}
+	}
+}
+
+func TestTimeRemainingIgnoresClockSetBack(t *testing.T) {
+	start := testEpoch
+	q := Question{TimeLeft: 30 * time.Second, Duration: 30 * time.Second, StartTime: start}
+	if got := timeRemaining(q, start.Add(-time.Hour)); got != 30*time.Second {
+		t.Errorf("clock set back: %v left, want 30s", got)
+	}
+	if got := timeRemaining(q, start.Add(40*time.Second)); got != -10*time.Second {
+		t.Errorf("past zero: %v left, want -10s", got)
+	}
+	q.Paused = true
+	if got := timeRemaining(q, start.Add(time.Hour)); got != 30*time.Second {
+		t.Errorf("paused: %v left, want 30s", got)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxSyncNonce bounds the nonce a client may have echoed back.
+const maxSyncNonce = 64
+
+// TimeSyncReply is the body of GET /time-sync, kept short for phones on a
+// busy network. Receive and Transmit are the server clock in Unix
+// nanoseconds when the request arrived and when the reply left. The nonce
+// and sample index come back as sent, so a client can match replies in a
+// burst and drop stale ones. See pkg/client for the offset calculation.
+type TimeSyncReply struct {
+	Nonce    string `json:"n,omitempty"`
+	Index    int    `json:"i,omitempty"`
+	Receive  int64  `json:"rx"`
+	Transmit int64  `json:"tx"`
+}
+
+// getTimeSync answers a clock sample. It takes no locks and has no rate
+// limit, so the samples of a burst are answered as fast as they come.
+func getTimeSync(c echo.Context) error {
+	received := clock.Now()
+	nonce := c.QueryParam("n")
+	if len(nonce) > maxSyncNonce {
+		return badRequest("n must be at most 64 characters")
+	}
+	reply := TimeSyncReply{Nonce: nonce, Receive: received.UnixNano()}
+	if raw := c.QueryParam("i"); raw != "" {
+		i, err := strconv.Atoi(raw)
+		if err != nil || i < 0 {
+			return badRequest("i must be a non-negative sample index")
+		}
+		reply.Index = i
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	reply.Transmit = clock.Now().UnixNano()
+	return c.JSON(http.StatusOK, reply)
+}
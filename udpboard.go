@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"net"
+	"time"
+)
+
+var (
+	udpBoardAddr   = flag.String("udp-scoreboard", "", "host:port to send the countdown to as UDP datagrams, e.g. 192.168.1.255:7000 for the LAN broadcast")
+	udpBoardFormat = flag.String("udp-format", "text", "UDP scoreboard datagram format: text or binary")
+	udpBoardRate   = flag.Int("udp-rate", 10, "most UDP scoreboard datagrams sent per second")
+)
+
+// The UDP scoreboard gets one datagram on every state change and on every
+// countdown tick, so a scoreboard that missed one is right again within a
+// second. Changes coming faster than -udp-rate are coalesced into the
+// latest. Two formats:
+//
+// text, one line of key=value pairs:
+//
+//	seconds=42 phase=running type=pomoc
+//
+// binary, 8 bytes:
+//
+//	0-1  "SQ"
+//	2    format version, 1
+//	3    phase: see udpPhases
+//	4    type: see udpTypes
+//	5    flags: bit 0 counting up, bit 1 paused
+//	6-7  seconds, big-endian, at most 65535
+//
+// seconds are those on show: left on a countdown, elapsed on a count-up,
+// past zero in overtime.
+
+// udpPhases and udpTypes are the binary codes, 0 for anything else.
+var (
+	udpPhases = map[string]byte{"waiting": 1, "running": 2, "counting_up": 3, "paused": 4, "reading": 5, "overtime": 6, "ended": 7}
+	udpTypes  = map[string]byte{"pomoc": 1, "rozstrel": 2, "waiting": 3, "end": 4}
+)
+
+// udpBoard sends the countdown to -udp-scoreboard.
+type udpBoard struct {
+	// conn is not connected, so a scoreboard that is off and answers with
+	// ICMP port unreachable doesn't make every other send fail.
+	conn   net.PacketConn
+	to     *net.UDPAddr
+	binary bool
+	gap    time.Duration
+
+	sentAt  time.Time
+	failing bool
+}
+
+// configureUDPBoard checks the UDP scoreboard flags and starts sending.
+func configureUDPBoard() error {
+	if *udpBoardAddr == "" {
+		return nil
+	}
+	if *udpBoardFormat != "text" && *udpBoardFormat != "binary" {
+		return fmt.Errorf("-udp-format must be text or binary")
+	}
+	if *udpBoardRate <= 0 {
+		return fmt.Errorf("-udp-rate must be positive")
+	}
+	to, err := net.ResolveUDPAddr("udp", *udpBoardAddr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return err
+	}
+	b := &udpBoard{conn: conn, to: to, binary: *udpBoardFormat == "binary", gap: time.Second / time.Duration(*udpBoardRate)}
+	sub := hub.subscribe("udp", false)
+	tick := ticks.subscribe()
+	workers.goWorker("udp scoreboard", func() { b.loop(sub, tick) })
+	return nil
+}
+
+func (b *udpBoard) loop(sub *subscriber, tick <-chan struct{}) {
+	var pending <-chan time.Time
+	b.send()
+	for {
+		select {
+		case <-sub.ch:
+		case <-tick:
+		case <-pending:
+			pending = nil
+			b.send()
+			continue
+		}
+		if pending != nil {
+			continue
+		}
+		if wait := b.gap - clock.Since(b.sentAt); wait > 0 {
+			pending = clock.After(wait)
+			continue
+		}
+		b.send()
+	}
+}
+
+// send writes the current state. A tick that shows nothing new is sent
+// again all the same, as the keepalive of the scoreboard.
+func (b *udpBoard) send() {
+	questionMutex.RLock()
+	q := publicQuestion(question)
+	questionMutex.RUnlock()
+
+	packet := udpPacket(q, b.binary)
+	b.sentAt = clock.Now()
+	if _, err := b.conn.WriteTo(packet, b.to); err != nil {
+		if !b.failing {
+			b.failing = true
+			notify(SeverityError, "udp", "UDP scoreboard %s failed: %v", *udpBoardAddr, err)
+		}
+		return
+	}
+	if b.failing {
+		b.failing = false
+		notify(SeverityInfo, "udp", "UDP scoreboard %s is working again", *udpBoardAddr)
+	}
+}
+
+// udpPacket is the datagram for q.
+func udpPacket(q PublicQuestionView, binaryFormat bool) []byte {
+	seconds := 0
+	if q.TimeDisplay != nil {
+		seconds = q.TimeDisplay.Minutes*60 + q.TimeDisplay.Seconds
+	}
+	if !binaryFormat {
+		return []byte(fmt.Sprintf("seconds=%d phase=%s type=%s\n", seconds, q.Phase, q.Type))
+	}
+	var flags byte
+	if q.CountUp {
+		flags |= 1
+	}
+	if q.Paused {
+		flags |= 2
+	}
+	p := []byte{'S', 'Q', 1, udpPhases[q.Phase], udpTypes[q.Type], flags, 0, 0}
+	binary.BigEndian.PutUint16(p[6:], uint16(min(seconds, 65535)))
+	return p
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUDPPacket(t *testing.T) {
+	running := PublicQuestionView{Type: "pomoc", Phase: "running", TimeDisplay: &TimeDisplay{Minutes: 1, Seconds: 12}}
+	if got := string(udpPacket(running, false)); got != "seconds=72 phase=running type=pomoc\n" {
+		t.Errorf("text %q", got)
+	}
+	if got := udpPacket(running, true); !bytes.Equal(got, []byte{'S', 'Q', 1, 2, 1, 0, 0, 72}) {
+		t.Errorf("binary % x", got)
+	}
+	paused := PublicQuestionView{Type: "rozstrel", Phase: "paused", Paused: true, CountUp: true, TimeDisplay: &TimeDisplay{Minutes: 2000}}
+	if got := udpPacket(paused, true); !bytes.Equal(got, []byte{'S', 'Q', 1, 4, 2, 3, 0xff, 0xff}) {
+		t.Errorf("binary paused count-up % x", got)
+	}
+	if got := udpPacket(PublicQuestionView{Type: "quiz", Phase: "new"}, true); !bytes.Equal(got, []byte{'S', 'Q', 1, 0, 0, 0, 0, 0}) {
+		t.Errorf("binary unknown % x", got)
+	}
+}
+
+// TestUDPBoard listens as a scoreboard and checks that changes within
+// -udp-rate come out as one datagram of the latest state, and that a
+// failing socket is notified once and its recovery too.
+func TestUDPBoard(t *testing.T) {
+	s := StartTestServer(t)
+	defer func(addr, format string, rate int) {
+		*udpBoardAddr, *udpBoardFormat, *udpBoardRate = addr, format, rate
+	}(*udpBoardAddr, *udpBoardFormat, *udpBoardRate)
+	*udpBoardAddr = "127.0.0.1:1"
+	for _, bad := range []struct {
+		format string
+		rate   int
+	}{{"json", 10}, {"text", 0}} {
+		*udpBoardFormat, *udpBoardRate = bad.format, bad.rate
+		if err := configureUDPBoard(); err == nil {
+			t.Errorf("-udp-format %s -udp-rate %d accepted", bad.format, bad.rate)
+		}
+	}
+
+	board, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer board.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	b := &udpBoard{conn: conn, to: board.LocalAddr().(*net.UDPAddr), gap: 100 * time.Millisecond}
+	sub := hub.subscribe("udp", false)
+	tick := ticks.subscribe()
+	go b.loop(sub, tick)
+
+	read := func() string {
+		t.Helper()
+		buf := make([]byte, 64)
+		board.SetReadDeadline(time.Now().Add(waitTimeout))
+		n, _, err := board.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+	if got := read(); !strings.HasPrefix(got, "seconds=") {
+		t.Fatalf("first datagram %q", got)
+	}
+
+	s.MustDo(t, http.MethodPost, "/set-question", map[string]interface{}{"question": "Hlavné mesto?", "type": "pomoc", "time_left": 30_000_000_000})
+	s.MustDo(t, http.MethodPost, "/pause", map[string]string{"reason": "tech"})
+	board.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, _, err := board.ReadFrom(make([]byte, 64)); err == nil {
+		t.Errorf("a datagram of %d bytes within the gap", n)
+	}
+	// The loop may not be waiting yet, so the clock goes on until it sends.
+	var got string
+	eventually(t, "the datagram after the gap", func() bool {
+		AdvanceClock(t, b.gap)
+		buf := make([]byte, 64)
+		board.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		n, _, err := board.ReadFrom(buf)
+		got = string(buf[:n])
+		return err == nil
+	})
+	if got != "seconds=30 phase=paused type=pomoc\n" {
+		t.Errorf("after the gap %q", got)
+	}
+	// The loop is left with nothing to wake it.
+	hub.unsubscribe(sub)
+	ticks.unsubscribe(tick)
+
+	// A failure is notified once, then the recovery.
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	failing := &udpBoard{conn: closed, to: board.LocalAddr().(*net.UDPAddr)}
+	notified := len(listNotifications())
+	failing.send()
+	failing.send()
+	failing.conn = conn
+	failing.send()
+	n := listNotifications()[notified:]
+	if len(n) != 2 || n[0].Severity != SeverityError || n[1].Severity != SeverityInfo || n[1].Category != "udp" {
+		t.Errorf("notifications %+v", n)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var undoDepth = flag.Int("undo-depth", 20, "how many operator commands undo can reverse")
+
+// undoOrigins are the origins whose commands can be undone: the operator's.
+// Timers, the watchdog and undo itself don't add to the stack.
+var undoOrigins = map[string]bool{"cli": true, "http": true, "ws": true}
+
+// undoOp is a command that can be reversed. undo reverses it and redo
+// applies it again; both run with origin "undo" or "redo", so what they do
+// is audited as such and never recorded as a new command. The undo or redo
+// itself is audited with the origin that asked for it.
+type undoOp struct {
+	ID          int       `json:"id"`
+	Action      string    `json:"action"`
+	Description string    `json:"description"`
+	Origin      string    `json:"origin"`
+	Time        time.Time `json:"time"`
+
+	undo func(origin string) error
+	redo func(origin string) error
+}
+
+// The stacks hold the newest operation last. A new command empties the
+// redo stack; a barrier, such as a restore or a new game, empties both,
+// since the operations before it no longer apply to the state.
+var (
+	undoMutex  sync.Mutex
+	undoStack  []undoOp
+	redoStack  []undoOp
+	nextUndoID = 1
+)
+
+// recordUndo adds op, done from origin, to the undo stack.
+func recordUndo(op undoOp, origin string) {
+	if transport, _ := splitOrigin(origin); !undoOrigins[transport] || *undoDepth <= 0 {
+		return
+	}
+	undoMutex.Lock()
+	defer undoMutex.Unlock()
+	op.ID = nextUndoID
+	op.Origin = origin
+	op.Time = clock.Now()
+	nextUndoID++
+	undoStack = append(undoStack, op)
+	if len(undoStack) > *undoDepth {
+		undoStack = undoStack[len(undoStack)-*undoDepth:]
+	}
+	redoStack = nil
+}
+
+// undoBarrier empties both stacks after an operation, done from origin,
+// that can't be undone.
+func undoBarrier(action, origin string) {
+	undoMutex.Lock()
+	n := len(undoStack) + len(redoStack)
+	undoStack, redoStack = nil, nil
+	undoMutex.Unlock()
+	if n > 0 {
+		audit("undo_barrier", origin, map[string]interface{}{"action": action, "dropped": n})
+	}
+}
+
+var (
+	errNothingToUndo = apiError(http.StatusConflict, "nothing_to_undo", "there is no command to undo")
+	errNothingToRedo = apiError(http.StatusConflict, "nothing_to_redo", "there is no undone command to redo")
+)
+
+// undoLast reverses the newest command, at the request of origin, and
+// moves it to the redo stack.
+func undoLast(origin string) (undoOp, error) {
+	return replayOp(&undoStack, &redoStack, "undo", origin, errNothingToUndo)
+}
+
+// redoLast applies the newest undone command again.
+func redoLast(origin string) (undoOp, error) {
+	return replayOp(&redoStack, &undoStack, "redo", origin, errNothingToRedo)
+}
+
+// replayOp runs the top of from in direction and moves it onto to. The
+// stack lock is not held while it runs, as the operation takes the state
+// locks. An operation that fails stays where it was.
+func replayOp(from, to *[]undoOp, direction, origin string, empty error) (undoOp, error) {
+	undoMutex.Lock()
+	if len(*from) == 0 {
+		undoMutex.Unlock()
+		return undoOp{}, empty
+	}
+	op := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	undoMutex.Unlock()
+
+	run := op.undo
+	if direction == "redo" {
+		run = op.redo
+	}
+	err := run(direction)
+
+	undoMutex.Lock()
+	if err != nil {
+		*from = append(*from, op)
+	} else {
+		*to = append(*to, op)
+	}
+	undoMutex.Unlock()
+	if err != nil {
+		return undoOp{}, err
+	}
+	audit(direction, origin, map[string]interface{}{"op": op.ID, "action": op.Action, "description": op.Description, "by": op.Origin})
+	return op, nil
+}
+
+// nextUndo is the command undo would reverse.
+func nextUndo() (undoOp, bool) {
+	undoMutex.Lock()
+	defer undoMutex.Unlock()
+	if len(undoStack) == 0 {
+		return undoOp{}, false
+	}
+	return undoStack[len(undoStack)-1], true
+}
+
+// frozenQuestion is q with its clock stored as of now, see freezeClock.
+func frozenQuestion(q Question) Question {
+	if !q.Paused {
+		freezeClock(&q)
+	}
+	return q
+}
+
+// swapQuestion puts q, frozen by frozenQuestion, back live with the time it
+// had left, and returns the question it replaced, frozen the same way. The
+// pause state stays as it is. The answers of the replaced question are
+// dropped as by any new question; those of q are not brought back.
+func swapQuestion(q Question, origin string) (Question, error) {
+	if ceremonyActive() {
+		return Question{}, errCeremonyActive
+	}
+	resetRound()
+	questionMutex.Lock()
+	replaced := frozenQuestion(question)
+	q.Paused, q.PauseReason, q.PauseMessage = question.Paused, question.PauseReason, question.PauseMessage
+	if !q.Paused {
+		resumeTimer(&q)
+	}
+	question = q
+	if !question.Reading {
+		startAnswerClock(clock.Now())
+	}
+	stateChanged(origin)
+	live := question
+	questionMutex.Unlock()
+	newQuestionStarted(live, origin)
+	go sendCurrentQuestion()
+	return replaced, nil
+}
+
+// recordQuestionUndo records that the question replaced, frozen when it
+// was, gave way to another. Undo and redo both swap the two.
+func recordQuestionUndo(replaced Question, text, origin string) {
+	other := replaced
+	swap := func(origin string) error {
+		prev, err := swapQuestion(other, origin)
+		if err == nil {
+			other = prev
+		}
+		return err
+	}
+	recordUndo(undoOp{Action: "question", Description: fmt.Sprintf("question %q (was %q)", text, replaced.Question), undo: swap, redo: swap}, origin)
+}
+
+func recordPauseUndo(reason, message, origin string) {
+	recordUndo(undoOp{
+		Action:      "pause",
+		Description: strings.TrimSpace("pause " + reason),
+		undo:        func(origin string) error { return resumeQuestion(origin) },
+		redo:        func(origin string) error { return pauseQuestion(reason, message, origin) },
+	}, origin)
+}
+
+func recordResumeUndo(reason, message, origin string) {
+	recordUndo(undoOp{
+		Action:      "resume",
+		Description: "resume",
+		undo:        func(origin string) error { return pauseQuestion(reason, message, origin) },
+		redo:        func(origin string) error { return resumeQuestion(origin) },
+	}, origin)
+}
+
+// recordTimeAdjustUndo records a change of delta to the time left. The
+// inverse may take all that is left, so it is forced.
+func recordTimeAdjustUndo(delta time.Duration, force bool, origin string) {
+	if delta == 0 {
+		return
+	}
+	recordUndo(undoOp{
+		Action:      "time_adjust",
+		Description: "adjust " + signedDuration(delta),
+		undo: func(origin string) error {
+			_, err := adjustTimeLeft(-delta, true, origin)
+			return err
+		},
+		redo: func(origin string) error {
+			_, err := adjustTimeLeft(delta, force, origin)
+			return err
+		},
+	}, origin)
+}
+
+func signedDuration(d time.Duration) string {
+	if d < 0 {
+		return d.String()
+	}
+	return "+" + d.String()
+}
+
+// recordScoreUndo records manual adjustment a. Undo reverts it in the
+// ledger; redo makes a new adjustment, which a later undo then reverts.
+func recordScoreUndo(a Adjustment, origin string) {
+	id := a.ID
+	recordUndo(undoOp{
+		Action:      "score",
+		Description: fmt.Sprintf("score %s %+d (%s)", a.Team, a.Delta, a.Reason),
+		undo: func(origin string) error {
+			_, err := revertAdjustment(id, fmt.Sprintf("undo of #%d", id), origin)
+			return err
+		},
+		redo: func(origin string) error {
+			b, err := adjustManually(a.Team, a.Delta, a.Reason, origin)
+			if err == nil {
+				id = b.ID
+			}
+			return err
+		},
+	}, origin)
+}
+
+// undoStatus is the status line for the next undo, empty with none.
+func undoStatus() string {
+	op, ok := nextUndo()
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("Undo: #%d %s (%s, %s ago)", op.ID, op.Description, op.Origin, clock.Since(op.Time).Round(time.Second))
+}
+
+// UndoStacks is the body of GET /undo, newest first.
+type UndoStacks struct {
+	Undo []undoOp `json:"undo"`
+	Redo []undoOp `json:"redo"`
+}
+
+func getUndo(c echo.Context) error {
+	undoMutex.Lock()
+	defer undoMutex.Unlock()
+	out := UndoStacks{Undo: []undoOp{}, Redo: []undoOp{}}
+	for i := len(undoStack) - 1; i >= 0; i-- {
+		out.Undo = append(out.Undo, undoStack[i])
+	}
+	for i := len(redoStack) - 1; i >= 0; i-- {
+		out.Redo = append(out.Redo, redoStack[i])
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+func postUndo(c echo.Context) error {
+	op, err := undoLast(requestOrigin(c))
+	if err != nil {
+		return undoError(err)
+	}
+	return c.JSON(http.StatusOK, op)
+}
+
+func postRedo(c echo.Context) error {
+	op, err := redoLast(requestOrigin(c))
+	if err != nil {
+		return undoError(err)
+	}
+	return c.JSON(http.StatusOK, op)
+}
+
+// undoError reports an operation that no longer applies, such as resuming
+// a question that isn't paused, as a conflict.
+func undoError(err error) error {
+	if _, ok := err.(*APIError); ok {
+		return err
+	}
+	return conflict(err.Error())
+}
+
+// handleUndoCommand runs "undo" and "redo".
+func handleUndoCommand(direction, origin string) error {
+	success := color.New(color.FgGreen)
+
+	run := undoLast
+	if direction == "redo" {
+		run = redoLast
+	}
+	op, err := run(origin)
+	if err != nil {
+		return err
+	}
+	if direction == "redo" {
+		success.Printf("Redone #%d: %s\n", op.ID, op.Description)
+		return nil
+	}
+	success.Printf("Undone #%d: %s\n", op.ID, op.Description)
+	return nil
+}
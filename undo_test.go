@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func lastAudit(t *testing.T, action string) AuditEntry {
+	t.Helper()
+	entries := auditEntries(action)
+	if len(entries) == 0 {
+		t.Fatalf("no %s in the audit log", action)
+	}
+	return entries[len(entries)-1]
+}
+
+// TestUndoAuditsRequester checks that an undo or redo is put down to
+// whoever asked for it, and the change it makes to "undo" or "redo".
+func TestUndoAuditsRequester(t *testing.T) {
+	s := StartTestServer(t)
+	s.MustDo(t, http.MethodPost, "/pause", map[string]string{"reason": "tech"})
+
+	op := s.MustDo(t, http.MethodPost, "/undo", nil)
+	if op["action"] != "pause" || op["origin"] != "http:operator" {
+		t.Fatalf("undid %v", op)
+	}
+	if e := lastAudit(t, "undo"); e.Origin != "http" || e.Operator != "operator" || e.Details["by"] != "http:operator" {
+		t.Errorf("undo audited as %+v", e)
+	}
+	if e := lastAudit(t, "resume"); e.Origin != "undo" {
+		t.Errorf("the resume audited as %+v", e)
+	}
+
+	if err := handleUndoCommand("redo", "cli"); err != nil {
+		t.Fatal(err)
+	}
+	if e := lastAudit(t, "redo"); e.Origin != "cli" || e.Operator != *cliOperatorName {
+		t.Errorf("redo audited as %+v", e)
+	}
+	if e := lastAudit(t, "pause"); e.Origin != "redo" {
+		t.Errorf("the pause again audited as %+v", e)
+	}
+
+	ws := dialWS(t, s, testKey)
+	wsSend(t, ws, WSCommand{ID: "1", Cmd: "resume"})
+	if _, err := undoLast("ws:Réžia"); err != nil {
+		t.Fatal(err)
+	}
+	if e := lastAudit(t, "undo"); e.Origin != "ws" || e.Operator != "Réžia" {
+		t.Errorf("undo for a socket operator audited as %+v", e)
+	}
+
+	if status := s.Do(t, http.MethodPost, "/redo", nil, nil); status != http.StatusOK {
+		t.Errorf("redo: status %d", status)
+	}
+	if status := s.Do(t, http.MethodPost, "/redo", nil, nil); status != http.StatusConflict {
+		t.Errorf("redo with nothing undone: status %d", status)
+	}
+
+	// Clearing the stacks is put down to whoever cleared them too.
+	resetGame("cli")
+	if e := lastAudit(t, "undo_barrier"); e.Origin != "cli" || e.Details["action"] != "reset_game" {
+		t.Errorf("barrier audited as %+v", e)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// localePattern accepts tags like "en" or "en-GB".
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z]{2})?$`)
+
+// validateVariants checks the locale texts of q. Variants translate the
+// default text, so they need one.
+func validateVariants(q Question) error {
+	if len(q.Variants) == 0 {
+		return nil
+	}
+	if strings.TrimSpace(q.Question) == "" {
+		return fmt.Errorf("question is required when variants are set")
+	}
+	for locale, text := range q.Variants {
+		if !localePattern.MatchString(locale) {
+			return fmt.Errorf("invalid variant locale %q", locale)
+		}
+		if strings.TrimSpace(text) == "" {
+			return fmt.Errorf("variant %s is empty", locale)
+		}
+	}
+	return nil
+}
+
+// renderVariants substitutes variables in every variant text.
+func renderVariants(variants map[string]string) (map[string]string, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]string, len(variants))
+	for locale, text := range variants {
+		rendered, err := renderQuestionText(text)
+		if err != nil {
+			return nil, err
+		}
+		out[locale] = rendered
+	}
+	return out, nil
+}
+
+// localizedQuestion puts the text for lang in the question field, falling
+// back to the default text when there is no such variant.
+func localizedQuestion(q PublicQuestionView, lang string) PublicQuestionView {
+	if text, ok := q.Variants[lang]; ok && lang != "" {
+		q.Question = text
+	}
+	return q
+}
+
+// setVariant changes one locale text of the live question without touching
+// its timer or answers. An empty text removes the variant.
+func setVariant(locale, text, origin string) error {
+	if !localePattern.MatchString(locale) {
+		return fmt.Errorf("invalid locale %q", locale)
+	}
+	rendered, err := renderQuestionText(text)
+	if err != nil {
+		return err
+	}
+
+	questionMutex.Lock()
+	if strings.TrimSpace(question.Question) == "" && rendered != "" {
+		questionMutex.Unlock()
+		return fmt.Errorf("set the default question text first")
+	}
+	variants := make(map[string]string, len(question.Variants)+1)
+	for k, v := range question.Variants {
+		variants[k] = v
+	}
+	if rendered == "" {
+		delete(variants, locale)
+	} else {
+		variants[locale] = rendered
+	}
+	if len(variants) == 0 {
+		variants = nil
+	}
+	question.Variants = variants
+	stateChanged("variant")
+	questionMutex.Unlock()
+
+	audit("variant", origin, map[string]interface{}{"locale": locale, "text": rendered})
+	go sendCurrentQuestion()
+	return nil
+}
+
+// handleVariantCommand runs "question.<locale> <text>".
+func handleVariantCommand(locale string, args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	text := strings.Join(args, " ")
+	if err := setVariant(locale, text, origin); err != nil {
+		return err
+	}
+	if text == "" {
+		success.Printf("Variant %s removed\n", locale)
+	} else {
+		success.Printf("Variant %s set\n", locale)
+	}
+	return nil
+}
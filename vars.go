@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	templateVars      = map[string]string{}
+	templateVarsMutex sync.RWMutex
+
+	varNamePattern    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	bareVarPattern    = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+	missingVarPattern = regexp.MustCompile(`map has no entry for key "([^"]+)"`)
+)
+
+// UnknownVariableError is returned when question text references a variable
+// that has not been set.
+type UnknownVariableError struct {
+	Name string
+}
+
+func (e *UnknownVariableError) Error() string {
+	return fmt.Sprintf("undefined template variable %q", e.Name)
+}
+
+// renderQuestionText substitutes variables into question text. Both
+// {{school}} and {{.school}} are accepted; a reference to an unset variable
+// is an error instead of rendering "<no value>".
+func renderQuestionText(raw string) (string, error) {
+	if !strings.Contains(raw, "{{") {
+		return raw, nil
+	}
+	src := bareVarPattern.ReplaceAllString(raw, "{{.$1}}")
+	tmpl, err := template.New("question").Option("missingkey=error").Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("invalid question template: %v", err)
+	}
+
+	templateVarsMutex.RLock()
+	data := make(map[string]string, len(templateVars))
+	for k, v := range templateVars {
+		data[k] = v
+	}
+	templateVarsMutex.RUnlock()
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		if m := missingVarPattern.FindStringSubmatch(err.Error()); m != nil {
+			return "", &UnknownVariableError{Name: m[1]}
+		}
+		return "", fmt.Errorf("rendering question template: %v", err)
+	}
+	return sb.String(), nil
+}
+
+// setTemplateVar sets a variable, or removes it when value is empty.
+func setTemplateVar(name, value, origin string) error {
+	if !varNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid variable name %q", name)
+	}
+	templateVarsMutex.Lock()
+	if value == "" {
+		delete(templateVars, name)
+	} else {
+		templateVars[name] = value
+	}
+	templateVarsMutex.Unlock()
+	audit("var", origin, map[string]interface{}{"name": name, "value": value})
+	return nil
+}
+
+func listTemplateVars() map[string]string {
+	templateVarsMutex.RLock()
+	defer templateVarsMutex.RUnlock()
+	out := make(map[string]string, len(templateVars))
+	for k, v := range templateVars {
+		out[k] = v
+	}
+	return out
+}
+
+func templateError(err error) error {
+	if uerr, ok := err.(*UnknownVariableError); ok {
+		return apiError(http.StatusBadRequest, "unknown_variable", uerr.Error()).withDetail("variable", uerr.Name)
+	}
+	return apiError(http.StatusBadRequest, "invalid_template", err.Error())
+}
+
+func getVars(c echo.Context) error {
+	return c.JSON(http.StatusOK, listTemplateVars())
+}
+
+// postVars merges the posted object into the variables. An empty string
+// removes a variable.
+func postVars(c echo.Context) error {
+	var req map[string]string
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return apiError(http.StatusBadRequest, "invalid_body", "body must be a JSON object of strings")
+	}
+	for name := range req {
+		if !varNamePattern.MatchString(name) {
+			return apiError(http.StatusBadRequest, "invalid_name", fmt.Sprintf("invalid variable name %q", name)).withDetail("name", name)
+		}
+	}
+	for name, value := range req {
+		setTemplateVar(name, value, requestOrigin(c))
+	}
+	return c.JSON(http.StatusOK, listTemplateVars())
+}
+
+func handleVarCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	if len(args) < 1 {
+		return errors.New("Usage: var <name> \"value\"")
+	}
+	value := strings.Trim(strings.Join(args[1:], " "), `"`)
+	if err := setTemplateVar(args[0], value, origin); err != nil {
+		return err
+	}
+	if value == "" {
+		success.Printf("Variable %s removed\n", args[0])
+	} else {
+		success.Printf("%s = %s\n", args[0], value)
+	}
+	return nil
+}
+
+func printTemplateVars() {
+	info := color.New(color.FgYellow)
+	vars := listTemplateVars()
+	if len(vars) == 0 {
+		info.Println("No variables set")
+		return
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info.Printf("  %-16s %s\n", name, vars[name])
+	}
+}
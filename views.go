@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// PublicQuestionView is the question as the audience, displays and the event
+// stream see it, with the time derived as of now.
+type PublicQuestionView struct {
+	Question      string        `json:"question"`
+	TimeLeft      time.Duration `json:"time_left"`
+	Type          string        `json:"type"`
+	StartTime     time.Time     `json:"start_time"`
+	CountUp       bool          `json:"count_up"`
+	AllowOvertime bool          `json:"allow_overtime"`
+	AnswerWindow  time.Duration `json:"answer_window,omitempty"`
+	// OptionsRevealAt is when the options appear; until then Options is
+	// left out and OptionsRevealIn counts down to them.
+	OptionsRevealAt time.Duration `json:"options_reveal_at,omitempty"`
+	OptionsRevealIn time.Duration `json:"options_reveal_in,omitempty"`
+	// ReadingTime is what is left of the reading phase while Reading.
+	ReadingTime time.Duration `json:"reading_time,omitempty"`
+	Reading     bool          `json:"reading,omitempty"`
+
+	Paused       bool   `json:"paused"`
+	PauseReason  string `json:"pause_reason,omitempty"`
+	PauseMessage string `json:"pause_message,omitempty"`
+	// Stalled is set when a count-up was frozen at its ceiling.
+	Stalled bool `json:"stalled,omitempty"`
+	// Degraded is set when the server's own heartbeat is late, so this
+	// payload may be stale; StallAge says by how much.
+	Degraded bool          `json:"degraded,omitempty"`
+	StallAge time.Duration `json:"stall_age,omitempty"`
+	// AriaLiveText changes only at announcement milestones, so a page can
+	// put it in an aria-live region without flooding screen readers.
+	AriaLiveText string `json:"aria_live_text,omitempty"`
+
+	Ceremony    *CeremonyView `json:"ceremony,omitempty"`
+	Round       *RoundView    `json:"round,omitempty"`
+	TimeDisplay *TimeDisplay  `json:"time_display,omitempty"`
+	Phase       string        `json:"phase,omitempty"`
+	Overtime    time.Duration `json:"overtime,omitempty"`
+	// UTCOffset is the server's local offset, e.g. "+02:00". Timestamps
+	// themselves are always UTC.
+	UTCOffset string `json:"utc_offset,omitempty"`
+
+	Variants map[string]string `json:"variants,omitempty"`
+	MediaURL string            `json:"media_url,omitempty"`
+	Options  []AnswerOption    `json:"options,omitempty"`
+	// MediaDisplayURL, when set, is what displays should load instead of
+	// MediaURL.
+	MediaDisplayURL   string `json:"media_display_url,omitempty"`
+	MediaFallbackText string `json:"media_fallback_text,omitempty"`
+	// NextMedia is the media of the next queued question, to preload. It
+	// has no text of that question.
+	NextMedia *MediaHint `json:"next_media,omitempty"`
+	// Page and TotalPages are set when the question text is split into
+	// pages; Question is then the text of page Page, from 1.
+	Page       int `json:"page,omitempty"`
+	TotalPages int `json:"total_pages,omitempty"`
+
+	Meta *QuestionMeta `json:"meta,omitempty"`
+	// Extra holds what only the question's type has, see extraBuilders.
+	Extra interface{} `json:"extra,omitempty"`
+}
+
+// OperatorQuestionView adds the fields only operators may see.
+type OperatorQuestionView struct {
+	PublicQuestionView
+	Notes      string   `json:"notes,omitempty"`
+	HostScript []string `json:"host_script,omitempty"`
+	// HostTimeLeft is the host's countdown, HostLead ahead of the public
+	// one; see hostlead.go.
+	HostTimeLeft *time.Duration `json:"host_time_left,omitempty"`
+	HostLead     time.Duration  `json:"host_lead"`
+	// FullQuestion is the whole text of a question shown in pages.
+	FullQuestion string `json:"full_question,omitempty"`
+	// Scoring and Breakdown show what each answer so far would earn, before
+	// the host awards the correct ones.
+	Scoring   *ScoringPolicy   `json:"scoring,omitempty"`
+	Breakdown []ScoreBreakdown `json:"breakdown"`
+	// Matching and Verdicts show the accepted answers and, once the floor
+	// closes, how each answer was classified.
+	Matching *MatchRules `json:"matching,omitempty"`
+	Verdicts []Verdict   `json:"verdicts,omitempty"`
+	// LockWhileLive and Locked report whether replacing the question now
+	// needs an override.
+	LockWhileLive bool `json:"lock_while_live"`
+	Locked        bool `json:"locked"`
+}
+
+// FlaskQuestion is the stored timer state sent to push targets, which work
+// out the remaining time from start_time themselves.
+type FlaskQuestion struct {
+	Question      string            `json:"question"`
+	TimeLeft      time.Duration     `json:"time_left"`
+	Type          string            `json:"type"`
+	StartTime     time.Time         `json:"start_time"`
+	CountUp       bool              `json:"count_up"`
+	AllowOvertime bool              `json:"allow_overtime"`
+	ReadingTime   time.Duration     `json:"reading_time,omitempty"`
+	Reading       bool              `json:"reading,omitempty"`
+	Paused        bool              `json:"paused"`
+	PauseReason   string            `json:"pause_reason,omitempty"`
+	PauseMessage  string            `json:"pause_message,omitempty"`
+	Stalled       bool              `json:"stalled,omitempty"`
+	Variants      map[string]string `json:"variants,omitempty"`
+	Options       []AnswerOption    `json:"options,omitempty"`
+	Page          int               `json:"page,omitempty"`
+	TotalPages    int               `json:"total_pages,omitempty"`
+	// HostScript is only sent to targets that opt in with host_script.
+	HostScript []string `json:"host_script,omitempty"`
+}
+
+// FlaskPushV2 is the schema version 2 push payload.
+type FlaskPushV2 struct {
+	SchemaVersion int           `json:"schema_version"`
+	Revision      uint64        `json:"revision"`
+	Question      FlaskQuestion `json:"question"`
+}
+
+func flaskQuestion(q Question) FlaskQuestion {
+	f := FlaskQuestion{
+		Question:      q.Question,
+		TimeLeft:      q.TimeLeft,
+		Type:          q.Type,
+		StartTime:     q.StartTime,
+		CountUp:       q.CountUp,
+		AllowOvertime: q.AllowOvertime,
+		ReadingTime:   q.ReadingTime,
+		Reading:       q.Reading,
+		Paused:        q.Paused,
+		PauseReason:   q.PauseReason,
+		PauseMessage:  q.PauseMessage,
+		Stalled:       q.Stalled,
+		Variants:      q.Variants,
+		Options:       q.Options,
+	}
+	if _, hidden := optionsHiddenFor(q, clock.Now()); hidden {
+		f.Options = nil
+	}
+	// Push targets are displays too, so they get the page on show.
+	var paged PublicQuestionView
+	applyPage(&paged, q)
+	if paged.TotalPages > 0 {
+		f.Question, f.Page, f.TotalPages, f.Variants = paged.Question, paged.Page, paged.TotalPages, paged.Variants
+	}
+	return f
+}
+
+// Field classes for questionFields.
+const (
+	fieldPublic   = "public"
+	fieldOperator = "operator"
+	fieldInternal = "internal"
+)
+
+// questionFields says who may see each field of Question. A field added to
+// Question without an entry here stops the server at startup, so nothing new
+// reaches the audience by accident.
+var questionFields = map[string]string{
+	"Question":          fieldPublic,
+	"TimeLeft":          fieldPublic,
+	"Type":              fieldPublic,
+	"StartTime":         fieldPublic,
+	"CountUp":           fieldPublic,
+	"AllowOvertime":     fieldPublic,
+	"AnswerWindow":      fieldPublic,
+	"OptionsRevealAt":   fieldPublic,
+	"ReadingTime":       fieldPublic,
+	"Reading":           fieldPublic,
+	"Paused":            fieldPublic,
+	"PauseReason":       fieldPublic,
+	"PauseMessage":      fieldPublic,
+	"Stalled":           fieldPublic,
+	"AriaLiveText":      fieldPublic,
+	"Variants":          fieldPublic,
+	"MediaURL":          fieldPublic,
+	"MediaDisplayURL":   fieldPublic,
+	"MediaFallbackText": fieldPublic,
+	"Options":           fieldPublic,
+	"Page":              fieldPublic,
+	"Meta":              fieldPublic,
+	"Notes":             fieldOperator,
+	"HostScript":        fieldOperator,
+	"HostLead":          fieldOperator,
+	"Scoring":           fieldOperator,
+	"Matching":          fieldOperator,
+	"BankID":            fieldInternal,
+	"Template":          fieldInternal,
+	"ExpiredFrom":       fieldInternal,
+	"Duration":          fieldInternal,
+}
+
+func init() {
+	if err := checkQuestionFields(); err != nil {
+		panic(err)
+	}
+}
+
+// checkQuestionFields verifies questionFields against the views: every
+// field is classified, public fields are in the public view, and operator
+// fields are in the operator view only.
+func checkQuestionFields() error {
+	internal := reflect.TypeOf(Question{})
+	public := reflect.TypeOf(PublicQuestionView{})
+	operator := reflect.TypeOf(OperatorQuestionView{})
+	for i := 0; i < internal.NumField(); i++ {
+		name := internal.Field(i).Name
+		class, ok := questionFields[name]
+		if !ok {
+			return fmt.Errorf("Question.%s is not classified in questionFields", name)
+		}
+		_, inPublic := public.FieldByName(name)
+		_, inOperator := operator.FieldByName(name)
+		switch {
+		case class == fieldPublic && !inPublic:
+			return fmt.Errorf("Question.%s is public but missing from PublicQuestionView", name)
+		case class == fieldOperator && (inPublic || !inOperator):
+			return fmt.Errorf("Question.%s is operator-only but not exactly in OperatorQuestionView", name)
+		case class == fieldInternal && inOperator:
+			return fmt.Errorf("Question.%s is internal but exposed in a view", name)
+		}
+	}
+	return nil
+}
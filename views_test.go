@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckQuestionFields(t *testing.T) {
+	if err := checkQuestionFields(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestNotesReachOnlyOperators follows a question's notes from the bank,
+// through the queue, to the live question, and checks that nothing the
+// audience can read has them.
+func TestNotesReachOnlyOperators(t *testing.T) {
+	s := StartTestServer(t)
+	const note, script = "secret-note: the answer is Bratislava", "secret-script"
+	entry := s.MustDo(t, http.MethodPost, "/bank", map[string]interface{}{
+		"question": "Hlavné mesto Slovenska?", "type": "pomoc", "time_left": 30_000_000_000,
+		"notes": note, "host_script": []string{script}, "checklist": []string{"map on screen"},
+	})
+	id := int(entry["id"].(float64))
+	if got := s.MustDo(t, http.MethodGet, fmt.Sprintf("/bank/%d", id), nil); got["notes"] != note {
+		t.Fatalf("bank entry notes %v", got["notes"])
+	}
+
+	var queued []QueueEntry
+	if status := s.Do(t, http.MethodPost, fmt.Sprintf("/bank/%d/queue", id), nil, &queued); status != http.StatusOK {
+		t.Fatalf("staging: status %d", status)
+	}
+	if len(queued) != 1 || queued[0].Notes != note {
+		t.Fatalf("staged %+v", queued)
+	}
+	// Nobody ticks off the checklist here, so go live regardless.
+	s.MustDo(t, http.MethodPost, "/queue/next?force=true", nil)
+	if got := s.MustDo(t, http.MethodGet, "/get-question/full", nil); got["notes"] != note {
+		t.Fatalf("operator view notes %v", got["notes"])
+	}
+	s.Flask.WaitFor(t, "the staged question", func(p map[string]interface{}) bool {
+		return p["question"] == "Hlavné mesto Slovenska?"
+	})
+
+	leaks := func(what string, data []byte) {
+		if strings.Contains(string(data), "secret-") {
+			t.Errorf("%s shows operator text: %s", what, data)
+		}
+	}
+	for _, path := range []string{"/get-question", "/v2/get-question", "/bootstrap", "/bank/export?public=true"} {
+		var raw json.RawMessage
+		if status := s.Do(t, http.MethodGet, path, nil, &raw); status != http.StatusOK {
+			t.Errorf("%s: status %d", path, status)
+		}
+		leaks(path, raw)
+	}
+	for _, p := range s.Flask.Payloads() {
+		raw, _ := json.Marshal(p)
+		leaks("a push", raw)
+	}
+
+	var export BankExport
+	s.Do(t, http.MethodGet, "/bank/export", nil, &export)
+	if len(export.Entries) != 1 || export.Entries[0].Notes != note || len(export.Entries[0].HostScript) != 1 {
+		t.Errorf("operator export %+v", export.Entries)
+	}
+}
+
+// TestTargetCredentialsStayHidden checks that no target view, the
+// operator's included, shows a push target's auth header.
+func TestTargetCredentialsStayHidden(t *testing.T) {
+	s := StartTestServer(t)
+	defer removePushTarget("overlay")
+	const secret = "Bearer secret-token"
+	var set json.RawMessage
+	if status := s.Do(t, http.MethodPost, "/targets", PushTarget{Name: "overlay", URL: "http://127.0.0.1:1/overlay", AuthHeader: secret}, &set); status != http.StatusOK {
+		t.Fatalf("adding: status %d", status)
+	}
+	shown := map[string]json.RawMessage{"POST /targets": set}
+	for _, path := range []string{"/targets", "/sync-status"} {
+		var raw json.RawMessage
+		if status := s.Do(t, http.MethodGet, path, nil, &raw); status != http.StatusOK {
+			t.Errorf("%s: status %d", path, status)
+		}
+		shown["GET "+path] = raw
+	}
+	for what, data := range shown {
+		if strings.Contains(string(data), "secret-token") || !strings.Contains(string(data), redactedAuthHeader) {
+			t.Errorf("%s: %s", what, data)
+		}
+	}
+
+	req := s.NewRequest(t, http.MethodGet, "/sync-status", nil)
+	req.Header.Del("X-API-Key")
+	if status := s.Send(t, req, nil).StatusCode; status != http.StatusUnauthorized {
+		t.Errorf("anonymous /sync-status: status %d", status)
+	}
+	for _, st := range pushTargetStatuses() {
+		if st.Name == "overlay" && st.AuthHeader != secret {
+			t.Errorf("the target sends %q", st.AuthHeader)
+		}
+	}
+}
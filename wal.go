@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+// The write-ahead log is a header followed by records. The header is the
+// magic string and a big-endian uint32 version. Each record is a uint32
+// payload length, the CRC-32 (IEEE) of the payload and the payload, a JSON
+// walRecord. A record holds the full new contents of one backup section, so
+// replaying is overlaying the newest image of each section on the snapshot.
+// A final record cut short by a crash is ignored, as is everything after the
+// first record that fails its CRC.
+const (
+	walMagic   = "STUSKWAL"
+	walVersion = 1
+	walHeader  = len(walMagic) + 4
+)
+
+var (
+	walPath             = flag.String("wal", "", "write-ahead log of state changes for crash recovery (empty disables)")
+	walSnapshotPath     = flag.String("wal-snapshot", "wal-snapshot.tar.gz", "snapshot archive the write-ahead log is replayed on top of")
+	walSnapshotInterval = flag.Duration("wal-snapshot-interval", 5*time.Second, "how often a snapshot is taken and the write-ahead log truncated")
+	verifyWAL           = flag.Bool("verify-wal", false, "print what recovery would replay from -wal and exit")
+)
+
+// walRecord is one logged section image.
+type walRecord struct {
+	Seq     uint64          `json:"seq"`
+	Time    time.Time       `json:"time"`
+	Section string          `json:"section"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// walScan is what reading a log found. Valid is the length of the intact
+// prefix; Dropped counts the bytes after it.
+type walScan struct {
+	Records []walRecord
+	Valid   int64
+	Dropped int64
+	Reason  string
+}
+
+type writeAheadLog struct {
+	// mu serializes appends and snapshots and guards everything below
+	// except syncMu's fields. It must not be taken with any state lock held.
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	seq     uint64
+	written uint64
+	hashes  map[string][32]byte
+	closed  bool
+
+	// syncMu batches fsyncs: whoever holds it syncs everything written so
+	// far, and callers queued behind it find their records already synced.
+	syncMu sync.Mutex
+	synced uint64
+}
+
+// wal is nil unless the server was started with -wal.
+var (
+	wal     *writeAheadLog
+	walWake = make(chan struct{}, 1)
+)
+
+func encodeWALRecord(r walRecord) ([]byte, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	return append(buf, payload...), nil
+}
+
+func walHeaderBytes() []byte {
+	header := make([]byte, walHeader)
+	copy(header, walMagic)
+	binary.BigEndian.PutUint32(header[len(walMagic):], walVersion)
+	return header
+}
+
+// scanWAL reads every intact record. Only a bad header is an error; damage
+// further in ends the scan and is reported in the result.
+func scanWAL(r io.Reader) (walScan, error) {
+	var scan walScan
+	br := bufio.NewReader(r)
+	header := make([]byte, walHeader)
+	n, err := io.ReadFull(br, header)
+	switch {
+	case err == io.EOF:
+		// Created but never written to.
+		return scan, nil
+	case err == io.ErrUnexpectedEOF:
+		scan.Reason, scan.Dropped = "torn header", int64(n)
+		return scan, nil
+	case err != nil:
+		return scan, fmt.Errorf("write-ahead log header: %v", err)
+	}
+	if string(header[:len(walMagic)]) != walMagic {
+		return scan, fmt.Errorf("not a write-ahead log")
+	}
+	if v := binary.BigEndian.Uint32(header[len(walMagic):]); v != walVersion {
+		return scan, fmt.Errorf("unsupported write-ahead log version %d (expected %d)", v, walVersion)
+	}
+	scan.Valid = int64(walHeader)
+
+	rest, err := io.ReadAll(br)
+	if err != nil {
+		return scan, err
+	}
+	for off := 0; off < len(rest); {
+		if len(rest)-off < 8 {
+			scan.Reason = "torn record header"
+			break
+		}
+		n := int(binary.BigEndian.Uint32(rest[off : off+4]))
+		sum := binary.BigEndian.Uint32(rest[off+4 : off+8])
+		if n > maxBackupFileSize {
+			scan.Reason = "record length out of range"
+			break
+		}
+		if len(rest)-off-8 < n {
+			scan.Reason = "torn record"
+			break
+		}
+		payload := rest[off+8 : off+8+n]
+		if crc32.ChecksumIEEE(payload) != sum {
+			scan.Reason = "checksum mismatch"
+			break
+		}
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			scan.Reason = "undecodable record"
+			break
+		}
+		scan.Records = append(scan.Records, rec)
+		off += 8 + n
+		scan.Valid += int64(8 + n)
+	}
+	scan.Dropped = int64(walHeader+len(rest)) - scan.Valid
+	return scan, nil
+}
+
+func scanWALFile(path string) (walScan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return walScan{}, err
+	}
+	defer f.Close()
+	return scanWAL(f)
+}
+
+// walRecovery is the state recovery would install: the snapshot's files
+// and the log records newer than it.
+type walRecovery struct {
+	Snapshot *BackupManifest
+	Files    map[string][]byte
+	Replay   []walRecord
+	Skipped  int
+	Scan     walScan
+}
+
+// planRecovery reads the snapshot and the log without touching live state.
+// Either may be missing.
+func planRecovery(snapshotPath, logPath string) (*walRecovery, error) {
+	plan := &walRecovery{Files: map[string][]byte{}}
+	if f, err := os.Open(snapshotPath); err == nil {
+		files, manifest, err := readBackupArchive(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", snapshotPath, err)
+		}
+		plan.Snapshot, plan.Files = manifest, files
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	scan, err := scanWALFile(logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return plan, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", logPath, err)
+	}
+	plan.Scan = scan
+	var after uint64
+	if plan.Snapshot != nil {
+		after = plan.Snapshot.WALSeq
+	}
+	for _, rec := range scan.Records {
+		if rec.Seq <= after {
+			plan.Skipped++
+			continue
+		}
+		plan.Replay = append(plan.Replay, rec)
+		plan.Files[rec.Section] = rec.Data
+	}
+	return plan, nil
+}
+
+// lastSeq is the sequence number logging carries on from.
+func (p *walRecovery) lastSeq() uint64 {
+	var seq uint64
+	if p.Snapshot != nil {
+		seq = p.Snapshot.WALSeq
+	}
+	for _, rec := range p.Scan.Records {
+		if rec.Seq > seq {
+			seq = rec.Seq
+		}
+	}
+	return seq
+}
+
+// startWAL recovers from the snapshot and log, then opens the log for
+// appending with any damaged tail cut off.
+func startWAL() error {
+	info := color.New(color.FgYellow)
+
+	plan, err := planRecovery(*walSnapshotPath, *walPath)
+	if err != nil {
+		return err
+	}
+	if plan.Snapshot != nil || len(plan.Replay) > 0 {
+		if err := installBackupFiles(plan.Files, true, "server"); err != nil {
+			return fmt.Errorf("recovery: %v", err)
+		}
+		audit("recover", "server", map[string]interface{}{"snapshot": plan.Snapshot != nil, "replayed": len(plan.Replay)})
+		info.Printf("Recovered state: %s\n", plan.summary())
+	}
+	if plan.Scan.Dropped > 0 {
+		notify(SeverityWarning, "wal", "Write-ahead log had a damaged tail (%s), %d bytes discarded", plan.Scan.Reason, plan.Scan.Dropped)
+	}
+
+	f, err := os.OpenFile(*walPath, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	size := plan.Scan.Valid
+	if size < int64(walHeader) {
+		size = 0
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	if size == 0 {
+		if _, err := f.Write(walHeaderBytes()); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	l := &writeAheadLog{f: f, w: bufio.NewWriter(f), seq: plan.lastSeq(), hashes: map[string][32]byte{}}
+	l.written, l.synced = l.seq, l.seq
+	// What was just recovered is already durable.
+	if _, err := l.changedSections(); err != nil {
+		f.Close()
+		return err
+	}
+	wal = l
+	workers.goWorker("wal", func() { runWAL(*walSnapshotInterval) })
+	return nil
+}
+
+func (p *walRecovery) summary() string {
+	from := "no snapshot"
+	if p.Snapshot != nil {
+		from = fmt.Sprintf("snapshot of %s", p.Snapshot.Created.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%s plus %d logged change(s)", from, len(p.Replay))
+}
+
+// changedSections dumps every backup section and returns those that differ
+// from what was last made durable, updating the hashes. l.mu must be held.
+func (l *writeAheadLog) changedSections() (map[string][]byte, error) {
+	changed := map[string][]byte{}
+	for _, s := range backupSections {
+		v, err := s.dump()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", s.file, err)
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", s.file, err)
+		}
+		sum := sha256.Sum256(data)
+		// A bank export is stamped with the time it was taken, which is not
+		// a change.
+		if export, ok := v.(BankExport); ok {
+			export.ExportedAt = time.Time{}
+			stable, _ := json.Marshal(export)
+			sum = sha256.Sum256(stable)
+		}
+		if l.hashes[s.file] != sum {
+			l.hashes[s.file] = sum
+			changed[s.file] = data
+		}
+	}
+	return changed, nil
+}
+
+// commit logs every section changed since the last commit and returns once
+// the records are on disk.
+func (l *writeAheadLog) commit() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	changed, err := l.changedSections()
+	if err == nil {
+		now := clock.Now()
+		for _, s := range backupSections {
+			data, ok := changed[s.file]
+			if !ok {
+				continue
+			}
+			l.seq++
+			var buf []byte
+			if buf, err = encodeWALRecord(walRecord{Seq: l.seq, Time: now, Section: s.file, Data: data}); err != nil {
+				break
+			}
+			if _, err = l.w.Write(buf); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = l.w.Flush()
+	}
+	if err != nil {
+		// The hashes no longer match the disk; make the next commit log
+		// everything again.
+		l.hashes = map[string][32]byte{}
+		l.mu.Unlock()
+		return err
+	}
+	l.written = l.seq
+	target := l.seq
+	l.mu.Unlock()
+	return l.sync(target)
+}
+
+// sync makes sure every record up to seq is on disk.
+func (l *writeAheadLog) sync(seq uint64) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	if l.synced >= seq {
+		return nil
+	}
+	l.mu.Lock()
+	upTo := l.written
+	l.mu.Unlock()
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.synced = upTo
+	return nil
+}
+
+// snapshot writes the snapshot archive and truncates the log. Records are
+// only dropped once the snapshot that includes them is in place; a crash in
+// between replays nothing twice, since the snapshot names its last record.
+func (l *writeAheadLog) snapshot() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	if _, err := l.changedSections(); err != nil {
+		return err
+	}
+	if err := writeArchiveFile(*walSnapshotPath, l.seq); err != nil {
+		return err
+	}
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	if err := l.f.Truncate(int64(walHeader)); err != nil {
+		return err
+	}
+	if _, err := l.f.Seek(int64(walHeader), io.SeekStart); err != nil {
+		return err
+	}
+	l.written = l.seq
+	return nil
+}
+
+// walCommit makes the changes so far durable before they are acknowledged.
+func walCommit() {
+	if wal == nil {
+		return
+	}
+	if err := wal.commit(); err != nil {
+		notify(SeverityError, "wal", "Write-ahead log append failed: %v", err)
+	}
+}
+
+// wakeWAL asks the log writer to commit changes nobody waits for, such as
+// a countdown expiring. It is called from stateChanged.
+func wakeWAL() {
+	select {
+	case walWake <- struct{}{}:
+	default:
+	}
+}
+
+func runWAL(interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-walWake:
+			walCommit()
+		case <-tick:
+			if err := wal.snapshot(); err != nil {
+				notify(SeverityError, "wal", "Snapshot failed: %v", err)
+			}
+		}
+	}
+}
+
+// stopWAL commits what is left and closes the log.
+func stopWAL() error {
+	if wal == nil {
+		return nil
+	}
+	if err := wal.commit(); err != nil {
+		return err
+	}
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	wal.closed = true
+	return wal.f.Close()
+}
+
+// walResponseWriter holds a mutation's response back until its changes are
+// in the log.
+type walResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *walResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *walResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// walMiddleware commits every non-GET request's changes before the client
+// sees the response.
+func walMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if wal == nil || c.Request().Method == http.MethodGet || c.Request().Method == http.MethodHead {
+			return next(c)
+		}
+		res := c.Response()
+		orig := res.Writer
+		buffered := &walResponseWriter{ResponseWriter: orig, status: http.StatusOK}
+		res.Writer = buffered
+		err := next(c)
+		// An error the handler returned is written by the error handler
+		// afterwards, straight to the client.
+		res.Writer = orig
+		walCommit()
+		if res.Committed {
+			orig.WriteHeader(buffered.status)
+			orig.Write(buffered.body.Bytes())
+		}
+		return err
+	}
+}
+
+// runVerifyWAL prints what recovery would do and exits.
+func runVerifyWAL() {
+	success := color.New(color.FgGreen)
+	info := color.New(color.FgYellow)
+	errorC := color.New(color.FgRed)
+
+	if *walPath == "" {
+		errorC.Println("-verify-wal needs -wal")
+		os.Exit(1)
+	}
+	plan, err := planRecovery(*walSnapshotPath, *walPath)
+	if err != nil {
+		errorC.Println(err)
+		os.Exit(1)
+	}
+	if plan.Snapshot != nil {
+		info.Printf("Snapshot %s: created %s, covers log up to #%d\n", *walSnapshotPath, plan.Snapshot.Created.Format(time.RFC3339), plan.Snapshot.WALSeq)
+	} else {
+		info.Printf("No snapshot at %s\n", *walSnapshotPath)
+	}
+	info.Printf("Log %s: %d intact record(s), %d already in the snapshot\n", *walPath, len(plan.Scan.Records), plan.Skipped)
+	for _, rec := range plan.Replay {
+		info.Printf("  #%-6d %s  %-14s %d bytes\n", rec.Seq, rec.Time.Format("15:04:05.000"), rec.Section, len(rec.Data))
+	}
+	if plan.Scan.Dropped > 0 {
+		errorC.Printf("Damaged tail: %s, %d bytes would be discarded\n", plan.Scan.Reason, plan.Scan.Dropped)
+	}
+	success.Printf("Recovery would install %s\n", plan.summary())
+	os.Exit(0)
+}
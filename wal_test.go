@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// walBytes is a log with a record for each of sections, numbered from 1.
+func walBytes(t *testing.T, sections ...string) []byte {
+	t.Helper()
+	buf := walHeaderBytes()
+	for i, s := range sections {
+		rec, err := encodeWALRecord(walRecord{Seq: uint64(i + 1), Time: testEpoch, Section: s, Data: json.RawMessage(`{"n":` + strconv.Itoa(i) + `}`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf = append(buf, rec...)
+	}
+	return buf
+}
+
+func TestScanWAL(t *testing.T) {
+	full := walBytes(t, "teams.json", "question.json", "teams.json")
+	scan, err := scanWAL(bytes.NewReader(full))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scan.Records) != 3 || scan.Valid != int64(len(full)) || scan.Dropped != 0 || scan.Reason != "" {
+		t.Fatalf("intact log: %d records, valid %d of %d, dropped %d (%s)", len(scan.Records), scan.Valid, len(full), scan.Dropped, scan.Reason)
+	}
+	if r := scan.Records[1]; r.Seq != 2 || r.Section != "question.json" || string(r.Data) != `{"n":1}` || !r.Time.Equal(testEpoch) {
+		t.Errorf("second record %+v", r)
+	}
+
+	twoRecords := int64(len(walBytes(t, "teams.json", "question.json")))
+	crcAt := int(twoRecords) + 4
+	for _, tc := range []struct {
+		name    string
+		data    []byte
+		records int
+		valid   int64
+		reason  string
+	}{
+		{"empty", nil, 0, 0, ""},
+		{"torn header", full[:5], 0, 0, "torn header"},
+		{"header only", walHeaderBytes(), 0, int64(walHeader), ""},
+		{"torn record", full[:len(full)-3], 2, twoRecords, "torn record"},
+		{"torn record header", full[:twoRecords+5], 2, twoRecords, "torn record header"},
+		{"checksum mismatch", flipByte(full, crcAt), 2, twoRecords, "checksum mismatch"},
+		{"flipped payload", flipByte(full, len(full)-2), 2, twoRecords, "checksum mismatch"},
+		{"length out of range", append(walBytes(t, "teams.json", "question.json"), 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0), 2, twoRecords, "record length out of range"},
+	} {
+		scan, err := scanWAL(bytes.NewReader(tc.data))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if len(scan.Records) != tc.records || scan.Valid != tc.valid || scan.Reason != tc.reason {
+			t.Errorf("%s: %d records, valid %d, %q; want %d, %d, %q", tc.name, len(scan.Records), scan.Valid, scan.Reason, tc.records, tc.valid, tc.reason)
+		}
+		if scan.Dropped != int64(len(tc.data))-scan.Valid {
+			t.Errorf("%s: dropped %d of %d with %d valid", tc.name, scan.Dropped, len(tc.data), scan.Valid)
+		}
+	}
+}
+
+func flipByte(data []byte, i int) []byte {
+	out := append([]byte(nil), data...)
+	out[i] ^= 0x40
+	return out
+}
+
+func TestScanWALHeader(t *testing.T) {
+	bad := walHeaderBytes()
+	copy(bad, "NOTAWAL!")
+	if _, err := scanWAL(bytes.NewReader(bad)); err == nil || err.Error() != "not a write-ahead log" {
+		t.Errorf("bad magic: %v", err)
+	}
+
+	future := walHeaderBytes()
+	binary.BigEndian.PutUint32(future[len(walMagic):], walVersion+1)
+	if _, err := scanWAL(bytes.NewReader(future)); err == nil || !strings.Contains(err.Error(), "unsupported write-ahead log version 2") {
+		t.Errorf("newer version: %v", err)
+	}
+}
+
+func TestPlanRecovery(t *testing.T) {
+	StartTestServer(t)
+	if err := addTeam(Team{Name: "Owls"}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	snapshot, log := filepath.Join(dir, "snapshot.tar.gz"), filepath.Join(dir, "wal")
+
+	// Nothing to recover from.
+	plan, err := planRecovery(snapshot, log)
+	if err != nil || plan.Snapshot != nil || len(plan.Replay) != 0 || plan.lastSeq() != 0 {
+		t.Fatalf("nothing: %+v, %v", plan, err)
+	}
+
+	// The log alone.
+	if err := os.WriteFile(log, walBytes(t, "teams.json", "vars.json", "teams.json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = planRecovery(snapshot, log)
+	if err != nil || len(plan.Replay) != 3 || plan.lastSeq() != 3 || string(plan.Files["teams.json"]) != `{"n":2}` {
+		t.Fatalf("log only: %+v, %v", plan, err)
+	}
+
+	// A snapshot taken after the second record, the log not truncated yet:
+	// only the third is replayed.
+	if err := writeArchiveFile(snapshot, 2); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = planRecovery(snapshot, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Snapshot == nil || plan.Snapshot.WALSeq != 2 || plan.Skipped != 2 || len(plan.Replay) != 1 || plan.Replay[0].Seq != 3 {
+		t.Fatalf("snapshot and log: %+v", plan)
+	}
+	if string(plan.Files["teams.json"]) != `{"n":2}` || !strings.Contains(string(plan.Files["question.json"]), `"question"`) {
+		t.Errorf("files %v", plan.Files)
+	}
+	if plan.lastSeq() != 3 || plan.summary() == "" {
+		t.Errorf("last seq %d", plan.lastSeq())
+	}
+
+	// A snapshot on its own carries on from the record it names.
+	if err := os.Remove(log); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeArchiveFile(snapshot, 7); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = planRecovery(snapshot, log)
+	if err != nil || plan.Snapshot == nil || len(plan.Replay) != 0 || plan.lastSeq() != 7 {
+		t.Fatalf("snapshot only: %+v, %v", plan, err)
+	}
+	if !strings.Contains(string(plan.Files["teams.json"]), "Owls") {
+		t.Errorf("snapshot teams %s", plan.Files["teams.json"])
+	}
+
+	// A log with a bad header fails recovery rather than being overwritten.
+	if err := os.WriteFile(log, []byte("NOTAWAL!\x00\x00\x00\x01"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := planRecovery(snapshot, log); err == nil {
+		t.Error("a bad header was recovered from")
+	}
+}
+
+// TestWALCommitAndRecover logs changes, snapshots, logs some more, then
+// recovers the lot, the queue included, into a fresh server.
+func TestWALCommitAndRecover(t *testing.T) {
+	StartTestServer(t)
+	dir := t.TempDir()
+	log := filepath.Join(dir, "wal")
+	saved := *walSnapshotPath
+	*walSnapshotPath = filepath.Join(dir, "snapshot.tar.gz")
+	defer func() { *walSnapshotPath = saved }()
+
+	f, err := os.Create(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(walHeaderBytes()); err != nil {
+		t.Fatal(err)
+	}
+	l := &writeAheadLog{f: f, w: bufio.NewWriter(f), hashes: map[string][32]byte{}}
+
+	// The first commit logs every section, the next only what changed.
+	if err := l.commit(); err != nil {
+		t.Fatal(err)
+	}
+	if l.seq != uint64(len(backupSections)) {
+		t.Fatalf("first commit logged %d records for %d sections", l.seq, len(backupSections))
+	}
+	if err := addTeam(Team{Name: "Owls"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.commit(); err != nil {
+		t.Fatal(err)
+	}
+	scan, err := scanWALFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := scan.Records[len(scan.Records)-1]
+	if len(scan.Records) != len(backupSections)+1 || last.Section != "teams.json" || l.synced != l.seq {
+		t.Fatalf("%d records, last %s, synced %d of %d", len(scan.Records), last.Section, l.synced, l.seq)
+	}
+
+	if err := l.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(log); err != nil || info.Size() != int64(walHeader) {
+		t.Fatalf("log after the snapshot: %v, %v", info, err)
+	}
+	if err := addTeam(Team{Name: "Foxes"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enqueue([]QueueEntry{{Question: "Hlavné mesto?", TimeLeft: 30 * time.Second}}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := planRecovery(*walSnapshotPath, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Snapshot == nil || plan.Snapshot.WALSeq != uint64(len(backupSections)+1) || len(plan.Replay) != 2 || plan.lastSeq() != l.seq {
+		t.Fatalf("plan: snapshot %+v, replay %d, last seq %d of %d", plan.Snapshot, len(plan.Replay), plan.lastSeq(), l.seq)
+	}
+
+	StartTestServer(t)
+	if err := installBackupFiles(plan.Files, true, "test"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, team := range listTeams() {
+		names = append(names, team.Name)
+	}
+	if strings.Join(names, ",") != "Owls,Foxes" {
+		t.Errorf("recovered teams %v", names)
+	}
+	if q := listQueue(); len(q) != 1 || q[0].Question != "Hlavné mesto?" {
+		t.Errorf("recovered queue %+v", q)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var operatorWatchdog = flag.Duration("operator-watchdog", 0, "pause a live question when a remote operator console sends no keepalive for this long (0 disables)")
+
+// watchdogPauseReason is the pause reason the watchdog uses, so a
+// reconnecting console can tell its own disconnection apart.
+const watchdogPauseReason = "operator disconnected"
+
+// The watchdog is armed by the first keepalive of a remote console, over
+// the WebSocket or HTTP, and disarmed when the console signs off. While a
+// question is live and no keepalive came for -operator-watchdog, it pauses
+// the question. Someone typing at the server's own CLI within that window
+// counts as attending the show, so the watchdog holds off then.
+var (
+	watchdogMutex sync.Mutex
+	watchdogArmed bool
+	lastKeepalive time.Time
+	// watchdogTripped is set when the watchdog paused the question, until
+	// the console is back.
+	watchdogTripped bool
+	watchdogFrom    string
+)
+
+// KeepaliveResult answers a keepalive. PromptResume is set on the first
+// keepalive after the watchdog paused the question: the console should ask
+// the operator to resume, it is not resumed by itself.
+type KeepaliveResult struct {
+	Window       time.Duration `json:"window"`
+	PromptResume bool          `json:"prompt_resume,omitempty"`
+	PausedFor    time.Duration `json:"paused_for,omitempty"`
+}
+
+// operatorKeepalive records a keepalive from origin.
+func operatorKeepalive(origin string) KeepaliveResult {
+	res := KeepaliveResult{Window: *operatorWatchdog}
+	if *operatorWatchdog <= 0 {
+		return res
+	}
+	watchdogMutex.Lock()
+	watchdogArmed = true
+	lastKeepalive = clock.Now()
+	watchdogFrom = origin
+	tripped := watchdogTripped
+	watchdogTripped = false
+	watchdogMutex.Unlock()
+	if !tripped {
+		return res
+	}
+
+	questionMutex.RLock()
+	stillPaused := question.Paused && question.PauseReason == watchdogPauseReason
+	since := pausedAt
+	questionMutex.RUnlock()
+	audit("operator_reconnect", origin, map[string]interface{}{"paused": stillPaused})
+	if !stillPaused {
+		return res
+	}
+	res.PromptResume = true
+	res.PausedFor = clock.Since(since)
+	hub.broadcastOperator(Event{Type: "watchdog_reconnect", Data: res})
+	asyncPrintf(color.New(color.FgYellow), "Operator console reconnected (%s); the question is still paused, 'resume' continues it\n", origin)
+	return res
+}
+
+// operatorSignOff disarms the watchdog when a console closes on purpose.
+func operatorSignOff(origin string) {
+	watchdogMutex.Lock()
+	wasArmed := watchdogArmed
+	watchdogArmed = false
+	watchdogTripped = false
+	watchdogMutex.Unlock()
+	if wasArmed {
+		audit("operator_sign_off", origin, nil)
+	}
+}
+
+// watchOperator checks the keepalives once a second.
+func watchOperator() {
+	if *operatorWatchdog <= 0 {
+		return
+	}
+	for {
+		time.Sleep(time.Second)
+		checkOperatorWatchdog()
+	}
+}
+
+func checkOperatorWatchdog() {
+	now := clock.Now()
+	watchdogMutex.Lock()
+	silent := now.Sub(lastKeepalive)
+	due := watchdogArmed && !watchdogTripped && silent >= *operatorWatchdog
+	from := watchdogFrom
+	watchdogMutex.Unlock()
+	if !due || cliAttended(now) || currentReplay() != nil {
+		return
+	}
+	questionMutex.RLock()
+	phase := publicQuestion(question).Phase
+	questionMutex.RUnlock()
+	if phase == "waiting" || phase == "ended" || phase == "paused" {
+		return
+	}
+
+	if err := pauseQuestion(watchdogPauseReason, "", "watchdog"); err != nil {
+		return
+	}
+	watchdogMutex.Lock()
+	watchdogTripped = true
+	watchdogMutex.Unlock()
+	notify(SeverityError, "operator", "No keepalive from the operator console (%s) for %s, question paused", from, silent.Round(time.Second))
+}
+
+// cliAttended reports whether the server's own CLI was used within the
+// watchdog window.
+func cliAttended(now time.Time) bool {
+	cliLockMutex.Lock()
+	defer cliLockMutex.Unlock()
+	return now.Sub(lastCLIInput) < *operatorWatchdog
+}
+
+// watchdogStatus is the status line of the watchdog, empty when it is off.
+func watchdogStatus() string {
+	if *operatorWatchdog <= 0 {
+		return ""
+	}
+	watchdogMutex.Lock()
+	defer watchdogMutex.Unlock()
+	if !watchdogArmed {
+		return fmt.Sprintf("Operator watchdog: %s, waiting for a remote console", *operatorWatchdog)
+	}
+	return fmt.Sprintf("Operator watchdog: %s, last keepalive %s ago via %s", *operatorWatchdog, clock.Since(lastKeepalive).Round(time.Second), watchdogFrom)
+}
+
+func postKeepalive(c echo.Context) error {
+	return c.JSON(http.StatusOK, operatorKeepalive(requestOrigin(c)))
+}
+
+func deleteKeepalive(c echo.Context) error {
+	operatorSignOff(requestOrigin(c))
+	return c.NoContent(http.StatusNoContent)
+}
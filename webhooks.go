@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	webhookAttempts = 3
+	webhookTimeout  = 5 * time.Second
+)
+
+var (
+	webhookURL       = flag.String("webhook", "", "Slack-compatible or Discord webhook URL for show milestone messages (empty disables)")
+	webhookEvents    = flag.String("webhook-events", "game_started,round_ended,long_pause,push_failed", "event types posted to -webhook")
+	webhookRate      = flag.Duration("webhook-rate", 30*time.Second, "minimum time between two messages of the same event type")
+	webhookPause     = flag.Duration("webhook-pause-after", time.Minute, "how long a pause lasts before long_pause is posted")
+	webhookTemplates = flag.String("webhook-templates", "", `JSON file of message templates, {"<event>": "text with {{placeholders}}"}`)
+)
+
+// webhookMessages are the built-in message templates by event type.
+var webhookMessages = map[string]string{
+	"game_started": ":clapper: The show has started: {{question}} ({{teams}} teams)",
+	"round_ended":  ":checkered_flag: Round {{round}} is over. Standings:\n{{standings}}",
+	"long_pause":   ":pause_button: Paused for {{duration}} ({{reason}}){{message}}",
+	"push_failed":  ":rotating_light: {{target}} is unreachable: {{error}}",
+	"test":         ":wave: Test message from the quiz server at {{time}}",
+}
+
+// webhookMessage is one message waiting to be posted.
+type webhookMessage struct {
+	event string
+	vars  map[string]string
+}
+
+var (
+	webhookMutex   sync.Mutex
+	webhookEnabled = map[string]bool{}
+	webhookLast    = map[string]time.Time{}
+	webhookStarted bool
+	webhookRound   int
+	webhookQueue   = make(chan webhookMessage, 64)
+	webhookClient  = &http.Client{Timeout: webhookTimeout}
+)
+
+func configureWebhooks() error {
+	if *webhookURL == "" {
+		return nil
+	}
+	if *webhookTemplates != "" {
+		data, err := os.ReadFile(*webhookTemplates)
+		if err != nil {
+			return err
+		}
+		var custom map[string]string
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return fmt.Errorf("%s: %v", *webhookTemplates, err)
+		}
+		for event, text := range custom {
+			if _, ok := webhookMessages[event]; !ok {
+				return fmt.Errorf("%s: unknown event type %q", *webhookTemplates, event)
+			}
+			webhookMessages[event] = text
+		}
+	}
+	for _, event := range strings.Split(*webhookEvents, ",") {
+		event = strings.TrimSpace(event)
+		if event == "" {
+			continue
+		}
+		if _, ok := webhookMessages[event]; !ok || event == "test" {
+			return fmt.Errorf("unknown webhook event type %q", event)
+		}
+		webhookEnabled[event] = true
+	}
+	workers.goWorker("webhooks", runWebhooks)
+	return nil
+}
+
+// postWebhook queues a message for event. It never blocks: a message that
+// doesn't fit, is rate limited or isn't configured is dropped.
+func postWebhook(event string, vars map[string]string) {
+	if *webhookURL == "" {
+		return
+	}
+	webhookMutex.Lock()
+	now := clock.Now()
+	if !webhookEnabled[event] || now.Sub(webhookLast[event]) < *webhookRate {
+		webhookMutex.Unlock()
+		return
+	}
+	webhookLast[event] = now
+	webhookMutex.Unlock()
+	select {
+	case webhookQueue <- webhookMessage{event: event, vars: vars}:
+	default:
+	}
+}
+
+func renderWebhook(event string, vars map[string]string) string {
+	text := webhookMessages[event]
+	for k, v := range vars {
+		text = strings.ReplaceAll(text, "{{"+k+"}}", v)
+	}
+	return text
+}
+
+// webhookBody wraps text the way the webhook's service expects it.
+func webhookBody(url, text string) ([]byte, error) {
+	if strings.Contains(url, "discord.com/") || strings.Contains(url, "discordapp.com/") {
+		return json.Marshal(map[string]string{"content": text})
+	}
+	return json.Marshal(map[string]string{"text": text})
+}
+
+func runWebhooks() {
+	for m := range webhookQueue {
+		if err := deliverWebhook(m); err != nil {
+			notify(SeverityWarning, "webhook", "Webhook %s message not delivered: %v", m.event, err)
+		}
+	}
+}
+
+// deliverWebhook posts m, retrying with backoff. Nothing here touches game
+// state.
+func deliverWebhook(m webhookMessage) error {
+	body, err := webhookBody(*webhookURL, renderWebhook(m.event, m.vars))
+	if err != nil {
+		return err
+	}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = sendWebhook(body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		<-clock.After(backoff)
+		backoff *= 2
+	}
+}
+
+func sendWebhook(body []byte) error {
+	resp, err := webhookClient.Post(*webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// observeWebhooks turns audit entries into milestone messages. It runs
+// inside audit, so it only looks at the entry and queues.
+func observeWebhooks(e AuditEntry) {
+	if *webhookURL == "" {
+		return
+	}
+	switch e.Action {
+	case "question":
+		typ, _ := e.Details["type"].(string)
+		if typ == "end" || typ == "waiting" {
+			return
+		}
+		webhookMutex.Lock()
+		first := !webhookStarted
+		webhookStarted = true
+		webhookMutex.Unlock()
+		if first {
+			text, _ := e.Details["question"].(string)
+			go func() {
+				postWebhook("game_started", map[string]string{"question": text, "teams": fmt.Sprint(len(listTeams()))})
+			}()
+		}
+	case "pause":
+		reason, _ := e.Details["reason"].(string)
+		message, _ := e.Details["message"].(string)
+		go watchLongPause(e.Time, reason, message)
+	}
+}
+
+// watchLongPause posts long_pause if the pause that began at since is
+// still going once -webhook-pause-after has passed.
+func watchLongPause(since time.Time, reason, message string) {
+	<-clock.After(*webhookPause)
+	// A pause that began after since is a different one.
+	questionMutex.RLock()
+	still := question.Paused && !pausedAt.After(since)
+	questionMutex.RUnlock()
+	if !still {
+		return
+	}
+	if reason == "" {
+		reason = "no reason given"
+	}
+	if message != "" {
+		message = ": " + message
+	}
+	postWebhook("long_pause", map[string]string{
+		"reason":   reason,
+		"message":  message,
+		"duration": webhookPause.String(),
+	})
+}
+
+// webhookRoundPlayed notes that a queue question of round went live, or
+// with round 0 that the show ended, and posts round_ended when the round
+// before it is over.
+func webhookRoundPlayed(round int) {
+	if *webhookURL == "" {
+		return
+	}
+	webhookMutex.Lock()
+	ended := webhookRound
+	webhookRound = round
+	webhookMutex.Unlock()
+	if ended == 0 || ended == round {
+		return
+	}
+	var lines []string
+	for _, s := range rankTeams(listTeams()) {
+		lines = append(lines, fmt.Sprintf("%d. %s %d", s.Place, s.Team.Name, s.Team.Score))
+	}
+	postWebhook("round_ended", map[string]string{"round": fmt.Sprint(ended), "standings": strings.Join(lines, "\n")})
+}
+
+// handleNotifyCommand runs "notify test".
+func handleNotifyCommand(args []string, origin string) error {
+	success := color.New(color.FgGreen)
+
+	if len(args) != 1 || args[0] != "test" {
+		return errors.New("Usage: notify test")
+	}
+	if *webhookURL == "" {
+		return errors.New("No webhook configured, start with -webhook <url>")
+	}
+	// The test is sent right away, past the rate limit, so the operator
+	// sees the result.
+	m := webhookMessage{event: "test", vars: map[string]string{"time": clock.Now().Format("15:04:05")}}
+	if err := deliverWebhook(m); err != nil {
+		return fmt.Errorf("Webhook test failed: %v", err)
+	}
+	success.Println("Webhook test message delivered")
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import "time"
+
+// An answer window limits when answers count to the first AnswerWindow of
+// the answer time, pauses excluded, while the countdown itself keeps
+// running on screen. The expiry watcher closes the floor when it is over.
+var (
+	// windowClosed is when the answer window closed the floor, if it did.
+	windowClosed *time.Time
+	// windowReopened is set when the operator opens answers again after the
+	// window closed; the window no longer applies then. Both are guarded by
+	// roundMutex and cleared by resetRound.
+	windowReopened bool
+)
+
+var errWindowClosed = &RoundError{Code: "window_closed", Message: "the answer window for this question has closed"}
+
+// answerWindowDeadline returns when the window of q closes, if it is still
+// open and running. Call with questionMutex held.
+func answerWindowDeadline(q Question) (time.Time, bool) {
+	if q.AnswerWindow <= 0 || q.Paused || q.Reading || q.Type == "end" || q.Type == "waiting" {
+		return time.Time{}, false
+	}
+	roundMutex.Lock()
+	defer roundMutex.Unlock()
+	if windowReopened || windowClosed != nil || floorClosed != nil || answeringAt.IsZero() {
+		return time.Time{}, false
+	}
+	return answeringAt.Add(answerPaused + q.AnswerWindow), true
+}
+
+// answerWindowPassed reports whether a submission at now is past the live
+// question's window. It doesn't wait for the watcher, so a submission a
+// moment after the deadline is refused too.
+func answerWindowPassed(now time.Time) bool {
+	questionMutex.RLock()
+	window, paused, since := question.AnswerWindow, question.Paused, pausedAt
+	questionMutex.RUnlock()
+	if window <= 0 {
+		return false
+	}
+	roundMutex.Lock()
+	defer roundMutex.Unlock()
+	return !windowReopened && !answeringAt.IsZero() && answerLatency(now, paused, since) >= window
+}
+
+// closeAnswerWindow closes the floor when the window is over and tells the
+// phones with a window_closed event.
+func closeAnswerWindow() {
+	questionMutex.RLock()
+	deadline, ok := answerWindowDeadline(question)
+	window := question.AnswerWindow
+	questionMutex.RUnlock()
+	if !ok || clock.Now().Before(deadline) {
+		return
+	}
+	roundMutex.Lock()
+	if floorClosed != nil {
+		roundMutex.Unlock()
+		return
+	}
+	now := clock.Now()
+	floorClosed, windowClosed = &now, &now
+	roundMutex.Unlock()
+
+	audit("window_close", "timer", map[string]interface{}{"window": window.Seconds(), "late": now.Sub(deadline).Seconds()})
+	hub.broadcast(Event{Type: "window_closed", Data: map[string]interface{}{"time": now, "window": window}})
+	roundChanged("window_close")
+	go sendCurrentQuestion()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	checkWire  = flag.String("check-wire", "", "replay the wire corpus in this directory (testdata/wire) against the API and exit, failing on any response that changed")
+	updateWire = flag.Bool("update-wire", false, "with -check-wire, rewrite the golden files from the current responses")
+)
+
+// The wire corpus locks what deployed clients parse. cases.json lists
+// requests, run in order against one fresh server on a stopped clock, and
+// each has a <name>.golden file holding the status, the wireHeaders and
+// the body exactly as served. A refactor that changes a byte of /v1 fails
+// -check-wire; a change meant for /v2 is accepted with -update-wire.
+
+// wireCase is one request of the corpus.
+type wireCase struct {
+	Name    string            `json:"name"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// wireHeaders are the response headers kept in golden files.
+var wireHeaders = []string{echo.HeaderContentType, apiVersionHeader, "Deprecation", "Link"}
+
+// wireEpoch is the time on the corpus server's clock, and wireKey its
+// -api-key.
+var wireEpoch = time.Date(2025, time.March, 14, 18, 0, 0, 0, time.UTC)
+
+const wireKey = "wire-check"
+
+// runWireCheck is main for -check-wire. It never returns.
+func runWireCheck() {
+	success := color.New(color.FgGreen)
+	errorC := color.New(color.FgRed)
+	info := color.New(color.FgYellow)
+
+	cases, err := loadWireCases(*checkWire)
+	if err != nil {
+		errorC.Printf("Error reading the wire corpus: %v\n", err)
+		os.Exit(1)
+	}
+	e := wireServer()
+
+	failed := 0
+	for _, wc := range cases {
+		got := serveWireCase(e, wc)
+		path := filepath.Join(*checkWire, wc.Name+".golden")
+		if *updateWire {
+			if err := os.WriteFile(path, got, 0644); err != nil {
+				errorC.Printf("Error writing %s: %v\n", path, err)
+				os.Exit(1)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			errorC.Printf("FAIL %s: %v (run with -update-wire to create it)\n", wc.Name, err)
+			failed++
+			continue
+		}
+		if diff := wireDiff(want, got); diff != "" {
+			errorC.Printf("FAIL %s %s %s\n", wc.Name, wc.Method, wc.Path)
+			fmt.Println(diff)
+			failed++
+		}
+	}
+
+	switch {
+	case *updateWire:
+		info.Printf("Wrote %d golden files to %s\n", len(cases), *checkWire)
+	case failed > 0:
+		errorC.Printf("%d of %d wire case(s) changed\n", failed, len(cases))
+		os.Exit(1)
+	default:
+		success.Printf("All %d wire cases match\n", len(cases))
+	}
+	os.Exit(0)
+}
+
+// loadWireCases reads cases.json from the corpus in dir.
+func loadWireCases(dir string) ([]wireCase, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, "cases.json"))
+	if err != nil {
+		return nil, err
+	}
+	var cases []wireCase
+	if err := json.Unmarshal(raw, &cases); err != nil {
+		return nil, fmt.Errorf("cases.json: %w", err)
+	}
+	return cases, nil
+}
+
+// wireServer sets up a fresh server for the corpus. The responses must
+// not depend on the machine: a stopped clock, UTC as the local zone for
+// utc_offset, and the corpus key. The storm guard would see the corpus as
+// a storm.
+func wireServer() *echo.Echo {
+	clock = NewManualClock(wireEpoch)
+	time.Local = time.UTC
+	*apiKey = wireKey
+	*stormLimit = 0
+	initializeQuestion()
+	return setupServer()
+}
+
+// serveWireCase sends wc to e and returns the response in golden form.
+func serveWireCase(e *echo.Echo, wc wireCase) []byte {
+	req := httptest.NewRequest(wc.Method, wc.Path, bytes.NewReader(wc.Body))
+	if len(wc.Body) > 0 {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	for k, v := range wc.Headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return formatWireResponse(rec)
+}
+
+// formatWireResponse is the golden form of rec: the status, the
+// wireHeaders that are set, a blank line and the body.
+func formatWireResponse(rec *httptest.ResponseRecorder) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d %s\n", rec.Code, http.StatusText(rec.Code))
+	for _, h := range wireHeaders {
+		if v := rec.Header().Get(h); v != "" {
+			fmt.Fprintf(&buf, "%s: %s\n", h, v)
+		}
+	}
+	buf.WriteString("\n")
+	buf.Write(rec.Body.Bytes())
+	return buf.Bytes()
+}
+
+// wireDiff describes the first line that differs, or is empty when want
+// and got are the same.
+func wireDiff(want, got []byte) string {
+	if bytes.Equal(want, got) {
+		return ""
+	}
+	w := strings.Split(string(want), "\n")
+	g := strings.Split(string(got), "\n")
+	for i := 0; i < max(len(w), len(g)); i++ {
+		var wl, gl string
+		if i < len(w) {
+			wl = w[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if wl != gl {
+			return fmt.Sprintf("  line %d\n  want: %s\n  got:  %s", i+1, strconv.Quote(wl), strconv.Quote(gl))
+		}
+	}
+	return ""
+}
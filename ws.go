@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+// WSCommand is a message from a WebSocket client. ID is echoed back on the
+// response so the client can match it to the request.
+type WSCommand struct {
+	ID    string  `json:"id"`
+	Cmd   string  `json:"cmd"`
+	Key   string  `json:"key,omitempty"`
+	Delta float64 `json:"delta,omitempty"`
+	Value string  `json:"value,omitempty"`
+	// CatchUp asks a "subscribe" command to replay the latest events.
+	CatchUp bool `json:"catch_up,omitempty"`
+	// Force lets a time command end the question at once.
+	Force bool `json:"force,omitempty"`
+	// Name is the operator's display name on an "auth" command.
+	Name string `json:"name,omitempty"`
+}
+
+// WSResponse answers one WSCommand.
+type WSResponse struct {
+	Type   string      `json:"type"`
+	ID     string      `json:"id,omitempty"`
+	OK     bool        `json:"ok"`
+	Result interface{} `json:"result,omitempty"`
+	Error  *APIError   `json:"error,omitempty"`
+}
+
+// wsCommands are the operator commands accepted over the socket. Each turns
+// the message into the words of the matching CLI command, which then runs
+// through runCommand like one typed at the terminal.
+var wsCommands = map[string]func(cmd WSCommand) []string{
+	"pause": func(cmd WSCommand) []string {
+		if cmd.Value == "" {
+			return []string{"pause"}
+		}
+		return []string{"pause", `"` + cmd.Value + `"`}
+	},
+	"resume": func(cmd WSCommand) []string {
+		return []string{"resume"}
+	},
+	"time.adjust": func(cmd WSCommand) []string {
+		return withForce([]string{"adjust", strconv.FormatFloat(cmd.Delta, 'f', -1, 64)}, cmd.Force)
+	},
+	"time.set": func(cmd WSCommand) []string {
+		return withForce([]string{"time", cmd.Value}, cmd.Force)
+	},
+	"next": func(cmd WSCommand) []string {
+		return withForce([]string{"next"}, cmd.Force)
+	},
+	"buzz.reset": func(cmd WSCommand) []string {
+		return []string{"buzz", "reset"}
+	},
+	"eliminate": func(cmd WSCommand) []string {
+		return []string{"eliminate", cmd.Value}
+	},
+}
+
+func withForce(words []string, force bool) []string {
+	if force {
+		return append(words, "--force")
+	}
+	return words
+}
+
+// wsConn is one connected socket. Writes from the event loop and from
+// command responses share it, hence the mutex.
+type wsConn struct {
+	ws            *websocket.Conn
+	writeMutex    sync.Mutex
+	authenticated bool
+	remote        string
+	// operator is the name the connection's commands are attributed to.
+	operator string
+}
+
+func (w *wsConn) send(v interface{}) error {
+	w.writeMutex.Lock()
+	defer w.writeMutex.Unlock()
+	return websocket.JSON.Send(w.ws, v)
+}
+
+// closeGoingAway sends a 1001 close frame carrying reason. The plain close
+// frame ws.Close writes after it is ignored by clients that saw this one.
+func (w *wsConn) closeGoingAway(reason string) {
+	w.writeMutex.Lock()
+	defer w.writeMutex.Unlock()
+	msg := binary.BigEndian.AppendUint16(nil, 1001)
+	w.ws.PayloadType = websocket.CloseFrame
+	w.ws.Write(append(msg, reason...))
+}
+
+func (w *wsConn) fail(id string, err *APIError) {
+	w.send(WSResponse{Type: "response", ID: id, Error: err})
+}
+
+// getWS upgrades to a WebSocket that carries the same events as /events,
+// taking the same types and catch_up parameters. A "subscribe" command
+// changes the types later. Operators (authenticated by header, or by an
+// "auth" command with the key) can also send commands on it.
+func getWS(c echo.Context) error {
+	authenticated := isAuthenticated(c)
+	remote := c.RealIP()
+	operator, _ := requestOperator(c)
+	// The stream is taken before the upgrade so a full server can still
+	// answer with a plain 503.
+	s, err := hub.join("ws", authenticated, parseEventTypes(c.QueryParam("types")), c.QueryParam("catch_up") == "true")
+	if err != nil {
+		return streamsFull()
+	}
+	defer hub.unsubscribe(s)
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		serveWS(&wsConn{ws: ws, authenticated: authenticated, remote: remote, operator: operator}, s)
+	}}.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+func serveWS(conn *wsConn, s *subscriber) {
+	defer conn.ws.Close()
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-hub.closing:
+				conn.closeGoingAway(hub.closeReason)
+				conn.ws.Close()
+				return
+			case <-s.evicted:
+				conn.closeGoingAway("server at capacity")
+				conn.ws.Close()
+				return
+			case ev := <-s.ch:
+				if err := conn.send(ev); err != nil {
+					conn.ws.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var raw []byte
+		if err := websocket.Message.Receive(conn.ws, &raw); err != nil {
+			return
+		}
+		hub.touch(s)
+		var cmd WSCommand
+		if err := json.Unmarshal(raw, &cmd); err != nil || cmd.Cmd == "" {
+			conn.fail(cmd.ID, apiError(http.StatusBadRequest, "invalid_command", "expected a JSON object with a cmd field"))
+			continue
+		}
+		switch cmd.Cmd {
+		case "auth":
+			conn.authenticate(cmd, s)
+			continue
+		case "subscribe":
+			hub.setFilter(s, parseEventTypes(cmd.Value), cmd.CatchUp)
+			conn.send(WSResponse{Type: "response", ID: cmd.ID, OK: true})
+			continue
+		case "keepalive", "keepalive.stop":
+			conn.keepalive(cmd)
+			continue
+		}
+		conn.run(cmd)
+	}
+}
+
+// authenticate upgrades the connection to an operator connection, named
+// after the key's label or the command's name.
+func (w *wsConn) authenticate(cmd WSCommand, s *subscriber) {
+	label, ok := keyOperator(cmd.Key)
+	if !ok {
+		w.fail(cmd.ID, apiError(http.StatusUnauthorized, "unauthorized", "invalid API key"))
+		return
+	}
+	w.authenticated = true
+	w.operator = operatorDisplayName(label, cmd.Name)
+	touchOperator(w.operator, "ws", w.remote)
+	hub.mu.Lock()
+	s.authenticated = true
+	hub.mu.Unlock()
+	w.send(WSResponse{Type: "response", ID: cmd.ID, OK: true})
+}
+
+// keepalive answers "keepalive" and "keepalive.stop", which are about the
+// connection's operator rather than the quiz.
+func (w *wsConn) keepalive(cmd WSCommand) {
+	if !w.authenticated {
+		w.fail(cmd.ID, apiError(http.StatusUnauthorized, "unauthorized", "commands need an operator connection"))
+		return
+	}
+	origin := "ws:" + w.operator
+	if cmd.Cmd == "keepalive.stop" {
+		operatorSignOff(origin)
+		w.send(WSResponse{Type: "response", ID: cmd.ID, OK: true})
+		return
+	}
+	w.send(WSResponse{Type: "response", ID: cmd.ID, OK: true, Result: operatorKeepalive(origin)})
+}
+
+func (w *wsConn) run(cmd WSCommand) {
+	words, ok := wsCommands[cmd.Cmd]
+	switch {
+	case !w.authenticated:
+		w.fail(cmd.ID, apiError(http.StatusUnauthorized, "unauthorized", "commands need an operator connection"))
+		return
+	case !ok:
+		w.fail(cmd.ID, apiError(http.StatusBadRequest, "unknown_command", fmt.Sprintf("unknown command %q", cmd.Cmd)).withDetail("commands", wsCommandNames()))
+		return
+	case stormGuard != nil && !stormGuard.allow("ws:"+cmd.Cmd, w.remote):
+		w.fail(cmd.ID, apiError(http.StatusTooManyRequests, "mutation_storm", "too many state changes, slow down"))
+		return
+	}
+
+	touchOperator(w.operator, "ws", w.remote)
+	args := words(cmd)
+	finish := trackCommand(strings.Join(args, " "), "ws")
+	result, err := runCommand(args, "ws:"+w.operator)
+	finish(err)
+	walCommit()
+	if err != nil {
+		w.fail(cmd.ID, wsError(err))
+		return
+	}
+	w.send(WSResponse{Type: "response", ID: cmd.ID, OK: true, Result: result})
+}
+
+// wsError gives command failures the same codes the REST endpoints use.
+func wsError(err error) *APIError {
+	var h *commandHint
+	if errors.As(err, &h) {
+		err = h.error
+	}
+	switch err.(type) {
+	case *RoundError:
+		err = roundError(err)
+	case *DurationError:
+		err = bindError(err)
+	case *ChecklistError:
+		err = checklistError(err.(*ChecklistError))
+	case *APIError:
+	default:
+		if err == errCeremonyActive {
+			return conflict(err.Error())
+		}
+		return apiError(http.StatusConflict, "rejected", err.Error())
+	}
+	var apiErr *APIError
+	errors.As(err, &apiErr)
+	return apiErr
+}
+
+func wsCommandNames() string {
+	names := make([]string, 0, len(wsCommands))
+	for name := range wsCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
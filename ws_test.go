@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// dialWS opens /ws, as an operator when key is set.
+func dialWS(t *testing.T, s *TestServer, key string) *websocket.Conn {
+	t.Helper()
+	cfg, err := websocket.NewConfig("ws"+strings.TrimPrefix(s.URL, "http")+"/ws?types=none", s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "" {
+		cfg.Header.Set("X-API-Key", key)
+	}
+	ws, err := websocket.DialConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// wsSend sends cmd and returns its response, skipping any events.
+func wsSend(t *testing.T, ws *websocket.Conn, cmd WSCommand) WSResponse {
+	t.Helper()
+	if err := websocket.JSON.Send(ws, cmd); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(waitTimeout))
+	for {
+		var res WSResponse
+		if err := websocket.JSON.Receive(ws, &res); err != nil {
+			t.Fatalf("%s: %v", cmd.Cmd, err)
+		}
+		if res.Type == "response" && res.ID == cmd.ID {
+			return res
+		}
+	}
+}
+
+func wsErrorCode(res WSResponse) string {
+	if res.Error == nil {
+		return ""
+	}
+	return res.Error.Code
+}
+
+// TestWSCommandsRunTheCLI checks that socket commands behave like the CLI
+// commands they stand for, failures included.
+func TestWSCommandsRunTheCLI(t *testing.T) {
+	s := StartTestServer(t)
+
+	anon := dialWS(t, s, "")
+	if res := wsSend(t, anon, WSCommand{ID: "1", Cmd: "pause"}); res.OK || wsErrorCode(res) != "unauthorized" {
+		t.Errorf("pause without a key: %+v", res)
+	}
+	if res := wsSend(t, anon, WSCommand{ID: "2", Cmd: "keepalive"}); res.OK || wsErrorCode(res) != "unauthorized" {
+		t.Errorf("keepalive without a key: %+v", res)
+	}
+
+	ws := dialWS(t, s, testKey)
+	if res := wsSend(t, ws, WSCommand{ID: "3", Cmd: "pause", Value: "Technická prestávka"}); !res.OK {
+		t.Fatalf("pause: %+v", res.Error)
+	}
+	questionMutex.RLock()
+	paused, message := question.Paused, question.PauseMessage
+	questionMutex.RUnlock()
+	if !paused || message != "Technická prestávka" {
+		t.Errorf("paused %v with %q", paused, message)
+	}
+	if entries := auditEntries("pause"); len(entries) == 0 || !strings.HasPrefix(entries[len(entries)-1].Origin, "ws") {
+		t.Errorf("pause audited as %+v", entries)
+	}
+	if res := wsSend(t, ws, WSCommand{ID: "4", Cmd: "pause"}); res.OK || wsErrorCode(res) != "rejected" {
+		t.Errorf("pausing twice: %+v", res)
+	}
+	if res := wsSend(t, ws, WSCommand{ID: "5", Cmd: "resume"}); !res.OK {
+		t.Fatalf("resume: %+v", res.Error)
+	}
+
+	// A change that would end the question takes force, as with the CLI's
+	// --force, and nobody is asked.
+	if res := wsSend(t, ws, WSCommand{ID: "6", Cmd: "time.adjust", Delta: -60}); res.OK || wsErrorCode(res) != "would_expire_immediately" {
+		t.Errorf("adjust past zero: %+v", res)
+	}
+	if res := wsSend(t, ws, WSCommand{ID: "7", Cmd: "time.adjust", Delta: 15}); !res.OK || res.Result.(map[string]interface{})["time_left"] != 45.0 {
+		t.Errorf("adjust: %+v", res)
+	}
+	if res := wsSend(t, ws, WSCommand{ID: "8", Cmd: "time.set", Value: "soon"}); res.OK || wsErrorCode(res) != "invalid_duration" {
+		t.Errorf("set to nonsense: %+v", res)
+	}
+	if res := wsSend(t, ws, WSCommand{ID: "9", Cmd: "time.set", Value: "1m30s"}); !res.OK {
+		t.Errorf("set: %+v", res.Error)
+	}
+	questionMutex.RLock()
+	left := question.TimeLeft
+	questionMutex.RUnlock()
+	if left != 90*time.Second {
+		t.Errorf("time left %s after time.set", left)
+	}
+
+	if res := wsSend(t, ws, WSCommand{ID: "10", Cmd: "eliminate", Value: "Nobody"}); res.OK {
+		t.Errorf("eliminated an unknown team: %+v", res)
+	}
+	if res := wsSend(t, ws, WSCommand{ID: "11", Cmd: "status"}); res.OK || wsErrorCode(res) != "unknown_command" {
+		t.Errorf("a CLI command the socket doesn't take: %+v", res)
+	}
+	if res := wsSend(t, ws, WSCommand{ID: "12", Cmd: "keepalive"}); !res.OK {
+		t.Errorf("keepalive: %+v", res.Error)
+	}
+}